@@ -1,18 +1,26 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/alfaoz/beammeup/internal/cli"
+	"github.com/alfaoz/beammeup/internal/config"
+	"github.com/alfaoz/beammeup/internal/creds"
+	"github.com/alfaoz/beammeup/internal/geoip"
 	"github.com/alfaoz/beammeup/internal/hangar"
+	"github.com/alfaoz/beammeup/internal/history"
+	"github.com/alfaoz/beammeup/internal/notify"
 	"github.com/alfaoz/beammeup/internal/session"
 	"github.com/alfaoz/beammeup/internal/ships"
-	"github.com/alfaoz/beammeup/internal/sshx"
 	"github.com/alfaoz/beammeup/internal/tui"
 	"github.com/alfaoz/beammeup/internal/update"
+	"github.com/alfaoz/beammeup/internal/usage"
 	"github.com/alfaoz/beammeup/internal/version"
 	"golang.org/x/term"
 )
@@ -22,6 +30,25 @@ func main() {
 }
 
 func run(args []string) int {
+	profile, args, err := cli.ExtractProfile(args)
+	if err != nil {
+		printErr(err)
+		return cli.ExitUsage
+	}
+	if profile == "" {
+		profile = config.Profile()
+	}
+	if err := config.UseProfile(profile); err != nil {
+		printErr(err)
+		return cli.ExitUsage
+	}
+
+	if len(args) > 0 {
+		if cmd, ok := cli.LookupCommand(args[0]); ok {
+			return runCommand(cmd, args[1:])
+		}
+	}
+
 	opts, err := cli.Parse(args)
 	if err != nil {
 		printErr(err)
@@ -29,6 +56,10 @@ func run(args []string) int {
 		return cli.ExitUsage
 	}
 
+	if readOnlyFromEnv() {
+		opts.ReadOnly = true
+	}
+
 	if opts.Help {
 		cli.PrintHelp()
 		return cli.ExitSuccess
@@ -46,17 +77,9 @@ func run(args []string) int {
 	}
 
 	hangarSvc := hangar.NewService()
-	sshOpts := sshx.DefaultConnectOptions()
-	if strings.TrimSpace(opts.SSHKnownHosts) != "" {
-		sshOpts.KnownHostsPath = strings.TrimSpace(opts.SSHKnownHosts)
-	}
-	if opts.StrictHostKey {
-		sshOpts.HostKeyMode = sshx.HostKeyStrict
-	}
-	if opts.InsecureHostKey {
-		sshOpts.HostKeyMode = sshx.HostKeyInsecureIgnore
-	}
-	hangarSvc.SSH = sshOpts
+	hangarSvc.SSH = cli.SSHOptions(opts)
+	hangarSvc.RemoteTmpDir = strings.TrimSpace(opts.RemoteTmpDir)
+	hangarSvc.ReadOnly = opts.ReadOnly
 
 	if opts.SelfUpdate {
 		result, err := runSelfUpdate(opts.BaseURL)
@@ -69,8 +92,11 @@ func run(args []string) int {
 	}
 
 	if shouldAutoUpdate(opts) {
-		result, err := runSelfUpdate(opts.BaseURL)
-		if err != nil {
+		// Package manager installs may prompt for sudo; leave those to an
+		// explicit --self-update.
+		if inst, err := update.DetectInstall(); err == nil && inst.Method != update.InstallArchive {
+			fmt.Fprintf(os.Stderr, "[beammeup] auto-update skipped: installed with %s; upgrade with %s\n", inst.Method, inst.UpgradeHint())
+		} else if result, err := runSelfUpdate(opts.BaseURL); err != nil {
 			fmt.Fprintf(os.Stderr, "[beammeup] auto-update skipped: %v\n", err)
 		} else if result.Updated {
 			printUpdateMessage(result)
@@ -79,7 +105,7 @@ func run(args []string) int {
 
 	isTTY := isTerminalFile(os.Stdin) && isTerminalFile(os.Stdout)
 	if cli.RequiresNonInteractive(opts, isTTY) {
-		runner := &cli.Runner{Store: store, Hangar: hangarSvc}
+		runner := newRunner(store, hangarSvc)
 		code, err := runner.Run(opts)
 		if err != nil {
 			printErr(err)
@@ -87,7 +113,18 @@ func run(args []string) int {
 		return code
 	}
 
+	runner := newRunner(store, hangarSvc)
 	app := tui.New(store, hangarSvc, session.NewPasswordCache())
+	app.Usage = runner.Usage
+	app.History = runner.History
+	app.Creds = runner.Creds
+	app.Notifier = runner.Notifier
+	app.Config = runner.Config
+	if opts.Last {
+		if app.StartShip = store.Last(); app.StartShip == "" {
+			fmt.Fprintln(os.Stderr, "[beammeup] --last: no ship has been used yet")
+		}
+	}
 	if err := app.Run(); err != nil {
 		if errors.Is(err, os.ErrClosed) {
 			return cli.ExitSuccess
@@ -98,6 +135,79 @@ func run(args []string) int {
 	return cli.ExitSuccess
 }
 
+func runCommand(cmd cli.Command, args []string) int {
+	store, err := ships.NewStore(strings.TrimSpace(os.Getenv("BEAMMEUP_SHIPS_DIR")))
+	if err != nil {
+		printErr(fmt.Errorf("initialize ships store: %w", err))
+		return cli.ExitFailure
+	}
+	svc := hangar.NewService()
+	svc.ReadOnly = readOnlyFromEnv()
+	svc.Client = clientFromEnv()
+	runner := newRunner(store, svc)
+	code, err := cmd.Execute(runner, args)
+	if err != nil {
+		printErr(err)
+	}
+	return code
+}
+
+// newRunner wires the optional client-side stores and notifiers. Failures
+// there only disable the features that need them.
+func newRunner(store *ships.Store, svc *hangar.Service) *cli.Runner {
+	runner := &cli.Runner{Store: store, Hangar: svc}
+	usageStore, err := usage.NewStore(strings.TrimSpace(os.Getenv("BEAMMEUP_USAGE_DIR")))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[beammeup] usage history disabled: %v\n", err)
+	} else {
+		runner.Usage = usageStore
+	}
+	historyStore, err := history.NewStore(strings.TrimSpace(os.Getenv("BEAMMEUP_HISTORY_DIR")))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[beammeup] status history disabled: %v\n", err)
+	} else {
+		runner.History = historyStore
+	}
+	credsStore, err := creds.NewStore(strings.TrimSpace(os.Getenv("BEAMMEUP_CREDS_DIR")), os.Getenv("BEAMMEUP_CREDS_PASSPHRASE"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[beammeup] stored credentials disabled: %v\n", err)
+	} else {
+		runner.Creds = credsStore
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[beammeup] config ignored: %v\n", err)
+	}
+	runner.Notifier = notify.FromConfig(cfg)
+	runner.Config = cfg
+	svc.GeoIP = geoip.New(cfg.GeoIPDB, cfg.GeoIPURL)
+	if dir, err := config.Dir(); err == nil {
+		svc.MetadataKeyDir = filepath.Join(dir, "keys")
+	}
+	svc.TemplateDir = strings.TrimSpace(os.Getenv("BEAMMEUP_TEMPLATE_DIR"))
+	return runner
+}
+
+// clientFromEnv is how this machine signs its changes in hangar.json:
+// BEAMMEUP_CLIENT_NAME, or user@host. "none" records nothing.
+func clientFromEnv() string {
+	name := strings.TrimSpace(os.Getenv("BEAMMEUP_CLIENT_NAME"))
+	switch {
+	case strings.EqualFold(name, "none"):
+		return ""
+	case name != "":
+		return name
+	}
+	return hangar.LocalClient()
+}
+
+// readOnlyFromEnv reports whether BEAMMEUP_READ_ONLY puts the whole process
+// in read-only mode, like --read-only.
+func readOnlyFromEnv() bool {
+	v := strings.ToLower(strings.TrimSpace(os.Getenv("BEAMMEUP_READ_ONLY")))
+	return v == "1" || v == "true" || v == "yes"
+}
+
 func shouldAutoUpdate(opts cli.Options) bool {
 	if opts.AutoUpdate {
 		return true
@@ -106,8 +216,25 @@ func shouldAutoUpdate(opts cli.Options) bool {
 	return v == "1" || v == "true" || v == "yes"
 }
 
+// runSelfUpdate checks for and installs an update. With telemetry opted in
+// (TELEMETRY=1 in the config), the anonymous ping goes out alongside the
+// check; its failures are never reported.
 func runSelfUpdate(baseURL string) (update.Result, error) {
-	return update.SelfUpdate(strings.TrimSpace(baseURL))
+	done := make(chan struct{})
+	cfg, _ := config.Load()
+	if cfg.Telemetry {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		go func() {
+			defer close(done)
+			defer cancel()
+			_ = update.SendPing(ctx, cfg.TelemetryURL, update.NewPing())
+		}()
+	} else {
+		close(done)
+	}
+	res, err := update.SelfUpdate(strings.TrimSpace(baseURL))
+	<-done
+	return res, err
 }
 
 func printUpdateMessage(res update.Result) {
@@ -116,6 +243,10 @@ func printUpdateMessage(res update.Result) {
 		v = version.AppVersion
 	}
 	if res.Updated {
+		if res.Method != "" && res.Method != update.InstallArchive {
+			fmt.Printf("[beammeup] updated to v%s via %s\n", v, res.Method)
+			return
+		}
 		fmt.Printf("[beammeup] updated to v%s\n", v)
 		return
 	}
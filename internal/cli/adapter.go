@@ -0,0 +1,86 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+
+	"github.com/alfaoz/beammeup/internal/tunnel"
+	"github.com/spf13/pflag"
+)
+
+const defaultAdapterAddr = "127.0.0.1:8118"
+
+func (r *Runner) runAdapter(args []string) (int, error) {
+	opts := DefaultOptions()
+	fs := pflag.NewFlagSet("beammeup adapter", pflag.ContinueOnError)
+	addTargetFlags(fs, &opts)
+	listen := fs.String("listen", defaultAdapterAddr, "Local HTTP proxy address")
+	refresh := fs.Bool("refresh", false, "Ask the server for the credentials instead of using the stored result card")
+	rulesFile := fs.String("rules", "", "Routing rules file sending destinations through the hangar, direct, or nowhere")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return ExitUsage, err
+	}
+	host, _, err := net.SplitHostPort(*listen)
+	if err != nil {
+		return ExitUsage, fmt.Errorf("invalid --listen address: %w", err)
+	}
+	var rules *tunnel.Rules
+	if *rulesFile != "" {
+		if rules, err = tunnel.LoadRules(*rulesFile); err != nil {
+			return ExitUsage, err
+		}
+	}
+
+	ship, res, password, code, err := r.hangarShow(opts, "socks5", *refresh)
+	if err != nil {
+		if code != ExitFailure {
+			return code, err
+		}
+		return ExitFailure, fmt.Errorf("%w\nhint: the adapter needs a SOCKS5 hangar. create one with --protocol socks5 --action configure", err)
+	}
+	if res.User == "" || res.Pass == "" {
+		return ExitFailure, errors.New("SOCKS5 hangar credentials are not retrievable. rotate them first")
+	}
+	ep := hangarEndpoint(ship, res)
+	upstream := net.JoinHostPort(ep.Host, ep.Port)
+
+	forward, closeForward, err := r.proxyDialer(ship, password)
+	if err != nil {
+		return ExitFailure, err
+	}
+	defer closeForward()
+	if forward == nil {
+		forward = net.Dial
+	}
+
+	fmt.Printf("\n[beammeup] http adapter :: %s\n", ship.Name)
+	fmt.Printf("  Local HTTP proxy: http://%s\n", *listen)
+	fmt.Printf("  Upstream: socks5://%s (authenticated)\n\n", upstream)
+	fmt.Printf("Quick test:\n")
+	fmt.Printf("  curl -x http://%s https://api.ipify.org\n\n", *listen)
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		fmt.Fprintf(os.Stderr, "[beammeup] WARNING: %s is reachable from other machines and the adapter has no authentication.\n", *listen)
+	}
+	fmt.Printf("Press Ctrl+C to stop.\n\n")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	logf := func(format string, args ...any) {
+		fmt.Fprintf(os.Stderr, "[adapter] "+format+"\n", args...)
+	}
+	dial := tunnel.SOCKS5Upstream(forward, upstream, res.User, res.Pass)
+	if rules != nil {
+		dial = rules.Routed(dial, nil)
+		logf("routing rules active: %d rule(s)", rules.Len())
+	}
+	if err := tunnel.RunHTTPAdapter(ctx, *listen, dial, logf); err != nil {
+		return ExitFailure, err
+	}
+	fmt.Println("\n[beammeup] http adapter closed.")
+	return ExitSuccess, nil
+}
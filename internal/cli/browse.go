@@ -0,0 +1,143 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/alfaoz/beammeup/internal/config"
+	"github.com/alfaoz/beammeup/internal/ships"
+	"github.com/alfaoz/beammeup/internal/tunnel"
+	"github.com/spf13/pflag"
+)
+
+// browserCandidates lists Chromium-family browsers in preference order. Each
+// accepts --user-data-dir and --proxy-server.
+var browserCandidates = map[string][]string{
+	"linux":   {"chromium", "chromium-browser", "google-chrome", "google-chrome-stable", "brave-browser", "microsoft-edge"},
+	"darwin":  {"/Applications/Chromium.app/Contents/MacOS/Chromium", "/Applications/Google Chrome.app/Contents/MacOS/Google Chrome", "/Applications/Brave Browser.app/Contents/MacOS/Brave Browser", "/Applications/Microsoft Edge.app/Contents/MacOS/Microsoft Edge"},
+	"windows": {"chrome", "msedge", "brave", `C:\Program Files\Google\Chrome\Application\chrome.exe`, `C:\Program Files (x86)\Microsoft\Edge\Application\msedge.exe`},
+}
+
+func (r *Runner) runBrowse(args []string) (int, error) {
+	opts := DefaultOptions()
+	fs := pflag.NewFlagSet("beammeup browse", pflag.ContinueOnError)
+	addTargetFlags(fs, &opts)
+	browser := fs.String("browser", "", "Browser name or path (default: first Chromium-family browser found)")
+	local := fs.String("local", "", "Use a local proxy instead (e.g. a stealth tunnel at 127.0.0.1:1080)")
+	localProtocol := fs.String("local-protocol", "socks5", "Protocol of the --local proxy (http or socks5)")
+	refresh := fs.Bool("refresh", false, "Ask the server for the credentials instead of using the stored result card")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return ExitUsage, err
+	}
+
+	exe, err := findBrowser(*browser)
+	if err != nil {
+		return ExitFailure, err
+	}
+
+	// Chromium's --proxy-server cannot carry credentials, so hangars are
+	// reached through an in-process adapter on a random loopback port.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var proxyServer, profileName string
+	if strings.TrimSpace(*local) != "" {
+		if _, _, err := net.SplitHostPort(strings.TrimSpace(*local)); err != nil {
+			return ExitUsage, fmt.Errorf("invalid --local address: %w", err)
+		}
+		protocol, ok := NormalizeProtocol(strings.ToLower(strings.TrimSpace(*localProtocol)))
+		if !ok || protocol == "" {
+			return ExitUsage, errors.New("invalid --local-protocol. use http or socks5")
+		}
+		proxyServer = protocol + "://" + strings.TrimSpace(*local)
+		profileName = fallback(opts.ShipName, "local")
+	} else {
+		ship, res, password, code, err := r.hangarShow(opts, "", *refresh)
+		if err != nil {
+			return code, err
+		}
+		if res.User == "" || res.Pass == "" {
+			return ExitFailure, errors.New("hangar credentials are not retrievable. rotate them first")
+		}
+		forward, closeForward, err := r.proxyDialer(ship, password)
+		if err != nil {
+			return ExitFailure, err
+		}
+		defer closeForward()
+		if forward == nil {
+			forward = net.Dial
+		}
+
+		ep := hangarEndpoint(ship, res)
+		upstream := net.JoinHostPort(ep.Host, ep.Port)
+		dial := tunnel.HTTPUpstream(forward, upstream, ep.User, ep.Pass)
+		if ep.Protocol == "socks5" {
+			dial = tunnel.SOCKS5Upstream(forward, upstream, ep.User, ep.Pass)
+		}
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return ExitFailure, fmt.Errorf("listen: %w", err)
+		}
+		logf := func(format string, args ...any) {
+			fmt.Fprintf(os.Stderr, "[browse] "+format+"\n", args...)
+		}
+		go tunnel.ServeHTTPAdapter(ctx, ln, dial, logf)
+		proxyServer = "http://" + ln.Addr().String()
+		profileName = ship.Name
+	}
+
+	dir, err := config.Dir()
+	if err != nil {
+		return ExitFailure, err
+	}
+	profile := filepath.Join(dir, "browser", ships.SanitizeName(profileName))
+	if err := os.MkdirAll(profile, 0o700); err != nil {
+		return ExitFailure, fmt.Errorf("create browser profile: %w", err)
+	}
+
+	fmt.Printf("[beammeup] launching %s through %s (%s)\n", filepath.Base(exe), profileName, proxyServer)
+	fmt.Printf("  Profile: %s\n", profile)
+	cmd := exec.Command(exe, browserArgs(profile, proxyServer)...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return ExitFailure, fmt.Errorf("browser: %w", err)
+	}
+	fmt.Println("[beammeup] browser closed.")
+	return ExitSuccess, nil
+}
+
+// browserArgs isolates the browser in profile and sends all of its traffic,
+// DNS included, through proxyServer.
+func browserArgs(profile, proxyServer string) []string {
+	return []string{
+		"--user-data-dir=" + profile,
+		"--proxy-server=" + proxyServer,
+		"--no-first-run",
+		"--no-default-browser-check",
+	}
+}
+
+// findBrowser resolves name (a command or path) or, when empty, the first
+// installed candidate for this OS.
+func findBrowser(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	candidates := browserCandidates[runtime.GOOS]
+	if name != "" {
+		candidates = []string{name}
+	}
+	for _, c := range candidates {
+		if path, err := exec.LookPath(c); err == nil {
+			return path, nil
+		}
+	}
+	if name != "" {
+		return "", fmt.Errorf("browser %q not found", name)
+	}
+	return "", errors.New("no Chromium-family browser found. pass --browser <name or path>")
+}
@@ -0,0 +1,128 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/alfaoz/beammeup/internal/cloudfw"
+	"github.com/alfaoz/beammeup/internal/ships"
+	"github.com/alfaoz/beammeup/internal/sshx"
+)
+
+// syncCloudFirewall opens the proxy port in the ship's cloud firewall after
+// an apply, and closes the port beammeup opened there before if the proxy
+// moved or now listens on localhost. It returns the line for the result
+// ("" when the ship has no cloud firewall) and records the port it owns.
+func (r *Runner) syncCloudFirewall(shipName string, ship ships.Ship, port int) string {
+	if ship.CloudFirewall == "" {
+		return ""
+	}
+	want := port
+	if ship.ListenLocal {
+		want = 0
+	}
+	prev := ship.CloudFirewallPort
+	fw, ip, err := r.cloudFirewall(ship)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[beammeup] WARNING: %v\n", err)
+		return "not changed: " + err.Error()
+	}
+
+	var notes []string
+	owned := prev
+	if prev > 0 && prev != want {
+		res, err := fw.Close(ip, prev)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[beammeup] WARNING: %v\n", err)
+			return "not changed: " + err.Error()
+		}
+		notes = append(notes, res.Note)
+		owned = 0
+	}
+	if want > 0 {
+		res, err := fw.Open(ip, want)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[beammeup] WARNING: %v\n", err)
+			notes = append(notes, "tcp/"+strconv.Itoa(want)+" not opened: "+err.Error())
+		} else {
+			notes = append(notes, res.Note)
+			if res.Changed {
+				owned = want
+			}
+		}
+	}
+	r.recordCloudFirewallPort(shipName, owned)
+	if len(notes) == 0 {
+		return "nothing to open: the proxy listens on localhost"
+	}
+	return strings.Join(notes, "; ")
+}
+
+// closeCloudFirewall removes the rule beammeup opened for the ship once its
+// hangar is destroyed. It returns the line for the result, or "".
+func (r *Runner) closeCloudFirewall(shipName string, ship ships.Ship) string {
+	if ship.CloudFirewall == "" || ship.CloudFirewallPort == 0 {
+		return ""
+	}
+	fw, ip, err := r.cloudFirewall(ship)
+	if err == nil {
+		var res cloudfw.Result
+		if res, err = fw.Close(ip, ship.CloudFirewallPort); err == nil {
+			r.recordCloudFirewallPort(shipName, 0)
+			return res.Note
+		}
+	}
+	fmt.Fprintf(os.Stderr, "[beammeup] WARNING: %v\n", err)
+	return fmt.Sprintf("tcp/%d left open: %v", ship.CloudFirewallPort, err)
+}
+
+func (r *Runner) cloudFirewall(ship ships.Ship) (cloudfw.Provider, string, error) {
+	fw, err := cloudfw.New(ship.CloudFirewall, r.Config)
+	if err != nil {
+		return nil, "", err
+	}
+	// An alias from ~/.ssh/config means nothing to DNS; use its HostName.
+	target := r.Hangar.SSH.Resolve(sshx.Target{Host: ship.Host})
+	ip, err := cloudfw.ServerIP(target.Host)
+	if err != nil {
+		return nil, "", err
+	}
+	return fw, ip, nil
+}
+
+// recordCloudFirewall keeps the saved ship's cloud firewall provider in line
+// with --cloud-firewall. Turning it off forgets the port opened there.
+func (r *Runner) recordCloudFirewall(shipName, provider string) {
+	if shipName == "" {
+		return
+	}
+	ship, err := r.Store.Load(shipName)
+	if err != nil || ship.CloudFirewall == provider {
+		return
+	}
+	ship.CloudFirewall = provider
+	if provider == "" {
+		ship.CloudFirewallPort = 0
+	}
+	if _, err := r.Store.Save(ship); err != nil {
+		fmt.Fprintf(os.Stderr, "[beammeup] WARNING: could not record cloud firewall: %v\n", err)
+	}
+}
+
+// recordCloudFirewallPort remembers which port beammeup opened in the ship's
+// cloud firewall, so destroy closes that one and no other.
+func (r *Runner) recordCloudFirewallPort(shipName string, port int) {
+	if shipName == "" {
+		return
+	}
+	ship, err := r.Store.Load(shipName)
+	if err != nil || ship.CloudFirewallPort == port {
+		return
+	}
+	ship.CloudFirewallPort = port
+	if _, err := r.Store.Save(ship); err != nil {
+		fmt.Fprintf(os.Stderr, "[beammeup] WARNING: could not record cloud firewall port: %v\n", err)
+	}
+}
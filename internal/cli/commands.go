@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/alfaoz/beammeup/internal/ships"
+	"github.com/spf13/pflag"
+)
+
+// Command is a beammeup subcommand (e.g. `beammeup simulate ...`).
+type Command struct {
+	Name    string
+	Summary string
+	Run     func(r *Runner, args []string) (int, error)
+}
+
+var commands = []Command{
+	{Name: "simulate", Summary: "Run the remote provisioning flow in a local container", Run: (*Runner).runSimulate},
+	{Name: "selftest", Summary: "Apply, verify, rotate and re-verify a ship's hangar", Run: (*Runner).runSelftest},
+	{Name: "validate", Summary: "Check ship profiles for mistakes and clashes before anything connects", Run: (*Runner).runValidate},
+	{Name: "diff", Summary: "Compare a saved ship profile with the live hangar", Run: (*Runner).runDiff},
+	{Name: "exec", Summary: "Run a one-off command on a ship's server", Run: (*Runner).runExec},
+	{Name: "fetch", Summary: "Download a file (or a managed config/log) from a ship's server", Run: (*Runner).runFetch},
+	{Name: "support-bundle", Summary: "Collect redacted local and server diagnostics into a tar.gz", Run: (*Runner).runSupportBundle},
+	{Name: "tunnel", Summary: "Show, stop or reconnect stealth tunnels, or run one as a service", Run: (*Runner).runTunnel},
+	{Name: "forward", Summary: "Forward ports over a ship's SSH connection (like ssh -L and -R)", Run: (*Runner).runForward},
+	{Name: "adapter", Summary: "Expose a SOCKS5 hangar as a local HTTP proxy", Run: (*Runner).runAdapter},
+	{Name: "browse", Summary: "Launch a browser with an isolated profile proxied through a ship", Run: (*Runner).runBrowse},
+	{Name: "pair", Summary: "Serve the current ship's proxy to the beammeup browser extension", Run: (*Runner).runPair},
+	{Name: "sysproxy", Summary: "Point the system proxy at a ship (on) or restore it (off)", Run: (*Runner).runSysproxy},
+	{Name: "toolproxy", Summary: "Configure git, npm or pip to use a ship's proxy", Run: (*Runner).runToolproxy},
+	{Name: "top", Summary: "Watch all ships live: status, connected clients and traffic rate", Run: (*Runner).runTop},
+	{Name: "trust-host", Summary: "Trust a ship's new SSH host key after it changed", Run: (*Runner).runTrustHost},
+	{Name: "hash-known-hosts", Summary: "Hash the host names in known_hosts, like ssh-keygen -H", Run: (*Runner).runHashKnownHosts},
+	{Name: "history", Summary: "Show a ship's recorded uptime, check times, applies and rotations", Run: (*Runner).runHistory},
+	{Name: "usage", Summary: "Record and show this month's traffic against the ship's quota", Run: (*Runner).runUsage},
+	{Name: "creds", Summary: "Show, import or delete the stored result cards (proxy credentials)", Run: (*Runner).runCreds},
+	{Name: "export", Summary: "Write the saved ships as an ssh_config file, or their proxy credentials for other tools", Run: (*Runner).runExport},
+	{Name: "profiles", Summary: "List the local profiles (separate ships, known_hosts and config)", Run: (*Runner).runProfiles},
+	{Name: "package-info", Summary: "Show how beammeup was installed and how it upgrades", Run: (*Runner).runPackageInfo},
+	{Name: "templates", Summary: "Print the systemd unit templates as rendered, or copy them for editing", Run: (*Runner).runTemplates},
+	{Name: "security", Summary: "Score the beammeup systemd units with systemd-analyze and optionally harden them", Run: (*Runner).runSecurity},
+	{Name: "users", Summary: "Break down a ship's HTTP proxy traffic by proxy user", Run: (*Runner).runUsers},
+}
+
+// LookupCommand returns the subcommand registered under name.
+func LookupCommand(name string) (Command, bool) {
+	for _, c := range commands {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Command{}, false
+}
+
+// Execute runs c, treating an explicit --help as success.
+func (c Command) Execute(r *Runner, args []string) (int, error) {
+	code, err := c.Run(r, args)
+	if errors.Is(err, pflag.ErrHelp) {
+		return ExitSuccess, nil
+	}
+	return code, err
+}
+
+// parseCommandFlags parses args into fs and rejects positional arguments.
+func parseCommandFlags(fs *pflag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unknown arguments: %v", fs.Args())
+	}
+	return nil
+}
+
+// prepareTarget resolves the ship and SSH password for a subcommand that
+// connects to a server, applying the shared SSH flags to the hangar service.
+func (r *Runner) prepareTarget(opts Options) (ships.Ship, string, int, error) {
+	if err := validateTargetFlags(opts); err != nil {
+		return ships.Ship{}, "", ExitUsage, err
+	}
+	r.Hangar.SSH = r.sshOptions(opts)
+	r.Hangar.RemoteTmpDir = strings.TrimSpace(opts.RemoteTmpDir)
+	if opts.ReadOnly {
+		r.Hangar.ReadOnly = true
+	}
+	if _, err := r.pickShip(&opts); err != nil {
+		return ships.Ship{}, "", ExitFailure, err
+	}
+	ship, code, err := r.resolveShip(opts, "", "")
+	if err != nil {
+		return ships.Ship{}, "", code, err
+	}
+	password, code, err := r.resolvePassword(ship, opts)
+	if err != nil {
+		return ships.Ship{}, "", code, err
+	}
+	return ship, password, ExitSuccess, nil
+}
+
+func commandsHelp() string {
+	var b strings.Builder
+	for _, c := range commands {
+		fmt.Fprintf(&b, "  %-28s  %s\n", c.Name, c.Summary)
+	}
+	return b.String()
+}
@@ -0,0 +1,308 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alfaoz/beammeup/internal/creds"
+	"github.com/alfaoz/beammeup/internal/hangar"
+	"github.com/alfaoz/beammeup/internal/ships"
+	"github.com/spf13/pflag"
+	"golang.org/x/term"
+)
+
+// recordCard stores an apply or show result as the ship's result card so the
+// client-side commands can reach the proxy without asking the server again.
+// Results without retrievable credentials keep the previous card.
+func (r *Runner) recordCard(shipName string, res hangar.ActionResult) {
+	if shipName == "" || r.Creds == nil || res.User == "" || res.Pass == "" {
+		return
+	}
+	if err := r.Creds.Save(creds.FromResult(shipName, res)); err != nil {
+		fmt.Fprintf(os.Stderr, "[beammeup] WARNING: could not store credentials: %v\n", err)
+	}
+}
+
+// forgetCard drops the ship's result card once its hangar is gone.
+func (r *Runner) forgetCard(shipName string) {
+	if shipName == "" || r.Creds == nil {
+		return
+	}
+	if err := r.Creds.Delete(shipName); err != nil {
+		fmt.Fprintf(os.Stderr, "[beammeup] WARNING: %v\n", err)
+	}
+}
+
+// hangarShow resolves the ship and the show result of its hangar for the
+// client-side commands. The stored result card answers when it matches
+// protocol (the ship's own when empty); otherwise, or with refresh, the
+// server is asked and the card updated. The SSH password is only resolved
+// when the server is contacted or the proxy listens on localhost, and is
+// empty otherwise.
+func (r *Runner) hangarShow(opts Options, protocol string, refresh bool) (ships.Ship, hangar.ActionResult, string, int, error) {
+	if err := validateTargetFlags(opts); err != nil {
+		return ships.Ship{}, hangar.ActionResult{}, "", ExitUsage, err
+	}
+	r.Hangar.SSH = r.sshOptions(opts)
+	r.Hangar.RemoteTmpDir = strings.TrimSpace(opts.RemoteTmpDir)
+	if opts.ReadOnly {
+		r.Hangar.ReadOnly = true
+	}
+	if _, err := r.pickShip(&opts); err != nil {
+		return ships.Ship{}, hangar.ActionResult{}, "", ExitFailure, err
+	}
+	ship, code, err := r.resolveShip(opts, "", "")
+	if err != nil {
+		return ships.Ship{}, hangar.ActionResult{}, "", code, err
+	}
+	if protocol == "" {
+		protocol = ship.Protocol
+	}
+
+	var res hangar.ActionResult
+	cached := false
+	if !refresh && r.Creds != nil && opts.ShipName != "" {
+		card, err := r.Creds.Load(opts.ShipName)
+		switch {
+		case err == nil:
+			expired := !card.ExpiresAt.IsZero() && card.ExpiresAt.Before(time.Now())
+			if (protocol == "" || card.Protocol == protocol) && !expired {
+				res, cached = card.Result(), true
+			}
+		case errors.Is(err, creds.ErrLocked):
+			fmt.Fprintf(os.Stderr, "[beammeup] %v; asking the server instead.\n", err)
+		case !errors.Is(err, creds.ErrNotFound):
+			fmt.Fprintf(os.Stderr, "[beammeup] WARNING: %v\n", err)
+		}
+	}
+	if cached && !ship.ListenLocal {
+		return ship, res, "", ExitSuccess, nil
+	}
+
+	password, code, err := r.resolvePassword(ship, opts)
+	if err != nil {
+		return ships.Ship{}, hangar.ActionResult{}, "", code, err
+	}
+	if cached {
+		return ship, res, password, ExitSuccess, nil
+	}
+	res, err = r.Hangar.Execute(ship, password, hangar.ActionInput{Mode: "show", Protocol: protocol, HTTPMode: ship.HTTPMode})
+	if err != nil {
+		return ships.Ship{}, hangar.ActionResult{}, "", ExitFailure, err
+	}
+	r.recordCard(opts.ShipName, res)
+	return ship, res, password, ExitSuccess, nil
+}
+
+func (r *Runner) runCreds(args []string) (int, error) {
+	const usage = "usage: beammeup creds <show|import|delete|lock|unlock> [--ship <name>] [file]"
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" {
+		if len(args) > 0 {
+			fmt.Println(strings.TrimPrefix(usage, "usage: "))
+			return ExitSuccess, nil
+		}
+		return ExitUsage, errors.New(usage)
+	}
+	if r.Creds == nil {
+		return ExitFailure, errors.New("stored credentials are not available")
+	}
+	sub := args[0]
+
+	fs := pflag.NewFlagSet("beammeup creds "+sub, pflag.ContinueOnError)
+	shipName := fs.String("ship", "", "Ship whose card to use")
+	asJSON := fs.Bool("json", false, "show: print the card as an importable .beamcreds document")
+	code := fs.String("code", "", "show/unlock: answer to the reveal challenge (prompted for when omitted)")
+	withTOTP := fs.Bool("totp", false, "lock: require an authenticator (TOTP) code")
+	withPassphrase := fs.Bool("passphrase", false, "lock: require a passphrase")
+	if err := fs.Parse(args[1:]); err != nil {
+		return ExitUsage, err
+	}
+
+	switch sub {
+	case "show":
+		if *shipName == "" || fs.NArg() > 0 {
+			return ExitUsage, errors.New("usage: beammeup creds show --ship <name> [--json]")
+		}
+		card, err := r.Creds.Load(*shipName)
+		if err != nil {
+			return ExitFailure, fmt.Errorf("%s: %w", *shipName, err)
+		}
+		if code, err := r.passRevealGate(*code); err != nil {
+			return code, err
+		}
+		if *asJSON {
+			data, err := r.Creds.Encode(card)
+			if err != nil {
+				return ExitFailure, err
+			}
+			os.Stdout.Write(data)
+			return ExitSuccess, nil
+		}
+		printCard(card)
+		return ExitSuccess, nil
+
+	case "import":
+		if fs.NArg() != 1 {
+			return ExitUsage, errors.New("usage: beammeup creds import <file" + creds.FileExt + "> [--ship <name>]")
+		}
+		data, err := os.ReadFile(fs.Arg(0))
+		if err != nil {
+			return ExitFailure, err
+		}
+		card, err := r.Creds.Decode(data)
+		if err != nil {
+			return ExitFailure, err
+		}
+		if *shipName != "" {
+			card.Ship = *shipName
+		}
+		if card.Ship == "" || card.User == "" || card.Pass == "" {
+			return ExitFailure, errors.New("the file has no ship name or credentials")
+		}
+		if err := r.Creds.Save(card); err != nil {
+			return ExitFailure, err
+		}
+		fmt.Printf("[beammeup] credentials for %s imported.\n", ships.SanitizeName(card.Ship))
+		return ExitSuccess, nil
+
+	case "delete":
+		if *shipName == "" || fs.NArg() > 0 {
+			return ExitUsage, errors.New("usage: beammeup creds delete --ship <name>")
+		}
+		if err := r.Creds.Delete(*shipName); err != nil {
+			return ExitFailure, err
+		}
+		fmt.Printf("[beammeup] stored credentials for %s removed.\n", *shipName)
+		return ExitSuccess, nil
+
+	case "lock":
+		if *withTOTP == *withPassphrase || fs.NArg() > 0 {
+			return ExitUsage, errors.New("usage: beammeup creds lock --totp|--passphrase")
+		}
+		if gate, err := r.Creds.LoadGate(); err != nil {
+			return ExitFailure, err
+		} else if gate.Kind() != "" {
+			return ExitUsage, fmt.Errorf("a %s challenge is already set; run beammeup creds unlock first", gate.Kind())
+		}
+		return r.lockCreds(*withTOTP)
+
+	case "unlock":
+		if fs.NArg() > 0 {
+			return ExitUsage, errors.New("usage: beammeup creds unlock [--code <answer>]")
+		}
+		if code, err := r.passRevealGate(*code); err != nil {
+			return code, err
+		}
+		if err := r.Creds.SaveGate(creds.Gate{}); err != nil {
+			return ExitFailure, err
+		}
+		fmt.Println("[beammeup] reveal challenge removed.")
+		return ExitSuccess, nil
+	}
+	return ExitUsage, fmt.Errorf("unknown creds command %q. use show, import, delete, lock or unlock", sub)
+}
+
+// passRevealGate asks the reveal challenge, if one is set, before stored
+// credentials are shown or exported. answer skips the prompt.
+func (r *Runner) passRevealGate(answer string) (int, error) {
+	gate, err := r.Creds.LoadGate()
+	if err != nil {
+		return ExitFailure, err
+	}
+	if gate.Kind() == "" {
+		return ExitSuccess, nil
+	}
+	if answer == "" {
+		prompt := "Authenticator code: "
+		if gate.Kind() == "passphrase" {
+			prompt = "Reveal passphrase: "
+		}
+		if answer, err = readSecret(prompt); err != nil {
+			return ExitUsage, fmt.Errorf("%w (or pass --code)", err)
+		}
+	}
+	if !gate.Check(answer, time.Now()) {
+		// Slow down guessing.
+		time.Sleep(time.Second)
+		return ExitFailure, errors.New("reveal challenge failed")
+	}
+	return ExitSuccess, nil
+}
+
+func (r *Runner) lockCreds(totp bool) (int, error) {
+	var gate creds.Gate
+	var err error
+	if totp {
+		if gate, err = creds.NewTOTPGate(); err != nil {
+			return ExitFailure, err
+		}
+		fmt.Println("Add this secret to your authenticator app:")
+		fmt.Printf("  Secret: %s\n", gate.TOTPSecret)
+		fmt.Printf("  URI:    %s\n", gate.TOTPURI("creds"))
+		code, err := readSecret("Code shown by the app: ")
+		if err != nil {
+			return ExitUsage, err
+		}
+		if !gate.Check(code, time.Now()) {
+			return ExitFailure, errors.New("code does not match; nothing was changed")
+		}
+	} else {
+		pass, err := readSecret("New reveal passphrase: ")
+		if err != nil {
+			return ExitUsage, err
+		}
+		again, err := readSecret("Repeat passphrase: ")
+		if err != nil {
+			return ExitUsage, err
+		}
+		if pass != again {
+			return ExitFailure, errors.New("passphrases do not match; nothing was changed")
+		}
+		if gate, err = creds.NewPassphraseGate(pass); err != nil {
+			return ExitFailure, err
+		}
+	}
+	if err := r.Creds.SaveGate(gate); err != nil {
+		return ExitFailure, err
+	}
+	fmt.Printf("[beammeup] stored credentials now need the %s to be shown or exported.\n", gate.Kind())
+	return ExitSuccess, nil
+}
+
+// readSecret prompts on the terminal without echo.
+func readSecret(prompt string) (string, error) {
+	fd, err := stdinFD()
+	if err != nil {
+		return "", err
+	}
+	if !term.IsTerminal(fd) {
+		return "", errors.New("no terminal to prompt on")
+	}
+	fmt.Print(prompt)
+	b, err := term.ReadPassword(fd)
+	fmt.Println()
+	if err != nil {
+		return "", fmt.Errorf("read input: %w", err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func printCard(card creds.Card) {
+	fmt.Printf("\n[beammeup] credentials :: %s\n", card.Ship)
+	fmt.Printf("  Proxy: %s://%s:%s\n", card.Protocol, card.Host, card.Port)
+	if card.HTTPMode != "" {
+		fmt.Printf("  HTTP mode: %s\n", card.HTTPMode)
+	}
+	fmt.Printf("  Username: %s\n", card.User)
+	fmt.Printf("  Password: %s\n", card.Pass)
+	if card.EgressIP != "" {
+		fmt.Printf("  Exit IP: %s\n", card.EgressIP)
+	}
+	if !card.ExpiresAt.IsZero() {
+		fmt.Printf("  Self-destruct: %s\n", card.ExpiresAt.Local().Format("2006-01-02 15:04"))
+	}
+	fmt.Printf("  Stored: %s\n", card.UpdatedAt.Local().Format("2006-01-02 15:04"))
+}
@@ -0,0 +1,50 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/alfaoz/beammeup/internal/hangar"
+	"github.com/spf13/pflag"
+)
+
+func (r *Runner) runDiff(args []string) (int, error) {
+	opts := DefaultOptions()
+	fs := pflag.NewFlagSet("beammeup diff", pflag.ContinueOnError)
+	addTargetFlags(fs, &opts)
+	if err := parseCommandFlags(fs, args); err != nil {
+		return ExitUsage, err
+	}
+	if opts.ShipName == "" {
+		return ExitUsage, errors.New("diff compares a saved profile; pass --ship <name>")
+	}
+
+	ship, password, code, err := r.prepareTarget(opts)
+	if err != nil {
+		return code, err
+	}
+	inv, err := r.Hangar.Inventory(ship, password)
+	if err != nil {
+		return ExitFailure, err
+	}
+
+	diffs := hangar.Diff(ship, inv)
+	fmt.Printf("[beammeup] %s: saved profile vs hangar on %s\n\n", ship.Name, fallback(inv.PublicIP, ship.Host))
+	fmt.Printf("    %-14s  %-20s  %s\n", "FIELD", "PROFILE", "HANGAR")
+	changed := 0
+	for _, d := range diffs {
+		marker := " "
+		if d.Differs() {
+			marker = "~"
+			changed++
+		}
+		fmt.Printf("  %s %-14s  %-20s  %s\n", marker, d.Field, d.Local, d.Remote)
+	}
+
+	if changed == 0 {
+		fmt.Println("\n[beammeup] profile and hangar match.")
+		return ExitSuccess, nil
+	}
+	fmt.Printf("\n[beammeup] %d field(s) differ. run `beammeup --ship %s --action configure` to apply the profile.\n", changed, ship.Name)
+	return ExitFailure, nil
+}
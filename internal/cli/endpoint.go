@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alfaoz/beammeup/internal/hangar"
+	"github.com/alfaoz/beammeup/internal/probe"
+	"github.com/alfaoz/beammeup/internal/ships"
+	"github.com/alfaoz/beammeup/internal/sshx"
+	"github.com/alfaoz/beammeup/internal/tunnel"
+)
+
+// hangarEndpoint describes how the client reaches the proxy from an apply or
+// show result: the ship's host, or localhost when the proxy only listens there.
+func hangarEndpoint(ship ships.Ship, res hangar.ActionResult) probe.Endpoint {
+	host := ship.Host
+	if ship.ListenLocal {
+		host = "127.0.0.1"
+	}
+	return probe.Endpoint{
+		Protocol: strings.ToLower(res.Protocol),
+		Host:     host,
+		Port:     res.Port,
+		User:     res.User,
+		Pass:     res.Pass,
+	}
+}
+
+// reachTimeout bounds each step of the reachability check after an apply.
+const reachTimeout = 5 * time.Second
+
+// reachFromHere checks the proxy port of an apply result from this machine,
+// with a request through it when auth is set. A proxy that only listens on
+// localhost is reached over SSH, so it is not checked and State is empty.
+func reachFromHere(ship ships.Ship, res hangar.ActionResult, auth bool) probe.Reachability {
+	if ship.ListenLocal || res.Port == "" {
+		return probe.Reachability{}
+	}
+	return probe.Reach(hangarEndpoint(ship, res), auth, probe.DefaultIPEchoURL, reachTimeout)
+}
+
+// proxyDialer returns how the client reaches the ship's proxy port: directly,
+// or through an SSH connection when the proxy only listens on localhost.
+func (r *Runner) proxyDialer(ship ships.Ship, password string) (tunnel.DialFunc, func(), error) {
+	if !ship.ListenLocal {
+		return nil, func() {}, nil
+	}
+	target := hangar.ShipTarget(ship, password)
+	client, err := sshx.ConnectWithOptions(target, r.Hangar.SSH)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ssh connect: %w", err)
+	}
+	return client.Dial, func() { client.Close() }, nil
+}
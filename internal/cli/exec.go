@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/alfaoz/beammeup/internal/hangar"
+	"github.com/alfaoz/beammeup/internal/sshx"
+	"github.com/spf13/pflag"
+)
+
+// runExec runs a one-off command on the ship's server and exits with its
+// status, so it can stand in for ssh in scripts.
+func (r *Runner) runExec(args []string) (int, error) {
+	opts := DefaultOptions()
+	fs := pflag.NewFlagSet("beammeup exec", pflag.ContinueOnError)
+	fs.SetInterspersed(false)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: beammeup exec --ship <name> [options] -- <command> [args...]")
+		fs.PrintDefaults()
+	}
+	addTargetFlags(fs, &opts)
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage, err
+	}
+	command := strings.TrimSpace(strings.Join(fs.Args(), " "))
+	if command == "" {
+		return ExitUsage, errors.New("usage: beammeup exec --ship <name> -- <command>")
+	}
+
+	ship, password, code, err := r.prepareTarget(opts)
+	if err != nil {
+		return code, err
+	}
+	if r.Hangar.ReadOnly {
+		// There is no telling what the command changes.
+		return ExitUsage, fmt.Errorf("%w: exec can change anything on the server", hangar.ErrReadOnly)
+	}
+	target := hangar.ShipTarget(ship, password)
+	client, err := sshx.ConnectWithOptions(target, r.Hangar.SSH)
+	if err != nil {
+		return ExitFailure, fmt.Errorf("ssh connect: %w", err)
+	}
+	defer client.Close()
+
+	status, err := client.Stream(command, os.Stdin, os.Stdout, os.Stderr)
+	if err != nil {
+		return ExitFailure, fmt.Errorf("exec: %w", err)
+	}
+	return status, nil
+}
@@ -0,0 +1,309 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/alfaoz/beammeup/internal/config"
+	"github.com/alfaoz/beammeup/internal/creds"
+	"github.com/alfaoz/beammeup/internal/exports"
+	"github.com/alfaoz/beammeup/internal/geoip"
+	"github.com/alfaoz/beammeup/internal/ships"
+	"github.com/alfaoz/beammeup/internal/sshx"
+	"github.com/spf13/pflag"
+)
+
+// exportFormats lists what beammeup export writes: ssh-config plus the
+// credential formats of the exports package.
+var exportFormats = append([]string{"ssh-config"}, exports.Formats...)
+
+// runExport writes the saved ships in a format other tools read: an
+// ssh_config file to Include, so plain ssh and scp reach the fleet by ship
+// name, or their proxy credentials (csv, json, host:port:user:pass lines,
+// Clash or FoxyProxy) for loading into other tooling. With --register the
+// file is remembered for the ship and rewritten whenever its credentials
+// change.
+func (r *Runner) runExport(args []string) (int, error) {
+	fs := pflag.NewFlagSet("beammeup export", pflag.ContinueOnError)
+	format := fs.String("format", "", "What to write: "+strings.Join(exportFormats, ", "))
+	output := fs.String("output", "", "File to write, or - for stdout (default: ~/.beammeup/ssh_config for ssh-config, stdout otherwise)")
+	shipName := fs.String("ship", "", "Only this saved ship")
+	tag := fs.String("tag", "", "Only ships carrying this tag")
+	prefix := fs.String("prefix", "", "ssh-config: put this in front of every Host alias, e.g. bm-")
+	identity := fs.String("identity-file", "", "ssh-config: IdentityFile for ships whose profile sets none (SSH_IDENTITY_FILE)")
+	protocol := fs.String("protocol", "", "Credential formats: only proxies of this protocol (http or socks5)")
+	code := fs.String("code", "", "Credential formats: answer to the reveal challenge (prompted for when omitted)")
+	register := fs.Bool("register", false, "With --ship, --format and --output: also rewrite this file whenever the ship's credentials change")
+	unregister := fs.Bool("unregister", false, "With --ship and --output: stop rewriting this file")
+	listTargets := fs.Bool("list", false, "With --ship: show the files registered for the ship")
+	refresh := fs.Bool("refresh", false, "With --ship: rewrite every file registered for the ship now")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return ExitUsage, err
+	}
+	var ops []string
+	for op, set := range map[string]bool{"register": *register, "unregister": *unregister, "list": *listTargets, "refresh": *refresh} {
+		if set {
+			ops = append(ops, op)
+		}
+	}
+	if len(ops) > 1 {
+		return ExitUsage, errors.New("use only one of --register, --unregister, --list and --refresh")
+	}
+	if len(ops) == 1 {
+		return r.exportTargets(ops[0], *shipName, *format, strings.TrimSpace(*output), *code)
+	}
+	if !slices.Contains(exportFormats, *format) {
+		return ExitUsage, fmt.Errorf("--format must be one of: %s", strings.Join(exportFormats, ", "))
+	}
+	*protocol = strings.ToLower(strings.TrimSpace(*protocol))
+	if *protocol != "" && *protocol != "http" && *protocol != "socks5" {
+		return ExitUsage, errors.New("--protocol must be http or socks5")
+	}
+	if strings.ContainsAny(*prefix, " \t*?!") {
+		return ExitUsage, errors.New("--prefix cannot contain spaces or ssh_config patterns")
+	}
+
+	names, err := r.Store.List()
+	if err != nil {
+		return ExitFailure, err
+	}
+	if *shipName != "" {
+		names = []string{ships.SanitizeName(*shipName)}
+	}
+	list := make([]ships.Ship, 0, len(names))
+	for _, name := range names {
+		ship, err := r.Store.Load(name)
+		if err != nil {
+			if *shipName != "" {
+				return ExitFailure, err
+			}
+			fmt.Fprintf(os.Stderr, "[beammeup] skipping %s: %v\n", name, err)
+			continue
+		}
+		if *tag != "" && !ship.HasTag(*tag) {
+			continue
+		}
+		if ship.IdentityFile == "" {
+			ship.IdentityFile = strings.TrimSpace(*identity)
+		}
+		list = append(list, ship)
+	}
+	if *tag != "" && len(list) == 0 {
+		return ExitFailure, fmt.Errorf("no saved ship carries the tag %q", *tag)
+	}
+
+	path := strings.TrimSpace(*output)
+	count := len(list)
+	var content string
+	switch *format {
+	case "ssh-config":
+		content = sshConfig(list, *prefix, sshx.DefaultConnectOptions().KnownHostsPath)
+		if path == "" {
+			dir, err := config.Dir()
+			if err != nil {
+				return ExitFailure, err
+			}
+			path = filepath.Join(dir, "ssh_config")
+		}
+	default:
+		if r.Creds == nil {
+			return ExitFailure, errors.New("no credential store available")
+		}
+		if exit, err := r.passRevealGate(*code); err != nil {
+			return exit, err
+		}
+		rows := r.credentialRows(list, *protocol, *shipName == "")
+		if len(rows) == 0 {
+			return ExitFailure, errors.New("no stored credentials to export; run configure or show on the ships first")
+		}
+		count = len(rows)
+		if content, err = exports.Render(rows, *format); err != nil {
+			return ExitFailure, err
+		}
+	}
+
+	if path == "" || path == "-" {
+		fmt.Print(content)
+		return ExitSuccess, nil
+	}
+	if path, err = exports.ExpandHome(path); err != nil {
+		return ExitFailure, err
+	}
+	if err := exports.WriteFileAtomic(path, []byte(content), 0o600); err != nil {
+		return ExitFailure, err
+	}
+	fmt.Printf("[beammeup] wrote %d ship(s) to %s\n", count, path)
+	if *format == "ssh-config" {
+		fmt.Printf("Use them from plain ssh by adding this line near the top of ~/.ssh/config:\n  Include %s\n", path)
+	}
+	return ExitSuccess, nil
+}
+
+// credentialRows reads the stored result cards of list, keeping only
+// proxies of protocol when it is set. Ships without a card or with a locked
+// one are left out with a note on stderr. With skipLocal, so are proxies
+// that only listen on localhost; for a single named ship they are kept, with
+// 127.0.0.1 for its SSH tunnel.
+func (r *Runner) credentialRows(list []ships.Ship, protocol string, skipLocal bool) []exports.Row {
+	var rows []exports.Row
+	for _, ship := range list {
+		if ship.ListenLocal && skipLocal {
+			fmt.Fprintf(os.Stderr, "[beammeup] skipping %s: the proxy only listens on localhost\n", ship.Name)
+			continue
+		}
+		card, err := r.Creds.Load(ship.Name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[beammeup] skipping %s: %v\n", ship.Name, err)
+			continue
+		}
+		if protocol != "" && card.Protocol != protocol {
+			continue
+		}
+		rows = append(rows, exports.FromCard(ship, card).Locate(r.exitGeo(ship.Name)))
+	}
+	return rows
+}
+
+// exitGeo returns where the last inventory located the ship's exit IP.
+func (r *Runner) exitGeo(name string) geoip.Info {
+	info, _ := r.Store.LoadHostInfo(name)
+	return info.Geo
+}
+
+// exportTargets lists, registers, unregisters or rewrites (op) the files
+// kept in step with a ship's credentials.
+func (r *Runner) exportTargets(op, shipName, format, output, code string) (int, error) {
+	if shipName == "" {
+		return ExitUsage, fmt.Errorf("--%s needs --ship <name>", op)
+	}
+	ship, err := r.Store.Load(shipName)
+	if err != nil {
+		return ExitFailure, err
+	}
+
+	switch op {
+	case "list":
+		targets := exports.Targets(ship)
+		if len(targets) == 0 {
+			fmt.Printf("[beammeup] no export files registered for %s.\n", ship.Name)
+			return ExitSuccess, nil
+		}
+		for _, t := range targets {
+			fmt.Printf("  %-10s %s\n", t.Format, t.Path)
+		}
+		return ExitSuccess, nil
+
+	case "unregister":
+		if output == "" {
+			return ExitUsage, errors.New("--unregister needs --output <file>")
+		}
+		var kept []string
+		for _, raw := range ship.Exports {
+			if t, err := exports.ParseTarget(raw); err != nil || t.Path != output {
+				kept = append(kept, raw)
+			}
+		}
+		if len(kept) == len(ship.Exports) {
+			return ExitFailure, fmt.Errorf("%s is not registered for %s", output, ship.Name)
+		}
+		ship.Exports = kept
+		if _, err := r.Store.Save(ship); err != nil {
+			return ExitFailure, err
+		}
+		fmt.Printf("[beammeup] %s is no longer updated for %s; the file itself is left in place.\n", output, ship.Name)
+		return ExitSuccess, nil
+
+	case "register":
+		if output == "" || output == "-" {
+			return ExitUsage, errors.New("--register needs --output <file>")
+		}
+		t, err := exports.ParseTarget(format + ":" + output)
+		if err != nil {
+			return ExitUsage, err
+		}
+		if slices.Contains(ship.Exports, t.String()) {
+			fmt.Printf("[beammeup] %s is already registered for %s.\n", t, ship.Name)
+		} else {
+			ship.Exports = append(ship.Exports, t.String())
+			if ship, err = r.Store.Save(ship); err != nil {
+				return ExitFailure, err
+			}
+			fmt.Printf("[beammeup] %s registered for %s; it is rewritten whenever the credentials change.\n", t, ship.Name)
+		}
+	}
+
+	if len(ship.Exports) == 0 {
+		return ExitFailure, fmt.Errorf("no export files registered for %s; add one with --register", ship.Name)
+	}
+	if r.Creds == nil {
+		return ExitFailure, errors.New("no credential store available")
+	}
+	if exit, err := r.passRevealGate(code); err != nil {
+		return exit, err
+	}
+	card, err := r.Creds.Load(ship.Name)
+	if err != nil {
+		if op == "register" {
+			fmt.Printf("[beammeup] not written yet (%v); the next configure or show writes it.\n", err)
+			return ExitSuccess, nil
+		}
+		return ExitFailure, err
+	}
+	written, err := exports.Regenerate(ship, card, r.exitGeo(ship.Name))
+	for _, path := range written {
+		fmt.Printf("  %s\n", path)
+	}
+	if err != nil {
+		return ExitFailure, err
+	}
+	fmt.Printf("[beammeup] %d export file(s) written for %s.\n", len(written), ship.Name)
+	return ExitSuccess, nil
+}
+
+// regenerateExports rewrites the export files registered for ship from its
+// new result card, e.g. after an apply or rotate changed the credentials.
+func (r *Runner) regenerateExports(ship ships.Ship, card creds.Card) {
+	if len(ship.Exports) == 0 {
+		return
+	}
+	written, err := exports.Regenerate(ship, card, r.exitGeo(ship.Name))
+	for _, path := range written {
+		fmt.Printf("Export updated: %s\n", path)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[beammeup] WARNING: %v\n", err)
+	}
+}
+
+// sshConfig renders one Host block per ship. knownHosts, when set, points
+// ssh at the host keys beammeup already trusts.
+func sshConfig(list []ships.Ship, prefix, knownHosts string) string {
+	var b strings.Builder
+	b.WriteString("# Written by beammeup export --format ssh-config; changes here are overwritten.\n")
+	for _, ship := range list {
+		fmt.Fprintf(&b, "\nHost %s%s\n", prefix, ship.Name)
+		fmt.Fprintf(&b, "  HostName %s\n", ship.Host)
+		fmt.Fprintf(&b, "  User %s\n", sshConfigValue(ship.SSHUser))
+		fmt.Fprintf(&b, "  Port %s\n", strconv.Itoa(ship.SSHPort))
+		if ship.IdentityFile != "" {
+			fmt.Fprintf(&b, "  IdentityFile %s\n", sshConfigValue(ship.IdentityFile))
+		}
+		if knownHosts != "" {
+			fmt.Fprintf(&b, "  UserKnownHostsFile %s\n", sshConfigValue(knownHosts))
+		}
+	}
+	return b.String()
+}
+
+// sshConfigValue quotes v when it holds whitespace.
+func sshConfigValue(v string) string {
+	if strings.ContainsAny(v, " \t") {
+		return strconv.Quote(v)
+	}
+	return v
+}
@@ -0,0 +1,33 @@
+package cli
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/alfaoz/beammeup/internal/ships"
+)
+
+func TestSSHConfig(t *testing.T) {
+	got := sshConfig([]ships.Ship{
+		{Name: "edge", Host: "198.51.100.10", SSHPort: 22, SSHUser: "root", IdentityFile: "~/.ssh/id_ed25519"},
+		{Name: "lab", Host: "lab.example.com", SSHPort: 2222, SSHUser: "ops"},
+	}, "bm-", "/home/me/My Keys/known_hosts")
+
+	want := `
+Host bm-edge
+  HostName 198.51.100.10
+  User root
+  Port 22
+  IdentityFile ~/.ssh/id_ed25519
+  UserKnownHostsFile "/home/me/My Keys/known_hosts"
+
+Host bm-lab
+  HostName lab.example.com
+  User ops
+  Port 2222
+  UserKnownHostsFile "/home/me/My Keys/known_hosts"
+`
+	if !strings.HasPrefix(got, "# ") || !strings.HasSuffix(got, want) {
+		t.Fatalf("sshConfig =\n%s", got)
+	}
+}
@@ -0,0 +1,102 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/alfaoz/beammeup/internal/hangar"
+	"github.com/alfaoz/beammeup/internal/sshx"
+	"github.com/spf13/pflag"
+)
+
+func (r *Runner) runFetch(args []string) (int, error) {
+	opts := DefaultOptions()
+	fs := pflag.NewFlagSet("beammeup fetch", pflag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: beammeup fetch --ship <name> [options] <remote-path|shortcut> [local-path|-]")
+		fs.PrintDefaults()
+		fmt.Fprintln(os.Stderr, "\nShortcuts:")
+		for _, f := range hangar.ManagedFiles {
+			fmt.Fprintf(os.Stderr, "  %-20s  %s\n", f.Name, f.Path)
+		}
+	}
+	addTargetFlags(fs, &opts)
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage, err
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		return ExitUsage, errors.New("usage: beammeup fetch --ship <name> <remote-path|shortcut> [local-path|-]")
+	}
+
+	remotePath := fs.Arg(0)
+	secret := false
+	if f, ok := hangar.ResolveManagedFile(remotePath, opts.RemoteTmpDir); ok {
+		remotePath, secret = f.Path, f.Secret
+	} else if !strings.HasPrefix(remotePath, "/") {
+		return ExitUsage, fmt.Errorf("%q is neither an absolute path nor a shortcut (see beammeup fetch -h)", remotePath)
+	}
+	localPath := fs.Arg(1)
+	if localPath == "" {
+		localPath = path.Base(remotePath)
+	} else if info, err := os.Stat(localPath); err == nil && info.IsDir() {
+		localPath = filepath.Join(localPath, path.Base(remotePath))
+	}
+
+	ship, password, code, err := r.prepareTarget(opts)
+	if err != nil {
+		return code, err
+	}
+	target := hangar.ShipTarget(ship, password)
+	client, err := sshx.ConnectWithOptions(target, r.Hangar.SSH)
+	if err != nil {
+		return ExitFailure, fmt.Errorf("ssh connect: %w", err)
+	}
+	defer client.Close()
+
+	if localPath == "-" {
+		if err := client.Download(remotePath, os.Stdout); err != nil {
+			return ExitFailure, fmt.Errorf("fetch %s: %w", remotePath, err)
+		}
+		return ExitSuccess, nil
+	}
+	n, err := downloadFile(client, remotePath, localPath)
+	if err != nil {
+		return ExitFailure, err
+	}
+	fmt.Printf("[beammeup] fetched %s:%s -> %s (%d bytes)\n", ship.Name, remotePath, localPath, n)
+	if secret {
+		fmt.Println("  This file contains proxy credentials; it was saved with mode 0600.")
+	}
+	return ExitSuccess, nil
+}
+
+// downloadFile writes remotePath to localPath, which only appears once the
+// transfer completed. Files are private (0600) since configs and logs can
+// hold credentials and client addresses.
+func downloadFile(client *sshx.Client, remotePath, localPath string) (int64, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(localPath), "."+filepath.Base(localPath)+".*")
+	if err != nil {
+		return 0, fmt.Errorf("create %s: %w", localPath, err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := client.Download(remotePath, tmp); err != nil {
+		tmp.Close()
+		return 0, fmt.Errorf("fetch %s: %w", remotePath, err)
+	}
+	info, err := tmp.Stat()
+	if err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, fmt.Errorf("write %s: %w", localPath, err)
+	}
+	if err := os.Rename(tmp.Name(), localPath); err != nil {
+		return 0, fmt.Errorf("write %s: %w", localPath, err)
+	}
+	return info.Size(), nil
+}
@@ -0,0 +1,521 @@
+package cli
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alfaoz/beammeup/internal/config"
+	"github.com/alfaoz/beammeup/internal/events"
+)
+
+// fleetTarget is one line of a --ships-from list: a saved ship, or a server
+// given as [user@]host[:port].
+type fleetTarget struct {
+	Ship string
+	Host string
+	User string
+	Port int
+}
+
+func (t fleetTarget) String() string {
+	if t.Ship != "" {
+		return t.Ship
+	}
+	s := t.Host
+	if t.Port > 0 {
+		s = net.JoinHostPort(t.Host, strconv.Itoa(t.Port))
+	}
+	if t.User != "" {
+		s = t.User + "@" + s
+	}
+	return s
+}
+
+// readFleet reads the --ships-from list at path ("-" is stdin): one ship
+// name or host spec per line. Blank lines and # comments are skipped, and
+// names of saved ships win over host names.
+func readFleet(path string, saved []string) ([]fleetTarget, error) {
+	var in io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		in = f
+	}
+	var targets []fleetTarget
+	scanner := bufio.NewScanner(in)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if slices.Contains(saved, line) {
+			targets = append(targets, fleetTarget{Ship: line})
+			continue
+		}
+		t, err := parseHostSpec(line)
+		if err != nil {
+			return nil, fmt.Errorf("--ships-from line %d: %w", n, err)
+		}
+		targets = append(targets, t)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("--ships-from: %w", err)
+	}
+	return targets, nil
+}
+
+// parseHostSpec reads [user@]host[:port]; IPv6 addresses with a port go in
+// brackets, as in [2001:db8::1]:2222.
+func parseHostSpec(spec string) (fleetTarget, error) {
+	var t fleetTarget
+	rest := spec
+	if i := strings.LastIndex(rest, "@"); i >= 0 {
+		t.User, rest = rest[:i], rest[i+1:]
+		if t.User == "" {
+			return t, fmt.Errorf("%q: empty user", spec)
+		}
+	}
+	t.Host = rest
+	if strings.HasPrefix(rest, "[") || strings.Count(rest, ":") == 1 {
+		host, port, err := net.SplitHostPort(rest)
+		if err != nil {
+			return t, fmt.Errorf("%q: %w", spec, err)
+		}
+		n, err := strconv.Atoi(port)
+		if err != nil || n < 1 || n > 65535 {
+			return t, fmt.Errorf("%q: invalid port %q", spec, port)
+		}
+		t.Host, t.Port = host, n
+	}
+	if t.Host == "" || strings.ContainsAny(t.Host, " \t/") {
+		return t, fmt.Errorf("%q is neither a saved ship nor a host", spec)
+	}
+	return t, nil
+}
+
+// Outcomes of a fleet's ships. A ship that never started has none.
+const (
+	fleetDone           = "done"
+	fleetFailed         = "failed"
+	fleetRolledBack     = "rolled back"
+	fleetRollbackFailed = "rollback failed"
+)
+
+// runFleet runs opts against every ship in the --ships-from list, or with
+// --retry-failed the ships the last list left unfinished: one after the
+// other, or with --max-parallel several at once. --on-error decides what a
+// failed ship does to the rest; the run fails if any ship did.
+func (r *Runner) runFleet(opts Options) (int, error) {
+	switch {
+	case opts.ShipName != "" || opts.Host != "" || opts.Last:
+		return ExitUsage, errors.New("use either --ships-from or --ship/--host/--last, not both")
+	case opts.ShipsFrom != "" && opts.RetryFailed:
+		return ExitUsage, errors.New("--retry-failed takes its ships from the last run; drop --ships-from")
+	case opts.Stealth:
+		return ExitUsage, errors.New("--stealth serves one ship; it does not take --ships-from")
+	case opts.ListShips:
+		return ExitUsage, errors.New("--list-ships does not take --ships-from")
+	case opts.MaxParallel < 1:
+		return ExitUsage, errors.New("--max-parallel must be at least 1")
+	case opts.Stagger < 0:
+		return ExitUsage, errors.New("--stagger must not be negative")
+	}
+	switch opts.OnError {
+	case "continue", "stop":
+	case "rollback":
+		if action, _ := NormalizeAction(strings.ToLower(strings.TrimSpace(opts.Action))); (action != "configure" && action != "rotate") || opts.PreflightOnly || opts.ShowInventory {
+			return ExitUsage, errors.New("--on-error rollback only applies to configure and rotate")
+		}
+	default:
+		return ExitUsage, errors.New("invalid --on-error. use continue, stop or rollback")
+	}
+	saved, err := r.Store.List()
+	if err != nil {
+		return ExitFailure, err
+	}
+	statePath, err := fleetStatePath()
+	if err != nil {
+		return ExitFailure, err
+	}
+	list := opts.ShipsFrom
+	if opts.RetryFailed {
+		if _, err := os.Stat(statePath); errors.Is(err, os.ErrNotExist) {
+			return ExitUsage, errors.New("--retry-failed: the last --ships-from run left no failed ships")
+		}
+		list = statePath
+	}
+	targets, err := readFleet(list, saved)
+	if err != nil {
+		return ExitUsage, err
+	}
+	if len(targets) == 0 {
+		return ExitUsage, errors.New("--ships-from: no ships listed")
+	}
+
+	var outcome []string
+	if opts.MaxParallel > 1 && len(targets) > 1 {
+		outcome, err = r.runFleetParallel(opts, targets)
+		if err != nil {
+			return ExitFailure, err
+		}
+	} else {
+		outcome = r.runFleetInOrder(opts, targets)
+	}
+	if opts.OnError == "rollback" && slices.Contains(outcome, fleetFailed) {
+		r.rollbackFleet(opts, targets, outcome)
+	}
+
+	byOutcome := map[string][]string{}
+	var unfinished []string
+	for i, t := range targets {
+		o := outcome[i]
+		if o == "" {
+			o = "skipped"
+		}
+		byOutcome[o] = append(byOutcome[o], t.String())
+		if o != fleetDone {
+			unfinished = append(unfinished, t.String())
+		}
+	}
+	if err := saveFleetState(statePath, unfinished); err != nil {
+		fmt.Fprintf(os.Stderr, "[beammeup] WARNING: could not save the failed ships for --retry-failed: %v\n", err)
+	}
+
+	done := len(byOutcome[fleetDone])
+	fmt.Printf("\n[beammeup] %d of %d ships done.\n", done, len(targets))
+	for _, o := range []string{fleetDone, fleetFailed, "skipped", fleetRolledBack, fleetRollbackFailed} {
+		if names := byOutcome[o]; len(names) > 0 && done < len(targets) {
+			fmt.Printf("  %-16s %s\n", o+":", strings.Join(names, ", "))
+		}
+	}
+	if len(unfinished) > 0 {
+		fmt.Println("Run them again with --retry-failed and the same options.")
+	}
+	r.Events.Result(map[string]string{
+		"ships":           strconv.Itoa(len(targets)),
+		"done":            strings.Join(byOutcome[fleetDone], ","),
+		"failed":          strings.Join(byOutcome[fleetFailed], ","),
+		"skipped":         strings.Join(byOutcome["skipped"], ","),
+		"rolled_back":     strings.Join(byOutcome[fleetRolledBack], ","),
+		"rollback_failed": strings.Join(byOutcome[fleetRollbackFailed], ","),
+	})
+	if len(unfinished) > 0 {
+		return ExitFailure, fmt.Errorf("%d of %d ships not done: %s", len(unfinished), len(targets), strings.Join(unfinished, ", "))
+	}
+	return ExitSuccess, nil
+}
+
+// shipOptions points opts at t for a run of its own.
+func (t fleetTarget) shipOptions(opts Options) Options {
+	o := opts
+	o.ShipsFrom, o.RetryFailed, o.MaxParallel, o.Stagger, o.OnError = "", false, 1, 0, "continue"
+	if t.Ship != "" {
+		o.ShipName = t.Ship
+		return o
+	}
+	o.Host = t.Host
+	if t.User != "" {
+		o.SSHUser = t.User
+	}
+	if t.Port > 0 {
+		o.SSHPort = t.Port
+	}
+	return o
+}
+
+// runFleetInOrder runs the ships one at a time in this process and returns
+// their outcomes.
+func (r *Runner) runFleetInOrder(opts Options, targets []fleetTarget) []string {
+	outcome := make([]string, len(targets))
+	pace := fleetPacer{gap: opts.Stagger}
+	for i, t := range targets {
+		pace.wait()
+		fmt.Printf("\n[beammeup] === %s (%d/%d) ===\n", t, i+1, len(targets))
+		outcome[i] = fleetDone
+		if !r.runFleetShip(t, t.shipOptions(opts)) {
+			outcome[i] = fleetFailed
+			if opts.OnError != "continue" {
+				fmt.Printf("\n[beammeup] %s failed; not starting the other ships (--on-error %s).\n", t, opts.OnError)
+				break
+			}
+		}
+	}
+	return outcome
+}
+
+// runFleetShip runs o in this process with t's events and reports whether
+// it succeeded.
+func (r *Runner) runFleetShip(t fleetTarget, o Options) bool {
+	fleetEvents, progress := r.Events, r.Hangar.Progress
+	defer func() { r.Events, r.Hangar.Progress = fleetEvents, progress }()
+	r.Events = fleetEvents.ForShip(t.String())
+	if fleetEvents != nil {
+		r.Hangar.Progress = r.Events.LogWriter()
+	}
+	code, err := r.run(o)
+	if err != nil {
+		r.Events.Error(err)
+		fmt.Fprintf(os.Stderr, "[beammeup] %s: %v\n", t, err)
+	} else if code != ExitSuccess {
+		r.Events.Error(fmt.Errorf("exit status %d", code))
+	}
+	return err == nil && code == ExitSuccess
+}
+
+// rollbackFleet restores the snapshot each ship done in this run took
+// before its apply, one ship at a time.
+func (r *Runner) rollbackFleet(opts Options, targets []fleetTarget, outcome []string) {
+	var done []int
+	for i, o := range outcome {
+		if o == fleetDone {
+			done = append(done, i)
+		}
+	}
+	if len(done) == 0 {
+		return
+	}
+	fmt.Printf("\n[beammeup] rolling back the %d ships configured in this run (--on-error rollback).\n", len(done))
+	for _, i := range done {
+		t := targets[i]
+		o := fleetRollbackOptions(t.shipOptions(opts))
+		fmt.Printf("\n[beammeup] === rollback %s ===\n", t)
+		outcome[i] = fleetRolledBack
+		if !r.runFleetShip(t, o) {
+			outcome[i] = fleetRollbackFailed
+		}
+	}
+}
+
+// fleetRollbackOptions keeps the connection settings of opts and asks for
+// the newest snapshot back.
+func fleetRollbackOptions(opts Options) Options {
+	o := DefaultOptions()
+	o.Host, o.ShipName = opts.Host, opts.ShipName
+	o.SSHPort, o.SSHUser, o.SSHPassword = opts.SSHPort, opts.SSHUser, opts.SSHPassword
+	o.SSHKnownHosts, o.SSHOTPCommand = opts.SSHKnownHosts, opts.SSHOTPCommand
+	o.SSHRetries, o.SSHRetryDelay, o.SSHKeepAlive = opts.SSHRetries, opts.SSHRetryDelay, opts.SSHKeepAlive
+	o.SSHCiphers, o.SSHKex, o.SSHMACs, o.SSHHostKeyAlgorithms = opts.SSHCiphers, opts.SSHKex, opts.SSHMACs, opts.SSHHostKeyAlgorithms
+	o.SSHProxy = opts.SSHProxy
+	o.RemoteTmpDir, o.StrictHostKey, o.InsecureHostKey, o.HashKnownHosts = opts.RemoteTmpDir, opts.StrictHostKey, opts.InsecureHostKey, opts.HashKnownHosts
+	o.BreakLock = opts.BreakLock
+	o.Action, o.RollbackTo, o.Yes = "rollback", "latest", true
+	return o
+}
+
+// runFleetParallel runs up to --max-parallel ships at once, each in a
+// beammeup process of its own started with this command line, and returns
+// their outcomes. Their output comes through line by line, prefixed with
+// the ship, and their events are relayed into this run's stream.
+func (r *Runner) runFleetParallel(opts Options, targets []fleetTarget) ([]string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("locate beammeup binary: %w", err)
+	}
+	base := fleetChildArgs(os.Args[1:])
+
+	var (
+		mu       sync.Mutex // guards the output and everything below
+		wg       sync.WaitGroup
+		finished int
+		nfailed  int
+		stopping bool
+	)
+	outcome := make([]string, len(targets))
+	slots := make(chan struct{}, opts.MaxParallel)
+	pace := fleetPacer{gap: opts.Stagger}
+	for i, t := range targets {
+		slots <- struct{}{}
+		pace.wait()
+		mu.Lock()
+		if stopping {
+			mu.Unlock()
+			<-slots
+			break
+		}
+		fmt.Printf("[beammeup] %s: started (%d/%d)\n", t, i+1, len(targets))
+		mu.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := runFleetChild(exe, append(slices.Clone(base), t.args()...), t.String(), r.Events.ForShip(t.String()), &mu)
+			<-slots
+			mu.Lock()
+			defer mu.Unlock()
+			finished++
+			outcome[i] = fleetDone
+			status := "done"
+			if err != nil {
+				outcome[i] = fleetFailed
+				nfailed++
+				status = "failed (" + err.Error() + ")"
+			}
+			fmt.Printf("[beammeup] %s: %s; %d of %d finished, %d failed\n", t, status, finished, len(targets), nfailed)
+			if err != nil && opts.OnError != "continue" && !stopping {
+				stopping = true
+				fmt.Printf("[beammeup] not starting more ships; waiting for the running ones (--on-error %s).\n", opts.OnError)
+			}
+		}()
+	}
+	wg.Wait()
+	return outcome, nil
+}
+
+// runFleetChild runs one ship of a parallel fleet and waits for it. Lines
+// the child prints go to our stdout and stderr as "ship | line", under mu.
+func runFleetChild(exe string, args []string, ship string, em *events.Emitter, mu *sync.Mutex) error {
+	var evRead, evWrite *os.File
+	if em != nil {
+		var err error
+		if evRead, evWrite, err = os.Pipe(); err != nil {
+			return err
+		}
+		defer evRead.Close()
+		args = append(args, "--events-json=3")
+	}
+	cmd := exec.Command(exe, args...)
+	cmd.Env = append(os.Environ(), "BEAMMEUP_AUTO_UPDATE=0")
+	if evWrite != nil {
+		cmd.ExtraFiles = []*os.File{evWrite}
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	err = cmd.Start()
+	if evWrite != nil {
+		evWrite.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	prefix := func(in io.Reader, out io.Writer) {
+		defer wg.Done()
+		scanner := bufio.NewScanner(in)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			mu.Lock()
+			fmt.Fprintf(out, "%s | %s\n", ship, scanner.Text())
+			mu.Unlock()
+		}
+	}
+	wg.Add(2)
+	go prefix(stdout, os.Stdout)
+	go prefix(stderr, os.Stderr)
+	if evRead != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dec := json.NewDecoder(evRead)
+			for {
+				var ev events.Event
+				if err := dec.Decode(&ev); err != nil {
+					return
+				}
+				em.Relay(ev)
+			}
+		}()
+	}
+	wg.Wait()
+	return cmd.Wait()
+}
+
+// args selects t on a child's command line.
+func (t fleetTarget) args() []string {
+	if t.Ship != "" {
+		return []string{"--ship=" + t.Ship}
+	}
+	args := []string{"--host=" + t.Host}
+	if t.User != "" {
+		args = append(args, "--ssh-user="+t.User)
+	}
+	if t.Port > 0 {
+		args = append(args, "--ssh-port="+strconv.Itoa(t.Port))
+	}
+	return args
+}
+
+// fleetOnlyFlags belong to the fleet run itself, not to the runs of its
+// ships. The value tells whether the flag's value may be the next argument;
+// --events-json only takes one after "=". A ship's events reach the fleet's
+// --output json through the child's --events-json.
+var fleetOnlyFlags = map[string]bool{"--ships-from": true, "--max-parallel": true, "--stagger": true, "--on-error": true, "--retry-failed": false, "--events-json": false, "--output": true}
+
+// fleetChildArgs drops the fleet's own flags from a command line, leaving
+// what every ship's run shares.
+func fleetChildArgs(args []string) []string {
+	var out []string
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "--auto-update" {
+			continue
+		}
+		name, _, hasValue := strings.Cut(a, "=")
+		if separate, ok := fleetOnlyFlags[name]; ok {
+			if separate && !hasValue {
+				i++
+			}
+			continue
+		}
+		out = append(out, a)
+	}
+	return out
+}
+
+// fleetStatePath is where a fleet run leaves the ships it did not finish,
+// for --retry-failed.
+func fleetStatePath() (string, error) {
+	dir, err := config.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "fleet-failed"), nil
+}
+
+// saveFleetState records the unfinished ships, one per line in the
+// --ships-from format, or removes the record when there are none.
+func saveFleetState(path string, unfinished []string) error {
+	if len(unfinished) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(strings.Join(unfinished, "\n")+"\n"), 0o600)
+}
+
+// fleetPacer spaces out the starts of a fleet's ships by at least gap.
+type fleetPacer struct {
+	gap  time.Duration
+	next time.Time
+}
+
+func (p *fleetPacer) wait() {
+	if d := time.Until(p.next); d > 0 {
+		time.Sleep(d)
+	}
+	p.next = time.Now().Add(p.gap)
+}
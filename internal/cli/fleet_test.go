@@ -0,0 +1,127 @@
+package cli
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/alfaoz/beammeup/internal/events"
+	"github.com/alfaoz/beammeup/internal/hangar"
+	"github.com/alfaoz/beammeup/internal/ships"
+)
+
+func TestReadFleet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fleet")
+	list := "# from the inventory tool\nmyship\n\nadmin@192.0.2.10:2222\n198.51.100.7\n[2001:db8::1]:22\n2001:db8::2\n"
+	if err := os.WriteFile(path, []byte(list), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readFleet(path, []string{"myship", "other"})
+	if err != nil {
+		t.Fatalf("readFleet: %v", err)
+	}
+	want := []fleetTarget{
+		{Ship: "myship"},
+		{Host: "192.0.2.10", User: "admin", Port: 2222},
+		{Host: "198.51.100.7"},
+		{Host: "2001:db8::1", Port: 22},
+		{Host: "2001:db8::2"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("readFleet = %+v, want %+v", got, want)
+	}
+	if s := got[1].String(); s != "admin@192.0.2.10:2222" {
+		t.Fatalf("String() = %q", s)
+	}
+
+	for _, bad := range []string{"@192.0.2.10", "192.0.2.10:0", "host name", "192.0.2.10:ssh"} {
+		if _, err := parseHostSpec(bad); err == nil {
+			t.Fatalf("expected %q to be refused", bad)
+		}
+	}
+}
+
+func TestFleetChildArgs(t *testing.T) {
+	args := []string{"--profile", "work", "--ships-from", "-", "--max-parallel=4", "--stagger", "5s", "--events-json", "--show-inventory", "--events-json=3", "--output", "json", "--ssh-user", "admin"}
+	got := fleetChildArgs(args)
+	want := []string{"--profile", "work", "--show-inventory", "--ssh-user", "admin"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("fleetChildArgs = %q, want %q", got, want)
+	}
+	got = append(got, fleetTarget{Host: "2001:db8::1", User: "root", Port: 2222}.args()...)
+	if got[len(got)-3] != "--host=2001:db8::1" || got[len(got)-1] != "--ssh-port=2222" {
+		t.Fatalf("target args = %q", got)
+	}
+}
+
+func TestFleetState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "fleet-failed")
+	unfinished := []fleetTarget{{Ship: "myship"}, {Host: "2001:db8::1", User: "admin", Port: 2222}, {Host: "192.0.2.10"}}
+	var lines []string
+	for _, u := range unfinished {
+		lines = append(lines, u.String())
+	}
+	if err := saveFleetState(path, lines); err != nil {
+		t.Fatalf("saveFleetState: %v", err)
+	}
+	got, err := readFleet(path, []string{"myship"})
+	if err != nil || !reflect.DeepEqual(got, unfinished) {
+		t.Fatalf("readFleet = %+v, %v; want %+v", got, err, unfinished)
+	}
+	if err := saveFleetState(path, nil); err != nil {
+		t.Fatalf("saveFleetState(nil): %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("state left behind after a clean run: %v", err)
+	}
+}
+
+func TestFleetStatusJSON(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	store, err := ships.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := hangar.NewService()
+	svc.ScriptRunner = func(_ string, args []string) (string, error) {
+		if args[1] != "inventory" {
+			t.Fatalf("--action status ran %q", args)
+		}
+		return "BM_PUBLIC_IP=203.0.113.10\nBM_HANGAR_STATUS=online\nBM_SOCKS_EXISTS=1\nBM_SOCKS_ACTIVE=1\nBM_SOCKS_PORT=1080\n", nil
+	}
+	var out strings.Builder
+	r := &Runner{Store: store, Hangar: svc, Events: events.NewResults(&out)}
+
+	list := filepath.Join(t.TempDir(), "fleet")
+	if err := os.WriteFile(list, []byte("192.0.2.10\n192.0.2.11\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	opts := DefaultOptions()
+	opts.ShipsFrom, opts.Action, opts.Output, opts.SSHPassword, opts.Yes = list, "status", "json", "pw", true
+	if code, err := r.Run(opts); err != nil || code != ExitSuccess {
+		t.Fatalf("Run = %d, %v", code, err)
+	}
+
+	var got []events.Event
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var ev events.Event
+		if err := json.Unmarshal([]byte(line), &ev); err != nil {
+			t.Fatalf("line %q: %v", line, err)
+		}
+		got = append(got, ev)
+	}
+	if len(got) != 3 {
+		t.Fatalf("want a result per ship and a summary, got:\n%s", out.String())
+	}
+	for i, ship := range []string{"192.0.2.10", "192.0.2.11"} {
+		if ev := got[i]; ev.Type != events.TypeResult || ev.Ship != ship || ev.Data["action"] != "inventory" || ev.Data["socks5_port"] != "1080" {
+			t.Fatalf("ship %d: %+v", i, ev)
+		}
+	}
+	if sum := got[2]; sum.Ship != "" || sum.Data["ships"] != "2" || sum.Data["done"] != "192.0.2.10,192.0.2.11" {
+		t.Fatalf("summary: %+v", sum)
+	}
+}
@@ -0,0 +1,225 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alfaoz/beammeup/internal/hangar"
+	"github.com/alfaoz/beammeup/internal/ships"
+	"github.com/alfaoz/beammeup/internal/tunnel"
+	"github.com/alfaoz/beammeup/internal/usage"
+	"github.com/spf13/pflag"
+)
+
+// runForward runs port forwards over a ship's SSH connection until
+// interrupted: --local with --to like ssh -L, --remote with --local like
+// ssh -R, or, without either, the forwards saved in the ship profile.
+func (r *Runner) runForward(args []string) (int, error) {
+	opts := DefaultOptions()
+	fs := pflag.NewFlagSet("beammeup forward", pflag.ContinueOnError)
+	addTargetFlags(fs, &opts)
+	remote := fs.String("remote", "", "Port (or host:port) to listen on at the server, forwarded to --local; other than 127.0.0.1 needs GatewayPorts in sshd")
+	local := fs.String("local", "", "Port (or host:port) on this machine: listened on with --to, connected to with --remote")
+	to := fs.String("to", "", "With --local: host:port to connect to from the server")
+	save := fs.Bool("save", false, "Also save the forward in the ship profile, so beammeup forward --ship runs it")
+	remove := fs.Bool("remove", false, "Remove the forward from the ship profile instead of running it")
+	list := fs.Bool("list", false, "Show the forwards saved in the ship profile")
+	status := fs.Bool("status", false, "Show the running forwards (all when --ship is omitted)")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return ExitUsage, err
+	}
+	if *status {
+		return showForwards(ships.SanitizeName(opts.ShipName))
+	}
+	f, given, err := forwardFromFlags(*remote, *local, *to)
+	if err != nil {
+		return ExitUsage, err
+	}
+	if (*save || *remove) && !given {
+		return ExitUsage, errors.New("--save and --remove need a forward: --local with --to, or --remote with --local")
+	}
+
+	if *list || *save || *remove {
+		if opts.ShipName == "" {
+			return ExitUsage, errors.New("--list, --save and --remove need --ship <name>")
+		}
+		ship, err := r.Store.Load(ships.SanitizeName(opts.ShipName))
+		if err != nil {
+			return ExitFailure, err
+		}
+		switch {
+		case *list:
+			if len(ship.Forwards) == 0 {
+				fmt.Printf("[beammeup] no forwards saved for %s.\n", ship.Name)
+			}
+			for _, spec := range ship.Forwards {
+				if f, err := tunnel.ParseForward(spec); err == nil {
+					fmt.Printf("  %s\n", f)
+				} else {
+					fmt.Printf("  %s (invalid: %v)\n", spec, err)
+				}
+			}
+			return ExitSuccess, nil
+		case *remove:
+			kept := slices.DeleteFunc(slices.Clone(ship.Forwards), func(spec string) bool { return spec == f.Spec() })
+			if len(kept) == len(ship.Forwards) {
+				return ExitFailure, fmt.Errorf("%s is not saved for %s", f, ship.Name)
+			}
+			ship.Forwards = kept
+			if _, err := r.Store.Save(ship); err != nil {
+				return ExitFailure, err
+			}
+			fmt.Printf("[beammeup] removed %s from %s.\n", f, ship.Name)
+			return ExitSuccess, nil
+		case *save:
+			if slices.Contains(ship.Forwards, f.Spec()) {
+				fmt.Printf("[beammeup] %s is already saved for %s.\n", f, ship.Name)
+				break
+			}
+			ship.Forwards = append(ship.Forwards, f.Spec())
+			if _, err := r.Store.Save(ship); err != nil {
+				return ExitFailure, err
+			}
+			fmt.Printf("[beammeup] saved %s for %s.\n", f, ship.Name)
+		}
+	}
+
+	ship, password, code, err := r.prepareTarget(opts)
+	if err != nil {
+		return code, err
+	}
+	forwards := []tunnel.Forward{f}
+	if !given {
+		forwards = forwards[:0]
+		for _, spec := range ship.Forwards {
+			f, err := tunnel.ParseForward(spec)
+			if err != nil {
+				return ExitFailure, err
+			}
+			forwards = append(forwards, f)
+		}
+		if len(forwards) == 0 {
+			return ExitUsage, fmt.Errorf("no forwards saved for %s; give --local with --to, or --remote with --local (add --save to keep it)", ship.Name)
+		}
+	}
+	dir, err := forwardRunDir()
+	if err != nil {
+		return ExitFailure, err
+	}
+	target := hangar.ShipTarget(ship, password)
+
+	fmt.Printf("\n[beammeup] port forwards :: %s\n", ship.Name)
+	for _, f := range forwards {
+		fmt.Printf("  %s\n", f)
+	}
+	fmt.Printf("\nPress Ctrl+C to stop.\n\n")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	logf := func(format string, args ...any) {
+		fmt.Fprintf(os.Stderr, "[forward] "+format+"\n", args...)
+	}
+	controlPath := filepath.Join(dir, tunnelName(ship)+".sock")
+	if err := tunnel.RunForwards(ctx, target, r.Hangar.SSH, forwards, controlPath, logf); err != nil {
+		return ExitFailure, err
+	}
+	fmt.Println("\n[beammeup] port forwards closed.")
+	return ExitSuccess, nil
+}
+
+// forwardFromFlags builds the forward the flags describe; given is false
+// when they describe none.
+func forwardFromFlags(remote, local, to string) (f tunnel.Forward, given bool, err error) {
+	switch {
+	case remote != "" && to != "":
+		return f, false, errors.New("use --local with --to (ssh -L) or --remote with --local (ssh -R), not both")
+	case remote != "":
+		if local == "" {
+			return f, false, errors.New("--remote needs --local, the service on this machine")
+		}
+		f = tunnel.Forward{Reverse: true, Listen: remote, To: local}
+	case to != "":
+		if local == "" {
+			return f, false, errors.New("--to needs --local, the port to listen on here")
+		}
+		f = tunnel.Forward{Listen: local, To: to}
+	case local != "":
+		return f, false, errors.New("--local needs --to (ssh -L) or --remote (ssh -R)")
+	default:
+		return f, false, nil
+	}
+	if f.Listen, err = tunnel.ForwardAddr(f.Listen); err != nil {
+		return f, false, err
+	}
+	if f.To, err = tunnel.ForwardAddr(f.To); err != nil {
+		return f, false, err
+	}
+	return f, true, nil
+}
+
+// forwardRunDir holds the control sockets of running forwards, apart from
+// the stealth tunnels' so beammeup tunnel does not list them.
+func forwardRunDir() (string, error) {
+	dir, err := tunnelRunDir()
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, "forward")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("create run dir: %w", err)
+	}
+	return dir, nil
+}
+
+// showForwards prints the running forwards of name, or of every ship.
+func showForwards(name string) (int, error) {
+	dir, err := forwardRunDir()
+	if err != nil {
+		return ExitFailure, err
+	}
+	pattern := "*.sock"
+	if name != "" {
+		pattern = name + ".sock"
+	}
+	socks, err := filepath.Glob(filepath.Join(dir, pattern))
+	if err != nil {
+		return ExitFailure, err
+	}
+	sort.Strings(socks)
+	found := false
+	for _, path := range socks {
+		st, err := tunnel.Control(path, tunnel.ControlStatus)
+		if err != nil {
+			// Left behind by a forward that did not shut down cleanly.
+			os.Remove(path)
+			continue
+		}
+		found = true
+		printForwardStatus(strings.TrimSuffix(filepath.Base(path), ".sock"), st, time.Now())
+	}
+	if !found {
+		fmt.Println("[beammeup] no forwards running.")
+	}
+	return ExitSuccess, nil
+}
+
+func printForwardStatus(name string, st tunnel.Status, now time.Time) {
+	fmt.Printf("\n[beammeup] forwards :: %s (pid %d)\n", name, st.PID)
+	fmt.Printf("  Server: %s\n", st.Host)
+	fmt.Printf("  Up since: %s (%s)\n", st.Started.Local().Format("2006-01-02 15:04"), now.Sub(st.Started).Round(time.Second))
+	for _, f := range st.Forwards {
+		fmt.Printf("  %s\n", f)
+	}
+	fmt.Printf("  Traffic: %s up, %s down over %d connections (%d open)\n", usage.FormatBytes(uint64(st.BytesUp)), usage.FormatBytes(uint64(st.BytesDown)), st.Served, len(st.Conns))
+	if !st.KeptAlive.IsZero() {
+		fmt.Printf("  Smart blinder: kept alive by these forwards (%s ago)\n", now.Sub(st.KeptAlive).Round(time.Second))
+	}
+}
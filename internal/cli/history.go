@@ -0,0 +1,310 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/alfaoz/beammeup/internal/history"
+	"github.com/alfaoz/beammeup/internal/ships"
+	"github.com/spf13/pflag"
+)
+
+// historyMarks is how many of the latest checks the sparklines show.
+const historyMarks = 40
+
+// runHistory shows what beammeup recorded about a ship: uptime, status and
+// latency sparklines, applies and rotations, and insights such as flapping.
+// Without --ship it prints one line per saved ship.
+func (r *Runner) runHistory(args []string) (int, error) {
+	fs := pflag.NewFlagSet("beammeup history", pflag.ContinueOnError)
+	shipName := fs.String("ship", "", "Saved ship to show in detail (default: a line per ship)")
+	days := fs.Int("days", 7, "How many days back to look")
+	limit := fs.Int("limit", 20, "With --ship: how many of the latest entries to list")
+	slo := fs.Bool("slo", false, "Report uptime over 7 and 30 days and the mean time between drifts, worst ship first")
+	target := fs.Float64("target", 99.5, "With --slo: uptime target in percent")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return ExitUsage, err
+	}
+	if fs.Changed("target") && !*slo {
+		return ExitUsage, errors.New("--target only applies to --slo")
+	}
+	if *slo && (fs.Changed("days") || fs.Changed("limit")) {
+		return ExitUsage, errors.New("--slo always covers 7 and 30 days; drop --days and --limit")
+	}
+	if *target <= 0 || *target > 100 {
+		return ExitUsage, errors.New("--target must be above 0 and at most 100")
+	}
+	if *days <= 0 {
+		return ExitUsage, errors.New("--days must be at least 1")
+	}
+	if *limit < 0 {
+		return ExitUsage, errors.New("--limit cannot be negative")
+	}
+	if r.History == nil {
+		return ExitFailure, errors.New("status history store is not available")
+	}
+	now := time.Now()
+	from := now.AddDate(0, 0, -*days)
+
+	if *slo {
+		return r.printSLOReport(*shipName, *target, now)
+	}
+	if *shipName != "" {
+		name := ships.SanitizeName(*shipName)
+		if _, err := r.Store.Load(name); err != nil {
+			return ExitFailure, err
+		}
+		entries, err := r.History.Load(name, from.Add(-history.MaxHold))
+		if err != nil {
+			return ExitFailure, err
+		}
+		printShipHistory(name, entries, from, now, *limit)
+		return ExitSuccess, nil
+	}
+
+	names, err := r.Store.List()
+	if err != nil {
+		return ExitFailure, err
+	}
+	if len(names) == 0 {
+		fmt.Printf("No ships saved yet in %s\n", r.Store.Dir)
+		return ExitSuccess, nil
+	}
+	fmt.Printf("[beammeup] history :: last %d days\n\n", *days)
+	fmt.Printf("%-20s %8s %7s  %s\n", "SHIP", "UPTIME", "CHECKS", "STATUS")
+	for _, name := range names {
+		entries, err := r.History.Load(name, from.Add(-history.MaxHold))
+		if err != nil {
+			return ExitFailure, err
+		}
+		a := history.Measure(entries, from, now)
+		uptime := "-"
+		if pct, ok := a.Percent(); ok {
+			uptime = fmt.Sprintf("%.1f%%", pct)
+		}
+		fmt.Printf("%-20s %8s %7d  %s\n", name, uptime, a.Checks, statusMarks(entries, from))
+		if note := historyInsight(entries, now); note != "" {
+			fmt.Printf("  %s\n", note)
+		}
+	}
+	return ExitSuccess, nil
+}
+
+func printShipHistory(name string, entries []history.Entry, from, now time.Time, limit int) {
+	fmt.Printf("[beammeup] history :: %s (since %s)\n", name, from.Local().Format("2006-01-02 15:04"))
+	a := history.Measure(entries, from, now)
+	if pct, ok := a.Percent(); ok {
+		fmt.Printf("  Uptime: %.2f%% over %d checks (down %s)\n", pct, a.Checks, a.Down.Round(time.Minute))
+	} else {
+		fmt.Printf("  Uptime: not enough checks yet (run beammeup --ship %s --show-inventory, or open it in the cockpit)\n", name)
+	}
+	if marks := statusMarks(entries, from); marks != "" {
+		fmt.Printf("  Status:  %s  (█ online, ▁ down, · planned)\n", marks)
+	}
+	var latencies []float64
+	var lo, hi time.Duration
+	for _, e := range history.Checks(entries) {
+		if e.Time.Before(from) || e.LatencyMS <= 0 {
+			continue
+		}
+		l := e.Latency()
+		if len(latencies) == 0 || l < lo {
+			lo = l
+		}
+		hi = max(hi, l)
+		latencies = append(latencies, float64(l))
+	}
+	if len(latencies) > 0 {
+		fmt.Printf("  Check time: %s  (%s to %s)\n", history.Sparkline(lastN(latencies, historyMarks)), lo.Round(10*time.Millisecond), hi.Round(10*time.Millisecond))
+	}
+	counts := map[string]int{}
+	for _, e := range entries {
+		if !e.Time.Before(from) {
+			counts[e.Kind]++
+		}
+	}
+	fmt.Printf("  Applies: %d, rotations: %d, destroys: %d\n", counts[history.KindApply], counts[history.KindRotate], counts[history.KindDestroy])
+	if note := historyInsight(entries, now); note != "" {
+		fmt.Printf("  Insight: %s\n", note)
+	}
+
+	var recent []history.Entry
+	for _, e := range entries {
+		if !e.Time.Before(from) {
+			recent = append(recent, e)
+		}
+	}
+	recent = lastN(recent, limit)
+	if len(recent) == 0 {
+		return
+	}
+	fmt.Println()
+	for _, e := range slices.Backward(recent) {
+		outcome := e.Status
+		switch {
+		case e.Kind == history.KindCheck || e.Kind == history.KindHostKey:
+		case e.Error != "":
+			outcome = "failed"
+		default:
+			outcome = "ok"
+		}
+		line := fmt.Sprintf("  %s  %-8s %-18s", e.Time.Local().Format("2006-01-02 15:04"), e.Kind, outcome)
+		switch {
+		case e.Fingerprint != "":
+			line += " " + e.Fingerprint
+		case e.Error != "":
+			line += " " + e.Error
+		case e.LatencyMS > 0:
+			line += " " + e.Latency().Round(10*time.Millisecond).String()
+		}
+		fmt.Println(line)
+	}
+}
+
+// sloRow is one ship in the uptime report.
+type sloRow struct {
+	ship        string
+	week, month float64
+	weekOK      bool
+	monthOK     bool
+	drifts      int
+	mtbd        time.Duration
+	mtbdOK      bool
+}
+
+// printSLOReport prints each ship's uptime over 7 and 30 days against
+// target, and how long it stays up between drifts, worst ship first: the
+// ones to move to another provider.
+func (r *Runner) printSLOReport(shipName string, target float64, now time.Time) (int, error) {
+	var names []string
+	if shipName != "" {
+		name := ships.SanitizeName(shipName)
+		if _, err := r.Store.Load(name); err != nil {
+			return ExitFailure, err
+		}
+		names = []string{name}
+	} else {
+		var err error
+		if names, err = r.Store.List(); err != nil {
+			return ExitFailure, err
+		}
+	}
+	if len(names) == 0 {
+		fmt.Printf("No ships saved yet in %s\n", r.Store.Dir)
+		return ExitSuccess, nil
+	}
+	week, month := now.AddDate(0, 0, -7), now.AddDate(0, 0, -30)
+	rows := make([]sloRow, 0, len(names))
+	for _, name := range names {
+		entries, err := r.History.Load(name, month.Add(-history.MaxHold))
+		if err != nil {
+			return ExitFailure, err
+		}
+		row := sloRow{ship: name, drifts: len(history.Drifts(entries, month, now))}
+		row.week, row.weekOK = history.Measure(entries, week, now).Percent()
+		row.month, row.monthOK = history.Measure(entries, month, now).Percent()
+		row.mtbd, row.mtbdOK = history.MeanTimeBetweenDrifts(entries, month, now)
+		rows = append(rows, row)
+	}
+	// Worst first; ships without data last.
+	slices.SortStableFunc(rows, func(a, b sloRow) int {
+		switch {
+		case a.monthOK != b.monthOK:
+			if a.monthOK {
+				return -1
+			}
+			return 1
+		case a.month < b.month:
+			return -1
+		case a.month > b.month:
+			return 1
+		}
+		return 0
+	})
+
+	pct := func(v float64, ok bool) string {
+		if !ok {
+			return "-"
+		}
+		return fmt.Sprintf("%.2f%%", v)
+	}
+	fmt.Printf("[beammeup] uptime report :: target %s\n\n", pct(target, true))
+	fmt.Printf("%-20s %9s %9s %7s  %-26s %s\n", "SHIP", "7 DAYS", "30 DAYS", "DRIFTS", "MEAN TIME BETWEEN DRIFTS", "TARGET")
+	missed := 0
+	for _, row := range rows {
+		mtbd := "no drifts"
+		if row.mtbdOK {
+			mtbd = roughDuration(row.mtbd)
+		}
+		verdict := "-"
+		if row.monthOK {
+			verdict = "met"
+			if row.month < target || (row.weekOK && row.week < target) {
+				verdict = "missed"
+				missed++
+			}
+		}
+		if !row.monthOK {
+			mtbd = "-"
+		}
+		fmt.Printf("%-20s %9s %9s %7d  %-26s %s\n", row.ship, pct(row.week, row.weekOK), pct(row.month, row.monthOK), row.drifts, mtbd, verdict)
+	}
+	if missed > 0 {
+		fmt.Printf("\n%d of %d ships missed the %s target.\n", missed, len(rows), pct(target, true))
+	}
+	fmt.Println("\nUptime counts checked time only; run checks regularly (e.g. --show-inventory from cron) for a fair picture.")
+	return ExitSuccess, nil
+}
+
+// statusMarks draws the latest checks since from, one mark each.
+func statusMarks(entries []history.Entry, from time.Time) string {
+	var marks []rune
+	for _, e := range history.Checks(entries) {
+		switch {
+		case e.Time.Before(from):
+		case e.Up():
+			marks = append(marks, '█')
+		case e.Planned():
+			marks = append(marks, '·')
+		default:
+			marks = append(marks, '▁')
+		}
+	}
+	return string(lastN(marks, historyMarks))
+}
+
+// historyInsight is the one-line takeaway from a ship's history, if any.
+func historyInsight(entries []history.Entry, now time.Time) string {
+	if flap, ok := history.Flapping(entries, now); ok {
+		return fmt.Sprintf("flapping for %s (%d status changes)", roughDuration(now.Sub(flap.Since)), flap.Changes)
+	}
+	checks := history.Checks(entries)
+	if len(checks) > 0 {
+		if last := checks[len(checks)-1]; !last.Up() && !last.Planned() {
+			return fmt.Sprintf("%s at the last check, %s ago", last.Status, roughDuration(now.Sub(last.Time)))
+		}
+	}
+	return ""
+}
+
+// roughDuration renders d the way people say it: "3 days", "5 hours".
+func roughDuration(d time.Duration) string {
+	switch {
+	case d >= 48*time.Hour:
+		return fmt.Sprintf("%d days", int(d/(24*time.Hour)))
+	case d >= 2*time.Hour:
+		return fmt.Sprintf("%d hours", int(d/time.Hour))
+	case d >= 2*time.Minute:
+		return fmt.Sprintf("%d minutes", int(d/time.Minute))
+	}
+	return d.Round(time.Second).String()
+}
+
+func lastN[T any](s []T, n int) []T {
+	if len(s) > n {
+		return s[len(s)-n:]
+	}
+	return s
+}
@@ -2,18 +2,34 @@ package cli
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/alfaoz/beammeup/internal/config"
+	"github.com/alfaoz/beammeup/internal/creds"
+	"github.com/alfaoz/beammeup/internal/events"
+	"github.com/alfaoz/beammeup/internal/geoip"
 	"github.com/alfaoz/beammeup/internal/hangar"
+	"github.com/alfaoz/beammeup/internal/history"
+	"github.com/alfaoz/beammeup/internal/hostkey"
+	"github.com/alfaoz/beammeup/internal/notify"
+	"github.com/alfaoz/beammeup/internal/remote"
 	"github.com/alfaoz/beammeup/internal/ships"
 	"github.com/alfaoz/beammeup/internal/sshx"
 	"github.com/alfaoz/beammeup/internal/tunnel"
+	"github.com/alfaoz/beammeup/internal/usage"
+	"golang.org/x/crypto/ssh"
 	"golang.org/x/term"
 )
 
@@ -24,8 +40,14 @@ const (
 )
 
 type Runner struct {
-	Store  *ships.Store
-	Hangar *hangar.Service
+	Store    *ships.Store
+	Hangar   *hangar.Service
+	Usage    *usage.Store
+	History  *history.Store
+	Creds    *creds.Store
+	Notifier notify.Notifier
+	Events   *events.Emitter // --events-json; nil when off
+	Config   config.Config   // client config: API tokens for --cloud-firewall, the leak check
 }
 
 func PrintHelp() {
@@ -33,41 +55,125 @@ func PrintHelp() {
 
 Usage:
   beammeup [options]
+  beammeup <command> [options]
 
+Commands:
+` + commandsHelp() + `
 Options:
+  --profile <name>              Use a separate local profile (ships, known_hosts, config) under ~/.beammeup/profiles
   --host <ip-or-hostname>       Server host or IP
   --ship <name>                 Use saved ship profile from ~/.beammeup/ships
+  --last                        Use the ship used most recently; alone, opens its cockpit
+  --ships-from <file|->         Run against each ship name or [user@]host[:port] listed, one per line (- reads stdin)
+  --max-parallel <n>            With --ships-from, run n ships at once (default 1)
+  --stagger <duration>          With --ships-from, wait at least this long between starting two ships (e.g. 5s)
+  --on-error <policy>           With --ships-from, once a ship fails: continue (default), stop, or rollback the ships configured
+  --retry-failed                Run against the ships the last --ships-from run did not finish
   --list-ships                  List saved ship profiles and exit
   --ssh-port <port>             SSH port (default: 22)
   --ssh-user <username>         SSH user (default: root)
   --ssh-password <password>     SSH password
+  --ssh-otp-command <command>   Command printing the one-time code for 2FA SSH logins
   --ssh-known-hosts <path>      SSH known_hosts file (default: ~/.beammeup/known_hosts)
+  --ssh-retries <n>             Extra tries when the SSH connection drops or is refused on the way (default 2)
+  --ssh-retry-delay <duration>  Wait before the first SSH retry, doubled after each (default 2s)
+  --ssh-keepalive <duration>    Check this often that the server still answers, keeping NAT state alive (default 30s, 0 disables)
+  --ssh-ciphers <list>          SSH ciphers to offer, OpenSSH style: a,b replaces, +a adds, -a removes, ^a goes first; or modern
+  --ssh-kex <list>              SSH key exchanges to offer, same syntax (e.g. +diffie-hellman-group1-sha1 for old Dropbear)
+  --ssh-macs <list>             SSH MACs to offer, same syntax
+  --ssh-hostkey-algorithms <list>  SSH host key algorithms to accept, same syntax
+  --ssh-proxy <url>             Reach SSH through socks5://[user:pass@]host:port or an http:// CONNECT proxy ("none" goes direct)
   --strict-host-key             Require known SSH host key (no TOFU)
   --insecure-ignore-host-key    Disable SSH host key verification (UNSAFE)
+  --hash-known-hosts            Record new SSH host keys under hashed host names
+  --remote-tmpdir <path>        Temp directory on the server (default: /tmp)
   --protocol <http|socks5>      Target protocol for show/configure actions
   --http-mode <auto|sidecar>    HTTP behavior when protocol is http
+  --http-conflict <choice>      Squid config beammeup did not write: adopt, replace, sidecar or abort (asks on a terminal)
   --proxy-port <port>           Proxy port for configure/preflight
-  --action <show|configure|rotate|destroy>
+  --action <show|status|configure|rotate|destroy|os-update|reboot|rollback>
+  --to <snapshot|latest>        With rollback: the snapshot to restore (without --to, lists them)
+  --rotate-scope <scope>        With rotate: password keeps the username, both (default) replaces it too
+  --rotate-grace <duration>     With rotate on HTTP: old credentials keep working this long, e.g. 24h
+  --refresh-clients             With rotate: rewrite local git/npm/pip and system proxy settings that use the old credentials
+  --security-only               With os-update: install security updates only
+  --reboot-timeout <duration>   With reboot: how long to wait for SSH to come back (default 5m)
+  --phase-timeout <duration>    With configure/rotate: limit per server step before rolling back (default 10m)
+  --no-verify                   With configure/rotate: skip the test requests through the new proxy
+  --events-json[=<fd>]          Stream JSON progress events to stderr, or to file descriptor <fd> (e.g. --events-json=3)
+  --output <text|json>          json: only the result events, one per ship and one per --ships-from run, on stdout
   --show-inventory              List detected beammeup setups and exit
+  --read-only                   Refuse anything that changes the server; alone, shows the inventory
   --preflight-only              Run checks only, make no remote changes
   --stealth                     Stealth mode: local SOCKS5 via SSH tunnel, zero remote footprint
+  --background                  With --stealth: detach the tunnel; see beammeup tunnel status|stop|reload
+  --allow-bind                  With --stealth: accept SOCKS5 BIND (server-side listener, e.g. active FTP)
+  --rules <file>                With --stealth: route destinations through the tunnel, direct, or block them
+  --conn-log <file>             With --stealth: log each connection (time, destination, bytes, duration) as JSON lines
+  --idle-timeout <dur>          With --stealth: close connections idle this long (default 15m)
+  --tunnel-max-conns <n>        With --stealth: connections the local tunnel serves at once (default 256)
+  --tunnel-rate <rate>          With --stealth: cap the tunnel's bandwidth per direction, e.g. 2M (bytes/s)
+  --tunnel-conn-rate <rate>     With --stealth: cap each connection's bandwidth per direction, e.g. 500k
   --no-firewall-change          Do not add firewall rules on the server
   --listen-local                Bind proxy to localhost on the server (requires SSH tunnel)
   --smart-blinder               Smart blinder (default: true). Disable with --smart-blinder=false
   --smart-blinder-idle-minutes  Smart blinder idle minutes (default: 10)
+  --traffic-cap-gb <gb>         Pause the proxy on the server after <gb> per month (0 removes the cap)
+  --quiet-hours <windows>       Stop the proxy during UTC windows, e.g. 00:00-06:00,12:00-13:00 ("off" clears)
+  --max-conns <n>               Limit the proxy credential to <n> concurrent connections (0 removes the limit)
+  --memory-max <size>           Cap the proxy unit's memory on the server, e.g. 256M (0 removes the cap)
+  --cpu-quota <percent>         Cap the proxy unit's CPU, e.g. 50% of one core (0 removes the cap)
+  --tasks-max <n>               Cap the proxy unit's processes and threads (0 removes the cap)
+  --rate-limit <n>              Limit the proxy credential to <n> new connections per minute (0 removes the limit)
+  --egress-ip <ip>              Send proxy traffic out from <ip> on a multi-IP server ("default" clears)
+  --socket-activation           SOCKS5: start microsocks on demand via a systemd socket (=false for always on)
+  --microsocks-binary <path>    SOCKS5: static microsocks to upload if the server has no package
+  --instance <name>             Work on a named HTTP sidecar instance, one of several on the server ("default" clears)
+  --cache-mb <n>                HTTP: cache repeated downloads in an <n> MB disk cache on the server (0 disables)
+  --cache-dir <path>            HTTP: where the server keeps that cache
+  --anonymity <preset>          HTTP: transparent (forward client IP), anonymous, or elite (default)
+  --allow-domains <list|file>   HTTP: only reach these destinations, e.g. "*.example.com,api.example.org" ("none" clears)
+  --deny-domains <list|file>    HTTP: refuse these destinations ("none" clears)
+  --cloud-firewall <provider>   Open the proxy port in the hetzner or digitalocean cloud firewall on apply, close it on destroy ("off" stops)
+  --ttl <duration>              Destroy the hangar on the server after <duration>, e.g. 4h (0 cancels)
+  --label <text>                Name the hangar in the server's hangar.json ("none" clears)
+  --description <text>          Describe the hangar in hangar.json, e.g. who to contact ("none" clears)
   --self-update                 Update local beammeup binary and exit
   --auto-update                 Update local beammeup before running requested action
   --base-url <https-url>        Override release base URL
   --version                     Print beammeup version and exit
-  --yes                         Skip confirmation prompts
+  --yes                         Skip routine confirmation prompts (also trusts a new server's SSH host key)
+  --force                       Also skip the typed DESTROY confirmation (required for unattended destroy)
+  --break-lock                  Take over a stuck lock left on the server by another run
   -h, --help                    Show this help
 
 Environment:
   BEAMMEUP_AUTO_UPDATE=1        Auto-run self-update on startup
+  BEAMMEUP_PROFILE              Local profile to use, like --profile ("default" is ~/.beammeup)
+  BEAMMEUP_READ_ONLY=1          Read-only mode for every command, like --read-only
+  BEAMMEUP_CLIENT_NAME          Name recorded in hangar.json for your changes (default: user@host; "none" records nothing)
   BEAMMEUP_SHIPS_DIR            Override ship profile directory
+  BEAMMEUP_USAGE_DIR            Override traffic history directory
+  BEAMMEUP_HISTORY_DIR          Override status history directory (checks, applies, rotations)
+  BEAMMEUP_CONFIG               Override client config file (default: ~/.beammeup/config)
+  BEAMMEUP_TELEMETRY=1          Opt in to the anonymous update-check ping (TELEMETRY=1 in the config)
+  BEAMMEUP_LEAK_CHECK=1         Look new proxy passwords up in Have I Been Pwned (LEAK_CHECK=1 in the config)
+  BEAMMEUP_GEOIP_DB             MMDB files locating the exit IP, comma-separated (GEOIP_DB in the config)
+  BEAMMEUP_GEOIP_URL            echoip-style API for GeoIP lookups (GEOIP_URL in the config)
+  BEAMMEUP_WEBHOOK_URL          Webhook notified on traffic quota warnings
+  BEAMMEUP_SMTP_PASS            SMTP password for email alerts (SMTP_PASS in the config)
+  BEAMMEUP_HETZNER_TOKEN        Hetzner Cloud API token for --cloud-firewall (HETZNER_TOKEN in the config)
+  BEAMMEUP_DIGITALOCEAN_TOKEN   DigitalOcean API token for --cloud-firewall (DIGITALOCEAN_TOKEN in the config)
   BEAMMEUP_SSH_KNOWN_HOSTS       Override SSH known_hosts file
+  BEAMMEUP_SSH_CONFIG            OpenSSH config for host aliases (default: ~/.ssh/config, "none" ignores it)
+  BEAMMEUP_SSH_OTP_COMMAND       Same as --ssh-otp-command
+  BEAMMEUP_SSH_RETRIES           Default for --ssh-retries
+  BEAMMEUP_SSH_RETRY_DELAY       Default for --ssh-retry-delay
+  BEAMMEUP_SSH_KEEPALIVE         Default for --ssh-keepalive
+  BEAMMEUP_SSH_PROXY             Default for --ssh-proxy
   BEAMMEUP_STRICT_HOST_KEY=1     Require known SSH host key (no TOFU)
   BEAMMEUP_INSECURE_IGNORE_HOST_KEY=1  Disable SSH host key verification (UNSAFE)
+  BEAMMEUP_HASH_KNOWN_HOSTS=1    Same as --hash-known-hosts
 `)
 }
 
@@ -75,12 +181,58 @@ func RequiresNonInteractive(opts Options, isTTY bool) bool {
 	if !isTTY {
 		return true
 	}
-	return opts.Host != "" || opts.ShipName != "" || opts.Action != "" || opts.ShowInventory || opts.PreflightOnly ||
-		opts.NoFirewallChange || opts.ListenLocalSet || opts.SmartBlinderSet || opts.SmartBlinderIdleMinSet ||
-		opts.Protocol != "" || opts.HTTPMode != "" || opts.ProxyPort > 0 || opts.Yes
+	return opts.Host != "" || opts.ShipName != "" || opts.ShipsFrom != "" || opts.RetryFailed || opts.Action != "" || opts.ShowInventory || opts.PreflightOnly ||
+		opts.NoFirewallChange || opts.ListenLocalSet || opts.SmartBlinderSet || opts.SmartBlinderIdleMinSet || opts.TrafficCapSet || opts.QuietHoursSet || opts.LimitsSet || opts.ResourcesSet || opts.EgressIPSet || opts.SocketActivationSet || opts.MicrosocksBinary != "" || opts.InstanceSet || opts.CacheSet || opts.AnonymitySet || opts.AllowDomainsSet || opts.DenyDomainsSet || opts.CloudFirewallSet || opts.TTLSet || opts.LabelSet || opts.DescriptionSet ||
+		opts.Protocol != "" || opts.HTTPMode != "" || opts.HTTPConflict != "" || opts.ProxyPort > 0 || opts.Yes || opts.Force || opts.BreakLock || opts.SecurityOnly || opts.RebootTimeout > 0 || opts.PhaseTimeout > 0 || opts.NoVerify || opts.EventsJSON != "" || opts.Output == "json"
 }
 
+// Run performs a non-interactive run. With --events-json it also writes the
+// event stream, ending in a result or an error event; --output json keeps
+// just the results of that stream, on stdout.
 func (r *Runner) Run(opts Options) (int, error) {
+	r.Hangar.SSH = r.sshOptions(opts)
+	switch opts.Output {
+	case "", "text":
+	case "json":
+		if opts.EventsJSON != "" {
+			return ExitUsage, errors.New("--output json already writes the results; drop --events-json")
+		}
+		if r.Events == nil {
+			r.Events = events.NewResults(os.Stdout)
+			// Keep stdout to the JSON lines; the human-readable output
+			// goes to stderr instead.
+			os.Stdout = os.Stderr
+		}
+	default:
+		return ExitUsage, errors.New("invalid --output. use text or json")
+	}
+	if opts.EventsJSON != "" && r.Events == nil {
+		em, err := events.Open(opts.EventsJSON)
+		if err != nil {
+			return ExitUsage, err
+		}
+		r.Events = em
+		r.Hangar.Progress = em.LogWriter()
+	}
+	var code int
+	var err error
+	switch {
+	case opts.ShipsFrom != "" || opts.RetryFailed:
+		code, err = r.runFleet(opts)
+	case opts.MaxParallel != 1 || opts.Stagger != 0 || opts.OnError != "continue":
+		code, err = ExitUsage, errors.New("--max-parallel, --stagger and --on-error only apply to --ships-from")
+	default:
+		code, err = r.run(opts)
+	}
+	if err != nil {
+		r.Events.Error(err)
+	} else if code != ExitSuccess {
+		r.Events.Error(fmt.Errorf("exit status %d", code))
+	}
+	return code, err
+}
+
+func (r *Runner) run(opts Options) (int, error) {
 	if opts.ListShips {
 		return r.listShips()
 	}
@@ -93,112 +245,218 @@ func (r *Runner) Run(opts Options) (int, error) {
 	if !ok {
 		return ExitUsage, errors.New("invalid --http-mode. use auto or sidecar")
 	}
+	switch opts.HTTPConflict {
+	case "", "adopt", "replace", "sidecar", "abort":
+	default:
+		return ExitUsage, errors.New("invalid --http-conflict. use adopt, replace, sidecar or abort")
+	}
 	action, ok := NormalizeAction(strings.ToLower(strings.TrimSpace(opts.Action)))
 	if !ok {
-		return ExitUsage, errors.New("invalid --action. use show, configure, rotate, or destroy")
+		return ExitUsage, errors.New("invalid --action. use show, status, configure, rotate, destroy, os-update, or reboot")
+	}
+	if action == "status" {
+		// status is the inventory, under the name scripts reach for.
+		action, opts.ShowInventory = "", true
 	}
 
+	if opts.Background && !opts.Stealth {
+		return ExitUsage, errors.New("--background only applies to --stealth")
+	}
+	if opts.StealthAllowBind && !opts.Stealth {
+		return ExitUsage, errors.New("--allow-bind only applies to --stealth")
+	}
+	if (opts.TunnelRate != "" || opts.TunnelConnRate != "") && !opts.Stealth {
+		return ExitUsage, errors.New("--tunnel-rate and --tunnel-conn-rate only apply to --stealth")
+	}
+	if opts.TunnelMaxConns < 0 {
+		return ExitUsage, errors.New("--tunnel-max-conns cannot be negative")
+	}
+	if opts.TunnelMaxConns != 0 && !opts.Stealth {
+		return ExitUsage, errors.New("--tunnel-max-conns only applies to --stealth")
+	}
+	if opts.IdleTimeout < 0 {
+		return ExitUsage, errors.New("--idle-timeout cannot be negative")
+	}
+	if opts.IdleTimeout != 0 && !opts.Stealth {
+		return ExitUsage, errors.New("--idle-timeout only applies to --stealth")
+	}
+	if opts.ConnLog != "" && !opts.Stealth {
+		return ExitUsage, errors.New("--conn-log only applies to --stealth")
+	}
+	if opts.RulesFile != "" && !opts.Stealth {
+		return ExitUsage, errors.New("--rules only applies to --stealth (and beammeup adapter)")
+	}
 	if opts.PreflightOnly && action != "" {
 		return ExitUsage, errors.New("use either --preflight-only or --action, not both")
 	}
-
-	var ship ships.Ship
-	loadedFromStore := false
-	if opts.ShipName != "" {
-		loaded, err := r.Store.Load(opts.ShipName)
-		if err != nil {
-			return ExitFailure, err
+	if opts.ReadOnly {
+		if action != "" && action != "show" {
+			return ExitUsage, fmt.Errorf("--read-only refuses --action %s; only show, --preflight-only and --show-inventory leave the server unchanged", action)
+		}
+		if opts.TTLSet {
+			return ExitUsage, errors.New("--read-only refuses --ttl")
+		}
+		if action == "" && !opts.PreflightOnly && !opts.Stealth {
+			opts.ShowInventory = true
 		}
-		ship = loaded
-		loadedFromStore = true
 	}
-
-	if opts.Host != "" {
-		ship.Host = opts.Host
+	if action == "destroy" && !opts.Force && !stdinIsTerminal() {
+		return ExitUsage, errDestroyNeedsForce
 	}
-	if opts.SSHPort > 0 {
-		ship.SSHPort = opts.SSHPort
+	if (opts.RotateScope != "" || opts.RotateGrace > 0 || opts.RefreshClients) && action != "rotate" {
+		return ExitUsage, errors.New("--rotate-scope, --rotate-grace and --refresh-clients only apply to --action rotate")
 	}
-	if opts.SSHUser != "" {
-		ship.SSHUser = opts.SSHUser
+	if opts.TTLSet && (action == "show" || action == "destroy" || opts.PreflightOnly || opts.ShowInventory || opts.Stealth) {
+		return ExitUsage, errors.New("--ttl only applies to configure and rotate")
 	}
-	if protocol != "" {
-		ship.Protocol = protocol
+	if opts.QuietHoursSet && (action == "show" || action == "destroy" || opts.PreflightOnly || opts.ShowInventory || opts.Stealth) {
+		return ExitUsage, errors.New("--quiet-hours only applies to configure and rotate")
 	}
-	if httpMode != "" || strings.EqualFold(strings.TrimSpace(opts.HTTPMode), "auto") {
-		ship.HTTPMode = httpMode
+	if (opts.LabelSet || opts.DescriptionSet) && (action == "show" || action == "destroy" || opts.PreflightOnly || opts.ShowInventory || opts.Stealth) {
+		return ExitUsage, errors.New("--label and --description only apply to configure and rotate")
 	}
-	if opts.ProxyPort > 0 {
-		ship.ProxyPort = opts.ProxyPort
+	if opts.LimitsSet && (action == "show" || action == "destroy" || opts.PreflightOnly || opts.ShowInventory || opts.Stealth) {
+		return ExitUsage, errors.New("--max-conns and --rate-limit only apply to configure and rotate")
 	}
-	if opts.NoFirewallChange {
-		ship.NoFirewallChange = true
+	if opts.ResourcesSet && (action == "show" || action == "destroy" || opts.PreflightOnly || opts.ShowInventory || opts.Stealth) {
+		return ExitUsage, errors.New("--memory-max, --cpu-quota and --tasks-max only apply to configure and rotate")
 	}
-
-	if loadedFromStore {
-		if opts.ListenLocalSet {
-			ship.ListenLocal = opts.ListenLocal
-		}
-		if opts.SmartBlinderSet {
-			ship.SmartBlinder = opts.SmartBlinder
-		}
-		if opts.SmartBlinderIdleMinSet {
-			ship.SmartBlinderIdleMinutes = opts.SmartBlinderIdleMinutes
+	if opts.EgressIPSet && (action == "show" || action == "destroy" || opts.ShowInventory || opts.Stealth) {
+		return ExitUsage, errors.New("--egress-ip only applies to configure, rotate and --preflight-only")
+	}
+	if opts.CacheSet && (action == "show" || action == "destroy" || opts.PreflightOnly || opts.ShowInventory || opts.Stealth) {
+		return ExitUsage, errors.New("--cache-mb and --cache-dir only apply to configure and rotate")
+	}
+	if opts.AnonymitySet && (action == "show" || action == "destroy" || opts.PreflightOnly || opts.ShowInventory || opts.Stealth) {
+		return ExitUsage, errors.New("--anonymity only applies to configure and rotate")
+	}
+	if opts.SocketActivationSet && (action == "show" || action == "destroy" || opts.PreflightOnly || opts.ShowInventory || opts.Stealth) {
+		return ExitUsage, errors.New("--socket-activation only applies to configure and rotate")
+	}
+	if opts.MicrosocksBinary != "" && (action == "show" || action == "destroy" || opts.PreflightOnly || opts.ShowInventory || opts.Stealth) {
+		return ExitUsage, errors.New("--microsocks-binary only applies to configure and rotate")
+	}
+	domainsSet := opts.AllowDomainsSet || opts.DenyDomainsSet
+	if domainsSet && (action == "show" || action == "destroy" || opts.PreflightOnly || opts.ShowInventory || opts.Stealth) {
+		return ExitUsage, errors.New("--allow-domains and --deny-domains only apply to configure and rotate")
+	}
+	if opts.CloudFirewallSet && (action == "show" || action == "destroy" || opts.PreflightOnly || opts.ShowInventory || opts.Stealth) {
+		return ExitUsage, errors.New("--cloud-firewall only applies to configure and rotate")
+	}
+	if opts.SecurityOnly && action != "os-update" {
+		return ExitUsage, errors.New("--security-only only applies to --action os-update")
+	}
+	if opts.RebootTimeout > 0 && action != "reboot" {
+		return ExitUsage, errors.New("--reboot-timeout only applies to --action reboot")
+	}
+	if opts.RollbackTo != "" && action != "rollback" {
+		return ExitUsage, errors.New("--to only applies to --action rollback")
+	}
+	if opts.PhaseTimeout > 0 && (action == "show" || action == "destroy" || action == "os-update" || action == "reboot" || action == "rollback" || opts.PreflightOnly || opts.ShowInventory || opts.Stealth) {
+		return ExitUsage, errors.New("--phase-timeout only applies to configure and rotate")
+	}
+	if opts.NoVerify && (action == "show" || action == "destroy" || action == "os-update" || action == "reboot" || action == "rollback" || opts.PreflightOnly || opts.ShowInventory || opts.Stealth) {
+		return ExitUsage, errors.New("--no-verify only applies to configure and rotate")
+	}
+	if (action == "os-update" || action == "reboot" || action == "rollback") && (opts.Stealth || opts.TTLSet || opts.QuietHoursSet || opts.LimitsSet || opts.ResourcesSet || opts.EgressIPSet || opts.SocketActivationSet || opts.MicrosocksBinary != "" || opts.CacheSet || opts.AnonymitySet || domainsSet || opts.CloudFirewallSet || opts.LabelSet || opts.DescriptionSet) {
+		if action == "rollback" {
+			return ExitUsage, errors.New("--action rollback restores a snapshot as it was saved; drop --stealth and the hangar settings")
 		}
-	} else {
-		ship.ListenLocal = opts.ListenLocal
-		ship.SmartBlinder = opts.SmartBlinder
-		ship.SmartBlinderIdleMinutes = opts.SmartBlinderIdleMinutes
+		return ExitUsage, fmt.Errorf("--action %s leaves the hangar alone; drop --stealth and the hangar settings", action)
+	}
+	if action == "reboot" && !opts.Yes && !stdinIsTerminal() {
+		return ExitUsage, errors.New("reboot needs --yes when not run from a terminal")
+	}
+	if action == "rollback" && opts.RollbackTo != "" && !opts.Yes && !stdinIsTerminal() {
+		return ExitUsage, errors.New("rollback --to needs --yes when not run from a terminal")
 	}
 
-	if ship.SmartBlinder && ship.SmartBlinderIdleMinutes <= 0 {
-		ship.SmartBlinderIdleMinutes = 10
+	picked, err := r.pickShip(&opts)
+	if err != nil {
+		return ExitFailure, err
 	}
-	if ship.SSHPort == 0 {
-		ship.SSHPort = 22
+	ship, code, err := r.resolveShip(opts, protocol, httpMode)
+	if err != nil {
+		return code, err
 	}
-	if ship.SSHUser == "" {
-		ship.SSHUser = "root"
+	if (opts.InstanceSet && opts.Stealth) || (ship.Instance != "" && ship.Protocol == "socks5") {
+		return ExitUsage, errors.New("--instance only applies to the HTTP proxy")
 	}
-
-	if strings.TrimSpace(ship.Host) == "" {
-		return ExitUsage, errors.New("no host provided. use --host or --ship")
+	if ship.Instance != "" && (opts.TrafficCapSet || opts.QuietHoursSet) {
+		return ExitUsage, errors.New("--traffic-cap-gb and --quiet-hours cover the whole server; set them on the default hangar, not an --instance")
 	}
-
-	password := opts.SSHPassword
-	if strings.TrimSpace(password) == "" {
-		fd, err := stdinFD()
-		if err != nil {
-			return ExitFailure, err
-		}
-		if !term.IsTerminal(fd) {
-			return ExitUsage, errors.New("ssh password is required")
+	if opts.CacheSet && ship.Protocol == "socks5" {
+		return ExitUsage, errors.New("--cache-mb only applies to the HTTP proxy")
+	}
+	if opts.AnonymitySet && ship.Protocol == "socks5" {
+		return ExitUsage, errors.New("--anonymity only applies to the HTTP proxy")
+	}
+	if opts.SocketActivation && ship.Protocol != "socks5" {
+		return ExitUsage, errors.New("--socket-activation only applies to the SOCKS5 proxy")
+	}
+	var microsocks []byte
+	if opts.MicrosocksBinary != "" {
+		if ship.Protocol != "socks5" {
+			return ExitUsage, errors.New("--microsocks-binary only applies to the SOCKS5 proxy")
 		}
-		fmt.Printf("SSH password for %s@%s: ", ship.SSHUser, ship.Host)
-		b, err := term.ReadPassword(fd)
-		fmt.Println()
-		if err != nil {
-			return ExitFailure, fmt.Errorf("read password: %w", err)
+		if microsocks, err = readMicrosocksBinary(opts.MicrosocksBinary); err != nil {
+			return ExitUsage, err
 		}
-		password = string(b)
 	}
-	if strings.TrimSpace(password) == "" {
-		return ExitUsage, errors.New("ssh password is required")
+	if opts.RotateGrace > 0 && ship.Protocol == "socks5" {
+		return ExitUsage, errors.New("--rotate-grace only applies to the HTTP proxy; microsocks accepts a single user")
+	}
+	if domainsSet && ship.Protocol == "socks5" {
+		return ExitUsage, errors.New("--allow-domains and --deny-domains only apply to the HTTP proxy")
+	}
+	if note := expiryNote(ship.ExpiresAt, time.Now()); note != "" && !opts.TTLSet {
+		fmt.Printf("[beammeup] reminder: hangar on %s %s.\n", ship.Name, note)
+	}
+	password, code, err := r.resolvePassword(ship, opts)
+	if err != nil {
+		return code, err
 	}
 
 	if opts.Stealth {
 		return r.runStealth(ship, password, opts)
 	}
 
-	inv, err := r.Hangar.Inventory(ship, password)
+	inventory := r.Hangar.InventoryContext
+	if opts.ReadOnly {
+		inventory = r.Hangar.AuditInventoryContext
+	}
+	endPhase := r.Events.Phase("inventory")
+	checked := time.Now()
+	ctx, stop := interruptContext()
+	inv, err := inventory(ctx, ship, password)
+	err = interrupted(ctx, err)
+	stop()
+	endPhase(err)
+	r.recordHistory(opts.ShipName, history.Check(inv.HangarStatus, time.Since(checked), err))
 	if err != nil {
 		return ExitFailure, err
 	}
+	r.recordHostInfo(opts.ShipName, inv.Host)
+	printHostReport(inv.Host)
+	if inv.GeoErr != nil {
+		fmt.Fprintf(os.Stderr, "[beammeup] WARNING: GeoIP lookup failed: %v\n", inv.GeoErr)
+	}
 	printInventorySummary(inv)
 
 	if opts.ShowInventory {
+		printListening(inv)
+		r.Events.Result(inventoryEventData(inv))
 		return ExitSuccess, nil
 	}
+	if action == "os-update" {
+		return r.runOSUpdate(ship, password, opts)
+	}
+	if action == "reboot" {
+		return r.runReboot(ship, password, opts)
+	}
+	if action == "rollback" {
+		return r.runRollback(ship, password, opts)
+	}
 
 	if opts.PreflightOnly {
 		action = "configure"
@@ -212,6 +470,15 @@ func (r *Runner) Run(opts Options) (int, error) {
 		rotate = true
 		action = "configure"
 	}
+	if picked && action == "configure" && !opts.PreflightOnly {
+		what := "configure"
+		if rotate {
+			what = "rotate"
+		}
+		if err := confirmPicked(ship, what, opts); err != nil {
+			return ExitFailure, err
+		}
+	}
 
 	if action != "destroy" {
 		if ship.Protocol == "" {
@@ -232,15 +499,12 @@ func (r *Runner) Run(opts Options) (int, error) {
 		in.Protocol = ship.Protocol
 		in.HTTPMode = ship.HTTPMode
 	case action == "destroy":
-		if !opts.Yes {
-			if !confirm("Destroy hangar on "+ship.Host+"?", false) {
-				return ExitFailure, errors.New("cancelled")
-			}
-			fmt.Print("Type DESTROY to confirm: ")
-			t := strings.TrimSpace(readLine())
-			if t != "DESTROY" {
-				return ExitFailure, errors.New("cancelled")
-			}
+		prompt := "Destroy hangar on " + ship.Host + "?"
+		if ship.Instance != "" {
+			prompt = "Destroy HTTP instance " + ship.Instance + " on " + ship.Host + "?"
+		}
+		if err := confirmDestroy(prompt, opts); err != nil {
+			return ExitFailure, err
 		}
 		in.Mode = "destroy"
 	case opts.PreflightOnly:
@@ -253,20 +517,75 @@ func (r *Runner) Run(opts Options) (int, error) {
 		in.Protocol = ship.Protocol
 		in.HTTPMode = ship.HTTPMode
 		in.RotateCredentials = rotate
+		in.RotateScope = opts.RotateScope
+		in.RotateGrace = opts.RotateGrace
 		in.ProxyPort = resolveProxyPort(ship, inv)
 		in.NoFirewallChange = ship.NoFirewallChange
+		in.TrafficCapGB = ship.TrafficCapGB
+		in.QuietHours = ship.QuietHours
+		in.Label = ship.HangarLabel
+		in.Description = ship.HangarDescription
+		in.MaxConns = ship.MaxConns
+		in.RateLimit = ship.RateLimit
+		in.MemoryMax = ship.MemoryMax
+		in.CPUQuota = ship.CPUQuota
+		in.TasksMax = ship.TasksMax
+		in.SocketActivation = ship.Protocol == "socks5" && ship.SocketActivation
+		in.MicrosocksBinary = microsocks
+		in.PhaseTimeout = opts.PhaseTimeout
+		in.SkipVerify = opts.NoVerify
+		if ship.Protocol == "http" {
+			in.CacheMB = ship.CacheMB
+			in.CacheDir = ship.CacheDir
+			in.Anonymity = ship.Anonymity
+			in.AllowDomains = ship.AllowDomains
+			in.DenyDomains = ship.DenyDomains
+		}
+		if opts.TTLSet {
+			in.TTL = opts.TTL
+			in.ClearTTL = opts.TTL == 0
+		}
 	}
 	if in.Mode == "apply" || in.Mode == "preflight" {
 		in.ListenLocal = ship.ListenLocal
 		in.SmartBlinder = ship.SmartBlinder
 		in.SmartBlinderIdleMinutes = ship.SmartBlinderIdleMinutes
+		in.EgressIP = ship.EgressIP
 	}
 
-	res, err := r.Hangar.Execute(ship, password, in)
-	if err != nil {
-		if isHTTPSquidConflict(err) && in.Mode == "apply" && strings.EqualFold(in.Protocol, "http") {
-			return ExitFailure, fmt.Errorf("%w\nhint: retry with --http-mode sidecar (isolated HTTP) or --protocol socks5 --proxy-port 18080", err)
+	before, hadBefore := creds.Card{}, false
+	if in.Mode == "apply" {
+		before, hadBefore = r.previousCard(opts.ShipName)
+	}
+
+	in.BreakLock = opts.BreakLock
+	execute := func(in hangar.ActionInput) (hangar.ActionResult, error) {
+		endPhase := r.Events.Phase(in.Mode)
+		ctx, stop := interruptContext()
+		res, err := r.Hangar.ExecuteContext(ctx, ship, password, in)
+		err = interrupted(ctx, err)
+		stop()
+		endPhase(err)
+		if e, ok := history.Action(in, err); ok {
+			r.recordHistory(opts.ShipName, e)
+		}
+		return res, err
+	}
+	res, err := execute(in)
+	if isHTTPSquidConflict(err) && (in.Mode == "apply" || in.Mode == "preflight") && strings.EqualFold(in.Protocol, "http") {
+		retry, ok, cerr := r.resolveSquidConflict(ship, password, in, opts)
+		if cerr != nil {
+			return ExitFailure, cerr
+		}
+		if !ok {
+			return ExitFailure, fmt.Errorf("%w\nhint: retry with --http-conflict sidecar (isolated HTTP), adopt or replace, or --protocol socks5 --proxy-port 18080", err)
+		}
+		in = retry
+		if res, err = execute(in); err == nil && in.HTTPMode == "sidecar" && in.Mode == "apply" {
+			r.recordHTTPMode(opts.ShipName, "sidecar")
 		}
+	}
+	if err != nil {
 		return ExitFailure, err
 	}
 
@@ -277,25 +596,53 @@ func (r *Runner) Run(opts Options) (int, error) {
 		fmt.Println("\nPreflight passed. No changes were made.")
 		fmt.Printf("Protocol: %s\n", res.Values.Get("BM_PREFLIGHT_PROTOCOL"))
 		fmt.Printf("Port: %s\n", res.Values.Get("BM_PREFLIGHT_PORT"))
+		if v := res.Values.Get("BM_PREFLIGHT_EGRESS_IP"); v != "" {
+			fmt.Printf("Egress IP: %s (assigned on the server)\n", v)
+		}
+		printPreflightResources(res.Values)
 		fmt.Println("Status: ready for launch.")
+		r.Events.Result(map[string]string{
+			"action":    "preflight",
+			"protocol":  res.Values.Get("BM_PREFLIGHT_PROTOCOL"),
+			"port":      res.Values.Get("BM_PREFLIGHT_PORT"),
+			"egress_ip": res.Values.Get("BM_PREFLIGHT_EGRESS_IP"),
+			"warnings":  res.Values.Get("BM_PREFLIGHT_WARNINGS"),
+		})
 		return ExitSuccess, nil
 	}
 
 	if res.Protocol == "DESTROY" {
+		if ship.Instance == "" {
+			r.recordExpiry(opts.ShipName, time.Time{})
+		}
+		r.forgetCard(opts.ShipName)
+		cloudNote := r.closeCloudFirewall(opts.ShipName, ship)
 		fmt.Println("\n[beammeup] destroy hangar complete.")
 		fmt.Printf("  Target: %s\n", res.Host)
 		if res.Note != "" {
 			fmt.Printf("  Result: %s\n", res.Note)
 		}
+		if cloudNote != "" {
+			fmt.Printf("  Cloud firewall: %s\n", cloudNote)
+		}
 		fmt.Println("\n[beammeup] jump successful.")
+		r.Events.Result(map[string]string{"action": "destroy", "host": res.Host, "note": res.Note})
 		return ExitSuccess, nil
 	}
 
+	var warnings []string
+	res, warnings = creds.Screen(r.Hangar, ship, password, in, res, r.Config.LeakCheck, r.Config.LeakCheckURL)
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "[beammeup] WARNING: %s\n", w)
+	}
+
 	proxyHost := res.Host
 	proxyPort := res.Port
 	if ship.ListenLocal {
 		proxyHost = "127.0.0.1"
 	}
+	port, _ := strconv.Atoi(proxyPort)
+	cloudNote := r.syncCloudFirewall(opts.ShipName, ship, port)
 
 	fmt.Printf("\nbeammeup %s complete (%s).\n", res.Action, res.Protocol)
 	fmt.Println("Connection details:")
@@ -306,6 +653,17 @@ func (r *Runner) Run(opts Options) (int, error) {
 	}
 	fmt.Printf("  Username: %s\n", fallback(res.User, "<not available>"))
 	fmt.Printf("  Password: %s\n", fallback(res.Pass, "<not retrievable>"))
+	if res.EgressIP != "" {
+		fmt.Printf("  Exit IP: %s\n", res.EgressIP)
+	}
+	if res.Verified != "" {
+		fmt.Printf("  Verified: %s\n", res.Verified)
+	}
+	reach := reachFromHere(ship, res, !opts.NoVerify)
+	if reach.State != "" {
+		res.Reachable = reach.String()
+		fmt.Printf("  Reachable from your location: %s\n", res.Reachable)
+	}
 	if ship.ListenLocal && proxyPort != "" {
 		sshCmd := fmt.Sprintf("ssh -N -o ExitOnForwardFailure=yes -L %s:127.0.0.1:%s %s@%s -p %d", proxyPort, proxyPort, ship.SSHUser, ship.Host, ship.SSHPort)
 		if ship.SSHPort == 22 {
@@ -317,9 +675,73 @@ func (r *Runner) Run(opts Options) (int, error) {
 	if res.FirewallNote != "" {
 		fmt.Printf("\nFirewall note: %s\n", res.FirewallNote)
 	}
+	if cloudNote != "" {
+		fmt.Printf("Cloud firewall: %s\n", cloudNote)
+	}
 	if res.Note != "" {
 		fmt.Printf("Note: %s\n", res.Note)
 	}
+	if res.Backup != "" {
+		fmt.Printf("Undo: beammeup --ship %s --action rollback --to %s\n", ship.Name, res.Backup)
+	}
+	r.recordExpiry(opts.ShipName, res.ExpiresAt)
+	r.recordEgressIP(opts.ShipName, res.EgressIP)
+	r.recordCard(opts.ShipName, res)
+	if after := creds.FromResult(ship.Name, res); after.Pass != "" && (!hadBefore || credentialsChanged(before, after)) {
+		r.regenerateExports(ship, after)
+	}
+	if opts.QuietHoursSet {
+		r.recordQuietHours(opts.ShipName, ship.QuietHours)
+	}
+	if opts.LabelSet || opts.DescriptionSet {
+		r.recordHangarInfo(opts.ShipName, ship.HangarLabel, ship.HangarDescription)
+	}
+	if opts.LimitsSet {
+		r.recordLimits(opts.ShipName, ship.MaxConns, ship.RateLimit)
+	}
+	if opts.ResourcesSet {
+		r.recordResources(opts.ShipName, ship.MemoryMax, ship.CPUQuota, ship.TasksMax)
+	}
+	if opts.InstanceSet {
+		r.recordInstance(opts.ShipName, ship.Instance)
+	}
+	if opts.CacheSet {
+		r.recordCache(opts.ShipName, ship.CacheMB, ship.CacheDir)
+	}
+	if opts.AnonymitySet {
+		r.recordAnonymity(opts.ShipName, ship.Anonymity)
+	}
+	if opts.SocketActivationSet {
+		r.recordSocketActivation(opts.ShipName, ship.SocketActivation)
+	}
+	if domainsSet {
+		r.recordDomains(opts.ShipName, ship.AllowDomains, ship.DenyDomains)
+	}
+	if opts.CloudFirewallSet {
+		r.recordCloudFirewall(opts.ShipName, ship.CloudFirewall)
+	}
+	if note := expiryNote(res.ExpiresAt, time.Now()); note != "" {
+		fmt.Printf("Self-destruct: hangar %s\n", note)
+	}
+	if rotate {
+		r.reportRotation(ship, before, hadBefore, res, opts.RefreshClients)
+	}
+
+	r.Events.Result(map[string]string{
+		"action":         res.Action,
+		"protocol":       res.Protocol,
+		"http_mode":      res.HTTPMode,
+		"host":           proxyHost,
+		"port":           proxyPort,
+		"user":           res.User,
+		"pass":           res.Pass,
+		"egress_ip":      res.EgressIP,
+		"note":           res.Note,
+		"backup":         res.Backup,
+		"verified":       res.Verified,
+		"reachable":      reach.State,
+		"cloud_firewall": cloudNote,
+	})
 
 	fmt.Println("\n[beammeup] jump successful.")
 	fmt.Println("\nChrome extension setup:")
@@ -340,6 +762,169 @@ func (r *Runner) Run(opts Options) (int, error) {
 	return ExitSuccess, nil
 }
 
+// resolveShip loads the ship selected by --ship (if any) and applies the
+// command-line overrides on top of it.
+func (r *Runner) resolveShip(opts Options, protocol, httpMode string) (ships.Ship, int, error) {
+	var ship ships.Ship
+	loadedFromStore := false
+	if opts.ShipName != "" {
+		loaded, err := r.Store.Load(opts.ShipName)
+		if err != nil {
+			return ships.Ship{}, ExitFailure, err
+		}
+		ship = loaded
+		loadedFromStore = true
+		if err := r.Store.SetLast(ship.Name); err != nil {
+			fmt.Fprintf(os.Stderr, "[beammeup] WARNING: %v\n", err)
+		}
+	}
+
+	if opts.Host != "" {
+		ship.Host = opts.Host
+	}
+	if opts.SSHPort > 0 {
+		ship.SSHPort = opts.SSHPort
+	}
+	if opts.SSHUser != "" {
+		ship.SSHUser = opts.SSHUser
+	}
+	if protocol != "" {
+		ship.Protocol = protocol
+	}
+	if httpMode != "" || strings.EqualFold(strings.TrimSpace(opts.HTTPMode), "auto") {
+		ship.HTTPMode = httpMode
+	}
+	if opts.ProxyPort > 0 {
+		ship.ProxyPort = opts.ProxyPort
+	}
+	if opts.NoFirewallChange {
+		ship.NoFirewallChange = true
+	}
+
+	if loadedFromStore {
+		if opts.ListenLocalSet {
+			ship.ListenLocal = opts.ListenLocal
+		}
+		if opts.SmartBlinderSet {
+			ship.SmartBlinder = opts.SmartBlinder
+		}
+		if opts.SmartBlinderIdleMinSet {
+			ship.SmartBlinderIdleMinutes = opts.SmartBlinderIdleMinutes
+		}
+	} else {
+		ship.ListenLocal = opts.ListenLocal
+		ship.SmartBlinder = opts.SmartBlinder
+		ship.SmartBlinderIdleMinutes = opts.SmartBlinderIdleMinutes
+	}
+
+	if opts.TrafficCapSet {
+		ship.TrafficCapGB = opts.TrafficCapGB
+	}
+	if opts.QuietHoursSet {
+		ship.QuietHours = opts.QuietHours
+	}
+	if opts.LabelSet {
+		ship.HangarLabel = opts.Label
+	}
+	if opts.DescriptionSet {
+		ship.HangarDescription = opts.Description
+	}
+	if opts.LimitsSet {
+		ship.MaxConns = opts.MaxConns
+		ship.RateLimit = opts.RateLimit
+	}
+	if opts.ResourcesSet {
+		ship.MemoryMax = opts.MemoryMax
+		ship.CPUQuota = opts.CPUQuota
+		ship.TasksMax = opts.TasksMax
+	}
+	if opts.EgressIPSet {
+		ship.EgressIP = opts.EgressIP
+	}
+	if opts.InstanceSet {
+		ship.Instance = opts.Instance
+	}
+	if opts.CacheSet {
+		ship.CacheMB = opts.CacheMB
+		ship.CacheDir = opts.CacheDir
+	}
+	if opts.AnonymitySet {
+		ship.Anonymity = opts.Anonymity
+	}
+	if opts.SocketActivationSet {
+		ship.SocketActivation = opts.SocketActivation
+	}
+	if opts.AllowDomainsSet {
+		ship.AllowDomains = opts.AllowDomains
+	}
+	if opts.DenyDomainsSet {
+		ship.DenyDomains = opts.DenyDomains
+	}
+	if opts.CloudFirewallSet {
+		ship.CloudFirewall = opts.CloudFirewall
+	}
+	if ship.Instance != "" {
+		// Instances are always HTTP sidecars.
+		if ship.Protocol == "" {
+			ship.Protocol = "http"
+		}
+		ship.HTTPMode = "sidecar"
+	}
+
+	if ship.SmartBlinder && ship.SmartBlinderIdleMinutes <= 0 {
+		ship.SmartBlinderIdleMinutes = 10
+	}
+	if ship.SSHPort == 0 {
+		ship.SSHPort = 22
+	}
+	if ship.SSHUser == "" {
+		ship.SSHUser = "root"
+	}
+
+	if strings.TrimSpace(ship.Host) == "" {
+		return ships.Ship{}, ExitUsage, errors.New("no host provided. use --host or --ship")
+	}
+	return ship, ExitSuccess, nil
+}
+
+// resolvePassword returns the SSH password from --ssh-password or, on a
+// terminal, by prompting for it. It returns an empty password without asking
+// when SSH keys (ssh-agent or ~/.ssh/config) log in on their own.
+func (r *Runner) resolvePassword(ship ships.Ship, opts Options) (string, int, error) {
+	// Everything that reaches a server asks for its password first, so this
+	// is where a ship with an untrusted host key is stopped.
+	if err := hostkey.Check(ship); err != nil {
+		return "", ExitFailure, err
+	}
+	password := opts.SSHPassword
+	if strings.TrimSpace(password) == "" && isTunnelDaemon() {
+		password = readDaemonPassword()
+	}
+	if strings.TrimSpace(password) == "" && r.Hangar.KeyLogin(ship) {
+		return "", ExitSuccess, nil
+	}
+	if strings.TrimSpace(password) == "" {
+		fd, err := stdinFD()
+		if err != nil {
+			return "", ExitFailure, err
+		}
+		if !term.IsTerminal(fd) {
+			return "", ExitUsage, errors.New("ssh password is required (or load a key into ssh-agent)")
+		}
+		fmt.Printf("SSH password for %s@%s: ", ship.SSHUser, ship.Host)
+		b, err := term.ReadPassword(fd)
+		fmt.Println()
+		if err != nil {
+			return "", ExitFailure, fmt.Errorf("read password: %w", err)
+		}
+		password = string(b)
+	}
+	if strings.TrimSpace(password) == "" {
+		return "", ExitUsage, errors.New("ssh password is required")
+	}
+	return password, ExitSuccess, nil
+}
+
 func (r *Runner) listShips() (int, error) {
 	shipsList, err := r.Store.List()
 	if err != nil {
@@ -350,30 +935,439 @@ func (r *Runner) listShips() (int, error) {
 		return ExitSuccess, nil
 	}
 	fmt.Printf("Saved ships (%s):\n", r.Store.Dir)
-	for _, ship := range shipsList {
-		fmt.Printf("  - %s\n", ship)
+	for _, name := range shipsList {
+		if ship, err := r.Store.Load(name); err == nil && !ship.ExpiresAt.IsZero() {
+			fmt.Printf("  - %s (%s)\n", name, expiryNote(ship.ExpiresAt, time.Now()))
+			continue
+		}
+		fmt.Printf("  - %s\n", name)
 	}
 	return ExitSuccess, nil
 }
 
+// recordExpiry stores the hangar's scheduled destroy time in the saved ship
+// profile (if any) so later runs can remind the user about it.
+func (r *Runner) recordExpiry(shipName string, expiresAt time.Time) {
+	if shipName == "" {
+		return
+	}
+	ship, err := r.Store.Load(shipName)
+	if err != nil || ship.ExpiresAt.Equal(expiresAt) {
+		return
+	}
+	ship.ExpiresAt = expiresAt
+	if _, err := r.Store.Save(ship); err != nil {
+		fmt.Fprintf(os.Stderr, "[beammeup] WARNING: could not record hangar expiry: %v\n", err)
+	}
+}
+
+// recordHistory adds e to the saved ship's status history, if any.
+func (r *Runner) recordHistory(shipName string, e history.Entry) {
+	if shipName == "" || r.History == nil {
+		return
+	}
+	if err := r.History.Record(shipName, e); err != nil {
+		fmt.Fprintf(os.Stderr, "[beammeup] WARNING: could not record status history: %v\n", err)
+	}
+}
+
+// hostKeys flags the saved ships behind a changed SSH host key.
+func (r *Runner) hostKeys() *hostkey.Guard {
+	return &hostkey.Guard{Store: r.Store, History: r.History, Notifier: r.Notifier}
+}
+
+// interruptContext is cancelled by Ctrl-C or SIGTERM, so a run stuck on an
+// unresponsive server drops its SSH session and still reports how it ended.
+func interruptContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// interrupted explains an error that came from ctx being cancelled.
+func interrupted(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("interrupted; the server may still be finishing the step it was on: %w", err)
+	}
+	return err
+}
+
+// sshOptions is SSHOptions with the hook that reports changed host keys
+// and, on a terminal, a prompt for one-time codes.
+func (r *Runner) sshOptions(opts Options) sshx.ConnectOptions {
+	sshOpts := SSHOptions(opts)
+	sshOpts.HostKeyChanged = r.hostKeys().Changed
+	if stdinIsTerminal() && !isTunnelDaemon() {
+		sshOpts.Prompt = promptSSH
+	}
+	switch {
+	case isTunnelDaemon():
+		// The run that started the tunnel already confirmed the key.
+	case opts.Yes:
+		sshOpts.ConfirmHostKey = announceHostKey
+	case stdinIsTerminal():
+		sshOpts.ConfirmHostKey = confirmHostKey
+	default:
+		sshOpts.ConfirmHostKey = refuseHostKey
+	}
+	return sshOpts
+}
+
+// confirmHostKey shows a first-seen SSH host key and asks before it goes
+// into known_hosts.
+func confirmHostKey(t sshx.Target, key ssh.PublicKey) error {
+	promptMu.Lock()
+	defer promptMu.Unlock()
+	fmt.Fprintf(os.Stderr, "[beammeup] first connection to %s. The server presents:\n", t.Host)
+	fmt.Fprintf(os.Stderr, "  %s %s\n", key.Type(), ssh.FingerprintSHA256(key))
+	fmt.Fprintln(os.Stderr, "  Compare it with the server's own key, e.g. from the provider's console:")
+	fmt.Fprintln(os.Stderr, "    ssh-keygen -lf /etc/ssh/ssh_host_ed25519_key.pub")
+	if !confirm("Trust this key?", false) {
+		return errors.New("declined")
+	}
+	return nil
+}
+
+// announceHostKey trusts a first-seen SSH host key under --yes, leaving its
+// fingerprint in the log.
+func announceHostKey(t sshx.Target, key ssh.PublicKey) error {
+	fmt.Fprintf(os.Stderr, "[beammeup] trusting the new SSH host key of %s: %s %s\n", t.Host, key.Type(), ssh.FingerprintSHA256(key))
+	return nil
+}
+
+// refuseHostKey stops runs without a terminal from trusting a first-seen SSH
+// host key nobody looked at.
+func refuseHostKey(sshx.Target, ssh.PublicKey) error {
+	return errors.New("no terminal to confirm it; pass --yes to trust new host keys, or add the key to known_hosts first")
+}
+
+// promptMu keeps prompts of SSH connections made in parallel (top, fleet
+// runs) from interleaving.
+var promptMu sync.Mutex
+
+// promptSSH asks on the terminal what the SSH server wants to know beyond
+// the password, usually a one-time code.
+func promptSSH(t sshx.Target, instruction, question string, echo bool) (string, error) {
+	promptMu.Lock()
+	defer promptMu.Unlock()
+	if instruction = strings.TrimSpace(instruction); instruction != "" {
+		fmt.Fprintln(os.Stderr, instruction)
+	}
+	fmt.Fprintf(os.Stderr, "[%s@%s] %s", t.User, t.Host, question)
+	fd, err := stdinFD()
+	if err != nil {
+		return "", err
+	}
+	if !echo {
+		b, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return string(b), err
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimSpace(line), err
+}
+
+// recordEgressIP keeps the saved ship's egress IP in line with what the
+// hangar was last configured with.
+func (r *Runner) recordEgressIP(shipName, egressIP string) {
+	if shipName == "" {
+		return
+	}
+	ship, err := r.Store.Load(shipName)
+	if err != nil || ship.EgressIP == egressIP {
+		return
+	}
+	ship.EgressIP = egressIP
+	if _, err := r.Store.Save(ship); err != nil {
+		fmt.Fprintf(os.Stderr, "[beammeup] WARNING: could not record egress IP: %v\n", err)
+	}
+}
+
+// recordHTTPMode keeps the saved ship on the HTTP mode its hangar was just
+// configured with, so later runs do not hit the same conflict again.
+func (r *Runner) recordHTTPMode(shipName, mode string) {
+	if shipName == "" {
+		return
+	}
+	ship, err := r.Store.Load(shipName)
+	if err != nil || ship.HTTPMode == mode {
+		return
+	}
+	ship.HTTPMode = mode
+	if _, err := r.Store.Save(ship); err != nil {
+		fmt.Fprintf(os.Stderr, "[beammeup] WARNING: could not record HTTP mode: %v\n", err)
+	}
+}
+
+// recordInstance points the saved ship at the HTTP instance it was just
+// configured on.
+func (r *Runner) recordInstance(shipName, instance string) {
+	if shipName == "" {
+		return
+	}
+	ship, err := r.Store.Load(shipName)
+	if err != nil || ship.Instance == instance {
+		return
+	}
+	ship.Instance = instance
+	if instance != "" {
+		ship.Protocol = "http"
+	}
+	if _, err := r.Store.Save(ship); err != nil {
+		fmt.Fprintf(os.Stderr, "[beammeup] WARNING: could not record instance: %v\n", err)
+	}
+}
+
+// recordCache keeps the saved ship's HTTP cache settings in line with what
+// the hangar was just configured with.
+func (r *Runner) recordCache(shipName string, sizeMB int, dir string) {
+	if shipName == "" {
+		return
+	}
+	ship, err := r.Store.Load(shipName)
+	if err != nil || (ship.CacheMB == sizeMB && ship.CacheDir == dir) {
+		return
+	}
+	ship.CacheMB = sizeMB
+	ship.CacheDir = dir
+	if _, err := r.Store.Save(ship); err != nil {
+		fmt.Fprintf(os.Stderr, "[beammeup] WARNING: could not record cache settings: %v\n", err)
+	}
+}
+
+// recordAnonymity keeps the saved ship's header preset in line with what
+// the hangar was just configured with.
+func (r *Runner) recordAnonymity(shipName, preset string) {
+	if shipName == "" {
+		return
+	}
+	ship, err := r.Store.Load(shipName)
+	if err != nil || ship.Anonymity == preset {
+		return
+	}
+	ship.Anonymity = preset
+	if _, err := r.Store.Save(ship); err != nil {
+		fmt.Fprintf(os.Stderr, "[beammeup] WARNING: could not record anonymity preset: %v\n", err)
+	}
+}
+
+// readMicrosocksBinary loads --microsocks-binary. It has to be a Linux
+// executable; a static build avoids depending on the server's libc.
+func readMicrosocksBinary(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("--microsocks-binary: %w", err)
+	}
+	if !bytes.HasPrefix(data, []byte("\x7fELF")) {
+		return nil, fmt.Errorf("--microsocks-binary: %s is not a Linux (ELF) executable", path)
+	}
+	return data, nil
+}
+
+// recordSocketActivation keeps the saved ship's socket activation setting
+// in line with what the hangar was just configured with.
+func (r *Runner) recordSocketActivation(shipName string, on bool) {
+	if shipName == "" {
+		return
+	}
+	ship, err := r.Store.Load(shipName)
+	if err != nil || ship.SocketActivation == on {
+		return
+	}
+	ship.SocketActivation = on
+	if _, err := r.Store.Save(ship); err != nil {
+		fmt.Fprintf(os.Stderr, "[beammeup] WARNING: could not record socket activation: %v\n", err)
+	}
+}
+
+// recordQuietHours keeps the saved ship's schedule in line with what the
+// hangar was just configured with, so later applies do not drop it.
+func (r *Runner) recordQuietHours(shipName, windows string) {
+	if shipName == "" {
+		return
+	}
+	ship, err := r.Store.Load(shipName)
+	if err != nil || ship.QuietHours == windows {
+		return
+	}
+	ship.QuietHours = windows
+	if _, err := r.Store.Save(ship); err != nil {
+		fmt.Fprintf(os.Stderr, "[beammeup] WARNING: could not record quiet hours: %v\n", err)
+	}
+}
+
+// recordHangarInfo keeps the saved ship's hangar label and description in
+// line with what hangar.json was just given.
+func (r *Runner) recordHangarInfo(shipName, label, description string) {
+	if shipName == "" {
+		return
+	}
+	ship, err := r.Store.Load(shipName)
+	if err != nil || (ship.HangarLabel == label && ship.HangarDescription == description) {
+		return
+	}
+	ship.HangarLabel, ship.HangarDescription = label, description
+	if _, err := r.Store.Save(ship); err != nil {
+		fmt.Fprintf(os.Stderr, "[beammeup] WARNING: could not record the hangar label: %v\n", err)
+	}
+}
+
+// recordLimits keeps the saved ship's connection limits in line with what
+// the hangar was just configured with.
+func (r *Runner) recordLimits(shipName string, maxConns, ratePerMinute int) {
+	if shipName == "" {
+		return
+	}
+	ship, err := r.Store.Load(shipName)
+	if err != nil || (ship.MaxConns == maxConns && ship.RateLimit == ratePerMinute) {
+		return
+	}
+	ship.MaxConns = maxConns
+	ship.RateLimit = ratePerMinute
+	if _, err := r.Store.Save(ship); err != nil {
+		fmt.Fprintf(os.Stderr, "[beammeup] WARNING: could not record connection limits: %v\n", err)
+	}
+}
+
+// recordResources keeps the saved ship's unit caps in line with what the
+// hangar was just configured with.
+func (r *Runner) recordResources(shipName, memoryMax, cpuQuota string, tasksMax int) {
+	if shipName == "" {
+		return
+	}
+	ship, err := r.Store.Load(shipName)
+	if err != nil || (ship.MemoryMax == memoryMax && ship.CPUQuota == cpuQuota && ship.TasksMax == tasksMax) {
+		return
+	}
+	ship.MemoryMax = memoryMax
+	ship.CPUQuota = cpuQuota
+	ship.TasksMax = tasksMax
+	if _, err := r.Store.Save(ship); err != nil {
+		fmt.Fprintf(os.Stderr, "[beammeup] WARNING: could not record resource caps: %v\n", err)
+	}
+}
+
+// recordDomains keeps the saved ship's destination lists in line with what
+// the hangar was just configured with.
+func (r *Runner) recordDomains(shipName string, allow, deny []string) {
+	if shipName == "" {
+		return
+	}
+	ship, err := r.Store.Load(shipName)
+	if err != nil || (slices.Equal(ship.AllowDomains, allow) && slices.Equal(ship.DenyDomains, deny)) {
+		return
+	}
+	ship.AllowDomains = allow
+	ship.DenyDomains = deny
+	if _, err := r.Store.Save(ship); err != nil {
+		fmt.Fprintf(os.Stderr, "[beammeup] WARNING: could not record domain lists: %v\n", err)
+	}
+}
+
+// recordHostInfo caches the host report for a saved ship. Ad-hoc --host
+// targets have no profile to attach it to.
+func (r *Runner) recordHostInfo(shipName string, h ships.HostInfo) {
+	if shipName == "" || h.IsZero() {
+		return
+	}
+	if _, err := r.Store.Load(shipName); err != nil {
+		return
+	}
+	if err := r.Store.SaveHostInfo(shipName, h); err != nil {
+		fmt.Fprintf(os.Stderr, "[beammeup] WARNING: could not cache host info: %v\n", err)
+	}
+}
+
+func printHostReport(h ships.HostInfo) {
+	if h.IsZero() {
+		return
+	}
+	h.CheckedAt = time.Time{}
+	fmt.Println("\n[ship-scan] host report:")
+	for _, line := range h.Lines() {
+		fmt.Printf("  %s\n", line)
+	}
+}
+
+// expiryNote describes a scheduled destroy time relative to now, or returns
+// "" when none is scheduled.
+func expiryNote(expiresAt, now time.Time) string {
+	if expiresAt.IsZero() {
+		return ""
+	}
+	at := expiresAt.Local().Format("2006-01-02 15:04 MST")
+	if !now.Before(expiresAt) {
+		return "expired at " + at + " and was destroyed by the server"
+	}
+	return fmt.Sprintf("expires at %s (in %s)", at, expiresAt.Sub(now).Round(time.Minute))
+}
+
 func resolveProxyPort(ship ships.Ship, inv hangar.Inventory) int {
 	if ship.ProxyPort > 0 {
 		return ship.ProxyPort
 	}
+	state := inv.HTTP
 	if ship.Protocol == "socks5" {
-		if inv.Socks5.Port != "" {
-			if p, _ := strconv.Atoi(inv.Socks5.Port); p > 0 {
-				return p
-			}
+		state = inv.Socks5
+	}
+	if p, _ := strconv.Atoi(state.Port); p > 0 {
+		return p
+	}
+	if proto, ok := hangar.LookupProtocol(ship.Protocol); ok {
+		return proto.DefaultPort()
+	}
+	return 0
+}
+
+// printPreflightResources reports the server's headroom as measured by
+// preflight. Hard shortages already failed the preflight on the server.
+func printPreflightResources(kv remote.KeyValues) {
+	if v := kv.Get("BM_PREFLIGHT_DISK_FREE_MB"); v != "" {
+		fmt.Printf("Disk free: %s MB (/var)\n", v)
+	}
+	if v := kv.Get("BM_PREFLIGHT_MEM_AVAILABLE_MB"); v != "" {
+		fmt.Printf("Memory available: %s MB\n", v)
+	}
+	if v := kv.Get("BM_PREFLIGHT_LOAD"); v != "" {
+		fmt.Printf("Load average: %s (%s CPU)\n", v, fallback(kv.Get("BM_PREFLIGHT_CPUS"), "?"))
+	}
+	for _, w := range strings.Split(kv.Get("BM_PREFLIGHT_WARNINGS"), ";") {
+		if w = strings.TrimSpace(w); w != "" {
+			fmt.Fprintf(os.Stderr, "[beammeup] WARNING: %s\n", w)
 		}
-		return 1080
 	}
-	if inv.HTTP.Port != "" {
-		if p, _ := strconv.Atoi(inv.HTTP.Port); p > 0 {
-			return p
+}
+
+// inventoryEventData is the --events-json result of --show-inventory.
+func inventoryEventData(inv hangar.Inventory) map[string]string {
+	return map[string]string{
+		"action":        "inventory",
+		"status":        string(inv.HangarStatus),
+		"public_ip":     inv.PublicIP,
+		"socks5":        strconv.FormatBool(inv.Socks5.Exists),
+		"socks5_active": strconv.FormatBool(inv.Socks5.Active),
+		"socks5_port":   inv.Socks5.Port,
+		"http":          strconv.FormatBool(inv.HTTP.Exists),
+		"http_active":   strconv.FormatBool(inv.HTTP.Active),
+		"http_port":     inv.HTTP.Port,
+		"country":       inv.Host.Geo.CountryCode,
+		"city":          inv.Host.Geo.City,
+		"asn":           geoip.ASLabel(inv.Host.Geo.ASN),
+	}
+}
+
+// printListening lists the server's listening sockets, marking the ones
+// that are neither SSH nor a beammeup proxy and reachable from outside.
+func printListening(inv hangar.Inventory) {
+	if len(inv.Listening) == 0 {
+		return
+	}
+	fmt.Println("\n[ship-scan] listening ports:")
+	for _, p := range inv.Listening {
+		mark := ""
+		if !p.Known() && !p.Loopback() {
+			mark = "  <- not beammeup"
 		}
+		fmt.Printf("  %-4s %-6d %-18s %s%s\n", p.Proto, p.Port, fallback(p.Process, "?"), p.Addr, mark)
 	}
-	return 18181
 }
 
 func printInventorySummary(inv hangar.Inventory) {
@@ -381,12 +1375,42 @@ func printInventorySummary(inv hangar.Inventory) {
 	if inv.HangarStatus != "" {
 		fmt.Printf("  Hangar: %s\n", inv.HangarStatus)
 	}
+	switch inv.HangarStatus {
+	case hangar.StatusTampered:
+		fmt.Fprintln(os.Stderr, "[beammeup] WARNING: hangar.json on the server was changed or removed outside beammeup. review the server, then run --action configure to re-sign it.")
+	case hangar.StatusUnverified:
+		fmt.Fprintln(os.Stderr, "[beammeup] WARNING: hangar.json on the server was signed by a key this machine does not have, e.g. another machine managing it. run --action configure to sign it here if that is expected.")
+	}
+	if inv.Label != "" {
+		fmt.Printf("  Label: %s\n", inv.Label)
+	}
+	if inv.Description != "" {
+		fmt.Printf("  Description: %s\n", inv.Description)
+	}
+	if change := inv.LastChange.Label(); change != "" {
+		fmt.Printf("  Last change: %s\n", change)
+	}
+	if inv.TrafficCapGB > 0 {
+		fmt.Printf("  Traffic cap: %d GB/month\n", inv.TrafficCapGB)
+	}
+	if inv.QuietHours != "" {
+		fmt.Printf("  Quiet hours: %s\n", hangar.QuietHoursLabel(inv.QuietHours))
+	}
+	if note := expiryNote(inv.ExpiresAt, time.Now()); note != "" {
+		fmt.Printf("  Self-destruct: %s\n", note)
+	}
+	if now := time.Now(); inv.KeptAlive(now) {
+		fmt.Printf("  Smart blinder: kept alive by client (%s ago)\n", now.Sub(inv.SmartBlinderKeepalive).Round(time.Second))
+	}
 	if inv.Socks5.Exists {
 		state := "inactive"
 		if inv.Socks5.Active {
 			state = "active"
 		}
-		fmt.Printf("  SOCKS5: %s, port=%s, user=%s\n", state, fallback(inv.Socks5.Port, "unknown"), fallback(inv.Socks5.User, "unknown"))
+		if inv.Socks5.SocketActivation {
+			state += " (on demand)"
+		}
+		fmt.Printf("  SOCKS5: %s, port=%s, user=%s%s%s%s\n", state, fallback(inv.Socks5.Port, "unknown"), fallback(inv.Socks5.User, "unknown"), egressSuffix(inv.Socks5.EgressIP), limitsSuffix(inv.Socks5), resourcesSuffix(inv.Socks5))
 	} else {
 		fmt.Println("  SOCKS5: not configured")
 	}
@@ -403,10 +1427,66 @@ func printInventorySummary(inv hangar.Inventory) {
 		if strings.TrimSpace(mode) == "" {
 			mode = "managed"
 		}
-		fmt.Printf("  HTTP:   %s, mode=%s, port=%s, user=%s%s\n", state, mode, fallback(inv.HTTP.Port, "unknown"), fallback(inv.HTTP.User, "unknown"), legacy)
+		label := "HTTP:  "
+		if inv.Instance != "" {
+			label = "HTTP [" + inv.Instance + "]:"
+		}
+		fmt.Printf("  %s %s, mode=%s, port=%s, user=%s, headers=%s%s%s%s%s\n", label, state, mode, fallback(inv.HTTP.Port, "unknown"), fallback(inv.HTTP.User, "unknown"), hangar.AnonymityLabel(inv.HTTP.Anonymity), egressSuffix(inv.HTTP.EgressIP), limitsSuffix(inv.HTTP), resourcesSuffix(inv.HTTP), legacy)
+		if inv.HTTP.SquidVersion != "" {
+			fmt.Printf("  HTTP Squid version: %s\n", inv.HTTP.SquidVersion)
+		}
+		if inv.HTTP.GraceUser != "" {
+			fmt.Printf("  HTTP previous user %s accepted until %s\n", inv.HTTP.GraceUser, inv.HTTP.GraceUntil.Local().Format("2006-01-02 15:04"))
+		}
+		if c := inv.HTTPCache; c.SizeMB > 0 {
+			fmt.Printf("  HTTP cache: %d MB at %s, %s used, %s\n", c.SizeMB, fallback(c.Dir, "unknown"), usage.FormatBytes(c.UsedBytes), cacheHitLabel(c))
+		}
+		if len(inv.HTTP.AllowDomains) > 0 || len(inv.HTTP.DenyDomains) > 0 {
+			fmt.Printf("  HTTP destinations: allow=%s, deny=%s\n", hangar.DomainsLabel(inv.HTTP.AllowDomains), fallback(strings.Join(inv.HTTP.DenyDomains, ","), "none"))
+		}
+	} else if inv.Instance != "" {
+		fmt.Printf("  HTTP [%s]: not configured\n", inv.Instance)
 	} else {
 		fmt.Println("  HTTP:   not configured")
 	}
+	for _, in := range inv.HTTPInstances {
+		if in.Name == inv.Instance {
+			continue
+		}
+		state := "inactive"
+		if in.Active {
+			state = "active"
+		}
+		fmt.Printf("  HTTP instance %s: %s, port=%s\n", in.Name, state, fallback(in.Port, "unknown"))
+	}
+}
+
+func cacheHitLabel(c hangar.CacheStats) string {
+	if c.Requests == 0 {
+		return "no cacheable requests yet"
+	}
+	return fmt.Sprintf("%d/%d requests served from cache (%d%%)", c.Hits, c.Requests, c.Hits*100/c.Requests)
+}
+
+func egressSuffix(ip string) string {
+	if ip == "" {
+		return ""
+	}
+	return ", egress=" + ip
+}
+
+func resourcesSuffix(st hangar.ProtocolState) string {
+	if st.MemoryMax == "" && st.CPUQuota == "" && st.TasksMax == 0 {
+		return ""
+	}
+	return ", resources=" + hangar.ResourcesLabel(st.MemoryMax, st.CPUQuota, st.TasksMax)
+}
+
+func limitsSuffix(st hangar.ProtocolState) string {
+	if st.MaxConns == 0 && st.RateLimit == 0 {
+		return ""
+	}
+	return ", limits=" + hangar.LimitsLabel(st.MaxConns, st.RateLimit)
 }
 
 func fallback(v, d string) string {
@@ -416,6 +1496,33 @@ func fallback(v, d string) string {
 	return v
 }
 
+var errDestroyNeedsForce = errors.New("destroy needs the typed confirmation on a terminal; pass --force to run it unattended")
+
+// confirmDestroy gates destructive actions. --yes only skips the yes/no
+// question; the typed DESTROY confirmation is skipped by --force alone, so a
+// recycled command line with --yes cannot wipe a hangar on its own.
+func confirmDestroy(prompt string, opts Options) error {
+	if opts.Force {
+		return nil
+	}
+	if !stdinIsTerminal() {
+		return errDestroyNeedsForce
+	}
+	if !opts.Yes && !confirm(prompt, false) {
+		return errors.New("cancelled")
+	}
+	fmt.Print("Type DESTROY to confirm: ")
+	if strings.TrimSpace(readLine()) != "DESTROY" {
+		return errors.New("cancelled")
+	}
+	return nil
+}
+
+func stdinIsTerminal() bool {
+	fd, err := stdinFD()
+	return err == nil && term.IsTerminal(fd)
+}
+
 func confirm(prompt string, defYes bool) bool {
 	reader := bufio.NewReader(os.Stdin)
 	if defYes {
@@ -438,18 +1545,29 @@ func readLine() string {
 }
 
 func (r *Runner) runStealth(ship ships.Ship, password string, opts Options) (int, error) {
+	cfg, err := tunnelConfig(opts)
+	if err != nil {
+		return ExitUsage, err
+	}
 	localPort := opts.ProxyPort
 	if localPort <= 0 {
 		localPort = 1080
 	}
 	localAddr := fmt.Sprintf("127.0.0.1:%d", localPort)
 
-	target := sshx.Target{
-		Host:     ship.Host,
-		Port:     ship.SSHPort,
-		User:     ship.SSHUser,
-		Password: password,
+	controlPath := ""
+	if dir, err := tunnelRunDir(); err == nil {
+		controlPath = filepath.Join(dir, tunnelName(ship)+".sock")
+	} else if opts.Background {
+		return ExitFailure, err
+	} else {
+		fmt.Fprintf(os.Stderr, "[beammeup] WARNING: tunnel control socket disabled: %v\n", err)
 	}
+	if opts.Background && !isTunnelDaemon() {
+		return startBackgroundTunnel(ship, password, controlPath)
+	}
+
+	target := hangar.ShipTarget(ship, password)
 
 	fmt.Printf("\n[beammeup] stealth mode\n")
 	fmt.Printf("  Server: %s@%s:%d\n", ship.SSHUser, ship.Host, ship.SSHPort)
@@ -459,20 +1577,67 @@ func (r *Runner) runStealth(ship ships.Ship, password string, opts Options) (int
 	fmt.Printf("  curl -x socks5h://%s https://api.ipify.org\n\n", localAddr)
 	fmt.Printf("Press Ctrl+C to stop.\n\n")
 
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
 	logf := func(format string, args ...any) {
 		fmt.Fprintf(os.Stderr, "[stealth] "+format+"\n", args...)
 	}
 
-	if err := tunnel.Run(ctx, target, r.Hangar.SSH, localAddr, logf); err != nil {
+	if r.Events != nil {
+		logf = func(format string, args ...any) {
+			fmt.Fprintf(os.Stderr, "[stealth] "+format+"\n", args...)
+			r.Events.Progress(fmt.Sprintf(format, args...))
+		}
+	}
+	endPhase := r.Events.Phase("tunnel")
+	err = tunnel.Run(ctx, target, r.Hangar.SSH, localAddr, controlPath, cfg, logf)
+	endPhase(err)
+	if err != nil {
 		return ExitFailure, err
 	}
 	fmt.Println("\n[beammeup] stealth tunnel closed.")
+	r.Events.Result(map[string]string{"action": "stealth", "proxy": "socks5://" + localAddr})
 	return ExitSuccess, nil
 }
 
+// tunnelConfig is the local proxy setup of a stealth tunnel.
+func tunnelConfig(opts Options) (tunnel.Config, error) {
+	cfg := tunnel.Config{AllowBind: opts.StealthAllowBind, IdleTimeout: opts.IdleTimeout, MaxConns: opts.TunnelMaxConns}
+	if opts.ConnLog != "" {
+		// Absolute, so the tunnel's log output names the file unambiguously.
+		path, err := filepath.Abs(opts.ConnLog)
+		if err != nil {
+			return tunnel.Config{}, err
+		}
+		cfg.ConnLogPath = path
+	}
+	for _, limit := range []struct {
+		flag, value string
+		dst         *int64
+	}{
+		{"--tunnel-rate", opts.TunnelRate, &cfg.Rate},
+		{"--tunnel-conn-rate", opts.TunnelConnRate, &cfg.ConnRate},
+	} {
+		if limit.value == "" {
+			continue
+		}
+		rate, err := tunnel.ParseRate(limit.value)
+		if err != nil {
+			return tunnel.Config{}, fmt.Errorf("%s: %w", limit.flag, err)
+		}
+		*limit.dst = rate
+	}
+	if opts.RulesFile != "" {
+		rules, err := tunnel.LoadRules(opts.RulesFile)
+		if err != nil {
+			return tunnel.Config{}, err
+		}
+		cfg.Rules = rules
+	}
+	return cfg, nil
+}
+
 func isHTTPSquidConflict(err error) bool {
 	if err == nil {
 		return false
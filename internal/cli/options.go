@@ -2,36 +2,104 @@ package cli
 
 import (
 	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/alfaoz/beammeup/internal/cloudfw"
+	"github.com/alfaoz/beammeup/internal/hangar"
+	"github.com/alfaoz/beammeup/internal/ships"
+	"github.com/alfaoz/beammeup/internal/sshx"
 	"github.com/spf13/pflag"
 )
 
 type Options struct {
 	Host                    string
 	ShipName                string
+	Last                    bool // --last: the ship used most recently
 	ListShips               bool
+	ShipsFrom               string        // file of ship names or host specs to run against in turn; "-" is stdin
+	MaxParallel             int           // --ships-from: ships run at once, each in its own beammeup process above 1
+	Stagger                 time.Duration // --ships-from: least time between two ships starting
+	OnError                 string        // --ships-from: continue, stop or rollback once a ship fails
+	RetryFailed             bool          // run the ships the last --ships-from run did not finish
 	SSHPort                 int
 	SSHUser                 string
 	SSHPassword             string
 	SSHKnownHosts           string
+	SSHOTPCommand           string
+	SSHRetries              int           // extra tries after a connection fails on the way to the server
+	SSHRetryDelay           time.Duration // first wait between tries, doubled each time; 0 keeps the SSH defaults
+	SSHKeepAlive            time.Duration // how often to check the server still answers; 0 disables
+	SSHCiphers              string        // algorithm lists in OpenSSH syntax; empty keeps ~/.ssh/config or the defaults
+	SSHKex                  string
+	SSHMACs                 string
+	SSHHostKeyAlgorithms    string
+	SSHProxy                string // socks5:// or http:// proxy for the SSH connection; "none" ignores BEAMMEUP_SSH_PROXY
+	RemoteTmpDir            string
 	StrictHostKey           bool
 	InsecureHostKey         bool
+	HashKnownHosts          bool
 	Protocol                string
 	HTTPMode                string
+	HTTPConflict            string // adopt|replace|sidecar|abort; empty asks on a terminal
 	ProxyPort               int
 	Action                  string
 	ShowInventory           bool
+	ReadOnly                bool
 	PreflightOnly           bool
 	NoFirewallChange        bool
 	ListenLocal             bool
 	SmartBlinder            bool
 	SmartBlinderIdleMinutes int
+	TrafficCapGB            int
+	QuietHours              string
+	RotateScope             string
+	RotateGrace             time.Duration
+	RefreshClients          bool // rotate: rewrite the local proxy settings that carry the old credentials
+	SecurityOnly            bool
+	RebootTimeout           time.Duration
+	PhaseTimeout            time.Duration // apply: limit per step on the server; 0 keeps the script's default
+	RollbackTo              string        // --action rollback: snapshot to restore, "latest" for the newest; empty lists them
+	NoVerify                bool          // apply: skip the proxied test request from the server
+	EventsJSON              string        // stderr or a file descriptor number; empty disables the event stream
+	Output                  string        // text, or json: one result object per ship on stdout, everything else on stderr
+	MaxConns                int
+	RateLimit               int
+	MemoryMax               string
+	CPUQuota                string
+	TasksMax                int
+	EgressIP                string
+	SocketActivation        bool
+	MicrosocksBinary        string // local static microsocks to upload where the server has no package
+	Instance                string
+	CacheMB                 int
+	CacheDir                string
+	Anonymity               string
+	AllowDomains            []string
+	DenyDomains             []string
+	CloudFirewall           string // hetzner or digitalocean; "" with CloudFirewallSet turns it off
+	TTL                     time.Duration
+	Label                   string // hangar label for hangar.json; "none" clears it
+	Description             string // hangar description for hangar.json; "none" clears it
 	Stealth                 bool
+	Background              bool
+	StealthAllowBind        bool
+	RulesFile               string        // --stealth: per-destination routing rules
+	ConnLog                 string        // --stealth: JSONL connection log; empty disables it
+	IdleTimeout             time.Duration // --stealth: close connections idle this long; 0 keeps the default
+	TunnelMaxConns          int           // --stealth: connections served at once; 0 keeps the default
+	TunnelRate              string        // --stealth: bandwidth cap for the whole tunnel, e.g. 2M
+	TunnelConnRate          string        // --stealth: bandwidth cap per connection
 	SelfUpdate              bool
 	AutoUpdate              bool
 	BaseURL                 string
 	VersionOnly             bool
 	Yes                     bool
+	Force                   bool
+	BreakLock               bool
 	Help                    bool
 	RawArgs                 []string
 
@@ -39,12 +107,33 @@ type Options struct {
 	ListenLocalSet         bool
 	SmartBlinderSet        bool
 	SmartBlinderIdleMinSet bool
+	TrafficCapSet          bool
+	QuietHoursSet          bool
+	LimitsSet              bool
+	ResourcesSet           bool
+	EgressIPSet            bool
+	SocketActivationSet    bool
+	InstanceSet            bool
+	CacheSet               bool
+	AnonymitySet           bool
+	AllowDomainsSet        bool
+	DenyDomainsSet         bool
+	CloudFirewallSet       bool
+	TTLSet                 bool
+	LabelSet               bool
+	DescriptionSet         bool
 }
 
 func DefaultOptions() Options {
+	ssh := sshx.DefaultConnectOptions()
 	return Options{
 		SSHPort:                 22,
 		SSHUser:                 "root",
+		MaxParallel:             1,
+		OnError:                 "continue",
+		SSHRetries:              ssh.Retries,
+		SSHRetryDelay:           ssh.RetryDelay,
+		SSHKeepAlive:            ssh.KeepAlive,
 		BaseURL:                 "https://beammeup.pw",
 		Protocol:                "",
 		Action:                  "",
@@ -58,42 +147,200 @@ func Parse(args []string) (Options, error) {
 	fs := pflag.NewFlagSet("beammeup", pflag.ContinueOnError)
 	fs.SetInterspersed(false)
 
-	fs.StringVar(&opts.Host, "host", opts.Host, "Server host or IP")
-	fs.StringVar(&opts.ShipName, "ship", opts.ShipName, "Use saved ship profile")
+	addTargetFlags(fs, &opts)
 	fs.BoolVar(&opts.ListShips, "list-ships", false, "List saved ships")
-	fs.IntVar(&opts.SSHPort, "ssh-port", opts.SSHPort, "SSH port")
-	fs.StringVar(&opts.SSHUser, "ssh-user", opts.SSHUser, "SSH user")
-	fs.StringVar(&opts.SSHPassword, "ssh-password", "", "SSH password")
-	fs.StringVar(&opts.SSHKnownHosts, "ssh-known-hosts", "", "SSH known_hosts file path")
-	fs.BoolVar(&opts.StrictHostKey, "strict-host-key", false, "Require known SSH host key (no TOFU)")
-	fs.BoolVar(&opts.InsecureHostKey, "insecure-ignore-host-key", false, "Disable SSH host key verification (UNSAFE)")
+	fs.StringVar(&opts.ShipsFrom, "ships-from", "", "Run against every ship or [user@]host[:port] listed in this file, one per line (- reads stdin)")
+	fs.IntVar(&opts.MaxParallel, "max-parallel", opts.MaxParallel, "With --ships-from, run this many ships at once")
+	fs.DurationVar(&opts.Stagger, "stagger", 0, "With --ships-from, wait at least this long between starting two ships (e.g. 5s)")
+	fs.StringVar(&opts.OnError, "on-error", opts.OnError, "With --ships-from, once a ship fails: continue, stop starting ships, or rollback the ones configured")
+	fs.BoolVar(&opts.RetryFailed, "retry-failed", false, "Run again against the ships the last --ships-from run did not finish")
 	fs.StringVar(&opts.Protocol, "protocol", "", "http or socks5")
 	fs.StringVar(&opts.HTTPMode, "http-mode", "", "auto or sidecar")
+	fs.StringVar(&opts.HTTPConflict, "http-conflict", "", "What to do about a Squid config beammeup did not write: adopt, replace, sidecar or abort")
 	fs.IntVar(&opts.ProxyPort, "proxy-port", 0, "Proxy port")
-	fs.StringVar(&opts.Action, "action", "", "show|configure|rotate|destroy")
+	fs.StringVar(&opts.Action, "action", "", "show|configure|rotate|destroy|os-update|reboot")
 	fs.BoolVar(&opts.ShowInventory, "show-inventory", false, "Show inventory")
 	fs.BoolVar(&opts.PreflightOnly, "preflight-only", false, "Preflight only")
 	fs.BoolVar(&opts.NoFirewallChange, "no-firewall-change", false, "Skip firewall changes")
 	fs.BoolVar(&opts.Stealth, "stealth", false, "Stealth mode: local SOCKS5 proxy via SSH tunnel, zero remote footprint")
+	fs.BoolVar(&opts.Background, "background", false, "With --stealth: detach the tunnel (control it with beammeup tunnel)")
+	fs.StringVar(&opts.RulesFile, "rules", "", "With --stealth: routing rules file sending destinations through the tunnel, direct, or nowhere")
+	fs.StringVar(&opts.ConnLog, "conn-log", "", "With --stealth: append a JSON line per connection (time, destination, bytes, duration) to this file")
+	fs.DurationVar(&opts.IdleTimeout, "idle-timeout", 0, "With --stealth: close connections that carry no traffic for this long (default 15m)")
+	fs.IntVar(&opts.TunnelMaxConns, "tunnel-max-conns", 0, "With --stealth: connections the local tunnel serves at once, each an SSH channel (default 256)")
+	fs.StringVar(&opts.TunnelRate, "tunnel-rate", "", "With --stealth: cap the tunnel's bandwidth in each direction, in bytes per second (e.g. 2M)")
+	fs.StringVar(&opts.TunnelConnRate, "tunnel-conn-rate", "", "With --stealth: cap each connection's bandwidth in each direction, in bytes per second (e.g. 500k)")
+	fs.BoolVar(&opts.StealthAllowBind, "allow-bind", false, "With --stealth: accept SOCKS5 BIND (incoming connections on the server, e.g. active FTP)")
 	fs.BoolVar(&opts.ListenLocal, "listen-local", opts.ListenLocal, "Bind proxy to localhost on server (requires SSH tunnel)")
 	fs.BoolVar(&opts.SmartBlinder, "smart-blinder", opts.SmartBlinder, "Smart blinder: stop proxy after idle (recommended)")
 	fs.IntVar(&opts.SmartBlinderIdleMinutes, "smart-blinder-idle-minutes", opts.SmartBlinderIdleMinutes, "Smart blinder idle minutes (default: 10)")
+	fs.IntVar(&opts.TrafficCapGB, "traffic-cap-gb", 0, "Pause the proxy on the server after this many GB per month (0 disables)")
+	fs.StringVar(&opts.QuietHours, "quiet-hours", "", "Stop the proxy on the server during these UTC windows, e.g. 00:00-06:00 (\"off\" clears)")
+	fs.IntVar(&opts.MaxConns, "max-conns", 0, "Concurrent connections the proxy credential may hold (0 removes the limit)")
+	fs.IntVar(&opts.RateLimit, "rate-limit", 0, "New connections per minute on the proxy credential (0 removes the limit)")
+	fs.StringVar(&opts.MemoryMax, "memory-max", "", "Cap the proxy unit's memory on the server, e.g. 256M (0 removes the cap)")
+	fs.StringVar(&opts.CPUQuota, "cpu-quota", "", "Cap the proxy unit's CPU on the server, e.g. 50% (0 removes the cap)")
+	fs.IntVar(&opts.TasksMax, "tasks-max", 0, "Cap the proxy unit's processes and threads on the server (0 removes the cap)")
+	fs.StringVar(&opts.RotateScope, "rotate-scope", "", "With --action rotate: password (keep the username) or both (default)")
+	fs.DurationVar(&opts.RotateGrace, "rotate-grace", 0, "With --action rotate on HTTP: keep the old credentials working this long (e.g. 24h)")
+	fs.BoolVar(&opts.RefreshClients, "refresh-clients", false, "With --action rotate: rewrite the git/npm/pip and system proxy settings that still use the old credentials")
+	fs.BoolVar(&opts.SecurityOnly, "security-only", false, "With --action os-update: install security updates only")
+	fs.DurationVar(&opts.RebootTimeout, "reboot-timeout", 0, "With --action reboot: how long to wait for SSH to come back (default 5m)")
+	fs.StringVar(&opts.RollbackTo, "to", "", "With --action rollback: the snapshot to restore, or latest (run without --to to list them)")
+	fs.BoolVar(&opts.NoVerify, "no-verify", false, "With configure or rotate: do not require a test request through the new proxy to succeed, nor send one from here")
+	fs.DurationVar(&opts.PhaseTimeout, "phase-timeout", 0, "With configure or rotate: time limit for each step on the server before the run is rolled back (default 10m)")
+	fs.StringVar(&opts.EventsJSON, "events-json", "", "Write line-delimited JSON progress events to stderr or the given file descriptor (e.g. 3)")
+	fs.Lookup("events-json").NoOptDefVal = "stderr"
+	fs.StringVar(&opts.Output, "output", "text", "text, or json for one JSON result per ship (and one for a --ships-from run) on stdout, with the rest on stderr")
+	fs.StringVar(&opts.EgressIP, "egress-ip", "", "Source IP for the proxy's outbound traffic on multi-IP servers (default: the server's main route)")
+	fs.BoolVar(&opts.SocketActivation, "socket-activation", false, "SOCKS5 only: let systemd start microsocks when a client connects and stop it when idle")
+	fs.StringVar(&opts.MicrosocksBinary, "microsocks-binary", "", "SOCKS5 only: static microsocks binary to upload when the server has no microsocks package")
+	fs.StringVar(&opts.Instance, "instance", "", "Named HTTP sidecar instance, for several hangars on one server (\"default\" for the main one)")
+	fs.IntVar(&opts.CacheMB, "cache-mb", 0, "HTTP only: cache downloads on the server in a disk cache of this many MB (0 disables)")
+	fs.StringVar(&opts.CacheDir, "cache-dir", "", "HTTP only: directory on the server for the cache (default: chosen by beammeup)")
+	fs.StringVar(&opts.Anonymity, "anonymity", "", "HTTP only: headers that reveal the client: transparent, anonymous or elite (default)")
+	var allowDomains, denyDomains string
+	fs.StringVar(&allowDomains, "allow-domains", "", "HTTP only: comma list or file of the only destination domains the proxy reaches (\"none\" clears)")
+	fs.StringVar(&denyDomains, "deny-domains", "", "HTTP only: comma list or file of destination domains the proxy refuses (\"none\" clears)")
+	fs.StringVar(&opts.CloudFirewall, "cloud-firewall", "", "Open the proxy port in the provider's cloud firewall: hetzner or digitalocean (\"off\" stops)")
+	fs.DurationVar(&opts.TTL, "ttl", 0, "Destroy the hangar on the server after this long (e.g. 4h; 0 cancels)")
+	fs.StringVar(&opts.Label, "label", "", "Name the hangar in the server's hangar.json, e.g. \"marketing team proxy\" (\"none\" clears)")
+	fs.StringVar(&opts.Description, "description", "", "Describe the hangar in the server's hangar.json, e.g. who to contact (\"none\" clears)")
 	fs.BoolVar(&opts.SelfUpdate, "self-update", false, "Self update")
 	fs.BoolVar(&opts.AutoUpdate, "auto-update", false, "Auto update")
 	fs.StringVar(&opts.BaseURL, "base-url", opts.BaseURL, "Release base URL")
 	fs.BoolVar(&opts.VersionOnly, "version", false, "Print version")
-	fs.BoolVar(&opts.Yes, "yes", false, "Skip confirmations")
+	fs.BoolVar(&opts.Force, "force", false, "Skip the typed confirmation for destructive actions")
+	fs.BoolVar(&opts.BreakLock, "break-lock", false, "Take over a stuck lock left on the server by another beammeup run")
 	fs.BoolVarP(&opts.Help, "help", "h", false, "Show help")
 
 	if err := fs.Parse(args); err != nil {
 		return opts, err
 	}
-	if opts.StrictHostKey && opts.InsecureHostKey {
-		return opts, fmt.Errorf("use either --strict-host-key or --insecure-ignore-host-key, not both")
+	if err := validateTargetFlags(opts); err != nil {
+		return opts, err
 	}
 	opts.ListenLocalSet = fs.Changed("listen-local")
 	opts.SmartBlinderSet = fs.Changed("smart-blinder")
 	opts.SmartBlinderIdleMinSet = fs.Changed("smart-blinder-idle-minutes")
+	opts.TrafficCapSet = fs.Changed("traffic-cap-gb")
+	if opts.TrafficCapGB < 0 {
+		return opts, fmt.Errorf("--traffic-cap-gb must be >= 0")
+	}
+	opts.QuietHoursSet = fs.Changed("quiet-hours")
+	opts.QuietHours = strings.TrimSpace(opts.QuietHours)
+	if strings.EqualFold(opts.QuietHours, "off") || strings.EqualFold(opts.QuietHours, "none") {
+		opts.QuietHours = ""
+	}
+	if opts.QuietHours != "" {
+		quiet, err := hangar.NormalizeQuietHours(opts.QuietHours)
+		if err != nil {
+			return opts, fmt.Errorf("--quiet-hours: %w", err)
+		}
+		opts.QuietHours = quiet
+	}
+	opts.LabelSet = fs.Changed("label")
+	opts.DescriptionSet = fs.Changed("description")
+	for _, info := range []struct {
+		flag string
+		val  *string
+	}{{"label", &opts.Label}, {"description", &opts.Description}} {
+		*info.val = strings.TrimSpace(*info.val)
+		if strings.EqualFold(*info.val, "none") {
+			*info.val = "none"
+		}
+		if err := hangar.CheckHangarInfo(*info.val); err != nil {
+			return opts, fmt.Errorf("--%s %w", info.flag, err)
+		}
+	}
+	opts.LimitsSet = fs.Changed("max-conns") || fs.Changed("rate-limit")
+	if opts.MaxConns < 0 {
+		return opts, fmt.Errorf("--max-conns must be >= 0")
+	}
+	if opts.RateLimit < 0 || opts.RateLimit > 10000 {
+		return opts, fmt.Errorf("--rate-limit must be between 0 and 10000")
+	}
+	opts.ResourcesSet = fs.Changed("memory-max") || fs.Changed("cpu-quota") || fs.Changed("tasks-max")
+	var err error
+	if opts.MemoryMax, err = normalizeMemoryMax(opts.MemoryMax); err != nil {
+		return opts, err
+	}
+	if opts.CPUQuota, err = normalizeCPUQuota(opts.CPUQuota); err != nil {
+		return opts, err
+	}
+	if opts.TasksMax < 0 {
+		return opts, fmt.Errorf("--tasks-max must be >= 0")
+	}
+	opts.RotateScope = strings.ToLower(strings.TrimSpace(opts.RotateScope))
+	if opts.RotateScope != "" && opts.RotateScope != "password" && opts.RotateScope != "both" {
+		return opts, fmt.Errorf("--rotate-scope must be password or both")
+	}
+	if opts.RotateGrace < 0 || (opts.RotateGrace > 0 && opts.RotateGrace < time.Minute) {
+		return opts, fmt.Errorf("--rotate-grace must be at least 1m")
+	}
+	if opts.RebootTimeout < 0 {
+		return opts, fmt.Errorf("--reboot-timeout must be >= 0")
+	}
+	if opts.PhaseTimeout < 0 || (opts.PhaseTimeout > 0 && opts.PhaseTimeout < 10*time.Second) {
+		return opts, fmt.Errorf("--phase-timeout must be at least 10s")
+	}
+	if opts.RotateGrace > 0 && opts.RotateScope == "password" {
+		return opts, fmt.Errorf("--rotate-grace needs a new username; drop --rotate-scope password")
+	}
+	opts.EgressIPSet = fs.Changed("egress-ip")
+	opts.SocketActivationSet = fs.Changed("socket-activation")
+	opts.EgressIP = strings.TrimSpace(opts.EgressIP)
+	if strings.EqualFold(opts.EgressIP, "default") {
+		opts.EgressIP = ""
+	}
+	if opts.EgressIP != "" && net.ParseIP(opts.EgressIP) == nil {
+		return opts, fmt.Errorf("--egress-ip must be an IP address (or \"default\")")
+	}
+	opts.InstanceSet = fs.Changed("instance")
+	opts.Instance = strings.ToLower(strings.TrimSpace(opts.Instance))
+	if opts.Instance == "default" {
+		opts.Instance = ""
+	}
+	if opts.Instance != "" && !ships.ValidInstanceName(opts.Instance) {
+		return opts, fmt.Errorf("--instance must be 1-32 characters of a-z, 0-9 and '-'")
+	}
+	opts.CacheSet = fs.Changed("cache-mb") || fs.Changed("cache-dir")
+	if opts.CacheMB < 0 {
+		return opts, fmt.Errorf("--cache-mb must be >= 0")
+	}
+	opts.CacheDir = strings.TrimSpace(opts.CacheDir)
+	if opts.CacheDir != "" && !strings.HasPrefix(opts.CacheDir, "/") {
+		return opts, fmt.Errorf("--cache-dir must be an absolute path on the server")
+	}
+	opts.AnonymitySet = fs.Changed("anonymity")
+	opts.Anonymity = strings.ToLower(strings.TrimSpace(opts.Anonymity))
+	switch opts.Anonymity {
+	case "", "transparent", "anonymous":
+	case "elite":
+		opts.Anonymity = ""
+	default:
+		return opts, fmt.Errorf("--anonymity must be transparent, anonymous or elite")
+	}
+	opts.AllowDomainsSet = fs.Changed("allow-domains")
+	opts.DenyDomainsSet = fs.Changed("deny-domains")
+	if opts.AllowDomains, err = parseDomainList("--allow-domains", allowDomains); err != nil {
+		return opts, err
+	}
+	if opts.DenyDomains, err = parseDomainList("--deny-domains", denyDomains); err != nil {
+		return opts, err
+	}
+	opts.CloudFirewallSet = fs.Changed("cloud-firewall")
+	opts.CloudFirewall = strings.ToLower(strings.TrimSpace(opts.CloudFirewall))
+	if opts.CloudFirewall == "off" || opts.CloudFirewall == "none" {
+		opts.CloudFirewall = ""
+	}
+	if opts.CloudFirewall != "" && !cloudfw.ValidProvider(opts.CloudFirewall) {
+		return opts, fmt.Errorf("--cloud-firewall must be %s or off", strings.Join(cloudfw.Providers, ", "))
+	}
+	opts.TTLSet = fs.Changed("ttl")
+	if opts.TTL < 0 || (opts.TTL > 0 && opts.TTL < time.Minute) {
+		return opts, fmt.Errorf("--ttl must be at least 1m (or 0 to cancel)")
+	}
 	if opts.SmartBlinder && opts.SmartBlinderIdleMinutes <= 0 {
 		return opts, fmt.Errorf("--smart-blinder-idle-minutes must be > 0")
 	}
@@ -105,21 +352,137 @@ func Parse(args []string) (Options, error) {
 	return opts, nil
 }
 
+// addTargetFlags registers the flags that select a ship and control how
+// beammeup connects to it. Subcommands share them with the main flag set.
+func addTargetFlags(fs *pflag.FlagSet, opts *Options) {
+	fs.StringVar(&opts.Host, "host", opts.Host, "Server host or IP")
+	fs.StringVar(&opts.ShipName, "ship", opts.ShipName, "Use saved ship profile")
+	fs.BoolVar(&opts.Last, "last", opts.Last, "Use the ship used most recently")
+	fs.IntVar(&opts.SSHPort, "ssh-port", opts.SSHPort, "SSH port")
+	fs.StringVar(&opts.SSHUser, "ssh-user", opts.SSHUser, "SSH user")
+	fs.StringVar(&opts.SSHPassword, "ssh-password", "", "SSH password")
+	fs.StringVar(&opts.SSHKnownHosts, "ssh-known-hosts", "", "SSH known_hosts file path")
+	fs.StringVar(&opts.SSHOTPCommand, "ssh-otp-command", "", "Command printing the one-time code the SSH server asks for (keyboard-interactive)")
+	fs.IntVar(&opts.SSHRetries, "ssh-retries", opts.SSHRetries, "Extra tries when the SSH connection fails on the way (network blip, sshd restarting)")
+	fs.DurationVar(&opts.SSHRetryDelay, "ssh-retry-delay", opts.SSHRetryDelay, "Wait before the first SSH retry, doubled for each one after")
+	fs.DurationVar(&opts.SSHKeepAlive, "ssh-keepalive", opts.SSHKeepAlive, "Send an SSH keepalive this often so idle NAT state survives long steps (0 disables)")
+	fs.StringVar(&opts.SSHCiphers, "ssh-ciphers", "", "SSH ciphers to offer, as in OpenSSH's Ciphers (+add, -remove, ^first) or modern")
+	fs.StringVar(&opts.SSHKex, "ssh-kex", "", "SSH key exchanges to offer, as in OpenSSH's KexAlgorithms or modern")
+	fs.StringVar(&opts.SSHMACs, "ssh-macs", "", "SSH MACs to offer, as in OpenSSH's MACs or modern")
+	fs.StringVar(&opts.SSHHostKeyAlgorithms, "ssh-hostkey-algorithms", "", "SSH host key algorithms to accept, as in OpenSSH's HostKeyAlgorithms or modern")
+	fs.StringVar(&opts.SSHProxy, "ssh-proxy", "", "Reach the SSH server through this proxy: socks5://[user:pass@]host:port or http://[user:pass@]host:port (CONNECT)")
+	fs.StringVar(&opts.RemoteTmpDir, "remote-tmpdir", "", "Temp directory on the server for install logs (default: /tmp)")
+	fs.BoolVar(&opts.StrictHostKey, "strict-host-key", false, "Require known SSH host key (no TOFU)")
+	fs.BoolVar(&opts.InsecureHostKey, "insecure-ignore-host-key", false, "Disable SSH host key verification (UNSAFE)")
+	fs.BoolVar(&opts.HashKnownHosts, "hash-known-hosts", false, "Record new SSH host keys under hashed host names, like OpenSSH's HashKnownHosts")
+	fs.BoolVar(&opts.ReadOnly, "read-only", opts.ReadOnly, "Refuse anything that would change the server")
+	fs.BoolVar(&opts.Yes, "yes", opts.Yes, "Skip routine confirmations, including trusting a server's first SSH host key")
+}
+
+func validateTargetFlags(opts Options) error {
+	if opts.Last && (opts.ShipName != "" || opts.Host != "") {
+		return fmt.Errorf("use either --last or --ship/--host, not both")
+	}
+	if opts.StrictHostKey && opts.InsecureHostKey {
+		return fmt.Errorf("use either --strict-host-key or --insecure-ignore-host-key, not both")
+	}
+	if opts.SSHRetries < 0 {
+		return fmt.Errorf("--ssh-retries must be >= 0")
+	}
+	if opts.SSHRetryDelay < 0 {
+		return fmt.Errorf("--ssh-retry-delay must be positive")
+	}
+	if opts.SSHKeepAlive < 0 {
+		return fmt.Errorf("--ssh-keepalive must not be negative")
+	}
+	for _, a := range []struct {
+		flag, spec string
+		kind       sshx.AlgorithmKind
+	}{
+		{"--ssh-ciphers", opts.SSHCiphers, sshx.AlgCiphers},
+		{"--ssh-kex", opts.SSHKex, sshx.AlgKeyExchanges},
+		{"--ssh-macs", opts.SSHMACs, sshx.AlgMACs},
+		{"--ssh-hostkey-algorithms", opts.SSHHostKeyAlgorithms, sshx.AlgHostKeys},
+	} {
+		if strings.TrimSpace(a.spec) == "" {
+			continue
+		}
+		if err := sshx.CheckAlgorithms(a.kind, a.spec); err != nil {
+			return fmt.Errorf("%s: %w", a.flag, err)
+		}
+	}
+	if v := strings.TrimSpace(opts.SSHProxy); v != "" && v != "none" {
+		if _, err := sshx.ParseProxy(v); err != nil {
+			return fmt.Errorf("--ssh-proxy: %w", err)
+		}
+	}
+	if dir := strings.TrimSpace(opts.RemoteTmpDir); dir != "" && !strings.HasPrefix(dir, "/") {
+		return fmt.Errorf("--remote-tmpdir must be an absolute path")
+	}
+	return nil
+}
+
+// SSHOptions derives the SSH connection options from the parsed flags,
+// starting from the environment-aware defaults.
+func SSHOptions(opts Options) sshx.ConnectOptions {
+	sshOpts := sshx.DefaultConnectOptions()
+	if strings.TrimSpace(opts.SSHKnownHosts) != "" {
+		sshOpts.KnownHostsPath = strings.TrimSpace(opts.SSHKnownHosts)
+	}
+	if strings.TrimSpace(opts.SSHOTPCommand) != "" {
+		sshOpts.OTPCommand = strings.TrimSpace(opts.SSHOTPCommand)
+	}
+	if opts.StrictHostKey {
+		sshOpts.HostKeyMode = sshx.HostKeyStrict
+	}
+	if opts.InsecureHostKey {
+		sshOpts.HostKeyMode = sshx.HostKeyInsecureIgnore
+	}
+	if opts.HashKnownHosts {
+		sshOpts.HashKnownHosts = true
+	}
+	if opts.SSHRetryDelay > 0 {
+		sshOpts.Retries, sshOpts.RetryDelay = opts.SSHRetries, opts.SSHRetryDelay
+	}
+	sshOpts.KeepAlive = opts.SSHKeepAlive
+	if v := strings.TrimSpace(opts.SSHCiphers); v != "" {
+		sshOpts.Ciphers = v
+	}
+	if v := strings.TrimSpace(opts.SSHKex); v != "" {
+		sshOpts.KeyExchanges = v
+	}
+	if v := strings.TrimSpace(opts.SSHMACs); v != "" {
+		sshOpts.MACs = v
+	}
+	if v := strings.TrimSpace(opts.SSHHostKeyAlgorithms); v != "" {
+		sshOpts.HostKeyAlgorithms = v
+	}
+	switch v := strings.TrimSpace(opts.SSHProxy); v {
+	case "":
+	case "none":
+		sshOpts.Proxy = ""
+	default:
+		sshOpts.Proxy = v
+	}
+	return sshOpts
+}
+
 func NormalizeProtocol(v string) (string, bool) {
 	switch v {
-	case "", "http", "socks5", "socks":
-		if v == "socks" {
-			return "socks5", true
-		}
+	case "":
 		return v, true
-	default:
-		return "", false
+	case "socks":
+		return "socks5", true
 	}
+	if _, ok := hangar.LookupProtocol(v); ok {
+		return v, true
+	}
+	return "", false
 }
 
 func NormalizeAction(v string) (string, bool) {
 	switch v {
-	case "", "show", "configure", "rotate", "destroy", "install", "uninstall":
+	case "", "show", "status", "configure", "rotate", "destroy", "os-update", "reboot", "rollback", "install", "uninstall":
 		if v == "install" {
 			return "configure", true
 		}
@@ -143,3 +506,66 @@ func NormalizeHTTPMode(v string) (string, bool) {
 		return "", false
 	}
 }
+
+// parseDomainList reads a --allow-domains/--deny-domains value. A path to a
+// local file is read one domain per line (# starts a comment); anything else
+// is a comma list. "none" clears the list.
+// normalizeMemoryMax turns --memory-max into a systemd MemoryMax value:
+// a number of bytes with an optional K, M, G or T suffix. "0" and "off"
+// clear the cap.
+func normalizeMemoryMax(v string) (string, error) {
+	v = strings.ToUpper(strings.TrimSpace(v))
+	v = strings.TrimSuffix(strings.TrimSuffix(v, "B"), "I")
+	if v == "" || v == "0" || v == "OFF" {
+		return "", nil
+	}
+	num := strings.TrimRight(v, "KMGT")
+	if len(v)-len(num) > 1 || num == "" || strings.HasPrefix(num, "0") || strings.Trim(num, "0123456789") != "" {
+		return "", fmt.Errorf("--memory-max must be a size like 256M or 1G (or 0)")
+	}
+	return v, nil
+}
+
+// normalizeCPUQuota turns --cpu-quota into a systemd CPUQuota percentage;
+// 100% is one full core. "0" and "off" clear the cap.
+func normalizeCPUQuota(v string) (string, error) {
+	v = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(v)), "%")
+	if v == "" || v == "0" || v == "off" {
+		return "", nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return "", fmt.Errorf("--cpu-quota must be a percentage like 50%% (or 0)")
+	}
+	return strconv.Itoa(n) + "%", nil
+}
+
+func parseDomainList(flag, raw string) ([]string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || strings.EqualFold(raw, "none") {
+		return nil, nil
+	}
+	var items []string
+	if st, err := os.Stat(raw); err == nil && st.Mode().IsRegular() {
+		data, err := os.ReadFile(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", flag, err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if i := strings.IndexByte(line, '#'); i >= 0 {
+				line = line[:i]
+			}
+			items = append(items, strings.Fields(line)...)
+		}
+	} else {
+		items = strings.Split(raw, ",")
+	}
+	domains, err := hangar.NormalizeDomains(items)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", flag, err)
+	}
+	if len(domains) == 0 {
+		return nil, fmt.Errorf("%s lists no domains (use \"none\" to clear)", flag)
+	}
+	return domains, nil
+}
@@ -26,11 +26,15 @@ func TestNormalizeProtocol(t *testing.T) {
 func TestNormalizeAction(t *testing.T) {
 	cases := map[string]string{
 		"show":      "show",
+		"status":    "status",
 		"configure": "configure",
 		"rotate":    "rotate",
 		"destroy":   "destroy",
 		"install":   "configure",
 		"uninstall": "destroy",
+		"os-update": "os-update",
+		"reboot":    "reboot",
+		"rollback":  "rollback",
 		"":          "",
 	}
 	for in, want := range cases {
@@ -66,3 +70,78 @@ func TestNormalizeHTTPMode(t *testing.T) {
 		t.Fatal("expected invalid mode")
 	}
 }
+
+func TestParseTTL(t *testing.T) {
+	opts, err := Parse([]string{"--ship", "edge", "--ttl", "4h"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if !opts.TTLSet || opts.TTL.Hours() != 4 {
+		t.Fatalf("unexpected ttl: set=%v ttl=%v", opts.TTLSet, opts.TTL)
+	}
+	opts, err = Parse([]string{"--ship", "edge", "--ttl", "0"})
+	if err != nil || !opts.TTLSet || opts.TTL != 0 {
+		t.Fatalf("expected --ttl 0 to cancel, got set=%v ttl=%v err=%v", opts.TTLSet, opts.TTL, err)
+	}
+	if _, err := Parse([]string{"--ttl", "30s"}); err == nil {
+		t.Fatal("expected sub-minute ttl to be rejected")
+	}
+}
+
+func TestParseResources(t *testing.T) {
+	opts, err := Parse([]string{"--ship", "edge", "--memory-max", "256mb", "--cpu-quota", "50"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if !opts.ResourcesSet || opts.MemoryMax != "256M" || opts.CPUQuota != "50%" || opts.TasksMax != 0 {
+		t.Fatalf("unexpected resources: set=%v memory=%q cpu=%q tasks=%d", opts.ResourcesSet, opts.MemoryMax, opts.CPUQuota, opts.TasksMax)
+	}
+	opts, err = Parse([]string{"--ship", "edge", "--memory-max", "0", "--cpu-quota", "off"})
+	if err != nil || opts.MemoryMax != "" || opts.CPUQuota != "" {
+		t.Fatalf("expected caps to clear, got memory=%q cpu=%q err=%v", opts.MemoryMax, opts.CPUQuota, err)
+	}
+	for _, bad := range [][]string{{"--memory-max", "lots"}, {"--memory-max", "1.5G"}, {"--cpu-quota", "-5%"}, {"--tasks-max", "-1"}} {
+		if _, err := Parse(bad); err == nil {
+			t.Fatalf("expected %v to be rejected", bad)
+		}
+	}
+}
+
+func TestParseRemoteTmpDir(t *testing.T) {
+	opts, err := Parse([]string{"--ship", "edge", "--remote-tmpdir", "/var/tmp/beammeup"})
+	if err != nil || opts.RemoteTmpDir != "/var/tmp/beammeup" {
+		t.Fatalf("unexpected remote tmpdir: %q err=%v", opts.RemoteTmpDir, err)
+	}
+	if _, err := Parse([]string{"--remote-tmpdir", "tmp"}); err == nil {
+		t.Fatal("expected relative --remote-tmpdir to be rejected")
+	}
+}
+
+func TestConfirmDestroyRequiresForceWithoutTerminal(t *testing.T) {
+	// go test runs without a terminal on stdin.
+	if err := confirmDestroy("Destroy?", Options{Yes: true}); err != errDestroyNeedsForce {
+		t.Fatalf("expected --yes alone to be refused, got %v", err)
+	}
+	if err := confirmDestroy("Destroy?", Options{Force: true}); err != nil {
+		t.Fatalf("expected --force to skip confirmation, got %v", err)
+	}
+}
+
+func TestExtractProfile(t *testing.T) {
+	name, rest, err := ExtractProfile([]string{"exec", "--profile", "work", "--ship", "a", "--", "echo", "--profile=x"})
+	if err != nil || name != "work" {
+		t.Fatalf("ExtractProfile = %q, %v", name, err)
+	}
+	if len(rest) != 6 || rest[0] != "exec" || rest[3] != "--" || rest[5] != "--profile=x" {
+		t.Fatalf("rest = %v", rest)
+	}
+	if name, _, err := ExtractProfile([]string{"--profile=default"}); err != nil || name != "default" {
+		t.Fatalf("default profile = %q, %v", name, err)
+	}
+	if _, _, err := ExtractProfile([]string{"--profile", "../x"}); err == nil {
+		t.Fatal("expected an invalid profile name to be rejected")
+	}
+	if _, _, err := ExtractProfile([]string{"--profile"}); err == nil {
+		t.Fatal("expected a missing profile name to be rejected")
+	}
+}
@@ -0,0 +1,69 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/alfaoz/beammeup/internal/ships"
+)
+
+// runOSUpdate is --action os-update: upgrade the server's packages with the
+// package manager's output streamed to the terminal.
+func (r *Runner) runOSUpdate(ship ships.Ship, password string, opts Options) (int, error) {
+	what := "all packages"
+	if opts.SecurityOnly {
+		what = "security updates"
+	}
+	if !opts.Yes && stdinIsTerminal() && !confirm(fmt.Sprintf("Install %s on %s? Services may restart.", what, ship.Host), true) {
+		return ExitFailure, errors.New("cancelled")
+	}
+
+	fmt.Printf("\n[beammeup] installing %s on %s...\n", what, ship.Host)
+	progress := io.Writer(os.Stderr)
+	if w := r.Events.LogWriter(); w != nil {
+		progress = io.MultiWriter(os.Stderr, w)
+	}
+	endPhase := r.Events.Phase("os-update")
+	res, err := r.Hangar.OSUpdate(ship, password, opts.SecurityOnly, progress)
+	endPhase(err)
+	if err != nil {
+		return ExitFailure, err
+	}
+	r.Events.Result(map[string]string{
+		"action":          "os-update",
+		"manager":         res.Manager,
+		"pending":         strconv.Itoa(res.Pending),
+		"reboot_required": strconv.FormatBool(res.RebootRequired),
+		"reboot_packages": strings.Join(res.RebootPackages, ","),
+	})
+
+	fmt.Printf("\n[beammeup] os update :: %s\n", ship.Name)
+	fmt.Printf("  Package manager: %s\n", res.Manager)
+	switch {
+	case res.Pending > 0 && opts.SecurityOnly:
+		fmt.Printf("  Still available: %d update(s) that are not security fixes\n", res.Pending)
+	case res.Pending > 0:
+		fmt.Printf("  Still available: %d update(s) held back\n", res.Pending)
+	default:
+		fmt.Println("  Up to date.")
+	}
+	if res.RebootRequired {
+		line := "  Reboot required: yes"
+		if len(res.RebootPackages) > 0 {
+			line += " (" + strings.Join(res.RebootPackages, ", ") + ")"
+		}
+		fmt.Println(line)
+		target := "--host " + ship.Host
+		if opts.ShipName != "" {
+			target = "--ship " + opts.ShipName
+		}
+		fmt.Printf("  Reboot with: beammeup %s --action reboot\n", target)
+	} else {
+		fmt.Println("  Reboot required: no")
+	}
+	return ExitSuccess, nil
+}
@@ -0,0 +1,45 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/alfaoz/beammeup/internal/update"
+	"github.com/alfaoz/beammeup/internal/version"
+	"github.com/spf13/pflag"
+)
+
+// runPackageInfo reports how this beammeup was installed, how it upgrades,
+// and which release files exist for the platform.
+func (r *Runner) runPackageInfo(args []string) (int, error) {
+	fs := pflag.NewFlagSet("beammeup package-info", pflag.ContinueOnError)
+	if err := parseCommandFlags(fs, args); err != nil {
+		return ExitUsage, err
+	}
+
+	inst, err := update.DetectInstall()
+	if err != nil {
+		return ExitFailure, err
+	}
+	osName, archName, err := update.Platform()
+	if err != nil {
+		return ExitFailure, err
+	}
+
+	fmt.Printf("[beammeup] v%s (%s/%s)\n", version.AppVersion, osName, archName)
+	fmt.Printf("  Executable: %s\n", inst.Path)
+	installed := inst.Method
+	if inst.Package != "" {
+		installed += " (package " + inst.Package + ")"
+	}
+	fmt.Printf("  Installed with: %s\n", installed)
+	fmt.Printf("  Upgrade: %s\n", inst.UpgradeHint())
+
+	fmt.Println("  Release files for this platform:")
+	fmt.Printf("    %s\n", update.Install{Method: update.InstallArchive}.AssetName(osName, archName))
+	if osName == "linux" {
+		fmt.Printf("    %s\n", update.Install{Method: update.InstallDeb}.AssetName(osName, archName))
+		fmt.Printf("    %s\n", update.Install{Method: update.InstallRPM}.AssetName(osName, archName))
+	}
+	fmt.Printf("  Homebrew: brew install %s/beammeup\n", update.HomebrewTap)
+	return ExitSuccess, nil
+}
@@ -0,0 +1,111 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"github.com/alfaoz/beammeup/internal/config"
+	"github.com/alfaoz/beammeup/internal/exports"
+	"github.com/alfaoz/beammeup/internal/pairing"
+	"github.com/alfaoz/beammeup/internal/tunnel"
+	"github.com/spf13/pflag"
+)
+
+const defaultPairAddr = "127.0.0.1:8765"
+
+// runPair serves the current ship's proxy to the companion browser extension
+// until interrupted. The current ship is the one used most recently, so
+// switching ships anywhere in beammeup switches the browser on its next poll.
+func (r *Runner) runPair(args []string) (int, error) {
+	fs := pflag.NewFlagSet("beammeup pair", pflag.ContinueOnError)
+	listen := fs.String("listen", defaultPairAddr, "Local address to serve the extension on (loopback only)")
+	newToken := fs.Bool("new-token", false, "Replace the pairing token, unpairing every extension paired before")
+	code := fs.String("code", "", "Answer to the reveal challenge (prompted for when omitted)")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return ExitUsage, err
+	}
+	host, _, err := net.SplitHostPort(*listen)
+	if err != nil {
+		return ExitUsage, fmt.Errorf("invalid --listen address: %w", err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return ExitUsage, errors.New("--listen must be a loopback address: the endpoint hands out proxy credentials")
+	}
+	if r.Creds == nil {
+		return ExitFailure, errors.New("no credential store available")
+	}
+	if exit, err := r.passRevealGate(*code); err != nil {
+		return exit, err
+	}
+	dir, err := config.Dir()
+	if err != nil {
+		return ExitFailure, err
+	}
+	token, err := pairing.Token(dir, *newToken)
+	if err != nil {
+		return ExitFailure, err
+	}
+
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return ExitFailure, fmt.Errorf("listen %s: %w", *listen, err)
+	}
+	srv := &http.Server{Handler: pairing.NewServer(token, r.pairingState), ReadHeaderTimeout: 10 * time.Second}
+
+	fmt.Printf("\n[beammeup] browser pairing :: http://%s%s\n", ln.Addr(), pairing.Path)
+	fmt.Printf("  Token: %s\n", token)
+	fmt.Printf("  Enter both in the beammeup browser extension; the token stays valid until --new-token.\n")
+	if name := r.Store.Last(); name != "" {
+		fmt.Printf("  Current ship: %s (switch by using another ship in beammeup)\n", name)
+	}
+	fmt.Printf("\nPress Ctrl+C to stop.\n\n")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return ExitFailure, err
+	}
+	fmt.Println("\n[beammeup] browser pairing stopped.")
+	return ExitSuccess, nil
+}
+
+// pairingState is what the extension gets: the proxy of the ship used most
+// recently, and its stealth tunnel when one is running.
+func (r *Runner) pairingState() pairing.State {
+	name := r.Store.Last()
+	if name == "" {
+		return pairing.State{Status: pairing.StatusNoShip}
+	}
+	st := pairing.State{Ship: name, Status: pairing.StatusNoCredentials}
+	if run, err := tunnelRunDir(); err == nil {
+		if ts, err := tunnel.Control(filepath.Join(run, name+".sock"), tunnel.ControlStatus); err == nil {
+			st.Tunnel = "socks5://" + ts.Listen
+		}
+	}
+	ship, err := r.Store.Load(name)
+	if err != nil {
+		return st
+	}
+	card, err := r.Creds.Load(name)
+	if err != nil {
+		return st
+	}
+	row := exports.FromCard(ship, card)
+	st.Status = pairing.StatusReady
+	st.Protocol, st.Host, st.Port, st.User, st.Pass = row.Protocol, row.Host, row.Port, row.User, row.Pass
+	st.Updated = card.UpdatedAt
+	return st
+}
@@ -0,0 +1,88 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/alfaoz/beammeup/internal/ships"
+	"golang.org/x/term"
+)
+
+// pickShip turns --last into the ship used most recently, or asks a
+// terminal user which saved ship to use when neither --ship nor --host was
+// given, instead of failing with "no host provided". It reports whether the
+// user picked one; without a terminal or saved ships it leaves opts alone.
+func (r *Runner) pickShip(opts *Options) (bool, error) {
+	if opts.Last {
+		name := r.Store.Last()
+		if name == "" {
+			return false, errors.New("--last: no ship has been used yet")
+		}
+		opts.ShipName = name
+		return false, nil
+	}
+	if opts.ShipName != "" || opts.Host != "" || !stdinIsTerminal() || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return false, nil
+	}
+	names, err := r.Store.List()
+	if err != nil || len(names) == 0 {
+		return false, nil
+	}
+	fmt.Println("No --ship or --host given. Saved ships:")
+	for i, name := range names {
+		fmt.Printf("  %2d) %s\n", i+1, shipLabel(r.Store, name))
+	}
+	fmt.Printf("Ship [1-%d or name]: ", len(names))
+	name, ok := pickFrom(names, readLine())
+	if !ok {
+		return false, errors.New("cancelled: no ship picked")
+	}
+	opts.ShipName = name
+	return true, nil
+}
+
+// pickFrom resolves an answer to the picker: a number from the list or a
+// ship name.
+func pickFrom(names []string, answer string) (string, bool) {
+	answer = strings.TrimSpace(answer)
+	if n, err := strconv.Atoi(answer); err == nil {
+		if n >= 1 && n <= len(names) {
+			return names[n-1], true
+		}
+		return "", false
+	}
+	answer = ships.SanitizeName(answer)
+	for _, name := range names {
+		if name == answer {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func shipLabel(store *ships.Store, name string) string {
+	ship, err := store.Load(name)
+	if err != nil {
+		return name
+	}
+	return fmt.Sprintf("%-20s %s (%s)", name, ship.Host, fallback(ship.Protocol, "http"))
+}
+
+// confirmPicked double-checks a change to the server on a ship the user
+// just picked, since the command line alone did not name it.
+func confirmPicked(ship ships.Ship, action string, opts Options) error {
+	if opts.Yes {
+		return nil
+	}
+	what := "Configure the hangar"
+	if action == "rotate" {
+		what = "Rotate the proxy credentials"
+	}
+	if !confirm(fmt.Sprintf("%s on %s (%s)?", what, ship.Name, ship.Host), true) {
+		return errors.New("cancelled")
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package cli
+
+import "testing"
+
+func TestPickFrom(t *testing.T) {
+	names := []string{"edge", "lab"}
+	cases := []struct {
+		answer string
+		want   string
+		ok     bool
+	}{
+		{"2", "lab", true},
+		{" Edge ", "edge", true},
+		{"3", "", false},
+		{"", "", false},
+		{"nope", "", false},
+	}
+	for _, c := range cases {
+		if got, ok := pickFrom(names, c.answer); got != c.want || ok != c.ok {
+			t.Fatalf("pickFrom(%q) = %q, %v", c.answer, got, ok)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/alfaoz/beammeup/internal/config"
+	"github.com/spf13/pflag"
+)
+
+// ExtractProfile removes --profile <name> (or --profile=<name>) from args,
+// wherever it appears before a "--", and returns the name. It is a global
+// option: the profile has to be active before the stores are opened, so it
+// is taken out before the rest of the arguments are parsed.
+func ExtractProfile(args []string) (string, []string, error) {
+	var name string
+	found := false
+	rest := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			rest = append(rest, args[i:]...)
+			i = len(args)
+			continue
+		case arg == "--profile":
+			if i+1 >= len(args) {
+				return "", nil, errors.New("--profile needs a name")
+			}
+			i++
+			name = args[i]
+		case strings.HasPrefix(arg, "--profile="):
+			name = strings.TrimPrefix(arg, "--profile=")
+		default:
+			rest = append(rest, arg)
+			continue
+		}
+		found = true
+	}
+	if !found {
+		return "", args, nil
+	}
+	name = strings.TrimSpace(name)
+	if name != "default" {
+		if err := config.ValidateProfile(name); err != nil {
+			return "", nil, fmt.Errorf("--profile: %w", err)
+		}
+	}
+	return name, rest, nil
+}
+
+// runProfiles lists the local profiles with their ship counts, marking the
+// active one.
+func (r *Runner) runProfiles(args []string) (int, error) {
+	fs := pflag.NewFlagSet("beammeup profiles", pflag.ContinueOnError)
+	if err := parseCommandFlags(fs, args); err != nil {
+		return ExitUsage, err
+	}
+	names, err := config.Profiles()
+	if err != nil {
+		return ExitFailure, err
+	}
+	active := config.Profile()
+
+	fmt.Println("[beammeup] profiles")
+	for _, name := range append([]string{""}, names...) {
+		dir, err := config.ProfileDir(name)
+		if err != nil {
+			return ExitFailure, err
+		}
+		shipsDir := filepath.Join(dir, "ships")
+		if name == active && r.Store != nil {
+			// Honors BEAMMEUP_SHIPS_DIR for the active profile.
+			shipsDir = r.Store.Dir
+		}
+		label, marker := name, " "
+		if label == "" {
+			label = "default"
+		}
+		if name == active {
+			marker = "*"
+		}
+		fmt.Printf("  %s %-20s %3d ship(s)  %s\n", marker, label, countShips(shipsDir), dir)
+	}
+	if len(names) == 0 {
+		fmt.Println("  Create one by passing --profile <name> (or setting BEAMMEUP_PROFILE) to any command.")
+	}
+	return ExitSuccess, nil
+}
+
+func countShips(dir string) int {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+	n := 0
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".ship") {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,108 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/alfaoz/beammeup/internal/hangar"
+	"github.com/alfaoz/beammeup/internal/ships"
+)
+
+const (
+	defaultRebootTimeout = 5 * time.Minute
+	rebootPollInterval   = 5 * time.Second
+)
+
+// runReboot is --action reboot: reboot the server, wait for SSH to come
+// back, and check that the units running before came up again.
+func (r *Runner) runReboot(ship ships.Ship, password string, opts Options) (int, error) {
+	timeout := opts.RebootTimeout
+	if timeout == 0 {
+		timeout = defaultRebootTimeout
+	}
+	if !opts.Yes && !confirm("Reboot "+ship.Host+"?", false) {
+		return ExitFailure, errors.New("cancelled")
+	}
+
+	start := time.Now()
+	endPhase := r.Events.Phase("reboot")
+	before, err := r.Hangar.Reboot(ship, password)
+	endPhase(err)
+	if err != nil {
+		return ExitFailure, err
+	}
+	fmt.Printf("\n[beammeup] %s is rebooting; waiting up to %s for SSH to come back...\n", ship.Host, timeout)
+	endPhase = r.Events.Phase("wait")
+	inv, err := r.waitForReboot(ship, password, start, timeout)
+	endPhase(err)
+	if err != nil {
+		return ExitFailure, err
+	}
+	r.recordHostInfo(opts.ShipName, inv.Host)
+	printHostReport(inv.Host)
+	printInventorySummary(inv)
+
+	active, failed, err := r.Hangar.Units(ship, password)
+	if err != nil {
+		return ExitFailure, err
+	}
+	var missing []string
+	for _, unit := range before {
+		if !slices.Contains(active, unit) {
+			missing = append(missing, unit)
+		}
+	}
+
+	fmt.Printf("\n[beammeup] reboot :: %s\n", ship.Name)
+	fmt.Printf("  Back after: %s\n", time.Since(start).Round(time.Second))
+	switch {
+	case len(before) == 0:
+		fmt.Println("  No beammeup units were running before the reboot.")
+	case len(missing) == 0:
+		fmt.Printf("  Auto-started: all %d unit(s) that were running (%s)\n", len(before), strings.Join(before, ", "))
+	default:
+		fmt.Printf("  Not running again: %s\n", strings.Join(missing, ", "))
+	}
+	if len(failed) > 0 {
+		fmt.Printf("  Failed: %s (see journalctl -u <unit>)\n", strings.Join(failed, ", "))
+	}
+	r.Events.Result(map[string]string{
+		"action":      "reboot",
+		"back_after":  time.Since(start).Round(time.Second).String(),
+		"not_running": strings.Join(missing, ","),
+		"failed":      strings.Join(failed, ","),
+	})
+	if len(missing) > 0 || len(failed) > 0 {
+		return ExitFailure, nil
+	}
+	return ExitSuccess, nil
+}
+
+// waitForReboot polls the server until an inventory answers from after the
+// reboot: its uptime is shorter than the time since start, or, where uptime
+// is unknown, the server has been unreachable in between.
+func (r *Runner) waitForReboot(ship ships.Ship, password string, start time.Time, timeout time.Duration) (hangar.Inventory, error) {
+	deadline := start.Add(timeout)
+	wentDown := false
+	var lastErr error
+	for time.Now().Before(deadline) {
+		time.Sleep(rebootPollInterval)
+		inv, err := r.Hangar.Inventory(ship, password)
+		if err != nil {
+			wentDown, lastErr = true, err
+			r.Events.Progress("not reachable yet")
+			continue
+		}
+		uptime := inv.Host.Uptime
+		if (uptime > 0 && uptime < time.Since(start)) || (uptime == 0 && wentDown) {
+			return inv, nil
+		}
+	}
+	if lastErr != nil {
+		return hangar.Inventory{}, fmt.Errorf("%s did not come back within %s: %w", ship.Host, timeout, lastErr)
+	}
+	return hangar.Inventory{}, fmt.Errorf("%s did not reboot within %s", ship.Host, timeout)
+}
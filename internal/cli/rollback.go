@@ -0,0 +1,69 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/alfaoz/beammeup/internal/ships"
+)
+
+// runRollback is --action rollback: without --to it lists the configuration
+// snapshots on the server, with --to it restores one.
+func (r *Runner) runRollback(ship ships.Ship, password string, opts Options) (int, error) {
+	if opts.RollbackTo == "" {
+		endPhase := r.Events.Phase("rollback")
+		backups, err := r.Hangar.Backups(ship, password)
+		endPhase(err)
+		if err != nil {
+			return ExitFailure, err
+		}
+		fmt.Printf("\n[beammeup] snapshots :: %s\n", ship.Name)
+		if len(backups) == 0 {
+			fmt.Println("  None yet; every configure and rotate takes one before changing the server.")
+		}
+		for i, stamp := range backups {
+			marker := ""
+			if i == 0 {
+				marker = "  (before the latest change)"
+			}
+			fmt.Printf("  %s%s\n", stamp, marker)
+		}
+		if len(backups) > 0 {
+			fmt.Printf("\nRestore one with: beammeup --ship %s --action rollback --to %s\n", ship.Name, backups[0])
+		}
+		r.Events.Result(map[string]string{"action": "rollback", "backups": strings.Join(backups, ",")})
+		return ExitSuccess, nil
+	}
+
+	if opts.RollbackTo == "latest" {
+		backups, err := r.Hangar.Backups(ship, password)
+		if err != nil {
+			return ExitFailure, err
+		}
+		if len(backups) == 0 {
+			return ExitFailure, fmt.Errorf("%s has no snapshot to roll back to", ship.Name)
+		}
+		opts.RollbackTo = backups[0]
+	}
+	if !opts.Yes && !confirm(fmt.Sprintf("Restore the configuration of %s on %s?", opts.RollbackTo, ship.Host), false) {
+		return ExitFailure, errors.New("cancelled")
+	}
+	endPhase := r.Events.Phase("rollback")
+	saved, err := r.Hangar.Rollback(ship, password, opts.RollbackTo)
+	endPhase(err)
+	if err != nil {
+		return ExitFailure, err
+	}
+	inv, err := r.Hangar.Inventory(ship, password)
+	if err != nil {
+		return ExitFailure, err
+	}
+	fmt.Printf("\n[beammeup] rollback :: %s\n", ship.Name)
+	fmt.Printf("  Restored: %s\n", opts.RollbackTo)
+	fmt.Printf("  Undo: beammeup --ship %s --action rollback --to %s\n", ship.Name, saved)
+	printInventorySummary(inv)
+	fmt.Println("\nThe ship profile still holds the settings of the later configuration; beammeup diff shows where they differ.")
+	r.Events.Result(map[string]string{"action": "rollback", "restored": opts.RollbackTo, "backup": saved})
+	return ExitSuccess, nil
+}
@@ -0,0 +1,142 @@
+package cli
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+
+	"github.com/alfaoz/beammeup/internal/config"
+	"github.com/alfaoz/beammeup/internal/creds"
+	"github.com/alfaoz/beammeup/internal/hangar"
+	"github.com/alfaoz/beammeup/internal/ships"
+	"github.com/alfaoz/beammeup/internal/sysproxy"
+	"github.com/alfaoz/beammeup/internal/toolproxy"
+)
+
+// staleClient is a setting on this machine that still holds the proxy
+// credentials from before a rotate.
+type staleClient struct {
+	Name string // git, npm, pip or "system proxy"
+	URL  string // the tool's proxy URL; empty for the system proxy
+}
+
+// previousCard returns the ship's result card from before an apply, so a
+// rotate can be compared with it.
+func (r *Runner) previousCard(shipName string) (creds.Card, bool) {
+	if shipName == "" || r.Creds == nil {
+		return creds.Card{}, false
+	}
+	card, err := r.Creds.Load(shipName)
+	return card, err == nil
+}
+
+// reportRotation prints what a rotate changed for clients and which of the
+// proxy settings beammeup wrote on this machine still use the old
+// credentials. With refresh, those settings are rewritten instead.
+func (r *Runner) reportRotation(ship ships.Ship, before creds.Card, hadBefore bool, res hangar.ActionResult, refresh bool) {
+	fmt.Println("\nBefore/after rotate:")
+	if !hadBefore {
+		fmt.Println("  no earlier result card on this machine to compare with.")
+		return
+	}
+	for _, c := range creds.Compare(before, creds.FromResult(ship.Name, res)) {
+		marker := " "
+		if c.Differs() {
+			marker = "~"
+		}
+		fmt.Printf("  %s %s\n", marker, c)
+	}
+
+	stale := staleClients(ship.Name, before)
+	if len(stale) == 0 {
+		fmt.Println("Clients to update: none set up by beammeup here; update browser extensions and other tools by hand.")
+		return
+	}
+	if !refresh {
+		fmt.Println("Clients to update (or rerun with --refresh-clients):")
+		for _, c := range stale {
+			if c.URL == "" {
+				fmt.Printf("  - %s: beammeup sysproxy on --ship %s\n", c.Name, ship.Name)
+			} else {
+				fmt.Printf("  - %s: beammeup toolproxy %s --ship %s\n", c.Name, c.Name, ship.Name)
+			}
+		}
+		return
+	}
+	ep := hangarEndpoint(ship, res)
+	fmt.Println("Clients refreshed:")
+	for _, c := range stale {
+		var err error
+		if c.URL == "" {
+			err = refreshSysproxy(ship.Name, sysproxy.Settings{Protocol: ep.Protocol, Host: ep.Host, Port: ep.Port, User: ep.User, Pass: ep.Pass})
+		} else {
+			_, err = toolproxy.Set(c.Name, rotatedURL(c.URL, net.JoinHostPort(ep.Host, ep.Port), ep.User, ep.Pass))
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[beammeup] WARNING: could not refresh %s: %v\n", c.Name, err)
+			continue
+		}
+		fmt.Printf("  - %s\n", c.Name)
+	}
+}
+
+// credentialsChanged reports whether a client of before has to change
+// anything to use after.
+func credentialsChanged(before, after creds.Card) bool {
+	for _, c := range creds.Compare(before, after) {
+		if c.Differs() {
+			return true
+		}
+	}
+	return false
+}
+
+// staleClients finds the toolproxy settings that carry before's
+// credentials, and the system proxy when sysproxy points at the ship.
+func staleClients(shipName string, before creds.Card) []staleClient {
+	var stale []staleClient
+	for _, tool := range toolproxy.Tools {
+		raw, err := toolproxy.Get(tool)
+		if err != nil || raw == "" {
+			continue
+		}
+		u, err := url.Parse(raw)
+		if err != nil || u.User == nil {
+			continue
+		}
+		if pass, _ := u.User.Password(); u.User.Username() == before.User && pass == before.Pass {
+			stale = append(stale, staleClient{Name: tool, URL: raw})
+		}
+	}
+	if dir, err := config.Dir(); err == nil {
+		if active, ok := sysproxy.Active(dir); ok && active == shipName {
+			stale = append(stale, staleClient{Name: "system proxy"})
+		}
+	}
+	return stale
+}
+
+// rotatedURL keeps the scheme of a tool's proxy URL (socks5h stays socks5h)
+// and swaps in the new address and credentials.
+func rotatedURL(raw, hostPort, user, pass string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	u.Host = hostPort
+	u.User = url.UserPassword(user, pass)
+	return u.String()
+}
+
+func refreshSysproxy(shipName string, s sysproxy.Settings) error {
+	backend, err := sysproxy.Detect()
+	if err != nil {
+		return err
+	}
+	dir, err := config.Dir()
+	if err != nil {
+		return err
+	}
+	return sysproxy.On(backend, dir, shipName, s)
+}
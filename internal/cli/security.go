@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/spf13/pflag"
+)
+
+func (r *Runner) runSecurity(args []string) (int, error) {
+	opts := DefaultOptions()
+	fs := pflag.NewFlagSet("beammeup security", pflag.ContinueOnError)
+	addTargetFlags(fs, &opts)
+	harden := fs.Bool("harden", false, "Install the sandboxing drop-in on the proxy services before scoring them")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return ExitUsage, err
+	}
+
+	ship, password, code, err := r.prepareTarget(opts)
+	if err != nil {
+		return code, err
+	}
+	units, err := r.Hangar.SecurityAudit(ship, password, *harden)
+	if err != nil {
+		return ExitFailure, err
+	}
+
+	fmt.Printf("[beammeup] unit security :: %s\n", ship.Name)
+	if len(units) == 0 {
+		fmt.Println("  No beammeup services on this server.")
+		return ExitSuccess, nil
+	}
+	fmt.Printf("  %-40s  %8s  %-8s  %s\n", "UNIT", "EXPOSURE", "LEVEL", "HARDENING")
+	rolledBack := false
+	for _, u := range units {
+		exposure := "-"
+		if u.Exposure >= 0 {
+			exposure = fmt.Sprintf("%.1f", u.Exposure)
+		}
+		state := u.State
+		switch state {
+		case "":
+			state = "-"
+		case "rolled-back":
+			rolledBack = true
+		}
+		fmt.Printf("  %-40s  %8s  %-8s  %s\n", u.Unit, exposure, u.Label, state)
+	}
+	fmt.Println("  Exposure runs from 0 (tightly sandboxed) to 10 (none); see systemd-analyze security <unit>.")
+	if rolledBack {
+		fmt.Println("  A service did not start with the drop-in, so it was removed again; check journalctl -u <unit>.")
+		return ExitFailure, nil
+	}
+	if !*harden {
+		fmt.Println("  Run with --harden to sandbox the proxy services further.")
+	}
+	return ExitSuccess, nil
+}
@@ -0,0 +1,129 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alfaoz/beammeup/internal/hangar"
+	"github.com/alfaoz/beammeup/internal/probe"
+	"github.com/spf13/pflag"
+)
+
+const selftestTimeout = 20 * time.Second
+
+func (r *Runner) runSelftest(args []string) (int, error) {
+	opts := DefaultOptions()
+	fs := pflag.NewFlagSet("beammeup selftest", pflag.ContinueOnError)
+	addTargetFlags(fs, &opts)
+	destroy := fs.Bool("destroy", false, "Destroy the hangar after the test")
+	echoURL := fs.String("echo-url", probe.DefaultIPEchoURL, "URL that returns the caller's public IP")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return ExitUsage, err
+	}
+
+	ship, password, code, err := r.prepareTarget(opts)
+	if err != nil {
+		return code, err
+	}
+
+	logf := func(format string, args ...any) {
+		fmt.Fprintf(os.Stderr, "[selftest] "+format+"\n", args...)
+	}
+	steps := &stepRecorder{logf: logf}
+	title := fmt.Sprintf("Selftest results (%s)", ship.Host)
+	finish := func() (int, error) {
+		if steps.print(title) {
+			return ExitFailure, errors.New("selftest failed")
+		}
+		fmt.Println("\n[beammeup] selftest passed.")
+		return ExitSuccess, nil
+	}
+
+	inv, err := r.Hangar.Inventory(ship, password)
+	if !steps.record("inventory", err) {
+		return finish()
+	}
+	if ship.Protocol == "" {
+		ship.Protocol = "http"
+	}
+
+	in := hangar.ActionInput{
+		Mode:                    "apply",
+		Protocol:                ship.Protocol,
+		HTTPMode:                ship.HTTPMode,
+		ProxyPort:               resolveProxyPort(ship, inv),
+		NoFirewallChange:        ship.NoFirewallChange,
+		ListenLocal:             ship.ListenLocal,
+		SmartBlinder:            ship.SmartBlinder,
+		SmartBlinderIdleMinutes: ship.SmartBlinderIdleMinutes,
+		TrafficCapGB:            ship.TrafficCapGB,
+		QuietHours:              ship.QuietHours,
+		Label:                   ship.HangarLabel,
+		Description:             ship.HangarDescription,
+		MaxConns:                ship.MaxConns,
+		RateLimit:               ship.RateLimit,
+		MemoryMax:               ship.MemoryMax,
+		CPUQuota:                ship.CPUQuota,
+		TasksMax:                ship.TasksMax,
+		EgressIP:                ship.EgressIP,
+		SocketActivation:        ship.Protocol == "socks5" && ship.SocketActivation,
+	}
+	applied, err := r.Hangar.Execute(ship, password, in)
+	if err == nil && applied.Pass == "" {
+		err = errors.New("apply returned no retrievable password")
+	}
+	if !steps.record("apply", err) {
+		return finish()
+	}
+
+	dial, closeDial, err := r.proxyDialer(ship, password)
+	if !steps.record("open proxy path", err) {
+		return finish()
+	}
+	defer closeDial()
+
+	ep := hangarEndpoint(ship, applied)
+	ip, err := probe.ExternalIP(ep, dial, *echoURL, selftestTimeout)
+	if steps.record("credentialed request through proxy", err) {
+		want := strings.TrimSpace(inv.PublicIP)
+		if want == "" || want == "UNKNOWN" {
+			steps.skip("exit IP matches server", "server public IP unknown")
+		} else if ip != want {
+			steps.record("exit IP matches server", fmt.Errorf("exit IP %s, server reports %s", ip, want))
+		} else {
+			steps.record("exit IP matches server", nil)
+		}
+	}
+
+	in.RotateCredentials = true
+	rotated, err := r.Hangar.Execute(ship, password, in)
+	if err == nil && rotated.Pass == "" {
+		err = errors.New("rotate returned no retrievable password")
+	}
+	if steps.record("rotate credentials", err) {
+		_, err = probe.ExternalIP(hangarEndpoint(ship, rotated), dial, *echoURL, selftestTimeout)
+		steps.record("new credentials accepted", err)
+
+		_, err = probe.ExternalIP(ep, dial, *echoURL, selftestTimeout)
+		switch {
+		case errors.Is(err, probe.ErrAuthRejected):
+			steps.record("old credentials rejected", nil)
+		case err == nil:
+			steps.record("old credentials rejected", errors.New("old credentials are still accepted"))
+		default:
+			steps.record("old credentials rejected", fmt.Errorf("unexpected error: %w", err))
+		}
+	}
+
+	if *destroy {
+		_, err = r.Hangar.Execute(ship, password, hangar.ActionInput{Mode: "destroy"})
+		steps.record("destroy", err)
+	} else {
+		steps.skip("destroy", "pass --destroy to remove the hangar")
+	}
+
+	return finish()
+}
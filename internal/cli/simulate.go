@@ -0,0 +1,147 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/alfaoz/beammeup/internal/hangar"
+	"github.com/alfaoz/beammeup/internal/ships"
+	"github.com/alfaoz/beammeup/internal/simulate"
+	"github.com/spf13/pflag"
+)
+
+func (r *Runner) runSimulate(args []string) (int, error) {
+	fs := pflag.NewFlagSet("beammeup simulate", pflag.ContinueOnError)
+	distro := fs.String("distro", "ubuntu:24.04", "Base image (ubuntu:<tag> or debian:<tag>)")
+	engine := fs.String("engine", "", "Container engine (docker or podman; default: auto-detect)")
+	protocolFlag := fs.String("protocol", "http", "http or socks5")
+	httpModeFlag := fs.String("http-mode", "", "auto or sidecar")
+	proxyPort := fs.Int("proxy-port", 0, "Proxy port")
+	listenLocal := fs.Bool("listen-local", false, "Bind proxy to localhost inside the container")
+	smartBlinder := fs.Bool("smart-blinder", true, "Enable smart blinder")
+	keepHangar := fs.Bool("keep-hangar", false, "Skip the destroy step")
+	keepContainer := fs.Bool("keep", false, "Leave the container running after the simulation")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return ExitUsage, err
+	}
+
+	protocol, ok := NormalizeProtocol(strings.ToLower(strings.TrimSpace(*protocolFlag)))
+	if !ok || protocol == "" {
+		return ExitUsage, errors.New("invalid --protocol. use http or socks5")
+	}
+	httpMode, ok := NormalizeHTTPMode(strings.ToLower(strings.TrimSpace(*httpModeFlag)))
+	if !ok {
+		return ExitUsage, errors.New("invalid --http-mode. use auto or sidecar")
+	}
+	if err := simulate.ValidateDistro(*distro); err != nil {
+		return ExitUsage, err
+	}
+
+	eng, err := simulate.DetectEngine(*engine)
+	if err != nil {
+		return ExitFailure, err
+	}
+
+	logf := func(format string, args ...any) {
+		fmt.Fprintf(os.Stderr, "[simulate] "+format+"\n", args...)
+	}
+
+	logf("starting %s container (%s)", *distro, eng)
+	c, err := simulate.Start(eng, *distro, logf)
+	if err != nil {
+		return ExitFailure, err
+	}
+	if *keepContainer {
+		defer logf("container left running: %s (remove with: %s rm -f %s)", c.Name, c.Engine, c.Name)
+	} else {
+		defer func() {
+			if err := c.Remove(); err != nil {
+				logf("%v", err)
+			}
+		}()
+	}
+
+	svc := hangar.NewService()
+	svc.ScriptRunner = c.RunScript
+
+	ship := ships.Ship{
+		Name:                    "simulation",
+		Host:                    c.Name,
+		Protocol:                protocol,
+		HTTPMode:                httpMode,
+		ProxyPort:               *proxyPort,
+		ListenLocal:             *listenLocal,
+		SmartBlinder:            *smartBlinder,
+		SmartBlinderIdleMinutes: 10,
+	}
+	if ship.ProxyPort == 0 {
+		ship.ProxyPort = resolveProxyPort(ship, hangar.Inventory{})
+	}
+
+	steps := &stepRecorder{logf: logf}
+	record := steps.record
+
+	_, err = svc.Inventory(ship, "")
+	ok = record("inventory (fresh)", err)
+
+	if ok {
+		_, err = svc.Execute(ship, "", hangar.ActionInput{
+			Mode:                    "preflight",
+			Protocol:                ship.Protocol,
+			HTTPMode:                ship.HTTPMode,
+			ProxyPort:               ship.ProxyPort,
+			ListenLocal:             ship.ListenLocal,
+			SmartBlinder:            ship.SmartBlinder,
+			SmartBlinderIdleMinutes: ship.SmartBlinderIdleMinutes,
+		})
+		ok = record("preflight", err)
+	}
+
+	if ok {
+		var res hangar.ActionResult
+		res, err = svc.Execute(ship, "", hangar.ActionInput{
+			Mode:                    "apply",
+			Protocol:                ship.Protocol,
+			HTTPMode:                ship.HTTPMode,
+			ProxyPort:               ship.ProxyPort,
+			NoFirewallChange:        true,
+			ListenLocal:             ship.ListenLocal,
+			SmartBlinder:            ship.SmartBlinder,
+			SmartBlinderIdleMinutes: ship.SmartBlinderIdleMinutes,
+		})
+		if err == nil && (res.User == "" || res.Pass == "") {
+			err = errors.New("apply returned no credentials")
+		}
+		ok = record("apply", err)
+	}
+
+	if ok {
+		var inv hangar.Inventory
+		inv, err = svc.Inventory(ship, "")
+		if err == nil && inv.HangarStatus != hangar.StatusOnline {
+			err = fmt.Errorf("expected hangar online, got %q", inv.HangarStatus)
+		}
+		ok = record("inventory (after apply)", err)
+	}
+
+	if ok && !*keepHangar {
+		_, err = svc.Execute(ship, "", hangar.ActionInput{Mode: "destroy"})
+		ok = record("destroy", err)
+		if ok {
+			var inv hangar.Inventory
+			inv, err = svc.Inventory(ship, "")
+			if err == nil && inv.HangarStatus != hangar.StatusMissing {
+				err = fmt.Errorf("expected hangar missing, got %q", inv.HangarStatus)
+			}
+			record("inventory (after destroy)", err)
+		}
+	}
+
+	if steps.print(fmt.Sprintf("Simulation results (%s, %s)", *distro, protocol)) {
+		return ExitFailure, errors.New("simulation failed")
+	}
+	fmt.Println("\n[beammeup] simulation passed.")
+	return ExitSuccess, nil
+}
@@ -0,0 +1,113 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/alfaoz/beammeup/internal/hangar"
+	"github.com/alfaoz/beammeup/internal/ships"
+	"golang.org/x/term"
+)
+
+// squidConflictChoices are the ways out of a Squid config beammeup did not
+// write, in the order a terminal user is offered them.
+var squidConflictChoices = []string{"sidecar", "adopt", "replace", "abort"}
+
+// resolveSquidConflict decides what to do about a system Squid config that
+// apply refused to overwrite: what --http-conflict says, or else what a
+// terminal user picks after seeing the config. It returns the input to run
+// again, or false to give up.
+func (r *Runner) resolveSquidConflict(ship ships.Ship, password string, in hangar.ActionInput, opts Options) (hangar.ActionInput, bool, error) {
+	choice := opts.HTTPConflict
+	if choice == "abort" || choice == "" && (!stdinIsTerminal() || !term.IsTerminal(int(os.Stdout.Fd()))) {
+		return in, false, nil
+	}
+
+	cfg, err := r.Hangar.SquidConfig(ship, password)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[beammeup] WARNING: %v\n", err)
+	}
+	if choice == "" {
+		fmt.Println("\n[beammeup] the server already has a Squid config that beammeup did not write:")
+		for _, line := range strings.Split(cfg.Summary(), "\n") {
+			fmt.Printf("  %s\n", line)
+		}
+		fmt.Println("What should beammeup do?")
+		for i, c := range squidConflictChoices {
+			fmt.Printf("  %d) %-8s %s\n", i+1, c, squidConflictHelp(c, cfg))
+		}
+		fmt.Printf("Choice [1-%d]: ", len(squidConflictChoices))
+		var ok bool
+		if choice, ok = pickFrom(squidConflictChoices, readLine()); !ok {
+			return in, false, nil
+		}
+		if choice == "abort" {
+			return in, false, nil
+		}
+	}
+	fmt.Fprintf(os.Stderr, "[beammeup] Squid conflict: %s\n", choice)
+	return squidConflictInput(in, ship, choice, cfg), true, nil
+}
+
+func squidConflictHelp(choice string, cfg hangar.SquidConfig) string {
+	switch choice {
+	case "sidecar":
+		return "leave it alone and run beammeup's HTTP proxy as a separate Squid"
+	case "adopt":
+		help := "take it over"
+		if len(cfg.Ports) > 0 {
+			help += " on port " + cfg.Ports[0]
+		}
+		if cfg.Users > 0 {
+			help += fmt.Sprintf(", keeping its %d users", cfg.Users)
+		}
+		return help + "; the old config is backed up"
+	case "replace":
+		return "back it up and replace it with beammeup's own"
+	default:
+		return "change nothing"
+	}
+}
+
+// squidConflictInput turns in into the run that carries out choice. Unless
+// the ship asks for a port, the server picks one: the old config's when
+// adopting, the usual default otherwise, and for a sidecar one the old
+// config does not listen on.
+func squidConflictInput(in hangar.ActionInput, ship ships.Ship, choice string, cfg hangar.SquidConfig) hangar.ActionInput {
+	if ship.ProxyPort == 0 {
+		in.ProxyPort = 0
+	}
+	switch choice {
+	case "sidecar":
+		in.HTTPMode = "sidecar"
+		if in.ProxyPort == 0 {
+			in.ProxyPort = sidecarPort(cfg.Ports)
+		}
+	case "adopt", "replace":
+		in.HTTPConflict = choice
+	}
+	return in
+}
+
+// sidecarPort is the first port from the HTTP default up that none of
+// ports, Squid http_port values such as "3128" or "127.0.0.1:3128", uses.
+func sidecarPort(ports []string) int {
+	var taken []int
+	for _, p := range ports {
+		if i := strings.LastIndex(p, ":"); i >= 0 {
+			p = p[i+1:]
+		}
+		if n, err := strconv.Atoi(p); err == nil {
+			taken = append(taken, n)
+		}
+	}
+	proto, _ := hangar.LookupProtocol("http")
+	port := proto.DefaultPort()
+	for slices.Contains(taken, port) {
+		port++
+	}
+	return port
+}
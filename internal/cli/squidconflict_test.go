@@ -0,0 +1,28 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/alfaoz/beammeup/internal/hangar"
+	"github.com/alfaoz/beammeup/internal/ships"
+)
+
+func TestSquidConflictInput(t *testing.T) {
+	cfg := hangar.SquidConfig{Ports: []string{"18181", "127.0.0.1:18182"}}
+	// resolveProxyPort filled in the old config's port; only the ship's
+	// own port survives the resolution.
+	in := hangar.ActionInput{Mode: "apply", Protocol: "http", ProxyPort: 18181}
+
+	got := squidConflictInput(in, ships.Ship{}, "sidecar", cfg)
+	if got.HTTPMode != "sidecar" || got.ProxyPort != 18183 || got.HTTPConflict != "" {
+		t.Fatalf("sidecar: %+v", got)
+	}
+	got = squidConflictInput(in, ships.Ship{}, "adopt", cfg)
+	if got.HTTPConflict != "adopt" || got.ProxyPort != 0 || got.HTTPMode != "" {
+		t.Fatalf("adopt: %+v", got)
+	}
+	got = squidConflictInput(in, ships.Ship{ProxyPort: 18181}, "replace", cfg)
+	if got.HTTPConflict != "replace" || got.ProxyPort != 18181 {
+		t.Fatalf("replace on the ship's port: %+v", got)
+	}
+}
@@ -0,0 +1,54 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+)
+
+// stepRecorder collects pass/fail results for multi-step checks (simulate,
+// selftest) and prints them as a matrix at the end.
+type stepRecorder struct {
+	logf  func(format string, args ...any)
+	steps []stepResult
+}
+
+type stepResult struct {
+	name    string
+	err     error
+	skipped string
+}
+
+func (s *stepRecorder) record(name string, err error) bool {
+	s.steps = append(s.steps, stepResult{name: name, err: err})
+	if s.logf != nil {
+		if err != nil {
+			s.logf("%s: FAIL", name)
+		} else {
+			s.logf("%s: ok", name)
+		}
+	}
+	return err == nil
+}
+
+func (s *stepRecorder) skip(name, reason string) {
+	s.steps = append(s.steps, stepResult{name: name, skipped: reason})
+}
+
+// print writes the result matrix and reports whether any step failed.
+func (s *stepRecorder) print(title string) bool {
+	fmt.Printf("\n%s:\n", title)
+	failed := false
+	for _, st := range s.steps {
+		switch {
+		case st.skipped != "":
+			fmt.Printf("  skip  %s (%s)\n", st.name, st.skipped)
+		case st.err != nil:
+			failed = true
+			fmt.Printf("  FAIL  %s\n", st.name)
+			fmt.Printf("        %s\n", strings.ReplaceAll(strings.TrimSpace(st.err.Error()), "\n", "\n        "))
+		default:
+			fmt.Printf("  ok    %s\n", st.name)
+		}
+	}
+	return failed
+}
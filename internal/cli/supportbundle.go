@@ -0,0 +1,214 @@
+package cli
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/alfaoz/beammeup/internal/config"
+	"github.com/alfaoz/beammeup/internal/hangar"
+	"github.com/alfaoz/beammeup/internal/sshx"
+	"github.com/alfaoz/beammeup/internal/version"
+	"github.com/spf13/pflag"
+)
+
+const supportJournalLines = 300
+
+func (r *Runner) runSupportBundle(args []string) (int, error) {
+	opts := DefaultOptions()
+	fs := pflag.NewFlagSet("beammeup support-bundle", pflag.ContinueOnError)
+	addTargetFlags(fs, &opts)
+	output := fs.String("output", "", "Archive path (default: beammeup-support-<ship>-<time>.tar.gz)")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return ExitUsage, err
+	}
+
+	ship, password, code, err := r.prepareTarget(opts)
+	if err != nil {
+		return code, err
+	}
+	now := time.Now()
+	path := strings.TrimSpace(*output)
+	if path == "" {
+		path = fmt.Sprintf("beammeup-support-%s-%s.tar.gz", ship.Name, now.Format("20060102-150405"))
+	}
+
+	b := &supportBundle{}
+	b.rd.add(ship.Host, "<host>")
+	logf := func(format string, args ...any) {
+		fmt.Fprintf(os.Stderr, "[support] "+format+"\n", args...)
+	}
+
+	logf("collecting local state")
+	if raw, err := os.ReadFile(filepath.Join(r.Store.Dir, ship.Name+".ship")); err == nil {
+		b.add("local/ship.txt", string(raw))
+	} else {
+		b.fail("local/ship.txt", err)
+	}
+	if info, err := r.Store.LoadHostInfo(ship.Name); err == nil && !info.IsZero() {
+		b.add("local/host-info.txt", strings.Join(info.Lines(), "\n")+"\n")
+	}
+	if cfgPath, err := config.Path(); err == nil {
+		if raw, err := os.ReadFile(cfgPath); err == nil {
+			b.add("local/config.txt", string(raw))
+		} else if !os.IsNotExist(err) {
+			b.fail("local/config.txt", err)
+		}
+	}
+
+	// The read-only inventory keeps the bundle from changing the server.
+	logf("reading hangar inventory")
+	res, err := r.Hangar.Execute(ship, password, hangar.ActionInput{Mode: "inventory", ReadOnly: true})
+	if err != nil {
+		b.fail("remote/inventory.txt", err)
+	} else {
+		inv := res.Inventory
+		b.rd.add(inv.PublicIP, "<public-ip>")
+		b.rd.add(inv.Socks5.User, "<socks-user>")
+		b.rd.add(inv.Socks5.Pass, "<socks-pass>")
+		b.rd.add(inv.HTTP.User, "<http-user>")
+		b.rd.add(inv.HTTP.Pass, "<http-pass>")
+		b.add("remote/inventory.txt", res.RawOutput)
+	}
+
+	target := hangar.ShipTarget(ship, password)
+	client, err := sshx.ConnectWithOptions(target, r.Hangar.SSH)
+	if err != nil {
+		b.fail("remote", fmt.Errorf("ssh connect: %w", err))
+	} else {
+		defer client.Close()
+		logf("collecting service states and journals")
+		units := strings.Join(hangar.ManagedUnits, " ")
+		b.addCommand(client, "remote/services.txt", "systemctl status --no-pager --full "+units)
+		unitFlags := "-u " + strings.Join(hangar.ManagedUnits, " -u ")
+		b.addCommand(client, "remote/journal.txt", fmt.Sprintf("journalctl --no-pager -n %d %s", supportJournalLines, unitFlags))
+		b.addCommand(client, "remote/network.txt", "ss -ltnp; echo; ufw status verbose")
+		b.addCommand(client, "remote/system.txt", "cat /etc/os-release; echo; uname -a; uptime; echo; df -h; echo; free -m")
+		for _, mf := range hangar.ManagedFiles {
+			// Access logs list every client address; they stay out.
+			if strings.HasSuffix(mf.Name, "access.log") {
+				continue
+			}
+			mf, _ = hangar.ResolveManagedFile(mf.Name, opts.RemoteTmpDir)
+			quoted := sshx.ShellQuote(mf.Path)
+			b.addCommand(client, "remote/files/"+mf.Name, "test ! -e "+quoted+" || tail -n 500 -- "+quoted)
+		}
+	}
+
+	b.add("manifest.txt", b.manifest(ship.Name, now))
+	if err := b.write(path); err != nil {
+		return ExitFailure, err
+	}
+	fmt.Printf("[beammeup] support bundle written to %s (%d files).\n", path, len(b.files))
+	fmt.Println("  Passwords, proxy users, the host and its public IP are redacted, but journals")
+	fmt.Println("  may still name other addresses. Review the archive before attaching it to an issue.")
+	return ExitSuccess, nil
+}
+
+type bundleFile struct {
+	name    string
+	content string
+}
+
+type supportBundle struct {
+	files    []bundleFile
+	failures []string
+	rd       redactor
+}
+
+func (b *supportBundle) add(name, content string) {
+	b.files = append(b.files, bundleFile{name: name, content: content})
+}
+
+func (b *supportBundle) fail(name string, err error) {
+	b.failures = append(b.failures, fmt.Sprintf("%s: %v", name, err))
+}
+
+func (b *supportBundle) addCommand(client *sshx.Client, name, command string) {
+	out, err := client.RunCombined(command + " 2>&1")
+	if err != nil {
+		out += fmt.Sprintf("\n[beammeup] command ended with: %v\n", err)
+	}
+	b.add(name, "$ "+command+"\n"+out)
+}
+
+func (b *supportBundle) manifest(shipName string, now time.Time) string {
+	var m strings.Builder
+	fmt.Fprintf(&m, "beammeup support bundle\n")
+	fmt.Fprintf(&m, "version: %s\n", version.AppVersion)
+	fmt.Fprintf(&m, "client: %s/%s (%s)\n", runtime.GOOS, runtime.GOARCH, runtime.Version())
+	fmt.Fprintf(&m, "ship: %s\n", shipName)
+	fmt.Fprintf(&m, "created: %s\n", now.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&m, "\nfiles:\n")
+	for _, f := range b.files {
+		fmt.Fprintf(&m, "  %s\n", f.name)
+	}
+	if len(b.failures) > 0 {
+		fmt.Fprintf(&m, "\nnot collected:\n")
+		for _, f := range b.failures {
+			fmt.Fprintf(&m, "  %s\n", f)
+		}
+	}
+	return m.String()
+}
+
+// write stores every file, redacted, in a gzipped tarball at path.
+func (b *supportBundle) write(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("create support bundle: %w", err)
+	}
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	dir := strings.TrimSuffix(filepath.Base(path), ".tar.gz")
+	now := time.Now()
+	for _, file := range b.files {
+		content := []byte(b.rd.apply(file.content))
+		hdr := &tar.Header{Name: dir + "/" + file.name, Mode: 0o600, Size: int64(len(content)), ModTime: now}
+		if err := tw.WriteHeader(hdr); err != nil {
+			f.Close()
+			return fmt.Errorf("write support bundle: %w", err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			f.Close()
+			return fmt.Errorf("write support bundle: %w", err)
+		}
+	}
+	for _, c := range []interface{ Close() error }{tw, gz, f} {
+		if err := c.Close(); err != nil {
+			return fmt.Errorf("write support bundle: %w", err)
+		}
+	}
+	return nil
+}
+
+// sensitiveLine matches KEY=VALUE lines whose key suggests a secret, such as
+// PROXY_PASS in env files or BM_METADATA_B64 in inventory output.
+var sensitiveLine = regexp.MustCompile(`(?im)^(\s*[A-Z0-9_]*(?:PASS|SECRET|TOKEN|WEBHOOK|_SIG|_B64)[A-Z0-9_]*=).+$`)
+
+// redactor replaces known values (host, credentials) wherever they appear
+// and blanks the values of sensitive-looking KEY=VALUE lines.
+type redactor struct {
+	pairs []string
+}
+
+func (rd *redactor) add(value, placeholder string) {
+	// Very short values would match all over unrelated text.
+	if v := strings.TrimSpace(value); len(v) >= 4 && v != "UNKNOWN" {
+		rd.pairs = append(rd.pairs, v, placeholder)
+	}
+}
+
+func (rd *redactor) apply(s string) string {
+	s = sensitiveLine.ReplaceAllString(s, "${1}<redacted>")
+	if len(rd.pairs) > 0 {
+		s = strings.NewReplacer(rd.pairs...).Replace(s)
+	}
+	return s
+}
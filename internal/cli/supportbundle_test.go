@@ -0,0 +1,35 @@
+package cli
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedactorHidesSecrets(t *testing.T) {
+	var rd redactor
+	rd.add("203.0.113.7", "<host>")
+	rd.add("beamx", "<socks-user>")
+	rd.add("ab", "<too-short>")
+
+	in := strings.Join([]string{
+		"HOST=203.0.113.7",
+		"PROXY_USER=beamx",
+		"PROXY_PASS=hunter22",
+		"BM_METADATA_B64=eyJ2ZXJzaW9uIjoiMSJ9",
+		"WEBHOOK_URL=https://hooks.example.invalid/T000/abc",
+		"Oct 16 12:00:01 vps sshd[1]: Accepted password for root from 203.0.113.7",
+		"ab stays",
+	}, "\n")
+	want := strings.Join([]string{
+		"HOST=<host>",
+		"PROXY_USER=<socks-user>",
+		"PROXY_PASS=<redacted>",
+		"BM_METADATA_B64=<redacted>",
+		"WEBHOOK_URL=<redacted>",
+		"Oct 16 12:00:01 vps sshd[1]: Accepted password for root from <host>",
+		"ab stays",
+	}, "\n")
+	if got := rd.apply(in); got != want {
+		t.Fatalf("unexpected redaction:\n%s", got)
+	}
+}
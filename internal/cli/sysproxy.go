@@ -0,0 +1,84 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/alfaoz/beammeup/internal/config"
+	"github.com/alfaoz/beammeup/internal/sysproxy"
+	"github.com/spf13/pflag"
+)
+
+func (r *Runner) runSysproxy(args []string) (int, error) {
+	if len(args) == 0 || (args[0] != "on" && args[0] != "off") {
+		if len(args) > 0 && (args[0] == "-h" || args[0] == "--help") {
+			fmt.Println("Usage: beammeup sysproxy on --ship <name> [--local <addr>] | beammeup sysproxy off")
+			return ExitSuccess, nil
+		}
+		return ExitUsage, errors.New("usage: beammeup sysproxy on|off [options]")
+	}
+	mode := args[0]
+
+	opts := DefaultOptions()
+	fs := pflag.NewFlagSet("beammeup sysproxy "+mode, pflag.ContinueOnError)
+	addTargetFlags(fs, &opts)
+	local := fs.String("local", "", "Point at a local proxy instead (e.g. a stealth tunnel at 127.0.0.1:1080)")
+	localProtocol := fs.String("local-protocol", "socks5", "Protocol of the --local proxy (http or socks5)")
+	refresh := fs.Bool("refresh", false, "Ask the server for the credentials instead of using the stored result card")
+	if err := parseCommandFlags(fs, args[1:]); err != nil {
+		return ExitUsage, err
+	}
+
+	backend, err := sysproxy.Detect()
+	if err != nil {
+		return ExitFailure, err
+	}
+	dir, err := config.Dir()
+	if err != nil {
+		return ExitFailure, err
+	}
+
+	if mode == "off" {
+		ship, err := sysproxy.Off(backend, dir)
+		if err != nil {
+			return ExitFailure, err
+		}
+		fmt.Printf("[beammeup] system proxy restored (was routed through %s).\n", fallback(ship, "a ship"))
+		return ExitSuccess, nil
+	}
+
+	var settings sysproxy.Settings
+	var shipName string
+	if strings.TrimSpace(*local) != "" {
+		host, port, err := net.SplitHostPort(strings.TrimSpace(*local))
+		if err != nil {
+			return ExitUsage, fmt.Errorf("invalid --local address: %w", err)
+		}
+		protocol, ok := NormalizeProtocol(strings.ToLower(strings.TrimSpace(*localProtocol)))
+		if !ok || protocol == "" {
+			return ExitUsage, errors.New("invalid --local-protocol. use http or socks5")
+		}
+		settings = sysproxy.Settings{Protocol: protocol, Host: host, Port: port}
+		shipName = fallback(opts.ShipName, "local proxy "+*local)
+	} else {
+		ship, res, _, code, err := r.hangarShow(opts, "", *refresh)
+		if err != nil {
+			return code, err
+		}
+		ep := hangarEndpoint(ship, res)
+		settings = sysproxy.Settings{Protocol: ep.Protocol, Host: ep.Host, Port: ep.Port, User: ep.User, Pass: ep.Pass}
+		shipName = ship.Name
+		if ship.ListenLocal {
+			fmt.Printf("[beammeup] the hangar listens on localhost only; keep an SSH tunnel running:\n  ssh -N -L %s:127.0.0.1:%s %s@%s -p %d\n", ep.Port, ep.Port, ship.SSHUser, ship.Host, ship.SSHPort)
+		}
+	}
+
+	if err := sysproxy.On(backend, dir, shipName, settings); err != nil {
+		return ExitFailure, err
+	}
+	fmt.Printf("[beammeup] system proxy (%s) now routes through %s://%s.\n", backend.Name(), settings.Protocol, net.JoinHostPort(settings.Host, settings.Port))
+	fmt.Println("  Restore the previous settings with: beammeup sysproxy off")
+	return ExitSuccess, nil
+}
@@ -0,0 +1,54 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/alfaoz/beammeup/internal/remote"
+	"github.com/spf13/pflag"
+)
+
+func (r *Runner) runTemplates(args []string) (int, error) {
+	fs := pflag.NewFlagSet("beammeup templates", pflag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: beammeup templates [--init <dir>] [--dir <dir>]")
+		fs.PrintDefaults()
+	}
+	initDir := fs.String("init", "", "Copy the built-in templates into this directory for editing")
+	dir := fs.String("dir", strings.TrimSpace(os.Getenv("BEAMMEUP_TEMPLATE_DIR")), "Override directory to render with (default: $BEAMMEUP_TEMPLATE_DIR)")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return ExitUsage, err
+	}
+
+	if *initDir != "" {
+		written, err := remote.WriteTemplates(*initDir)
+		if err != nil {
+			return ExitFailure, err
+		}
+		for _, path := range written {
+			fmt.Printf("  %s\n", path)
+		}
+		fmt.Printf("[beammeup] %d template(s) written. Set BEAMMEUP_TEMPLATE_DIR=%s to use your edits.\n", len(written), *initDir)
+		return ExitSuccess, nil
+	}
+
+	units, err := remote.RenderTemplates(*dir)
+	if err != nil {
+		return ExitFailure, err
+	}
+	if len(units) == 0 {
+		return ExitFailure, errors.New("no templates")
+	}
+	names := make([]string, 0, len(units))
+	for name := range units {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("# %s\n%s\n", name, units[name])
+	}
+	return ExitSuccess, nil
+}
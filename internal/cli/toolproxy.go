@@ -0,0 +1,92 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/alfaoz/beammeup/internal/toolproxy"
+	"github.com/spf13/pflag"
+)
+
+func (r *Runner) runToolproxy(args []string) (int, error) {
+	if len(args) == 0 || !slices.Contains(toolproxy.Tools, args[0]) {
+		if len(args) > 0 && (args[0] == "-h" || args[0] == "--help") {
+			fmt.Println("Usage: beammeup toolproxy git|npm|pip --ship <name> [--local <addr>] [--unset]")
+			return ExitSuccess, nil
+		}
+		return ExitUsage, errors.New("usage: beammeup toolproxy git|npm|pip [options]")
+	}
+	tool := args[0]
+
+	opts := DefaultOptions()
+	fs := pflag.NewFlagSet("beammeup toolproxy "+tool, pflag.ContinueOnError)
+	addTargetFlags(fs, &opts)
+	unset := fs.Bool("unset", false, "Remove the proxy settings instead")
+	local := fs.String("local", "", "Point at a local proxy instead (e.g. beammeup adapter at 127.0.0.1:8118)")
+	localProtocol := fs.String("local-protocol", "http", "Protocol of the --local proxy (http or socks5)")
+	refresh := fs.Bool("refresh", false, "Ask the server for the credentials instead of using the stored result card")
+	if err := parseCommandFlags(fs, args[1:]); err != nil {
+		return ExitUsage, err
+	}
+
+	if *unset {
+		where, err := toolproxy.Unset(tool)
+		if err != nil {
+			return ExitFailure, err
+		}
+		fmt.Printf("[beammeup] %s proxy removed (%s).\n", tool, where)
+		return ExitSuccess, nil
+	}
+
+	var u url.URL
+	if strings.TrimSpace(*local) != "" {
+		if _, _, err := net.SplitHostPort(strings.TrimSpace(*local)); err != nil {
+			return ExitUsage, fmt.Errorf("invalid --local address: %w", err)
+		}
+		protocol, ok := NormalizeProtocol(strings.ToLower(strings.TrimSpace(*localProtocol)))
+		if !ok || protocol == "" {
+			return ExitUsage, errors.New("invalid --local-protocol. use http or socks5")
+		}
+		u = url.URL{Scheme: protocol, Host: strings.TrimSpace(*local)}
+	} else {
+		ship, res, _, code, err := r.hangarShow(opts, "", *refresh)
+		if err != nil {
+			return code, err
+		}
+		if res.User == "" || res.Pass == "" {
+			return ExitFailure, errors.New("hangar credentials are not retrievable. rotate them first")
+		}
+		ep := hangarEndpoint(ship, res)
+		u = url.URL{Scheme: ep.Protocol, Host: net.JoinHostPort(ep.Host, ep.Port), User: url.UserPassword(ep.User, ep.Pass)}
+		if ship.ListenLocal {
+			fmt.Printf("[beammeup] the hangar listens on localhost only; keep an SSH tunnel running:\n  ssh -N -L %s:127.0.0.1:%s %s@%s -p %d\n", ep.Port, ep.Port, ship.SSHUser, ship.Host, ship.SSHPort)
+		}
+	}
+
+	if u.Scheme == "socks5" {
+		switch tool {
+		case "npm":
+			return ExitFailure, errors.New("npm does not support SOCKS5 proxies. run `beammeup adapter` and pass --local 127.0.0.1:8118")
+		case "pip":
+			fmt.Fprintln(os.Stderr, "[beammeup] WARNING: pip needs the PySocks package for SOCKS5 proxies (pip install pysocks).")
+		}
+		// socks5h resolves hostnames on the proxy, so DNS does not leak.
+		u.Scheme = "socks5h"
+	}
+
+	where, err := toolproxy.Set(tool, u.String())
+	if err != nil {
+		return ExitFailure, err
+	}
+	fmt.Printf("[beammeup] %s now uses %s (%s).\n", tool, u.Redacted(), where)
+	if u.User != nil {
+		fmt.Println("  The proxy credentials are stored in plain text there.")
+	}
+	fmt.Printf("  Remove with: beammeup toolproxy %s --unset\n", tool)
+	return ExitSuccess, nil
+}
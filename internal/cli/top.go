@@ -0,0 +1,231 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/alfaoz/beammeup/internal/hangar"
+	"github.com/alfaoz/beammeup/internal/ships"
+	"github.com/alfaoz/beammeup/internal/usage"
+	"github.com/spf13/pflag"
+	"golang.org/x/term"
+)
+
+const defaultTopInterval = 5 * time.Second
+
+// topRow is one ship in beammeup top.
+type topRow struct {
+	Ship    string
+	Last    hangar.Activity // latest successful snapshot
+	Rate    bool            // RXRate and TXRate hold a measurement
+	RXRate  float64         // bytes/s between the last two snapshots
+	TXRate  float64
+	Err     error     // from the latest poll
+	Checked time.Time // when the latest poll finished; zero before the first
+}
+
+// update folds a poll result into the row. The rate needs two snapshots in a
+// row; counters that went backwards (a reboot) start over.
+func (row *topRow) update(a hangar.Activity, err error, now time.Time) {
+	row.Checked, row.Err = now, err
+	if err != nil {
+		row.Rate = false
+		return
+	}
+	prev := row.Last
+	row.Last = a
+	secs := a.At.Sub(prev.At).Seconds()
+	row.Rate = !prev.At.IsZero() && secs > 0 && a.RXBytes >= prev.RXBytes && a.TXBytes >= prev.TXBytes
+	if row.Rate {
+		row.RXRate = float64(a.RXBytes-prev.RXBytes) / secs
+		row.TXRate = float64(a.TXBytes-prev.TXBytes) / secs
+	}
+}
+
+type topTarget struct {
+	ship     ships.Ship
+	password string
+}
+
+type topResult struct {
+	i   int
+	a   hangar.Activity
+	err error
+}
+
+// runTop shows every saved ship (or those given with --ship) live: status,
+// clients connected, server traffic rate and when each was last checked.
+func (r *Runner) runTop(args []string) (int, error) {
+	opts := DefaultOptions()
+	fs := pflag.NewFlagSet("beammeup top", pflag.ContinueOnError)
+	addTargetFlags(fs, &opts)
+	interval := fs.Duration("interval", defaultTopInterval, "How often to poll each ship")
+	once := fs.Bool("once", false, "Print one snapshot and exit (the default when stdout is not a terminal)")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return ExitUsage, err
+	}
+	if opts.Host != "" || opts.Last {
+		return ExitUsage, errors.New("top watches saved ships: pick them with --ship a,b or omit it for all")
+	}
+	if *interval < time.Second {
+		return ExitUsage, errors.New("--interval must be at least 1s")
+	}
+	if err := validateTargetFlags(opts); err != nil {
+		return ExitUsage, err
+	}
+	r.Hangar.SSH = r.sshOptions(opts)
+	r.Hangar.RemoteTmpDir = strings.TrimSpace(opts.RemoteTmpDir)
+	r.Hangar.ReadOnly = true
+
+	var names []string
+	for _, name := range strings.Split(opts.ShipName, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		var err error
+		if names, err = r.Store.List(); err != nil {
+			return ExitFailure, err
+		}
+	}
+	if len(names) == 0 {
+		return ExitUsage, fmt.Errorf("no ships saved yet in %s", r.Store.Dir)
+	}
+	targets := make([]topTarget, 0, len(names))
+	for _, name := range names {
+		ship, err := r.Store.Load(ships.SanitizeName(name))
+		if err != nil {
+			return ExitFailure, err
+		}
+		password, code, err := r.resolvePassword(ship, opts)
+		if err != nil {
+			return code, err
+		}
+		targets = append(targets, topTarget{ship: ship, password: password})
+	}
+
+	rows := make([]topRow, len(targets))
+	for i, t := range targets {
+		rows[i].Ship = t.ship.Name
+	}
+	results := make(chan topResult, len(targets))
+	poll := func() {
+		for i, t := range targets {
+			go func() {
+				a, err := r.Hangar.Activity(t.ship, t.password)
+				results <- topResult{i: i, a: a, err: err}
+			}()
+		}
+	}
+
+	if *once || !term.IsTerminal(int(os.Stdout.Fd())) {
+		poll()
+		for range targets {
+			res := <-results
+			rows[res.i].update(res.a, res.err, time.Now())
+		}
+		fmt.Print(renderTop(rows, time.Now(), 0))
+		return ExitSuccess, nil
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	refresh := make(chan struct{}, 1)
+	if fd, err := stdinFD(); err == nil && term.IsTerminal(fd) {
+		if old, err := term.MakeRaw(fd); err == nil {
+			defer term.Restore(fd, old)
+			go readTopKeys(stop, refresh)
+		}
+	}
+	// The alternate screen leaves the scrollback as it was on exit.
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer fmt.Print("\x1b[?25h\x1b[?1049l")
+	draw := func() {
+		frame := renderTop(rows, time.Now(), *interval)
+		fmt.Print("\x1b[H\x1b[2J" + strings.ReplaceAll(frame, "\n", "\r\n"))
+	}
+
+	tick := time.NewTicker(*interval)
+	defer tick.Stop()
+	// Polls never overlap: a slow ship delays the next round instead of
+	// piling up SSH sessions.
+	pending := len(targets)
+	poll()
+	draw()
+	for {
+		select {
+		case <-ctx.Done():
+			return ExitSuccess, nil
+		case res := <-results:
+			rows[res.i].update(res.a, res.err, time.Now())
+			pending--
+		case <-refresh:
+			if pending == 0 {
+				pending = len(targets)
+				poll()
+			}
+		case <-tick.C:
+			if pending == 0 {
+				pending = len(targets)
+				poll()
+			}
+		}
+		draw()
+	}
+}
+
+// readTopKeys handles the keys of the raw-mode terminal: q or Ctrl+C quits,
+// r polls right away.
+func readTopKeys(quit func(), refresh chan<- struct{}) {
+	buf := make([]byte, 1)
+	for {
+		if _, err := os.Stdin.Read(buf); err != nil {
+			return
+		}
+		switch buf[0] {
+		case 'q', 'Q', 3, 4:
+			quit()
+			return
+		case 'r', 'R':
+			select {
+			case refresh <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// renderTop draws the table; interval 0 leaves out the live-view header.
+func renderTop(rows []topRow, now time.Time, interval time.Duration) string {
+	var b strings.Builder
+	if interval > 0 {
+		fmt.Fprintf(&b, "[beammeup] top :: %d ships, every %s  (q quit, r refresh)  %s\n\n", len(rows), interval, now.Format("15:04:05"))
+	}
+	fmt.Fprintf(&b, "%-20s %-18s %6s %12s %12s  %s\n", "SHIP", "STATUS", "CONNS", "RX/s", "TX/s", "CHECKED")
+	for _, row := range rows {
+		status, conns, rx, tx, checked := "checking", "-", "-", "-", "-"
+		if !row.Checked.IsZero() {
+			checked = now.Sub(row.Checked).Round(time.Second).String() + " ago"
+			if row.Err != nil {
+				status = "unreachable"
+			} else {
+				status, conns = string(row.Last.Status), fmt.Sprintf("%d", row.Last.Conns)
+			}
+		}
+		if row.Rate {
+			rx, tx = usage.FormatBytes(uint64(row.RXRate))+"/s", usage.FormatBytes(uint64(row.TXRate))+"/s"
+		}
+		fmt.Fprintf(&b, "%-20s %-18s %6s %12s %12s  %s\n", row.Ship, status, conns, rx, tx, checked)
+		if row.Err != nil {
+			msg, _, _ := strings.Cut(row.Err.Error(), "\n")
+			fmt.Fprintf(&b, "  %s\n", msg)
+		}
+	}
+	return b.String()
+}
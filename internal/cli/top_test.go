@@ -0,0 +1,31 @@
+package cli
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alfaoz/beammeup/internal/hangar"
+)
+
+func TestTopRowRate(t *testing.T) {
+	start := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	var row topRow
+	row.update(hangar.Activity{Status: hangar.StatusOnline, RXBytes: 1000, TXBytes: 500, At: start}, nil, start)
+	if row.Rate {
+		t.Fatalf("a single snapshot has no rate: %+v", row)
+	}
+	row.update(hangar.Activity{Status: hangar.StatusOnline, RXBytes: 11000, TXBytes: 2500, At: start.Add(5 * time.Second)}, nil, start)
+	if !row.Rate || row.RXRate != 2000 || row.TXRate != 400 {
+		t.Fatalf("unexpected rate: %+v", row)
+	}
+	row.update(hangar.Activity{}, errors.New("dial tcp: i/o timeout"), start)
+	if row.Rate || row.Last.RXBytes != 11000 {
+		t.Fatalf("a failed poll should drop the rate but keep the snapshot: %+v", row)
+	}
+	// The server rebooted: counters restart from zero.
+	row.update(hangar.Activity{Status: hangar.StatusOnline, RXBytes: 10, TXBytes: 10, At: start.Add(20 * time.Second)}, nil, start)
+	if row.Rate {
+		t.Fatalf("counters that went backwards should not give a rate: %+v", row)
+	}
+}
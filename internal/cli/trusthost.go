@@ -0,0 +1,102 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/alfaoz/beammeup/internal/sshx"
+	"github.com/spf13/pflag"
+	"golang.org/x/crypto/ssh"
+)
+
+// runTrustHost re-trusts a ship's server after its SSH host key changed:
+// it shows the key the server presents now and, once confirmed, makes it the
+// only one known_hosts accepts for that server and clears the key-changed
+// flag on its ships.
+func (r *Runner) runTrustHost(args []string) (int, error) {
+	opts := DefaultOptions()
+	fs := pflag.NewFlagSet("beammeup trust-host", pflag.ContinueOnError)
+	addTargetFlags(fs, &opts)
+	fingerprint := fs.String("fingerprint", "", "Trust only if the server presents this key (SHA256:...); required without a terminal")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return ExitUsage, err
+	}
+	if err := validateTargetFlags(opts); err != nil {
+		return ExitUsage, err
+	}
+	if opts.InsecureHostKey {
+		return ExitUsage, errors.New("--insecure-ignore-host-key does not apply to trust-host")
+	}
+	r.Hangar.SSH = r.sshOptions(opts)
+	if _, err := r.pickShip(&opts); err != nil {
+		return ExitFailure, err
+	}
+	ship, code, err := r.resolveShip(opts, "", "")
+	if err != nil {
+		return code, err
+	}
+
+	key, err := sshx.ScanHostKey(sshx.Target{Host: ship.Host, Port: ship.SSHPort, User: ship.SSHUser}, r.Hangar.SSH)
+	if err != nil {
+		return ExitFailure, err
+	}
+	got := ssh.FingerprintSHA256(key)
+	fmt.Printf("[beammeup] trust-host :: %s (%s)\n", fallback(ship.Name, ship.Host), ship.Host)
+	fmt.Printf("  Server presents: %s %s\n", key.Type(), got)
+	if ship.HostKeyChanged != "" && ship.HostKeyChanged != got {
+		fmt.Printf("  WARNING: the key flagged earlier was %s; it changed again since.\n", ship.HostKeyChanged)
+	}
+
+	switch want := strings.TrimSpace(*fingerprint); {
+	case want != "":
+		if want != got {
+			return ExitFailure, fmt.Errorf("the server presents %s, not %s; nothing was trusted", got, want)
+		}
+	case !stdinIsTerminal():
+		return ExitUsage, errors.New("pass --fingerprint with the key you expect; trusting a host key needs a terminal otherwise")
+	default:
+		fmt.Println("  Compare it with the server's own key, e.g. from the provider's console:")
+		fmt.Println("    ssh-keygen -lf /etc/ssh/ssh_host_ed25519_key.pub")
+		if !confirm("Trust this key?", false) {
+			return ExitFailure, errors.New("cancelled")
+		}
+	}
+	if err := r.hostKeys().Trust(ship, r.Hangar.SSH, key); err != nil {
+		return ExitFailure, err
+	}
+	fmt.Printf("[beammeup] trusted %s for %s.\n", got, ship.Host)
+	return ExitSuccess, nil
+}
+
+// runHashKnownHosts hashes the host names already in known_hosts, so the
+// file no longer lists the ships' servers in plain text.
+func (r *Runner) runHashKnownHosts(args []string) (int, error) {
+	fs := pflag.NewFlagSet("beammeup hash-known-hosts", pflag.ContinueOnError)
+	knownHosts := fs.String("ssh-known-hosts", "", "SSH known_hosts file path")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return ExitUsage, err
+	}
+	path := SSHOptions(Options{SSHKnownHosts: *knownHosts}).KnownHostsPath
+	if path == "" {
+		return ExitFailure, errors.New("ssh known_hosts path not set")
+	}
+	n, err := sshx.HashKnownHostsFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		fmt.Printf("[beammeup] %s does not exist yet; nothing to hash.\n", path)
+	case err != nil:
+		return ExitFailure, err
+	case n == 0:
+		fmt.Printf("[beammeup] %s has no plain host names left.\n", path)
+	case n == 1:
+		fmt.Printf("[beammeup] hashed 1 host name in %s.\n", path)
+	default:
+		fmt.Printf("[beammeup] hashed %d host names in %s.\n", n, path)
+	}
+	if !SSHOptions(Options{}).HashKnownHosts {
+		fmt.Println("  New host keys are still recorded in plain text; set BEAMMEUP_HASH_KNOWN_HOSTS=1 or pass --hash-known-hosts to hash them too.")
+	}
+	return ExitSuccess, nil
+}
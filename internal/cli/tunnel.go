@@ -0,0 +1,215 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alfaoz/beammeup/internal/config"
+	"github.com/alfaoz/beammeup/internal/ships"
+	"github.com/alfaoz/beammeup/internal/tunnel"
+	"github.com/alfaoz/beammeup/internal/usage"
+	"github.com/spf13/pflag"
+)
+
+// tunnelDaemonEnv marks the detached child started by --background. The
+// child reads the SSH password from stdin instead of prompting.
+const tunnelDaemonEnv = "BEAMMEUP_TUNNEL_DAEMON"
+
+const tunnelStartTimeout = 30 * time.Second
+
+func isTunnelDaemon() bool {
+	return os.Getenv(tunnelDaemonEnv) == "1"
+}
+
+// tunnelRunDir holds the control sockets and logs of running tunnels.
+func tunnelRunDir() (string, error) {
+	dir, err := config.Dir()
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, "run")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("create run dir: %w", err)
+	}
+	return dir, nil
+}
+
+func tunnelName(ship ships.Ship) string {
+	if name := ships.SanitizeName(ship.Name); name != "" {
+		return name
+	}
+	return ships.SanitizeName(ship.Host)
+}
+
+// startBackgroundTunnel re-runs the current command line as a detached
+// process and returns once its control socket answers.
+func startBackgroundTunnel(ship ships.Ship, password, controlPath string) (int, error) {
+	if _, err := tunnel.Control(controlPath, tunnel.ControlStatus); err == nil {
+		return ExitFailure, fmt.Errorf("a tunnel for %s is already running; see beammeup tunnel status", tunnelName(ship))
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return ExitFailure, fmt.Errorf("locate beammeup binary: %w", err)
+	}
+	var args []string
+	for _, a := range os.Args[1:] {
+		if a == "--background" || strings.HasPrefix(a, "--background=") || a == "--auto-update" {
+			continue
+		}
+		args = append(args, a)
+	}
+
+	logPath := strings.TrimSuffix(controlPath, ".sock") + ".log"
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return ExitFailure, fmt.Errorf("open tunnel log: %w", err)
+	}
+	defer logFile.Close()
+
+	// The password goes through a pipe so it never shows up in the child's
+	// arguments or environment.
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return ExitFailure, err
+	}
+	defer pr.Close()
+	fmt.Fprintln(pw, password)
+	pw.Close()
+
+	cmd := exec.Command(exe, args...)
+	cmd.Env = append(os.Environ(), tunnelDaemonEnv+"=1", "BEAMMEUP_AUTO_UPDATE=0")
+	cmd.Stdin = pr
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = detachAttr()
+	if err := cmd.Start(); err != nil {
+		return ExitFailure, fmt.Errorf("start background tunnel: %w", err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	deadline := time.After(tunnelStartTimeout)
+	for {
+		if st, err := tunnel.Control(controlPath, tunnel.ControlStatus); err == nil {
+			fmt.Printf("[beammeup] stealth tunnel for %s running in the background (pid %d).\n", tunnelName(ship), st.PID)
+			fmt.Printf("  Local proxy: socks5://%s\n", st.Listen)
+			fmt.Printf("  Log: %s\n", logPath)
+			fmt.Printf("  Status: beammeup tunnel status --ship %s\n", tunnelName(ship))
+			fmt.Printf("  Stop:   beammeup tunnel stop --ship %s\n", tunnelName(ship))
+			return ExitSuccess, nil
+		}
+		select {
+		case err := <-exited:
+			return ExitFailure, fmt.Errorf("background tunnel exited (%v); see %s", err, logPath)
+		case <-deadline:
+			cmd.Process.Kill()
+			return ExitFailure, fmt.Errorf("background tunnel did not come up within %s; see %s", tunnelStartTimeout, logPath)
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+// readDaemonPassword reads the password handed over by startBackgroundTunnel.
+func readDaemonPassword() string {
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimRight(line, "\r\n")
+}
+
+func (r *Runner) runTunnel(args []string) (int, error) {
+	if len(args) > 0 && (args[0] == "install-service" || args[0] == "remove-service") {
+		return r.runTunnelService(args[0], args[1:])
+	}
+	fs := pflag.NewFlagSet("beammeup tunnel", pflag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: beammeup tunnel <status|stop|reload> [--ship <name>]")
+		fmt.Fprintln(os.Stderr, "       beammeup tunnel <install-service|remove-service> --ship <name> [stealth flags]")
+		fs.PrintDefaults()
+	}
+	shipName := fs.String("ship", "", "Ship whose tunnel to control (status: all when omitted)")
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage, err
+	}
+	if fs.NArg() != 1 {
+		return ExitUsage, errors.New("usage: beammeup tunnel <status|stop|reload> [--ship <name>]")
+	}
+	cmd := fs.Arg(0)
+	if cmd != tunnel.ControlStatus && cmd != tunnel.ControlStop && cmd != tunnel.ControlReload {
+		return ExitUsage, fmt.Errorf("unknown tunnel command %q. use status, stop, reload, install-service or remove-service", cmd)
+	}
+	dir, err := tunnelRunDir()
+	if err != nil {
+		return ExitFailure, err
+	}
+
+	name := ships.SanitizeName(*shipName)
+	if name == "" {
+		if cmd != tunnel.ControlStatus {
+			return ExitUsage, fmt.Errorf("tunnel %s needs --ship", cmd)
+		}
+		return listTunnels(dir)
+	}
+	path := filepath.Join(dir, name+".sock")
+	st, err := tunnel.Control(path, cmd)
+	if err != nil {
+		return ExitFailure, fmt.Errorf("tunnel %s: %w", name, err)
+	}
+	switch cmd {
+	case tunnel.ControlStatus:
+		printTunnelStatus(name, st, time.Now())
+	case tunnel.ControlStop:
+		fmt.Printf("[beammeup] tunnel %s stopping.\n", name)
+	case tunnel.ControlReload:
+		fmt.Printf("[beammeup] tunnel %s reconnected to its server.\n", name)
+	}
+	return ExitSuccess, nil
+}
+
+func listTunnels(dir string) (int, error) {
+	socks, err := filepath.Glob(filepath.Join(dir, "*.sock"))
+	if err != nil {
+		return ExitFailure, err
+	}
+	sort.Strings(socks)
+	found := false
+	for _, path := range socks {
+		st, err := tunnel.Control(path, tunnel.ControlStatus)
+		if err != nil {
+			// Left behind by a tunnel that did not shut down cleanly.
+			os.Remove(path)
+			continue
+		}
+		found = true
+		printTunnelStatus(strings.TrimSuffix(filepath.Base(path), ".sock"), st, time.Now())
+	}
+	if !found {
+		fmt.Println("[beammeup] no tunnels running.")
+	}
+	return ExitSuccess, nil
+}
+
+func printTunnelStatus(name string, st tunnel.Status, now time.Time) {
+	fmt.Printf("\n[beammeup] tunnel :: %s (pid %d)\n", name, st.PID)
+	fmt.Printf("  Local proxy: socks5://%s\n", st.Listen)
+	fmt.Printf("  Server: %s\n", st.Host)
+	fmt.Printf("  Up since: %s (%s)\n", st.Started.Local().Format("2006-01-02 15:04"), now.Sub(st.Started).Round(time.Second))
+	fmt.Printf("  Traffic: %s up, %s down over %d connections\n", usage.FormatBytes(uint64(st.BytesUp)), usage.FormatBytes(uint64(st.BytesDown)), st.Served)
+	if !st.KeptAlive.IsZero() {
+		fmt.Printf("  Smart blinder: kept alive by this tunnel (%s ago)\n", now.Sub(st.KeptAlive).Round(time.Second))
+	}
+	if len(st.Conns) == 0 {
+		fmt.Println("  Open connections: none")
+		return
+	}
+	fmt.Printf("  Open connections (%d):\n", len(st.Conns))
+	for _, c := range st.Conns {
+		fmt.Printf("    %-40s  %8s  %10s up  %10s down\n", c.Target, now.Sub(c.Since).Round(time.Second),
+			usage.FormatBytes(uint64(c.BytesUp)), usage.FormatBytes(uint64(c.BytesDown)))
+	}
+}
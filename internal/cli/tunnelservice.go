@@ -0,0 +1,468 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/alfaoz/beammeup/internal/config"
+	"github.com/alfaoz/beammeup/internal/hangar"
+	"github.com/alfaoz/beammeup/internal/hostkey"
+	"github.com/alfaoz/beammeup/internal/ships"
+	"github.com/alfaoz/beammeup/internal/tunnel"
+	"github.com/spf13/pflag"
+)
+
+// tunnelService is a stealth tunnel the operating system starts at login
+// and restarts when it fails: a systemd user unit on Linux, a launchd agent
+// on macOS and a scheduled task on Windows. It runs beammeup in the
+// foreground, so it can only log in with SSH keys.
+type tunnelService struct {
+	Name string // the ship's sanitized name
+	Exe  string
+	Args []string // beammeup's arguments
+	// Linux: SSH_AUTH_SOCK for the unit, relative to the user's runtime
+	// directory (%t); see agentSocket.
+	AgentSocket string
+	Log         string // launchd appends the tunnel's output here
+	User        string // Windows: the account the task logs on as
+}
+
+// serviceExec runs systemctl, launchctl or schtasks. Tests replace it.
+var serviceExec = func(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %w: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// runTunnelService handles beammeup tunnel install-service and
+// remove-service.
+func (r *Runner) runTunnelService(cmd string, args []string) (int, error) {
+	opts := DefaultOptions()
+	fs := pflag.NewFlagSet("beammeup tunnel "+cmd, pflag.ContinueOnError)
+	fs.StringVar(&opts.ShipName, "ship", "", "Saved ship whose stealth tunnel to run as a service")
+	if cmd == "install-service" {
+		fs.IntVar(&opts.ProxyPort, "proxy-port", 0, "Local SOCKS5 port (default 1080)")
+		fs.StringVar(&opts.RulesFile, "rules", "", "Routing rules file sending destinations through the tunnel, direct, or nowhere")
+		fs.StringVar(&opts.ConnLog, "conn-log", "", "Append a JSON line per connection to this file")
+		fs.DurationVar(&opts.IdleTimeout, "idle-timeout", 0, "Close connections that carry no traffic for this long (default 15m)")
+		fs.IntVar(&opts.TunnelMaxConns, "tunnel-max-conns", 0, "Connections the tunnel serves at once (default 256)")
+		fs.StringVar(&opts.TunnelRate, "tunnel-rate", "", "Cap the tunnel's bandwidth in each direction, e.g. 2M (bytes/s)")
+		fs.StringVar(&opts.TunnelConnRate, "tunnel-conn-rate", "", "Cap each connection's bandwidth in each direction, e.g. 500k")
+		fs.BoolVar(&opts.StealthAllowBind, "allow-bind", false, "Accept SOCKS5 BIND")
+	}
+	if err := parseCommandFlags(fs, args); err != nil {
+		return ExitUsage, err
+	}
+	name := ships.SanitizeName(opts.ShipName)
+	if name == "" {
+		return ExitUsage, fmt.Errorf("tunnel %s needs --ship <name> of a saved ship", cmd)
+	}
+	if cmd == "remove-service" {
+		if err := removeTunnelService(name); err != nil {
+			return ExitFailure, err
+		}
+		fmt.Printf("[beammeup] tunnel service for %s removed.\n", name)
+		return ExitSuccess, nil
+	}
+
+	ship, err := r.Store.Load(name)
+	if err != nil {
+		return ExitFailure, err
+	}
+	if err := hostkey.Check(ship); err != nil {
+		return ExitFailure, err
+	}
+	// The service starts unattended, so nobody is there to type a password
+	// and the profile never stores one.
+	r.Hangar.SSH = r.sshOptions(opts)
+	if !r.Hangar.KeyLogin(ship) {
+		return ExitFailure, fmt.Errorf("%s logs in with a password, which a tunnel service has no way to enter; set SSH_IDENTITY_FILE in its profile, or load its key into ssh-agent", ship.Name)
+	}
+	if _, err := tunnelConfig(opts); err != nil {
+		return ExitUsage, err
+	}
+	svc, err := newTunnelService(ship, opts)
+	if err != nil {
+		return ExitFailure, err
+	}
+	if dir, err := tunnelRunDir(); err == nil {
+		if _, err := tunnel.Control(filepath.Join(dir, name+".sock"), tunnel.ControlStatus); err == nil {
+			return ExitFailure, fmt.Errorf("a tunnel for %s is already running; stop it first with beammeup tunnel stop --ship %s", name, name)
+		}
+	}
+	where, err := installTunnelService(svc)
+	if err != nil {
+		return ExitFailure, err
+	}
+	fmt.Printf("[beammeup] stealth tunnel for %s installed as a service and started.\n", name)
+	fmt.Printf("  Service: %s\n", where)
+	fmt.Printf("  Status: beammeup tunnel status --ship %s\n", name)
+	fmt.Printf("  Remove: beammeup tunnel remove-service --ship %s\n", name)
+	if runtime.GOOS == "linux" {
+		fmt.Println("  It starts when you log in; to start it at boot, run: loginctl enable-linger")
+		if sock, stable := agentSocket(os.Getenv("SSH_AUTH_SOCK"), os.Getenv("XDG_RUNTIME_DIR")); !stable && !r.keyFileLogin(ship) {
+			fmt.Printf("  Note: %s logs in through ssh-agent only, and this session's agent is gone after a reboot. The service looks for one at $XDG_RUNTIME_DIR/%s (systemctl --user enable --now ssh-agent); or set SSH_IDENTITY_FILE in the ship's profile.\n", name, sock)
+		}
+	}
+	return ExitSuccess, nil
+}
+
+// keyFileLogin reports whether ship has a key file to log in with, its own
+// SSH_IDENTITY_FILE or one from ~/.ssh/config, rather than only the agent.
+func (r *Runner) keyFileLogin(ship ships.Ship) bool {
+	for _, path := range r.Hangar.SSH.Resolve(hangar.ShipTarget(ship, "")).IdentityFiles {
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// newTunnelService describes the service running ship's stealth tunnel with
+// the tunnel settings in opts.
+func newTunnelService(ship ships.Ship, opts Options) (tunnelService, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return tunnelService{}, fmt.Errorf("locate beammeup binary: %w", err)
+	}
+	svc := tunnelService{Name: tunnelName(ship), Exe: exe}
+	if profile := config.Profile(); profile != "" {
+		svc.Args = append(svc.Args, "--profile", profile)
+	}
+	svc.Args = append(svc.Args, "--ship", svc.Name, "--stealth")
+	if opts.ProxyPort > 0 {
+		svc.Args = append(svc.Args, "--proxy-port", strconv.Itoa(opts.ProxyPort))
+	}
+	// Services start in another directory, so files are passed absolute.
+	for _, f := range []struct{ flag, path string }{{"--rules", opts.RulesFile}, {"--conn-log", opts.ConnLog}} {
+		if f.path == "" {
+			continue
+		}
+		path, err := filepath.Abs(f.path)
+		if err != nil {
+			return tunnelService{}, err
+		}
+		svc.Args = append(svc.Args, f.flag, path)
+	}
+	if opts.IdleTimeout > 0 {
+		svc.Args = append(svc.Args, "--idle-timeout", opts.IdleTimeout.String())
+	}
+	if opts.TunnelMaxConns > 0 {
+		svc.Args = append(svc.Args, "--tunnel-max-conns", strconv.Itoa(opts.TunnelMaxConns))
+	}
+	if opts.TunnelRate != "" {
+		svc.Args = append(svc.Args, "--tunnel-rate", opts.TunnelRate)
+	}
+	if opts.TunnelConnRate != "" {
+		svc.Args = append(svc.Args, "--tunnel-conn-rate", opts.TunnelConnRate)
+	}
+	if opts.StealthAllowBind {
+		svc.Args = append(svc.Args, "--allow-bind")
+	}
+
+	switch runtime.GOOS {
+	case "linux":
+		// systemd does not hand the login session's agent to user units.
+		svc.AgentSocket, _ = agentSocket(os.Getenv("SSH_AUTH_SOCK"), os.Getenv("XDG_RUNTIME_DIR"))
+	case "darwin":
+		if dir, err := tunnelRunDir(); err == nil {
+			svc.Log = filepath.Join(dir, svc.Name+".log")
+		}
+	case "windows":
+		u, err := user.Current()
+		if err != nil {
+			return tunnelService{}, fmt.Errorf("look up the current user: %w", err)
+		}
+		svc.User = u.Username
+	}
+	return svc, nil
+}
+
+// agentSocket is where the tunnel unit finds ssh-agent, relative to the
+// user's runtime directory: the login session's socket when it lives there
+// (GNOME Keyring's keyring/ssh, say), else ssh-agent.socket, where the
+// ssh-agent user unit listens. A per-session path like /tmp/ssh-XXXX/agent.N
+// is gone after a reboot, so it is never written to the unit; stable reports
+// whether sock itself was kept.
+func agentSocket(sock, runtimeDir string) (rel string, stable bool) {
+	sock, runtimeDir = strings.TrimSpace(sock), strings.TrimSpace(runtimeDir)
+	if sock != "" && runtimeDir != "" {
+		if rel, err := filepath.Rel(runtimeDir, sock); err == nil && filepath.IsLocal(rel) {
+			return filepath.ToSlash(rel), true
+		}
+	}
+	return "ssh-agent.socket", false
+}
+
+func systemdUnitPath(name string) (string, error) {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("resolve home dir: %w", err)
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "systemd", "user", "beammeup-tunnel-"+name+".service"), nil
+}
+
+func launchdLabel(name string) string { return "pw.beammeup.tunnel." + name }
+
+func launchdPlistPath(name string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("resolve home dir: %w", err)
+	}
+	return filepath.Join(home, "Library", "LaunchAgents", launchdLabel(name)+".plist"), nil
+}
+
+func scheduledTaskName(name string) string { return "beammeup-tunnel-" + name }
+
+// installTunnelService writes svc for the running operating system, starts
+// it and returns where it went.
+func installTunnelService(svc tunnelService) (string, error) {
+	switch runtime.GOOS {
+	case "linux":
+		path, err := systemdUnitPath(svc.Name)
+		if err != nil {
+			return "", err
+		}
+		if err := writeServiceFile(path, []byte(systemdTunnelUnit(svc))); err != nil {
+			return "", err
+		}
+		if err := serviceExec("systemctl", "--user", "daemon-reload"); err != nil {
+			return "", err
+		}
+		return path, serviceExec("systemctl", "--user", "enable", "--now", filepath.Base(path))
+	case "darwin":
+		path, err := launchdPlistPath(svc.Name)
+		if err != nil {
+			return "", err
+		}
+		if err := writeServiceFile(path, []byte(launchdTunnelPlist(svc))); err != nil {
+			return "", err
+		}
+		// Reinstalling replaces the loaded agent.
+		serviceExec("launchctl", "unload", path)
+		return path, serviceExec("launchctl", "load", "-w", path)
+	case "windows":
+		task, err := os.CreateTemp("", "beammeup-task-*.xml")
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(task.Name())
+		_, err = task.Write(utf16LE(windowsTunnelTask(svc)))
+		if cerr := task.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return "", err
+		}
+		name := scheduledTaskName(svc.Name)
+		if err := serviceExec("schtasks", "/Create", "/TN", name, "/XML", task.Name(), "/F"); err != nil {
+			return "", err
+		}
+		return "scheduled task " + name, serviceExec("schtasks", "/Run", "/TN", name)
+	}
+	return "", fmt.Errorf("tunnel services are not supported on %s", runtime.GOOS)
+}
+
+// removeTunnelService stops the service for the ship named name and
+// deletes it.
+func removeTunnelService(name string) error {
+	switch runtime.GOOS {
+	case "linux":
+		path, err := systemdUnitPath(name)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("no tunnel service installed for %s", name)
+		}
+		if err := serviceExec("systemctl", "--user", "disable", "--now", filepath.Base(path)); err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		return serviceExec("systemctl", "--user", "daemon-reload")
+	case "darwin":
+		path, err := launchdPlistPath(name)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("no tunnel service installed for %s", name)
+		}
+		if err := serviceExec("launchctl", "unload", "-w", path); err != nil {
+			return err
+		}
+		return os.Remove(path)
+	case "windows":
+		task := scheduledTaskName(name)
+		// Ending a task that is not running fails; deleting it is what counts.
+		serviceExec("schtasks", "/End", "/TN", task)
+		return serviceExec("schtasks", "/Delete", "/TN", task, "/F")
+	}
+	return fmt.Errorf("tunnel services are not supported on %s", runtime.GOOS)
+}
+
+func writeServiceFile(path string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, 0o644)
+}
+
+// systemdTunnelUnit renders svc as a systemd user unit. It restarts the
+// tunnel when it fails, but not after beammeup tunnel stop.
+func systemdTunnelUnit(svc tunnelService) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[Unit]\nDescription=beammeup stealth tunnel (%s)\n", svc.Name)
+	// Keep retrying while the network is not up yet after boot.
+	b.WriteString("StartLimitIntervalSec=0\n\n[Service]\n")
+	if svc.AgentSocket != "" {
+		// %t stays a specifier: it is the runtime directory at each start.
+		fmt.Fprintf(&b, "Environment=\"SSH_AUTH_SOCK=%%t/%s\n", strings.TrimPrefix(systemdQuote(svc.AgentSocket), `"`))
+	}
+	b.WriteString("ExecStart=" + systemdQuote(svc.Exe))
+	for _, a := range svc.Args {
+		b.WriteString(" " + systemdQuote(a))
+	}
+	b.WriteString("\nRestart=on-failure\nRestartSec=10\n\n[Install]\nWantedBy=default.target\n")
+	return b.String()
+}
+
+// systemdQuote quotes s as one word of a unit file command line, keeping
+// systemd from expanding specifiers (%) and variables ($) in it.
+func systemdQuote(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%", "$", "$$").Replace(s)
+	return `"` + s + `"`
+}
+
+// launchdTunnelPlist renders svc as a launchd agent that runs at login and
+// is restarted when the tunnel fails.
+func launchdTunnelPlist(svc tunnelService) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+`)
+	fmt.Fprintf(&b, "\t<key>Label</key>\n\t<string>%s</string>\n", xmlText(launchdLabel(svc.Name)))
+	b.WriteString("\t<key>ProgramArguments</key>\n\t<array>\n")
+	for _, a := range append([]string{svc.Exe}, svc.Args...) {
+		fmt.Fprintf(&b, "\t\t<string>%s</string>\n", xmlText(a))
+	}
+	b.WriteString("\t</array>\n")
+	b.WriteString("\t<key>RunAtLoad</key>\n\t<true/>\n")
+	b.WriteString("\t<key>KeepAlive</key>\n\t<dict>\n\t\t<key>SuccessfulExit</key>\n\t\t<false/>\n\t</dict>\n")
+	b.WriteString("\t<key>ThrottleInterval</key>\n\t<integer>10</integer>\n")
+	if svc.Log != "" {
+		fmt.Fprintf(&b, "\t<key>StandardOutPath</key>\n\t<string>%s</string>\n", xmlText(svc.Log))
+		fmt.Fprintf(&b, "\t<key>StandardErrorPath</key>\n\t<string>%s</string>\n", xmlText(svc.Log))
+	}
+	b.WriteString("</dict>\n</plist>\n")
+	return b.String()
+}
+
+// windowsTunnelTask renders svc as Task Scheduler XML. The task starts when
+// svc.User logs on and runs without a window (S4U, which stores no
+// password); it has no time limit and is restarted when the tunnel fails.
+func windowsTunnelTask(svc tunnelService) string {
+	args := make([]string, len(svc.Args))
+	for i, a := range svc.Args {
+		args[i] = windowsQuoteArg(a)
+	}
+	user := xmlText(svc.User)
+	return `<?xml version="1.0" encoding="UTF-16"?>
+<Task version="1.2" xmlns="http://schemas.microsoft.com/windows/2004/02/mit/task">
+  <RegistrationInfo>
+    <Description>beammeup stealth tunnel (` + xmlText(svc.Name) + `)</Description>
+  </RegistrationInfo>
+  <Triggers>
+    <LogonTrigger>
+      <Enabled>true</Enabled>
+      <UserId>` + user + `</UserId>
+    </LogonTrigger>
+  </Triggers>
+  <Principals>
+    <Principal id="Author">
+      <UserId>` + user + `</UserId>
+      <LogonType>S4U</LogonType>
+      <RunLevel>LeastPrivilege</RunLevel>
+    </Principal>
+  </Principals>
+  <Settings>
+    <MultipleInstancesPolicy>IgnoreNew</MultipleInstancesPolicy>
+    <DisallowStartIfOnBatteries>false</DisallowStartIfOnBatteries>
+    <StopIfGoingOnBatteries>false</StopIfGoingOnBatteries>
+    <StartWhenAvailable>true</StartWhenAvailable>
+    <ExecutionTimeLimit>PT0S</ExecutionTimeLimit>
+    <RestartOnFailure>
+      <Interval>PT1M</Interval>
+      <Count>999</Count>
+    </RestartOnFailure>
+    <Hidden>true</Hidden>
+  </Settings>
+  <Actions Context="Author">
+    <Exec>
+      <Command>` + xmlText(windowsQuoteArg(svc.Exe)) + `</Command>
+      <Arguments>` + xmlText(strings.Join(args, " ")) + `</Arguments>
+    </Exec>
+  </Actions>
+</Task>
+`
+}
+
+// windowsQuoteArg quotes s the way the C runtime splits a command line.
+func windowsQuoteArg(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\"") {
+		return s
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	slashes := 0
+	for _, c := range s {
+		switch c {
+		case '\\':
+			slashes++
+			continue
+		case '"':
+			b.WriteString(strings.Repeat(`\`, 2*slashes+1))
+		default:
+			b.WriteString(strings.Repeat(`\`, slashes))
+		}
+		slashes = 0
+		b.WriteRune(c)
+	}
+	b.WriteString(strings.Repeat(`\`, 2*slashes))
+	b.WriteByte('"')
+	return b.String()
+}
+
+func xmlText(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// utf16LE encodes s with a byte order mark, as schtasks /XML expects.
+func utf16LE(s string) []byte {
+	out := []byte{0xff, 0xfe}
+	for _, u := range utf16.Encode([]rune(s)) {
+		out = append(out, byte(u), byte(u>>8))
+	}
+	return out
+}
@@ -0,0 +1,137 @@
+package cli
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/alfaoz/beammeup/internal/hangar"
+	"github.com/alfaoz/beammeup/internal/ships"
+)
+
+func TestTunnelServiceRefusesPasswordShips(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	store, err := ships.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Save(ships.Ship{Name: "edge", Host: "192.0.2.10", SSHPort: 22, SSHUser: "root"}); err != nil {
+		t.Fatal(err)
+	}
+	svc := hangar.NewService()
+	svc.ScriptRunner = func(string, []string) (string, error) { return "", nil }
+	defer func(orig func(string, ...string) error) { serviceExec = orig }(serviceExec)
+	serviceExec = func(name string, args ...string) error {
+		t.Fatalf("ran %s %q for a ship without key login", name, args)
+		return nil
+	}
+	r := &Runner{Store: store, Hangar: svc}
+	_, err = r.runTunnel([]string{"install-service", "--ship", "edge"})
+	if err == nil || !strings.Contains(err.Error(), "password") {
+		t.Fatalf("install-service = %v, want a refusal naming the password", err)
+	}
+	if _, err := r.runTunnel([]string{"install-service"}); err == nil {
+		t.Fatal("install-service without --ship should fail")
+	}
+}
+
+func TestTunnelServiceFiles(t *testing.T) {
+	svc := tunnelService{
+		Name:        "edge",
+		Exe:         `C:\Program Files\beammeup\beammeup.exe`,
+		Args:        []string{"--ship", "edge", "--stealth", "--rules", `C:\Users\a b\100% & "more".txt`},
+		Log:         "/Users/ops/.beammeup/run/edge.log",
+		User:        `HOST\ops`,
+		AgentSocket: "keyring/ssh",
+	}
+
+	unit := systemdTunnelUnit(svc)
+	for _, want := range []string{
+		`ExecStart="C:\\Program Files\\beammeup\\beammeup.exe" "--ship" "edge" "--stealth" "--rules" "C:\\Users\\a b\\100%% & \"more\".txt"`,
+		`Environment="SSH_AUTH_SOCK=%t/keyring/ssh"`,
+		"Restart=on-failure",
+		"WantedBy=default.target",
+	} {
+		if !strings.Contains(unit, want) {
+			t.Fatalf("systemd unit lacks %s:\n%s", want, unit)
+		}
+	}
+
+	var plist struct {
+		Strings []string `xml:"dict>array>string"`
+	}
+	if err := xml.Unmarshal([]byte(launchdTunnelPlist(svc)), &plist); err != nil {
+		t.Fatalf("plist: %v", err)
+	}
+	if got := plist.Strings[len(plist.Strings)-1]; got != svc.Args[len(svc.Args)-1] {
+		t.Fatalf("plist rules path = %q", got)
+	}
+
+	var task struct {
+		UserID    string `xml:"Principals>Principal>UserId"`
+		Command   string `xml:"Actions>Exec>Command"`
+		Arguments string `xml:"Actions>Exec>Arguments"`
+		Limit     string `xml:"Settings>ExecutionTimeLimit"`
+	}
+	encoded := utf16LE(windowsTunnelTask(svc))
+	if encoded[0] != 0xff || encoded[1] != 0xfe {
+		t.Fatal("task XML lacks the UTF-16 byte order mark")
+	}
+	units := make([]uint16, 0, len(encoded)/2)
+	for i := 2; i < len(encoded); i += 2 {
+		units = append(units, uint16(encoded[i])|uint16(encoded[i+1])<<8)
+	}
+	dec := xml.NewDecoder(strings.NewReader(string(utf16.Decode(units))))
+	dec.CharsetReader = func(_ string, r io.Reader) (io.Reader, error) { return r, nil }
+	if err := dec.Decode(&task); err != nil {
+		t.Fatalf("task XML: %v", err)
+	}
+	if task.UserID != svc.User || task.Limit != "PT0S" || task.Command != `"C:\Program Files\beammeup\beammeup.exe"` {
+		t.Fatalf("task = %+v", task)
+	}
+	if want := `--ship edge --stealth --rules "C:\Users\a b\100% & \"more\".txt"`; task.Arguments != want {
+		t.Fatalf("task arguments = %s, want %s", task.Arguments, want)
+	}
+}
+
+func TestWindowsQuoteArg(t *testing.T) {
+	for in, want := range map[string]string{
+		"--stealth":     "--stealth",
+		"":              `""`,
+		`C:\a b\`:       `"C:\a b\\"`,
+		`say "hi"`:      `"say \"hi\""`,
+		`C:\no-space\x`: `C:\no-space\x`,
+		`a\\"b c`:       `"a\\\\\"b c"`,
+	} {
+		if got := windowsQuoteArg(in); got != want {
+			t.Errorf("windowsQuoteArg(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestTunnelServiceAgentSocket(t *testing.T) {
+	for _, c := range []struct {
+		sock, runtime, want string
+		stable              bool
+	}{
+		// A session agent's socket does not outlive the session, so an
+		// agent-only ship's unit points at the ssh-agent user unit instead.
+		{"/tmp/ssh-XXXXabcd/agent.1234", "/run/user/1000", "ssh-agent.socket", false},
+		{"", "/run/user/1000", "ssh-agent.socket", false},
+		{"/run/user/1000/keyring/ssh", "/run/user/1000", "keyring/ssh", true},
+		{"/run/user/1000/ssh-agent.socket", "/run/user/1000/", "ssh-agent.socket", true},
+		{"/run/user/1000/../1001/agent", "/run/user/1000", "ssh-agent.socket", false},
+	} {
+		got, stable := agentSocket(c.sock, c.runtime)
+		if got != c.want || stable != c.stable {
+			t.Errorf("agentSocket(%q, %q) = %q, %v; want %q, %v", c.sock, c.runtime, got, stable, c.want, c.stable)
+		}
+	}
+
+	unit := systemdTunnelUnit(tunnelService{Name: "edge", Exe: "/usr/bin/beammeup", AgentSocket: "ssh-agent.socket"})
+	if !strings.Contains(unit, "Environment=\"SSH_AUTH_SOCK=%t/ssh-agent.socket\"\n") || strings.Contains(unit, "/tmp/") {
+		t.Fatalf("unit should find the agent under the runtime directory:\n%s", unit)
+	}
+}
@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/alfaoz/beammeup/internal/usage"
+	"github.com/spf13/pflag"
+)
+
+func (r *Runner) runUsage(args []string) (int, error) {
+	opts := DefaultOptions()
+	fs := pflag.NewFlagSet("beammeup usage", pflag.ContinueOnError)
+	addTargetFlags(fs, &opts)
+	quotaGB := fs.Int("quota-gb", 0, "Monthly traffic quota in GB (default: ship setting)")
+	warnPercent := fs.Int("warn-percent", 0, "Warn at this percentage of the quota (default: ship setting)")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return ExitUsage, err
+	}
+	if *quotaGB < 0 {
+		return ExitUsage, fmt.Errorf("--quota-gb must be >= 0")
+	}
+	if fs.Changed("warn-percent") && (*warnPercent <= 0 || *warnPercent > 100) {
+		return ExitUsage, fmt.Errorf("--warn-percent must be between 1 and 100")
+	}
+	if r.Usage == nil {
+		return ExitFailure, fmt.Errorf("usage history store is not available")
+	}
+
+	ship, password, code, err := r.prepareTarget(opts)
+	if err != nil {
+		return code, err
+	}
+	if fs.Changed("quota-gb") {
+		ship.TrafficQuotaGB = *quotaGB
+	}
+	if fs.Changed("warn-percent") {
+		ship.TrafficWarnPercent = *warnPercent
+	}
+
+	u, err := r.Hangar.Usage(ship, password)
+	if err != nil {
+		return ExitFailure, err
+	}
+	rep, err := r.Usage.Record(ship.Name, usage.Sample{
+		Source: u.Source,
+		Period: u.Period,
+		RX:     u.RXBytes,
+		TX:     u.TXBytes,
+	}, usage.Quota{GB: ship.TrafficQuotaGB, WarnPercent: ship.TrafficWarnPercent})
+	if err != nil {
+		return ExitFailure, err
+	}
+
+	fmt.Printf("[beammeup] traffic :: %s\n", ship.Name)
+	fmt.Printf("  Month: %s\n", rep.Month)
+	fmt.Printf("  Source: %s (%s)\n", rep.Source, fallback(u.Iface, "unknown interface"))
+	fmt.Printf("  Used: %s\n", usage.FormatBytes(rep.Used))
+	if rep.Quota.Bytes() > 0 {
+		fmt.Printf("  Quota: %s (%.0f%% used)\n", usage.FormatBytes(rep.Quota.Bytes()), rep.Percent())
+	} else {
+		fmt.Printf("  Quota: not set (use --quota-gb or TRAFFIC_QUOTA_GB in the ship file)\n")
+	}
+
+	if rep.Level != usage.LevelOK {
+		fmt.Fprintf(os.Stderr, "[beammeup] WARNING: %s: %s\n", rep.Level, rep.Summary())
+	}
+	if rep.Crossed && r.Notifier != nil {
+		if err := r.Notifier.Notify(rep.Alert()); err != nil {
+			fmt.Fprintf(os.Stderr, "[beammeup] notification failed: %v\n", err)
+		}
+	}
+	return ExitSuccess, nil
+}
@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/alfaoz/beammeup/internal/usage"
+	"github.com/spf13/pflag"
+)
+
+func (r *Runner) runUsers(args []string) (int, error) {
+	opts := DefaultOptions()
+	fs := pflag.NewFlagSet("beammeup users", pflag.ContinueOnError)
+	addTargetFlags(fs, &opts)
+	if err := parseCommandFlags(fs, args); err != nil {
+		return ExitUsage, err
+	}
+
+	ship, password, code, err := r.prepareTarget(opts)
+	if err != nil {
+		return code, err
+	}
+	if ship.Protocol == "socks5" {
+		return ExitUsage, fmt.Errorf("per-user traffic needs the HTTP proxy; microsocks keeps no access log")
+	}
+
+	users, since, err := r.Hangar.UserTraffic(ship, password)
+	if err != nil {
+		return ExitFailure, err
+	}
+
+	fmt.Printf("[beammeup] traffic by user :: %s\n", ship.Name)
+	if !since.IsZero() {
+		fmt.Printf("  Since: %s (start of the current access log)\n", since.Local().Format("2006-01-02 15:04"))
+	}
+	if len(users) == 0 {
+		fmt.Println("  No requests logged yet.")
+		return ExitSuccess, nil
+	}
+	var total uint64
+	for _, u := range users {
+		total += u.Bytes
+	}
+	fmt.Printf("  %-24s  %10s  %10s  %6s\n", "USER", "REQUESTS", "SENT", "SHARE")
+	for _, u := range users {
+		name := u.User
+		if name == "-" {
+			name = "(unauthenticated)"
+		}
+		share := 0.0
+		if total > 0 {
+			share = float64(u.Bytes) * 100 / float64(total)
+		}
+		fmt.Printf("  %-24s  %10d  %10s  %5.1f%%\n", name, u.Requests, usage.FormatBytes(u.Bytes), share)
+	}
+	return ExitSuccess, nil
+}
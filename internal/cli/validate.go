@@ -0,0 +1,421 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alfaoz/beammeup/internal/cloudfw"
+	"github.com/alfaoz/beammeup/internal/exports"
+	"github.com/alfaoz/beammeup/internal/hangar"
+	"github.com/alfaoz/beammeup/internal/ships"
+	"github.com/alfaoz/beammeup/internal/tunnel"
+	"github.com/spf13/pflag"
+)
+
+// finding is one problem validate reports. Line is 0 for problems of the
+// whole ship or of the fleet.
+type finding struct {
+	File    string
+	Line    int
+	Ship    string
+	Warning bool
+	Msg     string
+}
+
+func (f finding) String() string {
+	where := f.Ship
+	if f.File != "" {
+		where = f.File
+		if f.Line > 0 {
+			where += ":" + strconv.Itoa(f.Line)
+		}
+	}
+	level := "error"
+	if f.Warning {
+		level = "warning"
+	}
+	return where + ": " + level + ": " + f.Msg
+}
+
+// shipKeys checks the value of every key a .ship file may hold; the returned
+// message is empty when the value is fine. Empty values keep the default and
+// are not passed in.
+var shipKeys = map[string]func(v string) string{
+	"HOST": func(v string) string {
+		if strings.ContainsAny(v, " \t/") {
+			return "not a host name or IP address"
+		}
+		return ""
+	},
+	"SSH_PORT":                   checkPort,
+	"SSH_USER":                   checkNoSpace,
+	"SSH_IDENTITY_FILE":          func(string) string { return "" },
+	"PROTOCOL":                   checkOneOf("http", "socks5"),
+	"HTTP_MODE":                  checkOneOf("managed", "sidecar"),
+	"PROXY_PORT":                 checkPort,
+	"NO_FIREWALL_CHANGE":         checkBool,
+	"LISTEN_LOCAL":               checkBool,
+	"SMART_BLINDER":              checkOneOf("0", "1", "true", "false", "yes", "no"),
+	"SMART_BLINDER_IDLE_MINUTES": checkCount,
+	"TRAFFIC_QUOTA_GB":           checkCount,
+	"TRAFFIC_WARN_PERCENT": func(v string) string {
+		if n, err := strconv.Atoi(v); err != nil || n < 1 || n > 100 {
+			return "want a percentage from 1 to 100"
+		}
+		return ""
+	},
+	"TRAFFIC_CAP_GB": checkCount,
+	"QUIET_HOURS": func(v string) string {
+		if _, err := hangar.NormalizeQuietHours(v); err != nil {
+			return err.Error()
+		}
+		return ""
+	},
+	"MAX_CONNS":  checkCount,
+	"RATE_LIMIT": checkCount,
+	"MEMORY_MAX": func(v string) string {
+		if _, err := normalizeMemoryMax(v); err != nil {
+			return "want a size like 256M or 1G"
+		}
+		return ""
+	},
+	"CPU_QUOTA": func(v string) string {
+		if _, err := normalizeCPUQuota(v); err != nil {
+			return "want a percentage like 50%"
+		}
+		return ""
+	},
+	"TASKS_MAX": checkCount,
+	"EGRESS_IP": func(v string) string {
+		if net.ParseIP(v) == nil {
+			return "not an IP address"
+		}
+		return ""
+	},
+	"SOCKET_ACTIVATION": checkBool,
+	"INSTANCE": func(v string) string {
+		if !ships.ValidInstanceName(v) {
+			return "want 1-32 characters of a-z, 0-9 and '-'"
+		}
+		return ""
+	},
+	"CACHE_MB": checkCount,
+	"CACHE_DIR": func(v string) string {
+		if !strings.HasPrefix(v, "/") {
+			return "want an absolute path"
+		}
+		return ""
+	},
+	"ANONYMITY":          checkOneOf("elite", "anonymous", "transparent"),
+	"ALLOW_DOMAINS":      checkNoSpace,
+	"DENY_DOMAINS":       checkNoSpace,
+	"TAGS":               func(string) string { return "" },
+	"HANGAR_LABEL":       checkHangarInfo,
+	"HANGAR_DESCRIPTION": checkHangarInfo,
+	"EXPORTS": func(v string) string {
+		for _, t := range strings.Split(v, ",") {
+			if _, err := exports.ParseTarget(strings.TrimSpace(t)); err != nil {
+				return err.Error()
+			}
+		}
+		return ""
+	},
+	"FORWARDS": func(v string) string {
+		for _, spec := range strings.Split(v, ",") {
+			if _, err := tunnel.ParseForward(spec); err != nil {
+				return err.Error()
+			}
+		}
+		return ""
+	},
+	"CLOUD_FIREWALL":      checkOneOf(cloudfw.Providers...),
+	"CLOUD_FIREWALL_PORT": checkCount,
+	"EXPIRES_AT": func(v string) string {
+		if _, err := time.Parse(time.RFC3339, v); err != nil {
+			return "want an RFC 3339 time like 2026-01-02T15:04:05Z"
+		}
+		return ""
+	},
+	"HOST_KEY_CHANGED": func(string) string { return "" },
+}
+
+func checkPort(v string) string {
+	if n, err := strconv.Atoi(v); err != nil || n < 1 || n > 65535 {
+		return "want a port from 1 to 65535"
+	}
+	return ""
+}
+
+func checkCount(v string) string {
+	if n, err := strconv.Atoi(v); err != nil || n < 0 {
+		return "want a whole number, 0 or more"
+	}
+	return ""
+}
+
+func checkBool(v string) string {
+	if v == "1" || v == "0" || strings.EqualFold(v, "true") || strings.EqualFold(v, "false") {
+		return ""
+	}
+	return "want 1 or 0"
+}
+
+func checkNoSpace(v string) string {
+	if strings.ContainsAny(v, " \t") {
+		return "cannot contain spaces"
+	}
+	return ""
+}
+
+func checkHangarInfo(v string) string {
+	if err := hangar.CheckHangarInfo(v); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+func checkOneOf(allowed ...string) func(string) string {
+	return func(v string) string {
+		if slices.Contains(allowed, v) {
+			return ""
+		}
+		return "want one of " + strings.Join(allowed, ", ")
+	}
+}
+
+// runValidate lints ship profiles, the saved ones or the files given with
+// -f, without connecting to any server: unknown keys, values beammeup would
+// silently replace with a default, settings that contradict each other and
+// ships that would fight over the same port on one server.
+func (r *Runner) runValidate(args []string) (int, error) {
+	fs := pflag.NewFlagSet("beammeup validate", pflag.ContinueOnError)
+	var files []string
+	fs.StringArrayVarP(&files, "file", "f", nil, "Ship profile (.ship) or directory of them to check; repeatable (default: the saved ships)")
+	strict := fs.Bool("strict", false, "Fail on warnings too")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return ExitUsage, err
+	}
+	if len(files) == 0 {
+		if r.Store == nil {
+			return ExitFailure, errors.New("no ship store available")
+		}
+		files = []string{r.Store.Dir}
+	}
+	paths, err := shipFiles(files)
+	if err != nil {
+		return ExitFailure, err
+	}
+	if len(paths) == 0 {
+		return ExitFailure, errors.New("no .ship files to validate")
+	}
+
+	var findings []finding
+	var fleet []ships.Ship
+	for _, path := range paths {
+		ship, found := lintShipFile(path)
+		findings = append(findings, found...)
+		if ship.Name != "" {
+			fleet = append(fleet, ship)
+		}
+	}
+	findings = append(findings, lintFleet(fleet)...)
+
+	errs, warnings := 0, 0
+	for _, f := range findings {
+		fmt.Println(f)
+		if f.Warning {
+			warnings++
+		} else {
+			errs++
+		}
+	}
+	fmt.Printf("[beammeup] validate: %d ship(s), %d error(s), %d warning(s)\n", len(paths), errs, warnings)
+	if errs > 0 || (*strict && warnings > 0) {
+		return ExitFailure, nil
+	}
+	return ExitSuccess, nil
+}
+
+// shipFiles expands the -f arguments: directories to the .ship files in
+// them, files as given.
+func shipFiles(args []string) ([]string, error) {
+	var paths []string
+	for _, arg := range args {
+		st, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !st.IsDir() {
+			paths = append(paths, arg)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(arg, "*.ship"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(matches)
+		paths = append(paths, matches...)
+	}
+	return paths, nil
+}
+
+// lintShipFile checks one .ship file line by line, then the ship it loads
+// as. The ship is zero when the file does not load at all.
+func lintShipFile(path string) (ships.Ship, []finding) {
+	var out []finding
+	add := func(line int, warning bool, format string, a ...any) {
+		out = append(out, finding{File: path, Line: line, Warning: warning, Msg: fmt.Sprintf(format, a...)})
+	}
+	if base := strings.TrimSuffix(filepath.Base(path), ".ship"); base != ships.SanitizeName(base) {
+		add(0, true, "file name %q is not a ship name; it is saved as %q", base, ships.SanitizeName(base))
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		add(0, false, "%v", err)
+		return ships.Ship{}, out
+	}
+	defer f.Close()
+	seen := map[string]int{}
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			add(n, false, "not a KEY=VALUE line")
+			continue
+		}
+		check, known := shipKeys[key]
+		if !known {
+			add(n, true, "unknown key %s is ignored", key)
+			continue
+		}
+		if prev, dup := seen[key]; dup {
+			add(n, true, "%s is also set on line %d; this value wins", key, prev)
+		}
+		seen[key] = n
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+		if msg := check(value); msg != "" {
+			add(n, false, "%s=%s: %s", key, value, msg)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		add(0, false, "%v", err)
+	}
+
+	ship, err := ships.LoadFile(path)
+	if err != nil {
+		add(0, false, "%v", err)
+		return ships.Ship{}, out
+	}
+	for _, f := range lintShip(ship) {
+		f.File = path
+		out = append(out, f)
+	}
+	return ship, out
+}
+
+// lintShip finds settings of one ship that contradict each other or will
+// not do what they seem to.
+func lintShip(ship ships.Ship) []finding {
+	var out []finding
+	add := func(warning bool, format string, a ...any) {
+		out = append(out, finding{Ship: ship.Name, Warning: warning, Msg: fmt.Sprintf(format, a...)})
+	}
+	socks := ship.Protocol == "socks5"
+	if ship.ProxyPort == ship.SSHPort {
+		add(false, "proxy port %d is the SSH port", ship.ProxyPort)
+	}
+	if socks && ship.Instance != "" {
+		add(false, "INSTANCE is HTTP only")
+	}
+	if ship.Instance != "" && (ship.TrafficCapGB > 0 || ship.QuietHours != "") {
+		add(true, "TRAFFIC_CAP_GB and QUIET_HOURS cover the whole server and are ignored for an INSTANCE")
+	}
+	if socks && (ship.HTTPMode != "" || ship.CacheMB > 0 || ship.Anonymity != "" || len(ship.AllowDomains) > 0 || len(ship.DenyDomains) > 0) {
+		add(true, "HTTP_MODE, CACHE_MB, ANONYMITY and the domain lists are ignored for socks5")
+	}
+	if !socks && ship.SocketActivation {
+		add(true, "SOCKET_ACTIVATION is SOCKS5 only and is ignored")
+	}
+	if ship.ListenLocal {
+		tunneled := false
+		for _, spec := range ship.Forwards {
+			fw, err := tunnel.ParseForward(spec)
+			if err != nil || fw.Reverse {
+				continue
+			}
+			if _, port, _ := net.SplitHostPort(fw.To); port == strconv.Itoa(ship.ProxyPort) {
+				tunneled = true
+			}
+		}
+		if !tunneled {
+			add(true, "LISTEN_LOCAL: the proxy is only reachable through an SSH tunnel, and no saved forward leads to port %d (beammeup forward --ship %s --local %d --to 127.0.0.1:%d --save)", ship.ProxyPort, ship.Name, ship.ProxyPort, ship.ProxyPort)
+		}
+		if ship.CloudFirewall != "" {
+			add(true, "CLOUD_FIREWALL opens the proxy port, but LISTEN_LOCAL keeps the proxy off the public interface")
+		}
+	}
+	if ship.TrafficCapGB > 0 && ship.TrafficQuotaGB > 0 && ship.TrafficCapGB > ship.TrafficQuotaGB {
+		add(true, "TRAFFIC_CAP_GB (%d) is above the provider quota TRAFFIC_QUOTA_GB (%d)", ship.TrafficCapGB, ship.TrafficQuotaGB)
+	}
+	for _, d := range ship.AllowDomains {
+		for _, deny := range ship.DenyDomains {
+			if strings.EqualFold(d, deny) {
+				add(true, "%s is both allowed and denied", d)
+			}
+		}
+	}
+	if ship.Expired(time.Now()) {
+		add(true, "the hangar expired at %s and was destroyed on the server", ship.ExpiresAt.Format(time.RFC3339))
+	}
+	if ship.HostKeyChanged != "" {
+		add(true, "the SSH host key changed to %s; nothing connects until beammeup trust-host --ship %s", ship.HostKeyChanged, ship.Name)
+	}
+	return out
+}
+
+// lintFleet finds ships that clash with each other: the same name twice, or
+// two hangars on one server that want the same proxy port.
+func lintFleet(fleet []ships.Ship) []finding {
+	var out []finding
+	byName := map[string]bool{}
+	type slot struct {
+		host string
+		port int
+	}
+	bySlot := map[slot]ships.Ship{}
+	for _, ship := range fleet {
+		if byName[ship.Name] {
+			out = append(out, finding{Ship: ship.Name, Msg: "more than one profile is named " + ship.Name})
+		}
+		byName[ship.Name] = true
+
+		key := slot{strings.ToLower(ship.Host), ship.ProxyPort}
+		other, taken := bySlot[key]
+		if !taken {
+			bySlot[key] = ship
+			continue
+		}
+		if other.Protocol == ship.Protocol && other.Instance == ship.Instance {
+			out = append(out, finding{Ship: ship.Name, Warning: true, Msg: fmt.Sprintf("manages the same hangar as %s (%s port %d); applying one changes the other", other.Name, ship.Host, ship.ProxyPort)})
+			continue
+		}
+		out = append(out, finding{Ship: ship.Name, Msg: fmt.Sprintf("proxy port %d on %s is also used by %s", ship.ProxyPort, ship.Host, other.Name)})
+	}
+	return out
+}
@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alfaoz/beammeup/internal/ships"
+)
+
+func TestLintShipFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	edge := write("edge.ship", "HOST=192.0.2.10\nPROTOCOL=socks5\nPROXY_PORT=1080\nLISTEN_LOCAL=yes\nPROXY_PORT=1081\nPROXY_PROT=1\nQUIET_HOURS=25:00-26:00\nbroken line\n")
+	lab := write("lab.ship", "HOST=192.0.2.10\nPROTOCOL=http\nPROXY_PORT=1081\nLISTEN_LOCAL=1\nCLOUD_FIREWALL=hetzner\n")
+
+	ship, found := lintShipFile(edge)
+	var got []string
+	for _, f := range found {
+		got = append(got, f.String())
+	}
+	for _, want := range []string{
+		edge + ":4: error: LISTEN_LOCAL=yes: want 1 or 0",
+		edge + ":5: warning: PROXY_PORT is also set on line 3; this value wins",
+		edge + ":6: warning: unknown key PROXY_PROT is ignored",
+		edge + ":7: error: QUIET_HOURS=25:00-26:00",
+		edge + ":8: error: not a KEY=VALUE line",
+	} {
+		if !containsPrefix(got, want) {
+			t.Fatalf("missing %q in:\n%s", want, strings.Join(got, "\n"))
+		}
+	}
+	if ship.ProxyPort != 1081 {
+		t.Fatalf("expected the later PROXY_PORT to load, got %d", ship.ProxyPort)
+	}
+
+	other, found := lintShipFile(lab)
+	var labMsgs []string
+	for _, f := range found {
+		labMsgs = append(labMsgs, f.Msg)
+	}
+	if len(found) != 2 || !strings.HasPrefix(labMsgs[0], "LISTEN_LOCAL: the proxy is only reachable") || !strings.HasPrefix(labMsgs[1], "CLOUD_FIREWALL opens") {
+		t.Fatalf("unexpected findings for lab: %q", labMsgs)
+	}
+
+	clash := lintFleet([]ships.Ship{ship, other})
+	if len(clash) != 1 || clash[0].Warning || !strings.Contains(clash[0].Msg, "proxy port 1081 on 192.0.2.10 is also used by edge") {
+		t.Fatalf("expected a port clash, got %+v", clash)
+	}
+}
+
+func TestLintSavedShipIsClean(t *testing.T) {
+	store, err := ships.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	if _, err := store.Save(ships.Ship{Name: "edge", Host: "192.0.2.10"}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if _, found := lintShipFile(filepath.Join(store.Dir, "edge.ship")); len(found) > 0 {
+		t.Fatalf("a profile beammeup wrote should validate, got %v", found)
+	}
+}
+
+func containsPrefix(list []string, prefix string) bool {
+	for _, s := range list {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,191 @@
+// Package cloudfw opens and closes the proxy port in a VPS provider's cloud
+// firewall, the filter in front of the server that the OS firewall changes
+// made by the remote script cannot reach.
+package cloudfw
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alfaoz/beammeup/internal/config"
+)
+
+// Providers lists the supported cloud firewalls.
+var Providers = []string{"hetzner", "digitalocean"}
+
+// anywhere is the source of the rules beammeup adds.
+var anywhere = []string{"0.0.0.0/0", "::/0"}
+
+// Result describes what Open or Close did.
+type Result struct {
+	Changed bool   // a rule was added or removed
+	Note    string // for the result card
+}
+
+// Provider changes the cloud firewalls applied to a server, found by its
+// public IPv4 address.
+type Provider interface {
+	Name() string
+	// Open allows tcp/port from anywhere. Changed is false when a firewall
+	// already allows it or none applies to the server; only ports Open
+	// changed should be closed again.
+	Open(ip string, port int) (Result, error)
+	// Close removes the rule Open added.
+	Close(ip string, port int) (Result, error)
+}
+
+// ValidProvider reports whether name is one of Providers.
+func ValidProvider(name string) bool {
+	for _, p := range Providers {
+		if name == p {
+			return true
+		}
+	}
+	return false
+}
+
+// New returns the provider called name, using the API token from cfg.
+func New(name string, cfg config.Config) (Provider, error) {
+	switch name {
+	case "hetzner":
+		if cfg.HetznerToken == "" {
+			return nil, fmt.Errorf("cloud firewall: set HETZNER_TOKEN in the config file (or BEAMMEUP_HETZNER_TOKEN)")
+		}
+		return &Hetzner{api: newAPI(hetznerURL, cfg.HetznerToken)}, nil
+	case "digitalocean":
+		if cfg.DigitalOceanToken == "" {
+			return nil, fmt.Errorf("cloud firewall: set DIGITALOCEAN_TOKEN in the config file (or BEAMMEUP_DIGITALOCEAN_TOKEN)")
+		}
+		return &DigitalOcean{api: newAPI(digitalOceanURL, cfg.DigitalOceanToken)}, nil
+	}
+	return nil, fmt.Errorf("cloud firewall: unknown provider %q (want %s)", name, strings.Join(Providers, " or "))
+}
+
+// ServerIP resolves host to the IPv4 address the providers index servers by.
+func ServerIP(host string) (string, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.To4() == nil {
+			return "", fmt.Errorf("cloud firewall: %s is not an IPv4 address", host)
+		}
+		return ip.String(), nil
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return "", fmt.Errorf("cloud firewall: resolve %s: %w", host, err)
+	}
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			return ip.String(), nil
+		}
+	}
+	return "", fmt.Errorf("cloud firewall: %s has no IPv4 address", host)
+}
+
+// api is a JSON REST client with bearer authentication.
+type api struct {
+	BaseURL string
+	Token   string
+	Client  *http.Client
+}
+
+func newAPI(base, token string) api {
+	return api{BaseURL: base, Token: token, Client: &http.Client{Timeout: 15 * time.Second}}
+}
+
+func (a api) do(method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, strings.TrimRight(a.BaseURL, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+a.Token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := a.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: %s%s", method, path, resp.Status, apiMessage(data))
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// apiMessage pulls the message out of an error body of either provider.
+func apiMessage(data []byte) string {
+	var e struct {
+		Message string `json:"message"`
+		Error   struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(data, &e) != nil {
+		return ""
+	}
+	if e.Error.Message != "" {
+		return ": " + e.Error.Message
+	}
+	if e.Message != "" {
+		return ": " + e.Message
+	}
+	return ""
+}
+
+// coversAnywhere reports whether sources admit every address.
+func coversAnywhere(sources []string) bool {
+	for _, s := range sources {
+		if s == "0.0.0.0/0" {
+			return true
+		}
+	}
+	return false
+}
+
+// portIn reports whether port falls in spec: "1080", "1000-2000" or, for
+// DigitalOcean, "all" or "0".
+func portIn(port int, spec string) bool {
+	spec = strings.TrimSpace(spec)
+	if spec == "all" || spec == "0" {
+		return true
+	}
+	lo, hi, isRange := strings.Cut(spec, "-")
+	var from, to int
+	if _, err := fmt.Sscanf(lo, "%d", &from); err != nil {
+		return false
+	}
+	to = from
+	if isRange {
+		if _, err := fmt.Sscanf(hi, "%d", &to); err != nil {
+			return false
+		}
+	}
+	return port >= from && port <= to
+}
+
+func sortedIDs(ids []int64) []int64 {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
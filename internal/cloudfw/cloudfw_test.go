@@ -0,0 +1,123 @@
+package cloudfw
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func testAPI(srv *httptest.Server) api {
+	return api{BaseURL: srv.URL, Token: "tok", Client: srv.Client()}
+}
+
+func TestHetznerOpenClose(t *testing.T) {
+	rules := []hetznerRule{{Direction: "in", Protocol: "tcp", Port: "22", SourceIPs: anywhere}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/servers":
+			w.Write([]byte(`{"servers":[{"public_net":{"ipv4":{"ip":"198.51.100.10"},"firewalls":[{"id":7}]}}],"meta":{"pagination":{"next_page":null}}}`))
+		case r.Method == "GET" && r.URL.Path == "/firewalls/7":
+			json.NewEncoder(w).Encode(map[string]any{"firewall": hetznerFirewall{ID: 7, Name: "web", Rules: rules}})
+		case r.Method == "POST" && r.URL.Path == "/firewalls/7/actions/set_rules":
+			var body struct {
+				Rules []hetznerRule `json:"rules"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			rules = body.Rules
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"actions":[]}`))
+		default:
+			t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	h := &Hetzner{api: testAPI(srv)}
+
+	res, err := h.Open("198.51.100.10", 1080)
+	if err != nil || !res.Changed {
+		t.Fatalf("Open = %+v, %v", res, err)
+	}
+	if len(rules) != 2 || rules[1].Port != "1080" || rules[1].Description != hetznerRuleDescription {
+		t.Fatalf("rules after open = %+v", rules)
+	}
+	if res, err := h.Open("198.51.100.10", 1080); err != nil || res.Changed {
+		t.Fatalf("second Open = %+v, %v; want already allowed", res, err)
+	}
+	if res, err := h.Close("198.51.100.10", 1080); err != nil || !res.Changed {
+		t.Fatalf("Close = %+v, %v", res, err)
+	}
+	if len(rules) != 1 || rules[0].Port != "22" {
+		t.Fatalf("rules after close = %+v", rules)
+	}
+	if _, err := h.Open("198.51.100.99", 1080); err == nil || !strings.Contains(err.Error(), "no server") {
+		t.Fatalf("unknown server: %v", err)
+	}
+}
+
+func TestDigitalOceanOpenByTag(t *testing.T) {
+	var added, removed []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/droplets":
+			w.Write([]byte(`{"droplets":[{"id":3,"tags":["proxy"],"networks":{"v4":[{"ip_address":"10.0.0.5","type":"private"},{"ip_address":"198.51.100.20","type":"public"}]}}],"links":{}}`))
+		case r.Method == "GET" && r.URL.Path == "/firewalls":
+			w.Write([]byte(`{"firewalls":[{"id":"other","name":"db","droplet_ids":[9]},{"id":"fw1","name":"edge","tags":["proxy"],"inbound_rules":[{"protocol":"tcp","ports":"22","sources":{"addresses":["0.0.0.0/0"]}}]}]}`))
+		case r.URL.Path == "/firewalls/fw1/rules":
+			var body struct {
+				Rules []doInboundRule `json:"inbound_rules"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			if r.Method == "POST" {
+				added = append(added, body.Rules[0].Ports)
+			} else {
+				removed = append(removed, body.Rules[0].Ports)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	d := &DigitalOcean{api: testAPI(srv)}
+
+	if res, err := d.Open("198.51.100.20", 18181); err != nil || !res.Changed || !strings.Contains(res.Note, "edge") {
+		t.Fatalf("Open = %+v, %v", res, err)
+	}
+	if len(added) != 1 || added[0] != "18181" {
+		t.Fatalf("added = %v", added)
+	}
+	if res, err := d.Open("198.51.100.20", 22); err != nil || res.Changed {
+		t.Fatalf("Open of an allowed port = %+v, %v", res, err)
+	}
+	// The fake does not keep the rule, so there is nothing to close.
+	if res, err := d.Close("198.51.100.20", 18181); err != nil || res.Changed || len(removed) != 0 {
+		t.Fatalf("Close = %+v, %v, removed %v", res, err, removed)
+	}
+}
+
+func TestPortIn(t *testing.T) {
+	cases := []struct {
+		port int
+		spec string
+		want bool
+	}{
+		{1080, "1080", true},
+		{1080, "1000-2000", true},
+		{2001, "1000-2000", false},
+		{1080, "all", true},
+		{1080, "", false},
+	}
+	for _, c := range cases {
+		if got := portIn(c.port, c.spec); got != c.want {
+			t.Fatalf("portIn(%d, %q) = %v", c.port, c.spec, got)
+		}
+	}
+}
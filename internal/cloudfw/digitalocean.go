@@ -0,0 +1,150 @@
+package cloudfw
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+const digitalOceanURL = "https://api.digitalocean.com/v2"
+
+// DigitalOcean talks to the DigitalOcean API. Its firewall rules carry no
+// description, so Close removes the exact rule Open adds; callers only
+// close ports Open reported as changed.
+type DigitalOcean struct {
+	api api
+}
+
+func (d *DigitalOcean) Name() string { return "digitalocean" }
+
+type doSources struct {
+	Addresses []string `json:"addresses,omitempty"`
+}
+
+type doInboundRule struct {
+	Protocol string    `json:"protocol"`
+	Ports    string    `json:"ports"`
+	Sources  doSources `json:"sources"`
+}
+
+type doFirewall struct {
+	ID           string          `json:"id"`
+	Name         string          `json:"name"`
+	DropletIDs   []int64         `json:"droplet_ids"`
+	Tags         []string        `json:"tags"`
+	InboundRules []doInboundRule `json:"inbound_rules"`
+}
+
+// firewalls returns the firewalls applied to the droplet with public IPv4
+// ip, directly or through one of its tags.
+func (d *DigitalOcean) firewalls(ip string) ([]doFirewall, error) {
+	var (
+		dropletID int64
+		tags      []string
+		found     bool
+	)
+	for page := 1; !found; page++ {
+		var out struct {
+			Droplets []struct {
+				ID       int64    `json:"id"`
+				Tags     []string `json:"tags"`
+				Networks struct {
+					V4 []struct {
+						IPAddress string `json:"ip_address"`
+						Type      string `json:"type"`
+					} `json:"v4"`
+				} `json:"networks"`
+			} `json:"droplets"`
+			Links struct {
+				Pages struct {
+					Next string `json:"next"`
+				} `json:"pages"`
+			} `json:"links"`
+		}
+		if err := d.api.do("GET", "/droplets?per_page=200&page="+strconv.Itoa(page), nil, &out); err != nil {
+			return nil, fmt.Errorf("digitalocean: %w", err)
+		}
+		for _, drop := range out.Droplets {
+			for _, n := range drop.Networks.V4 {
+				if n.Type == "public" && n.IPAddress == ip {
+					dropletID, tags, found = drop.ID, drop.Tags, true
+				}
+			}
+		}
+		if out.Links.Pages.Next == "" {
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("digitalocean: no droplet with IP %s in this account", ip)
+	}
+
+	var out struct {
+		Firewalls []doFirewall `json:"firewalls"`
+	}
+	if err := d.api.do("GET", "/firewalls?per_page=200", nil, &out); err != nil {
+		return nil, fmt.Errorf("digitalocean: %w", err)
+	}
+	var fws []doFirewall
+	for _, fw := range out.Firewalls {
+		applied := slices.Contains(fw.DropletIDs, dropletID)
+		for _, t := range fw.Tags {
+			applied = applied || slices.Contains(tags, t)
+		}
+		if applied {
+			fws = append(fws, fw)
+		}
+	}
+	return fws, nil
+}
+
+func doRule(port int) map[string]any {
+	return map[string]any{"inbound_rules": []doInboundRule{{
+		Protocol: "tcp",
+		Ports:    strconv.Itoa(port),
+		Sources:  doSources{Addresses: anywhere},
+	}}}
+}
+
+func (d *DigitalOcean) Open(ip string, port int) (Result, error) {
+	fws, err := d.firewalls(ip)
+	if err != nil {
+		return Result{}, err
+	}
+	if len(fws) == 0 {
+		return Result{Note: "no DigitalOcean firewall is applied to the droplet"}, nil
+	}
+	for _, fw := range fws {
+		for _, r := range fw.InboundRules {
+			if r.Protocol == "tcp" && portIn(port, r.Ports) && coversAnywhere(r.Sources.Addresses) {
+				return Result{Note: fmt.Sprintf("tcp/%d already allowed by DigitalOcean firewall %s", port, fw.Name)}, nil
+			}
+		}
+	}
+	fw := fws[0]
+	if err := d.api.do("POST", "/firewalls/"+fw.ID+"/rules", doRule(port), nil); err != nil {
+		return Result{}, fmt.Errorf("digitalocean: firewall %s: %w", fw.Name, err)
+	}
+	return Result{Changed: true, Note: fmt.Sprintf("opened tcp/%d in DigitalOcean firewall %s", port, fw.Name)}, nil
+}
+
+func (d *DigitalOcean) Close(ip string, port int) (Result, error) {
+	fws, err := d.firewalls(ip)
+	if err != nil {
+		return Result{}, err
+	}
+	res := Result{Note: fmt.Sprintf("no rule for tcp/%d in the DigitalOcean firewalls", port)}
+	for _, fw := range fws {
+		for _, r := range fw.InboundRules {
+			if r.Protocol != "tcp" || r.Ports != strconv.Itoa(port) || !slices.Equal(r.Sources.Addresses, anywhere) {
+				continue
+			}
+			if err := d.api.do("DELETE", "/firewalls/"+fw.ID+"/rules", doRule(port), nil); err != nil {
+				return res, fmt.Errorf("digitalocean: firewall %s: %w", fw.Name, err)
+			}
+			res = Result{Changed: true, Note: fmt.Sprintf("closed tcp/%d in DigitalOcean firewall %s", port, fw.Name)}
+			break
+		}
+	}
+	return res, nil
+}
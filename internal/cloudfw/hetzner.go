@@ -0,0 +1,151 @@
+package cloudfw
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const hetznerURL = "https://api.hetzner.cloud/v1"
+
+// hetznerRuleDescription marks the rules beammeup adds, so Close removes
+// only those.
+const hetznerRuleDescription = "beammeup proxy"
+
+// Hetzner talks to the Hetzner Cloud API.
+type Hetzner struct {
+	api api
+}
+
+func (h *Hetzner) Name() string { return "hetzner" }
+
+type hetznerRule struct {
+	Direction      string   `json:"direction"`
+	Protocol       string   `json:"protocol"`
+	Port           string   `json:"port,omitempty"`
+	SourceIPs      []string `json:"source_ips,omitempty"`
+	DestinationIPs []string `json:"destination_ips,omitempty"`
+	Description    string   `json:"description,omitempty"`
+}
+
+type hetznerFirewall struct {
+	ID    int64         `json:"id"`
+	Name  string        `json:"name"`
+	Rules []hetznerRule `json:"rules"`
+}
+
+// firewalls returns the firewalls applied to the server with public IPv4 ip.
+func (h *Hetzner) firewalls(ip string) ([]hetznerFirewall, error) {
+	var ids []int64
+	found := false
+	for page := 1; page > 0; {
+		var out struct {
+			Servers []struct {
+				PublicNet struct {
+					IPv4 struct {
+						IP string `json:"ip"`
+					} `json:"ipv4"`
+					Firewalls []struct {
+						ID int64 `json:"id"`
+					} `json:"firewalls"`
+				} `json:"public_net"`
+			} `json:"servers"`
+			Meta struct {
+				Pagination struct {
+					NextPage int `json:"next_page"`
+				} `json:"pagination"`
+			} `json:"meta"`
+		}
+		if err := h.api.do("GET", "/servers?per_page=50&page="+strconv.Itoa(page), nil, &out); err != nil {
+			return nil, fmt.Errorf("hetzner: %w", err)
+		}
+		for _, s := range out.Servers {
+			if s.PublicNet.IPv4.IP != ip {
+				continue
+			}
+			found = true
+			for _, fw := range s.PublicNet.Firewalls {
+				ids = append(ids, fw.ID)
+			}
+		}
+		page = out.Meta.Pagination.NextPage
+	}
+	if !found {
+		return nil, fmt.Errorf("hetzner: no server with IP %s in this project", ip)
+	}
+	var fws []hetznerFirewall
+	for _, id := range sortedIDs(ids) {
+		var out struct {
+			Firewall hetznerFirewall `json:"firewall"`
+		}
+		if err := h.api.do("GET", "/firewalls/"+strconv.FormatInt(id, 10), nil, &out); err != nil {
+			return nil, fmt.Errorf("hetzner: %w", err)
+		}
+		fws = append(fws, out.Firewall)
+	}
+	return fws, nil
+}
+
+func (h *Hetzner) setRules(fw hetznerFirewall, rules []hetznerRule) error {
+	if rules == nil {
+		rules = []hetznerRule{}
+	}
+	body := map[string]any{"rules": rules}
+	if err := h.api.do("POST", "/firewalls/"+strconv.FormatInt(fw.ID, 10)+"/actions/set_rules", body, nil); err != nil {
+		return fmt.Errorf("hetzner: firewall %s: %w", fw.Name, err)
+	}
+	return nil
+}
+
+func (h *Hetzner) Open(ip string, port int) (Result, error) {
+	fws, err := h.firewalls(ip)
+	if err != nil {
+		return Result{}, err
+	}
+	if len(fws) == 0 {
+		return Result{Note: "no Hetzner firewall is applied to the server"}, nil
+	}
+	for _, fw := range fws {
+		for _, r := range fw.Rules {
+			if r.Direction == "in" && r.Protocol == "tcp" && portIn(port, r.Port) && coversAnywhere(r.SourceIPs) {
+				return Result{Note: fmt.Sprintf("tcp/%d already allowed by Hetzner firewall %s", port, fw.Name)}, nil
+			}
+		}
+	}
+	fw := fws[0]
+	rules := append(fw.Rules, hetznerRule{
+		Direction:   "in",
+		Protocol:    "tcp",
+		Port:        strconv.Itoa(port),
+		SourceIPs:   anywhere,
+		Description: hetznerRuleDescription,
+	})
+	if err := h.setRules(fw, rules); err != nil {
+		return Result{}, err
+	}
+	return Result{Changed: true, Note: fmt.Sprintf("opened tcp/%d in Hetzner firewall %s", port, fw.Name)}, nil
+}
+
+func (h *Hetzner) Close(ip string, port int) (Result, error) {
+	fws, err := h.firewalls(ip)
+	if err != nil {
+		return Result{}, err
+	}
+	res := Result{Note: fmt.Sprintf("no beammeup rule for tcp/%d in the Hetzner firewalls", port)}
+	for _, fw := range fws {
+		kept := make([]hetznerRule, 0, len(fw.Rules))
+		for _, r := range fw.Rules {
+			if r.Description == hetznerRuleDescription && r.Direction == "in" && r.Port == strconv.Itoa(port) {
+				continue
+			}
+			kept = append(kept, r)
+		}
+		if len(kept) == len(fw.Rules) {
+			continue
+		}
+		if err := h.setRules(fw, kept); err != nil {
+			return res, err
+		}
+		res = Result{Changed: true, Note: fmt.Sprintf("closed tcp/%d in Hetzner firewall %s", port, fw.Name)}
+	}
+	return res, nil
+}
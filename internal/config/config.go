@@ -0,0 +1,293 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultDirSuffix  = ".beammeup"
+	DefaultFileSuffix = ".beammeup/config"
+)
+
+// Config holds client-wide settings that are not tied to a single ship.
+// The file uses the same KEY=VALUE format as .ship profiles.
+type Config struct {
+	WebhookURL string
+
+	// SMTP email alerts for critical events; off unless EMAIL_TO is set.
+	// Port 465 uses implicit TLS, anything else STARTTLS when offered.
+	SMTPHost  string
+	SMTPPort  int // 587 when unset
+	SMTPUser  string
+	SMTPPass  string
+	EmailFrom string   // SMTP_USER when unset
+	EmailTo   []string // EMAIL_TO, comma-separated
+	EmailAll  bool     // EMAIL_EVENTS=all mails every event, not only critical ones
+
+	// Telemetry opts in to the anonymous ping sent with update checks. It is
+	// off unless TELEMETRY is set to a true value.
+	Telemetry    bool
+	TelemetryURL string // empty means the default endpoint
+
+	// LeakCheck looks new proxy passwords up in Have I Been Pwned (only a
+	// hash prefix is sent) and replaces generated ones found there. It is
+	// off unless LEAK_CHECK is set to a true value.
+	LeakCheck    bool
+	LeakCheckURL string // empty means the public range API
+
+	// GeoIP locates a ship's exit IP during inventory. GeoIPDB lists local
+	// MMDB files (GEOIP_DB, comma-separated); GeoIPURL is an echoip-style
+	// API asked for what they leave open. Both empty turns it off.
+	GeoIPDB  []string
+	GeoIPURL string
+
+	// API tokens for the cloud firewall integration (--cloud-firewall).
+	// They need read and write access to servers and firewalls.
+	HetznerToken      string
+	DigitalOceanToken string
+}
+
+// ProfilesDirName is the subdirectory of ~/.beammeup holding the named
+// profiles. Each profile is laid out like ~/.beammeup itself.
+const ProfilesDirName = "profiles"
+
+// profileEnv lists the per-path overrides a named profile fills in, so the
+// stores and the SSH client (and child processes such as background tunnels)
+// pick up the profile's directories without knowing about profiles.
+var profileEnv = []struct{ env, name string }{
+	{"BEAMMEUP_SHIPS_DIR", "ships"},
+	{"BEAMMEUP_USAGE_DIR", "usage"},
+	{"BEAMMEUP_HISTORY_DIR", "history"},
+	{"BEAMMEUP_CREDS_DIR", "creds"},
+	{"BEAMMEUP_SSH_KNOWN_HOSTS", "known_hosts"},
+	{"BEAMMEUP_CONFIG", "config"},
+}
+
+// Profile returns the active profile from BEAMMEUP_PROFILE. The empty string
+// is the default profile, ~/.beammeup itself.
+func Profile() string {
+	name := strings.TrimSpace(os.Getenv("BEAMMEUP_PROFILE"))
+	if name == "default" {
+		return ""
+	}
+	return name
+}
+
+// ValidateProfile checks that name can be used as a profile directory:
+// lowercase letters, digits, '-' and '_', starting with a letter or digit.
+func ValidateProfile(name string) error {
+	if name == "" || len(name) > 64 {
+		return fmt.Errorf("invalid profile name %q", name)
+	}
+	for i, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case (c == '-' || c == '_') && i > 0:
+		default:
+			return fmt.Errorf("invalid profile name %q: use lowercase letters, digits, '-' and '_'", name)
+		}
+	}
+	return nil
+}
+
+// UseProfile makes name the active profile for this process and the ones it
+// starts. "" and "default" select ~/.beammeup. Path overrides that are
+// already set in the environment still win.
+func UseProfile(name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" || name == "default" {
+		return os.Unsetenv("BEAMMEUP_PROFILE")
+	}
+	if err := ValidateProfile(name); err != nil {
+		return err
+	}
+	if err := os.Setenv("BEAMMEUP_PROFILE", name); err != nil {
+		return err
+	}
+	dir, err := Dir()
+	if err != nil {
+		return err
+	}
+	for _, p := range profileEnv {
+		if strings.TrimSpace(os.Getenv(p.env)) != "" {
+			continue
+		}
+		if err := os.Setenv(p.env, filepath.Join(dir, p.name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Profiles lists the named profiles found under ~/.beammeup, sorted. The
+// default profile is not included.
+func Profiles() ([]string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("resolve home dir: %w", err)
+	}
+	entries, err := os.ReadDir(filepath.Join(home, DefaultDirSuffix, ProfilesDirName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() && ValidateProfile(e.Name()) == nil {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+// Dir returns the directory holding beammeup's client-side state: ~/.beammeup,
+// or ~/.beammeup/profiles/<name> while a named profile is active.
+func Dir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("resolve home dir: %w", err)
+	}
+	return profileDir(home, Profile())
+}
+
+// ProfileDir returns the state directory of the named profile; "" is the
+// default profile.
+func ProfileDir(name string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("resolve home dir: %w", err)
+	}
+	return profileDir(home, name)
+}
+
+func profileDir(home, name string) (string, error) {
+	base := filepath.Join(home, DefaultDirSuffix)
+	if name == "" || name == "default" {
+		return base, nil
+	}
+	if err := ValidateProfile(name); err != nil {
+		return "", err
+	}
+	return filepath.Join(base, ProfilesDirName, name), nil
+}
+
+// Path returns the config file location, honoring BEAMMEUP_CONFIG.
+func Path() (string, error) {
+	if v := strings.TrimSpace(os.Getenv("BEAMMEUP_CONFIG")); v != "" {
+		return v, nil
+	}
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "config"), nil
+}
+
+// Load reads the config file. A missing file yields the zero Config.
+// Environment variables override file values.
+func Load() (Config, error) {
+	var cfg Config
+	path, err := Path()
+	if err != nil {
+		return cfg, err
+	}
+	vals, err := readKeyValues(path)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.WebhookURL = strings.TrimSpace(vals["WEBHOOK_URL"])
+	if v := strings.TrimSpace(os.Getenv("BEAMMEUP_WEBHOOK_URL")); v != "" {
+		cfg.WebhookURL = v
+	}
+	cfg.SMTPHost = strings.TrimSpace(vals["SMTP_HOST"])
+	cfg.SMTPPort, _ = strconv.Atoi(strings.TrimSpace(vals["SMTP_PORT"]))
+	cfg.SMTPUser = strings.TrimSpace(vals["SMTP_USER"])
+	cfg.SMTPPass = strings.TrimSpace(vals["SMTP_PASS"])
+	if v := strings.TrimSpace(os.Getenv("BEAMMEUP_SMTP_PASS")); v != "" {
+		cfg.SMTPPass = v
+	}
+	cfg.EmailFrom = strings.TrimSpace(vals["EMAIL_FROM"])
+	for _, to := range strings.Split(vals["EMAIL_TO"], ",") {
+		if to = strings.TrimSpace(to); to != "" {
+			cfg.EmailTo = append(cfg.EmailTo, to)
+		}
+	}
+	cfg.EmailAll = strings.EqualFold(strings.TrimSpace(vals["EMAIL_EVENTS"]), "all")
+	cfg.Telemetry = isTrue(vals["TELEMETRY"])
+	if v := strings.TrimSpace(os.Getenv("BEAMMEUP_TELEMETRY")); v != "" {
+		cfg.Telemetry = isTrue(v)
+	}
+	cfg.TelemetryURL = strings.TrimSpace(vals["TELEMETRY_URL"])
+	cfg.LeakCheck = isTrue(vals["LEAK_CHECK"])
+	if v := strings.TrimSpace(os.Getenv("BEAMMEUP_LEAK_CHECK")); v != "" {
+		cfg.LeakCheck = isTrue(v)
+	}
+	cfg.LeakCheckURL = strings.TrimSpace(vals["LEAK_CHECK_URL"])
+	geoDB := vals["GEOIP_DB"]
+	if v := strings.TrimSpace(os.Getenv("BEAMMEUP_GEOIP_DB")); v != "" {
+		geoDB = v
+	}
+	for _, path := range strings.Split(geoDB, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			cfg.GeoIPDB = append(cfg.GeoIPDB, path)
+		}
+	}
+	cfg.GeoIPURL = strings.TrimSpace(vals["GEOIP_URL"])
+	if v := strings.TrimSpace(os.Getenv("BEAMMEUP_GEOIP_URL")); v != "" {
+		cfg.GeoIPURL = v
+	}
+	cfg.HetznerToken = strings.TrimSpace(vals["HETZNER_TOKEN"])
+	if v := strings.TrimSpace(os.Getenv("BEAMMEUP_HETZNER_TOKEN")); v != "" {
+		cfg.HetznerToken = v
+	}
+	cfg.DigitalOceanToken = strings.TrimSpace(vals["DIGITALOCEAN_TOKEN"])
+	if v := strings.TrimSpace(os.Getenv("BEAMMEUP_DIGITALOCEAN_TOKEN")); v != "" {
+		cfg.DigitalOceanToken = v
+	}
+	return cfg, nil
+}
+
+func isTrue(v string) bool {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "1", "true", "yes", "on":
+		return true
+	}
+	return false
+}
+
+func readKeyValues(path string) (map[string]string, error) {
+	vals := map[string]string{}
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return vals, nil
+		}
+		return nil, fmt.Errorf("open config: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		vals[strings.TrimSpace(parts[0])] = parts[1]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scan config: %w", err)
+	}
+	return vals, nil
+}
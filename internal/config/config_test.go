@@ -0,0 +1,44 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUseProfile(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("BEAMMEUP_PROFILE", "")
+	for _, p := range profileEnv {
+		t.Setenv(p.env, "")
+	}
+	t.Setenv("BEAMMEUP_SHIPS_DIR", "/srv/ships")
+
+	if err := UseProfile("Client A"); err == nil {
+		t.Fatalf("expected an invalid profile name to be rejected")
+	}
+	if err := UseProfile("client-a"); err != nil {
+		t.Fatalf("UseProfile: %v", err)
+	}
+	want := filepath.Join(home, ".beammeup", "profiles", "client-a")
+	if dir, err := Dir(); err != nil || dir != want {
+		t.Fatalf("Dir() = %q, %v; want %q", dir, err, want)
+	}
+	if got := os.Getenv("BEAMMEUP_SSH_KNOWN_HOSTS"); got != filepath.Join(want, "known_hosts") {
+		t.Fatalf("known_hosts = %q", got)
+	}
+	if got := os.Getenv("BEAMMEUP_SHIPS_DIR"); got != "/srv/ships" {
+		t.Fatalf("explicit ships dir should win, got %q", got)
+	}
+	if path, _ := Path(); path != filepath.Join(want, "config") {
+		t.Fatalf("Path() = %q", path)
+	}
+
+	if err := UseProfile("default"); err != nil {
+		t.Fatalf("UseProfile(default): %v", err)
+	}
+	if dir, _ := Dir(); dir != filepath.Join(home, ".beammeup") {
+		t.Fatalf("default Dir() = %q", dir)
+	}
+}
@@ -0,0 +1,181 @@
+package creds
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	totpStep   = 30 * time.Second
+	totpDigits = 6
+)
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Gate is the optional challenge in front of revealing or exporting stored
+// credentials. At most one of the two fields is set.
+type Gate struct {
+	TOTPSecret     string // base32, RFC 6238 with SHA-1, 30s steps and 6 digits
+	PassphraseHash string // scrypt$<salt>$<key>, both base64
+}
+
+// Kind names the challenge: "totp", "passphrase", or "" when there is none.
+func (g Gate) Kind() string {
+	switch {
+	case g.TOTPSecret != "":
+		return "totp"
+	case g.PassphraseHash != "":
+		return "passphrase"
+	}
+	return ""
+}
+
+// NewTOTPGate returns a gate with a fresh random authenticator secret.
+func NewTOTPGate() (Gate, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return Gate{}, err
+	}
+	return Gate{TOTPSecret: b32.EncodeToString(secret)}, nil
+}
+
+// NewPassphraseGate returns a gate that accepts passphrase.
+func NewPassphraseGate(passphrase string) (Gate, error) {
+	if passphrase == "" {
+		return Gate{}, errors.New("empty passphrase")
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return Gate{}, err
+	}
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return Gate{}, err
+	}
+	return Gate{PassphraseHash: "scrypt$" + base64.StdEncoding.EncodeToString(salt) + "$" + base64.StdEncoding.EncodeToString(key)}, nil
+}
+
+// TOTPURI is the otpauth:// link authenticator apps import (usually as a QR
+// code) for a TOTP gate.
+func (g Gate) TOTPURI(account string) string {
+	v := url.Values{}
+	v.Set("secret", g.TOTPSecret)
+	v.Set("issuer", "beammeup")
+	return "otpauth://totp/" + url.PathEscape("beammeup:"+account) + "?" + v.Encode()
+}
+
+// Check reports whether answer passes the gate at now. A gate without a
+// challenge accepts anything. TOTP codes from the neighbouring time steps are
+// accepted to allow for clock drift.
+func (g Gate) Check(answer string, now time.Time) bool {
+	switch g.Kind() {
+	case "totp":
+		secret, err := b32.DecodeString(strings.ToUpper(g.TOTPSecret))
+		if err != nil {
+			return false
+		}
+		answer = strings.ReplaceAll(strings.TrimSpace(answer), " ", "")
+		for _, skew := range []time.Duration{0, -totpStep, totpStep} {
+			if subtle.ConstantTimeCompare([]byte(TOTP(secret, now.Add(skew))), []byte(answer)) == 1 {
+				return true
+			}
+		}
+		return false
+	case "passphrase":
+		parts := strings.Split(g.PassphraseHash, "$")
+		if len(parts) != 3 || parts[0] != "scrypt" {
+			return false
+		}
+		salt, err1 := base64.StdEncoding.DecodeString(parts[1])
+		want, err2 := base64.StdEncoding.DecodeString(parts[2])
+		if err1 != nil || err2 != nil {
+			return false
+		}
+		got, err := scrypt.Key([]byte(answer), salt, 1<<15, 8, 1, len(want))
+		return err == nil && subtle.ConstantTimeCompare(got, want) == 1
+	}
+	return true
+}
+
+// TOTP returns the RFC 6238 code for secret at t.
+func TOTP(secret []byte, t time.Time) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(t.Unix()/int64(totpStep/time.Second)))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, code%1_000_000)
+}
+
+func (s *Store) gatePath() string {
+	return filepath.Join(s.Dir, "gate")
+}
+
+// LoadGate returns the reveal gate; the zero Gate when none is set.
+func (s *Store) LoadGate() (Gate, error) {
+	f, err := os.Open(s.gatePath())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return Gate{}, nil
+		}
+		return Gate{}, fmt.Errorf("open reveal gate: %w", err)
+	}
+	defer f.Close()
+
+	var g Gate
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "TOTP_SECRET":
+			g.TOTPSecret = value
+		case "PASSPHRASE_HASH":
+			g.PassphraseHash = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Gate{}, fmt.Errorf("read reveal gate: %w", err)
+	}
+	return g, nil
+}
+
+// SaveGate replaces the reveal gate. The zero Gate removes it.
+func (s *Store) SaveGate(g Gate) error {
+	if g.Kind() == "" {
+		if err := os.Remove(s.gatePath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove reveal gate: %w", err)
+		}
+		return nil
+	}
+	var b strings.Builder
+	if g.TOTPSecret != "" {
+		fmt.Fprintf(&b, "TOTP_SECRET=%s\n", g.TOTPSecret)
+	}
+	if g.PassphraseHash != "" {
+		fmt.Fprintf(&b, "PASSPHRASE_HASH=%s\n", g.PassphraseHash)
+	}
+	if err := os.WriteFile(s.gatePath(), []byte(b.String()), 0o600); err != nil {
+		return fmt.Errorf("write reveal gate: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package creds
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTOTPVectors(t *testing.T) {
+	// RFC 6238 appendix B (SHA-1), truncated to 6 digits.
+	secret := []byte("12345678901234567890")
+	cases := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1234567890:  "005924",
+		20000000000: "353130",
+	}
+	for unix, want := range cases {
+		if got := TOTP(secret, time.Unix(unix, 0)); got != want {
+			t.Fatalf("TOTP(%d) = %s, want %s", unix, got, want)
+		}
+	}
+}
+
+func TestGateCheck(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	g := Gate{TOTPSecret: b32.EncodeToString([]byte("12345678901234567890"))}
+	if !g.Check("005924", now) || !g.Check("005 924", now.Add(20*time.Second)) {
+		t.Fatalf("current code should pass")
+	}
+	if g.Check("005924", now.Add(2*time.Minute)) || g.Check("", now) {
+		t.Fatalf("stale or empty code should fail")
+	}
+
+	g, err := NewPassphraseGate("open sesame")
+	if err != nil {
+		t.Fatalf("NewPassphraseGate: %v", err)
+	}
+	if !g.Check("open sesame", now) || g.Check("open sesame!", now) {
+		t.Fatalf("passphrase gate accepted the wrong answer")
+	}
+
+	store, err := NewStore(t.TempDir(), "")
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	if err := store.SaveGate(g); err != nil {
+		t.Fatalf("SaveGate: %v", err)
+	}
+	loaded, err := store.LoadGate()
+	if err != nil || loaded != g {
+		t.Fatalf("LoadGate = %+v, %v", loaded, err)
+	}
+	if err := store.SaveGate(Gate{}); err != nil {
+		t.Fatalf("SaveGate(zero): %v", err)
+	}
+	if loaded, _ := store.LoadGate(); loaded.Kind() != "" {
+		t.Fatalf("gate should be removed, got %+v", loaded)
+	}
+}
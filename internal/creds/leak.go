@@ -0,0 +1,150 @@
+package creds
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/alfaoz/beammeup/internal/hangar"
+	"github.com/alfaoz/beammeup/internal/ships"
+)
+
+// DefaultPwnedURL is the Have I Been Pwned range API. Only the first five
+// hex characters of a password's SHA-1 are sent to it (k-anonymity); the
+// match against the returned suffixes happens locally.
+const DefaultPwnedURL = "https://api.pwnedpasswords.com/range/"
+
+// leakRetries is how often Screen generates a new password before giving up.
+const leakRetries = 3
+
+// Pwned returns how many times pass appears in known breaches, looked up at
+// url (DefaultPwnedURL when empty).
+func Pwned(url, pass string) (int, error) {
+	if url == "" {
+		url = DefaultPwnedURL
+	}
+	sum := sha1.Sum([]byte(pass))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	req, err := http.NewRequest(http.MethodGet, url+hash[:5], nil)
+	if err != nil {
+		return 0, err
+	}
+	// Padding hides how many suffixes share the prefix.
+	req.Header.Set("Add-Padding", "true")
+	req.Header.Set("User-Agent", "beammeup")
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("pwned passwords: %s", resp.Status)
+	}
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		suffix, count, ok := strings.Cut(strings.TrimSpace(sc.Text()), ":")
+		if ok && strings.EqualFold(suffix, hash[5:]) {
+			n, _ := strconv.Atoi(count)
+			return n, nil
+		}
+	}
+	return 0, sc.Err()
+}
+
+var commonWords = []string{"password", "passwort", "proxy", "beammeup", "qwerty", "letmein", "admin", "welcome", "123456", "abc123"}
+
+// Weak returns why pass makes a poor proxy password, or "" when it does not.
+// Passwords beammeup generates always pass.
+func Weak(pass string) string {
+	if len(pass) < 12 {
+		return "it is shorter than 12 characters"
+	}
+	lower := strings.ToLower(pass)
+	for _, w := range commonWords {
+		if strings.Contains(lower, w) {
+			return fmt.Sprintf("it contains %q", w)
+		}
+	}
+	distinct := map[rune]bool{}
+	var lowers, uppers, digits, others bool
+	for _, c := range pass {
+		distinct[c] = true
+		switch {
+		case unicode.IsLower(c):
+			lowers = true
+		case unicode.IsUpper(c):
+			uppers = true
+		case unicode.IsDigit(c):
+			digits = true
+		default:
+			others = true
+		}
+	}
+	if len(distinct) < 6 {
+		return "it repeats the same few characters"
+	}
+	classes := 0
+	for _, has := range []bool{lowers, uppers, digits, others} {
+		if has {
+			classes++
+		}
+	}
+	if classes < 2 && len(pass) < 20 {
+		return "it uses only one kind of character"
+	}
+	return ""
+}
+
+// Screen checks the proxy password of an apply result. A password the run
+// kept may have been set by hand on the server, so it is checked for
+// strength. With leakCheck, it is also looked up at url (see Pwned): one the
+// run generated (new hangar or rotation) is rotated again, keeping the
+// username, until a clean one comes back; a kept one is only reported. The
+// returned warnings are for the caller to show, along with the result that is
+// now live on the server.
+func Screen(svc *hangar.Service, ship ships.Ship, sshPassword string, in hangar.ActionInput, res hangar.ActionResult, leakCheck bool, url string) (hangar.ActionResult, []string) {
+	if in.Mode != "apply" || res.Pass == "" || strings.EqualFold(res.Protocol, "DESTROY") {
+		return res, nil
+	}
+	fresh := in.RotateCredentials || res.Action == "created"
+	var warnings []string
+	if !fresh {
+		if why := Weak(res.Pass); why != "" {
+			warnings = append(warnings, fmt.Sprintf("the proxy password on the server is weak: %s. Replace it with: beammeup --ship %s --action rotate --rotate-scope password", why, ship.Name))
+		}
+	}
+	if !leakCheck {
+		return res, warnings
+	}
+	for try := 0; ; try++ {
+		n, err := Pwned(url, res.Pass)
+		if err != nil {
+			return res, append(warnings, fmt.Sprintf("leak check skipped: %v", err))
+		}
+		if n == 0 {
+			return res, warnings
+		}
+		if !fresh {
+			return res, append(warnings, fmt.Sprintf("the proxy password appears %d times in known breaches. Replace it with: beammeup --ship %s --action rotate --rotate-scope password", n, ship.Name))
+		}
+		if try == leakRetries {
+			return res, append(warnings, fmt.Sprintf("the generated proxy password is still in known breaches after %d new ones; rotate again", leakRetries))
+		}
+		warnings = append(warnings, "the generated proxy password appears in known breaches; generating a new one")
+		again := in
+		again.RotateCredentials = true
+		again.RotateScope = "password"
+		again.RotateGrace = 0
+		next, err := svc.Execute(ship, sshPassword, again)
+		if err != nil {
+			return res, append(warnings, fmt.Sprintf("could not replace it: %v", err))
+		}
+		res = next
+	}
+}
@@ -0,0 +1,97 @@
+package creds
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alfaoz/beammeup/internal/hangar"
+	"github.com/alfaoz/beammeup/internal/ships"
+)
+
+// pwnedServer answers range queries like the HIBP API, knowing the given
+// passwords as breached.
+func pwnedServer(t *testing.T, breached ...string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prefix := strings.TrimPrefix(r.URL.Path, "/range/")
+		if len(prefix) != 5 {
+			t.Errorf("expected a 5 character hash prefix, got %q", prefix)
+		}
+		fmt.Fprintln(w, "0000000000000000000000000000000000A:0")
+		for _, p := range breached {
+			sum := sha1.Sum([]byte(p))
+			hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+			if hash[:5] == prefix {
+				fmt.Fprintf(w, "%s:42\r\n", hash[5:])
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestPwned(t *testing.T) {
+	srv := pwnedServer(t, "hunter2")
+	if n, err := Pwned(srv.URL+"/range/", "hunter2"); err != nil || n != 42 {
+		t.Fatalf("Pwned(hunter2) = %d, %v", n, err)
+	}
+	if n, err := Pwned(srv.URL+"/range/", "Xq7vR2mN9pL4tK8wZ3yB"); err != nil || n != 0 {
+		t.Fatalf("Pwned(random) = %d, %v", n, err)
+	}
+}
+
+func TestWeak(t *testing.T) {
+	for pass, weak := range map[string]bool{
+		"short1A":                      true,
+		"MyProxyPassword1":             true,
+		"aaaaabbbbbcccc":               true,
+		"lowercaseonlyhere":            true,
+		"Xq7vR2mN9pL4tK8wZ3yB":         false,
+		"correct-horse-battery-staple": false,
+	} {
+		if got := Weak(pass) != ""; got != weak {
+			t.Fatalf("Weak(%q) = %q, want weak=%v", pass, Weak(pass), weak)
+		}
+	}
+}
+
+func TestScreenRegeneratesLeaked(t *testing.T) {
+	srv := pwnedServer(t, "leakedLeaked1", "leakedLeaked2")
+	passes := []string{"leakedLeaked2", "Xq7vR2mN9pL4tK8wZ3yB"}
+	svc := hangar.NewService()
+	svc.ScriptRunner = func(_ string, args []string) (string, error) {
+		if args[1] == "prepare" {
+			return "BM_PROXY_PORT=1080\nBM_PROXY_FINGERPRINT=" + strings.Repeat("0", 64) + "\n", nil
+		}
+		if got := strings.Join(args, " "); !strings.Contains(got, "--rotate-credentials --rotate-scope password") {
+			t.Fatalf("expected a password rotation, got %q", got)
+		}
+		pass := passes[0]
+		passes = passes[1:]
+		return "BM_RESULT_PROTOCOL=SOCKS5\nBM_RESULT_USER=beamab12c\nBM_RESULT_PASS=" + pass + "\nBM_RESULT_ACTION=updated\n", nil
+	}
+	ship := ships.Ship{Name: "myship", Host: "192.0.2.10", SSHUser: "root", SSHPort: 22}
+	in := hangar.ActionInput{Mode: "apply", Protocol: "socks5"}
+	first := hangar.ActionResult{Protocol: "SOCKS5", User: "beamab12c", Pass: "leakedLeaked1", Action: "created"}
+
+	res, warnings := Screen(svc, ship, "pw", in, first, true, srv.URL+"/range/")
+	if res.Pass != "Xq7vR2mN9pL4tK8wZ3yB" || len(warnings) != 2 {
+		t.Fatalf("expected two regenerations, got %q with %q", res.Pass, warnings)
+	}
+
+	// A password the run kept is only reported.
+	kept := first
+	kept.Action = "updated"
+	res, warnings = Screen(svc, ship, "pw", in, kept, true, srv.URL+"/range/")
+	if res.Pass != "leakedLeaked1" || len(warnings) != 1 || !strings.Contains(warnings[0], "42 times") {
+		t.Fatalf("kept password: got %q with %q", res.Pass, warnings)
+	}
+	if _, warnings := Screen(svc, ship, "pw", in, kept, false, ""); len(warnings) != 0 {
+		t.Fatalf("leakedLeaked1 is not weak, got %q", warnings)
+	}
+}
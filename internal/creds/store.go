@@ -0,0 +1,306 @@
+package creds
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/alfaoz/beammeup/internal/hangar"
+	"github.com/alfaoz/beammeup/internal/ships"
+	"golang.org/x/crypto/scrypt"
+)
+
+const DefaultDirSuffix = ".beammeup/creds"
+
+// FileExt is the extension of a result card exported for another machine.
+const FileExt = ".beamcreds"
+
+var (
+	// ErrNotFound means no result card is stored for the ship.
+	ErrNotFound = errors.New("no stored credentials")
+	// ErrLocked means the card is encrypted and no (or the wrong) passphrase
+	// was given.
+	ErrLocked = errors.New("stored credentials are encrypted; set BEAMMEUP_CREDS_PASSPHRASE")
+)
+
+// Card is the last apply or show result for a ship: everything a client-side
+// feature needs to reach the proxy without asking the server again.
+type Card struct {
+	Ship      string    `json:"ship"`
+	Protocol  string    `json:"protocol"` // http|socks5
+	HTTPMode  string    `json:"http_mode,omitempty"`
+	Host      string    `json:"host"`
+	Port      string    `json:"port"`
+	User      string    `json:"user"`
+	Pass      string    `json:"pass"`
+	EgressIP  string    `json:"egress_ip,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// FromResult builds the card for ship from an apply or show result.
+func FromResult(ship string, res hangar.ActionResult) Card {
+	return Card{
+		Ship:      ship,
+		Protocol:  strings.ToLower(res.Protocol),
+		HTTPMode:  res.HTTPMode,
+		Host:      res.Host,
+		Port:      res.Port,
+		User:      res.User,
+		Pass:      res.Pass,
+		EgressIP:  res.EgressIP,
+		ExpiresAt: res.ExpiresAt,
+	}
+}
+
+// Result turns the card back into the show result it came from.
+func (c Card) Result() hangar.ActionResult {
+	return hangar.ActionResult{
+		Protocol:  strings.ToUpper(c.Protocol),
+		HTTPMode:  c.HTTPMode,
+		Host:      c.Host,
+		Port:      c.Port,
+		User:      c.User,
+		Pass:      c.Pass,
+		Action:    "show",
+		EgressIP:  c.EgressIP,
+		ExpiresAt: c.ExpiresAt,
+	}
+}
+
+// Change is one field clients connect with, compared between two cards.
+type Change struct {
+	Field  string
+	Before string
+	After  string
+}
+
+func (c Change) Differs() bool { return c.Before != c.After }
+
+func (c Change) String() string {
+	switch {
+	case c.Field == "Password" && c.Differs():
+		return "Password: changed"
+	case c.Field == "Password":
+		return "Password: kept"
+	case c.Differs():
+		return fmt.Sprintf("%s: %s -> %s", c.Field, fallback(c.Before, "-"), fallback(c.After, "-"))
+	default:
+		return fmt.Sprintf("%s: kept (%s)", c.Field, fallback(c.After, "-"))
+	}
+}
+
+// Compare lists the fields a client connects with, from the card before a
+// rotate or apply to the one after it. Passwords are compared but never
+// shown.
+func Compare(before, after Card) []Change {
+	return []Change{
+		{"Protocol", before.Protocol, after.Protocol},
+		{"Host", before.Host, after.Host},
+		{"Port", before.Port, after.Port},
+		{"Username", before.User, after.User},
+		{"Password", before.Pass, after.Pass},
+	}
+}
+
+func fallback(v, def string) string {
+	if v == "" {
+		return def
+	}
+	return v
+}
+
+// sealed is the on-disk form of an encrypted card.
+type sealed struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+type Store struct {
+	Dir string
+	// Passphrase, when set, encrypts cards written from now on and decrypts
+	// encrypted ones. Plain cards stay readable either way.
+	Passphrase string
+}
+
+func NewStore(dir, passphrase string) (*Store, error) {
+	if strings.TrimSpace(dir) == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("resolve home dir: %w", err)
+		}
+		dir = filepath.Join(home, DefaultDirSuffix)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("ensure creds dir: %w", err)
+	}
+	return &Store{Dir: dir, Passphrase: passphrase}, nil
+}
+
+func (s *Store) path(ship string) string {
+	return filepath.Join(s.Dir, ship+".json")
+}
+
+// Save stores card under its ship name, replacing any previous card.
+func (s *Store) Save(card Card) error {
+	ship := ships.SanitizeName(card.Ship)
+	if ship == "" {
+		return errors.New("invalid ship name")
+	}
+	card.Ship = ship
+	card.Protocol = strings.ToLower(card.Protocol)
+	if card.UpdatedAt.IsZero() {
+		card.UpdatedAt = time.Now()
+	}
+	card.UpdatedAt = card.UpdatedAt.UTC()
+	data, err := s.Encode(card)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(s.Dir, ship+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("write credentials: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write credentials: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write credentials: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write credentials: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path(ship)); err != nil {
+		return fmt.Errorf("write credentials: %w", err)
+	}
+	return nil
+}
+
+// Load returns the card stored for ship.
+func (s *Store) Load(ship string) (Card, error) {
+	ship = ships.SanitizeName(ship)
+	if ship == "" {
+		return Card{}, errors.New("invalid ship name")
+	}
+	data, err := os.ReadFile(s.path(ship))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return Card{}, ErrNotFound
+		}
+		return Card{}, fmt.Errorf("read credentials: %w", err)
+	}
+	return s.Decode(data)
+}
+
+// Delete removes ship's card.
+func (s *Store) Delete(ship string) error {
+	ship = ships.SanitizeName(ship)
+	if ship == "" {
+		return errors.New("invalid ship name")
+	}
+	if err := os.Remove(s.path(ship)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("delete credentials: %w", err)
+	}
+	return nil
+}
+
+// Encode renders card as a .beamcreds document, encrypted when the store has
+// a passphrase.
+func (s *Store) Encode(card Card) ([]byte, error) {
+	plain, err := json.MarshalIndent(card, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if s.Passphrase == "" {
+		return append(plain, '\n'), nil
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(s.Passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out, err := json.MarshalIndent(sealed{
+		Version:    1,
+		KDF:        "scrypt",
+		Salt:       salt,
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, plain, nil),
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+// Decode parses a .beamcreds document, decrypting it with the store's
+// passphrase when it is sealed.
+func (s *Store) Decode(data []byte) (Card, error) {
+	var probe struct {
+		Ciphertext []byte `json:"ciphertext"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return Card{}, fmt.Errorf("parse credentials: %w", err)
+	}
+	if probe.Ciphertext != nil {
+		var env sealed
+		if err := json.Unmarshal(data, &env); err != nil {
+			return Card{}, fmt.Errorf("parse credentials: %w", err)
+		}
+		if env.Version != 1 || env.KDF != "scrypt" {
+			return Card{}, fmt.Errorf("unsupported credentials format (version %d, %s)", env.Version, env.KDF)
+		}
+		if s.Passphrase == "" {
+			return Card{}, ErrLocked
+		}
+		aead, err := newAEAD(s.Passphrase, env.Salt)
+		if err != nil {
+			return Card{}, err
+		}
+		if len(env.Nonce) != aead.NonceSize() {
+			return Card{}, errors.New("parse credentials: bad nonce")
+		}
+		plain, err := aead.Open(nil, env.Nonce, env.Ciphertext, nil)
+		if err != nil {
+			return Card{}, ErrLocked
+		}
+		data = plain
+	}
+	var card Card
+	if err := json.Unmarshal(data, &card); err != nil {
+		return Card{}, fmt.Errorf("parse credentials: %w", err)
+	}
+	return card, nil
+}
+
+func newAEAD(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
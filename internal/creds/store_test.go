@@ -0,0 +1,82 @@
+package creds
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestStoreRoundTrip(t *testing.T) {
+	store, err := NewStore(t.TempDir(), "")
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	card := Card{Ship: "edge", Protocol: "HTTP", Host: "203.0.113.7", Port: "8888", User: "u", Pass: "p"}
+	if err := store.Save(card); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	info, err := os.Stat(store.path("edge"))
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("card mode = %v, want 0600", info.Mode().Perm())
+	}
+	got, err := store.Load("edge")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got.Protocol != "http" || got.Pass != "p" || got.UpdatedAt.IsZero() {
+		t.Fatalf("unexpected card: %+v", got)
+	}
+	if err := store.Delete("edge"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := store.Load("edge"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestStoreEncrypted(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(dir, "correct horse")
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	if err := store.Save(Card{Ship: "edge", Protocol: "socks5", User: "u", Pass: "secret-pass"}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	raw, err := os.ReadFile(store.path("edge"))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if strings.Contains(string(raw), "secret-pass") {
+		t.Fatalf("encrypted card leaks the password: %s", raw)
+	}
+	if got, err := store.Load("edge"); err != nil || got.Pass != "secret-pass" {
+		t.Fatalf("Load = %+v, %v", got, err)
+	}
+	for _, pass := range []string{"", "wrong"} {
+		other := &Store{Dir: dir, Passphrase: pass}
+		if _, err := other.Load("edge"); !errors.Is(err, ErrLocked) {
+			t.Fatalf("passphrase %q: expected ErrLocked, got %v", pass, err)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	before := Card{Protocol: "http", Host: "203.0.113.7", Port: "8888", User: "u1", Pass: "old"}
+	after := Card{Protocol: "http", Host: "203.0.113.7", Port: "8888", User: "u2", Pass: "new"}
+	var got []string
+	for _, c := range Compare(before, after) {
+		got = append(got, c.String())
+	}
+	want := "Protocol: kept (http)|Host: kept (203.0.113.7)|Port: kept (8888)|Username: u1 -> u2|Password: changed"
+	if strings.Join(got, "|") != want {
+		t.Fatalf("Compare = %q", got)
+	}
+	if strings.Contains(strings.Join(got, ""), "old") {
+		t.Fatal("Compare leaked the old password")
+	}
+}
@@ -0,0 +1,199 @@
+// Package events writes the machine-readable event stream of --events-json:
+// one JSON object per line, so wrappers and GUIs can follow a run without
+// scraping the human-oriented output.
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types.
+const (
+	TypePhase    = "phase"
+	TypeProgress = "progress"
+	TypeResult   = "result"
+	TypeError    = "error"
+)
+
+// Event is one line of the stream.
+type Event struct {
+	Time    time.Time         `json:"time"`
+	Type    string            `json:"type"`
+	Ship    string            `json:"ship,omitempty"` // set on the events of one ship in a --ships-from run
+	Phase   string            `json:"phase,omitempty"`
+	Status  string            `json:"status,omitempty"` // phase events: started, done or failed
+	Message string            `json:"message,omitempty"`
+	Data    map[string]string `json:"data,omitempty"`
+}
+
+// Emitter writes events to w. A nil *Emitter discards everything, so callers
+// need not check whether the stream is on.
+type Emitter struct {
+	mu      *sync.Mutex
+	w       io.Writer
+	phase   string
+	ship    string
+	results bool // only result and error events (--output json)
+	now     func() time.Time
+}
+
+func New(w io.Writer) *Emitter {
+	return &Emitter{mu: &sync.Mutex{}, w: w, now: time.Now}
+}
+
+// NewResults returns an emitter that writes only result and error events,
+// one line per ship and one for the run, for --output json.
+func NewResults(w io.Writer) *Emitter {
+	e := New(w)
+	e.results = true
+	return e
+}
+
+// ForShip returns an emitter writing to the same stream that marks its
+// events with ship and follows its own phases.
+func (e *Emitter) ForShip(ship string) *Emitter {
+	if e == nil {
+		return nil
+	}
+	return &Emitter{mu: e.mu, w: e.w, ship: ship, results: e.results, now: e.now}
+}
+
+// Open returns the emitter for an --events-json target: "stderr" (or empty)
+// or the number of an inherited file descriptor, e.g. "3".
+func Open(target string) (*Emitter, error) {
+	switch target = strings.TrimSpace(target); target {
+	case "", "stderr":
+		return New(os.Stderr), nil
+	case "stdout":
+		return nil, fmt.Errorf("--events-json: stdout carries the human-readable output; use stderr or a file descriptor")
+	}
+	fd, err := strconv.Atoi(target)
+	if err != nil || fd < 3 {
+		return nil, fmt.Errorf("--events-json: want stderr or a file descriptor number >= 3, got %q", target)
+	}
+	f := os.NewFile(uintptr(fd), "events")
+	if f == nil {
+		return nil, fmt.Errorf("--events-json: file descriptor %d is not open", fd)
+	}
+	if _, err := f.Stat(); err != nil {
+		return nil, fmt.Errorf("--events-json: file descriptor %d is not open", fd)
+	}
+	return New(f), nil
+}
+
+func (e *Emitter) emit(ev Event) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ev.Time = e.now().UTC()
+	if ev.Phase == "" && ev.Type != TypePhase {
+		ev.Phase = e.phase
+	}
+	e.write(ev)
+}
+
+// Relay passes on an event read from another beammeup's stream, such as a
+// --max-parallel child's, marked with this emitter's ship.
+func (e *Emitter) Relay(ev Event) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if ev.Time.IsZero() {
+		ev.Time = e.now().UTC()
+	}
+	e.write(ev)
+}
+
+// write needs e.mu held.
+func (e *Emitter) write(ev Event) {
+	if e.results && ev.Type != TypeResult && ev.Type != TypeError {
+		return
+	}
+	ev.Ship = e.ship
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	e.w.Write(append(line, '\n'))
+}
+
+// Phase announces that phase started and returns the function that closes
+// it: done for a nil error, failed otherwise. Progress lines in between are
+// attributed to phase.
+func (e *Emitter) Phase(phase string) func(err error) {
+	if e == nil {
+		return func(error) {}
+	}
+	e.mu.Lock()
+	e.phase = phase
+	e.mu.Unlock()
+	e.emit(Event{Type: TypePhase, Phase: phase, Status: "started"})
+	return func(err error) {
+		ev := Event{Type: TypePhase, Phase: phase, Status: "done"}
+		if err != nil {
+			ev.Status = "failed"
+			ev.Message = err.Error()
+		}
+		e.emit(ev)
+	}
+}
+
+// Progress reports a step within the current phase.
+func (e *Emitter) Progress(message string) {
+	e.emit(Event{Type: TypeProgress, Message: message})
+}
+
+// Result reports the outcome of the run.
+func (e *Emitter) Result(data map[string]string) {
+	e.emit(Event{Type: TypeResult, Data: data})
+}
+
+// Error reports the error the run ended with.
+func (e *Emitter) Error(err error) {
+	if err == nil {
+		return
+	}
+	e.emit(Event{Type: TypeError, Message: err.Error()})
+}
+
+// LogWriter turns each line written to it into a progress event, for the
+// remote script's log. It returns nil for a nil Emitter.
+func (e *Emitter) LogWriter() io.Writer {
+	if e == nil {
+		return nil
+	}
+	return &logWriter{e: e}
+}
+
+type logWriter struct {
+	e   *Emitter
+	buf bytes.Buffer
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	for {
+		line, err := w.buf.ReadString('\n')
+		if err != nil {
+			// Keep the partial line for the next write.
+			w.buf.Reset()
+			w.buf.WriteString(line)
+			return len(p), nil
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			w.e.Progress(line)
+		}
+	}
+}
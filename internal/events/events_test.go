@@ -0,0 +1,72 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEmitterStream(t *testing.T) {
+	var b strings.Builder
+	e := New(&b)
+	e.now = func() time.Time { return time.Unix(0, 0) }
+
+	end := e.Phase("apply")
+	io.WriteString(e.LogWriter(), "[beammeup] installing squid\n[beammeup] wri")
+	end(errors.New("boom"))
+	e.Result(map[string]string{"port": "1080"})
+
+	var got []Event
+	sc := bufio.NewScanner(strings.NewReader(b.String()))
+	for sc.Scan() {
+		var ev Event
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+			t.Fatalf("line %q: %v", sc.Text(), err)
+		}
+		got = append(got, ev)
+	}
+	if len(got) != 4 {
+		t.Fatalf("got %d events: %s", len(got), b.String())
+	}
+	if got[0].Type != TypePhase || got[0].Status != "started" || got[0].Phase != "apply" {
+		t.Fatalf("start = %+v", got[0])
+	}
+	if got[1].Type != TypeProgress || got[1].Phase != "apply" || got[1].Message != "[beammeup] installing squid" {
+		t.Fatalf("progress = %+v", got[1])
+	}
+	if got[2].Status != "failed" || got[2].Message != "boom" {
+		t.Fatalf("end = %+v", got[2])
+	}
+	if got[3].Type != TypeResult || got[3].Data["port"] != "1080" {
+		t.Fatalf("result = %+v", got[3])
+	}
+	if got[0].Ship != "" {
+		t.Fatalf("a single-ship run should not name the ship: %+v", got[0])
+	}
+
+	b.Reset()
+	e.ForShip("myship").Result(map[string]string{"port": "3128"})
+	if !strings.Contains(b.String(), `"ship":"myship"`) {
+		t.Fatalf("ship missing from %s", b.String())
+	}
+
+	var nilEmitter *Emitter
+	nilEmitter.Phase("x")(nil)
+	nilEmitter.Error(errors.New("ignored"))
+}
+
+func TestOpenRejectsStdout(t *testing.T) {
+	if _, err := Open("stdout"); err == nil {
+		t.Fatal("expected stdout to be rejected")
+	}
+	if _, err := Open("1"); err == nil {
+		t.Fatal("expected fd 1 to be rejected")
+	}
+	if e, err := Open(""); err != nil || e == nil {
+		t.Fatalf("Open(\"\") = %v, %v", e, err)
+	}
+}
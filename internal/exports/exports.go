@@ -0,0 +1,250 @@
+// Package exports renders proxy credentials in the formats other tools
+// import (csv, json, host:port:user:pass lines, Clash, FoxyProxy) and keeps
+// the files registered for a ship in step with its stored credentials.
+package exports
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/alfaoz/beammeup/internal/creds"
+	"github.com/alfaoz/beammeup/internal/geoip"
+	"github.com/alfaoz/beammeup/internal/ships"
+)
+
+// Formats lists the credential formats Render writes.
+var Formats = []string{"csv", "json", "proxyline", "clash", "foxyproxy"}
+
+// Row is one proxy in an export.
+type Row struct {
+	Ship     string `json:"ship"`
+	Protocol string `json:"protocol"` // http|socks5
+	Host     string `json:"host"`
+	Port     string `json:"port"`
+	User     string `json:"user"`
+	Pass     string `json:"pass"`
+
+	// Where the exit IP sits, from the last inventory with GeoIP set up.
+	Country string `json:"country,omitempty"` // ISO code
+	City    string `json:"city,omitempty"`
+	ASN     string `json:"asn,omitempty"` // "AS24940"
+	Org     string `json:"org,omitempty"`
+}
+
+// Locate adds where the exit IP sits to row.
+func (row Row) Locate(geo geoip.Info) Row {
+	row.Country, row.City, row.ASN, row.Org = geo.CountryCode, geo.City, geoip.ASLabel(geo.ASN), geo.Org
+	return row
+}
+
+// FromCard builds the row for ship from its stored result card. A proxy that
+// listens on localhost is reached through an SSH tunnel on 127.0.0.1.
+func FromCard(ship ships.Ship, card creds.Card) Row {
+	host := card.Host
+	if host == "" {
+		host = ship.Host
+	}
+	if ship.ListenLocal {
+		host = "127.0.0.1"
+	}
+	return Row{Ship: ship.Name, Protocol: card.Protocol, Host: host, Port: card.Port, User: card.User, Pass: card.Pass}
+}
+
+// Render writes rows in format, one of Formats.
+func Render(rows []Row, format string) (string, error) {
+	var b strings.Builder
+	switch format {
+	case "csv":
+		w := csv.NewWriter(&b)
+		w.Write([]string{"ship", "protocol", "host", "port", "user", "pass", "country", "city", "asn", "org"})
+		for _, row := range rows {
+			w.Write([]string{row.Ship, row.Protocol, row.Host, row.Port, row.User, row.Pass, row.Country, row.City, row.ASN, row.Org})
+		}
+		w.Flush()
+		return b.String(), w.Error()
+	case "json":
+		return indentJSON(rows)
+	case "proxyline":
+		for _, row := range rows {
+			fmt.Fprintf(&b, "%s:%s:%s:%s\n", row.Host, row.Port, row.User, row.Pass)
+		}
+		return b.String(), nil
+	case "clash":
+		b.WriteString("# Written by beammeup; changes here are overwritten.\nproxies:\n")
+		for _, row := range rows {
+			fmt.Fprintf(&b, "  - name: %s\n", strconv.Quote(row.Ship))
+			fmt.Fprintf(&b, "    type: %s\n", row.Protocol)
+			fmt.Fprintf(&b, "    server: %s\n", strconv.Quote(row.Host))
+			fmt.Fprintf(&b, "    port: %s\n", row.Port)
+			fmt.Fprintf(&b, "    username: %s\n", strconv.Quote(row.User))
+			fmt.Fprintf(&b, "    password: %s\n", strconv.Quote(row.Pass))
+		}
+		return b.String(), nil
+	case "foxyproxy":
+		return foxyProxy(rows)
+	default:
+		return "", fmt.Errorf("unknown export format %q (use %s)", format, strings.Join(Formats, ", "))
+	}
+}
+
+// foxyProxy writes the settings file FoxyProxy 8 imports (Options > Import).
+func foxyProxy(rows []Row) (string, error) {
+	type proxy struct {
+		Active   bool     `json:"active"`
+		Title    string   `json:"title"`
+		Type     string   `json:"type"`
+		Hostname string   `json:"hostname"`
+		Port     string   `json:"port"`
+		Username string   `json:"username"`
+		Password string   `json:"password"`
+		CC       string   `json:"cc"`
+		City     string   `json:"city"`
+		ProxyDNS bool     `json:"proxyDNS"`
+		Include  []string `json:"include"`
+		Exclude  []string `json:"exclude"`
+	}
+	data := make([]proxy, 0, len(rows))
+	for _, row := range rows {
+		data = append(data, proxy{
+			Active:   true,
+			Title:    "beammeup " + row.Ship,
+			Type:     row.Protocol,
+			Hostname: row.Host,
+			Port:     row.Port,
+			Username: row.User,
+			Password: row.Pass,
+			CC:       row.Country,
+			City:     row.City,
+			ProxyDNS: true,
+			Include:  []string{},
+			Exclude:  []string{},
+		})
+	}
+	return indentJSON(map[string]any{"mode": "disable", "data": data})
+}
+
+func indentJSON(v any) (string, error) {
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// Target is a file registered for a ship, rewritten whenever its
+// credentials change. It is stored in the ship profile as format:path.
+type Target struct {
+	Format string
+	Path   string
+}
+
+func (t Target) String() string { return t.Format + ":" + t.Path }
+
+// ParseTarget reads a format:path entry.
+func ParseTarget(raw string) (Target, error) {
+	format, path, ok := strings.Cut(strings.TrimSpace(raw), ":")
+	format = strings.ToLower(strings.TrimSpace(format))
+	path = strings.TrimSpace(path)
+	if !ok || path == "" {
+		return Target{}, fmt.Errorf("export target %q: want format:path", raw)
+	}
+	if !slices.Contains(Formats, format) {
+		return Target{}, fmt.Errorf("export target %q: format must be one of %s", raw, strings.Join(Formats, ", "))
+	}
+	if strings.Contains(path, ",") {
+		return Target{}, fmt.Errorf("export target %q: the path cannot contain a comma", raw)
+	}
+	return Target{Format: format, Path: path}, nil
+}
+
+// Targets reads the ship's registered export targets, skipping entries it
+// cannot parse.
+func Targets(ship ships.Ship) []Target {
+	var out []Target
+	for _, raw := range ship.Exports {
+		if t, err := ParseTarget(raw); err == nil {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// Regenerate rewrites every export target registered for ship from card and
+// geo, the location of its exit IP. It returns the paths written and the
+// first error; a failing target does not stop the others.
+func Regenerate(ship ships.Ship, card creds.Card, geo geoip.Info) ([]string, error) {
+	rows := []Row{FromCard(ship, card).Locate(geo)}
+	var (
+		written  []string
+		firstErr error
+	)
+	for _, t := range Targets(ship) {
+		err := writeTarget(t, rows)
+		if err == nil {
+			written = append(written, t.Path)
+		} else if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return written, firstErr
+}
+
+func writeTarget(t Target, rows []Row) error {
+	content, err := Render(rows, t.Format)
+	if err != nil {
+		return err
+	}
+	path, err := ExpandHome(t.Path)
+	if err != nil {
+		return err
+	}
+	if err := WriteFileAtomic(path, []byte(content), 0o600); err != nil {
+		return fmt.Errorf("export %s: %w", t, err)
+	}
+	return nil
+}
+
+// ExpandHome resolves a leading ~/ in path.
+func ExpandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("resolve home dir: %w", err)
+	}
+	return filepath.Join(home, strings.TrimPrefix(path, "~")), nil
+}
+
+// WriteFileAtomic replaces path with data through a temporary file in the
+// same directory, so readers never see a half-written file.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,97 @@
+package exports
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alfaoz/beammeup/internal/creds"
+	"github.com/alfaoz/beammeup/internal/geoip"
+	"github.com/alfaoz/beammeup/internal/ships"
+)
+
+func TestRender(t *testing.T) {
+	rows := []Row{
+		Row{Ship: "edge", Protocol: "socks5", Host: "198.51.100.10", Port: "1080", User: "u1", Pass: "p,1"}.Locate(geoip.Info{CountryCode: "DE", City: "Frankfurt am Main", ASN: 64500, Org: "Example Net"}),
+		{Ship: "lab", Protocol: "http", Host: "192.0.2.7", Port: "8080", User: "u2", Pass: "p2"},
+	}
+	got, err := Render(rows, "csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "ship,protocol,host,port,user,pass,country,city,asn,org\nedge,socks5,198.51.100.10,1080,u1,\"p,1\",DE,Frankfurt am Main,AS64500,Example Net\nlab,http,192.0.2.7,8080,u2,p2,,,,\n"
+	if got != want {
+		t.Fatalf("csv =\n%s", got)
+	}
+
+	got, err = Render(rows, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var back []Row
+	if err := json.Unmarshal([]byte(got), &back); err != nil || len(back) != 2 || back[1] != rows[1] {
+		t.Fatalf("json = %s (%v)", got, err)
+	}
+	if !strings.Contains(got, `"pass": "p,1"`) || !strings.Contains(got, `"asn": "AS64500"`) || strings.Count(got, `"country"`) != 1 {
+		t.Fatalf("json keys = %s", got)
+	}
+
+	got, err = Render(rows, "proxyline")
+	if err != nil || got != "198.51.100.10:1080:u1:p,1\n192.0.2.7:8080:u2:p2\n" {
+		t.Fatalf("proxyline = %q, %v", got, err)
+	}
+
+	got, err = Render(rows[:1], "clash")
+	if err != nil || !strings.HasSuffix(got, "proxies:\n  - name: \"edge\"\n    type: socks5\n    server: \"198.51.100.10\"\n    port: 1080\n    username: \"u1\"\n    password: \"p,1\"\n") {
+		t.Fatalf("clash =\n%s (%v)", got, err)
+	}
+
+	got, err = Render(rows[:1], "foxyproxy")
+	var foxy struct {
+		Data []struct {
+			Hostname, Port, Username, Type, CC string
+		}
+	}
+	if err != nil || json.Unmarshal([]byte(got), &foxy) != nil || len(foxy.Data) != 1 || foxy.Data[0].Hostname != "198.51.100.10" || foxy.Data[0].Type != "socks5" || foxy.Data[0].CC != "DE" {
+		t.Fatalf("foxyproxy = %s (%v)", got, err)
+	}
+
+	if _, err := Render(rows, "pac"); err == nil {
+		t.Fatal("unknown format accepted")
+	}
+}
+
+func TestParseTarget(t *testing.T) {
+	if got, err := ParseTarget("Clash:~/clash/edge.yaml"); err != nil || got != (Target{Format: "clash", Path: "~/clash/edge.yaml"}) {
+		t.Fatalf("ParseTarget = %+v, %v", got, err)
+	}
+	for _, bad := range []string{"clash", "clash:", "pac:/tmp/x", "csv:/tmp/a,b"} {
+		if _, err := ParseTarget(bad); err == nil {
+			t.Fatalf("ParseTarget(%q) accepted", bad)
+		}
+	}
+}
+
+func TestRegenerate(t *testing.T) {
+	dir := t.TempDir()
+	ship := ships.Ship{Name: "edge", Host: "198.51.100.10", ListenLocal: true, Exports: []string{
+		"proxyline:" + filepath.Join(dir, "edge.txt"),
+		"bogus",
+		"json:" + filepath.Join(dir, "sub", "edge.json"),
+	}}
+	card := creds.Card{Protocol: "socks5", Port: "1080", User: "u", Pass: "p"}
+	written, err := Regenerate(ship, card, geoip.Info{})
+	if err != nil || len(written) != 2 {
+		t.Fatalf("Regenerate = %v, %v", written, err)
+	}
+	got, _ := os.ReadFile(filepath.Join(dir, "edge.txt"))
+	if string(got) != "127.0.0.1:1080:u:p\n" {
+		t.Fatalf("proxyline file = %q", got)
+	}
+	info, err := os.Stat(filepath.Join(dir, "sub", "edge.json"))
+	if err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("json file: %v, %v", info, err)
+	}
+}
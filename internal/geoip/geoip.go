@@ -0,0 +1,218 @@
+// Package geoip tells where a ship's exit IP sits: country, city and the
+// network (ASN) it belongs to. The lookup is opt-in and prefers local MMDB
+// files (MaxMind GeoLite2, DB-IP Lite), so no third party learns which
+// servers beammeup manages; an echoip-compatible API is the fallback.
+package geoip
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Info is what the lookup found; any field may be empty.
+type Info struct {
+	CountryCode string
+	Country     string
+	City        string
+	ASN         uint
+	Org         string // the AS's organization
+}
+
+func (i Info) IsZero() bool {
+	return i == Info{}
+}
+
+// Location renders "Frankfurt am Main, Germany (DE)".
+func (i Info) Location() string {
+	var parts []string
+	if i.City != "" {
+		parts = append(parts, i.City)
+	}
+	if i.Country != "" {
+		parts = append(parts, i.Country)
+	}
+	s := strings.Join(parts, ", ")
+	if i.CountryCode != "" {
+		if s == "" {
+			return i.CountryCode
+		}
+		s += " (" + i.CountryCode + ")"
+	}
+	return s
+}
+
+// Network renders "AS24940 Hetzner Online GmbH".
+func (i Info) Network() string {
+	switch {
+	case i.ASN == 0:
+		return i.Org
+	case i.Org == "":
+		return ASLabel(i.ASN)
+	}
+	return ASLabel(i.ASN) + " " + i.Org
+}
+
+// ASLabel renders asn as "AS24940", or "" for 0.
+func ASLabel(asn uint) string {
+	if asn == 0 {
+		return ""
+	}
+	return "AS" + strconv.FormatUint(uint64(asn), 10)
+}
+
+// Resolver looks addresses up in DBs, then at URL for whatever they left
+// open. The zero Resolver finds nothing.
+type Resolver struct {
+	DBs []string // MMDB files, e.g. a city and an ASN database
+	URL string   // echoip-style JSON API, e.g. https://ifconfig.co/json
+
+	once sync.Once
+	dbs  []*reader
+	err  error
+}
+
+// New returns a Resolver, or nil when neither dbs nor apiURL is set.
+func New(dbs []string, apiURL string) *Resolver {
+	var paths []string
+	for _, p := range dbs {
+		if p = strings.TrimSpace(p); p != "" {
+			paths = append(paths, p)
+		}
+	}
+	apiURL = strings.TrimSpace(apiURL)
+	if len(paths) == 0 && apiURL == "" {
+		return nil
+	}
+	return &Resolver{DBs: paths, URL: apiURL}
+}
+
+// Lookup returns what is known about ip. The databases are read on first use.
+func (r *Resolver) Lookup(ip string) (Info, error) {
+	addr := net.ParseIP(strings.TrimSpace(ip))
+	if addr == nil {
+		return Info{}, fmt.Errorf("not an IP address: %q", ip)
+	}
+	r.once.Do(func() {
+		for _, path := range r.DBs {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				r.err = err
+				return
+			}
+			db, err := openReader(data)
+			if err != nil {
+				r.err = fmt.Errorf("%s: %w", path, err)
+				return
+			}
+			r.dbs = append(r.dbs, db)
+		}
+	})
+	if r.err != nil {
+		return Info{}, r.err
+	}
+	var info Info
+	for _, db := range r.dbs {
+		rec, err := db.lookup(addr)
+		if err != nil {
+			return info, err
+		}
+		merge(&info, fromRecord(rec))
+	}
+	if r.URL != "" && (info.CountryCode == "" || info.ASN == 0) {
+		found, err := lookupAPI(r.URL, addr.String())
+		if err != nil {
+			return info, err
+		}
+		merge(&info, found)
+	}
+	return info, nil
+}
+
+// merge fills the fields of dst that are still empty from src.
+func merge(dst *Info, src Info) {
+	if dst.CountryCode == "" {
+		dst.CountryCode = src.CountryCode
+	}
+	if dst.Country == "" {
+		dst.Country = src.Country
+	}
+	if dst.City == "" {
+		dst.City = src.City
+	}
+	if dst.ASN == 0 {
+		dst.ASN = src.ASN
+	}
+	if dst.Org == "" {
+		dst.Org = src.Org
+	}
+}
+
+// fromRecord reads the GeoLite2 and DB-IP record layout, which City,
+// Country and ASN databases share.
+func fromRecord(rec map[string]any) Info {
+	var info Info
+	if country, ok := rec["country"].(map[string]any); ok {
+		info.CountryCode, _ = country["iso_code"].(string)
+		info.Country = englishName(country)
+	}
+	if city, ok := rec["city"].(map[string]any); ok {
+		info.City = englishName(city)
+	}
+	if asn, ok := rec["autonomous_system_number"].(uint64); ok {
+		info.ASN = uint(asn)
+	}
+	info.Org, _ = rec["autonomous_system_organization"].(string)
+	return info
+}
+
+func englishName(m map[string]any) string {
+	names, _ := m["names"].(map[string]any)
+	name, _ := names["en"].(string)
+	return name
+}
+
+// lookupAPI asks an echoip-compatible service (ifconfig.co, or one you run)
+// about ip.
+func lookupAPI(apiURL, ip string) (Info, error) {
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		return Info{}, err
+	}
+	q := u.Query()
+	q.Set("ip", ip)
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return Info{}, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "beammeup")
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		return Info{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Info{}, fmt.Errorf("geoip api: %s", resp.Status)
+	}
+	var body struct {
+		Country    string `json:"country"`
+		CountryISO string `json:"country_iso"`
+		City       string `json:"city"`
+		ASN        string `json:"asn"`
+		ASNOrg     string `json:"asn_org"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return Info{}, fmt.Errorf("geoip api: %w", err)
+	}
+	asn, _ := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(body.ASN), "AS"), 10, 32)
+	return Info{CountryCode: body.CountryISO, Country: body.Country, City: body.City, ASN: uint(asn), Org: body.ASNOrg}, nil
+}
@@ -0,0 +1,112 @@
+package geoip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Writers for the few MMDB data types the test database uses.
+func mmdbMap(n int) []byte { return []byte{0xe0 | byte(n)} }
+func mmdbString(s string) []byte {
+	if len(s) >= 29 {
+		return append([]byte{0x40 | 29, byte(len(s) - 29)}, s...)
+	}
+	return append([]byte{0x40 | byte(len(s))}, s...)
+}
+func mmdbUint(kind byte, v uint32) []byte {
+	return []byte{kind<<5 | 4, byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}
+}
+
+func cat(parts ...[]byte) []byte {
+	var out []byte
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
+
+// testDB builds an IPv6 database with 24-bit records: 0.0.0.0/1 is a German
+// city, 128.0.0.0/1 an AS whose name is reached through a pointer, and
+// nothing outside ::/96 is known.
+func testDB(t *testing.T) string {
+	city := cat(mmdbMap(2),
+		mmdbString("country"), mmdbMap(2),
+		mmdbString("iso_code"), mmdbString("DE"),
+		mmdbString("names"), mmdbMap(1), mmdbString("en"), mmdbString("Germany"),
+		mmdbString("city"), mmdbMap(1),
+		mmdbString("names"), mmdbMap(1), mmdbString("en"), mmdbString("Frankfurt am Main"),
+	)
+	org := mmdbString("Example Net")
+	asAt := len(city) + len(org)
+	as := cat(mmdbMap(2),
+		mmdbString("autonomous_system_number"), mmdbUint(6, 64500),
+		mmdbString("autonomous_system_organization"), []byte{0x20, byte(len(city))},
+	)
+	data := cat(city, org, as)
+
+	const nodes = 97
+	var tree []byte
+	rec := func(v int) []byte { return []byte{byte(v >> 16), byte(v >> 8), byte(v)} }
+	for i := 0; i < 96; i++ {
+		tree = append(tree, cat(rec(i+1), rec(nodes))...)
+	}
+	tree = append(tree, cat(rec(nodes+16), rec(nodes+16+asAt))...)
+
+	meta := cat(mmdbMap(3),
+		mmdbString("node_count"), mmdbUint(6, nodes),
+		mmdbString("record_size"), mmdbUint(5, 24),
+		mmdbString("ip_version"), mmdbUint(5, 6),
+	)
+	path := filepath.Join(t.TempDir(), "test.mmdb")
+	if err := os.WriteFile(path, cat(tree, make([]byte, 16), data, metadataMarker, meta), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLookupDB(t *testing.T) {
+	r := New([]string{testDB(t)}, "")
+	info, err := r.Lookup("192.0.2.10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Location() != "" || info.Network() != "AS64500 Example Net" {
+		t.Fatalf("192.0.2.10 = %+v", info)
+	}
+	info, err = r.Lookup("127.0.0.1")
+	if err != nil || info.Location() != "Frankfurt am Main, Germany (DE)" || info.ASN != 0 {
+		t.Fatalf("127.0.0.1 = %+v, %v", info, err)
+	}
+	if info, err := r.Lookup("2001:db8::1"); err != nil || !info.IsZero() {
+		t.Fatalf("2001:db8::1 = %+v, %v", info, err)
+	}
+	if _, err := New([]string{filepath.Join(t.TempDir(), "missing.mmdb")}, "").Lookup("192.0.2.10"); err == nil {
+		t.Fatal("expected a missing database to fail")
+	}
+}
+
+func TestLookupAPIFillsTheGaps(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("ip") != "127.0.0.1" {
+			http.Error(w, "bad ip", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"ip":"127.0.0.1","country":"Elsewhere","country_iso":"XX","city":"Nowhere","asn":"AS64501","asn_org":"API Net"}`))
+	}))
+	defer srv.Close()
+
+	info, err := New([]string{testDB(t)}, srv.URL+"/json").Lookup("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The database answers where it can; the API only adds the AS.
+	if info.CountryCode != "DE" || info.City != "Frankfurt am Main" || info.Network() != "AS64501 API Net" {
+		t.Fatalf("merged = %+v", info)
+	}
+	if New(nil, " ") != nil {
+		t.Fatal("expected no resolver without a source")
+	}
+}
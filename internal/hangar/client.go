@@ -0,0 +1,81 @@
+package hangar
+
+import (
+	"os"
+	"os/user"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ClientChange is the last change a beammeup client made to a hangar, as
+// the server recorded it.
+type ClientChange struct {
+	Client  string // user@host of the machine that ran it
+	Version string // beammeup version it ran
+	Action  string // apply, rotate, destroy or rollback
+	At      time.Time
+}
+
+// Label renders "bob@laptop rotated the credentials at 2026-01-02 15:04 CET
+// (beammeup 2.1.0)", or "" if nothing was recorded.
+func (c ClientChange) Label() string {
+	if c.Client == "" {
+		return ""
+	}
+	var verb string
+	switch c.Action {
+	case "apply":
+		verb = "configured the hangar"
+	case "rotate":
+		verb = "rotated the credentials"
+	case "destroy":
+		verb = "destroyed the hangar"
+	case "rollback":
+		verb = "rolled the hangar back"
+	default:
+		verb = "ran " + c.Action
+	}
+	s := c.Client + " " + verb
+	if !c.At.IsZero() {
+		s += " at " + c.At.Local().Format("2006-01-02 15:04 MST")
+	}
+	if c.Version != "" {
+		s += " (beammeup " + c.Version + ")"
+	}
+	return s
+}
+
+// LocalClient names this machine for the hangar's record of who changed it:
+// the login name and the short hostname, e.g. "bob@laptop".
+func LocalClient() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		name = u.Username
+		// Windows reports DOMAIN\user.
+		if i := strings.LastIndex(name, `\`); i >= 0 {
+			name = name[i+1:]
+		}
+	}
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		return name
+	}
+	host, _, _ = strings.Cut(host, ".")
+	return name + "@" + host
+}
+
+// cleanClient makes name something the server accepts for --client: one
+// line of at most 100 characters without "=".
+func cleanClient(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r == '=' || unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(name))
+	if r := []rune(name); len(r) > 100 {
+		name = string(r[:100])
+	}
+	return name
+}
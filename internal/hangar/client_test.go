@@ -0,0 +1,48 @@
+package hangar
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alfaoz/beammeup/internal/remote"
+	"github.com/alfaoz/beammeup/internal/ships"
+	"github.com/alfaoz/beammeup/internal/version"
+)
+
+func TestLastClient(t *testing.T) {
+	svc := NewService()
+	svc.Client = "bob@laptop\n=x"
+	var calls []string
+	svc.ScriptRunner = func(_ string, args []string) (string, error) {
+		calls = append(calls, strings.Join(args, " "))
+		return "BM_ROLLBACK=OK\nBM_BACKUP=20260101T120000Z\n", nil
+	}
+	ship := ships.Ship{Host: "x", SSHUser: "root", SSHPort: 22}
+	if _, err := svc.Rollback(ship, "pw", "20260101T110000Z"); err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+	if _, err := svc.Backups(ship, "pw"); err == nil {
+		t.Fatal("expected the fake list to be refused")
+	}
+	if want := "--client bob@laptopx --client-version " + version.AppVersion; !strings.HasSuffix(calls[0], want) {
+		t.Fatalf("rollback should name the client, got %q", calls[0])
+	}
+	if strings.Contains(calls[1], "--client") {
+		t.Fatalf("listing snapshots changes nothing and should not name the client, got %q", calls[1])
+	}
+
+	inv := parseInventory(remote.KeyValues{
+		"BM_LAST_CLIENT":         "bob@laptop",
+		"BM_LAST_CLIENT_VERSION": "2.1.0",
+		"BM_LAST_CLIENT_ACTION":  "rotate",
+		"BM_LAST_CLIENT_AT":      "1767366240",
+	})
+	at := time.Unix(1767366240, 0).Local().Format("2006-01-02 15:04 MST")
+	if got, want := inv.LastChange.Label(), "bob@laptop rotated the credentials at "+at+" (beammeup 2.1.0)"; got != want {
+		t.Fatalf("Label() = %q, want %q", got, want)
+	}
+	if (ClientChange{}).Label() != "" {
+		t.Fatal("an empty record should have no label")
+	}
+}
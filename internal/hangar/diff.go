@@ -0,0 +1,188 @@
+package hangar
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/alfaoz/beammeup/internal/ships"
+)
+
+// FieldDiff compares one setting of a saved ship profile with what the
+// hangar actually runs.
+type FieldDiff struct {
+	Field  string
+	Local  string
+	Remote string
+}
+
+func (d FieldDiff) Differs() bool { return d.Local != d.Remote }
+
+// Diff lists the ship's settings next to the live inventory, in a stable
+// order. When the profile's protocol is not deployed only that field is
+// compared, since the rest would describe a hangar that does not exist.
+func Diff(ship ships.Ship, inv Inventory) []FieldDiff {
+	protocol := strings.ToLower(strings.TrimSpace(ship.Protocol))
+	if protocol == "" {
+		protocol = "http"
+	}
+	state := inv.HTTP
+	if protocol == "socks5" {
+		state = inv.Socks5
+	}
+
+	var deployed []string
+	if inv.HTTP.Exists {
+		deployed = append(deployed, "http")
+	}
+	if inv.Socks5.Exists {
+		deployed = append(deployed, "socks5")
+	}
+	remoteProtocol := "none"
+	if len(deployed) > 0 {
+		remoteProtocol = strings.Join(deployed, "+")
+	}
+	if state.Exists {
+		remoteProtocol = protocol
+	}
+	diffs := []FieldDiff{{Field: "protocol", Local: protocol, Remote: remoteProtocol}}
+	if !state.Exists {
+		return diffs
+	}
+
+	if protocol == "http" {
+		diffs = append(diffs,
+			FieldDiff{Field: "http mode", Local: httpModeLabel(ship.HTTPMode), Remote: httpModeLabel(state.Mode)},
+			FieldDiff{Field: "cache", Local: cacheLabel(ship.CacheMB), Remote: cacheLabel(inv.HTTPCache.SizeMB)},
+			FieldDiff{Field: "anonymity", Local: AnonymityLabel(ship.Anonymity), Remote: AnonymityLabel(state.Anonymity)},
+			FieldDiff{Field: "allowed domains", Local: DomainsLabel(ship.AllowDomains), Remote: DomainsLabel(state.AllowDomains)},
+			FieldDiff{Field: "denied domains", Local: DomainsLabel(ship.DenyDomains), Remote: DomainsLabel(state.DenyDomains)},
+		)
+	}
+	port := strconv.Itoa(ship.ProxyPort)
+	diffs = append(diffs,
+		FieldDiff{Field: "port", Local: port, Remote: state.Port},
+		FieldDiff{Field: "listen local", Local: onOff(ship.ListenLocal), Remote: onOff(state.ListenLocal)},
+		FieldDiff{Field: "egress ip", Local: egressLabel(ship.EgressIP), Remote: egressLabel(state.EgressIP)},
+		FieldDiff{Field: "limits", Local: LimitsLabel(ship.MaxConns, ship.RateLimit), Remote: LimitsLabel(state.MaxConns, state.RateLimit)},
+		FieldDiff{Field: "resources", Local: ResourcesLabel(ship.MemoryMax, ship.CPUQuota, ship.TasksMax), Remote: ResourcesLabel(state.MemoryMax, state.CPUQuota, state.TasksMax)},
+	)
+
+	// ufw is the only firewall beammeup manages, and a localhost-only proxy
+	// never needs a rule.
+	if inv.UFWActive && !state.ListenLocal {
+		local := "open via ufw"
+		if ship.NoFirewallChange {
+			local = "not managed"
+		}
+		remote := "not open in ufw"
+		if slices.Contains(inv.UFWAllowedPorts, state.Port) {
+			remote = "open via ufw"
+		}
+		if ship.NoFirewallChange && remote != "open via ufw" {
+			remote = "not managed"
+		}
+		diffs = append(diffs, FieldDiff{Field: "firewall", Local: local, Remote: remote})
+	}
+
+	diffs = append(diffs,
+		FieldDiff{Field: "smart blinder", Local: blinderLabel(ship.SmartBlinder, ship.SmartBlinderIdleMinutes), Remote: blinderLabel(inv.SmartBlinder, inv.SmartBlinderIdleMinutes)},
+		FieldDiff{Field: "traffic cap", Local: capLabel(ship.TrafficCapGB), Remote: capLabel(inv.TrafficCapGB)},
+		FieldDiff{Field: "quiet hours", Local: QuietHoursLabel(ship.QuietHours), Remote: QuietHoursLabel(inv.QuietHours)},
+	)
+	return diffs
+}
+
+func httpModeLabel(mode string) string {
+	if strings.EqualFold(strings.TrimSpace(mode), "sidecar") {
+		return "sidecar"
+	}
+	return "managed"
+}
+
+func onOff(v bool) string {
+	if v {
+		return "on"
+	}
+	return "off"
+}
+
+func blinderLabel(on bool, idleMinutes int) string {
+	if !on {
+		return "off"
+	}
+	return fmt.Sprintf("on (%dm idle)", idleMinutes)
+}
+
+func egressLabel(ip string) string {
+	if strings.TrimSpace(ip) == "" {
+		return "default route"
+	}
+	return strings.TrimSpace(ip)
+}
+
+// AnonymityLabel names an HTTP header preset; empty means the default,
+// elite.
+func AnonymityLabel(preset string) string {
+	if p := strings.ToLower(strings.TrimSpace(preset)); p != "" {
+		return p
+	}
+	return "elite"
+}
+
+// DomainsLabel lists domain entries for display, "any" when there are none.
+func DomainsLabel(domains []string) string {
+	if len(domains) == 0 {
+		return "any"
+	}
+	return strings.Join(domains, ",")
+}
+
+// LimitsLabel describes a hangar's connection limits for display.
+func LimitsLabel(maxConns, ratePerMinute int) string {
+	var parts []string
+	if maxConns > 0 {
+		parts = append(parts, fmt.Sprintf("%d conns", maxConns))
+	}
+	if ratePerMinute > 0 {
+		parts = append(parts, fmt.Sprintf("%d/min", ratePerMinute))
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ResourcesLabel describes the systemd caps on a proxy unit, "none" when
+// there are none.
+func ResourcesLabel(memoryMax, cpuQuota string, tasksMax int) string {
+	var parts []string
+	if memoryMax != "" {
+		parts = append(parts, "memory "+memoryMax)
+	}
+	if cpuQuota != "" {
+		parts = append(parts, "cpu "+cpuQuota)
+	}
+	if tasksMax > 0 {
+		parts = append(parts, fmt.Sprintf("%d tasks", tasksMax))
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ", ")
+}
+
+func cacheLabel(mb int) string {
+	if mb <= 0 {
+		return "off"
+	}
+	return fmt.Sprintf("%d MB", mb)
+}
+
+func capLabel(gb int) string {
+	if gb <= 0 {
+		return "none"
+	}
+	return fmt.Sprintf("%d GB/month", gb)
+}
@@ -0,0 +1,57 @@
+package hangar
+
+import (
+	"testing"
+
+	"github.com/alfaoz/beammeup/internal/ships"
+)
+
+func TestDiffReportsDivergedFields(t *testing.T) {
+	ship := ships.Ship{Protocol: "socks5", ProxyPort: 1080, SmartBlinder: true, SmartBlinderIdleMinutes: 10}
+	inv := Inventory{
+		Socks5:                  ProtocolState{Exists: true, Port: "18080", ListenLocal: true, EgressIP: "203.0.113.9"},
+		UFWActive:               true,
+		UFWAllowedPorts:         []string{"22", "18080"},
+		SmartBlinder:            true,
+		SmartBlinderIdleMinutes: 10,
+		TrafficCapGB:            500,
+	}
+	got := map[string]FieldDiff{}
+	for _, d := range Diff(ship, inv) {
+		got[d.Field] = d
+	}
+	for _, field := range []string{"port", "listen local", "egress ip", "traffic cap"} {
+		if !got[field].Differs() {
+			t.Fatalf("expected %s to differ: %+v", field, got[field])
+		}
+	}
+	for _, field := range []string{"protocol", "smart blinder"} {
+		if got[field].Differs() {
+			t.Fatalf("expected %s to match: %+v", field, got[field])
+		}
+	}
+	if _, ok := got["firewall"]; ok {
+		t.Fatalf("firewall should not be compared for a localhost-only proxy")
+	}
+}
+
+func TestDiffMissingProtocol(t *testing.T) {
+	diffs := Diff(ships.Ship{Protocol: "http", ProxyPort: 18181}, Inventory{Socks5: ProtocolState{Exists: true, Port: "1080"}})
+	if len(diffs) != 1 || diffs[0].Remote != "socks5" || !diffs[0].Differs() {
+		t.Fatalf("unexpected diff: %+v", diffs)
+	}
+}
+
+func TestDiffFirewall(t *testing.T) {
+	ship := ships.Ship{Protocol: "http", ProxyPort: 18181}
+	inv := Inventory{HTTP: ProtocolState{Exists: true, Port: "18181"}, UFWActive: true, UFWAllowedPorts: []string{"22"}}
+	for _, d := range Diff(ship, inv) {
+		if d.Field == "firewall" {
+			if !d.Differs() || d.Remote != "not open in ufw" {
+				t.Fatalf("unexpected firewall diff: %+v", d)
+			}
+			return
+		}
+	}
+	t.Fatal("expected a firewall field")
+}
@@ -0,0 +1,61 @@
+package hangar
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// NormalizeDomains turns destination domains into Squid dstdomain entries.
+// "*.example.com" and ".example.com" match the domain and all of its
+// subdomains; a bare name matches only itself. Duplicates, and bare names a
+// wildcard already covers, are dropped.
+func NormalizeDomains(items []string) ([]string, error) {
+	wild := map[string]bool{}
+	var names []string
+	for _, raw := range items {
+		d := strings.ToLower(strings.TrimSpace(raw))
+		if d == "" {
+			continue
+		}
+		d = strings.TrimPrefix(d, "*")
+		isWild := strings.HasPrefix(d, ".")
+		name := strings.TrimSuffix(strings.TrimPrefix(d, "."), ".")
+		if !validDomain(name) {
+			return nil, fmt.Errorf("invalid domain %q", raw)
+		}
+		if isWild {
+			wild[name] = true
+		}
+		names = append(names, name)
+	}
+
+	var out []string
+	for _, name := range names {
+		entry := name
+		if wild[name] {
+			entry = "." + name
+		}
+		if !slices.Contains(out, entry) {
+			out = append(out, entry)
+		}
+	}
+	return out, nil
+}
+
+func validDomain(name string) bool {
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !((r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
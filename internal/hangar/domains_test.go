@@ -0,0 +1,22 @@
+package hangar
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNormalizeDomains(t *testing.T) {
+	got, err := NormalizeDomains([]string{"Example.com", "*.example.com", " api.example.org ", "", ".test.invalid.", "api.example.org"})
+	if err != nil {
+		t.Fatalf("NormalizeDomains: %v", err)
+	}
+	want := []string{".example.com", "api.example.org", ".test.invalid"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("NormalizeDomains = %v, want %v", got, want)
+	}
+	for _, bad := range []string{"exa mple.com", "-bad.com", "a..b", "http://example.com", "*"} {
+		if _, err := NormalizeDomains([]string{bad}); err == nil {
+			t.Fatalf("%q should be rejected", bad)
+		}
+	}
+}
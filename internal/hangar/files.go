@@ -0,0 +1,60 @@
+package hangar
+
+import (
+	"path"
+	"strings"
+)
+
+// ManagedFile is a file beammeup writes on the server, addressable by a short
+// name in commands like `beammeup fetch`.
+type ManagedFile struct {
+	Name   string
+	Path   string
+	Secret bool // holds proxy credentials
+}
+
+// ManagedFiles lists the files worth looking at when debugging a hangar.
+var ManagedFiles = []ManagedFile{
+	{Name: "hangar.json", Path: remoteStateDir + "/hangar.json"},
+	{Name: "microsocks.env", Path: remoteStateDir + "/microsocks.env", Secret: true},
+	{Name: "http.env", Path: remoteStateDir + "/http.env", Secret: true},
+	{Name: "squid.conf", Path: "/etc/squid/squid.conf"},
+	{Name: "squid-access.log", Path: "/var/log/squid/access.log"},
+	{Name: "squid-cache.log", Path: "/var/log/squid/cache.log"},
+	{Name: "sidecar.conf", Path: remoteStateDir + "/http-sidecar/squid.conf"},
+	{Name: "sidecar-access.log", Path: "/var/log/beammeup-http/access.log"},
+	{Name: "sidecar-cache.log", Path: "/var/log/beammeup-http/cache.log"},
+	{Name: "smart-blinder.env", Path: remoteStateDir + "/smart-blinder.env"},
+	{Name: "traffic-cap.env", Path: remoteStateDir + "/traffic-cap.env"},
+	{Name: "ttl.env", Path: remoteStateDir + "/ttl.env"},
+	{Name: "install.log", Path: "/tmp/beammeup-install.log"},
+}
+
+// ManagedUnits are the systemd units beammeup installs, plus the system
+// Squid it may manage.
+var ManagedUnits = []string{
+	"beammeup-microsocks.service",
+	"beammeup-http-sidecar.service",
+	"squid.service",
+	"beammeup-smart-blinder.service",
+	"beammeup-smart-blinder.timer",
+	"beammeup-traffic-cap.service",
+	"beammeup-traffic-cap.timer",
+	"beammeup-ttl.service",
+	"beammeup-ttl.timer",
+}
+
+// ResolveManagedFile maps a ManagedFiles name to its path on the server.
+// tmpDir replaces /tmp as with Service.RemoteTmpDir.
+func ResolveManagedFile(name, tmpDir string) (ManagedFile, bool) {
+	for _, f := range ManagedFiles {
+		if f.Name != name {
+			continue
+		}
+		if dir := strings.TrimSpace(tmpDir); dir != "" && strings.HasPrefix(f.Path, "/tmp/") {
+			f.Path = path.Join(dir, strings.TrimPrefix(f.Path, "/tmp/"))
+		}
+		return f, true
+	}
+	return ManagedFile{}, false
+}
@@ -0,0 +1,16 @@
+package hangar
+
+import "testing"
+
+func TestResolveManagedFile(t *testing.T) {
+	f, ok := ResolveManagedFile("http.env", "")
+	if !ok || f.Path != "/etc/beammeup/http.env" || !f.Secret {
+		t.Fatalf("unexpected http.env: %+v ok=%v", f, ok)
+	}
+	if f, _ := ResolveManagedFile("install.log", "/var/tmp/bm/"); f.Path != "/var/tmp/bm/beammeup-install.log" {
+		t.Fatalf("install.log should follow the remote tmpdir, got %q", f.Path)
+	}
+	if _, ok := ResolveManagedFile("/etc/passwd", ""); ok {
+		t.Fatal("paths are not shortcuts")
+	}
+}
@@ -0,0 +1,187 @@
+package hangar
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/alfaoz/beammeup/internal/remote"
+	"github.com/alfaoz/beammeup/internal/ships"
+	"github.com/alfaoz/beammeup/internal/sshx"
+)
+
+// signMetadata signs hangar.json with an HMAC key that never leaves the
+// client, so a mismatch means something other than beammeup rewrote it. The
+// signature leads with an ID of the key, so a file another client signed
+// can be told apart from one that was edited.
+func signMetadata(key, content []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(content)
+	return metadataKeyID(key) + ":" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// metadataKeyID names a signing key without revealing it.
+func metadataKeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// metadataKeyName is the key file for the server a ship points at. Keys
+// follow the server rather than the ship, so every ship on one server signs
+// and checks with the same key.
+func metadataKeyName(ship ships.Ship) string {
+	port := ship.SSHPort
+	if port == 0 {
+		port = 22
+	}
+	addr := net.JoinHostPort(strings.ToLower(strings.TrimSpace(ship.Host)), strconv.Itoa(port))
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '.' || r == '-' {
+			return r
+		}
+		return '_'
+	}, addr) + ".key"
+}
+
+// metadataKey loads the signing key for the ship's server. A missing key is
+// created when create is set and reported as nil otherwise; created tells
+// the caller there is nothing to verify against yet. A key from before keys
+// followed the server, stored under the ship name, is moved over.
+func (s *Service) metadataKey(ship ships.Ship, create bool) (key []byte, created bool, err error) {
+	path := filepath.Join(s.MetadataKeyDir, metadataKeyName(ship))
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) && strings.TrimSpace(ship.Name) != "" {
+		legacy := filepath.Join(s.MetadataKeyDir, ships.SanitizeName(ship.Name)+".key")
+		if err := os.Rename(legacy, path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, false, fmt.Errorf("move metadata key: %w", err)
+		}
+	}
+
+	b, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		key, err = hex.DecodeString(strings.TrimSpace(string(b)))
+		if err != nil || len(key) != 32 {
+			return nil, false, fmt.Errorf("invalid metadata key %s", path)
+		}
+		return key, false, nil
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, false, fmt.Errorf("read metadata key: %w", err)
+	case !create:
+		return nil, false, nil
+	}
+
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, false, fmt.Errorf("generate metadata key: %w", err)
+	}
+	if err := os.MkdirAll(s.MetadataKeyDir, 0o700); err != nil {
+		return nil, false, fmt.Errorf("create metadata key dir: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0o600); err != nil {
+		return nil, false, fmt.Errorf("write metadata key: %w", err)
+	}
+	return key, true, nil
+}
+
+// checkMetadata verifies hangar.json as the inventory found it and returns
+// StatusTampered or StatusUnverified when it does not check out. Once a key
+// exists for the server, a configured hangar without hangar.json or its
+// signature is tampered too. Unless the inventory was read-only or the check
+// failed, it then signs the refreshed file the script left behind.
+func (s *Service) checkMetadata(ctx context.Context, target sshx.Target, ship ships.Ship, kv remote.KeyValues, readOnly bool) (Status, error) {
+	if s.MetadataKeyDir == "" {
+		return "", nil
+	}
+	key, created, err := s.metadataKey(ship, !readOnly)
+	if err != nil || key == nil {
+		return "", err
+	}
+	if !created {
+		if status := verifyMetadata(key, kv); status != "" {
+			// Leave the file unsigned so the status sticks until the next
+			// apply rewrites it.
+			return status, nil
+		}
+	}
+	if readOnly {
+		return "", nil
+	}
+	return "", s.storeMetadataSignature(ctx, target, key, kv)
+}
+
+// verifyMetadata checks hangar.json as the inventory reported it against
+// key. A signature by another key is unverified rather than tampered: it
+// may come from another machine managing the same server.
+func verifyMetadata(key []byte, kv remote.KeyValues) Status {
+	found := kv.Get("BM_METADATA_B64")
+	if found == "" {
+		if status := Status(strings.TrimSpace(kv.Get("BM_HANGAR_STATUS"))); status == "" || status == StatusMissing {
+			return ""
+		}
+		return StatusTampered
+	}
+	content, err := base64.StdEncoding.DecodeString(found)
+	if err != nil {
+		return StatusTampered
+	}
+	sig := strings.TrimSpace(kv.Get("BM_METADATA_SIG"))
+	if hmac.Equal([]byte(signMetadata(key, content)), []byte(sig)) {
+		return ""
+	}
+	id, _, ok := strings.Cut(sig, ":")
+	if sig == "" || ok && id == metadataKeyID(key) {
+		return StatusTampered
+	}
+	// Another key, or a signature from before they carried an ID.
+	return StatusUnverified
+}
+
+// storeMetadataSignature signs the hangar.json content the script reported
+// writing, if any, and stores the signature next to it.
+func (s *Service) storeMetadataSignature(ctx context.Context, target sshx.Target, key []byte, kv remote.KeyValues) error {
+	written := kv.Get("BM_METADATA_WRITTEN_B64")
+	if written == "" {
+		return nil
+	}
+	content, err := base64.StdEncoding.DecodeString(written)
+	if err != nil {
+		return fmt.Errorf("decode hangar metadata: %w", err)
+	}
+	sum := sha256.Sum256(content)
+	in := ActionInput{Mode: "sign", MetadataSignature: signMetadata(key, content), MetadataSHA256: hex.EncodeToString(sum[:])}
+	out, _, err := s.runRemote(ctx, target, in)
+	if err != nil {
+		return err
+	}
+	if !out.Bool("BM_METADATA_SIGNED") {
+		return errors.New("server did not confirm the signature")
+	}
+	return nil
+}
+
+// MaxHangarInfoLen is the longest label or description hangar.json takes.
+const MaxHangarInfoLen = 200
+
+// CheckHangarInfo accepts a hangar label or description: one line of text
+// of at most MaxHangarInfoLen characters.
+func CheckHangarInfo(s string) error {
+	if strings.IndexFunc(s, unicode.IsControl) >= 0 {
+		return errors.New("must be a single line of text")
+	}
+	if n := utf8.RuneCountInString(s); n > MaxHangarInfoLen {
+		return fmt.Errorf("is %d characters long (at most %d)", n, MaxHangarInfoLen)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package hangar
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alfaoz/beammeup/internal/remote"
+	"github.com/alfaoz/beammeup/internal/ships"
+	"github.com/alfaoz/beammeup/internal/sshx"
+)
+
+// fakeMetadataServer keeps hangar.json and its signature the way the remote
+// script does.
+type fakeMetadataServer struct {
+	meta, sig string
+	signs     int
+}
+
+func (f *fakeMetadataServer) run(_ sshx.Target, in ActionInput) (remote.KeyValues, string, error) {
+	kv := remote.KeyValues{}
+	switch in.Mode {
+	case "inventory":
+		kv["BM_PUBLIC_IP"] = "203.0.113.10"
+		kv["BM_SOCKS_EXISTS"] = "1"
+		kv["BM_HANGAR_STATUS"] = "online"
+		if f.meta != "" {
+			kv["BM_METADATA_B64"] = base64.StdEncoding.EncodeToString([]byte(f.meta))
+			kv["BM_METADATA_SIG"] = f.sig
+		}
+		if !in.ReadOnly {
+			f.meta, f.sig = `{"status":"online"}`, ""
+			kv["BM_METADATA_WRITTEN_B64"] = base64.StdEncoding.EncodeToString([]byte(f.meta))
+		}
+	case "sign":
+		sum := sha256.Sum256([]byte(f.meta))
+		if in.MetadataSHA256 == hex.EncodeToString(sum[:]) {
+			f.sig = in.MetadataSignature
+			f.signs++
+			kv["BM_METADATA_SIGNED"] = "1"
+		}
+	}
+	return kv, "", nil
+}
+
+func TestMetadataSignatureDetectsTampering(t *testing.T) {
+	server := &fakeMetadataServer{meta: `{"status":"drift"}`}
+	svc := NewService()
+	svc.MetadataKeyDir = t.TempDir()
+	svc.runRemoteFn = server.run
+	ship := ships.Ship{Name: "edge", Host: "203.0.113.10", SSHUser: "root", SSHPort: 22}
+
+	// First contact trusts the unsigned file and signs the refreshed one.
+	inv, err := svc.Inventory(ship, "pw")
+	if err != nil || inv.HangarStatus != StatusOnline || server.signs != 1 {
+		t.Fatalf("first inventory: status=%q signs=%d err=%v", inv.HangarStatus, server.signs, err)
+	}
+	inv, err = svc.Inventory(ship, "pw")
+	if err != nil || inv.HangarStatus != StatusOnline || server.signs != 2 {
+		t.Fatalf("second inventory: status=%q signs=%d err=%v", inv.HangarStatus, server.signs, err)
+	}
+
+	server.meta = `{"status":"online","notes":"edited by hand"}`
+	inv, err = svc.AuditInventory(ship, "pw")
+	if err != nil || inv.HangarStatus != StatusTampered {
+		t.Fatalf("expected tampered on audit, got %q err=%v", inv.HangarStatus, err)
+	}
+	inv, err = svc.Inventory(ship, "pw")
+	if err != nil || inv.HangarStatus != StatusTampered || server.signs != 2 {
+		t.Fatalf("expected tampered without re-signing, got %q signs=%d err=%v", inv.HangarStatus, server.signs, err)
+	}
+	if inv, _ = svc.Inventory(ship, "pw"); inv.HangarStatus != StatusTampered {
+		t.Fatalf("tampered status should persist until apply, got %q", inv.HangarStatus)
+	}
+}
+
+func TestMetadataKeyFollowsServer(t *testing.T) {
+	server := &fakeMetadataServer{}
+	svc := NewService()
+	svc.MetadataKeyDir = t.TempDir()
+	svc.runRemoteFn = server.run
+	edge := ships.Ship{Name: "edge", Host: "203.0.113.10", SSHUser: "root", SSHPort: 22}
+	other := edge
+	other.Name = "edge-http"
+
+	if _, err := svc.Inventory(edge, "pw"); err != nil {
+		t.Fatal(err)
+	}
+	if inv, err := svc.Inventory(other, "pw"); err != nil || inv.HangarStatus != StatusOnline {
+		t.Fatalf("a second ship on the same server should share the key, got %q err=%v", inv.HangarStatus, err)
+	}
+	if name := metadataKeyName(ships.Ship{Host: "2001:db8::1", SSHPort: 2222}); name != "_2001_db8__1__2222.key" {
+		t.Fatalf("metadataKeyName = %q", name)
+	}
+
+	// A key saved under the ship name by an older version moves over.
+	svc.MetadataKeyDir = t.TempDir()
+	legacy := strings.Repeat("ab", 32) + "\n"
+	if err := os.WriteFile(filepath.Join(svc.MetadataKeyDir, "edge.key"), []byte(legacy), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if key, created, err := svc.metadataKey(edge, false); err != nil || created || hex.EncodeToString(key) != strings.TrimSpace(legacy) {
+		t.Fatalf("legacy key not picked up: %x %v %v", key, created, err)
+	}
+	if _, err := os.Stat(filepath.Join(svc.MetadataKeyDir, "203.0.113.10_22.key")); err != nil {
+		t.Fatalf("legacy key not moved: %v", err)
+	}
+}
+
+func TestMetadataUnverifiedAndMissing(t *testing.T) {
+	server := &fakeMetadataServer{}
+	svc := NewService()
+	svc.MetadataKeyDir = t.TempDir()
+	svc.runRemoteFn = server.run
+	ship := ships.Ship{Name: "edge", Host: "203.0.113.10", SSHUser: "root", SSHPort: 22}
+	if _, err := svc.Inventory(ship, "pw"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another machine's key signed the current file.
+	server.sig = signMetadata([]byte("0123456789abcdef0123456789abcdef"), []byte(server.meta))
+	if inv, err := svc.AuditInventory(ship, "pw"); err != nil || inv.HangarStatus != StatusUnverified {
+		t.Fatalf("expected unverified, got %q err=%v", inv.HangarStatus, err)
+	}
+
+	server.meta, server.sig = "", ""
+	if inv, err := svc.AuditInventory(ship, "pw"); err != nil || inv.HangarStatus != StatusTampered {
+		t.Fatalf("a configured hangar without hangar.json should be tampered, got %q err=%v", inv.HangarStatus, err)
+	}
+}
@@ -0,0 +1,87 @@
+package hangar
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ListenPort is a socket listening on the server, as reported by ss.
+type ListenPort struct {
+	Proto   string // "tcp" or "udp"
+	Port    int
+	Process string // empty when ss could not tell
+	Addr    string // "*", "0.0.0.0" and "::" mean every address
+}
+
+// knownProcesses are the listeners a beammeup server is expected to run:
+// SSH itself, the HTTP and SOCKS5 proxies, and systemd holding the SOCKS5
+// port for on-demand starts.
+var knownProcesses = map[string]bool{"sshd": true, "squid": true, "microsocks": true, "systemd": true}
+
+// Loopback reports whether only the server itself can reach p.
+func (p ListenPort) Loopback() bool {
+	if p.Addr == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(p.Addr)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Known reports whether p belongs to SSH or a beammeup proxy.
+func (p ListenPort) Known() bool {
+	return knownProcesses[p.Process]
+}
+
+// Label renders p as "tcp/22 sshd (0.0.0.0)".
+func (p ListenPort) Label() string {
+	process := p.Process
+	if process == "" {
+		process = "?"
+	}
+	return p.Proto + "/" + strconv.Itoa(p.Port) + " " + process + " (" + p.Addr + ")"
+}
+
+// PortInUse returns the TCP listener on port, if any.
+func (inv Inventory) PortInUse(port int) (ListenPort, bool) {
+	for _, p := range inv.Listening {
+		if p.Proto == "tcp" && p.Port == port {
+			return p, true
+		}
+	}
+	return ListenPort{}, false
+}
+
+// Unexpected lists the listeners reachable from outside that are neither SSH
+// nor a beammeup proxy.
+func (inv Inventory) Unexpected() []ListenPort {
+	var out []ListenPort
+	for _, p := range inv.Listening {
+		if !p.Known() && !p.Loopback() {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// parseListening reads the "proto:port:process:address" list from the
+// inventory. The address comes last since IPv6 ones contain colons.
+func parseListening(v string) []ListenPort {
+	var out []ListenPort
+	for _, f := range strings.Fields(v) {
+		parts := strings.SplitN(f, ":", 4)
+		if len(parts) != 4 {
+			continue
+		}
+		port, err := strconv.Atoi(parts[1])
+		if err != nil || port <= 0 {
+			continue
+		}
+		p := ListenPort{Proto: parts[0], Port: port, Process: parts[2], Addr: parts[3]}
+		if p.Process == "-" {
+			p.Process = ""
+		}
+		out = append(out, p)
+	}
+	return out
+}
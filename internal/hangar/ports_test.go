@@ -0,0 +1,32 @@
+package hangar
+
+import (
+	"testing"
+
+	"github.com/alfaoz/beammeup/internal/remote"
+)
+
+func TestInventoryListening(t *testing.T) {
+	inv := parseInventory(remote.KeyValues{
+		"BM_LISTEN_PORTS": "tcp:22:sshd:0.0.0.0 tcp:22:sshd::: udp:53:systemd-resolve:127.0.0.53 tcp:631:-:::1 tcp:3306:mysqld:* tcp:18181:squid:* bogus tcp:x:y:z",
+	})
+	if len(inv.Listening) != 6 {
+		t.Fatalf("got %d listeners: %+v", len(inv.Listening), inv.Listening)
+	}
+	if p := inv.Listening[1]; p.Addr != "::" || p.Process != "sshd" {
+		t.Fatalf("ipv6 listener parsed as %+v", p)
+	}
+	if p := inv.Listening[3]; p.Process != "" || !p.Loopback() || p.Label() != "tcp/631 ? (::1)" {
+		t.Fatalf("unnamed loopback listener parsed as %+v", p)
+	}
+	if p, ok := inv.PortInUse(18181); !ok || p.Process != "squid" {
+		t.Fatalf("18181 should be held by squid, got %+v %v", p, ok)
+	}
+	if _, ok := inv.PortInUse(53); ok {
+		t.Fatal("a UDP listener should not hold a TCP port")
+	}
+	odd := inv.Unexpected()
+	if len(odd) != 1 || odd[0].Port != 3306 {
+		t.Fatalf("unexpected listeners: %+v", odd)
+	}
+}
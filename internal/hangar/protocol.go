@@ -0,0 +1,203 @@
+package hangar
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alfaoz/beammeup/internal/remote"
+)
+
+// Protocol is one kind of proxy a hangar can run. The remote script still
+// does the work on the server; a Protocol owns the script arguments that
+// only it understands and its part of the inventory, so adding one means an
+// implementation here plus its functions in the script, without touching
+// the shared flow.
+type Protocol interface {
+	// Name is the --protocol value, e.g. "http".
+	Name() string
+	DefaultPort() int
+
+	// Preflight, Apply, Show and Destroy return the protocol-specific script
+	// arguments for in, on top of the shared ones scriptArgs adds.
+	Preflight(in ActionInput) []string
+	Apply(in ActionInput) []string
+	Show(in ActionInput) []string
+	Destroy(in ActionInput) []string
+
+	// ParseInventory reads the protocol's state from inventory output.
+	ParseInventory(kv remote.KeyValues) ProtocolState
+}
+
+var protocols = map[string]Protocol{}
+
+// RegisterProtocol makes p available under p.Name(). It panics on a
+// duplicate name, like the other registries in the standard library.
+func RegisterProtocol(p Protocol) {
+	name := p.Name()
+	if _, dup := protocols[name]; dup {
+		panic("hangar: protocol registered twice: " + name)
+	}
+	protocols[name] = p
+}
+
+// LookupProtocol returns the protocol registered as name. An empty name is
+// the HTTP proxy, matching the script's default.
+func LookupProtocol(name string) (Protocol, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		name = "http"
+	}
+	p, ok := protocols[name]
+	return p, ok
+}
+
+// Protocols lists the registered protocol names, sorted.
+func Protocols() []string {
+	names := make([]string, 0, len(protocols))
+	for name := range protocols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// protocolArgs dispatches in.Mode to the protocol's hook.
+func protocolArgs(in ActionInput) []string {
+	p, ok := LookupProtocol(in.Protocol)
+	if !ok {
+		return nil
+	}
+	switch in.Mode {
+	case "preflight":
+		return p.Preflight(in)
+	case "apply":
+		return p.Apply(in)
+	case "show":
+		return p.Show(in)
+	case "destroy":
+		return p.Destroy(in)
+	}
+	return nil
+}
+
+func init() {
+	RegisterProtocol(socks5Protocol{})
+	RegisterProtocol(httpProtocol{})
+}
+
+// socks5Protocol is microsocks, always on or socket activated.
+type socks5Protocol struct{}
+
+func (socks5Protocol) Name() string                   { return "socks5" }
+func (socks5Protocol) DefaultPort() int               { return 1080 }
+func (socks5Protocol) Preflight(ActionInput) []string { return nil }
+func (socks5Protocol) Show(ActionInput) []string      { return nil }
+func (socks5Protocol) Destroy(ActionInput) []string   { return nil }
+
+func (socks5Protocol) Apply(in ActionInput) []string {
+	var args []string
+	if in.SocketActivation {
+		args = append(args, "--socket-activation")
+	}
+	if len(in.MicrosocksBinary) > 0 {
+		// The script only installs the upload if it still has this checksum.
+		sum := sha256.Sum256(in.MicrosocksBinary)
+		args = append(args, "--microsocks-sha256", hex.EncodeToString(sum[:]))
+	}
+	return args
+}
+
+func (socks5Protocol) ParseInventory(kv remote.KeyValues) ProtocolState {
+	return ProtocolState{
+		Exists: kv.Bool("BM_SOCKS_EXISTS"),
+		Active: kv.Bool("BM_SOCKS_ACTIVE"),
+		Port:   kv.Get("BM_SOCKS_PORT"),
+		User:   kv.Get("BM_SOCKS_USER"),
+		Pass:   kv.Get("BM_SOCKS_PASS"),
+		Mode:   kv.Get("BM_SOCKS_MODE"),
+
+		ListenLocal: kv.Bool("BM_SOCKS_LISTEN_LOCAL"),
+		EgressIP:    kv.Get("BM_SOCKS_EGRESS_IP"),
+		MaxConns:    kv.Int("BM_SOCKS_MAX_CONNS"),
+		RateLimit:   kv.Int("BM_SOCKS_RATE_LIMIT"),
+		MemoryMax:   uncapped(kv.Get("BM_SOCKS_MEMORY_MAX")),
+		CPUQuota:    uncapped(kv.Get("BM_SOCKS_CPU_QUOTA")),
+		TasksMax:    kv.Int("BM_SOCKS_TASKS_MAX"),
+
+		SocketActivation: kv.Bool("BM_SOCKS_SOCKET_ACTIVATION"),
+	}
+}
+
+// httpProtocol is Squid, either the system install (managed) or a sidecar.
+type httpProtocol struct{}
+
+func (httpProtocol) Name() string                   { return "http" }
+func (httpProtocol) DefaultPort() int               { return 18181 }
+func (httpProtocol) Preflight(ActionInput) []string { return nil }
+func (httpProtocol) Show(ActionInput) []string      { return nil }
+func (httpProtocol) Destroy(ActionInput) []string   { return nil }
+
+func (httpProtocol) Apply(in ActionInput) []string {
+	var args []string
+	if v := strings.TrimSpace(in.Anonymity); v != "" {
+		args = append(args, "--anonymity", v)
+	}
+	if len(in.AllowDomains) > 0 {
+		args = append(args, "--allow-domains", strings.Join(in.AllowDomains, ","))
+	}
+	if len(in.DenyDomains) > 0 {
+		args = append(args, "--deny-domains", strings.Join(in.DenyDomains, ","))
+	}
+	if in.CacheMB > 0 {
+		args = append(args, "--cache-mb", fmt.Sprintf("%d", in.CacheMB))
+		if dir := strings.TrimSpace(in.CacheDir); dir != "" {
+			args = append(args, "--cache-dir", dir)
+		}
+	}
+	if in.RotateCredentials && in.RotateGrace > 0 {
+		args = append(args, "--rotate-grace-seconds", fmt.Sprintf("%d", int64(in.RotateGrace.Round(time.Second)/time.Second)))
+	}
+	return args
+}
+
+func (httpProtocol) ParseInventory(kv remote.KeyValues) ProtocolState {
+	return ProtocolState{
+		Exists:  kv.Bool("BM_HTTP_EXISTS"),
+		Active:  kv.Bool("BM_HTTP_ACTIVE"),
+		Port:    kv.Get("BM_HTTP_PORT"),
+		User:    kv.Get("BM_HTTP_USER"),
+		Pass:    kv.Get("BM_HTTP_PASS"),
+		Mode:    kv.Get("BM_HTTP_MODE"),
+		Managed: kv.Bool("BM_HTTP_MANAGED"),
+		Legacy:  kv.Bool("BM_HTTP_LEGACY"),
+
+		ListenLocal: kv.Bool("BM_HTTP_LISTEN_LOCAL"),
+		EgressIP:    kv.Get("BM_HTTP_EGRESS_IP"),
+		Anonymity:   kv.Get("BM_HTTP_ANONYMITY"),
+		MaxConns:    kv.Int("BM_HTTP_MAX_CONNS"),
+		RateLimit:   kv.Int("BM_HTTP_RATE_LIMIT"),
+		MemoryMax:   uncapped(kv.Get("BM_HTTP_MEMORY_MAX")),
+		CPUQuota:    uncapped(kv.Get("BM_HTTP_CPU_QUOTA")),
+		TasksMax:    kv.Int("BM_HTTP_TASKS_MAX"),
+
+		SquidVersion: kv.Get("BM_HTTP_SQUID_VERSION"),
+
+		AllowDomains: splitCSV(kv.Get("BM_HTTP_ALLOW_DOMAINS")),
+		DenyDomains:  splitCSV(kv.Get("BM_HTTP_DENY_DOMAINS")),
+
+		GraceUser:  kv.Get("BM_HTTP_GRACE_USER"),
+		GraceUntil: parseEpoch(kv.Get("BM_HTTP_GRACE_EXPIRES_AT")),
+	}
+}
+
+// uncapped maps the script's "0" for a missing systemd cap to "".
+func uncapped(v string) string {
+	if v = strings.TrimSpace(v); v == "0" {
+		return ""
+	}
+	return v
+}
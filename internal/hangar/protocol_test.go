@@ -0,0 +1,28 @@
+package hangar
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/alfaoz/beammeup/internal/remote"
+)
+
+func TestProtocolRegistry(t *testing.T) {
+	if got := Protocols(); !slices.Equal(got, []string{"http", "socks5"}) {
+		t.Fatalf("Protocols() = %v", got)
+	}
+	p, ok := LookupProtocol("")
+	if !ok || p.Name() != "http" || p.DefaultPort() != 18181 {
+		t.Fatalf("empty protocol should be HTTP, got %v %v", p, ok)
+	}
+	if _, ok := LookupProtocol("wireguard"); ok {
+		t.Fatalf("unregistered protocol found")
+	}
+	st := protocols["socks5"].ParseInventory(remote.KeyValues{"BM_SOCKS_EXISTS": "1", "BM_SOCKS_PORT": "1080", "BM_HTTP_PORT": "3128"})
+	if !st.Exists || st.Port != "1080" {
+		t.Fatalf("unexpected SOCKS5 state: %+v", st)
+	}
+	if args := protocols["socks5"].Apply(ActionInput{Anonymity: "strict", CacheMB: 64}); len(args) != 0 {
+		t.Fatalf("SOCKS5 should ignore HTTP settings, got %v", args)
+	}
+}
@@ -0,0 +1,55 @@
+package hangar
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NormalizeQuietHours checks a comma list of HH:MM-HH:MM windows (UTC) and
+// returns it zero-padded, the form the server script expects. A window may
+// wrap past midnight, e.g. 22:00-06:00.
+func NormalizeQuietHours(spec string) (string, error) {
+	var windows []string
+	for _, raw := range strings.Split(spec, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(raw, "-")
+		if !ok {
+			return "", fmt.Errorf("invalid window %q (use HH:MM-HH:MM)", raw)
+		}
+		start, err := parseClock(from)
+		if err != nil {
+			return "", fmt.Errorf("invalid window %q: %w", raw, err)
+		}
+		end, err := parseClock(to)
+		if err != nil {
+			return "", fmt.Errorf("invalid window %q: %w", raw, err)
+		}
+		if start == end {
+			return "", fmt.Errorf("invalid window %q: start and end are the same", raw)
+		}
+		windows = append(windows, start+"-"+end)
+	}
+	if len(windows) == 0 {
+		return "", fmt.Errorf("no quiet hours given")
+	}
+	return strings.Join(windows, ","), nil
+}
+
+func parseClock(s string) (string, error) {
+	var h, m int
+	if n, err := fmt.Sscanf(strings.TrimSpace(s)+"\n", "%d:%d\n", &h, &m); err != nil || n != 2 || h < 0 || h > 23 || m < 0 || m > 59 {
+		return "", fmt.Errorf("%q is not a time of day", strings.TrimSpace(s))
+	}
+	return fmt.Sprintf("%02d:%02d", h, m), nil
+}
+
+// QuietHoursLabel describes a schedule for display.
+func QuietHoursLabel(spec string) string {
+	if spec == "" {
+		return "none"
+	}
+	return spec + " UTC"
+}
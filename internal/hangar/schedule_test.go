@@ -0,0 +1,18 @@
+package hangar
+
+import "testing"
+
+func TestNormalizeQuietHours(t *testing.T) {
+	got, err := NormalizeQuietHours(" 0:00-6:00, 22:30-01:15 ")
+	if err != nil {
+		t.Fatalf("NormalizeQuietHours: %v", err)
+	}
+	if got != "00:00-06:00,22:30-01:15" {
+		t.Fatalf("NormalizeQuietHours = %q", got)
+	}
+	for _, bad := range []string{"", "00:00", "24:00-06:00", "00:60-06:00", "06:00-06:00", "a:b-c:d", "00:00-06:00x"} {
+		if _, err := NormalizeQuietHours(bad); err == nil {
+			t.Fatalf("%q should be rejected", bad)
+		}
+	}
+}
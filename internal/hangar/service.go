@@ -2,14 +2,22 @@ package hangar
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/alfaoz/beammeup/internal/geoip"
 	"github.com/alfaoz/beammeup/internal/remote"
 	"github.com/alfaoz/beammeup/internal/ships"
 	"github.com/alfaoz/beammeup/internal/sshx"
+	"github.com/alfaoz/beammeup/internal/version"
 )
 
 type Status string
@@ -19,37 +27,200 @@ const (
 	StatusMissing Status = "missing"
 	StatusDrift   Status = "drift"
 	StatusBlinded Status = "blinded"
+	// StatusQuotaExceeded means the server-side traffic cap paused the proxy
+	// for the rest of the month.
+	StatusQuotaExceeded Status = "quota-exceeded"
+	// StatusQuietHours means the proxy is stopped for a scheduled quiet
+	// window and starts again when it ends.
+	StatusQuietHours Status = "quiet-hours"
+	// StatusTampered means hangar.json no longer matches the signature this
+	// client stored, i.e. something else modified beammeup's state.
+	StatusTampered Status = "metadata-tampered"
+	// StatusUnverified means hangar.json carries a signature from a key this
+	// client does not have, e.g. another machine managing the same server.
+	StatusUnverified Status = "metadata-unverified"
 )
 
 type ProtocolState struct {
-	Exists  bool
-	Active  bool
-	Port    string
-	User    string
-	Pass    string
-	Mode    string
-	Managed bool
-	Legacy  bool
+	Exists      bool
+	Active      bool
+	Port        string
+	User        string
+	Pass        string
+	Mode        string
+	Managed     bool
+	Legacy      bool
+	ListenLocal bool
+	EgressIP    string // empty when outbound traffic uses the default route
+	Anonymity   string // HTTP only; header preset, empty on hangars set up before presets existed
+	MaxConns    int    // concurrent connection limit, 0 when unlimited
+	RateLimit   int    // new connections per minute, 0 when unlimited
+	MemoryMax   string // systemd MemoryMax of the proxy unit, empty when uncapped
+	CPUQuota    string // systemd CPUQuota, e.g. "50%", empty when uncapped
+	TasksMax    int    // systemd TasksMax, 0 when uncapped
+
+	// SquidVersion is HTTP only: the installed Squid, e.g. "5.7", empty when
+	// Squid is not installed.
+	SquidVersion string
+
+	// SocketActivation is SOCKS5 only: systemd holds the port and starts
+	// microsocks while clients are connected.
+	SocketActivation bool
+
+	AllowDomains []string // HTTP only
+	DenyDomains  []string // HTTP only
+
+	// GraceUser is the pre-rotation HTTP user still accepted until
+	// GraceUntil; empty when no rotation overlap is running.
+	GraceUser  string
+	GraceUntil time.Time
+}
+
+// HTTPInstance is a named HTTP sidecar sharing the server with the default
+// hangar.
+type HTTPInstance struct {
+	Name   string
+	Port   string
+	Active bool
+}
+
+// CacheStats describes the HTTP hangar's disk cache. SizeMB is 0 when
+// caching is off. Hits and Requests count plain HTTP requests in the current
+// access log; HTTPS is tunnelled and never cached.
+type CacheStats struct {
+	SizeMB    int
+	Dir       string
+	UsedBytes uint64
+	Hits      int
+	Requests  int
 }
 
 type Inventory struct {
 	PublicIP       string
 	Socks5         ProtocolState
-	HTTP           ProtocolState
+	HTTP           ProtocolState // the ship's instance when it names one
+	Instance       string        // instance HTTP describes; empty for the default hangar
+	HTTPInstances  []HTTPInstance
+	HTTPCache      CacheStats
 	HangarStatus   Status
 	MetadataExists bool
+	TrafficCapGB   int
+	QuietHours     string    // UTC windows, empty when no schedule is set
+	ExpiresAt      time.Time // zero unless a TTL is scheduled
+	Label          string    // what the hangar is called in hangar.json, e.g. "marketing team proxy"
+	Description    string    // free text about the hangar, e.g. who to contact
+	LastChange     ClientChange
+
+	UFWActive               bool
+	UFWAllowedPorts         []string // TCP ports with a ufw ALLOW rule
+	SmartBlinder            bool
+	SmartBlinderIdleMinutes int
+	SmartBlinderKeepalive   time.Time // last touch by a client's tunnel or forward; zero if none
+
+	Listening []ListenPort // every listening TCP and UDP socket, by port
+
+	Host   ships.HostInfo // CheckedAt is when this inventory ran
+	GeoErr error          // why the GeoIP lookup of PublicIP failed, if it did
+}
+
+// KeptAlive reports whether a client's recent keepalive, rather than proxy
+// traffic, is holding the smart blinder off at now.
+func (inv Inventory) KeptAlive(now time.Time) bool {
+	if !inv.SmartBlinder || inv.SmartBlinderKeepalive.IsZero() {
+		return false
+	}
+	return now.Sub(inv.SmartBlinderKeepalive) < time.Duration(inv.SmartBlinderIdleMinutes)*time.Minute
+}
+
+// Usage is the raw traffic counter reported by the server. Source is
+// "vnstat" (Period holds the month the totals cover) or "iptables"
+// (monotonic counters that reset on reboot; Period is empty).
+type Usage struct {
+	Source  string
+	Iface   string
+	Period  string
+	RXBytes uint64
+	TXBytes uint64
+}
+
+// UserTraffic is what one proxy user moved through the HTTP hangar, as far
+// back as the server's current access log goes.
+type UserTraffic struct {
+	User     string // "-" for requests that never authenticated
+	Requests int
+	Bytes    uint64 // sent to the client
+}
+
+// Activity is a cheap snapshot of a hangar in use: how many clients are
+// connected to the proxy ports, and the server interface's byte counters,
+// which two snapshots turn into a rate.
+type Activity struct {
+	Status  Status
+	Conns   int
+	Iface   string
+	RXBytes uint64
+	TXBytes uint64
+	At      time.Time // when the snapshot was read
+}
+
+// UnitSecurity is systemd-analyze's verdict on one beammeup service.
+type UnitSecurity struct {
+	Unit     string
+	Exposure float64 // 0 (best) to 10; -1 when systemd-analyze gave none
+	Label    string  // e.g. "OK", "MEDIUM", "EXPOSED", "UNSAFE"
+	State    string  // "hardened", "rolled-back", or empty without the drop-in
+}
+
+// OSUpdateResult is what an os-update run left behind on the server.
+type OSUpdateResult struct {
+	Manager        string   // "apt" or "dnf"
+	Pending        int      // upgrades still available afterwards (held back, or not security fixes)
+	RebootRequired bool     // a new kernel or core library waits for a reboot
+	RebootPackages []string // packages that asked for the reboot, where the distro records them
 }
 
 type ActionInput struct {
-	Mode                    string // inventory|show|preflight|apply|destroy
+	Mode                    string // inventory|usage|users|security|units|squid-config|os-update|reboot|show|preflight|apply|destroy|rollback
 	Protocol                string // http|socks5
 	HTTPMode                string // auto|sidecar
+	HTTPConflict            string // apply/preflight; "adopt" or "replace" takes over a system Squid config beammeup did not write
 	ProxyPort               int
 	NoFirewallChange        bool
 	ListenLocal             bool
 	SmartBlinder            bool
 	SmartBlinderIdleMinutes int
+	TrafficCapGB            int           // apply only; 0 removes the cap
+	QuietHours              string        // apply only; UTC windows when the proxy is stopped, empty removes the schedule
+	MaxConns                int           // apply only; concurrent connections on the hangar's credential, 0 removes the limit
+	RateLimit               int           // apply only; new connections per minute, 0 removes the limit
+	MemoryMax               string        // apply only; MemoryMax for the proxy unit (e.g. "256M"), empty removes the cap
+	CPUQuota                string        // apply only; CPUQuota for the proxy unit (e.g. "50%"), empty removes the cap
+	TasksMax                int           // apply only; TasksMax for the proxy unit, 0 removes the cap
+	EgressIP                string        // apply/preflight; source IP for outbound proxy traffic, empty for the default route
+	SocketActivation        bool          // apply only; SOCKS5 runs on demand behind a systemd socket
+	MicrosocksBinary        []byte        // apply only; static microsocks uploaded for servers without the package
+	PhaseTimeout            time.Duration // apply only; limit for each step on the server, 0 for the script's default
+	RollbackTo              string        // rollback only; snapshot to restore, empty lists the snapshots
+	SkipVerify              bool          // apply only; skip the request through the new proxy that has to succeed
+	Instance                string        // named HTTP instance to work on; empty for the default hangar
+	CacheMB                 int           // apply only; HTTP disk cache size, 0 turns caching off
+	CacheDir                string        // apply only; HTTP cache location, empty for the server default
+	Anonymity               string        // apply only; HTTP header preset (transparent|anonymous|elite), empty for elite
+	AllowDomains            []string      // apply only; restrict HTTP to these destinations, empty for no restriction
+	DenyDomains             []string      // apply only; destinations HTTP refuses
+	Label                   string        // apply only; hangar label for hangar.json, empty keeps the server's, "none" clears it
+	Description             string        // apply only; like Label, for the longer description
+	TTL                     time.Duration // apply only; destroy the hangar this long after apply
+	ClearTTL                bool          // apply only; cancel a scheduled destroy
 	RotateCredentials       bool
+	RotateScope             string        // with RotateCredentials: "password" keeps the username, empty or "both" replaces both
+	RotateGrace             time.Duration // with RotateCredentials: HTTP keeps accepting the old credentials this long
+	BreakLock               bool          // apply/destroy; take over another run's lock on the server
+	ReadOnly                bool          // inventory/usage only; never write to the server
+	Harden                  bool          // security only; install the hardening drop-ins first
+	SecurityOnly            bool          // os-update only; install security updates only
+	MetadataSignature       string        // sign only
+	MetadataSHA256          string        // sign only; hangar.json content that was signed
 }
 
 type ActionResult struct {
@@ -62,6 +233,11 @@ type ActionResult struct {
 	Action       string
 	FirewallNote string
 	Note         string
+	ExpiresAt    time.Time // scheduled destroy after apply; zero if none
+	EgressIP     string    // source IP the proxy connects out from; empty for the default route
+	Backup       string    // apply only; snapshot of the server before the change, for Rollback
+	Verified     string    // apply only; outcome of the request sent through the new proxy from the server
+	Reachable    string    // apply only; the client's own check of the proxy port, set by the caller
 	RawOutput    string
 	Inventory    Inventory
 	Values       remote.KeyValues
@@ -70,27 +246,225 @@ type ActionResult struct {
 type Service struct {
 	runRemoteFn func(target sshx.Target, in ActionInput) (remote.KeyValues, string, error)
 	SSH         sshx.ConnectOptions
+
+	// MetadataKeyDir holds the per-ship keys that sign hangar.json. Empty
+	// disables signing and tamper checks.
+	MetadataKeyDir string
+
+	// TemplateDir holds <unit>.tmpl files that replace the built-in systemd
+	// unit templates. Empty uses the built-ins.
+	TemplateDir string
+
+	// RemoteTmpDir replaces /tmp on the server for install logs and the
+	// temporary files of tools the script runs. Empty keeps /tmp.
+	RemoteTmpDir string
+
+	// ScriptRunner, when set, executes the remote script in place of the SSH
+	// transport (e.g. inside a local simulation container).
+	ScriptRunner func(script string, args []string) (string, error)
+
+	// Progress, when set, receives the script's log (stderr) as it runs
+	// for the actions that do not stream it elsewhere.
+	Progress io.Writer
+
+	// ReadOnly refuses every mode that changes the server (see
+	// checkReadOnly) and runs inventories as audits, so nothing on the
+	// server is written, not even hangar.json or its signature.
+	ReadOnly bool
+
+	// Client names this machine (see LocalClient) to the server, which
+	// records it in hangar.json for the runs that change the hangar. Empty
+	// leaves the record alone.
+	Client string
+
+	// GeoIP, when set, locates the public IP of every inventory
+	// (Host.Geo). Nil skips the lookup.
+	GeoIP *geoip.Resolver
+}
+
+// ErrReadOnly is returned for actions a read-only Service refuses.
+var ErrReadOnly = errors.New("read-only mode")
+
+// checkReadOnly reports whether mode only reads the server. Anything not
+// listed is refused, so new modes stay locked out until they are vetted.
+func checkReadOnly(in ActionInput) error {
+	switch in.Mode {
+	case "inventory", "usage", "users", "activity", "show", "preflight", "units", "squid-config":
+		return nil
+	case "security":
+		if !in.Harden {
+			return nil
+		}
+	case "rollback":
+		if in.RollbackTo == "" {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: refusing to run %s on the server", ErrReadOnly, in.Mode)
 }
 
+const (
+	remoteStateDir = "/etc/beammeup"
+	// ttlScriptStagePath is where apply --ttl-seconds picks up the copy of the
+	// script its expiry timer runs (see enable_ttl in the remote script).
+	ttlScriptStagePath = remoteStateDir + "/ttl-destroy.sh.new"
+
+	// microsocksStagePath is where apply picks up an uploaded microsocks
+	// binary (see ensure_microsocks in the remote script).
+	microsocksStagePath = remoteStateDir + "/microsocks.upload"
+)
+
 func NewService() *Service { return &Service{SSH: sshx.DefaultConnectOptions()} }
 
-func (s *Service) runRemote(target sshx.Target, in ActionInput) (remote.KeyValues, string, error) {
+func (s *Service) runRemote(ctx context.Context, target sshx.Target, in ActionInput) (remote.KeyValues, string, error) {
+	return s.runRemoteStream(ctx, target, in, s.Progress)
+}
+
+// runRemoteStream is runRemote with the script's stderr (its log and the
+// output of the tools it runs) copied to progress as it arrives. The returned
+// output then holds stdout only. A nil progress collects everything.
+// Cancelling ctx abandons the SSH session; the script may go on running on
+// the server until its next write fails.
+func (s *Service) runRemoteStream(ctx context.Context, target sshx.Target, in ActionInput, progress io.Writer) (remote.KeyValues, string, error) {
+	if s.ReadOnly {
+		if err := checkReadOnly(in); err != nil {
+			return nil, "", err
+		}
+		// Without it inventory refreshes hangar.json and usage adds its
+		// iptables counting chains.
+		in.ReadOnly = true
+	}
 	if s.runRemoteFn != nil {
 		return s.runRemoteFn(target, in)
 	}
 
-	client, err := sshx.ConnectWithOptions(target, s.SSH)
+	args := scriptArgs(in)
+	if dir := strings.TrimSpace(s.RemoteTmpDir); dir != "" {
+		args = append(args, "--tmpdir", dir)
+	}
+	if client := cleanClient(s.Client); client != "" && (in.Mode == "apply" || in.Mode == "destroy" || in.RollbackTo != "") {
+		args = append(args, "--client", client, "--client-version", version.AppVersion)
+	}
+	units, err := remote.RenderTemplates(s.TemplateDir)
 	if err != nil {
-		return nil, "", fmt.Errorf("ssh connect: %w", err)
+		return nil, "", err
 	}
-	defer client.Close()
 
-	remotePath := fmt.Sprintf("/tmp/beammeup-v2-%d.sh", time.Now().UnixNano())
-	if err := client.Upload([]byte(remote.Script), remotePath, 0o700); err != nil {
-		return nil, "", fmt.Errorf("upload remote script: %w", err)
+	var client *sshx.Client
+	if s.ScriptRunner == nil {
+		client, err = sshx.ConnectContext(ctx, target, s.SSH)
+		if err != nil {
+			return nil, "", fmt.Errorf("ssh connect: %w", err)
+		}
+		defer client.Close()
+
+		if in.Mode == "apply" && (in.TTL > 0 || len(in.MicrosocksBinary) > 0) {
+			if out, err := client.RunCombinedContext(ctx, "mkdir -p -m 700 "+remoteStateDir); err != nil {
+				return nil, "", fmt.Errorf("prepare %s: %w\n%s", remoteStateDir, err, tailString(out, 1024))
+			}
+		}
+		if in.Mode == "apply" && in.TTL > 0 {
+			// The expiry timer runs destroy long after this session ends, so
+			// it needs its own copy of the script on the server.
+			if err := client.UploadContext(ctx, ttlScript(units), ttlScriptStagePath, 0o700); err != nil {
+				return nil, "", fmt.Errorf("upload expiry script: %w", err)
+			}
+		}
+		if in.Mode == "apply" && len(in.MicrosocksBinary) > 0 {
+			if err := client.UploadContext(ctx, in.MicrosocksBinary, microsocksStagePath, 0o700); err != nil {
+				return nil, "", fmt.Errorf("upload microsocks binary: %w", err)
+			}
+		}
 	}
-	defer client.RunCombined("rm -f " + remotePath)
 
+	run := func(mode string, args []string, units map[string]string) (remote.KeyValues, string, error) {
+		payload := remote.PayloadWith(units)
+		var out string
+		var runErr error
+		if s.ScriptRunner != nil {
+			out, runErr = s.ScriptRunner(payload, args)
+			if progress != nil {
+				writeLogLines(progress, out)
+			}
+		} else if progress != nil {
+			var stdout strings.Builder
+			status, err := client.StreamContext(ctx, "bash -s -- "+shellJoin(args), strings.NewReader(payload), &stdout, progress)
+			out, runErr = stdout.String(), err
+			if err == nil && status != 0 {
+				runErr = fmt.Errorf("exit status %d", status)
+			}
+		} else {
+			out, runErr = client.RunWithStdinContext(ctx, "bash -s -- "+shellJoin(args), []byte(payload))
+		}
+
+		kv := remote.ParseBM(out)
+		if runErr != nil && !hasSuccessMarker(mode, kv) {
+			sanitized := sanitizeRemoteOutput(out)
+			if strings.TrimSpace(sanitized) == "" {
+				keys := redactedKeys(kv)
+				if len(keys) > 0 {
+					sanitized = "parsed keys: " + strings.Join(keys, ", ")
+				}
+			}
+			return kv, out, fmt.Errorf("remote command failed (mode=%s): %w\n%s", mode, runErr, tailString(sanitized, 8192))
+		}
+		return kv, out, nil
+	}
+
+	if in.Mode == "apply" && (in.Protocol == "http" || in.Protocol == "socks5") {
+		// squid.conf and the proxy units are rendered here from what only
+		// the server knows (its Squid version, helper paths, the resolved
+		// port), so a prepare run reports those first. Apply refuses to run
+		// if the server no longer matches the fingerprint it returned.
+		kv, out, err := run("prepare", prepareArgs(args), units)
+		if err != nil {
+			return kv, out, err
+		}
+		data, fingerprint, err := remote.ParseTemplateData(kv)
+		if err != nil {
+			return kv, out, err
+		}
+		proxy, err := remote.RenderProxyTemplates(s.TemplateDir, data)
+		if err != nil {
+			return kv, out, err
+		}
+		for name, body := range proxy {
+			units[name] = body
+		}
+		args = append(args, "--prepared", fingerprint)
+	}
+	return run(in.Mode, args, units)
+}
+
+// ttlScript is the copy of the script the expiry timer runs. Like every
+// payload it carries bm_template with the rendered units, which destroy
+// still installs from (e.g. limits.service when other limits remain).
+func ttlScript(units map[string]string) []byte {
+	return []byte(remote.PayloadWith(units))
+}
+
+// prepareArgs is an apply's arguments with the mode switched to prepare.
+func prepareArgs(args []string) []string {
+	out := append([]string(nil), args...)
+	for i := 0; i+1 < len(out); i++ {
+		if out[i] == "--mode" {
+			out[i+1] = "prepare"
+			break
+		}
+	}
+	return out
+}
+
+// writeLogLines copies the lines of out that are not BM_ output to w.
+func writeLogLines(w io.Writer, out string) {
+	for _, line := range strings.SplitAfter(out, "\n") {
+		if line != "" && !strings.HasPrefix(line, "BM_") {
+			io.WriteString(w, line)
+		}
+	}
+}
+
+func scriptArgs(in ActionInput) []string {
 	args := []string{"--mode", in.Mode}
 	if strings.TrimSpace(in.Protocol) != "" {
 		args = append(args, "--protocol", in.Protocol)
@@ -98,6 +472,9 @@ func (s *Service) runRemote(target sshx.Target, in ActionInput) (remote.KeyValue
 	if strings.TrimSpace(in.HTTPMode) != "" {
 		args = append(args, "--http-mode", in.HTTPMode)
 	}
+	if in.HTTPConflict != "" && (in.Mode == "apply" || in.Mode == "preflight") {
+		args = append(args, "--http-conflict", in.HTTPConflict)
+	}
 	if in.ProxyPort > 0 {
 		args = append(args, "--proxy-port", fmt.Sprintf("%d", in.ProxyPort))
 	}
@@ -117,36 +494,106 @@ func (s *Service) runRemote(target sshx.Target, in ActionInput) (remote.KeyValue
 			args = append(args, "--smart-blinder-idle-minutes", fmt.Sprintf("%d", in.SmartBlinderIdleMinutes))
 		}
 	}
+	if (in.Mode == "apply" || in.Mode == "preflight") && strings.TrimSpace(in.EgressIP) != "" {
+		args = append(args, "--egress-ip", strings.TrimSpace(in.EgressIP))
+	}
+	if strings.TrimSpace(in.Instance) != "" {
+		args = append(args, "--instance", strings.TrimSpace(in.Instance))
+	}
+	if in.Mode == "apply" {
+		// The cap and quiet hours cover the whole server, so a named
+		// instance leaves them to the default hangar.
+		if strings.TrimSpace(in.Instance) == "" {
+			args = append(args, "--traffic-cap-gb", fmt.Sprintf("%d", max(in.TrafficCapGB, 0)))
+			quiet := in.QuietHours
+			if quiet == "" {
+				quiet = "off"
+			}
+			args = append(args, "--quiet-hours", quiet)
+		}
+		args = append(args, "--max-conns", fmt.Sprintf("%d", max(in.MaxConns, 0)), "--rate-limit", fmt.Sprintf("%d", max(in.RateLimit, 0)))
+		args = append(args, "--memory-max", zeroIfEmpty(in.MemoryMax), "--cpu-quota", zeroIfEmpty(in.CPUQuota), "--tasks-max", fmt.Sprintf("%d", max(in.TasksMax, 0)))
+		switch {
+		case in.TTL > 0:
+			args = append(args, "--ttl-seconds", fmt.Sprintf("%d", int64(in.TTL.Round(time.Second)/time.Second)))
+		case in.ClearTTL:
+			args = append(args, "--ttl-seconds", "0")
+		}
+		if label := strings.TrimSpace(in.Label); label != "" {
+			args = append(args, "--label", label)
+		}
+		if description := strings.TrimSpace(in.Description); description != "" {
+			args = append(args, "--description", description)
+		}
+		if in.SkipVerify {
+			args = append(args, "--skip-verify")
+		}
+		if in.PhaseTimeout > 0 {
+			args = append(args, "--phase-timeout-seconds", fmt.Sprintf("%d", int64(in.PhaseTimeout.Round(time.Second)/time.Second)))
+		}
+	}
 	if in.RotateCredentials {
 		args = append(args, "--rotate-credentials")
+		if in.RotateScope == "password" {
+			args = append(args, "--rotate-scope", "password")
+		}
+	}
+	if in.BreakLock && (in.Mode == "apply" || in.Mode == "destroy") {
+		args = append(args, "--break-lock")
+	}
+	if in.ReadOnly && (in.Mode == "inventory" || in.Mode == "usage") {
+		args = append(args, "--read-only")
+	}
+	if in.Harden && in.Mode == "security" {
+		args = append(args, "--harden")
+	}
+	if in.SecurityOnly && in.Mode == "os-update" {
+		args = append(args, "--security-only")
+	}
+	if in.Mode == "sign" {
+		args = append(args, "--metadata-signature", in.MetadataSignature, "--metadata-sha256", in.MetadataSHA256)
+	}
+	if in.Mode == "rollback" && in.RollbackTo != "" {
+		args = append(args, "--to", in.RollbackTo)
 	}
+	return append(args, protocolArgs(in)...)
+}
 
-	cmd := "bash " + remotePath + " " + shellJoin(args)
-	out, err := client.RunCombined(cmd)
-	kv := remote.ParseBM(out)
-	if err != nil {
-		if !hasSuccessMarker(in.Mode, kv) {
-			sanitized := sanitizeRemoteOutput(out)
-			if strings.TrimSpace(sanitized) == "" {
-				keys := redactedKeys(kv)
-				if len(keys) > 0 {
-					sanitized = "parsed keys: " + strings.Join(keys, ", ")
-				}
-			}
-			return kv, out, fmt.Errorf("remote command failed (mode=%s): %w\n%s", in.Mode, err, tailString(sanitized, 8192))
-		}
+func zeroIfEmpty(v string) string {
+	if v = strings.TrimSpace(v); v == "" {
+		return "0"
 	}
-	return kv, out, nil
+	return v
 }
 
 func hasSuccessMarker(mode string, kv remote.KeyValues) bool {
 	switch strings.ToLower(strings.TrimSpace(mode)) {
 	case "inventory":
 		return strings.TrimSpace(kv.Get("BM_PUBLIC_IP")) != ""
+	case "usage":
+		return strings.TrimSpace(kv.Get("BM_USAGE_SOURCE")) != ""
+	case "users":
+		return strings.TrimSpace(kv.Get("BM_USERS_LOG")) != ""
+	case "security":
+		return strings.TrimSpace(kv.Get("BM_SECURITY_AVAILABLE")) != ""
+	case "os-update":
+		return strings.TrimSpace(kv.Get("BM_OS_UPDATE")) == "OK"
+	case "reboot":
+		return strings.TrimSpace(kv.Get("BM_REBOOT")) == "OK"
+	case "units":
+		_, ok := kv["BM_UNITS_ACTIVE"]
+		return ok
+	case "squid-config":
+		_, ok := kv["BM_SQUID_CONF"]
+		return ok
 	case "preflight":
 		return strings.TrimSpace(kv.Get("BM_PREFLIGHT")) == "OK"
 	case "show", "apply", "destroy":
 		return strings.TrimSpace(kv.Get("BM_RESULT_PROTOCOL")) != ""
+	case "sign":
+		return kv.Bool("BM_METADATA_SIGNED")
+	case "rollback":
+		return strings.TrimSpace(kv.Get("BM_ROLLBACK")) != ""
 	default:
 		return false
 	}
@@ -215,46 +662,457 @@ func parseInventory(kv remote.KeyValues) Inventory {
 			status = StatusMissing
 		}
 	}
+	capBytes, _ := strconv.ParseUint(strings.TrimSpace(kv.Get("BM_TRAFFIC_CAP_BYTES")), 10, 64)
 	return Inventory{
-		PublicIP: kv.Get("BM_PUBLIC_IP"),
-		Socks5: ProtocolState{
-			Exists: kv.Bool("BM_SOCKS_EXISTS"),
-			Active: kv.Bool("BM_SOCKS_ACTIVE"),
-			Port:   kv.Get("BM_SOCKS_PORT"),
-			User:   kv.Get("BM_SOCKS_USER"),
-			Pass:   kv.Get("BM_SOCKS_PASS"),
-			Mode:   kv.Get("BM_SOCKS_MODE"),
-		},
-		HTTP: ProtocolState{
-			Exists:  kv.Bool("BM_HTTP_EXISTS"),
-			Active:  kv.Bool("BM_HTTP_ACTIVE"),
-			Port:    kv.Get("BM_HTTP_PORT"),
-			User:    kv.Get("BM_HTTP_USER"),
-			Pass:    kv.Get("BM_HTTP_PASS"),
-			Mode:    kv.Get("BM_HTTP_MODE"),
-			Managed: kv.Bool("BM_HTTP_MANAGED"),
-			Legacy:  kv.Bool("BM_HTTP_LEGACY"),
+		PublicIP:      kv.Get("BM_PUBLIC_IP"),
+		Socks5:        protocols["socks5"].ParseInventory(kv),
+		HTTP:          protocols["http"].ParseInventory(kv),
+		Instance:      kv.Get("BM_HTTP_INSTANCE"),
+		HTTPInstances: parseHTTPInstances(kv.Get("BM_HTTP_INSTANCES")),
+		HTTPCache: CacheStats{
+			SizeMB:    kv.Int("BM_HTTP_CACHE_MB"),
+			Dir:       kv.Get("BM_HTTP_CACHE_DIR"),
+			UsedBytes: uint64(kv.Int("BM_HTTP_CACHE_USED_KB")) * 1024,
+			Hits:      kv.Int("BM_HTTP_CACHE_HITS"),
+			Requests:  kv.Int("BM_HTTP_CACHE_REQUESTS"),
 		},
 		HangarStatus:   status,
 		MetadataExists: kv.Bool("BM_METADATA_EXISTS"),
+		TrafficCapGB:   int(capBytes / 1_000_000_000),
+		QuietHours:     kv.Get("BM_QUIET_HOURS"),
+		ExpiresAt:      parseEpoch(kv.Get("BM_EXPIRES_AT")),
+		Label:          kv.Get("BM_HANGAR_LABEL"),
+		Description:    kv.Get("BM_HANGAR_DESCRIPTION"),
+		LastChange: ClientChange{
+			Client:  kv.Get("BM_LAST_CLIENT"),
+			Version: kv.Get("BM_LAST_CLIENT_VERSION"),
+			Action:  kv.Get("BM_LAST_CLIENT_ACTION"),
+			At:      parseEpoch(kv.Get("BM_LAST_CLIENT_AT")),
+		},
+
+		UFWActive:               kv.Bool("BM_UFW_ACTIVE"),
+		UFWAllowedPorts:         strings.Fields(kv.Get("BM_UFW_ALLOWED_PORTS")),
+		SmartBlinder:            kv.Bool("BM_SMART_BLINDER"),
+		SmartBlinderIdleMinutes: kv.Int("BM_SMART_BLINDER_IDLE_MINUTES"),
+		SmartBlinderKeepalive:   parseEpoch(kv.Get("BM_SMART_BLINDER_KEEPALIVE")),
+
+		Listening: parseListening(kv.Get("BM_LISTEN_PORTS")),
+
+		Host: ships.HostInfo{
+			OS:     kv.Get("BM_HOST_OS"),
+			Kernel: kv.Get("BM_HOST_KERNEL"),
+			Virt:   kv.Get("BM_HOST_VIRT"),
+			Uptime: time.Duration(kv.Int("BM_HOST_UPTIME_SECONDS")) * time.Second,
+			Ports:  strings.Fields(kv.Get("BM_HOST_PORTS")),
+
+			Addresses: strings.Fields(kv.Get("BM_HOST_ADDRESSES")),
+		},
+	}
+}
+
+func splitCSV(v string) []string {
+	var out []string
+	for _, f := range strings.Split(v, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// parseHTTPInstances reads the "name:port:active" list from the inventory.
+func parseHTTPInstances(v string) []HTTPInstance {
+	var out []HTTPInstance
+	for _, f := range strings.Fields(v) {
+		parts := strings.Split(f, ":")
+		if len(parts) != 3 || parts[0] == "" {
+			continue
+		}
+		out = append(out, HTTPInstance{Name: parts[0], Port: parts[1], Active: parts[2] == "1"})
+	}
+	return out
+}
+
+// parseEpoch reads a Unix timestamp, returning the zero time when v is empty
+// or malformed.
+func parseEpoch(v string) time.Time {
+	sec, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+	if err != nil || sec <= 0 {
+		return time.Time{}
 	}
+	return time.Unix(sec, 0)
+}
+
+// KeyLogin reports whether SSH keys (the ship's own IdentityFile, the
+// ssh-agent's or an IdentityFile in ~/.ssh/config) log in to ship without a
+// password. An empty password then makes every call use the keys.
+func (s *Service) KeyLogin(ship ships.Ship) bool {
+	if s.ScriptRunner != nil {
+		return false
+	}
+	return sshx.KeyLogin(ShipTarget(ship, ""), s.SSH)
+}
+
+// ShipTarget is how beammeup logs in to ship. The profile's own key
+// (SSH_IDENTITY_FILE) is offered first and, like a user or port chosen for
+// the ship, takes the place of what ~/.ssh/config says for the host.
+func ShipTarget(ship ships.Ship, password string) sshx.Target {
+	t := sshx.Target{Host: ship.Host, Port: ship.SSHPort, User: ship.SSHUser, Password: password}
+	if key := strings.TrimSpace(ship.IdentityFile); key != "" {
+		if rest, ok := strings.CutPrefix(key, "~/"); ok {
+			if home, err := os.UserHomeDir(); err == nil {
+				key = filepath.Join(home, rest)
+			}
+		}
+		t.IdentityFiles = []string{key}
+	}
+	return t
 }
 
 func (s *Service) Inventory(ship ships.Ship, password string) (Inventory, error) {
-	target := sshx.Target{Host: ship.Host, Port: ship.SSHPort, User: ship.SSHUser, Password: password}
-	kv, out, err := s.runRemote(target, ActionInput{Mode: "inventory"})
+	return s.inventory(context.Background(), ship, password, false)
+}
+
+// InventoryContext is Inventory that gives up when ctx is done.
+func (s *Service) InventoryContext(ctx context.Context, ship ships.Ship, password string) (Inventory, error) {
+	return s.inventory(ctx, ship, password, false)
+}
+
+// AuditInventory reads the same inventory without touching the server:
+// unlike Inventory it does not refresh or reconstruct hangar.json.
+func (s *Service) AuditInventory(ship ships.Ship, password string) (Inventory, error) {
+	return s.inventory(context.Background(), ship, password, true)
+}
+
+// AuditInventoryContext is AuditInventory that gives up when ctx is done.
+func (s *Service) AuditInventoryContext(ctx context.Context, ship ships.Ship, password string) (Inventory, error) {
+	return s.inventory(ctx, ship, password, true)
+}
+
+func (s *Service) inventory(ctx context.Context, ship ships.Ship, password string, readOnly bool) (Inventory, error) {
+	readOnly = readOnly || s.ReadOnly
+	target := ShipTarget(ship, password)
+	kv, out, err := s.runRemote(ctx, target, ActionInput{Mode: "inventory", ReadOnly: readOnly, Instance: ship.Instance})
 	if err != nil {
 		return Inventory{}, fmt.Errorf("inventory failed: %w", err)
 	}
 	if len(kv) == 0 {
 		return Inventory{}, fmt.Errorf("inventory returned no BM output\n%s", out)
 	}
-	return parseInventory(kv), nil
+	inv := parseInventory(kv)
+	inv.Host.CheckedAt = time.Now()
+	if s.GeoIP != nil && inv.PublicIP != "" {
+		inv.Host.Geo, inv.GeoErr = s.GeoIP.Lookup(inv.PublicIP)
+	}
+	metaStatus, err := s.checkMetadata(ctx, target, ship, kv, readOnly)
+	if err != nil {
+		return Inventory{}, fmt.Errorf("sign hangar metadata: %w", err)
+	}
+	if metaStatus != "" {
+		inv.HangarStatus = metaStatus
+	}
+	return inv, nil
+}
+
+func (s *Service) Usage(ship ships.Ship, password string) (Usage, error) {
+	target := ShipTarget(ship, password)
+	kv, out, err := s.runRemote(context.Background(), target, ActionInput{Mode: "usage"})
+	if err != nil {
+		return Usage{}, fmt.Errorf("usage failed: %w", err)
+	}
+	if kv.Get("BM_USAGE_SOURCE") == "" {
+		return Usage{}, fmt.Errorf("usage returned no BM output\n%s", out)
+	}
+	rx, err := strconv.ParseUint(kv.Get("BM_USAGE_RX_BYTES"), 10, 64)
+	if err != nil {
+		return Usage{}, fmt.Errorf("parse rx bytes: %w", err)
+	}
+	tx, err := strconv.ParseUint(kv.Get("BM_USAGE_TX_BYTES"), 10, 64)
+	if err != nil {
+		return Usage{}, fmt.Errorf("parse tx bytes: %w", err)
+	}
+	return Usage{
+		Source:  kv.Get("BM_USAGE_SOURCE"),
+		Iface:   kv.Get("BM_USAGE_IFACE"),
+		Period:  kv.Get("BM_USAGE_PERIOD"),
+		RXBytes: rx,
+		TXBytes: tx,
+	}, nil
+}
+
+// Activity reads the ship's connection count and traffic counters without
+// changing anything on the server; cheap enough to poll every few seconds.
+func (s *Service) Activity(ship ships.Ship, password string) (Activity, error) {
+	target := ShipTarget(ship, password)
+	kv, out, err := s.runRemote(context.Background(), target, ActionInput{Mode: "activity", Instance: ship.Instance})
+	if err != nil {
+		return Activity{}, fmt.Errorf("activity failed: %w", err)
+	}
+	if kv.Get("BM_HANGAR_STATUS") == "" {
+		return Activity{}, fmt.Errorf("activity returned no BM output\n%s", out)
+	}
+	rx, _ := strconv.ParseUint(strings.TrimSpace(kv.Get("BM_ACTIVITY_RX_BYTES")), 10, 64)
+	tx, _ := strconv.ParseUint(strings.TrimSpace(kv.Get("BM_ACTIVITY_TX_BYTES")), 10, 64)
+	return Activity{
+		Status:  Status(kv.Get("BM_HANGAR_STATUS")),
+		Conns:   kv.Int("BM_ACTIVITY_CONNS"),
+		Iface:   kv.Get("BM_ACTIVITY_IFACE"),
+		RXBytes: rx,
+		TXBytes: tx,
+		At:      time.Now(),
+	}, nil
+}
+
+// UserTraffic breaks the ship's HTTP hangar traffic down by proxy user,
+// busiest first. since is the oldest entry in the log the totals cover.
+func (s *Service) UserTraffic(ship ships.Ship, password string) (users []UserTraffic, since time.Time, err error) {
+	target := ShipTarget(ship, password)
+	kv, out, err := s.runRemote(context.Background(), target, ActionInput{Mode: "users", Instance: ship.Instance})
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("user traffic failed: %w", err)
+	}
+	if kv.Get("BM_USERS_LOG") == "" {
+		return nil, time.Time{}, fmt.Errorf("user traffic returned no BM output\n%s", out)
+	}
+	return parseUserTraffic(kv.Get("BM_USERS")), parseEpoch(kv.Get("BM_USERS_SINCE")), nil
+}
+
+func parseUserTraffic(v string) []UserTraffic {
+	var users []UserTraffic
+	for _, f := range strings.Fields(v) {
+		// htpasswd user names cannot contain ':'.
+		parts := strings.Split(f, ":")
+		if len(parts) != 3 {
+			continue
+		}
+		reqs, _ := strconv.Atoi(parts[1])
+		bytes, _ := strconv.ParseUint(parts[2], 10, 64)
+		users = append(users, UserTraffic{User: parts[0], Requests: reqs, Bytes: bytes})
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].Bytes != users[j].Bytes {
+			return users[i].Bytes > users[j].Bytes
+		}
+		return users[i].User < users[j].User
+	})
+	return users
+}
+
+// SecurityAudit runs systemd-analyze security on the server's beammeup
+// services. With harden, the proxy services first get the sandboxing drop-in
+// (restarting them if they run); one that fails to start with it is rolled
+// back and reported as such.
+func (s *Service) SecurityAudit(ship ships.Ship, password string, harden bool) ([]UnitSecurity, error) {
+	target := ShipTarget(ship, password)
+	kv, out, err := s.runRemote(context.Background(), target, ActionInput{Mode: "security", Harden: harden})
+	if err != nil {
+		return nil, fmt.Errorf("security audit failed: %w", err)
+	}
+	switch kv.Get("BM_SECURITY_AVAILABLE") {
+	case "":
+		return nil, fmt.Errorf("security audit returned no BM output\n%s", out)
+	case "0":
+		return nil, fmt.Errorf("systemd-analyze security is not available on the server (needs systemd 240 or newer)")
+	}
+	return parseUnitSecurity(kv.Get("BM_SECURITY")), nil
+}
+
+// OSUpdate upgrades the server's packages, all of them or with securityOnly
+// just the security fixes, copying the package manager's output to progress
+// as it runs.
+func (s *Service) OSUpdate(ship ships.Ship, password string, securityOnly bool, progress io.Writer) (OSUpdateResult, error) {
+	target := ShipTarget(ship, password)
+	kv, out, err := s.runRemoteStream(context.Background(), target, ActionInput{Mode: "os-update", SecurityOnly: securityOnly}, progress)
+	if err != nil {
+		return OSUpdateResult{}, fmt.Errorf("os update failed: %w", err)
+	}
+	if kv.Get("BM_OS_UPDATE") != "OK" {
+		return OSUpdateResult{}, fmt.Errorf("os update returned no BM output\n%s", out)
+	}
+	return OSUpdateResult{
+		Manager:        kv.Get("BM_OS_UPDATE_MANAGER"),
+		Pending:        kv.Int("BM_OS_UPDATE_PENDING"),
+		RebootRequired: kv.Bool("BM_OS_UPDATE_REBOOT_REQUIRED"),
+		RebootPackages: splitCSV(kv.Get("BM_OS_UPDATE_REBOOT_PACKAGES")),
+	}, nil
+}
+
+// Reboot reboots the server a few seconds after it returns and reports the
+// beammeup units that were running, for comparing with Units once the server
+// is back.
+func (s *Service) Reboot(ship ships.Ship, password string) ([]string, error) {
+	target := ShipTarget(ship, password)
+	kv, out, err := s.runRemote(context.Background(), target, ActionInput{Mode: "reboot"})
+	if err != nil {
+		return nil, fmt.Errorf("reboot failed: %w", err)
+	}
+	if kv.Get("BM_REBOOT") != "OK" {
+		return nil, fmt.Errorf("reboot returned no BM output\n%s", out)
+	}
+	return strings.Fields(kv.Get("BM_UNITS_ACTIVE")), nil
+}
+
+// Units lists the beammeup services and timers (and the system Squid when
+// the hangar uses it) that are running, and those that failed.
+func (s *Service) Units(ship ships.Ship, password string) (active, failed []string, err error) {
+	target := ShipTarget(ship, password)
+	kv, out, err := s.runRemote(context.Background(), target, ActionInput{Mode: "units"})
+	if err != nil {
+		return nil, nil, fmt.Errorf("unit status failed: %w", err)
+	}
+	if _, ok := kv["BM_UNITS_ACTIVE"]; !ok {
+		return nil, nil, fmt.Errorf("unit status returned no BM output\n%s", out)
+	}
+	return strings.Fields(kv.Get("BM_UNITS_ACTIVE")), strings.Fields(kv.Get("BM_UNITS_FAILED")), nil
+}
+
+// SquidConfig describes the system Squid config on a server, so the user can
+// decide what to do with one beammeup did not write (see
+// ActionInput.HTTPConflict).
+type SquidConfig struct {
+	Path       string // empty when the server has no Squid config
+	Foreign    bool   // not written by beammeup
+	Modified   time.Time
+	Directives int      // lines that are neither blank nor comments
+	Ports      []string // http_port and https_port values, e.g. "3128" or "10.0.0.1:3128"
+	Auth       []string // auth_param schemes, e.g. "basic"
+	UsersFile  string   // htpasswd file behind basic_ncsa_auth, empty without one
+	Users      int      // entries in UsersFile
+	ACLs       int
+	Includes   []string // files pulled in with include
+	Active     bool     // squid.service is running
+	Backup     string   // beammeup's backup of an earlier config, empty if none
+}
+
+// Summary describes the config in a few lines for a prompt.
+func (c SquidConfig) Summary() string {
+	if c.Path == "" {
+		return "no Squid config on the server"
+	}
+	lines := []string{fmt.Sprintf("Config: %s (%d directives", c.Path, c.Directives)}
+	if !c.Modified.IsZero() {
+		lines[0] += ", changed " + c.Modified.UTC().Format("2006-01-02")
+	}
+	lines[0] += ")"
+	lines = append(lines, "Listens on: "+orNone(strings.Join(c.Ports, ", ")))
+	auth := orNone(strings.Join(c.Auth, ", "))
+	if c.UsersFile != "" {
+		auth += fmt.Sprintf(", %d users in %s", c.Users, c.UsersFile)
+	}
+	lines = append(lines, "Auth: "+auth, fmt.Sprintf("ACLs: %d", c.ACLs))
+	if len(c.Includes) > 0 {
+		lines = append(lines, "Includes: "+strings.Join(c.Includes, ", "))
+	}
+	state := "stopped"
+	if c.Active {
+		state = "running"
+	}
+	lines = append(lines, "Squid: "+state)
+	if c.Backup != "" {
+		lines = append(lines, "Earlier backup: "+c.Backup+" (kept beside the new one)")
+	}
+	return strings.Join(lines, "\n")
+}
+
+func orNone(v string) string {
+	if v == "" {
+		return "none"
+	}
+	return v
+}
+
+// SquidConfig reads the summary of the server's system Squid config.
+func (s *Service) SquidConfig(ship ships.Ship, password string) (SquidConfig, error) {
+	target := ShipTarget(ship, password)
+	kv, out, err := s.runRemote(context.Background(), target, ActionInput{Mode: "squid-config"})
+	if err != nil {
+		return SquidConfig{}, fmt.Errorf("reading the Squid config failed: %w", err)
+	}
+	if _, ok := kv["BM_SQUID_CONF"]; !ok {
+		return SquidConfig{}, fmt.Errorf("squid-config returned no BM output\n%s", out)
+	}
+	return parseSquidConfig(kv), nil
+}
+
+func parseSquidConfig(kv remote.KeyValues) SquidConfig {
+	return SquidConfig{
+		Path:       kv.Get("BM_SQUID_CONF"),
+		Foreign:    kv.Bool("BM_SQUID_FOREIGN"),
+		Modified:   parseEpoch(kv.Get("BM_SQUID_MODIFIED")),
+		Directives: kv.Int("BM_SQUID_DIRECTIVES"),
+		Ports:      splitCSV(kv.Get("BM_SQUID_PORTS")),
+		Auth:       splitCSV(kv.Get("BM_SQUID_AUTH")),
+		UsersFile:  kv.Get("BM_SQUID_USERS_FILE"),
+		Users:      kv.Int("BM_SQUID_USERS"),
+		ACLs:       kv.Int("BM_SQUID_ACLS"),
+		Includes:   splitCSV(kv.Get("BM_SQUID_INCLUDES")),
+		Active:     kv.Bool("BM_SQUID_ACTIVE"),
+		Backup:     kv.Get("BM_SQUID_BACKUP"),
+	}
+}
+
+// Backups lists the configuration snapshots on the server, newest first.
+// Every apply takes one before it changes anything.
+func (s *Service) Backups(ship ships.Ship, password string) ([]string, error) {
+	target := ShipTarget(ship, password)
+	kv, out, err := s.runRemote(context.Background(), target, ActionInput{Mode: "rollback"})
+	if err != nil {
+		return nil, fmt.Errorf("listing snapshots failed: %w", err)
+	}
+	if kv.Get("BM_ROLLBACK") != "LIST" {
+		return nil, fmt.Errorf("snapshot list returned no BM output\n%s", out)
+	}
+	return splitCSV(kv.Get("BM_BACKUPS")), nil
+}
+
+// Rollback restores the snapshot to and returns the snapshot it took of the
+// configuration it replaced, so the rollback can be undone in turn.
+func (s *Service) Rollback(ship ships.Ship, password, to string) (string, error) {
+	target := ShipTarget(ship, password)
+	kv, out, err := s.runRemote(context.Background(), target, ActionInput{Mode: "rollback", RollbackTo: to})
+	if err != nil {
+		return "", fmt.Errorf("rollback failed: %w", err)
+	}
+	if kv.Get("BM_ROLLBACK") != "OK" {
+		return "", fmt.Errorf("rollback returned no BM output\n%s", out)
+	}
+	return kv.Get("BM_BACKUP"), nil
+}
+
+func parseUnitSecurity(v string) []UnitSecurity {
+	var units []UnitSecurity
+	for _, f := range strings.Fields(v) {
+		parts := strings.Split(f, ":")
+		if len(parts) != 4 || parts[0] == "" {
+			continue
+		}
+		exposure, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			exposure = -1
+		}
+		state := parts[3]
+		if state == "-" {
+			state = ""
+		}
+		units = append(units, UnitSecurity{Unit: parts[0], Exposure: exposure, Label: parts[2], State: state})
+	}
+	return units
 }
 
 func (s *Service) Execute(ship ships.Ship, password string, in ActionInput) (ActionResult, error) {
-	target := sshx.Target{Host: ship.Host, Port: ship.SSHPort, User: ship.SSHUser, Password: password}
-	kv, out, err := s.runRemote(target, in)
+	return s.ExecuteContext(context.Background(), ship, password, in)
+}
+
+// ExecuteContext is Execute that gives up when ctx is done. Cancelling an
+// apply midway leaves the server as far as the script got; its own rollback
+// only runs when a step fails on the server.
+func (s *Service) ExecuteContext(ctx context.Context, ship ships.Ship, password string, in ActionInput) (ActionResult, error) {
+	target := ShipTarget(ship, password)
+	// A ship that names an instance only ever touches that instance.
+	if in.Instance == "" && in.Protocol != "socks5" {
+		in.Instance = ship.Instance
+	}
+	kv, out, err := s.runRemote(ctx, target, in)
 	if err != nil {
 		return ActionResult{}, err
 	}
@@ -269,12 +1127,27 @@ func (s *Service) Execute(ship ships.Ship, password string, in ActionInput) (Act
 		Action:       kv.Get("BM_RESULT_ACTION"),
 		FirewallNote: kv.Get("BM_RESULT_FIREWALL_NOTE"),
 		Note:         kv.Get("BM_RESULT_NOTE"),
+		ExpiresAt:    parseEpoch(kv.Get("BM_RESULT_EXPIRES_AT")),
+		EgressIP:     kv.Get("BM_RESULT_EGRESS_IP"),
+		Backup:       kv.Get("BM_RESULT_BACKUP"),
+		Verified:     kv.Get("BM_RESULT_VERIFIED"),
 		RawOutput:    out,
 		Values:       kv,
 	}
 	if res.Host == "" || res.Host == "UNKNOWN" {
 		res.Host = ship.Host
 	}
+	if s.MetadataKeyDir != "" && kv.Get("BM_METADATA_WRITTEN_B64") != "" {
+		// The change itself went through; an unsigned hangar.json only
+		// shows up as tampered on the next inventory.
+		key, _, err := s.metadataKey(ship, true)
+		if err == nil {
+			err = s.storeMetadataSignature(ctx, target, key, kv)
+		}
+		if err != nil {
+			res.Note = strings.TrimSpace(res.Note + " (warning: hangar.json not signed: " + err.Error() + ")")
+		}
+	}
 	if len(kv) > 0 && kv.Get("BM_SOCKS_EXISTS") != "" {
 		res.Inventory = parseInventory(kv)
 	}
@@ -1,8 +1,15 @@
 package hangar
 
 import (
+	"encoding/base64"
 	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/alfaoz/beammeup/internal/remote"
 	"github.com/alfaoz/beammeup/internal/ships"
@@ -51,6 +58,21 @@ func TestInventoryMapping(t *testing.T) {
 	}
 }
 
+func TestInventoryKeptAlive(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	inv := Inventory{SmartBlinder: true, SmartBlinderIdleMinutes: 10, SmartBlinderKeepalive: now.Add(-2 * time.Minute)}
+	if !inv.KeptAlive(now) {
+		t.Fatalf("a touch 2 minutes ago should keep a 10 minute blinder off")
+	}
+	if inv.KeptAlive(now.Add(9 * time.Minute)) {
+		t.Fatalf("a touch 11 minutes ago should no longer count")
+	}
+	inv.SmartBlinder = false
+	if inv.KeptAlive(now) {
+		t.Fatalf("without a smart blinder nothing is kept alive")
+	}
+}
+
 func TestExecuteMapping(t *testing.T) {
 	svc := NewService()
 	svc.runRemoteFn = func(_ sshx.Target, in ActionInput) (remote.KeyValues, string, error) {
@@ -66,6 +88,8 @@ func TestExecuteMapping(t *testing.T) {
 			"BM_RESULT_ACTION":        "updated",
 			"BM_RESULT_FIREWALL_NOTE": "opened",
 			"BM_RESULT_NOTE":          "ok",
+			"BM_RESULT_VERIFIED":      "yes, a request through the proxy left from 192.0.2.10",
+			"BM_RESULT_BACKUP":        "20260101T120000Z",
 		}, "raw", nil
 	}
 
@@ -80,6 +104,128 @@ func TestExecuteMapping(t *testing.T) {
 	if res.Protocol != "HTTP" || res.Port != "18181" || res.User != "beamhttp" {
 		t.Fatalf("unexpected result: %+v", res)
 	}
+	if !strings.HasPrefix(res.Verified, "yes") || res.Backup != "20260101T120000Z" {
+		t.Fatalf("unexpected verification or backup: %q %q", res.Verified, res.Backup)
+	}
+}
+
+func TestApplyRendersProxyConfigsFromPrepare(t *testing.T) {
+	fingerprint := strings.Repeat("ab", 32)
+	svc := NewService()
+	var calls []string
+	svc.ScriptRunner = func(script string, args []string) (string, error) {
+		calls = append(calls, strings.Join(args, " "))
+		if args[1] == "prepare" {
+			if strings.Contains(script, "    squid.conf) ") {
+				t.Fatal("squid.conf was rendered before the prepare run")
+			}
+			return "BM_PROXY_HTTP_MODE=managed\nBM_PROXY_PORT=18181\nBM_PROXY_SQUID_VERSION=6.13\n" +
+				"BM_PROXY_AUTH_HELPER=/usr/lib/squid/basic_ncsa_auth\nBM_PROXY_FINGERPRINT=" + fingerprint + "\n", nil
+		}
+		_, rest, _ := strings.Cut(script, "    squid.conf) printf '%s' '")
+		encoded, _, _ := strings.Cut(rest, "'")
+		conf, _ := base64.StdEncoding.DecodeString(encoded)
+		if !strings.Contains(string(conf), "\nhttp_port 18181\n") || !strings.Contains(string(conf), "program /usr/lib/squid/basic_ncsa_auth ") {
+			t.Fatalf("apply did not get the squid.conf rendered from the prepare run:\n%s", conf)
+		}
+		return "BM_RESULT_PROTOCOL=HTTP\nBM_RESULT_PORT=18181\n", nil
+	}
+	ship := ships.Ship{Host: "192.0.2.10", SSHUser: "root", SSHPort: 22}
+	if _, err := svc.Execute(ship, "pw", ActionInput{Mode: "apply", Protocol: "http"}); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if len(calls) != 2 || !strings.HasPrefix(calls[0], "--mode prepare --protocol http") ||
+		!strings.HasPrefix(calls[1], "--mode apply") || !strings.HasSuffix(calls[1], "--prepared "+fingerprint) {
+		t.Fatalf("expected prepare, then apply with its fingerprint, got %q", calls)
+	}
+
+	calls = nil
+	svc.ScriptRunner = func(_ string, args []string) (string, error) {
+		calls = append(calls, strings.Join(args, " "))
+		return "Installing squid ...\n", nil
+	}
+	if _, err := svc.Execute(ship, "pw", ActionInput{Mode: "apply", Protocol: "http"}); err == nil || len(calls) != 1 {
+		t.Fatalf("a prepare run without BM_PROXY_ values should stop the apply, got %v after %q", err, calls)
+	}
+}
+
+func TestTTLScriptDestroysInstanceWithOtherLimits(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash not available")
+	}
+	units, err := remote.RenderTemplates("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	staged := string(ttlScript(units))
+	main := strings.Index(staged, "\nMODE=\"inventory\"\n")
+	if !strings.HasPrefix(staged, "{\n") || main < 0 {
+		t.Fatal("unexpected layout of the staged script")
+	}
+
+	// Everything destroy_http_instance does up to the limits runs for real,
+	// against a scratch BEAM_DIR; only systemd, iptables and the state the
+	// server would have are faked.
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "limits.conf"), []byte("http-team-a 3128 10 0\nsocks5 1080 5 0\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	harness := staged[:main] + `
+BEAM_DIR="$1"
+use_http_instance team-a
+LIMITS_CONF="$BEAM_DIR/limits.conf"
+LIMITS_SCRIPT="$BEAM_DIR/limits.sh"
+LIMITS_SERVICE_FILE="$BEAM_DIR/limits.service"
+HTTP_SIDECAR_SERVICE_FILE="$BEAM_DIR/sidecar.service"
+METADATA_EXISTS=0
+systemctl() { :; }
+iptables() { return 1; }
+ip6tables() { return 1; }
+export -f iptables ip6tables
+ensure_requirements() { :; }
+load_http_state() { HTTP_EXISTS=1; }
+load_socks_state() { :; }
+reconcile_hangar_status() { :; }
+disable_ttl() { :; }
+destroy_http_instance
+}
+`
+	out, err := exec.Command("bash", "-c", harness, "ttl-destroy.sh", dir).CombinedOutput()
+	if err != nil {
+		t.Fatalf("destroy from the staged script failed: %v\n%s", err, out)
+	}
+	if !strings.Contains(string(out), "BM_RESULT_ACTION=destroyed") {
+		t.Fatalf("destroy did not finish:\n%s", out)
+	}
+	if conf, _ := os.ReadFile(filepath.Join(dir, "limits.conf")); string(conf) != "socks5 1080 5 0\n" {
+		t.Fatalf("limits.conf = %q, want only the SOCKS5 entry left", conf)
+	}
+	if unit, _ := os.ReadFile(filepath.Join(dir, "limits.service")); !strings.Contains(string(unit), "[Unit]") {
+		t.Fatalf("limits.service was not installed from the staged templates: %q", unit)
+	}
+}
+
+func TestShipTargetUsesProfileKey(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	ship := ships.Ship{Host: "192.0.2.10", SSHPort: 22, SSHUser: "root", IdentityFile: "~/.ssh/id_ed25519"}
+	target := ShipTarget(ship, "pw")
+	if want := filepath.Join(home, ".ssh", "id_ed25519"); len(target.IdentityFiles) != 1 || target.IdentityFiles[0] != want || target.Password != "pw" {
+		t.Fatalf("ShipTarget = %+v, want the profile key %s", target, want)
+	}
+
+	// The profile's key wins over the one ~/.ssh/config names for the host.
+	config := filepath.Join(home, "ssh_config")
+	if err := os.WriteFile(config, []byte("Host 192.0.2.10\n  IdentityFile ~/.ssh/other\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	resolved := sshx.ConnectOptions{SSHConfigPath: config}.Resolve(target)
+	if !slices.Equal(resolved.IdentityFiles, target.IdentityFiles) {
+		t.Fatalf("Resolve replaced the profile key: %q", resolved.IdentityFiles)
+	}
+	if got := ShipTarget(ships.Ship{Host: "192.0.2.10"}, "").IdentityFiles; got != nil {
+		t.Fatalf("a ship without SSH_IDENTITY_FILE should leave the keys to ~/.ssh/config, got %q", got)
+	}
 }
 
 func TestInventoryErrorPassthrough(t *testing.T) {
@@ -93,3 +239,528 @@ func TestInventoryErrorPassthrough(t *testing.T) {
 		t.Fatal("expected error")
 	}
 }
+
+func TestUsageMapping(t *testing.T) {
+	svc := NewService()
+	svc.runRemoteFn = func(_ sshx.Target, in ActionInput) (remote.KeyValues, string, error) {
+		if in.Mode != "usage" {
+			t.Fatalf("expected usage mode, got %q", in.Mode)
+		}
+		return remote.KeyValues{
+			"BM_USAGE_SOURCE":   "vnstat",
+			"BM_USAGE_IFACE":    "eth0",
+			"BM_USAGE_PERIOD":   "2026-10",
+			"BM_USAGE_RX_BYTES": "1500000000",
+			"BM_USAGE_TX_BYTES": "250000000",
+		}, "", nil
+	}
+
+	u, err := svc.Usage(ships.Ship{Host: "x", SSHUser: "root", SSHPort: 22}, "pw")
+	if err != nil {
+		t.Fatalf("Usage: %v", err)
+	}
+	if u.Source != "vnstat" || u.Period != "2026-10" || u.RXBytes != 1500000000 || u.TXBytes != 250000000 {
+		t.Fatalf("unexpected usage: %+v", u)
+	}
+}
+
+func TestActivityMapping(t *testing.T) {
+	svc := NewService()
+	svc.ReadOnly = true
+	svc.runRemoteFn = func(_ sshx.Target, in ActionInput) (remote.KeyValues, string, error) {
+		if in.Mode != "activity" {
+			t.Fatalf("expected activity mode, got %q", in.Mode)
+		}
+		return remote.KeyValues{
+			"BM_HANGAR_STATUS":     "online",
+			"BM_ACTIVITY_CONNS":    "7",
+			"BM_ACTIVITY_IFACE":    "eth0",
+			"BM_ACTIVITY_RX_BYTES": "123456789",
+			"BM_ACTIVITY_TX_BYTES": "",
+		}, "", nil
+	}
+
+	a, err := svc.Activity(ships.Ship{Host: "x", SSHUser: "root", SSHPort: 22}, "pw")
+	if err != nil {
+		t.Fatalf("Activity: %v", err)
+	}
+	if a.Status != StatusOnline || a.Conns != 7 || a.RXBytes != 123456789 || a.TXBytes != 0 || a.At.IsZero() {
+		t.Fatalf("unexpected activity: %+v", a)
+	}
+}
+
+func TestOSUpdateStreamsLog(t *testing.T) {
+	svc := NewService()
+	svc.ScriptRunner = func(_ string, args []string) (string, error) {
+		if got := strings.Join(args, " "); got != "--mode os-update --security-only" {
+			t.Fatalf("unexpected args %q", got)
+		}
+		return "Unpacking openssl ...\nBM_OS_UPDATE=OK\nBM_OS_UPDATE_MANAGER=apt\nBM_OS_UPDATE_PENDING=3\n" +
+			"BM_OS_UPDATE_REBOOT_REQUIRED=1\nBM_OS_UPDATE_REBOOT_PACKAGES=linux-image-6.1.0-28-amd64\n", nil
+	}
+
+	var progress strings.Builder
+	res, err := svc.OSUpdate(ships.Ship{Host: "x", SSHUser: "root", SSHPort: 22}, "pw", true, &progress)
+	if err != nil {
+		t.Fatalf("OSUpdate: %v", err)
+	}
+	if progress.String() != "Unpacking openssl ...\n" {
+		t.Fatalf("progress should hold the log lines only, got %q", progress.String())
+	}
+	if res.Manager != "apt" || res.Pending != 3 || !res.RebootRequired || len(res.RebootPackages) != 1 {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+}
+
+func TestUnitsMapping(t *testing.T) {
+	svc := NewService()
+	svc.runRemoteFn = func(_ sshx.Target, in ActionInput) (remote.KeyValues, string, error) {
+		if in.Mode != "units" {
+			t.Fatalf("expected units mode, got %q", in.Mode)
+		}
+		return remote.KeyValues{
+			"BM_UNITS_ACTIVE": "beammeup-limits.service beammeup-microsocks.service",
+			"BM_UNITS_FAILED": "",
+		}, "", nil
+	}
+	active, failed, err := svc.Units(ships.Ship{Host: "x", SSHUser: "root", SSHPort: 22}, "pw")
+	if err != nil {
+		t.Fatalf("Units: %v", err)
+	}
+	if len(active) != 2 || active[1] != "beammeup-microsocks.service" || len(failed) != 0 {
+		t.Fatalf("unexpected units: %v %v", active, failed)
+	}
+}
+
+func TestSquidConfigMapping(t *testing.T) {
+	svc := NewService()
+	svc.runRemoteFn = func(_ sshx.Target, in ActionInput) (remote.KeyValues, string, error) {
+		if in.Mode != "squid-config" {
+			t.Fatalf("expected squid-config mode, got %q", in.Mode)
+		}
+		return remote.KeyValues{
+			"BM_SQUID_CONF":       "/etc/squid/squid.conf",
+			"BM_SQUID_FOREIGN":    "1",
+			"BM_SQUID_DIRECTIVES": "42",
+			"BM_SQUID_PORTS":      "3128,127.0.0.1:3129",
+			"BM_SQUID_AUTH":       "basic",
+			"BM_SQUID_USERS_FILE": "/etc/squid/passwd",
+			"BM_SQUID_USERS":      "12",
+			"BM_SQUID_ACTIVE":     "1",
+		}, "", nil
+	}
+	cfg, err := svc.SquidConfig(ships.Ship{Host: "x", SSHUser: "root", SSHPort: 22}, "pw")
+	if err != nil {
+		t.Fatalf("SquidConfig: %v", err)
+	}
+	if !cfg.Foreign || len(cfg.Ports) != 2 || cfg.Users != 12 || !cfg.Active {
+		t.Fatalf("unexpected config: %+v", cfg)
+	}
+	summary := cfg.Summary()
+	for _, want := range []string{"42 directives", "3128, 127.0.0.1:3129", "12 users in /etc/squid/passwd", "Squid: running"} {
+		if !strings.Contains(summary, want) {
+			t.Fatalf("summary lacks %q:\n%s", want, summary)
+		}
+	}
+	if err := checkReadOnly(ActionInput{Mode: "squid-config"}); err != nil {
+		t.Fatalf("squid-config only reads: %v", err)
+	}
+}
+
+func TestScriptArgsHTTPConflict(t *testing.T) {
+	args := strings.Join(scriptArgs(ActionInput{Mode: "apply", Protocol: "http", HTTPConflict: "adopt"}), " ")
+	if !strings.Contains(args, "--http-conflict adopt") {
+		t.Fatalf("expected --http-conflict, got %q", args)
+	}
+	args = strings.Join(scriptArgs(ActionInput{Mode: "show", Protocol: "http", HTTPConflict: "adopt"}), " ")
+	if strings.Contains(args, "--http-conflict") {
+		t.Fatalf("--http-conflict only goes with apply and preflight, got %q", args)
+	}
+}
+
+func TestRollbackMapping(t *testing.T) {
+	svc := NewService()
+	svc.runRemoteFn = func(_ sshx.Target, in ActionInput) (remote.KeyValues, string, error) {
+		if in.Mode != "rollback" {
+			t.Fatalf("expected rollback mode, got %q", in.Mode)
+		}
+		if in.RollbackTo == "" {
+			return remote.KeyValues{"BM_ROLLBACK": "LIST", "BM_BACKUPS": "20260102T090000Z,20260101T090000Z"}, "", nil
+		}
+		if args := strings.Join(scriptArgs(in), " "); !strings.Contains(args, "--to 20260101T090000Z") {
+			t.Fatalf("expected --to, got %q", args)
+		}
+		return remote.KeyValues{"BM_ROLLBACK": "OK", "BM_BACKUP": "20260103T090000Z"}, "", nil
+	}
+	ship := ships.Ship{Host: "x", SSHUser: "root", SSHPort: 22}
+	backups, err := svc.Backups(ship, "pw")
+	if err != nil || len(backups) != 2 || backups[0] != "20260102T090000Z" {
+		t.Fatalf("Backups = %v, %v", backups, err)
+	}
+	saved, err := svc.Rollback(ship, "pw", "20260101T090000Z")
+	if err != nil || saved != "20260103T090000Z" {
+		t.Fatalf("Rollback = %q, %v", saved, err)
+	}
+}
+
+func TestScriptArgsTrafficCap(t *testing.T) {
+	args := strings.Join(scriptArgs(ActionInput{Mode: "apply", Protocol: "http", TrafficCapGB: 900}), " ")
+	if !strings.Contains(args, "--traffic-cap-gb 900") {
+		t.Fatalf("expected traffic cap in apply args, got %q", args)
+	}
+	args = strings.Join(scriptArgs(ActionInput{Mode: "apply", Protocol: "http"}), " ")
+	if !strings.Contains(args, "--traffic-cap-gb 0") {
+		t.Fatalf("expected apply without cap to remove it, got %q", args)
+	}
+	args = strings.Join(scriptArgs(ActionInput{Mode: "show", Protocol: "http", TrafficCapGB: 900}), " ")
+	if strings.Contains(args, "--traffic-cap-gb") {
+		t.Fatalf("traffic cap should only be sent on apply, got %q", args)
+	}
+}
+
+func TestScriptArgsInstanceLeavesServerSettings(t *testing.T) {
+	args := strings.Join(scriptArgs(ActionInput{Mode: "apply", Protocol: "http", Instance: "team-a", TTL: time.Hour}), " ")
+	if strings.Contains(args, "--traffic-cap-gb") || strings.Contains(args, "--quiet-hours") {
+		t.Fatalf("an instance apply must not touch the server-wide cap or quiet hours, got %q", args)
+	}
+	if !strings.Contains(args, "--instance team-a") || !strings.Contains(args, "--ttl-seconds 3600") {
+		t.Fatalf("expected the instance and its expiry, got %q", args)
+	}
+}
+
+func TestScriptArgsTTL(t *testing.T) {
+	args := strings.Join(scriptArgs(ActionInput{Mode: "apply", Protocol: "socks5", TTL: 4 * time.Hour}), " ")
+	if !strings.Contains(args, "--ttl-seconds 14400") {
+		t.Fatalf("expected ttl in apply args, got %q", args)
+	}
+	args = strings.Join(scriptArgs(ActionInput{Mode: "apply", Protocol: "socks5", ClearTTL: true}), " ")
+	if !strings.Contains(args, "--ttl-seconds 0") {
+		t.Fatalf("expected ttl cancel in apply args, got %q", args)
+	}
+	args = strings.Join(scriptArgs(ActionInput{Mode: "apply", Protocol: "socks5"}), " ")
+	if strings.Contains(args, "--ttl-seconds") {
+		t.Fatalf("apply without ttl should keep the schedule, got %q", args)
+	}
+	inv := parseInventory(remote.KeyValues{"BM_SOCKS_EXISTS": "1", "BM_EXPIRES_AT": "1792166400"})
+	if !inv.ExpiresAt.Equal(time.Unix(1792166400, 0)) {
+		t.Fatalf("unexpected expiry: %v", inv.ExpiresAt)
+	}
+}
+
+func TestAuditInventoryIsReadOnly(t *testing.T) {
+	svc := NewService()
+	svc.runRemoteFn = func(_ sshx.Target, in ActionInput) (remote.KeyValues, string, error) {
+		if in.Mode != "inventory" || !in.ReadOnly {
+			t.Fatalf("expected read-only inventory, got mode=%q readOnly=%v", in.Mode, in.ReadOnly)
+		}
+		return remote.KeyValues{"BM_SOCKS_EXISTS": "1"}, "", nil
+	}
+	if _, err := svc.AuditInventory(ships.Ship{Host: "x", SSHUser: "root", SSHPort: 22}, "pw"); err != nil {
+		t.Fatalf("AuditInventory: %v", err)
+	}
+	args := strings.Join(scriptArgs(ActionInput{Mode: "inventory", ReadOnly: true}), " ")
+	if args != "--mode inventory --read-only" {
+		t.Fatalf("unexpected args: %q", args)
+	}
+}
+
+func TestReadOnlyServiceRefusesChanges(t *testing.T) {
+	svc := NewService()
+	svc.ReadOnly = true
+	svc.MetadataKeyDir = t.TempDir()
+	svc.runRemoteFn = func(_ sshx.Target, in ActionInput) (remote.KeyValues, string, error) {
+		if !in.ReadOnly {
+			t.Fatalf("mode %s ran without --read-only", in.Mode)
+		}
+		return remote.KeyValues{"BM_SOCKS_EXISTS": "1", "BM_USAGE_SOURCE": "vnstat", "BM_USAGE_RX_BYTES": "1", "BM_USAGE_TX_BYTES": "2"}, "", nil
+	}
+	ship := ships.Ship{Name: "audit", Host: "x", SSHUser: "root", SSHPort: 22}
+	if _, err := svc.Inventory(ship, "pw"); err != nil {
+		t.Fatalf("Inventory: %v", err)
+	}
+	if _, err := svc.Usage(ship, "pw"); err != nil {
+		t.Fatalf("Usage: %v", err)
+	}
+	if entries, _ := os.ReadDir(svc.MetadataKeyDir); len(entries) != 0 {
+		t.Fatalf("read-only inventory created a metadata key")
+	}
+	for _, in := range []ActionInput{{Mode: "apply"}, {Mode: "destroy"}, {Mode: "sign"}, {Mode: "reboot"}, {Mode: "os-update"}, {Mode: "security", Harden: true}} {
+		if _, err := svc.Execute(ship, "pw", in); !errors.Is(err, ErrReadOnly) {
+			t.Fatalf("%s: expected ErrReadOnly, got %v", in.Mode, err)
+		}
+	}
+	if args := strings.Join(scriptArgs(ActionInput{Mode: "usage", ReadOnly: true}), " "); args != "--mode usage --read-only" {
+		t.Fatalf("unexpected args: %q", args)
+	}
+}
+
+func TestScriptArgsBreakLock(t *testing.T) {
+	args := strings.Join(scriptArgs(ActionInput{Mode: "destroy", BreakLock: true}), " ")
+	if !strings.Contains(args, "--break-lock") {
+		t.Fatalf("expected --break-lock for destroy, got %q", args)
+	}
+}
+
+func TestScriptArgsEgressIP(t *testing.T) {
+	args := strings.Join(scriptArgs(ActionInput{Mode: "apply", Protocol: "socks5", EgressIP: "203.0.113.9"}), " ")
+	if !strings.Contains(args, "--egress-ip 203.0.113.9") {
+		t.Fatalf("expected --egress-ip for apply, got %q", args)
+	}
+	if args := strings.Join(scriptArgs(ActionInput{Mode: "inventory", EgressIP: "203.0.113.9"}), " "); strings.Contains(args, "--egress-ip") {
+		t.Fatalf("inventory should not pass --egress-ip, got %q", args)
+	}
+}
+
+func TestShipInstanceReachesScript(t *testing.T) {
+	svc := NewService()
+	var got []ActionInput
+	svc.runRemoteFn = func(_ sshx.Target, in ActionInput) (remote.KeyValues, string, error) {
+		got = append(got, in)
+		return remote.KeyValues{
+			"BM_HTTP_EXISTS":    "1",
+			"BM_HTTP_INSTANCE":  "team-a",
+			"BM_HTTP_INSTANCES": "team-a:18200:1 team-b:18201:0 bad",
+			"BM_RESULT_ACTION":  "show",
+		}, "", nil
+	}
+	ship := ships.Ship{Host: "x", SSHUser: "root", SSHPort: 22, Protocol: "http", Instance: "team-a"}
+
+	inv, err := svc.Inventory(ship, "pw")
+	if err != nil {
+		t.Fatalf("Inventory: %v", err)
+	}
+	if inv.Instance != "team-a" || len(inv.HTTPInstances) != 2 {
+		t.Fatalf("unexpected instances: %q %+v", inv.Instance, inv.HTTPInstances)
+	}
+	if b := inv.HTTPInstances[1]; b.Name != "team-b" || b.Port != "18201" || b.Active {
+		t.Fatalf("unexpected second instance: %+v", b)
+	}
+	if _, err := svc.Execute(ship, "pw", ActionInput{Mode: "destroy"}); err != nil {
+		t.Fatalf("Execute destroy: %v", err)
+	}
+	if _, err := svc.Execute(ship, "pw", ActionInput{Mode: "show", Protocol: "socks5"}); err != nil {
+		t.Fatalf("Execute show: %v", err)
+	}
+	if got[0].Instance != "team-a" || got[1].Instance != "team-a" {
+		t.Fatalf("inventory and destroy should target the ship's instance: %+v", got[:2])
+	}
+	if got[2].Instance != "" {
+		t.Fatalf("SOCKS5 actions have no instance, got %q", got[2].Instance)
+	}
+	if args := strings.Join(scriptArgs(got[1]), " "); !strings.Contains(args, "--instance team-a") {
+		t.Fatalf("expected --instance in script args, got %q", args)
+	}
+}
+
+func TestScriptArgsCache(t *testing.T) {
+	args := strings.Join(scriptArgs(ActionInput{Mode: "apply", Protocol: "http", CacheMB: 2048, CacheDir: "/srv/cache"}), " ")
+	if !strings.Contains(args, "--cache-mb 2048 --cache-dir /srv/cache") {
+		t.Fatalf("expected cache args for HTTP apply, got %q", args)
+	}
+	for _, in := range []ActionInput{
+		{Mode: "apply", Protocol: "socks5", CacheMB: 2048},
+		{Mode: "show", Protocol: "http", CacheMB: 2048},
+		{Mode: "apply", Protocol: "http", CacheDir: "/srv/cache"},
+	} {
+		if args := strings.Join(scriptArgs(in), " "); strings.Contains(args, "--cache") {
+			t.Fatalf("%+v should not pass cache args, got %q", in, args)
+		}
+	}
+}
+
+func TestInventoryCacheStats(t *testing.T) {
+	inv := parseInventory(remote.KeyValues{
+		"BM_HTTP_EXISTS":         "1",
+		"BM_HTTP_CACHE_MB":       "1024",
+		"BM_HTTP_CACHE_DIR":      "/var/spool/squid/beammeup",
+		"BM_HTTP_CACHE_USED_KB":  "2048",
+		"BM_HTTP_CACHE_HITS":     "3",
+		"BM_HTTP_CACHE_REQUESTS": "12",
+	})
+	want := CacheStats{SizeMB: 1024, Dir: "/var/spool/squid/beammeup", UsedBytes: 2048 * 1024, Hits: 3, Requests: 12}
+	if inv.HTTPCache != want {
+		t.Fatalf("unexpected cache stats: %+v", inv.HTTPCache)
+	}
+}
+
+func TestInventorySquidVersion(t *testing.T) {
+	inv := parseInventory(remote.KeyValues{"BM_HTTP_EXISTS": "1", "BM_HTTP_SQUID_VERSION": "4.13"})
+	if inv.HTTP.SquidVersion != "4.13" {
+		t.Fatalf("unexpected squid version: %q", inv.HTTP.SquidVersion)
+	}
+}
+
+func TestScriptArgsAnonymity(t *testing.T) {
+	args := strings.Join(scriptArgs(ActionInput{Mode: "apply", Protocol: "http", Anonymity: "transparent"}), " ")
+	if !strings.Contains(args, "--anonymity transparent") {
+		t.Fatalf("expected --anonymity for HTTP apply, got %q", args)
+	}
+	if args := strings.Join(scriptArgs(ActionInput{Mode: "apply", Protocol: "socks5", Anonymity: "transparent"}), " "); strings.Contains(args, "--anonymity") {
+		t.Fatalf("SOCKS5 should not pass --anonymity, got %q", args)
+	}
+	if got := AnonymityLabel(""); got != "elite" {
+		t.Fatalf("empty preset should read as elite, got %q", got)
+	}
+}
+
+func TestScriptArgsDomains(t *testing.T) {
+	in := ActionInput{Mode: "apply", Protocol: "http", AllowDomains: []string{".example.com", "api.example.org"}, DenyDomains: []string{"ads.example.com"}}
+	args := strings.Join(scriptArgs(in), " ")
+	if !strings.Contains(args, "--allow-domains .example.com,api.example.org") || !strings.Contains(args, "--deny-domains ads.example.com") {
+		t.Fatalf("expected domain lists for HTTP apply, got %q", args)
+	}
+	in.Protocol = "socks5"
+	if args := strings.Join(scriptArgs(in), " "); strings.Contains(args, "-domains") {
+		t.Fatalf("SOCKS5 should not pass domain lists, got %q", args)
+	}
+	inv := parseInventory(remote.KeyValues{"BM_HTTP_EXISTS": "1", "BM_HTTP_ALLOW_DOMAINS": ".example.com,api.example.org"})
+	if len(inv.HTTP.AllowDomains) != 2 || inv.HTTP.AllowDomains[1] != "api.example.org" || inv.HTTP.DenyDomains != nil {
+		t.Fatalf("unexpected domain lists: %+v", inv.HTTP)
+	}
+}
+
+func TestScriptArgsQuietHours(t *testing.T) {
+	args := strings.Join(scriptArgs(ActionInput{Mode: "apply", Protocol: "socks5", QuietHours: "00:00-06:00"}), " ")
+	if !strings.Contains(args, "--quiet-hours 00:00-06:00") {
+		t.Fatalf("expected --quiet-hours on apply, got %q", args)
+	}
+	args = strings.Join(scriptArgs(ActionInput{Mode: "apply", Protocol: "http"}), " ")
+	if !strings.Contains(args, "--quiet-hours off") {
+		t.Fatalf("apply without a schedule should clear it, got %q", args)
+	}
+	inv := parseInventory(remote.KeyValues{"BM_QUIET_HOURS": "00:00-06:00", "BM_HANGAR_STATUS": "quiet-hours"})
+	if inv.QuietHours != "00:00-06:00" || inv.HangarStatus != StatusQuietHours {
+		t.Fatalf("unexpected inventory: quiet=%q status=%q", inv.QuietHours, inv.HangarStatus)
+	}
+}
+
+func TestScriptArgsHangarInfo(t *testing.T) {
+	args := scriptArgs(ActionInput{Mode: "apply", Protocol: "http", Label: "marketing team proxy", Description: "none"})
+	if !slices.Contains(args, "marketing team proxy") || !strings.Contains(strings.Join(args, " "), "--description none") {
+		t.Fatalf("expected --label and --description on apply, got %q", args)
+	}
+	for _, in := range []ActionInput{{Mode: "apply", Protocol: "http"}, {Mode: "inventory", Label: "x"}} {
+		if args := strings.Join(scriptArgs(in), " "); strings.Contains(args, "--label") || strings.Contains(args, "--description") {
+			t.Fatalf("%s without a label should leave the server's alone, got %q", in.Mode, args)
+		}
+	}
+	inv := parseInventory(remote.KeyValues{"BM_HANGAR_LABEL": "marketing team proxy", "BM_HANGAR_DESCRIPTION": "contact alice"})
+	if inv.Label != "marketing team proxy" || inv.Description != "contact alice" {
+		t.Fatalf("unexpected inventory: label=%q description=%q", inv.Label, inv.Description)
+	}
+	if CheckHangarInfo("one\ntwo") == nil || CheckHangarInfo(strings.Repeat("x", MaxHangarInfoLen+1)) == nil {
+		t.Fatal("expected multi-line and overlong text to be refused")
+	}
+}
+
+func TestScriptArgsLimits(t *testing.T) {
+	args := strings.Join(scriptArgs(ActionInput{Mode: "apply", Protocol: "socks5", MaxConns: 50, RateLimit: 600}), " ")
+	if !strings.Contains(args, "--max-conns 50 --rate-limit 600") {
+		t.Fatalf("expected limits on apply, got %q", args)
+	}
+	inv := parseInventory(remote.KeyValues{"BM_HTTP_EXISTS": "1", "BM_HTTP_MAX_CONNS": "20", "BM_HTTP_RATE_LIMIT": "0"})
+	if inv.HTTP.MaxConns != 20 || inv.HTTP.RateLimit != 0 {
+		t.Fatalf("unexpected limits: %+v", inv.HTTP)
+	}
+	if got := LimitsLabel(20, 0); got != "20 conns" {
+		t.Fatalf("LimitsLabel = %q", got)
+	}
+	args = strings.Join(scriptArgs(ActionInput{Mode: "apply", Protocol: "http", MemoryMax: "256M"}), " ")
+	if !strings.Contains(args, "--memory-max 256M --cpu-quota 0 --tasks-max 0") {
+		t.Fatalf("expected resource caps on apply, got %q", args)
+	}
+	inv = parseInventory(remote.KeyValues{"BM_HTTP_EXISTS": "1", "BM_HTTP_MEMORY_MAX": "256M", "BM_HTTP_CPU_QUOTA": "0", "BM_HTTP_TASKS_MAX": "64"})
+	if got := ResourcesLabel(inv.HTTP.MemoryMax, inv.HTTP.CPUQuota, inv.HTTP.TasksMax); got != "memory 256M, 64 tasks" {
+		t.Fatalf("ResourcesLabel = %q", got)
+	}
+}
+
+func TestScriptArgsSocketActivation(t *testing.T) {
+	args := strings.Join(scriptArgs(ActionInput{Mode: "apply", Protocol: "socks5", SocketActivation: true}), " ")
+	if !strings.Contains(args, "--socket-activation") {
+		t.Fatalf("expected --socket-activation on SOCKS5 apply, got %q", args)
+	}
+	args = strings.Join(scriptArgs(ActionInput{Mode: "apply", Protocol: "http", SocketActivation: true}), " ")
+	if strings.Contains(args, "--socket-activation") {
+		t.Fatalf("HTTP apply must not get --socket-activation, got %q", args)
+	}
+	inv := parseInventory(remote.KeyValues{"BM_SOCKS_EXISTS": "1", "BM_SOCKS_SOCKET_ACTIVATION": "1"})
+	if !inv.Socks5.SocketActivation {
+		t.Fatalf("unexpected SOCKS5 state: %+v", inv.Socks5)
+	}
+}
+
+func TestScriptArgsMicrosocksBinary(t *testing.T) {
+	args := strings.Join(scriptArgs(ActionInput{Mode: "apply", Protocol: "socks5", MicrosocksBinary: []byte("\x7fELF")}), " ")
+	if !strings.Contains(args, "--microsocks-sha256 3bdbb4fe8397cd2b842430b39ccff01a8663c751945ef5e9a09e267fb8b1d359") {
+		t.Fatalf("expected the upload's checksum, got %q", args)
+	}
+	args = strings.Join(scriptArgs(ActionInput{Mode: "apply", Protocol: "socks5"}), " ")
+	if strings.Contains(args, "--microsocks-sha256") {
+		t.Fatalf("no upload, no checksum: %q", args)
+	}
+}
+
+func TestScriptArgsPhaseTimeout(t *testing.T) {
+	args := strings.Join(scriptArgs(ActionInput{Mode: "apply", Protocol: "http", PhaseTimeout: 90 * time.Second}), " ")
+	if !strings.Contains(args, "--phase-timeout-seconds 90") {
+		t.Fatalf("expected the phase timeout, got %q", args)
+	}
+	args = strings.Join(scriptArgs(ActionInput{Mode: "apply", Protocol: "http"}), " ")
+	if strings.Contains(args, "--phase-timeout-seconds") {
+		t.Fatalf("the script's default needs no argument, got %q", args)
+	}
+}
+
+func TestScriptArgsSkipVerify(t *testing.T) {
+	args := strings.Join(scriptArgs(ActionInput{Mode: "apply", Protocol: "socks5", SkipVerify: true}), " ")
+	if !strings.Contains(args, "--skip-verify") {
+		t.Fatalf("expected --skip-verify, got %q", args)
+	}
+	args = strings.Join(scriptArgs(ActionInput{Mode: "apply", Protocol: "socks5"}), " ")
+	if strings.Contains(args, "--skip-verify") {
+		t.Fatalf("verification is on by default, got %q", args)
+	}
+}
+
+func TestParseUserTraffic(t *testing.T) {
+	got := parseUserTraffic("alice:12:2048 -:3:0 bob:40:900000 bad:entry")
+	if len(got) != 3 || got[0].User != "bob" || got[1] != (UserTraffic{User: "alice", Requests: 12, Bytes: 2048}) || got[2].User != "-" {
+		t.Fatalf("unexpected user traffic: %+v", got)
+	}
+}
+
+func TestParseUnitSecurity(t *testing.T) {
+	got := parseUnitSecurity("beammeup-microsocks.service:2.1:OK:hardened beammeup-ttl.service::: bad:entry")
+	if len(got) != 2 || got[0] != (UnitSecurity{Unit: "beammeup-microsocks.service", Exposure: 2.1, Label: "OK", State: "hardened"}) {
+		t.Fatalf("unexpected units: %+v", got)
+	}
+	if got[1].Exposure != -1 || got[1].State != "" {
+		t.Fatalf("unknown score should be -1 without state, got %+v", got[1])
+	}
+	if args := strings.Join(scriptArgs(ActionInput{Mode: "security", Harden: true}), " "); !strings.Contains(args, "--harden") {
+		t.Fatalf("expected --harden, got %q", args)
+	}
+}
+
+func TestScriptArgsRotateScope(t *testing.T) {
+	args := strings.Join(scriptArgs(ActionInput{Mode: "apply", Protocol: "http", RotateCredentials: true, RotateScope: "password"}), " ")
+	if !strings.Contains(args, "--rotate-credentials --rotate-scope password") {
+		t.Fatalf("expected password-only rotation, got %q", args)
+	}
+	args = strings.Join(scriptArgs(ActionInput{Mode: "apply", Protocol: "http", RotateCredentials: true}), " ")
+	if strings.Contains(args, "--rotate-scope") {
+		t.Fatalf("default rotation should not pass a scope, got %q", args)
+	}
+}
+
+func TestScriptArgsRotateGrace(t *testing.T) {
+	in := ActionInput{Mode: "apply", Protocol: "http", RotateCredentials: true, RotateGrace: 24 * time.Hour}
+	if args := strings.Join(scriptArgs(in), " "); !strings.Contains(args, "--rotate-grace-seconds 86400") {
+		t.Fatalf("expected grace seconds, got %q", args)
+	}
+	in.Protocol = "socks5"
+	if args := strings.Join(scriptArgs(in), " "); strings.Contains(args, "--rotate-grace-seconds") {
+		t.Fatalf("SOCKS5 has no overlap, got %q", args)
+	}
+}
@@ -0,0 +1,196 @@
+package history
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"github.com/alfaoz/beammeup/internal/hangar"
+)
+
+// MaxHold is how long one check's status is assumed to last. Past it, until
+// the next check, the ship's state is unknown and left out of uptime.
+const MaxHold = 6 * time.Hour
+
+// A ship flaps when its checks keep switching between up and down: at least
+// flapChanges switches, none further than flapGap apart, the latest within
+// flapGap of now.
+const (
+	flapChanges = 3
+	flapGap     = 24 * time.Hour
+)
+
+// classify sorts a checked status into up, planned downtime the user asked
+// for (smart blinder, quiet hours, traffic cap), or down.
+func classify(status string) (up, planned bool) {
+	switch hangar.Status(status) {
+	case hangar.StatusOnline:
+		return true, false
+	case hangar.StatusBlinded, hangar.StatusQuietHours, hangar.StatusQuotaExceeded:
+		return false, true
+	}
+	return false, false
+}
+
+// Up reports whether a check's status counts as serving.
+func (e Entry) Up() bool {
+	up, _ := classify(e.Status)
+	return up
+}
+
+// Planned reports whether a check's status is downtime the user asked for.
+func (e Entry) Planned() bool {
+	_, planned := classify(e.Status)
+	return planned
+}
+
+// Checks returns the status checks among entries.
+func Checks(entries []Entry) []Entry {
+	var out []Entry
+	for _, e := range entries {
+		if e.Kind == KindCheck {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// Availability is how long a ship was seen up and down in a window. Planned
+// downtime and stretches no check covers count as neither.
+type Availability struct {
+	Up     time.Duration
+	Down   time.Duration
+	Checks int // checks within the window
+}
+
+// Percent returns the uptime share; ok is false when nothing was measured.
+func (a Availability) Percent() (pct float64, ok bool) {
+	total := a.Up + a.Down
+	if total <= 0 {
+		return 0, false
+	}
+	return 100 * float64(a.Up) / float64(total), true
+}
+
+// Measure computes the availability between from and to. Each check's status
+// holds until the next check, or for MaxHold at most; pass entries from
+// MaxHold before from so the window starts with a known state.
+func Measure(entries []Entry, from, to time.Time) Availability {
+	var a Availability
+	checks := Checks(entries)
+	for i, e := range checks {
+		if !e.Time.Before(from) && e.Time.Before(to) {
+			a.Checks++
+		}
+		start, end := e.Time, e.Time.Add(MaxHold)
+		if i+1 < len(checks) && checks[i+1].Time.Before(end) {
+			end = checks[i+1].Time
+		}
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if !end.After(start) {
+			continue
+		}
+		switch up, planned := classify(e.Status); {
+		case planned:
+		case up:
+			a.Up += end.Sub(start)
+		default:
+			a.Down += end.Sub(start)
+		}
+	}
+	return a
+}
+
+// Flap describes a ship that keeps switching between up and down.
+type Flap struct {
+	Since   time.Time // first switch of the current run
+	Changes int
+}
+
+// switches returns the checks where the ship went from up to down or back.
+// Planned downtime does not count either way.
+func switches(entries []Entry) []Entry {
+	var out []Entry
+	var prev *Entry
+	for _, e := range Checks(entries) {
+		if e.Planned() {
+			continue
+		}
+		if prev != nil && prev.Up() != e.Up() {
+			out = append(out, e)
+		}
+		prev = &e
+	}
+	return out
+}
+
+// Drifts returns when the ship went down between from and to, i.e. the
+// checks that found it down after one that found it up.
+func Drifts(entries []Entry, from, to time.Time) []time.Time {
+	var out []time.Time
+	for _, e := range switches(entries) {
+		if !e.Up() && !e.Time.Before(from) && e.Time.Before(to) {
+			out = append(out, e.Time)
+		}
+	}
+	return out
+}
+
+// MeanTimeBetweenDrifts is the uptime in a window divided by the drifts in
+// it; ok is false without a drift.
+func MeanTimeBetweenDrifts(entries []Entry, from, to time.Time) (mtbd time.Duration, ok bool) {
+	n := len(Drifts(entries, from, to))
+	if n == 0 {
+		return 0, false
+	}
+	return Measure(entries, from, to).Up / time.Duration(n), true
+}
+
+// Flapping reports whether the ship is flapping at now. Planned downtime
+// does not count as a switch.
+func Flapping(entries []Entry, now time.Time) (Flap, bool) {
+	var at []time.Time
+	for _, e := range switches(entries) {
+		at = append(at, e.Time)
+	}
+	if len(at) == 0 || now.Sub(at[len(at)-1]) > flapGap {
+		return Flap{}, false
+	}
+	first := len(at) - 1
+	for first > 0 && at[first].Sub(at[first-1]) <= flapGap {
+		first--
+	}
+	n := len(at) - first
+	if n < flapChanges {
+		return Flap{}, false
+	}
+	return Flap{Since: at[first], Changes: n}, true
+}
+
+var sparkLevels = []rune("▁▂▃▄▅▆▇█")
+
+// Sparkline draws values as a row of block characters scaled between their
+// minimum and maximum.
+func Sparkline(values []float64) string {
+	if len(values) == 0 {
+		return ""
+	}
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, v := range values {
+		lo, hi = math.Min(lo, v), math.Max(hi, v)
+	}
+	var b strings.Builder
+	for _, v := range values {
+		i := 0
+		if hi > lo {
+			i = int((v - lo) / (hi - lo) * float64(len(sparkLevels)-1))
+		}
+		b.WriteRune(sparkLevels[i])
+	}
+	return b.String()
+}
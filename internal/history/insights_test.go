@@ -0,0 +1,69 @@
+package history
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMeasure(t *testing.T) {
+	start := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	at := func(h int) time.Time { return start.Add(time.Duration(h) * time.Hour) }
+	entries := []Entry{
+		{Time: at(-1), Kind: KindCheck, Status: "online"},
+		{Time: at(1), Kind: KindCheck, Status: "drift"},
+		{Time: at(2), Kind: KindApply},
+		{Time: at(3), Kind: KindCheck, Status: "online"},
+		{Time: at(4), Kind: KindCheck, Status: "blinded"},
+		{Time: at(6), Kind: KindCheck, Status: "online"},
+	}
+	// Up 0-1, down 1-3, up 3-4, planned 4-6, up 6-10 (cut by to).
+	a := Measure(entries, start, at(10))
+	if a.Up != 6*time.Hour || a.Down != 2*time.Hour || a.Checks != 4 {
+		t.Fatalf("unexpected availability: %+v", a)
+	}
+	if pct, ok := a.Percent(); !ok || pct != 75 {
+		t.Fatalf("Percent = %v, %v", pct, ok)
+	}
+	if drifts := Drifts(entries, start, at(10)); len(drifts) != 1 || !drifts[0].Equal(at(1)) {
+		t.Fatalf("Drifts = %v", drifts)
+	}
+	if mtbd, ok := MeanTimeBetweenDrifts(entries, start, at(10)); !ok || mtbd != 6*time.Hour {
+		t.Fatalf("MeanTimeBetweenDrifts = %v, %v", mtbd, ok)
+	}
+	// Past MaxHold after the last check nothing is known.
+	if a := Measure(entries, at(20), at(30)); a.Up != 0 || a.Down != 0 {
+		t.Fatalf("a window without checks should measure nothing: %+v", a)
+	}
+}
+
+func TestFlapping(t *testing.T) {
+	start := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	at := func(h int) time.Time { return start.Add(time.Duration(h) * time.Hour) }
+	entries := []Entry{
+		{Time: at(0), Kind: KindCheck, Status: "online"},
+		{Time: at(10), Kind: KindCheck, Status: "unreachable"},
+		{Time: at(30), Kind: KindCheck, Status: "online"},
+		{Time: at(40), Kind: KindCheck, Status: "blinded"}, // planned, not a switch
+		{Time: at(45), Kind: KindCheck, Status: "online"},
+		{Time: at(50), Kind: KindCheck, Status: "drift"},
+	}
+	flap, ok := Flapping(entries, at(70))
+	if !ok || flap.Changes != 3 || !flap.Since.Equal(at(10)) {
+		t.Fatalf("Flapping = %+v, %v", flap, ok)
+	}
+	if _, ok := Flapping(entries, at(100)); ok {
+		t.Fatalf("a ship quiet for more than a day is not flapping")
+	}
+	if _, ok := Flapping(entries[:3], at(31)); ok {
+		t.Fatalf("two switches are not flapping yet")
+	}
+}
+
+func TestSparkline(t *testing.T) {
+	if got := Sparkline([]float64{1, 8, 4.5, 1}); got != "▁█▄▁" {
+		t.Fatalf("Sparkline = %q", got)
+	}
+	if got := Sparkline([]float64{3, 3}); got != "▁▁" {
+		t.Fatalf("flat Sparkline = %q", got)
+	}
+}
@@ -0,0 +1,274 @@
+// Package history keeps a per-ship log of what beammeup saw and did: status
+// checks with their round-trip time, applies, rotations and destroys. It
+// backs the history view, its sparklines, uptime figures and insights such
+// as a ship that keeps flapping.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alfaoz/beammeup/internal/hangar"
+	"github.com/alfaoz/beammeup/internal/ships"
+	"github.com/alfaoz/beammeup/internal/sshx"
+)
+
+const DefaultDirSuffix = ".beammeup/history"
+
+// What a ship's history keeps when nothing else is set: entries of the last
+// DefaultKeepDays days, and of those at most the newest DefaultMaxEntries.
+const (
+	DefaultKeepDays   = 180
+	DefaultMaxEntries = 10000
+)
+
+// Entry kinds.
+const (
+	KindCheck   = "check"    // a status check (inventory)
+	KindApply   = "apply"    // configure
+	KindRotate  = "rotate"   // configure with new credentials
+	KindDestroy = "destroy"  // the hangar (or an HTTP instance) was removed
+	KindHostKey = "host-key" // the server's SSH host key changed, or a new one was trusted
+)
+
+const (
+	// StatusUnreachable is recorded for a check that could not reach the
+	// server.
+	StatusUnreachable = "unreachable"
+	// StatusKeyChanged is recorded for a check refused because the host key
+	// changed, and for the host-key entry of the change itself.
+	StatusKeyChanged = "key-changed"
+	// StatusKeyTrusted is the host-key entry for a new key trusted by hand.
+	StatusKeyTrusted = "key-trusted"
+)
+
+// Entry is one line of a ship's history.
+type Entry struct {
+	Time      time.Time `json:"time"`
+	Kind      string    `json:"kind"`
+	Status    string    `json:"status,omitempty"`     // checks: the hangar status, or unreachable
+	LatencyMS int64     `json:"latency_ms,omitempty"` // checks: SSH round trip of the check
+	Error     string    `json:"error,omitempty"`      // the action or check failed
+
+	Fingerprint string `json:"fingerprint,omitempty"` // host-key entries: the new key
+}
+
+// Latency is the entry's round-trip time.
+func (e Entry) Latency() time.Duration {
+	return time.Duration(e.LatencyMS) * time.Millisecond
+}
+
+// Check builds the entry for a status check that took took.
+func Check(status hangar.Status, took time.Duration, err error) Entry {
+	e := Entry{Kind: KindCheck, Status: string(status), LatencyMS: took.Milliseconds()}
+	if err != nil {
+		e.Status, e.LatencyMS, e.Error = StatusUnreachable, 0, firstLine(err)
+		var hke *sshx.HostKeyError
+		if errors.As(err, &hke) && hke.Reason == "mismatch" {
+			e.Status = StatusKeyChanged
+		}
+	}
+	return e
+}
+
+// Action builds the entry for a finished hangar action; ok is false for
+// modes that change nothing (show, preflight and the like).
+func Action(in hangar.ActionInput, err error) (e Entry, ok bool) {
+	switch {
+	case in.Mode == "apply" && in.RotateCredentials:
+		e.Kind = KindRotate
+	case in.Mode == "apply":
+		e.Kind = KindApply
+	case in.Mode == "destroy":
+		e.Kind = KindDestroy
+	default:
+		return Entry{}, false
+	}
+	if err != nil {
+		e.Error = firstLine(err)
+	}
+	return e, true
+}
+
+func firstLine(err error) string {
+	msg, _, _ := strings.Cut(err.Error(), "\n")
+	return msg
+}
+
+type Store struct {
+	Dir        string
+	KeepDays   int // entries older than this many days are pruned; 0 uses DefaultKeepDays
+	MaxEntries int // at most this many entries are kept per ship; 0 uses DefaultMaxEntries
+
+	mu     sync.Mutex
+	pruned map[string]bool // ships pruned by this process
+}
+
+func NewStore(dir string) (*Store, error) {
+	if strings.TrimSpace(dir) == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("resolve home dir: %w", err)
+		}
+		dir = filepath.Join(home, DefaultDirSuffix)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("ensure history dir: %w", err)
+	}
+	return &Store{Dir: dir}, nil
+}
+
+func (s *Store) path(ship string) string {
+	return filepath.Join(s.Dir, ship+".history")
+}
+
+// Record appends e to ship's history, stamping it with the current time
+// unless it carries one. The first Record for a ship in each run prunes its
+// history first.
+func (s *Store) Record(ship string, e Entry) error {
+	ship = ships.SanitizeName(ship)
+	if ship == "" {
+		return errors.New("invalid ship name")
+	}
+	if err := s.pruneOnce(ship, time.Now()); err != nil {
+		return err
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.Time = e.Time.UTC().Truncate(time.Second)
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path(ship), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("open history: %w", err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("write history: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("write history: %w", err)
+	}
+	return nil
+}
+
+// pruneOnce prunes ship's history unless this process already did, so a
+// long history is rewritten once per run rather than on every entry.
+func (s *Store) pruneOnce(ship string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pruned[ship] {
+		return nil
+	}
+	if err := s.prune(ship, now); err != nil {
+		return err
+	}
+	if s.pruned == nil {
+		s.pruned = map[string]bool{}
+	}
+	s.pruned[ship] = true
+	return nil
+}
+
+// prune drops ship's entries older than KeepDays, then all but the newest
+// MaxEntries, the way the server's prune_backups keeps its snapshots in
+// check. Lines that do not parse go too; the file is only rewritten when
+// something was dropped.
+func (s *Store) prune(ship string, now time.Time) error {
+	keepDays, maxEntries := s.KeepDays, s.MaxEntries
+	if keepDays <= 0 {
+		keepDays = DefaultKeepDays
+	}
+	if maxEntries <= 0 {
+		maxEntries = DefaultMaxEntries
+	}
+	path := s.path(ship)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("read history: %w", err)
+	}
+	cutoff := now.AddDate(0, 0, -keepDays)
+	lines := strings.SplitAfter(strings.TrimSuffix(string(b), "\n"), "\n")
+	kept := make([]string, 0, len(lines))
+	for _, line := range lines {
+		var e Entry
+		if err := json.Unmarshal([]byte(line), &e); err != nil || e.Time.IsZero() || e.Kind == "" || e.Time.Before(cutoff) {
+			continue
+		}
+		kept = append(kept, strings.TrimSuffix(line, "\n")+"\n")
+	}
+	if len(kept) > maxEntries {
+		kept = kept[len(kept)-maxEntries:]
+	}
+	if len(kept) == len(lines) {
+		return nil
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(kept, "")), 0o600); err != nil {
+		return fmt.Errorf("prune history: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("prune history: %w", err)
+	}
+	return nil
+}
+
+// Load returns ship's entries from since on, oldest first. A zero since
+// returns everything; lines that do not parse are skipped.
+func (s *Store) Load(ship string, since time.Time) ([]Entry, error) {
+	ship = ships.SanitizeName(ship)
+	if ship == "" {
+		return nil, errors.New("invalid ship name")
+	}
+	f, err := os.Open(s.path(ship))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("open history: %w", err)
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || e.Time.IsZero() || e.Kind == "" {
+			continue
+		}
+		if e.Time.Before(since) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scan history: %w", err)
+	}
+	return entries, nil
+}
+
+// Delete removes ship's history.
+func (s *Store) Delete(ship string) error {
+	ship = ships.SanitizeName(ship)
+	if ship == "" {
+		return errors.New("invalid ship name")
+	}
+	if err := os.Remove(s.path(ship)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("delete history: %w", err)
+	}
+	return nil
+}
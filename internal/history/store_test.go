@@ -0,0 +1,90 @@
+package history
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alfaoz/beammeup/internal/hangar"
+)
+
+func TestRecordLoad(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	day := func(d int) time.Time { return time.Date(2026, 10, d, 12, 0, 0, 0, time.UTC) }
+	rotate, _ := Action(hangar.ActionInput{Mode: "apply", RotateCredentials: true}, nil)
+	for _, e := range []Entry{
+		{Time: day(1), Kind: KindCheck, Status: "online", LatencyMS: 420},
+		{Time: day(2), Kind: KindApply, Error: "apply failed"},
+		{Time: day(3), Kind: rotate.Kind},
+	} {
+		if err := store.Record("myship", e); err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+	}
+	// A line from a newer or broken writer is skipped, not fatal.
+	f, _ := os.OpenFile(store.path("myship"), os.O_APPEND|os.O_WRONLY, 0)
+	f.WriteString("not json\n")
+	f.Close()
+
+	all, err := store.Load("myship", time.Time{})
+	if err != nil || len(all) != 3 {
+		t.Fatalf("Load = %+v, %v", all, err)
+	}
+	if all[0].Latency() != 420*time.Millisecond || all[2].Kind != KindRotate {
+		t.Fatalf("unexpected entries: %+v", all)
+	}
+	recent, _ := store.Load("myship", day(2))
+	if len(recent) != 2 || recent[0].Kind != KindApply {
+		t.Fatalf("Load since day 2 = %+v", recent)
+	}
+}
+
+func TestRecordPrunes(t *testing.T) {
+	dir := t.TempDir()
+	old, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	now := time.Now().UTC()
+	for _, e := range []Entry{
+		{Time: now.AddDate(0, 0, -40), Kind: KindApply},
+		{Time: now.AddDate(0, 0, -3), Kind: KindCheck, Status: "online"},
+		{Time: now.AddDate(0, 0, -2), Kind: KindCheck, Status: "online"},
+		{Time: now.AddDate(0, 0, -1), Kind: KindCheck, Status: "drift"},
+	} {
+		if err := old.Record("myship", e); err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+	}
+
+	// The next run keeps 30 days and at most two entries before adding one.
+	store := &Store{Dir: dir, KeepDays: 30, MaxEntries: 2}
+	if err := store.Record("myship", Entry{Kind: KindRotate}); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	all, err := store.Load("myship", time.Time{})
+	if err != nil || len(all) != 3 {
+		t.Fatalf("Load = %+v, %v", all, err)
+	}
+	if !all[0].Time.Equal(now.AddDate(0, 0, -2).Truncate(time.Second)) || all[1].Status != "drift" || all[2].Kind != KindRotate {
+		t.Fatalf("pruned the wrong entries: %+v", all)
+	}
+}
+
+func TestCheckEntry(t *testing.T) {
+	e := Check(hangar.StatusOnline, 1500*time.Millisecond, nil)
+	if e.Status != "online" || e.LatencyMS != 1500 {
+		t.Fatalf("unexpected check: %+v", e)
+	}
+	e = Check("", time.Second, errors.New("dial tcp: i/o timeout\nmore"))
+	if e.Status != StatusUnreachable || e.Error != "dial tcp: i/o timeout" || e.LatencyMS != 0 {
+		t.Fatalf("unexpected failed check: %+v", e)
+	}
+	if _, ok := Action(hangar.ActionInput{Mode: "show"}, nil); ok {
+		t.Fatalf("show should not be recorded")
+	}
+}
@@ -0,0 +1,150 @@
+// Package hostkey treats a changed SSH host key as a security event rather
+// than a connection error: every ship on that server is flagged, the change
+// goes into their history and out to the notifiers, and nothing connects to
+// them again until the new key is trusted explicitly.
+package hostkey
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/alfaoz/beammeup/internal/history"
+	"github.com/alfaoz/beammeup/internal/notify"
+	"github.com/alfaoz/beammeup/internal/ships"
+	"github.com/alfaoz/beammeup/internal/sshx"
+	"golang.org/x/crypto/ssh"
+)
+
+// EventChanged is the notification sent when a ship's host key changes.
+const EventChanged = "ssh.host-key-changed"
+
+// ChangedError refuses to reach a ship whose host key changed.
+type ChangedError struct {
+	Ship        string
+	Fingerprint string
+}
+
+func (e *ChangedError) Error() string {
+	return fmt.Sprintf("SSH host key of %s changed (now %s) and is not trusted. Make sure the server was rebuilt or re-keyed, then run: beammeup trust-host --ship %s", e.Ship, e.Fingerprint, e.Ship)
+}
+
+// Check returns a *ChangedError while ship carries an untrusted key.
+func Check(ship ships.Ship) error {
+	if ship.HostKeyChanged == "" {
+		return nil
+	}
+	return &ChangedError{Ship: ship.Name, Fingerprint: ship.HostKeyChanged}
+}
+
+// Polls of several ships report changes concurrently; ship files are
+// rewritten whole, so one change is handled at a time.
+var mu sync.Mutex
+
+// Guard flags the ships behind a changed key. History and Notifier are
+// optional.
+type Guard struct {
+	Store    *ships.Store
+	History  *history.Store
+	Notifier notify.Notifier
+}
+
+// Changed is the sshx.ConnectOptions.HostKeyChanged hook. A change already
+// flagged with the same fingerprint is not reported again.
+func (g *Guard) Changed(t sshx.Target, e *sshx.HostKeyError) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, ship := range g.shipsOn(t) {
+		if ship.HostKeyChanged == e.Fingerprint {
+			continue
+		}
+		ship.HostKeyChanged = e.Fingerprint
+		if _, err := g.Store.Save(ship); err != nil {
+			fmt.Fprintf(os.Stderr, "[beammeup] WARNING: could not flag the changed host key on %s: %v\n", ship.Name, err)
+		}
+		fmt.Fprintf(os.Stderr, "[beammeup] WARNING: SSH host key of %s (%s) changed to %s. Connections to it are refused until you run: beammeup trust-host --ship %s\n", ship.Name, ship.Host, e.Fingerprint, ship.Name)
+		g.record(ship.Name, history.StatusKeyChanged, e.Fingerprint)
+		if g.Notifier == nil {
+			continue
+		}
+		ev := notify.Event{
+			Type:     EventChanged,
+			Ship:     ship.Name,
+			Message:  fmt.Sprintf("ship %s: SSH host key changed to %s; beammeup will not connect until it is trusted", ship.Name, e.Fingerprint),
+			Critical: true,
+			Fields: map[string]string{
+				"host":        ship.Host,
+				"fingerprint": e.Fingerprint,
+				"known":       strings.Join(e.Known, ","),
+			},
+		}
+		if err := g.Notifier.Notify(ev); err != nil {
+			fmt.Fprintf(os.Stderr, "[beammeup] WARNING: notification failed: %v\n", err)
+		}
+	}
+}
+
+// Trust makes key the trusted host key of ship's server and clears the flag
+// on every ship there.
+func (g *Guard) Trust(ship ships.Ship, opts sshx.ConnectOptions, key ssh.PublicKey) error {
+	t := target(ship)
+	if err := sshx.TrustHostKey(t, opts, key); err != nil {
+		return fmt.Errorf("update known_hosts: %w", err)
+	}
+	fp := ssh.FingerprintSHA256(key)
+	mu.Lock()
+	defer mu.Unlock()
+	// A host with no saved ship (--host) only has known_hosts to fix.
+	on := g.shipsOn(t)
+	for _, s := range on {
+		if s.HostKeyChanged != "" {
+			s.HostKeyChanged = ""
+			if _, err := g.Store.Save(s); err != nil {
+				return err
+			}
+		}
+		g.record(s.Name, history.StatusKeyTrusted, fp)
+	}
+	return nil
+}
+
+// shipsOn returns the saved ships that connect to t's server.
+func (g *Guard) shipsOn(t sshx.Target) []ships.Ship {
+	if g.Store == nil {
+		return nil
+	}
+	names, err := g.Store.List()
+	if err != nil {
+		return nil
+	}
+	var out []ships.Ship
+	for _, name := range names {
+		ship, err := g.Store.Load(name)
+		if err == nil && strings.EqualFold(ship.Host, t.Host) && port(ship.SSHPort) == port(t.Port) {
+			out = append(out, ship)
+		}
+	}
+	return out
+}
+
+func (g *Guard) record(ship, status, fingerprint string) {
+	if g.History == nil {
+		return
+	}
+	e := history.Entry{Kind: history.KindHostKey, Status: status, Fingerprint: fingerprint}
+	if err := g.History.Record(ship, e); err != nil {
+		fmt.Fprintf(os.Stderr, "[beammeup] WARNING: could not record status history: %v\n", err)
+	}
+}
+
+func target(ship ships.Ship) sshx.Target {
+	return sshx.Target{Host: ship.Host, Port: ship.SSHPort, User: ship.SSHUser}
+}
+
+func port(p int) int {
+	if p == 0 {
+		return 22
+	}
+	return p
+}
@@ -0,0 +1,90 @@
+package hostkey
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alfaoz/beammeup/internal/history"
+	"github.com/alfaoz/beammeup/internal/notify"
+	"github.com/alfaoz/beammeup/internal/ships"
+	"github.com/alfaoz/beammeup/internal/sshx"
+	"golang.org/x/crypto/ssh"
+)
+
+type recorder []notify.Event
+
+func (r *recorder) Notify(e notify.Event) error {
+	*r = append(*r, e)
+	return nil
+}
+
+func TestChangedAndTrust(t *testing.T) {
+	dir := t.TempDir()
+	store, err := ships.NewStore(filepath.Join(dir, "ships"))
+	if err != nil {
+		t.Fatalf("ships.NewStore: %v", err)
+	}
+	hist, err := history.NewStore(filepath.Join(dir, "history"))
+	if err != nil {
+		t.Fatalf("history.NewStore: %v", err)
+	}
+	for _, s := range []ships.Ship{
+		{Name: "alpha", Host: "192.0.2.10", SSHUser: "root", SSHPort: 22},
+		{Name: "beta", Host: "192.0.2.10", SSHUser: "deploy", SSHPort: 22},
+		{Name: "gamma", Host: "192.0.2.10", SSHUser: "root", SSHPort: 2222},
+	} {
+		if _, err := store.Save(s); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+	}
+	var sent recorder
+	g := &Guard{Store: store, History: hist, Notifier: &sent}
+	e := &sshx.HostKeyError{Addr: "192.0.2.10:22", Reason: "mismatch", Fingerprint: "SHA256:new", Known: []string{"SHA256:old"}}
+	g.Changed(sshx.Target{Host: "192.0.2.10"}, e)
+	g.Changed(sshx.Target{Host: "192.0.2.10", Port: 22}, e)
+
+	alpha, _ := store.Load("alpha")
+	var ce *ChangedError
+	if err := Check(alpha); !errors.As(err, &ce) || ce.Fingerprint != "SHA256:new" {
+		t.Fatalf("alpha not flagged: %v", err)
+	}
+	if gamma, _ := store.Load("gamma"); Check(gamma) != nil {
+		t.Fatal("a ship on another port must not be flagged")
+	}
+	if len(sent) != 2 || !sent[0].Critical || sent[0].Type != EventChanged {
+		t.Fatalf("expected one critical event per ship, got %+v", sent)
+	}
+	entries, _ := hist.Load("beta", time.Time{})
+	if len(entries) != 1 || entries[0].Status != history.StatusKeyChanged {
+		t.Fatalf("unexpected history: %+v", entries)
+	}
+
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	key, _ := ssh.NewPublicKey(priv.Public())
+	known := filepath.Join(dir, "known_hosts")
+	if err := os.WriteFile(known, []byte("192.0.2.10 ssh-ed25519 AAAAold\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Trust(alpha, sshx.ConnectOptions{KnownHostsPath: known}, key); err != nil {
+		t.Fatalf("Trust: %v", err)
+	}
+	for _, name := range []string{"alpha", "beta"} {
+		if s, _ := store.Load(name); Check(s) != nil {
+			t.Fatalf("%s still flagged after Trust", name)
+		}
+	}
+	b, _ := os.ReadFile(known)
+	if strings.Contains(string(b), "AAAAold") || !strings.Contains(string(b), "192.0.2.10 ssh-ed25519 ") {
+		t.Fatalf("known_hosts not updated:\n%s", b)
+	}
+	entries, _ = hist.Load("beta", time.Time{})
+	if len(entries) != 2 || entries[1].Status != history.StatusKeyTrusted {
+		t.Fatalf("trust not recorded: %+v", entries)
+	}
+}
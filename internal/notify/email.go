@@ -0,0 +1,137 @@
+package notify
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSMTPPort = 587
+	smtpTimeout     = 30 * time.Second
+)
+
+// Email mails events over SMTP. Only critical events go out unless All is
+// set: a mailbox is for what needs a human, warnings are better left to the
+// webhook.
+type Email struct {
+	Host string
+	Port int // 465 is implicit TLS; others use STARTTLS when the server offers it
+	User string
+	Pass string
+	From string
+	To   []string
+	All  bool
+}
+
+func (e *Email) Notify(ev Event) error {
+	if !ev.Critical && !e.All {
+		return nil
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+	if err := e.send(e.message(ev)); err != nil {
+		return fmt.Errorf("email: %w", err)
+	}
+	return nil
+}
+
+// message renders ev as a plain-text mail.
+func (e *Email) message(ev Event) []byte {
+	subject := "[beammeup] " + ev.Type
+	if ev.Ship != "" {
+		subject = "[beammeup] " + ev.Ship + ": " + ev.Type
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: beammeup <%s>\r\n", headerSafe(e.From))
+	fmt.Fprintf(&b, "To: %s\r\n", headerSafe(strings.Join(e.To, ", ")))
+	fmt.Fprintf(&b, "Subject: %s\r\n", headerSafe(subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", ev.Time.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&b, "%s\r\n\r\n", ev.Message)
+	fmt.Fprintf(&b, "type: %s\r\n", ev.Type)
+	if ev.Ship != "" {
+		fmt.Fprintf(&b, "ship: %s\r\n", ev.Ship)
+	}
+	fmt.Fprintf(&b, "time: %s\r\n", ev.Time.UTC().Format(time.RFC3339))
+	keys := make([]string, 0, len(ev.Fields))
+	for k := range ev.Fields {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s: %s\r\n", k, ev.Fields[k])
+	}
+	return []byte(b.String())
+}
+
+// headerSafe keeps a value on one header line.
+func headerSafe(v string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(v)
+}
+
+func (e *Email) send(msg []byte) error {
+	port := e.Port
+	if port <= 0 {
+		port = defaultSMTPPort
+	}
+	addr := net.JoinHostPort(e.Host, strconv.Itoa(port))
+	tlsConfig := &tls.Config{ServerName: e.Host}
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+	var conn net.Conn
+	var err error
+	if port == 465 {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+	c, err := smtp.NewClient(conn, e.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if port != 465 {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(tlsConfig); err != nil {
+				return err
+			}
+		}
+	}
+	if e.User != "" {
+		// PlainAuth refuses to send the password unencrypted to anything but
+		// localhost.
+		if err := c.Auth(smtp.PlainAuth("", e.User, e.Pass, e.Host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(e.From); err != nil {
+		return err
+	}
+	for _, to := range e.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
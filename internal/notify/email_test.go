@@ -0,0 +1,102 @@
+package notify
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEmailMessage(t *testing.T) {
+	e := &Email{From: "alerts@example.com", To: []string{"a@example.com", "b@example.com"}}
+	msg := string(e.message(Event{
+		Type:    "traffic.quota-exceeded",
+		Ship:    "fra\r\nBcc: x@example.com",
+		Message: "over quota",
+		Time:    time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC),
+		Fields:  map[string]string{"source": "vnstat", "month": "2026-03"},
+	}))
+	for _, want := range []string{
+		"To: a@example.com, b@example.com\r\n",
+		"Subject: [beammeup] fra  Bcc: x@example.com: traffic.quota-exceeded\r\n",
+		"\r\n\r\nover quota\r\n",
+		"month: 2026-03\r\nsource: vnstat\r\n",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Fatalf("message missing %q:\n%s", want, msg)
+		}
+	}
+}
+
+func TestEmailSendsCriticalOnly(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	got := make(chan string, 2)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			serveSMTP(conn, got)
+		}
+	}()
+
+	port, _ := strconv.Atoi(strings.TrimPrefix(ln.Addr().String(), "127.0.0.1:"))
+	e := &Email{Host: "127.0.0.1", Port: port, From: "alerts@example.com", To: []string{"me@example.com"}}
+	if err := e.Notify(Event{Type: "traffic.quota-warning", Ship: "fra"}); err != nil {
+		t.Fatalf("warning: %v", err)
+	}
+	if err := e.Notify(Event{Type: "traffic.quota-exceeded", Ship: "fra", Message: "over quota", Critical: true}); err != nil {
+		t.Fatalf("critical: %v", err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("expected one mail, got %d", len(got))
+	}
+	if msg := <-got; !strings.Contains(msg, "Subject: [beammeup] fra: traffic.quota-exceeded") {
+		t.Fatalf("unexpected mail:\n%s", msg)
+	}
+}
+
+// serveSMTP answers one plain SMTP session and sends the mail body to got.
+func serveSMTP(conn net.Conn, got chan<- string) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+	reply("220 test")
+	var data strings.Builder
+	inData := false
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		if inData {
+			if line == ".\r\n" {
+				inData = false
+				got <- data.String()
+				reply("250 ok")
+			} else {
+				data.WriteString(line)
+			}
+			continue
+		}
+		switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+		case strings.HasPrefix(cmd, "EHLO"):
+			reply("250 test")
+		case cmd == "DATA":
+			inData = true
+			reply("354 go on")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
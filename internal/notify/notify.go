@@ -0,0 +1,102 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alfaoz/beammeup/internal/config"
+)
+
+// Event is a user-facing alert about a ship.
+type Event struct {
+	Type    string            `json:"type"`
+	Ship    string            `json:"ship,omitempty"`
+	Message string            `json:"message"`
+	Time    time.Time         `json:"time"`
+	Fields  map[string]string `json:"fields,omitempty"`
+	// Critical marks events that need a human now, such as an exceeded
+	// quota; only these are emailed by default.
+	Critical bool `json:"critical,omitempty"`
+}
+
+// Notifier delivers events to an external channel.
+type Notifier interface {
+	Notify(ev Event) error
+}
+
+// FromConfig returns the notifiers configured in cfg, or nil when none is set.
+func FromConfig(cfg config.Config) Notifier {
+	var all Multi
+	if url := strings.TrimSpace(cfg.WebhookURL); url != "" {
+		all = append(all, &Webhook{URL: url})
+	}
+	if cfg.SMTPHost != "" && len(cfg.EmailTo) > 0 {
+		from := cfg.EmailFrom
+		if from == "" {
+			from = cfg.SMTPUser
+		}
+		all = append(all, &Email{
+			Host: cfg.SMTPHost,
+			Port: cfg.SMTPPort,
+			User: cfg.SMTPUser,
+			Pass: cfg.SMTPPass,
+			From: from,
+			To:   cfg.EmailTo,
+			All:  cfg.EmailAll,
+		})
+	}
+	switch len(all) {
+	case 0:
+		return nil
+	case 1:
+		return all[0]
+	}
+	return all
+}
+
+// Multi delivers every event to each notifier in turn.
+type Multi []Notifier
+
+func (m Multi) Notify(ev Event) error {
+	var errs []error
+	for _, n := range m {
+		if err := n.Notify(ev); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Webhook POSTs events as JSON to URL.
+type Webhook struct {
+	URL    string
+	Client *http.Client
+}
+
+func (w *Webhook) Notify(ev Event) error {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("encode webhook event: %w", err)
+	}
+	client := w.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Post(w.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook: unexpected status %s", resp.Status)
+	}
+	return nil
+}
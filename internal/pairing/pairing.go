@@ -0,0 +1,171 @@
+// Package pairing serves the current proxy to a companion browser extension:
+// a token-protected JSON endpoint on localhost that the extension polls, so
+// switching ships in beammeup switches the browser too.
+package pairing
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Path is where the state is served.
+const Path = "/v1/state"
+
+// Statuses reported in State.
+const (
+	StatusReady         = "ready"
+	StatusNoShip        = "no-ship"        // no ship has been used yet
+	StatusNoCredentials = "no-credentials" // the ship has no stored result card
+)
+
+// State is what the extension sees on every poll.
+type State struct {
+	Ship     string    `json:"ship,omitempty"`
+	Status   string    `json:"status"`
+	Protocol string    `json:"protocol,omitempty"` // http|socks5
+	Host     string    `json:"host,omitempty"`
+	Port     string    `json:"port,omitempty"`
+	User     string    `json:"user,omitempty"`
+	Pass     string    `json:"pass,omitempty"`
+	Tunnel   string    `json:"tunnel,omitempty"` // socks5://addr of a running stealth tunnel for the ship
+	Updated  time.Time `json:"updated,omitzero"` // when the credentials were stored
+}
+
+// stateTTL bounds how often state is recomputed however fast the extension
+// polls; reading a result card means decrypting it.
+const stateTTL = 2 * time.Second
+
+// Server answers the extension. Requests need the pairing token as a bearer
+// token and a loopback Host header, which keeps web pages from reaching the
+// endpoint through DNS rebinding.
+type Server struct {
+	token string
+	state func() State
+
+	mu     sync.Mutex
+	cached []byte
+	etag   string
+	at     time.Time
+}
+
+// NewServer serves what state returns to clients presenting token.
+func NewServer(token string, state func() State) *Server {
+	return &Server{token: token, state: state}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !loopbackHost(r.Host) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if origin := r.Header.Get("Origin"); strings.HasPrefix(origin, "chrome-extension://") || strings.HasPrefix(origin, "moz-extension://") {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Vary", "Origin")
+	}
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Methods", "GET")
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, If-None-Match")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.URL.Path != Path {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+		// Slow down guessing.
+		time.Sleep(500 * time.Millisecond)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	body, etag, err := s.current()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// current returns the encoded state and its ETag, recomputing them at most
+// once per stateTTL.
+func (s *Server) current() ([]byte, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cached != nil && time.Since(s.at) < stateTTL {
+		return s.cached, s.etag, nil
+	}
+	body, err := json.Marshal(s.state())
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(body)
+	s.cached, s.etag, s.at = body, `"`+hex.EncodeToString(sum[:8])+`"`, time.Now()
+	return s.cached, s.etag, nil
+}
+
+func loopbackHost(hostport string) bool {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && ip.IsLoopback()
+}
+
+// tokenFile holds the pairing token in the config dir.
+const tokenFile = "pair-token"
+
+// Token returns the pairing token kept in dir, creating one on first use or
+// when renew is set. Renewing unpairs every extension paired before.
+func Token(dir string, renew bool) (string, error) {
+	path := filepath.Join(dir, tokenFile)
+	if !renew {
+		b, err := os.ReadFile(path)
+		if err == nil && len(strings.TrimSpace(string(b))) > 0 {
+			return strings.TrimSpace(string(b)), nil
+		}
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("read pairing token: %w", err)
+		}
+	}
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(raw)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, []byte(token+"\n"), 0o600); err != nil {
+		return "", fmt.Errorf("write pairing token: %w", err)
+	}
+	return token, nil
+}
@@ -0,0 +1,74 @@
+package pairing
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServer(t *testing.T) {
+	srv := NewServer("secret", func() State {
+		return State{Ship: "myship", Status: StatusReady, Protocol: "socks5", Host: "192.0.2.10", Port: "1080", User: "u", Pass: "p"}
+	})
+	get := func(host, token, etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "http://"+host+Path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get("127.0.0.1:8765", "", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("without a token: %d", rec.Code)
+	}
+	if rec := get("127.0.0.1:8765", "wrong", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("with a wrong token: %d", rec.Code)
+	}
+	if rec := get("evil.example.com:8765", "secret", ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("with a rebound host name: %d", rec.Code)
+	}
+
+	rec := get("localhost:8765", "secret", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("paired request: %d %s", rec.Code, rec.Body)
+	}
+	var st State
+	if err := json.Unmarshal(rec.Body.Bytes(), &st); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if st.Ship != "myship" || st.Pass != "p" || st.Status != StatusReady {
+		t.Fatalf("unexpected state: %+v", st)
+	}
+	etag := rec.Header().Get("ETag")
+	if rec := get("127.0.0.1:8765", "secret", etag); rec.Code != http.StatusNotModified {
+		t.Fatalf("unchanged state with If-None-Match: %d", rec.Code)
+	}
+
+	pre := httptest.NewRequest(http.MethodOptions, "http://127.0.0.1:8765"+Path, nil)
+	pre.Header.Set("Origin", "moz-extension://abc")
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, pre)
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Access-Control-Allow-Origin") != "moz-extension://abc" {
+		t.Fatalf("preflight: %d %v", rec.Code, rec.Header())
+	}
+}
+
+func TestToken(t *testing.T) {
+	dir := t.TempDir()
+	first, err := Token(dir, false)
+	if err != nil || len(first) != 48 {
+		t.Fatalf("Token = %q, %v", first, err)
+	}
+	if again, _ := Token(dir, false); again != first {
+		t.Fatalf("token changed without renew: %q != %q", again, first)
+	}
+	if renewed, _ := Token(dir, true); renewed == first {
+		t.Fatalf("renew kept the old token")
+	}
+}
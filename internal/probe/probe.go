@@ -0,0 +1,98 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/alfaoz/beammeup/internal/tunnel"
+)
+
+// DefaultIPEchoURL returns the caller's public IPv4 as plain text.
+const DefaultIPEchoURL = "https://api.ipify.org"
+
+// ErrAuthRejected is returned when the proxy refuses the supplied
+// credentials (HTTP 407 or SOCKS5 auth failure).
+var ErrAuthRejected = errors.New("proxy rejected credentials")
+
+// Endpoint describes a hangar proxy as seen from the client.
+type Endpoint struct {
+	Protocol string // http|socks5
+	Host     string
+	Port     string
+	User     string
+	Pass     string
+}
+
+func (e Endpoint) addr() string { return net.JoinHostPort(e.Host, e.Port) }
+
+// ExternalIP performs a credentialed request through the proxy to echoURL and
+// returns the body (the exit IP). dial reaches the proxy itself; nil means a
+// direct TCP connection.
+func ExternalIP(ep Endpoint, dial tunnel.DialFunc, echoURL string, timeout time.Duration) (string, error) {
+	if dial == nil {
+		dial = func(network, addr string) (net.Conn, error) {
+			return net.DialTimeout(network, addr, timeout)
+		}
+	}
+	if strings.TrimSpace(echoURL) == "" {
+		echoURL = DefaultIPEchoURL
+	}
+
+	tr := &http.Transport{
+		DisableKeepAlives:   true,
+		TLSHandshakeTimeout: timeout,
+	}
+	switch strings.ToLower(ep.Protocol) {
+	case "http":
+		proxyURL := &url.URL{Scheme: "http", Host: ep.addr()}
+		if ep.User != "" {
+			proxyURL.User = url.UserPassword(ep.User, ep.Pass)
+		}
+		tr.Proxy = http.ProxyURL(proxyURL)
+		tr.DialContext = func(_ context.Context, network, addr string) (net.Conn, error) {
+			return dial(network, addr)
+		}
+	case "socks5":
+		tr.DialContext = func(_ context.Context, _ string, addr string) (net.Conn, error) {
+			return tunnel.DialSOCKS5(dial, ep.addr(), ep.User, ep.Pass, addr)
+		}
+	default:
+		return "", fmt.Errorf("unsupported proxy protocol %q", ep.Protocol)
+	}
+
+	client := &http.Client{Transport: tr, Timeout: timeout}
+	resp, err := client.Get(echoURL)
+	if err != nil {
+		if isAuthError(err) {
+			return "", ErrAuthRejected
+		}
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusProxyAuthRequired {
+		return "", ErrAuthRejected
+	}
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("echo request failed: %s", resp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func isAuthError(err error) bool {
+	if errors.Is(err, tunnel.ErrSOCKSAuthRejected) {
+		return true
+	}
+	v := err.Error()
+	return strings.Contains(v, "Proxy Authentication Required") || strings.Contains(v, "407")
+}
@@ -0,0 +1,78 @@
+package probe
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestExternalIPHTTPProxy(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := proxyBasicAuth(r)
+		if !ok || user != "beam" || pass != "secret" {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		w.Write([]byte("203.0.113.7\n"))
+	}))
+	defer proxy.Close()
+
+	u, _ := url.Parse(proxy.URL)
+	host, port, _ := net.SplitHostPort(u.Host)
+
+	ip, err := ExternalIP(Endpoint{Protocol: "http", Host: host, Port: port, User: "beam", Pass: "secret"}, nil, "http://echo.example.invalid/", 5*time.Second)
+	if err != nil {
+		t.Fatalf("ExternalIP: %v", err)
+	}
+	if ip != "203.0.113.7" {
+		t.Fatalf("unexpected ip %q", ip)
+	}
+
+	_, err = ExternalIP(Endpoint{Protocol: "http", Host: host, Port: port, User: "beam", Pass: "old"}, nil, "http://echo.example.invalid/", 5*time.Second)
+	if err != ErrAuthRejected {
+		t.Fatalf("expected ErrAuthRejected, got %v", err)
+	}
+}
+
+func proxyBasicAuth(r *http.Request) (string, string, bool) {
+	h := r.Header.Get("Proxy-Authorization")
+	if h == "" {
+		return "", "", false
+	}
+	req := &http.Request{Header: http.Header{"Authorization": []string{h}}}
+	return req.BasicAuth()
+}
+
+func TestReach(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, _, ok := proxyBasicAuth(r); !ok {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		w.Write([]byte("203.0.113.7\n"))
+	}))
+	defer proxy.Close()
+	u, _ := url.Parse(proxy.URL)
+	host, port, _ := net.SplitHostPort(u.Host)
+	ep := Endpoint{Protocol: "http", Host: host, Port: port, User: "beam", Pass: "secret"}
+
+	if got := Reach(ep, true, "http://echo.example.invalid/", 5*time.Second); got.State != Reachable || got.Detail != "authenticated request ok" {
+		t.Fatalf("authenticated = %+v", got)
+	}
+	if got := Reach(ep, false, "", 5*time.Second); got.State != Reachable || got.Detail != "port open" {
+		t.Fatalf("connect only = %+v", got)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	_, closedPort, _ := net.SplitHostPort(l.Addr().String())
+	l.Close()
+	if got := Reach(Endpoint{Protocol: "http", Host: "127.0.0.1", Port: closedPort}, true, "", 5*time.Second); got.State != Unreachable {
+		t.Fatalf("closed port = %+v", got)
+	}
+}
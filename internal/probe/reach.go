@@ -0,0 +1,68 @@
+package probe
+
+import (
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"time"
+)
+
+// Outcomes of Reach.
+const (
+	Reachable   = "yes"
+	Unreachable = "no"      // refused or no route: nothing answers on the port
+	Blocked     = "blocked" // dropped or reset on the way: a filter between here and the server
+)
+
+// Reachability is what the client machine sees of a proxy port.
+type Reachability struct {
+	State  string // yes, no or blocked
+	Detail string
+}
+
+func (r Reachability) String() string {
+	if r.Detail == "" {
+		return r.State
+	}
+	return r.State + " (" + r.Detail + ")"
+}
+
+// Reach connects from this machine to the proxy port and, with auth set and
+// credentials on ep, sends a request through it to echoURL. A timed-out
+// connect is reported as blocked: providers and networks that filter ports
+// drop the packets rather than refuse them.
+func Reach(ep Endpoint, auth bool, echoURL string, timeout time.Duration) Reachability {
+	conn, err := net.DialTimeout("tcp", ep.addr(), timeout)
+	if err != nil {
+		return dialOutcome(err)
+	}
+	conn.Close()
+	if !auth || ep.User == "" || ep.Pass == "" {
+		return Reachability{State: Reachable, Detail: "port open"}
+	}
+
+	_, err = ExternalIP(ep, nil, echoURL, timeout)
+	switch {
+	case err == nil:
+		return Reachability{State: Reachable, Detail: "authenticated request ok"}
+	case errors.Is(err, ErrAuthRejected):
+		return Reachability{State: Reachable, Detail: "port open, but the proxy rejected the credentials"}
+	case errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		return Reachability{State: Blocked, Detail: "port open, but the connection is cut once the proxy protocol starts"}
+	}
+	return Reachability{State: Reachable, Detail: "port open, but the request through it failed: " + err.Error()}
+}
+
+func dialOutcome(err error) Reachability {
+	var ne net.Error
+	switch {
+	case errors.As(err, &ne) && ne.Timeout():
+		return Reachability{State: Blocked, Detail: "connection attempt timed out"}
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return Reachability{State: Unreachable, Detail: "connection refused"}
+	case errors.Is(err, syscall.ECONNRESET):
+		return Reachability{State: Blocked, Detail: "connection reset"}
+	}
+	return Reachability{State: Unreachable, Detail: err.Error()}
+}
@@ -1,6 +1,24 @@
 package remote
 
-// Script is uploaded and executed on the target server.
+// Payload is Script wrapped in a command group for "bash -s", with the
+// built-in unit templates rendered in front of it. bash parses the whole
+// group before running any of it, so a command inside the script that reads
+// stdin cannot swallow the rest of the script.
+func Payload() string {
+	units, err := RenderTemplates("")
+	if err != nil {
+		// The built-in templates are covered by tests.
+		panic(err)
+	}
+	return PayloadWith(units)
+}
+
+// PayloadWith is Payload with units from RenderTemplates.
+func PayloadWith(units map[string]string) string {
+	return "{\n" + templateFunc(units) + Script + "\n}\n"
+}
+
+// Script is piped to bash on the target server.
 const Script = `#!/usr/bin/env bash
 set -euo pipefail
 
@@ -13,6 +31,14 @@ die() {
   exit 1
 }
 
+# install_unit writes a systemd unit the client rendered from
+# internal/remote/templates (see bm_template in the payload) to dest.
+install_unit() {
+  local name="$1" dest="$2"
+  bm_template "$name" | base64 -d >"$dest" || die "unit template $name is missing from the payload."
+  chmod 644 "$dest"
+}
+
 is_valid_port() {
   local port="$1"
   [[ "$port" =~ ^[0-9]+$ ]] || return 1
@@ -141,7 +167,7 @@ ensure_requirements() {
 ensure_packages() {
   local install_needed=0
   local pkg
-  local log_file="/tmp/beammeup-install.log"
+  local log_file="${REMOTE_TMPDIR}/beammeup-install.log"
 
   for pkg in "$@"; do
     if ! dpkg -s "$pkg" >/dev/null 2>&1; then
@@ -207,6 +233,208 @@ cleanup_firewall_rule() {
   fi
 }
 
+default_iface() {
+  ip route show default 2>/dev/null | awk '{for (i = 1; i <= NF; i++) if ($i == "dev") { print $(i + 1); exit }}'
+}
+
+ensure_traffic_accounting() {
+  command -v iptables >/dev/null 2>&1 || return 1
+  if ! iptables -nL "$ACCT_IN_CHAIN" >/dev/null 2>&1; then
+    iptables -N "$ACCT_IN_CHAIN" || return 1
+    iptables -A "$ACCT_IN_CHAIN" ! -i lo -j RETURN || return 1
+  fi
+  if ! iptables -nL "$ACCT_OUT_CHAIN" >/dev/null 2>&1; then
+    iptables -N "$ACCT_OUT_CHAIN" || return 1
+    iptables -A "$ACCT_OUT_CHAIN" ! -o lo -j RETURN || return 1
+  fi
+  iptables -C INPUT -j "$ACCT_IN_CHAIN" >/dev/null 2>&1 || iptables -I INPUT 1 -j "$ACCT_IN_CHAIN" || return 1
+  iptables -C OUTPUT -j "$ACCT_OUT_CHAIN" >/dev/null 2>&1 || iptables -I OUTPUT 1 -j "$ACCT_OUT_CHAIN" || return 1
+}
+
+read_chain_bytes() {
+  iptables -nvxL "$1" 2>/dev/null | awk 'NR == 3 { print $2; exit }'
+}
+
+cleanup_traffic_accounting() {
+  command -v iptables >/dev/null 2>&1 || return 0
+  local chain parent
+  for chain in "$ACCT_IN_CHAIN" "$ACCT_OUT_CHAIN"; do
+    parent="INPUT"
+    [[ "$chain" == "$ACCT_OUT_CHAIN" ]] && parent="OUTPUT"
+    while iptables -D "$parent" -j "$chain" >/dev/null 2>&1; do :; done
+    iptables -F "$chain" >/dev/null 2>&1 || true
+    iptables -X "$chain" >/dev/null 2>&1 || true
+  done
+}
+
+# limits_key names the hangar a limit belongs to: socks5, http, or
+# http-<instance>. Each hangar has its own port and credential.
+limits_key() {
+  if [[ "$1" == "socks5" ]]; then
+    echo "socks5"
+  else
+    echo "http${HTTP_INSTANCE:+-$HTTP_INSTANCE}"
+  fi
+}
+
+# read_limits prints "<max-conns> <rate-per-minute>" for a hangar, 0 for
+# no limit.
+read_limits() {
+  local key="$1" k port conns rate
+  if [[ -f "$LIMITS_CONF" ]]; then
+    while read -r k port conns rate; do
+      if [[ "$k" == "$key" ]]; then
+        echo "${conns:-0} ${rate:-0}"
+        return
+      fi
+    done <"$LIMITS_CONF"
+  fi
+  echo "0 0"
+}
+
+disable_limits() {
+  if service_defined "$LIMITS_SERVICE"; then
+    systemctl disable --now "$LIMITS_SERVICE" >/dev/null 2>&1 || true
+  fi
+  if [[ -f "$LIMITS_SCRIPT" ]]; then
+    : >"$LIMITS_CONF"
+    bash "$LIMITS_SCRIPT" >/dev/null 2>&1 || true
+  fi
+  rm -f "$LIMITS_CONF" "$LIMITS_SCRIPT" "$LIMITS_SERVICE_FILE"
+  systemctl daemon-reload
+}
+
+# set_limits records the limits of one hangar and reloads the firewall
+# rules. Both 0 drops the hangar's entry; an empty file removes everything.
+set_limits() {
+  local key="$1" port="$2" conns="$3" rate="$4"
+
+  mkdir -p "$BEAM_DIR"
+  local tmp
+  tmp="$(mktemp)"
+  if [[ -f "$LIMITS_CONF" ]]; then
+    awk -v k="$key" '$1 != k' "$LIMITS_CONF" >"$tmp"
+  fi
+  if (( conns > 0 || rate > 0 )); then
+    printf '%s %s %s %s\n' "$key" "$port" "$conns" "$rate" >>"$tmp"
+  fi
+  if [[ ! -s "$tmp" ]]; then
+    rm -f "$tmp"
+    [[ -f "$LIMITS_CONF" || -f "$LIMITS_SCRIPT" ]] && disable_limits
+    return 0
+  fi
+  mv -f "$tmp" "$LIMITS_CONF"
+  chmod 600 "$LIMITS_CONF"
+
+  cat >"$LIMITS_SCRIPT" <<'EOF_LIMITS'
+#!/usr/bin/env bash
+set -euo pipefail
+
+LIMITS_CONF="/etc/beammeup/limits.conf"
+CHAIN="BEAMMEUP-LIMIT"
+
+for ipt in iptables ip6tables; do
+  command -v "$ipt" >/dev/null 2>&1 || continue
+  if [[ ! -s "$LIMITS_CONF" ]]; then
+    while "$ipt" -D INPUT -j "$CHAIN" >/dev/null 2>&1; do :; done
+    "$ipt" -F "$CHAIN" >/dev/null 2>&1 || true
+    "$ipt" -X "$CHAIN" >/dev/null 2>&1 || true
+    continue
+  fi
+  "$ipt" -N "$CHAIN" >/dev/null 2>&1 || "$ipt" -F "$CHAIN"
+  "$ipt" -C INPUT -j "$CHAIN" >/dev/null 2>&1 || "$ipt" -I INPUT 1 -j "$CHAIN"
+  while read -r key port conns rate; do
+    [[ "$port" =~ ^[0-9]+$ ]] || continue
+    # The whole hangar shares one budget whatever the client address, so a
+    # leaked credential used from many machines is still held to it.
+    if (( ${conns:-0} > 0 )); then
+      "$ipt" -A "$CHAIN" -p tcp --dport "$port" --syn -m connlimit --connlimit-above "$conns" --connlimit-mask 0 \
+        -j REJECT --reject-with tcp-reset
+    fi
+    if (( ${rate:-0} > 0 )); then
+      "$ipt" -A "$CHAIN" -p tcp --dport "$port" --syn -m hashlimit --hashlimit-name "bm-${port}" \
+        --hashlimit-above "${rate}/minute" --hashlimit-burst "$rate" -j REJECT --reject-with tcp-reset
+    fi
+  done <"$LIMITS_CONF"
+done
+EOF_LIMITS
+  chmod 700 "$LIMITS_SCRIPT"
+
+  install_unit limits.service "$LIMITS_SERVICE_FILE"
+
+  systemctl daemon-reload
+  systemctl enable "$LIMITS_SERVICE" >/dev/null 2>&1 || true
+  bash "$LIMITS_SCRIPT" || die "Failed to install connection limits (needs iptables with connlimit and hashlimit)."
+}
+
+# configure_limits applies --max-conns / --rate-limit to the hangar that
+# apply just configured on <port>.
+configure_limits() {
+  local protocol="$1" port="$2"
+  [[ -n "$MAX_CONNS" || -n "$RATE_LIMIT" ]] || return 0
+  local current
+  read -r -a current <<<"$(read_limits "$(limits_key "$protocol")")"
+  local conns="${MAX_CONNS:-${current[0]}}" rate="${RATE_LIMIT:-${current[1]}}"
+  if (( conns > 0 || rate > 0 )); then
+    command -v iptables >/dev/null 2>&1 || die "Connection limits need iptables on the server."
+  fi
+  set_limits "$(limits_key "$protocol")" "$port" "$conns" "$rate"
+}
+
+# Resource drop-ins cap what a proxy unit may take from the server, so a
+# runaway Squid cannot starve a small VPS. Like the hardening drop-in they
+# sit next to the unit, which also works for the system squid.service.
+RESOURCES_DROPIN="beammeup-resources.conf"
+
+# read_resources prints "<memory-max> <cpu-quota> <tasks-max>" from unit's
+# drop-in, 0 for a setting without a cap.
+read_resources() {
+  local file="/etc/systemd/system/$1.d/${RESOURCES_DROPIN}" memory=0 cpu=0 tasks=0 line
+  if [[ -f "$file" ]]; then
+    while IFS= read -r line; do
+      case "$line" in
+        MemoryMax=*) memory="${line#*=}" ;;
+        CPUQuota=*) cpu="${line#*=}" ;;
+        TasksMax=*) tasks="${line#*=}" ;;
+      esac
+    done <"$file"
+  fi
+  echo "$memory $cpu $tasks"
+}
+
+remove_resources() {
+  local unit_file="$1"
+  rm -f "${unit_file}.d/${RESOURCES_DROPIN}"
+  rmdir "${unit_file}.d" 2>/dev/null || true
+}
+
+# configure_resources applies --memory-max / --cpu-quota / --tasks-max to
+# the unit apply just configured. A flag left out keeps the current value;
+# 0 lifts the cap. daemon-reload hands the new limits to the running unit.
+configure_resources() {
+  local unit="$1"
+  [[ -n "$MEMORY_MAX" || -n "$CPU_QUOTA" || -n "$TASKS_MAX" ]] || return 0
+  local current
+  read -r -a current <<<"$(read_resources "$unit")"
+  local memory="${MEMORY_MAX:-${current[0]}}" cpu="${CPU_QUOTA:-${current[1]}}" tasks="${TASKS_MAX:-${current[2]}}"
+  local file="/etc/systemd/system/${unit}.d/${RESOURCES_DROPIN}"
+  if [[ "$memory" == "0" && "$cpu" == "0" && "$tasks" == "0" ]]; then
+    [[ -f "$file" ]] || return 0
+    remove_resources "/etc/systemd/system/${unit}"
+  else
+    mkdir -p "${file%/*}"
+    {
+      echo "# Written by beammeup apply (--memory-max, --cpu-quota, --tasks-max)."
+      echo "[Service]"
+      [[ "$memory" == "0" ]] || echo "MemoryMax=$memory"
+      [[ "$cpu" == "0" ]] || echo "CPUQuota=$cpu"
+      [[ "$tasks" == "0" ]] || echo "TasksMax=$tasks"
+    } >"$file"
+    chmod 644 "$file"
+  fi
+  systemctl daemon-reload
+}
+
 disable_smart_blinder() {
   if service_defined "$BLINDER_TIMER"; then
     systemctl disable --now "$BLINDER_TIMER" >/dev/null 2>&1 || true
@@ -214,7 +442,7 @@ disable_smart_blinder() {
   if service_defined "$BLINDER_SERVICE"; then
     systemctl stop "$BLINDER_SERVICE" >/dev/null 2>&1 || true
   fi
-  rm -f "$BLINDER_ENV" "$BLINDER_LAST" "$BLINDER_STATE" "$BLINDER_SCRIPT" "$BLINDER_SERVICE_FILE" "$BLINDER_TIMER_FILE"
+  rm -f "$BLINDER_ENV" "$BLINDER_LAST" "$BLINDER_STATE" "$BLINDER_KEEPALIVE" "$BLINDER_SCRIPT" "$BLINDER_SERVICE_FILE" "$BLINDER_TIMER_FILE"
   systemctl daemon-reload
 }
 
@@ -240,6 +468,7 @@ BEAM_DIR="/etc/beammeup"
 BLINDER_ENV="${BEAM_DIR}/smart-blinder.env"
 BLINDER_LAST="${BEAM_DIR}/smart-blinder.last"
 BLINDER_STATE="${BEAM_DIR}/smart-blinder.state"
+BLINDER_KEEPALIVE="${BEAM_DIR}/smart-blinder.keepalive"
 SOCKS_ENV="${BEAM_DIR}/microsocks.env"
 HTTP_ENV="${BEAM_DIR}/http.env"
 HTTP_SIDECAR_LOG="/var/log/beammeup-http/access.log"
@@ -313,10 +542,20 @@ fi
 log_mtime=0
 log_mtime="$(max "$log_mtime" "$(mtime_epoch "$HTTP_SIDECAR_LOG")")"
 log_mtime="$(max "$log_mtime" "$(mtime_epoch "$HTTP_MANAGED_LOG")")"
+for instance_log in /var/log/beammeup-http-*/access.log; do
+  log_mtime="$(max "$log_mtime" "$(mtime_epoch "$instance_log")")"
+done
 if (( log_mtime > last )); then
   last="$log_mtime"
 fi
 
+# Touched over SSH by beammeup stealth tunnels and port forwards while they
+# carry traffic, which the proxy ports and logs do not show.
+keepalive_mtime="$(mtime_epoch "$BLINDER_KEEPALIVE")"
+if (( keepalive_mtime > last )); then
+  last="$keepalive_mtime"
+fi
+
 socks_port="$(read_env_value "$SOCKS_ENV" PROXY_PORT || true)"
 http_port="$(read_env_value "$HTTP_ENV" PROXY_PORT || true)"
 
@@ -327,6 +566,12 @@ fi
 if port_has_activity "$http_port"; then
   active_now=1
 fi
+for instance_env in "${BEAM_DIR}"/http-*.env; do
+  [[ -f "$instance_env" ]] || continue
+  if port_has_activity "$(read_env_value "$instance_env" PROXY_PORT || true)"; then
+    active_now=1
+  fi
+done
 if (( active_now == 1 )); then
   last="$now"
 fi
@@ -347,10 +592,13 @@ if service_defined "beammeup-microsocks.service" && service_active "beammeup-mic
   systemctl stop "beammeup-microsocks.service" >/dev/null 2>&1 || true
   stopped=1
 fi
-if service_defined "beammeup-http-sidecar.service" && service_active "beammeup-http-sidecar.service"; then
-  systemctl stop "beammeup-http-sidecar.service" >/dev/null 2>&1 || true
-  stopped=1
-fi
+for unit in /etc/systemd/system/beammeup-http-sidecar*.service; do
+  unit="${unit##*/}"
+  if service_defined "$unit" && service_active "$unit"; then
+    systemctl stop "$unit" >/dev/null 2>&1 || true
+    stopped=1
+  fi
+done
 
 if [[ -f "$HTTP_ENV" ]]; then
   http_mode="$(read_env_value "$HTTP_ENV" HTTP_MODE || true)"
@@ -369,37 +617,406 @@ fi
 EOF_BLINDER
   chmod 700 "$BLINDER_SCRIPT"
 
-  cat >"$BLINDER_SERVICE_FILE" <<EOF_UNIT
-[Unit]
-Description=Beammeup Smart Blinder
+  install_unit smart-blinder.service "$BLINDER_SERVICE_FILE"
+  install_unit smart-blinder.timer "$BLINDER_TIMER_FILE"
 
-[Service]
-Type=oneshot
-ExecStart=/usr/bin/env bash $BLINDER_SCRIPT
-EOF_UNIT
-  chmod 644 "$BLINDER_SERVICE_FILE"
+  systemctl daemon-reload
+  systemctl enable --now "$BLINDER_TIMER" >/dev/null 2>&1 || true
+
+  rm -f "$BLINDER_STATE"
+  date +%s >"$BLINDER_LAST"
+  chmod 600 "$BLINDER_LAST" || true
+}
+
+disable_traffic_cap() {
+  if service_defined "$CAP_TIMER"; then
+    systemctl disable --now "$CAP_TIMER" >/dev/null 2>&1 || true
+  fi
+  rm -f "$CAP_ENV" "$CAP_STATE" "$CAP_EXCEEDED" "$CAP_SCRIPT" "$CAP_SERVICE_FILE" "$CAP_TIMER_FILE"
+  systemctl daemon-reload
+}
+
+enable_traffic_cap() {
+  local cap_gb="$1"
+  local cap_bytes=$((cap_gb * 1000 * 1000 * 1000))
+
+  mkdir -p "$BEAM_DIR"
+
+  cat >"$CAP_ENV" <<EOF_ENV
+CAP_BYTES=$cap_bytes
+EOF_ENV
+  chmod 600 "$CAP_ENV"
+
+  cat >"$CAP_SCRIPT" <<'EOF_CAP'
+#!/usr/bin/env bash
+set -euo pipefail
+
+BEAM_DIR="/etc/beammeup"
+CAP_ENV="${BEAM_DIR}/traffic-cap.env"
+CAP_STATE="${BEAM_DIR}/traffic-cap.state"
+CAP_EXCEEDED="${BEAM_DIR}/traffic-cap.exceeded"
+HTTP_ENV="${BEAM_DIR}/http.env"
+ACCT_IN_CHAIN="BEAMMEUP-ACCT-IN"
+ACCT_OUT_CHAIN="BEAMMEUP-ACCT-OUT"
+
+read_env_value() {
+  local file="$1"
+  local key="$2"
+  [[ -f "$file" ]] || return 1
+  grep -m1 "^${key}=" "$file" | cut -d= -f2- || true
+}
+
+service_defined() {
+  systemctl cat "$1" >/dev/null 2>&1
+}
+
+service_active() {
+  systemctl is-active --quiet "$1" 2>/dev/null
+}
+
+proxy_units() {
+  local units=("beammeup-microsocks.socket" "beammeup-microsocks.service" "beammeup-http-sidecar.service") unit
+  for unit in /etc/systemd/system/beammeup-http-sidecar-*.service; do
+    [[ -f "$unit" ]] || continue
+    units+=("${unit##*/}")
+  done
+  if [[ -f "$HTTP_ENV" && "$(read_env_value "$HTTP_ENV" HTTP_MODE || true)" != "sidecar" ]]; then
+    units+=("squid.service")
+  fi
+  printf '%s\n' "${units[@]}"
+}
+
+chain_bytes() {
+  iptables -nvxL "$1" 2>/dev/null | awk 'NR == 3 { print $2; exit }'
+}
+
+ensure_chain() {
+  local chain="$1" parent="$2" match="$3"
+  if ! iptables -nL "$chain" >/dev/null 2>&1; then
+    iptables -N "$chain"
+    iptables -A "$chain" ! "$match" lo -j RETURN
+  fi
+  iptables -C "$parent" -j "$chain" >/dev/null 2>&1 || iptables -I "$parent" 1 -j "$chain"
+}
+
+month="$(date -u +%Y-%m)"
+cap_bytes="$(read_env_value "$CAP_ENV" CAP_BYTES || true)"
+[[ "${cap_bytes:-}" =~ ^[0-9]+$ ]] && (( cap_bytes > 0 )) || exit 0
+
+used=""
+iface="$(ip route show default 2>/dev/null | awk '{for (i = 1; i <= NF; i++) if ($i == "dev") { print $(i + 1); exit }}')"
+if command -v vnstat >/dev/null 2>&1 && [[ -n "$iface" ]]; then
+  IFS=';' read -r -a f <<<"$(vnstat --oneline b -i "$iface" 2>/dev/null || true)"
+  if [[ "${#f[@]}" -ge 10 && "${f[8]}" =~ ^[0-9]+$ && "${f[9]}" =~ ^[0-9]+$ ]]; then
+    used=$((f[8] + f[9]))
+  fi
+fi
+
+if [[ -z "$used" ]]; then
+  command -v iptables >/dev/null 2>&1 || exit 0
+  ensure_chain "$ACCT_IN_CHAIN" INPUT -i
+  ensure_chain "$ACCT_OUT_CHAIN" OUTPUT -o
+  rx="$(chain_bytes "$ACCT_IN_CHAIN")"
+  tx="$(chain_bytes "$ACCT_OUT_CHAIN")"
+  [[ "$rx" =~ ^[0-9]+$ && "$tx" =~ ^[0-9]+$ ]] || exit 0
+  current=$((rx + tx))
+
+  state_month="$(read_env_value "$CAP_STATE" MONTH || true)"
+  total="$(read_env_value "$CAP_STATE" TOTAL || true)"
+  last="$(read_env_value "$CAP_STATE" LAST || true)"
+  [[ "${total:-}" =~ ^[0-9]+$ ]] || total=0
+  if [[ "$state_month" != "$month" ]]; then
+    total=0
+  fi
+  if [[ "${last:-}" =~ ^[0-9]+$ ]]; then
+    if (( current >= last )); then
+      total=$((total + current - last))
+    else
+      total=$((total + current))
+    fi
+  fi
+  printf 'MONTH=%s\nTOTAL=%s\nLAST=%s\n' "$month" "$total" "$current" >"$CAP_STATE"
+  chmod 600 "$CAP_STATE" || true
+  used="$total"
+fi
+
+if (( used < cap_bytes )); then
+  # Back under the cap (new month or raised cap): resume what the cap paused.
+  if [[ -f "$CAP_EXCEEDED" ]]; then
+    for unit in $(read_env_value "$CAP_EXCEEDED" UNITS || true); do
+      systemctl start "$unit" >/dev/null 2>&1 || true
+    done
+    rm -f "$CAP_EXCEEDED"
+  fi
+  exit 0
+fi
 
-  cat >"$BLINDER_TIMER_FILE" <<EOF_UNIT
+paused="$(read_env_value "$CAP_EXCEEDED" UNITS || true)"
+while read -r unit; do
+  if service_defined "$unit" && service_active "$unit"; then
+    systemctl stop "$unit" >/dev/null 2>&1 || true
+    case " $paused " in
+      *" $unit "*) ;;
+      *) paused="${paused:+$paused }$unit" ;;
+    esac
+  fi
+done < <(proxy_units)
+
+printf 'MONTH=%s\nUSED=%s\nCAP=%s\nUNITS=%s\n' "$month" "$used" "$cap_bytes" "$paused" >"$CAP_EXCEEDED"
+chmod 600 "$CAP_EXCEEDED" || true
+EOF_CAP
+  chmod 700 "$CAP_SCRIPT"
+
+  install_unit traffic-cap.service "$CAP_SERVICE_FILE"
+  install_unit traffic-cap.timer "$CAP_TIMER_FILE"
+
+  systemctl daemon-reload
+  systemctl enable --now "$CAP_TIMER" >/dev/null 2>&1 || true
+}
+
+traffic_cap_exceeded() {
+  local month
+  month="$(date -u +%Y-%m)"
+  [[ -f "$CAP_EXCEEDED" && "$(read_env_value "$CAP_EXCEEDED" MONTH || true)" == "$month" ]]
+}
+
+# configure_traffic_cap installs or removes the cap before apply changes
+# anything, and refuses to restart a hangar whose cap is already used up.
+configure_traffic_cap() {
+  [[ -n "$TRAFFIC_CAP_GB" ]] || return 0
+  if [[ "$TRAFFIC_CAP_GB" == "0" ]]; then
+    disable_traffic_cap
+    return 0
+  fi
+  is_valid_positive_int "$TRAFFIC_CAP_GB" || die "Invalid --traffic-cap-gb value: $TRAFFIC_CAP_GB"
+
+  enable_traffic_cap "$TRAFFIC_CAP_GB"
+  bash "$CAP_SCRIPT" || true
+  if traffic_cap_exceeded; then
+    local used
+    used="$(read_env_value "$CAP_EXCEEDED" USED || true)"
+    die "Traffic cap of ${TRAFFIC_CAP_GB} GB reached for $(date -u +%Y-%m) (used ${used:-?} bytes). Raise --traffic-cap-gb or wait for next month."
+  fi
+}
+
+disable_quiet_hours() {
+  if service_defined "$QUIET_TIMER"; then
+    systemctl disable --now "$QUIET_TIMER" >/dev/null 2>&1 || true
+  fi
+  rm -f "$QUIET_ENV" "$QUIET_PAUSED" "$QUIET_SCRIPT" "$QUIET_SERVICE_FILE" "$QUIET_TIMER_FILE"
+  systemctl daemon-reload
+}
+
+# enable_quiet_hours installs a per-minute timer that stops the proxy units
+# inside any of the windows (HH:MM-HH:MM in UTC, comma separated, may wrap
+# past midnight) and starts again what it stopped once the window ends.
+enable_quiet_hours() {
+  local windows="$1"
+
+  mkdir -p "$BEAM_DIR"
+
+  cat >"$QUIET_ENV" <<EOF_ENV
+WINDOWS=$windows
+EOF_ENV
+  chmod 600 "$QUIET_ENV"
+
+  cat >"$QUIET_SCRIPT" <<'EOF_QUIET'
+#!/usr/bin/env bash
+set -euo pipefail
+
+BEAM_DIR="/etc/beammeup"
+QUIET_ENV="${BEAM_DIR}/quiet-hours.env"
+QUIET_PAUSED="${BEAM_DIR}/quiet-hours.paused"
+CAP_EXCEEDED="${BEAM_DIR}/traffic-cap.exceeded"
+HTTP_ENV="${BEAM_DIR}/http.env"
+
+read_env_value() {
+  local file="$1"
+  local key="$2"
+  [[ -f "$file" ]] || return 1
+  grep -m1 "^${key}=" "$file" | cut -d= -f2- || true
+}
+
+service_defined() {
+  systemctl cat "$1" >/dev/null 2>&1
+}
+
+service_active() {
+  systemctl is-active --quiet "$1" 2>/dev/null
+}
+
+proxy_units() {
+  local units=("beammeup-microsocks.socket" "beammeup-microsocks.service" "beammeup-http-sidecar.service") unit
+  for unit in /etc/systemd/system/beammeup-http-sidecar-*.service; do
+    [[ -f "$unit" ]] || continue
+    units+=("${unit##*/}")
+  done
+  if [[ -f "$HTTP_ENV" && "$(read_env_value "$HTTP_ENV" HTTP_MODE || true)" != "sidecar" ]]; then
+    units+=("squid.service")
+  fi
+  printf '%s\n' "${units[@]}"
+}
+
+minutes() {
+  echo $(( 10#${1%%:*} * 60 + 10#${1##*:} ))
+}
+
+in_quiet_window() {
+  local now window start end
+  now="$(minutes "$(date -u +%H:%M)")"
+  for window in ${1//,/ }; do
+    start="$(minutes "${window%-*}")"
+    end="$(minutes "${window#*-}")"
+    if (( start < end )); then
+      (( now >= start && now < end )) && return 0
+    else
+      (( now >= start || now < end )) && return 0
+    fi
+  done
+  return 1
+}
+
+windows="$(read_env_value "$QUIET_ENV" WINDOWS || true)"
+[[ -n "$windows" ]] || exit 0
+
+if ! in_quiet_window "$windows"; then
+  [[ -f "$QUIET_PAUSED" ]] || exit 0
+  # A cap reached overnight keeps the proxy down; try again next run.
+  if [[ -f "$CAP_EXCEEDED" && "$(read_env_value "$CAP_EXCEEDED" MONTH || true)" == "$(date -u +%Y-%m)" ]]; then
+    exit 0
+  fi
+  for unit in $(read_env_value "$QUIET_PAUSED" UNITS || true); do
+    systemctl start "$unit" >/dev/null 2>&1 || true
+  done
+  rm -f "$QUIET_PAUSED"
+  exit 0
+fi
+
+paused="$(read_env_value "$QUIET_PAUSED" UNITS || true)"
+while read -r unit; do
+  if service_defined "$unit" && service_active "$unit"; then
+    systemctl stop "$unit" >/dev/null 2>&1 || true
+    case " $paused " in
+      *" $unit "*) ;;
+      *) paused="${paused:+$paused }$unit" ;;
+    esac
+  fi
+done < <(proxy_units)
+
+if [[ -n "$paused" ]]; then
+  printf 'SINCE=%s\nUNITS=%s\n' "$(read_env_value "$QUIET_PAUSED" SINCE || date +%s)" "$paused" >"$QUIET_PAUSED"
+  chmod 600 "$QUIET_PAUSED" || true
+fi
+EOF_QUIET
+  chmod 700 "$QUIET_SCRIPT"
+
+  install_unit quiet-hours.service "$QUIET_SERVICE_FILE"
+  install_unit quiet-hours.timer "$QUIET_TIMER_FILE"
+
+  systemctl daemon-reload
+  systemctl enable --now "$QUIET_TIMER" >/dev/null 2>&1 || true
+}
+
+quiet_hours_paused() {
+  [[ -f "$QUIET_PAUSED" ]]
+}
+
+# configure_quiet_hours installs, replaces or removes the schedule. It runs
+# after apply so a hangar configured inside a quiet window goes down right
+# away instead of at the next tick.
+configure_quiet_hours() {
+  [[ -n "$QUIET_HOURS" ]] || return 0
+  if [[ "$QUIET_HOURS" == "off" ]]; then
+    if [[ -f "$QUIET_ENV" || -f "$QUIET_TIMER_FILE" ]]; then
+      # Hand back whatever is still paused for the night.
+      local unit units
+      units="$(read_env_value "$QUIET_PAUSED" UNITS || true)"
+      disable_quiet_hours
+      for unit in $units; do
+        systemctl start "$unit" >/dev/null 2>&1 || true
+      done
+    fi
+    return 0
+  fi
+  enable_quiet_hours "$QUIET_HOURS"
+  bash "$QUIET_SCRIPT" || true
+  if quiet_hours_paused; then
+    log "Inside quiet hours (${QUIET_HOURS} UTC): the proxy stays stopped until the window ends."
+  fi
+}
+
+disable_ttl() {
+  if service_defined "$TTL_TIMER"; then
+    systemctl disable --now "$TTL_TIMER" >/dev/null 2>&1 || true
+  fi
+  rm -f "$TTL_ENV" "$TTL_SCRIPT" "$TTL_STAGED" "$TTL_TIMER_FILE"
+  # Named instances share one template unit; it goes with the last timer.
+  if [[ -z "$HTTP_INSTANCE" ]] || ! compgen -G "/etc/systemd/system/beammeup-ttl-instance@*.timer" >/dev/null; then
+    rm -f "$TTL_SERVICE_FILE"
+  fi
+  systemctl daemon-reload
+}
+
+# enable_ttl keeps a copy of this script on the server and schedules it to
+# run destroy at the expiry time. Persistent=true fires a missed expiry on
+# the next boot. When the script arrives on stdin the client stages the copy
+# at $TTL_STAGED beforehand. A named instance gets its own timer, which
+# destroys only that instance.
+enable_ttl() {
+  local ttl_seconds="$1"
+  local self="${BASH_SOURCE[0]:-}"
+  local staged="$TTL_STAGED"
+
+  mkdir -p "$BEAM_DIR"
+  if [[ -f "$staged" ]]; then
+    mv -f "$staged" "$TTL_SCRIPT"
+    chmod 700 "$TTL_SCRIPT"
+  elif [[ -f "$self" && "$self" != "$TTL_SCRIPT" ]]; then
+    install -m 700 "$self" "$TTL_SCRIPT"
+  elif [[ ! -f "$TTL_SCRIPT" ]]; then
+    die "--ttl-seconds needs a copy of the script at ${staged}."
+  fi
+
+  local expires_at
+  expires_at=$(( $(date +%s) + ttl_seconds ))
+
+  cat >"$TTL_ENV" <<EOF_ENV
+EXPIRES_AT=$expires_at
+EOF_ENV
+  chmod 600 "$TTL_ENV"
+
+  install_unit "$TTL_UNIT" "$TTL_SERVICE_FILE"
+
+  cat >"$TTL_TIMER_FILE" <<EOF_UNIT
 [Unit]
-Description=Beammeup Smart Blinder Timer
+Description=beammeup ${HTTP_INSTANCE:+HTTP instance $HTTP_INSTANCE }expiry timer
 
 [Timer]
-OnBootSec=2min
-OnUnitActiveSec=30s
-AccuracySec=10s
+OnCalendar=$(date -u -d "@${expires_at}" '+%Y-%m-%d %H:%M:%S') UTC
+AccuracySec=30s
 Persistent=true
 
 [Install]
 WantedBy=timers.target
 EOF_UNIT
-  chmod 644 "$BLINDER_TIMER_FILE"
+  chmod 644 "$TTL_TIMER_FILE"
 
   systemctl daemon-reload
-  systemctl enable --now "$BLINDER_TIMER" >/dev/null 2>&1 || true
+  systemctl enable --now "$TTL_TIMER" >/dev/null 2>&1 || die "Failed to schedule hangar expiry."
+}
 
-  rm -f "$BLINDER_STATE"
-  date +%s >"$BLINDER_LAST"
-  chmod 600 "$BLINDER_LAST" || true
+# configure_ttl runs after a successful apply: a positive --ttl-seconds
+# (re)schedules destruction, 0 cancels it, and no flag keeps what is there.
+configure_ttl() {
+  if [[ -n "$TTL_SECONDS" ]]; then
+    if [[ "$TTL_SECONDS" == "0" ]]; then
+      disable_ttl
+    else
+      is_valid_positive_int "$TTL_SECONDS" || die "Invalid --ttl-seconds value: $TTL_SECONDS"
+      enable_ttl "$TTL_SECONDS"
+    fi
+  fi
+  printf 'BM_RESULT_EXPIRES_AT=%s\n' "$(read_env_value "$TTL_ENV" EXPIRES_AT || true)"
 }
 
 configure_smart_blinder() {
@@ -414,32 +1031,106 @@ BEAM_DIR="/etc/beammeup"
 SOCKS_ENV="${BEAM_DIR}/microsocks.env"
 SOCKS_SERVICE="beammeup-microsocks.service"
 SOCKS_SERVICE_FILE="/etc/systemd/system/${SOCKS_SERVICE}"
+SOCKS_SOCKET="beammeup-microsocks.socket"
+SOCKS_SOCKET_FILE="/etc/systemd/system/${SOCKS_SOCKET}"
+SOCKS_PROXYD_SERVICE="beammeup-microsocks-proxy.service"
+SOCKS_PROXYD_SERVICE_FILE="/etc/systemd/system/${SOCKS_PROXYD_SERVICE}"
+SOCKS_WAIT_SCRIPT="${BEAM_DIR}/microsocks-wait.sh"
+SOCKS_IDLE_SECONDS=60
+MICROSOCKS_LOCAL_BIN="/usr/local/bin/microsocks"
+MICROSOCKS_UPLOAD="${BEAM_DIR}/microsocks.upload"
+# The release built from source where there is neither a package nor an
+# uploaded binary. Bump the version and the tarball checksum together;
+# without a checksum the source build is refused.
+MICROSOCKS_VERSION="1.0.5"
+MICROSOCKS_SHA256=""
+MICROSOCKS_URL="https://github.com/rofl0r/microsocks/archive/refs/tags/v${MICROSOCKS_VERSION}.tar.gz"
 HTTP_ENV="${BEAM_DIR}/http.env"
 HTTP_HTPASSWD="${BEAM_DIR}/http.htpasswd"
+HTTP_ADOPTED_HTPASSWD="${BEAM_DIR}/http-adopted.htpasswd"
 HTTP_SIDECAR_DIR="${BEAM_DIR}/http-sidecar"
 HTTP_SIDECAR_CONF="${HTTP_SIDECAR_DIR}/squid.conf"
 HTTP_SIDECAR_HTPASSWD="${HTTP_SIDECAR_DIR}/htpasswd"
 HTTP_SIDECAR_LOG_DIR="/var/log/beammeup-http"
 HTTP_SIDECAR_SERVICE="beammeup-http-sidecar.service"
 HTTP_SIDECAR_SERVICE_FILE="/etc/systemd/system/${HTTP_SIDECAR_SERVICE}"
+HTTP_SIDECAR_RUN_DIR="beammeup-http"
+HTTP_INSTANCE=""
 SQUID_CONF="/etc/squid/squid.conf"
 SQUID_BACKUP="/etc/squid/squid.conf.beammeup.bak"
+HTTP_MANAGED_CACHE_DIR="/var/spool/squid/beammeup"
 HANGAR_META="${BEAM_DIR}/hangar.json"
+HANGAR_SIG="${BEAM_DIR}/hangar.json.sig"
+HANGAR_INFO_ENV="${BEAM_DIR}/hangar-info.env"
+LAST_CLIENT_ENV="${BEAM_DIR}/last-client.env"
 
 BLINDER_ENV="${BEAM_DIR}/smart-blinder.env"
 BLINDER_LAST="${BEAM_DIR}/smart-blinder.last"
 BLINDER_STATE="${BEAM_DIR}/smart-blinder.state"
+BLINDER_KEEPALIVE="${BEAM_DIR}/smart-blinder.keepalive"
 BLINDER_SCRIPT="${BEAM_DIR}/smart-blinder.sh"
 BLINDER_SERVICE="beammeup-smart-blinder.service"
 BLINDER_TIMER="beammeup-smart-blinder.timer"
 BLINDER_SERVICE_FILE="/etc/systemd/system/${BLINDER_SERVICE}"
 BLINDER_TIMER_FILE="/etc/systemd/system/${BLINDER_TIMER}"
 
+CAP_ENV="${BEAM_DIR}/traffic-cap.env"
+CAP_STATE="${BEAM_DIR}/traffic-cap.state"
+CAP_EXCEEDED="${BEAM_DIR}/traffic-cap.exceeded"
+CAP_SCRIPT="${BEAM_DIR}/traffic-cap.sh"
+CAP_SERVICE="beammeup-traffic-cap.service"
+CAP_TIMER="beammeup-traffic-cap.timer"
+CAP_SERVICE_FILE="/etc/systemd/system/${CAP_SERVICE}"
+CAP_TIMER_FILE="/etc/systemd/system/${CAP_TIMER}"
+
+QUIET_ENV="${BEAM_DIR}/quiet-hours.env"
+QUIET_PAUSED="${BEAM_DIR}/quiet-hours.paused"
+QUIET_SCRIPT="${BEAM_DIR}/quiet-hours.sh"
+QUIET_SERVICE="beammeup-quiet-hours.service"
+QUIET_TIMER="beammeup-quiet-hours.timer"
+QUIET_SERVICE_FILE="/etc/systemd/system/${QUIET_SERVICE}"
+QUIET_TIMER_FILE="/etc/systemd/system/${QUIET_TIMER}"
+
+LIMITS_CONF="${BEAM_DIR}/limits.conf"
+LIMITS_SCRIPT="${BEAM_DIR}/limits.sh"
+LIMITS_SERVICE="beammeup-limits.service"
+LIMITS_SERVICE_FILE="/etc/systemd/system/${LIMITS_SERVICE}"
+
+GRACE_SCRIPT="${BEAM_DIR}/rotate-grace.sh"
+GRACE_SERVICE="beammeup-rotate-grace.service"
+GRACE_TIMER="beammeup-rotate-grace.timer"
+GRACE_SERVICE_FILE="/etc/systemd/system/${GRACE_SERVICE}"
+GRACE_TIMER_FILE="/etc/systemd/system/${GRACE_TIMER}"
+
+TTL_ENV="${BEAM_DIR}/ttl.env"
+TTL_SCRIPT="${BEAM_DIR}/ttl-destroy.sh"
+TTL_STAGED="${BEAM_DIR}/ttl-destroy.sh.new"
+TTL_UNIT="ttl.service"
+TTL_SERVICE="beammeup-ttl.service"
+TTL_TIMER="beammeup-ttl.timer"
+TTL_SERVICE_FILE="/etc/systemd/system/${TTL_SERVICE}"
+TTL_TIMER_FILE="/etc/systemd/system/${TTL_TIMER}"
+
+LOCK_FILE="${BEAM_DIR}/lock"
+BACKUP_DIR="${BEAM_DIR}/backups"
+BACKUP_KEEP=10
+BACKUP_STAMP_RE="[0-9]{8}T[0-9]{6}Z"
+LOCK_STALE_SECONDS=1800
+
+# Echo endpoints verify_proxy fetches through a freshly applied proxy.
+VERIFY_URLS="https://api.ipify.org https://ifconfig.me"
+
+ACCT_IN_CHAIN="BEAMMEUP-ACCT-IN"
+ACCT_OUT_CHAIN="BEAMMEUP-ACCT-OUT"
+
 SOCKS_EXISTS=0
 SOCKS_ACTIVE=0
 SOCKS_PORT=""
 SOCKS_USER=""
 SOCKS_PASS=""
+SOCKS_EGRESS_IP=""
+SOCKS_SOCKET_ACTIVATION=0
+SOCKS_BACKEND_PORT=""
 
 HTTP_EXISTS=0
 HTTP_ACTIVE=0
@@ -449,16 +1140,75 @@ HTTP_PASS=""
 HTTP_MODE=""
 HTTP_MANAGED=0
 HTTP_LEGACY=0
+HTTP_EGRESS_IP=""
+HTTP_CACHE_MB=0
+HTTP_CACHE_DIR=""
+HTTP_ANONYMITY=""
+
+SQUID_VERSION=""
+SQUID_MAJOR=0
+SQUID_MINOR=0
 
 HANGAR_STATUS="missing"
 METADATA_EXISTS=0
 
+# use_http_instance points the HTTP_* and TTL_* paths at a named sidecar
+# instance. Each instance has its own env file, Squid config, credentials,
+# logs, unit and expiry timer, so several can run side by side on different
+# ports. An empty name selects the default hangar.
+use_http_instance() {
+  local name="$1"
+  HTTP_INSTANCE="$name"
+  if [[ -z "$name" ]]; then
+    HTTP_ENV="${BEAM_DIR}/http.env"
+    HTTP_SIDECAR_DIR="${BEAM_DIR}/http-sidecar"
+    HTTP_SIDECAR_LOG_DIR="/var/log/beammeup-http"
+    HTTP_SIDECAR_SERVICE="beammeup-http-sidecar.service"
+    HTTP_SIDECAR_RUN_DIR="beammeup-http"
+    TTL_ENV="${BEAM_DIR}/ttl.env"
+    TTL_SCRIPT="${BEAM_DIR}/ttl-destroy.sh"
+    TTL_UNIT="ttl.service"
+    TTL_SERVICE="beammeup-ttl.service"
+    TTL_TIMER="beammeup-ttl.timer"
+    TTL_SERVICE_FILE="/etc/systemd/system/${TTL_SERVICE}"
+  else
+    HTTP_ENV="${BEAM_DIR}/http-${name}.env"
+    HTTP_SIDECAR_DIR="${BEAM_DIR}/http-sidecar-${name}"
+    HTTP_SIDECAR_LOG_DIR="/var/log/beammeup-http-${name}"
+    HTTP_SIDECAR_SERVICE="beammeup-http-sidecar-${name}.service"
+    HTTP_SIDECAR_RUN_DIR="beammeup-http-${name}"
+    TTL_ENV="${BEAM_DIR}/ttl-${name}.env"
+    TTL_SCRIPT="${BEAM_DIR}/ttl-destroy-${name}.sh"
+    TTL_UNIT="ttl-instance.service"
+    TTL_SERVICE="beammeup-ttl-instance@${name}.service"
+    TTL_TIMER="beammeup-ttl-instance@${name}.timer"
+    TTL_SERVICE_FILE="/etc/systemd/system/beammeup-ttl-instance@.service"
+  fi
+  TTL_TIMER_FILE="/etc/systemd/system/${TTL_TIMER}"
+  HTTP_SIDECAR_CONF="${HTTP_SIDECAR_DIR}/squid.conf"
+  HTTP_SIDECAR_HTPASSWD="${HTTP_SIDECAR_DIR}/htpasswd"
+  HTTP_SIDECAR_SERVICE_FILE="/etc/systemd/system/${HTTP_SIDECAR_SERVICE}"
+}
+
+# http_instance_names lists the named HTTP instances on this host.
+http_instance_names() {
+  local env name
+  for env in "${BEAM_DIR}"/http-*.env; do
+    [[ -f "$env" ]] || continue
+    name="${env##*/http-}"
+    printf '%s\n' "${name%.env}"
+  done
+}
+
 load_socks_state() {
   SOCKS_EXISTS=0
   SOCKS_ACTIVE=0
   SOCKS_PORT=""
   SOCKS_USER=""
   SOCKS_PASS=""
+  SOCKS_EGRESS_IP=""
+  SOCKS_SOCKET_ACTIVATION=0
+  SOCKS_BACKEND_PORT=""
 
   if [[ -f "$SOCKS_ENV" || -f "$SOCKS_SERVICE_FILE" ]]; then
     SOCKS_EXISTS=1
@@ -467,11 +1217,20 @@ load_socks_state() {
   SOCKS_PORT="$(read_env_value "$SOCKS_ENV" PROXY_PORT || true)"
   SOCKS_USER="$(read_env_value "$SOCKS_ENV" PROXY_USER || true)"
   SOCKS_PASS="$(read_env_value "$SOCKS_ENV" PROXY_PASS || true)"
+  SOCKS_EGRESS_IP="$(read_env_value "$SOCKS_ENV" EGRESS_IP || true)"
+  SOCKS_BACKEND_PORT="$(read_env_value "$SOCKS_ENV" BACKEND_PORT || true)"
 
   if service_defined "$SOCKS_SERVICE"; then
     SOCKS_EXISTS=1
     SOCKS_ACTIVE="$(service_active "$SOCKS_SERVICE")"
   fi
+  # With socket activation microsocks only runs while clients are connected;
+  # the hangar is up as long as systemd listens for them.
+  if [[ -f "$SOCKS_SOCKET_FILE" ]]; then
+    SOCKS_EXISTS=1
+    SOCKS_SOCKET_ACTIVATION=1
+    SOCKS_ACTIVE="$(service_active "$SOCKS_SOCKET")"
+  fi
 }
 
 load_http_state() {
@@ -483,11 +1242,22 @@ load_http_state() {
   HTTP_MODE=""
   HTTP_MANAGED=0
   HTTP_LEGACY=0
+  HTTP_EGRESS_IP=""
+  HTTP_CACHE_MB=0
+  HTTP_CACHE_DIR=""
+  HTTP_ANONYMITY=""
 
   HTTP_PORT="$(read_env_value "$HTTP_ENV" PROXY_PORT || true)"
   HTTP_USER="$(read_env_value "$HTTP_ENV" PROXY_USER || true)"
   HTTP_PASS="$(read_env_value "$HTTP_ENV" PROXY_PASS || true)"
   HTTP_MODE="$(read_env_value "$HTTP_ENV" HTTP_MODE || true)"
+  HTTP_EGRESS_IP="$(read_env_value "$HTTP_ENV" EGRESS_IP || true)"
+  HTTP_ANONYMITY="$(read_env_value "$HTTP_ENV" ANONYMITY || true)"
+  HTTP_CACHE_MB="$(read_env_value "$HTTP_ENV" CACHE_MB || true)"
+  [[ "$HTTP_CACHE_MB" =~ ^[0-9]+$ ]] || HTTP_CACHE_MB=0
+  if (( HTTP_CACHE_MB > 0 )); then
+    HTTP_CACHE_DIR="$(read_env_value "$HTTP_ENV" CACHE_DIR || true)"
+  fi
 
   if [[ "$HTTP_MODE" != "sidecar" ]]; then
     HTTP_MODE=""
@@ -497,7 +1267,12 @@ load_http_state() {
     HTTP_EXISTS=1
   fi
 
-  if [[ "$HTTP_MODE" == "sidecar" || -f "$HTTP_SIDECAR_SERVICE_FILE" || -f "$HTTP_SIDECAR_CONF" ]]; then
+  if [[ -n "$HTTP_INSTANCE" || "$HTTP_MODE" == "sidecar" || -f "$HTTP_SIDECAR_SERVICE_FILE" || -f "$HTTP_SIDECAR_CONF" ]]; then
+    if [[ -n "$HTTP_INSTANCE" && ! -f "$HTTP_ENV" && ! -f "$HTTP_SIDECAR_SERVICE_FILE" ]]; then
+      # Instances never fall back to the system Squid.
+      HTTP_MODE=""
+      return
+    fi
     HTTP_EXISTS=1
     HTTP_MODE="sidecar"
 
@@ -544,31 +1319,170 @@ load_http_state() {
   fi
 }
 
-write_hangar_metadata() {
-  local status="$1"
-  local notes="$2"
+# http_states prints "exists active" for the default HTTP hangar and for
+# every named instance.
+http_states() {
+  local name
+  for name in "" $(http_instance_names); do
+    (
+      use_http_instance "$name"
+      load_http_state
+      printf '%s %s\n' "$HTTP_EXISTS" "$HTTP_ACTIVE"
+    )
+  done
+}
+
+# http_instances_json renders the named HTTP instances for hangar.json.
+http_instances_json() {
+  local name sep=""
+  printf '['
+  for name in $(http_instance_names); do
+    (
+      use_http_instance "$name"
+      load_http_state
+      printf '%s\n      {"name": "%s", "exists": %s, "active": %s, "port": "%s", "user": "%s", "egress_ip": "%s"}' \
+        "$sep" "$name" "$HTTP_EXISTS" "$HTTP_ACTIVE" "$HTTP_PORT" "$HTTP_USER" "$HTTP_EGRESS_IP"
+    )
+    sep=","
+  done
+  [[ -z "$sep" ]] || printf '\n    '
+  printf ']'
+}
+
+# http_instances_summary lists the named HTTP instances as
+# "name:port:active", space separated.
+http_instances_summary() {
+  local name
+  for name in $(http_instance_names); do
+    (
+      use_http_instance "$name"
+      load_http_state
+      printf '%s:%s:%s\n' "$name" "$HTTP_PORT" "$HTTP_ACTIVE"
+    )
+  done | tr '\n' ' ' | sed 's/ $//'
+}
+
+# json_escape escapes backslashes and double quotes for a JSON string; the
+# client keeps control characters out of what it sends.
+json_escape() {
+  local s="$1"
+  s="${s//\\/\\\\}"
+  printf '%s' "${s//\"/\\\"}"
+}
+
+# configure_hangar_info stores the hangar's label and description for
+# hangar.json. Options not given keep what is there; "none" clears.
+configure_hangar_info() {
+  [[ -n "$HANGAR_LABEL" || -n "$HANGAR_DESCRIPTION" ]] || return 0
+  local label description
+  label="$(read_env_value "$HANGAR_INFO_ENV" LABEL || true)"
+  description="$(read_env_value "$HANGAR_INFO_ENV" DESCRIPTION || true)"
+  [[ -z "$HANGAR_LABEL" ]] || label="$HANGAR_LABEL"
+  [[ -z "$HANGAR_DESCRIPTION" ]] || description="$HANGAR_DESCRIPTION"
+  [[ "$label" != "none" ]] || label=""
+  [[ "$description" != "none" ]] || description=""
   mkdir -p "$BEAM_DIR"
-  cat >"$HANGAR_META" <<EOF_META
-{
-  "version": "1",
-  "updated_at": "$(date -u +%Y-%m-%dT%H:%M:%SZ)",
+  if [[ -z "$label" && -z "$description" ]]; then
+    rm -f "$HANGAR_INFO_ENV"
+    return 0
+  fi
+  printf 'LABEL=%s\nDESCRIPTION=%s\n' "$label" "$description" >"$HANGAR_INFO_ENV"
+  chmod 600 "$HANGAR_INFO_ENV"
+}
+
+# record_last_client notes which client changed the hangar, so teams
+# sharing a server can tell who did what. Runs without --client leave the
+# previous entry alone.
+record_last_client() {
+  [[ -n "$CLIENT_ID" ]] || return 0
+  local action="$MODE"
+  if [[ "$MODE" == "apply" && "$ROTATE_CREDENTIALS" == "1" ]]; then
+    action="rotate"
+  fi
+  mkdir -p "$BEAM_DIR"
+  printf 'CLIENT=%s\nVERSION=%s\nACTION=%s\nAT=%s\n' "$CLIENT_ID" "$CLIENT_VERSION" "$action" "$(date +%s)" >"$LAST_CLIENT_ENV"
+  chmod 600 "$LAST_CLIENT_ENV"
+}
+
+# last_client_json prints the last_client object of hangar.json.
+last_client_json() {
+  local at
+  at="$(read_env_value "$LAST_CLIENT_ENV" AT || true)"
+  if [[ "$at" =~ ^[0-9]+$ ]]; then
+    at="$(date -u -d "@$at" +%Y-%m-%dT%H:%M:%SZ)"
+  else
+    at=""
+  fi
+  printf '{"client": "%s", "version": "%s", "action": "%s", "at": "%s"}' \
+    "$(json_escape "$(read_env_value "$LAST_CLIENT_ENV" CLIENT || true)")" \
+    "$(json_escape "$(read_env_value "$LAST_CLIENT_ENV" VERSION || true)")" \
+    "$(read_env_value "$LAST_CLIENT_ENV" ACTION || true)" "$at"
+}
+
+write_hangar_metadata() {
+  local status="$1"
+  local notes="$2"
+  local http_exists="$HTTP_EXISTS" http_active="$HTTP_ACTIVE" http_port="$HTTP_PORT"
+  local http_user="$HTTP_USER" http_egress_ip="$HTTP_EGRESS_IP"
+  # The top-level http entry always describes the default hangar, even when
+  # this run works on an instance.
+  if [[ -n "$HTTP_INSTANCE" ]]; then
+    IFS='|' read -r http_exists http_active http_port http_user http_egress_ip <<<"$(
+      use_http_instance ""
+      load_http_state
+      printf '%s|%s|%s|%s|%s' "$HTTP_EXISTS" "$HTTP_ACTIVE" "$HTTP_PORT" "$HTTP_USER" "$HTTP_EGRESS_IP"
+    )"
+  fi
+  mkdir -p "$BEAM_DIR"
+  cat >"$HANGAR_META" <<EOF_META
+{
+  "version": "1",
+  "updated_at": "$(date -u +%Y-%m-%dT%H:%M:%SZ)",
   "status": "${status}",
   "notes": "${notes}",
+  "label": "$(json_escape "$(read_env_value "$HANGAR_INFO_ENV" LABEL || true)")",
+  "description": "$(json_escape "$(read_env_value "$HANGAR_INFO_ENV" DESCRIPTION || true)")",
+  "last_client": $(last_client_json),
   "http": {
-    "exists": ${HTTP_EXISTS},
-    "active": ${HTTP_ACTIVE},
-    "port": "${HTTP_PORT}",
-    "user": "${HTTP_USER}"
+    "exists": ${http_exists},
+    "active": ${http_active},
+    "port": "${http_port}",
+    "user": "${http_user}",
+    "egress_ip": "${http_egress_ip}",
+    "instances": $(http_instances_json)
   },
   "socks5": {
     "exists": ${SOCKS_EXISTS},
     "active": ${SOCKS_ACTIVE},
     "port": "${SOCKS_PORT}",
-    "user": "${SOCKS_USER}"
+    "user": "${SOCKS_USER}",
+    "egress_ip": "${SOCKS_EGRESS_IP}"
   }
 }
 EOF_META
   chmod 600 "$HANGAR_META"
+  # The client signs the new content with its local key (see sign_metadata);
+  # until then the old signature no longer matches.
+  rm -f "$HANGAR_SIG"
+  METADATA_WRITTEN=1
+}
+
+print_metadata_written() {
+  [[ "$METADATA_WRITTEN" == "1" && -f "$HANGAR_META" ]] || return 0
+  printf 'BM_METADATA_WRITTEN_B64=%s\n' "$(base64 -w0 "$HANGAR_META")"
+}
+
+# sign_metadata stores the client's signature, but only if hangar.json is
+# still the content the client signed.
+sign_metadata() {
+  [[ "$METADATA_SIGNATURE" =~ ^([0-9a-f]{16}:)?[0-9a-f]{64}$ ]] || die "Invalid --metadata-signature."
+  [[ -f "$HANGAR_META" ]] || die "No hangar metadata to sign."
+  local sum
+  sum="$(sha256sum "$HANGAR_META" | awk '{print $1}')"
+  [[ "$sum" == "$METADATA_SHA256" ]] || die "hangar.json changed before it could be signed."
+  printf '%s\n' "$METADATA_SIGNATURE" >"$HANGAR_SIG"
+  chmod 600 "$HANGAR_SIG"
+  printf 'BM_METADATA_SIGNED=1\n'
 }
 
 reconcile_hangar_status() {
@@ -577,10 +1491,21 @@ reconcile_hangar_status() {
     METADATA_EXISTS=1
   fi
 
-  local any_exists=0
-  if [[ "$SOCKS_EXISTS" == "1" || "$HTTP_EXISTS" == "1" ]]; then
+  local any_exists=0 any_active=0 exists active
+  if [[ "$SOCKS_EXISTS" == "1" ]]; then
     any_exists=1
   fi
+  if [[ "$SOCKS_EXISTS" == "1" && "$SOCKS_ACTIVE" == "1" ]]; then
+    any_active=1
+  fi
+  while read -r exists active; do
+    if [[ "$exists" == "1" ]]; then
+      any_exists=1
+      if [[ "$active" == "1" ]]; then
+        any_active=1
+      fi
+    fi
+  done < <(http_states)
 
   if [[ "$any_exists" == "0" ]]; then
     if [[ "$METADATA_EXISTS" == "1" ]]; then
@@ -591,38 +1516,447 @@ reconcile_hangar_status() {
     return
   fi
 
-  local any_active=0
-  if [[ "$SOCKS_EXISTS" == "1" && "$SOCKS_ACTIVE" == "1" ]]; then
-    any_active=1
-  fi
-  if [[ "$HTTP_EXISTS" == "1" && "$HTTP_ACTIVE" == "1" ]]; then
-    any_active=1
-  fi
-
   if [[ "$any_active" == "1" ]]; then
     HANGAR_STATUS="online"
+  elif traffic_cap_exceeded; then
+    HANGAR_STATUS="quota-exceeded"
+  elif quiet_hours_paused; then
+    HANGAR_STATUS="quiet-hours"
   elif [[ -f "$BLINDER_ENV" && -f "$BLINDER_STATE" ]]; then
     HANGAR_STATUS="blinded"
   else
     HANGAR_STATUS="drift"
   fi
 
-  if [[ "$METADATA_EXISTS" == "0" ]]; then
+  if [[ "$METADATA_EXISTS" == "0" && "$READ_ONLY" == "0" ]]; then
     write_hangar_metadata "$HANGAR_STATUS" "reconstructed metadata from managed config"
     METADATA_EXISTS=1
   fi
 }
 
+socks_listen_local() {
+  if [[ -f "$SOCKS_SOCKET_FILE" ]]; then
+    if grep -q "^ListenStream=127.0.0.1:" "$SOCKS_SOCKET_FILE"; then
+      echo 1
+    else
+      echo 0
+    fi
+  elif [[ -f "$SOCKS_SERVICE_FILE" ]] && grep -q -- "-i 127.0.0.1 " "$SOCKS_SERVICE_FILE"; then
+    echo 1
+  else
+    echo 0
+  fi
+}
+
+http_listen_local() {
+  local conf="$SQUID_CONF"
+  if [[ "$HTTP_MODE" == "sidecar" ]]; then
+    conf="$HTTP_SIDECAR_CONF"
+  fi
+  if [[ -f "$conf" ]] && grep -Eq '^http_port 127\.0\.0\.1:' "$conf"; then
+    echo 1
+  else
+    echo 0
+  fi
+}
+
+ufw_active() {
+  command -v ufw >/dev/null 2>&1 && [[ "$(ufw status 2>/dev/null | head -n 1 || true)" == "Status: active" ]]
+}
+
+# ufw_allowed_ports lists TCP ports with an ALLOW rule, space separated.
+ufw_allowed_ports() {
+  ufw status 2>/dev/null \
+    | awk '$1 ~ /^[0-9]+\/tcp$/ && ($2 == "ALLOW" || $3 == "ALLOW") { split($1, p, "/"); print p[1] }' \
+    | sort -un | tr '\n' ' ' | sed 's/ $//'
+}
+
+# host_ports lists listening TCP ports owned by sshd, squid or microsocks as
+# "port/process", space separated.
+host_ports() {
+  ss -ltnpH 2>/dev/null \
+    | awk 'match($0, /users:\(\("[^"]+"/) {
+        name = substr($0, RSTART + 9, RLENGTH - 10)
+        port = $4; sub(/.*:/, "", port)
+        if (name ~ /^(sshd|squid|microsocks)$/) print port "/" name
+      }' \
+    | sort -t/ -k1,1n -u | tr '\n' ' ' | sed 's/ $//'
+}
+
+# listen_ports lists every listening TCP and UDP socket as
+# "proto:port:process:address", space separated. The process is "-" when ss
+# cannot tell, and spaces or colons in its name become "_".
+listen_ports() {
+  ss -ltunpH 2>/dev/null \
+    | awk '{
+        addr = $5; port = addr
+        sub(/.*:/, "", port); sub(/:[^:]*$/, "", addr)
+        gsub(/[][]/, "", addr); sub(/%.*/, "", addr)
+        name = "-"
+        if (match($0, /users:\(\("[^"]+"/)) name = substr($0, RSTART + 9, RLENGTH - 10)
+        gsub(/[ :]/, "_", name)
+        print $1 ":" port ":" name ":" addr
+      }' \
+    | sort -t: -k2,2n -k1,1 | uniq | tr '\n' ' ' | sed 's/ $//'
+}
+
+# host_addresses lists the server's global IPv4 and IPv6 addresses, the
+# candidates for --egress-ip.
+host_addresses() {
+  ip -o addr show scope global 2>/dev/null \
+    | awk '{ split($4, a, "/"); print a[1] }' \
+    | tr '\n' ' ' | sed 's/ $//'
+}
+
+print_host_info() {
+  local os_name="" uptime=""
+  if [[ -r /etc/os-release ]]; then
+    os_name="$(. /etc/os-release && printf '%s' "${PRETTY_NAME:-${NAME:-}}")"
+  fi
+  uptime="$(awk '{print int($1)}' /proc/uptime 2>/dev/null || true)"
+  printf 'BM_HOST_OS=%s\n' "$os_name"
+  printf 'BM_HOST_KERNEL=%s\n' "$(uname -r 2>/dev/null || true)"
+  printf 'BM_HOST_VIRT=%s\n' "$(systemd-detect-virt 2>/dev/null || true)"
+  printf 'BM_HOST_UPTIME_SECONDS=%s\n' "$uptime"
+  printf 'BM_HOST_PORTS=%s\n' "$(host_ports)"
+  printf 'BM_HOST_ADDRESSES=%s\n' "$(host_addresses)"
+}
+
+# print_http_cache_stats reports the HTTP cache size, disk use and hit count.
+# Requests tunnelled with CONNECT (HTTPS) cannot be cached and are left out.
+print_http_cache_stats() {
+  printf 'BM_HTTP_CACHE_MB=%s\n' "$HTTP_CACHE_MB"
+  printf 'BM_HTTP_CACHE_DIR=%s\n' "$HTTP_CACHE_DIR"
+  (( HTTP_CACHE_MB > 0 )) || return 0
+  local log="/var/log/squid/access.log" used="" counts=""
+  if [[ "$HTTP_MODE" == "sidecar" ]]; then
+    log="$HTTP_SIDECAR_LOG_DIR/access.log"
+  fi
+  if [[ -d "$HTTP_CACHE_DIR" ]]; then
+    used="$(du -sk "$HTTP_CACHE_DIR" 2>/dev/null | awk '{print $1}' || true)"
+  fi
+  if [[ -f "$log" ]]; then
+    counts="$(awk '$6 != "CONNECT" { n++; if ($4 ~ /HIT/) h++ } END { printf "%d %d", h, n }' "$log" 2>/dev/null || true)"
+  fi
+  printf 'BM_HTTP_CACHE_USED_KB=%s\n' "$used"
+  printf 'BM_HTTP_CACHE_HITS=%s\n' "${counts%% *}"
+  printf 'BM_HTTP_CACHE_REQUESTS=%s\n' "${counts##* }"
+}
+
+# print_user_traffic totals the HTTP hangar's access log by proxy user:
+# requests and bytes sent to the client, one "user:requests:bytes" entry per
+# user. Squid logs the authenticated user in field 8; "-" is anonymous.
+print_user_traffic() {
+  load_http_state
+  [[ "$HTTP_EXISTS" == "1" ]] || die "No HTTP hangar here; per-user traffic comes from the Squid access log."
+  local log="/var/log/squid/access.log"
+  if [[ "$HTTP_MODE" == "sidecar" ]]; then
+    log="$HTTP_SIDECAR_LOG_DIR/access.log"
+  fi
+  local since="" users=""
+  if [[ -f "$log" ]]; then
+    since="$(awk 'NR == 1 { printf "%d", $1; exit }' "$log" 2>/dev/null || true)"
+    users="$(awk '$8 != "" { n[$8]++; b[$8] += $5 } END { for (u in n) printf "%s:%d:%.0f ", u, n[u], b[u] }' "$log" 2>/dev/null || true)"
+  fi
+  printf 'BM_USERS_LOG=%s\n' "$log"
+  printf 'BM_USERS_SINCE=%s\n' "$since"
+  printf 'BM_USERS=%s\n' "${users% }"
+}
+
+# print_activity is the cheap snapshot beammeup top polls: the hangar status,
+# clients connected to the proxy ports and the default interface's byte
+# counters, which the client turns into a rate. It never writes anything.
+print_activity() {
+  local READ_ONLY=1
+  load_socks_state
+  load_http_state
+  reconcile_hangar_status
+
+  local filter="" port conns=0 iface rx="" tx=""
+  for port in "$SOCKS_PORT" "$HTTP_PORT"; do
+    is_valid_port "$port" || continue
+    filter="${filter:+$filter or }sport = :$port"
+  done
+  if [[ -n "$filter" ]]; then
+    conns="$(ss -Htn state established "( $filter )" 2>/dev/null | wc -l)"
+  fi
+  iface="$(default_iface)"
+  if [[ -n "$iface" ]]; then
+    rx="$(cat "/sys/class/net/$iface/statistics/rx_bytes" 2>/dev/null || true)"
+    tx="$(cat "/sys/class/net/$iface/statistics/tx_bytes" 2>/dev/null || true)"
+  fi
+
+  printf 'BM_HANGAR_STATUS=%s\n' "$HANGAR_STATUS"
+  printf 'BM_ACTIVITY_CONNS=%s\n' "$conns"
+  printf 'BM_ACTIVITY_IFACE=%s\n' "$iface"
+  printf 'BM_ACTIVITY_RX_BYTES=%s\n' "$rx"
+  printf 'BM_ACTIVITY_TX_BYTES=%s\n' "$tx"
+}
+
+# Hardening drop-ins live next to the unit they tighten, so removing the unit
+# directory's drop-in restores the unit as beammeup wrote it.
+HARDENING_DROPIN="beammeup-hardening.conf"
+
+remove_hardening() {
+  local unit_file="$1"
+  rm -f "${unit_file}.d/${HARDENING_DROPIN}"
+  rmdir "${unit_file}.d" 2>/dev/null || true
+}
+
+beammeup_units() {
+  systemctl list-unit-files 'beammeup-*.service' --no-legend 2>/dev/null | awk '{print $1}'
+}
+
+# unit_exposure prints systemd-analyze's overall "score LABEL" for a unit.
+unit_exposure() {
+  systemd-analyze security --no-pager "$1" 2>/dev/null \
+    | awk '/Overall exposure level/ { if (match($0, /[0-9]+\.[0-9]+ [A-Z]+/)) { print substr($0, RSTART, RLENGTH); exit } }'
+}
+
+# harden_unit installs the hardening drop-in for unit and restarts it if it
+# was running. A unit that does not come back loses the drop-in again.
+harden_unit() {
+  local unit="$1" template="$2"
+  local dir="/etc/systemd/system/${unit}.d"
+  local was_active=0
+  if systemctl is-active --quiet "$unit"; then
+    was_active=1
+  fi
+  mkdir -p "$dir"
+  install_unit "$template" "$dir/$HARDENING_DROPIN"
+  systemctl daemon-reload
+  if (( was_active == 1 )); then
+    systemctl restart "$unit" >/dev/null 2>&1 || true
+    sleep 1
+    if ! systemctl is-active --quiet "$unit"; then
+      log "$unit did not start with the hardening drop-in; removing it."
+      remove_hardening "/etc/systemd/system/${unit}"
+      systemctl daemon-reload
+      systemctl restart "$unit" >/dev/null 2>&1 || true
+      printf 'rolled-back'
+      return
+    fi
+  fi
+  printf 'hardened'
+}
+
+# run_security_audit reports systemd-analyze's exposure score for every
+# beammeup service, one "unit:score:label:state" entry each, where state is
+# hardened, rolled-back, or - without a drop-in. --harden first installs the
+# drop-in on the proxy services; the helper oneshots have nothing to gain.
+run_security_audit() {
+  if ! command -v systemd-analyze >/dev/null 2>&1 || ! systemd-analyze security --help >/dev/null 2>&1; then
+    printf 'BM_SECURITY_AVAILABLE=0\n'
+    return
+  fi
+  local unit template state exposure entries=""
+  while read -r unit; do
+    [[ -n "$unit" ]] || continue
+    template=""
+    case "$unit" in
+      beammeup-microsocks.service) template="hardening-microsocks.conf" ;;
+      beammeup-http-sidecar.service|beammeup-http-sidecar-*.service) template="hardening-squid.conf" ;;
+    esac
+    state="-"
+    if [[ -f "/etc/systemd/system/${unit}.d/${HARDENING_DROPIN}" ]]; then
+      state="hardened"
+    fi
+    if [[ "$HARDEN" == "1" && -n "$template" ]]; then
+      state="$(harden_unit "$unit" "$template")"
+    fi
+    exposure="$(unit_exposure "$unit")"
+    entries+="${unit}:${exposure% *}:${exposure#* }:${state} "
+  done < <(beammeup_units)
+  printf 'BM_SECURITY_AVAILABLE=1\n'
+  printf 'BM_SECURITY=%s\n' "${entries% }"
+}
+
+# run_os_update upgrades the server's packages. Package manager output goes
+# to stderr, which the client streams, so stdout carries only the BM_ lines.
+# --security-only limits apt to unattended-upgrades' default origins (the
+# distro's security pocket) and dnf to updates flagged as security fixes.
+run_os_update() {
+  (( EUID == 0 )) || die "os-update must run as root."
+  local manager reboot=0 reboot_pkgs="" pending=""
+  if command -v apt-get >/dev/null 2>&1; then
+    manager="apt"
+    export DEBIAN_FRONTEND=noninteractive NEEDRESTART_MODE=a
+    local -a apt_opts=(-y -o DPkg::Lock::Timeout=300 -o Dpkg::Options::=--force-confdef -o Dpkg::Options::=--force-confold)
+    log "Refreshing package lists."
+    apt-get "${apt_opts[@]}" update >&2 || die "apt-get update failed."
+    if [[ "$SECURITY_ONLY" == "1" ]]; then
+      if ! command -v unattended-upgrade >/dev/null 2>&1; then
+        log "Installing unattended-upgrades for security-only updates."
+        apt-get "${apt_opts[@]}" install unattended-upgrades >&2 || die "Cannot install unattended-upgrades."
+      fi
+      log "Installing security updates."
+      unattended-upgrade -v >&2 || die "unattended-upgrade failed."
+    else
+      log "Upgrading all packages."
+      apt-get "${apt_opts[@]}" dist-upgrade >&2 || die "apt-get dist-upgrade failed."
+      apt-get "${apt_opts[@]}" autoremove >&2 || true
+    fi
+    pending="$(apt-get -s upgrade 2>/dev/null | grep -c '^Inst ' || true)"
+    if [[ -f /var/run/reboot-required ]]; then
+      reboot=1
+      reboot_pkgs="$(sort -u /var/run/reboot-required.pkgs 2>/dev/null | paste -sd, - || true)"
+    fi
+  elif command -v dnf >/dev/null 2>&1; then
+    manager="dnf"
+    if [[ "$SECURITY_ONLY" == "1" ]]; then
+      log "Installing security updates."
+      dnf -y upgrade --security >&2 || die "dnf upgrade --security failed."
+    else
+      log "Upgrading all packages."
+      dnf -y upgrade >&2 || die "dnf upgrade failed."
+    fi
+    pending="$(dnf -q check-update 2>/dev/null | grep -c '^[A-Za-z0-9]' || true)"
+    if command -v needs-restarting >/dev/null 2>&1 && ! needs-restarting -r >/dev/null 2>&1; then
+      reboot=1
+    fi
+  else
+    die "No supported package manager (apt-get or dnf) found."
+  fi
+
+  printf 'BM_OS_UPDATE=OK\n'
+  printf 'BM_OS_UPDATE_MANAGER=%s\n' "$manager"
+  printf 'BM_OS_UPDATE_PENDING=%s\n' "$pending"
+  printf 'BM_OS_UPDATE_REBOOT_REQUIRED=%s\n' "$reboot"
+  printf 'BM_OS_UPDATE_REBOOT_PACKAGES=%s\n' "$reboot_pkgs"
+}
+
+# print_units lists the beammeup services and timers (and Squid when the
+# hangar uses the system install) that are running, and those that failed.
+print_units() {
+  load_http_state
+  local active failed
+  active="$(systemctl list-units 'beammeup-*' --type=service,socket,timer --state=active --no-legend --plain 2>/dev/null | awk '{print $1}' | sort | tr '\n' ' ' || true)"
+  failed="$(systemctl list-units 'beammeup-*' --type=service,socket,timer --state=failed --no-legend --plain 2>/dev/null | awk '{print $1}' | sort | tr '\n' ' ' || true)"
+  if [[ -f "$SOCKS_SOCKET_FILE" ]]; then
+    # Socket-activated microsocks comes and goes with its clients; the socket
+    # is what has to be up.
+    active="$(printf '%s\n' $active | grep -vxE 'beammeup-microsocks(-proxy)?\.service' | tr '\n' ' ' || true)"
+  fi
+  if [[ "$HTTP_MANAGED" == "1" ]]; then
+    if systemctl is-active --quiet squid; then
+      active+="squid.service "
+    elif systemctl is-failed --quiet squid; then
+      failed+="squid.service "
+    fi
+  fi
+  printf 'BM_UNITS_ACTIVE=%s\n' "${active% }"
+  printf 'BM_UNITS_FAILED=%s\n' "${failed% }"
+}
+
+# print_squid_config describes the system Squid config, so a client can show
+# what is in the way before deciding what to do with a config beammeup did
+# not write. BM_SQUID_CONF is empty when there is none.
+print_squid_config() {
+  if [[ ! -f "$SQUID_CONF" ]]; then
+    printf 'BM_SQUID_CONF=\n'
+    return 0
+  fi
+  local foreign=1
+  if grep -q "managed by beammeup" "$SQUID_CONF" || grep -q "beammeup-proxy" "$SQUID_CONF"; then
+    foreign=0
+  fi
+  local users_file users=""
+  users_file="$(squid_password_file "$SQUID_CONF")"
+  if [[ -n "$users_file" && -r "$users_file" ]]; then
+    users="$(grep -cE '^[^:#[:space:]]+:' "$users_file" || true)"
+  fi
+  printf 'BM_SQUID_CONF=%s\n' "$SQUID_CONF"
+  printf 'BM_SQUID_FOREIGN=%s\n' "$foreign"
+  printf 'BM_SQUID_MODIFIED=%s\n' "$(stat -c %Y "$SQUID_CONF" 2>/dev/null || true)"
+  printf 'BM_SQUID_DIRECTIVES=%s\n' "$(grep -cvE '^[[:space:]]*(#|$)' "$SQUID_CONF" || true)"
+  printf 'BM_SQUID_PORTS=%s\n' "$(awk '$1 == "http_port" || $1 == "https_port" {print $2}' "$SQUID_CONF" | paste -sd, -)"
+  printf 'BM_SQUID_AUTH=%s\n' "$(awk '$1 == "auth_param" {print $2}' "$SQUID_CONF" | sort -u | paste -sd, -)"
+  printf 'BM_SQUID_USERS_FILE=%s\n' "$users_file"
+  printf 'BM_SQUID_USERS=%s\n' "$users"
+  printf 'BM_SQUID_ACLS=%s\n' "$(grep -cE '^[[:space:]]*acl[[:space:]]' "$SQUID_CONF" || true)"
+  printf 'BM_SQUID_INCLUDES=%s\n' "$(awk '$1 == "include" {print $2}' "$SQUID_CONF" | paste -sd, -)"
+  printf 'BM_SQUID_ACTIVE=%s\n' "$(systemctl is-active --quiet squid 2>/dev/null && echo 1 || echo 0)"
+  printf 'BM_SQUID_BACKUP=%s\n' "$( [[ -f "$SQUID_BACKUP" ]] && echo "$SQUID_BACKUP" || true)"
+}
+
+# squid_password_file prints the htpasswd file a Squid config checks basic
+# auth against, when it uses basic_ncsa_auth.
+squid_password_file() {
+  awk '$1 == "auth_param" && $2 == "basic" && $3 == "program" && $4 ~ /basic_ncsa_auth$/ {print $5; exit}' "$1" 2>/dev/null || true
+}
+
+# take_over_squid_config makes way for beammeup's config when the system
+# Squid has one beammeup did not write (--http-conflict). The old config
+# becomes the backup that destroy puts back, an older backup being kept
+# beside it. "replace" then starts clean on --proxy-port or 18181; "adopt"
+# keeps the port the old config listened on and the users of its password
+# file, so the clients already using it keep working.
+take_over_squid_config() {
+  local how="$1"
+  if [[ -f "$SQUID_BACKUP" ]]; then
+    mv "$SQUID_BACKUP" "${SQUID_BACKUP}.$(date -u +%Y%m%dT%H%M%SZ)"
+  fi
+  cp -p "$SQUID_CONF" "$SQUID_BACKUP"
+  rm -f "$HTTP_ADOPTED_HTPASSWD"
+  TAKEOVER_NOTE="The previous Squid config is saved as ${SQUID_BACKUP}; destroy restores it."
+  if [[ "$how" == "replace" ]]; then
+    PROXY_PORT="${PROXY_PORT:-18181}"
+    log "Replacing the existing Squid config at ${SQUID_CONF}."
+    return 0
+  fi
+
+  log "Adopting the existing Squid config at ${SQUID_CONF}${HTTP_PORT:+ on port $HTTP_PORT}."
+  local users_file
+  users_file="$(squid_password_file "$SQUID_CONF")"
+  if [[ -n "$users_file" && -f "$users_file" && "$users_file" != "$HTTP_HTPASSWD" ]]; then
+    grep -E '^[^:#[:space:]]+:' "$users_file" >"$HTTP_ADOPTED_HTPASSWD" || true
+    chmod 600 "$HTTP_ADOPTED_HTPASSWD"
+    local count
+    count="$(wc -l <"$HTTP_ADOPTED_HTPASSWD")"
+    if (( count > 0 )); then
+      TAKEOVER_NOTE+=" Its ${count} proxy user(s) from ${users_file} keep working."
+    else
+      rm -f "$HTTP_ADOPTED_HTPASSWD"
+    fi
+  fi
+}
+
+# schedule_reboot reports what runs now and reboots a few seconds after this
+# session has ended, so the client still gets the answer.
+schedule_reboot() {
+  (( EUID == 0 )) || die "reboot must run as root."
+  print_units
+  if ! systemd-run --quiet --on-active=3 --unit=beammeup-reboot /bin/systemctl reboot >/dev/null 2>&1; then
+    nohup bash -c 'sleep 3; systemctl reboot' >/dev/null 2>&1 &
+  fi
+  log "Rebooting in 3 seconds."
+  printf 'BM_REBOOT=OK\n'
+}
+
 print_inventory() {
+  # Report the metadata as found, before the refresh below rewrites it, so
+  # the client can check it against the signature it stored last time.
+  if [[ -f "$HANGAR_META" ]]; then
+    printf 'BM_METADATA_B64=%s\n' "$(base64 -w0 "$HANGAR_META")"
+    printf 'BM_METADATA_SIG=%s\n' "$(head -n 1 "$HANGAR_SIG" 2>/dev/null || true)"
+  fi
+
   load_socks_state
   load_http_state
   reconcile_hangar_status
 
-  if [[ "$METADATA_EXISTS" == "1" ]]; then
+  # --read-only leaves hangar.json alone so an audit changes nothing.
+  if [[ "$METADATA_EXISTS" == "1" && "$READ_ONLY" == "0" ]]; then
     write_hangar_metadata "$HANGAR_STATUS" "inventory refresh"
   fi
 
   printf 'BM_PUBLIC_IP=%s\n' "$(get_public_ip)"
+  printf 'BM_HANGAR_LABEL=%s\n' "$(read_env_value "$HANGAR_INFO_ENV" LABEL || true)"
+  printf 'BM_HANGAR_DESCRIPTION=%s\n' "$(read_env_value "$HANGAR_INFO_ENV" DESCRIPTION || true)"
+  printf 'BM_LAST_CLIENT=%s\n' "$(read_env_value "$LAST_CLIENT_ENV" CLIENT || true)"
+  printf 'BM_LAST_CLIENT_VERSION=%s\n' "$(read_env_value "$LAST_CLIENT_ENV" VERSION || true)"
+  printf 'BM_LAST_CLIENT_ACTION=%s\n' "$(read_env_value "$LAST_CLIENT_ENV" ACTION || true)"
+  printf 'BM_LAST_CLIENT_AT=%s\n' "$(read_env_value "$LAST_CLIENT_ENV" AT || true)"
 
   printf 'BM_SOCKS_EXISTS=%s\n' "$SOCKS_EXISTS"
   printf 'BM_SOCKS_ACTIVE=%s\n' "$SOCKS_ACTIVE"
@@ -630,6 +1964,17 @@ print_inventory() {
   printf 'BM_SOCKS_USER=%s\n' "$SOCKS_USER"
   printf 'BM_SOCKS_PASS=%s\n' "$SOCKS_PASS"
   printf 'BM_SOCKS_MODE=managed\n'
+  printf 'BM_SOCKS_EGRESS_IP=%s\n' "$SOCKS_EGRESS_IP"
+  printf 'BM_SOCKS_SOCKET_ACTIVATION=%s\n' "$SOCKS_SOCKET_ACTIVATION"
+  local -a limits
+  read -r -a limits <<<"$(read_limits socks5)"
+  printf 'BM_SOCKS_MAX_CONNS=%s\n' "${limits[0]}"
+  printf 'BM_SOCKS_RATE_LIMIT=%s\n' "${limits[1]}"
+  local -a resources
+  read -r -a resources <<<"$(read_resources "$SOCKS_SERVICE")"
+  printf 'BM_SOCKS_MEMORY_MAX=%s\n' "${resources[0]}"
+  printf 'BM_SOCKS_CPU_QUOTA=%s\n' "${resources[1]}"
+  printf 'BM_SOCKS_TASKS_MAX=%s\n' "${resources[2]}"
 
   printf 'BM_HTTP_EXISTS=%s\n' "$HTTP_EXISTS"
   printf 'BM_HTTP_ACTIVE=%s\n' "$HTTP_ACTIVE"
@@ -638,10 +1983,99 @@ print_inventory() {
   printf 'BM_HTTP_LEGACY=%s\n' "$HTTP_LEGACY"
   printf 'BM_HTTP_PORT=%s\n' "$HTTP_PORT"
   printf 'BM_HTTP_USER=%s\n' "$HTTP_USER"
+  printf 'BM_HTTP_SQUID_VERSION=%s\n' "$(squid_version)"
   printf 'BM_HTTP_PASS=%s\n' "$HTTP_PASS"
+  printf 'BM_HTTP_EGRESS_IP=%s\n' "$HTTP_EGRESS_IP"
+  printf 'BM_HTTP_INSTANCE=%s\n' "$HTTP_INSTANCE"
+  printf 'BM_HTTP_ANONYMITY=%s\n' "$HTTP_ANONYMITY"
+  read -r -a limits <<<"$(read_limits "$(limits_key http)")"
+  printf 'BM_HTTP_MAX_CONNS=%s\n' "${limits[0]}"
+  printf 'BM_HTTP_RATE_LIMIT=%s\n' "${limits[1]}"
+  local http_unit="$HTTP_SIDECAR_SERVICE"
+  if [[ "$HTTP_MANAGED" == "1" ]]; then
+    http_unit="squid.service"
+  fi
+  read -r -a resources <<<"$(read_resources "$http_unit")"
+  printf 'BM_HTTP_MEMORY_MAX=%s\n' "${resources[0]}"
+  printf 'BM_HTTP_CPU_QUOTA=%s\n' "${resources[1]}"
+  printf 'BM_HTTP_TASKS_MAX=%s\n' "${resources[2]}"
+  printf 'BM_HTTP_ALLOW_DOMAINS=%s\n' "$(domain_list_csv allow "$HTTP_MODE")"
+  printf 'BM_HTTP_DENY_DOMAINS=%s\n' "$(domain_list_csv deny "$HTTP_MODE")"
+  local grace="${HTTP_HTPASSWD}.grace"
+  if [[ "$HTTP_MODE" == "sidecar" ]]; then
+    grace="${HTTP_SIDECAR_HTPASSWD}.grace"
+  fi
+  printf 'BM_HTTP_GRACE_USER=%s\n' "$(read_env_value "$grace" USER || true)"
+  printf 'BM_HTTP_GRACE_EXPIRES_AT=%s\n' "$(read_env_value "$grace" EXPIRES_AT || true)"
+  print_http_cache_stats
+  printf 'BM_HTTP_INSTANCES=%s\n' "$(http_instances_summary)"
+
+  printf 'BM_SOCKS_LISTEN_LOCAL=%s\n' "$(socks_listen_local)"
+  printf 'BM_HTTP_LISTEN_LOCAL=%s\n' "$(http_listen_local)"
+  printf 'BM_LISTEN_PORTS=%s\n' "$(listen_ports)"
+
+  if ufw_active; then
+    printf 'BM_UFW_ACTIVE=1\n'
+    printf 'BM_UFW_ALLOWED_PORTS=%s\n' "$(ufw_allowed_ports)"
+  else
+    printf 'BM_UFW_ACTIVE=0\n'
+  fi
+
+  if [[ -f "$BLINDER_ENV" ]]; then
+    local idle_seconds
+    idle_seconds="$(read_env_value "$BLINDER_ENV" IDLE_SECONDS || true)"
+    [[ "$idle_seconds" =~ ^[0-9]+$ ]] || idle_seconds=0
+    printf 'BM_SMART_BLINDER=1\n'
+    printf 'BM_SMART_BLINDER_IDLE_MINUTES=%s\n' "$(( idle_seconds / 60 ))"
+    printf 'BM_SMART_BLINDER_KEEPALIVE=%s\n' "$(stat -c %Y "$BLINDER_KEEPALIVE" 2>/dev/null || true)"
+  else
+    printf 'BM_SMART_BLINDER=0\n'
+  fi
+
+  printf 'BM_TRAFFIC_CAP_BYTES=%s\n' "$(read_env_value "$CAP_ENV" CAP_BYTES || true)"
+  printf 'BM_EXPIRES_AT=%s\n' "$(read_env_value "$TTL_ENV" EXPIRES_AT || true)"
+  printf 'BM_QUIET_HOURS=%s\n' "$(read_env_value "$QUIET_ENV" WINDOWS || true)"
 
   printf 'BM_HANGAR_STATUS=%s\n' "$HANGAR_STATUS"
   printf 'BM_METADATA_EXISTS=%s\n' "$METADATA_EXISTS"
+  print_host_info
+}
+
+print_usage() {
+  local iface rx="" tx="" source="" period=""
+  iface="$(default_iface)"
+
+  if command -v vnstat >/dev/null 2>&1 && [[ -n "$iface" ]]; then
+    local line
+    local -a f
+    line="$(vnstat --oneline b -i "$iface" 2>/dev/null || true)"
+    IFS=';' read -r -a f <<<"$line"
+    if [[ "${#f[@]}" -ge 10 && "${f[8]}" =~ ^[0-9]+$ && "${f[9]}" =~ ^[0-9]+$ ]]; then
+      source="vnstat"
+      period="${f[7]}"
+      rx="${f[8]}"
+      tx="${f[9]}"
+    fi
+  fi
+
+  if [[ -z "$source" ]]; then
+    (( EUID == 0 )) || die "Traffic accounting requires vnstat or root for iptables counters."
+    if [[ "$READ_ONLY" == "1" ]]; then
+      iptables -nL "$ACCT_IN_CHAIN" >/dev/null 2>&1 || die "Traffic accounting is not set up and --read-only leaves iptables alone: install vnstat or read usage once without --read-only."
+    else
+      ensure_traffic_accounting || die "Traffic accounting unavailable: install vnstat or iptables."
+    fi
+    rx="$(read_chain_bytes "$ACCT_IN_CHAIN")"
+    tx="$(read_chain_bytes "$ACCT_OUT_CHAIN")"
+    [[ "$rx" =~ ^[0-9]+$ && "$tx" =~ ^[0-9]+$ ]] || die "Failed to read iptables traffic counters."
+    source="iptables"
+  fi
+
+  printf 'BM_USAGE_SOURCE=%s\n' "$source"
+  printf 'BM_USAGE_IFACE=%s\n' "$iface"
+  printf 'BM_USAGE_PERIOD=%s\n' "$period"
+  printf 'BM_USAGE_RX_BYTES=%s\n' "$rx"
+  printf 'BM_USAGE_TX_BYTES=%s\n' "$tx"
 }
 
 emit_result() {
@@ -660,11 +2094,125 @@ emit_result() {
   printf 'BM_RESULT_HTTP_MODE=%s\n' "$HTTP_MODE"
   printf 'BM_RESULT_ACTION=%s\n' "$action"
   printf 'BM_RESULT_FIREWALL_NOTE=%s\n' "${FIREWALL_NOTE:-}"
+  printf 'BM_RESULT_EGRESS_IP=%s\n' "${RESULT_EGRESS_IP:-}"
+  printf 'BM_RESULT_VERIFIED=%s\n' "${VERIFY_NOTE:-}"
   printf 'BM_RESULT_NOTE=%s\n' "$note"
 }
 
+# verify_proxy sends a request through the new proxy with the new
+# credentials, from the server itself, and fails the apply (which then rolls
+# back) unless an echo endpoint answers through it. An active unit alone
+# does not show that the credentials or the way out work.
+verify_proxy() {
+  local kind="$1" port="$2" user="$3" pass="$4" allow_file="${5:-}"
+  VERIFY_NOTE=""
+  if [[ "$VERIFY" != "1" ]]; then
+    VERIFY_NOTE="skipped by request"
+    return 0
+  fi
+  if [[ -f "$CAP_EXCEEDED" ]]; then
+    VERIFY_NOTE="skipped, the traffic cap has the proxy stopped"
+    return 0
+  fi
+  if [[ -n "$allow_file" && -f "$allow_file" ]]; then
+    VERIFY_NOTE="skipped, the destination allow list would refuse the echo endpoint"
+    return 0
+  fi
+  if [[ "$EGRESS_IP" == *:* ]]; then
+    VERIFY_NOTE="skipped, the echo endpoints only answer over IPv4"
+    return 0
+  fi
+
+  begin_phase verify
+  local url="" candidate
+  for candidate in $VERIFY_URLS; do
+    if curl -4fsS --max-time 10 -o /dev/null "$candidate" 2>/dev/null; then
+      url="$candidate"
+      break
+    fi
+  done
+  if [[ -z "$url" ]]; then
+    log "Skipping the proxy check: the server cannot reach $VERIFY_URLS directly."
+    VERIFY_NOTE="skipped, the server cannot reach an echo endpoint"
+    return 0
+  fi
+
+  local proxy="http://127.0.0.1:$port"
+  [[ "$kind" == "socks5" ]] && proxy="socks5h://127.0.0.1:$port"
+  local err_file exit_ip="" attempt
+  err_file="$(mktemp)"
+  for attempt in 1 2 3; do
+    if exit_ip="$(curl -4fsS --max-time 15 -x "$proxy" --proxy-user "$user:$pass" "$url" 2>"$err_file")"; then
+      break
+    fi
+    exit_ip=""
+    sleep 2
+  done
+  local err
+  err="$(tail -n 1 "$err_file")"
+  rm -f "$err_file"
+  [[ -n "$exit_ip" ]] || die "No request got through the new proxy on port $port with its credentials: ${err:-no answer}."
+  exit_ip="$(tr -d '[:space:]' <<<"$exit_ip")"
+  if [[ -n "$EGRESS_IP" && "$exit_ip" != "$EGRESS_IP" ]]; then
+    die "Requests through the proxy leave from $exit_ip instead of --egress-ip $EGRESS_IP."
+  fi
+  VERIFY_NOTE="yes, a request through the proxy left from $exit_ip"
+}
+
+# check_resources measures free disk, available memory and load. It refuses
+# when installing the proxy would exhaust the server (Squid wants a few
+# hundred MB of disk and about 128 MB of RAM; microsocks next to nothing)
+# and collects softer concerns in RESOURCE_WARNINGS.
+check_resources() {
+  local need_disk_mb=50
+  local need_mem_mb=16
+  if [[ "$PROTOCOL" == "http" ]] && ! dpkg -s squid >/dev/null 2>&1; then
+    need_disk_mb=300
+    need_mem_mb=128
+  fi
+
+  RESOURCE_DISK_FREE_MB="$(df -Pm /var 2>/dev/null | awk 'NR==2 {print $4}' || true)"
+  RESOURCE_MEM_AVAILABLE_MB="$(awk '/^MemAvailable:/ {print int($2 / 1024)}' /proc/meminfo 2>/dev/null || true)"
+  RESOURCE_LOAD="$(awk '{print $1}' /proc/loadavg 2>/dev/null || true)"
+  RESOURCE_CPUS="$(nproc 2>/dev/null || echo 1)"
+  RESOURCE_WARNINGS=()
+
+  if [[ "$RESOURCE_DISK_FREE_MB" =~ ^[0-9]+$ ]]; then
+    (( RESOURCE_DISK_FREE_MB >= need_disk_mb )) || die "Only ${RESOURCE_DISK_FREE_MB} MB free on /var; the ${PROTOCOL} install needs about ${need_disk_mb} MB."
+    (( RESOURCE_DISK_FREE_MB >= need_disk_mb * 4 )) || RESOURCE_WARNINGS+=("disk is nearly full (${RESOURCE_DISK_FREE_MB} MB free on /var)")
+  fi
+  if [[ "$RESOURCE_MEM_AVAILABLE_MB" =~ ^[0-9]+$ ]]; then
+    (( RESOURCE_MEM_AVAILABLE_MB >= need_mem_mb )) || die "Only ${RESOURCE_MEM_AVAILABLE_MB} MB of memory available; the ${PROTOCOL} proxy needs about ${need_mem_mb} MB."
+    (( RESOURCE_MEM_AVAILABLE_MB >= need_mem_mb * 2 )) || RESOURCE_WARNINGS+=("memory is tight (${RESOURCE_MEM_AVAILABLE_MB} MB available)")
+  fi
+  if [[ "$RESOURCE_LOAD" =~ ^[0-9.]+$ && "$RESOURCE_CPUS" =~ ^[0-9]+$ ]]; then
+    if awk -v l="$RESOURCE_LOAD" -v c="$RESOURCE_CPUS" 'BEGIN { exit !(l > c * 2) }'; then
+      RESOURCE_WARNINGS+=("load average ${RESOURCE_LOAD} is high for ${RESOURCE_CPUS} CPU(s)")
+    fi
+  fi
+
+  local warning
+  for warning in "${RESOURCE_WARNINGS[@]}"; do
+    log "Warning: ${warning}"
+  done
+}
+
+# check_egress_ip makes sure --egress-ip names an address this server owns;
+# Squid and microsocks would otherwise fail every outbound connection.
+check_egress_ip() {
+  [[ -n "$EGRESS_IP" ]] || return 0
+  [[ "$EGRESS_IP" =~ ^[0-9A-Fa-f.:]+$ ]] || die "Invalid --egress-ip value: $EGRESS_IP"
+  local addr
+  for addr in $(host_addresses); do
+    [[ "$addr" == "$EGRESS_IP" ]] && return 0
+  done
+  die "Egress IP $EGRESS_IP is not assigned to this server (available: $(host_addresses))."
+}
+
 run_preflight() {
   ensure_requirements
+  check_resources
+  check_egress_ip
   load_socks_state
   load_http_state
 
@@ -683,10 +2231,10 @@ run_preflight() {
     fi
 
     local mode="${HTTP_MODE_REQUEST:-auto}"
-    if [[ "$mode" == "auto" && "$HTTP_MODE" == "sidecar" ]]; then
+    if [[ "$mode" == "auto" && ( "$HTTP_MODE" == "sidecar" || -n "$HTTP_INSTANCE" ) ]]; then
       mode="sidecar"
     fi
-    if [[ "$mode" != "sidecar" ]]; then
+    if [[ "$mode" != "sidecar" && -z "$HTTP_CONFLICT" ]]; then
       if [[ -f "$SQUID_CONF" ]] && ! grep -q "managed by beammeup" "$SQUID_CONF"; then
         if ! grep -q "beammeup-proxy" "$SQUID_CONF"; then
           die "Existing non-beammeup Squid config detected at $SQUID_CONF. Use --http-mode sidecar or choose SOCKS5."
@@ -701,11 +2249,260 @@ run_preflight() {
   printf 'BM_PREFLIGHT=OK\n'
   printf 'BM_PREFLIGHT_PROTOCOL=%s\n' "$PROTOCOL"
   printf 'BM_PREFLIGHT_PORT=%s\n' "$chosen_port"
+  printf 'BM_PREFLIGHT_EGRESS_IP=%s\n' "$EGRESS_IP"
+  printf 'BM_PREFLIGHT_DISK_FREE_MB=%s\n' "$RESOURCE_DISK_FREE_MB"
+  printf 'BM_PREFLIGHT_MEM_AVAILABLE_MB=%s\n' "$RESOURCE_MEM_AVAILABLE_MB"
+  printf 'BM_PREFLIGHT_LOAD=%s\n' "$RESOURCE_LOAD"
+  printf 'BM_PREFLIGHT_CPUS=%s\n' "$RESOURCE_CPUS"
+  local IFS=';'
+  printf 'BM_PREFLIGHT_WARNINGS=%s\n' "${RESOURCE_WARNINGS[*]}"
+}
+
+# keep_previous_credential puts the pre-rotation htpasswd entry back next to
+# the new one and records when it expires. The grace timer removes it then.
+keep_previous_credential() {
+  local htpasswd_file="$1" entry="$2" conf="$3"
+  [[ -n "$entry" ]] || return 0
+  printf '%s\n' "$entry" >>"$htpasswd_file"
+  local expires_at=$(( $(date +%s) + ROTATE_GRACE_SECONDS ))
+  printf 'USER=%s\nEXPIRES_AT=%s\nCONF=%s\n' "${entry%%:*}" "$expires_at" "$conf" >"${htpasswd_file}.grace"
+  chmod 600 "${htpasswd_file}.grace"
+  enable_rotation_grace
+  GRACE_NOTE="Previous credentials (${entry%%:*}) stay valid until $(date -u -d "@${expires_at}" '+%Y-%m-%d %H:%M UTC')."
+}
+
+# previous_credential prints the htpasswd entry a rotation with
+# --rotate-grace-seconds should keep alive.
+previous_credential() {
+  local htpasswd_file="$1"
+  (( ROTATE_GRACE_SECONDS > 0 )) || return 0
+  [[ "$ROTATE_CREDENTIALS" -eq 1 && -n "$HTTP_USER" && -f "$htpasswd_file" ]] || return 0
+  grep -m1 "^${HTTP_USER}:" "$htpasswd_file" || true
+}
+
+disable_rotation_grace() {
+  if service_defined "$GRACE_TIMER"; then
+    systemctl disable --now "$GRACE_TIMER" >/dev/null 2>&1 || true
+  fi
+  rm -f "$GRACE_SCRIPT" "$GRACE_SERVICE_FILE" "$GRACE_TIMER_FILE"
+  systemctl daemon-reload
+}
+
+enable_rotation_grace() {
+  cat >"$GRACE_SCRIPT" <<'EOF_GRACE'
+#!/usr/bin/env bash
+set -euo pipefail
+
+read_env_value() {
+  local file="$1"
+  local key="$2"
+  [[ -f "$file" ]] || return 1
+  grep -m1 "^${key}=" "$file" | cut -d= -f2- || true
+}
+
+now="$(date +%s)"
+pending=0
+for grace in /etc/beammeup/http.htpasswd.grace /etc/beammeup/http-sidecar*/htpasswd.grace; do
+  [[ -f "$grace" ]] || continue
+  expires_at="$(read_env_value "$grace" EXPIRES_AT || true)"
+  if [[ "$expires_at" =~ ^[0-9]+$ ]] && (( now < expires_at )); then
+    pending=1
+    continue
+  fi
+  htpasswd_file="${grace%.grace}"
+  user="$(read_env_value "$grace" USER || true)"
+  conf="$(read_env_value "$grace" CONF || true)"
+  if [[ -n "$user" && -f "$htpasswd_file" ]]; then
+    htpasswd -D "$htpasswd_file" "$user" >/dev/null 2>&1 || true
+    # Restart the auth helpers so cached logins of the old user end too.
+    squid -k reconfigure -f "$conf" >/dev/null 2>&1 || true
+  fi
+  rm -f "$grace"
+done
+
+if (( pending == 0 )); then
+  systemctl disable beammeup-rotate-grace.timer >/dev/null 2>&1 || true
+fi
+EOF_GRACE
+  chmod 700 "$GRACE_SCRIPT"
+
+  install_unit rotate-grace.service "$GRACE_SERVICE_FILE"
+  install_unit rotate-grace.timer "$GRACE_TIMER_FILE"
+
+  systemctl daemon-reload
+  systemctl enable --now "$GRACE_TIMER" >/dev/null 2>&1 || true
+}
+
+rotation_note() {
+  if [[ "$ROTATE_SCOPE" == "password" ]]; then
+    echo "Password rotated; username kept."
+  else
+    echo "Credentials rotated."
+  fi
+}
+
+# socket_proxyd_bin prints the systemd-socket-proxyd that --socket-activation
+# puts in front of microsocks. --exit-idle-time, which lets it (and with it
+# microsocks) exit once the last client is gone, needs systemd 246.
+socket_proxyd_bin() {
+  local version bin
+  version="$(systemctl --version 2>/dev/null | awk 'NR == 1 { print $2 }')"
+  version="${version%%[^0-9]*}"
+  [[ -n "$version" ]] && (( version >= 246 )) || return 1
+  for bin in /usr/lib/systemd/systemd-socket-proxyd /lib/systemd/systemd-socket-proxyd; do
+    if [[ -x "$bin" ]]; then
+      echo "$bin"
+      return 0
+    fi
+  done
+  return 1
+}
+
+# socks_backend_port picks the loopback port socket-activated microsocks
+# listens on, keeping the one already in use.
+socks_backend_port() {
+  local port
+  if is_valid_port "$SOCKS_BACKEND_PORT"; then
+    echo "$SOCKS_BACKEND_PORT"
+    return 0
+  fi
+  for (( port = 41080; port < 41180; port++ )); do
+    if ! port_in_use "$port"; then
+      echo "$port"
+      return 0
+    fi
+  done
+  return 1
+}
+
+# remove_socks_socket drops the socket activation units, for destroy and for
+# going back to an always-on microsocks.
+remove_socks_socket() {
+  if service_defined "$SOCKS_SOCKET"; then
+    systemctl disable --now "$SOCKS_SOCKET" >/dev/null 2>&1 || true
+  fi
+  if service_defined "$SOCKS_PROXYD_SERVICE"; then
+    systemctl stop "$SOCKS_PROXYD_SERVICE" >/dev/null 2>&1 || true
+  fi
+  rm -f "$SOCKS_SOCKET_FILE" "$SOCKS_PROXYD_SERVICE_FILE" "$SOCKS_WAIT_SCRIPT"
+}
+
+# microsocks_packaged succeeds when apt can install microsocks.
+microsocks_packaged() {
+  local candidate
+  candidate="$(apt-cache policy microsocks 2>/dev/null | awk '/Candidate:/ {print $2}')"
+  [[ -n "$candidate" && "$candidate" != "(none)" ]]
+}
+
+# sha256_matches succeeds when file has the given sha256.
+sha256_matches() {
+  local file="$1" want="$2" got
+  got="$(sha256sum "$file" | awk '{print $1}')"
+  [[ "$got" == "$want" ]]
+}
+
+# ensure_microsocks makes microsocks available: the distro package where
+# there is one, else the static binary the client uploaded
+# (--microsocks-binary), else the pinned release built from source. The last
+# two go to MICROSOCKS_LOCAL_BIN.
+ensure_microsocks() {
+  if command -v microsocks >/dev/null 2>&1 || [[ -x "$MICROSOCKS_LOCAL_BIN" ]]; then
+    rm -f "$MICROSOCKS_UPLOAD"
+    return 0
+  fi
+
+  local log_file="${REMOTE_TMPDIR}/beammeup-install.log"
+  if ! microsocks_packaged; then
+    DEBIAN_FRONTEND=noninteractive apt-get update >>"$log_file" 2>&1 || true
+  fi
+  if microsocks_packaged; then
+    rm -f "$MICROSOCKS_UPLOAD"
+    ensure_packages microsocks
+    return 0
+  fi
+
+  if [[ -n "$MICROSOCKS_UPLOAD_SHA256" ]]; then
+    [[ -f "$MICROSOCKS_UPLOAD" ]] || die "The uploaded microsocks binary is missing from $MICROSOCKS_UPLOAD."
+    if ! sha256_matches "$MICROSOCKS_UPLOAD" "$MICROSOCKS_UPLOAD_SHA256"; then
+      rm -f "$MICROSOCKS_UPLOAD"
+      die "The uploaded microsocks binary does not match its checksum."
+    fi
+    log "No microsocks package here; installing the uploaded binary to $MICROSOCKS_LOCAL_BIN"
+    install -m 755 "$MICROSOCKS_UPLOAD" "$MICROSOCKS_LOCAL_BIN"
+    rm -f "$MICROSOCKS_UPLOAD"
+    return 0
+  fi
+
+  [[ -n "$MICROSOCKS_SHA256" ]] || die "No microsocks package here and no checksum pinned for building microsocks $MICROSOCKS_VERSION; upload a static build with --microsocks-binary."
+  log "No microsocks package here; building microsocks $MICROSOCKS_VERSION from source"
+  ensure_packages gcc make libc6-dev curl ca-certificates
+
+  local work
+  work="$(mktemp -d)"
+  if ! curl -fsSL --retry 3 -o "$work/microsocks.tar.gz" "$MICROSOCKS_URL" >>"$log_file" 2>&1; then
+    rm -rf "$work"
+    die "Could not download microsocks $MICROSOCKS_VERSION from $MICROSOCKS_URL."
+  fi
+  if ! sha256_matches "$work/microsocks.tar.gz" "$MICROSOCKS_SHA256"; then
+    rm -rf "$work"
+    die "The microsocks $MICROSOCKS_VERSION tarball does not match its pinned checksum."
+  fi
+  if ! tar -xzf "$work/microsocks.tar.gz" -C "$work" --strip-components=1 >>"$log_file" 2>&1 ||
+    ! make -C "$work" >>"$log_file" 2>&1; then
+    tail -n 50 "$log_file" >&2 || true
+    rm -rf "$work"
+    die "Building microsocks $MICROSOCKS_VERSION failed."
+  fi
+  install -m 755 "$work/microsocks" "$MICROSOCKS_LOCAL_BIN"
+  rm -rf "$work"
+}
+
+# install_proxy_packages installs what the proxy for PROTOCOL runs on. The
+# prepare run does it before the server is examined; apply then finds it all.
+install_proxy_packages() {
+  if [[ "$PROTOCOL" == "socks5" ]]; then
+    ensure_packages curl iproute2
+    ensure_microsocks
+  else
+    ensure_packages squid apache2-utils curl iproute2
+  fi
+}
+
+# resolve_socks works out what the SOCKS5 config is rendered with into the
+# APPLY_* variables. It only reads the server, so the prepare run and the
+# apply that follows reach the same answer.
+resolve_socks() {
+  load_socks_state
+  load_http_state
+
+  APPLY_PORT="${PROXY_PORT:-${SOCKS_PORT:-1080}}"
+  is_valid_port "$APPLY_PORT" || die "Invalid proxy port: $APPLY_PORT"
+  ensure_port_available "$APPLY_PORT" "$SOCKS_PORT"
+
+  APPLY_BIND_IP="0.0.0.0"
+  if [[ "${LISTEN_LOCAL:-0}" -eq 1 ]]; then
+    APPLY_BIND_IP="127.0.0.1"
+  fi
+
+  APPLY_MICROSOCKS_BIN="$(command -v microsocks || true)"
+  if [[ -z "$APPLY_MICROSOCKS_BIN" && -x "$MICROSOCKS_LOCAL_BIN" ]]; then
+    APPLY_MICROSOCKS_BIN="$MICROSOCKS_LOCAL_BIN"
+  fi
+  [[ -n "$APPLY_MICROSOCKS_BIN" ]] || die "microsocks binary not found after install."
+
+  APPLY_PROXYD_BIN=""
+  APPLY_BACKEND_PORT=""
+  if [[ "$SOCKET_ACTIVATION" -eq 1 ]]; then
+    APPLY_PROXYD_BIN="$(socket_proxyd_bin)" || die "--socket-activation needs systemd 246 or newer with systemd-socket-proxyd."
+    APPLY_BACKEND_PORT="$(socks_backend_port)" || die "No free loopback port for socket-activated microsocks."
+  fi
 }
 
 apply_socks() {
   ensure_requirements
-  ensure_packages microsocks curl iproute2
+  begin_phase install
+  install_proxy_packages
+  begin_phase configure
 
   mkdir -p "$BEAM_DIR"
 
@@ -713,71 +2510,73 @@ apply_socks() {
     useradd --system --no-create-home --shell /usr/sbin/nologin beammeup
   fi
 
-  load_socks_state
-  load_http_state
+  resolve_socks
+  check_prepared
 
   local existed="$SOCKS_EXISTS"
-  local desired_port="${PROXY_PORT:-${SOCKS_PORT:-1080}}"
+  local desired_port="$APPLY_PORT"
   local final_user="$SOCKS_USER"
   local final_pass="$SOCKS_PASS"
   local note=""
-  local bind_ip="0.0.0.0"
-
-  if [[ "${LISTEN_LOCAL:-0}" -eq 1 ]]; then
-    bind_ip="127.0.0.1"
-  fi
-
-  is_valid_port "$desired_port" || die "Invalid proxy port: $desired_port"
-  ensure_port_available "$desired_port" "$SOCKS_PORT"
+  local backend_port="$APPLY_BACKEND_PORT"
 
-  if [[ -z "$final_user" || "$ROTATE_CREDENTIALS" -eq 1 ]]; then
+  if [[ -z "$final_user" || ( "$ROTATE_CREDENTIALS" -eq 1 && "$ROTATE_SCOPE" == "both" ) ]]; then
     final_user="beam$(generate_secret 'a-z0-9' 5)"
   fi
   if [[ -z "$final_pass" || "$ROTATE_CREDENTIALS" -eq 1 ]]; then
     final_pass="$(generate_secret 'A-Za-z0-9' 20)"
   fi
 
-  local microsocks_bin
-  microsocks_bin="$(command -v microsocks || true)"
-  [[ -n "$microsocks_bin" ]] || die "microsocks binary not found after install."
-
   cat >"$SOCKS_ENV" <<EOF_ENV
 PROXY_PORT=$desired_port
 PROXY_USER=$final_user
 PROXY_PASS=$final_pass
+EGRESS_IP=$EGRESS_IP
+BACKEND_PORT=$backend_port
 EOF_ENV
   chmod 600 "$SOCKS_ENV"
 
-  cat >"$SOCKS_SERVICE_FILE" <<EOF_UNIT
-[Unit]
-Description=Beammeup SOCKS5 Proxy
-After=network-online.target
-Wants=network-online.target
-
-[Service]
-Type=simple
-User=beammeup
-Group=beammeup
-EnvironmentFile=$SOCKS_ENV
-ExecStart=$microsocks_bin -i $bind_ip -p \${PROXY_PORT} -u \${PROXY_USER} -P \${PROXY_PASS}
-Restart=always
-RestartSec=2
-NoNewPrivileges=true
-PrivateTmp=true
-ProtectHome=true
-ProtectSystem=strict
-LimitNOFILE=32768
+  if [[ "$SOCKET_ACTIVATION" -eq 1 ]]; then
+    # The socket takes over the public port from an always-on microsocks.
+    systemctl disable --now "$SOCKS_SERVICE" >/dev/null 2>&1 || true
 
-[Install]
-WantedBy=multi-user.target
-EOF_UNIT
-  chmod 644 "$SOCKS_SERVICE_FILE"
+    cat >"$SOCKS_WAIT_SCRIPT" <<'EOF_WAIT'
+#!/usr/bin/env bash
+# Holds back systemd-socket-proxyd until microsocks listens, so the client
+# that woke it is not refused.
+for _ in $(seq 1 50); do
+  ss -Hltn "( sport = :$1 )" 2>/dev/null | grep -q . && exit 0
+  sleep 0.1
+done
+exit 1
+EOF_WAIT
+    chmod 700 "$SOCKS_WAIT_SCRIPT"
+
+    install_unit microsocks-ondemand.service "$SOCKS_SERVICE_FILE"
+    install_unit microsocks-proxy.service "$SOCKS_PROXYD_SERVICE_FILE"
+    install_unit microsocks.socket "$SOCKS_SOCKET_FILE"
+
+    systemctl daemon-reload
+    # A changed port or credential only reaches a running microsocks on its
+    # next start.
+    systemctl stop "$SOCKS_PROXYD_SERVICE" "$SOCKS_SERVICE" >/dev/null 2>&1 || true
+    systemctl enable "$SOCKS_SOCKET" >/dev/null 2>&1
+    systemctl restart "$SOCKS_SOCKET"
+    if ! systemctl is-active --quiet "$SOCKS_SOCKET"; then
+      journalctl -u "$SOCKS_SOCKET" -n 50 --no-pager >&2 || true
+      die "SOCKS5 socket failed to start."
+    fi
+  else
+    remove_socks_socket
 
-  systemctl daemon-reload
-  systemctl enable --now "$SOCKS_SERVICE"
-  if ! systemctl is-active --quiet "$SOCKS_SERVICE"; then
-    journalctl -u "$SOCKS_SERVICE" -n 50 --no-pager >&2 || true
-    die "SOCKS5 service failed to start."
+    install_unit microsocks.service "$SOCKS_SERVICE_FILE"
+
+    systemctl daemon-reload
+    systemctl enable --now "$SOCKS_SERVICE"
+    if ! systemctl is-active --quiet "$SOCKS_SERVICE"; then
+      journalctl -u "$SOCKS_SERVICE" -n 50 --no-pager >&2 || true
+      die "SOCKS5 service failed to start."
+    fi
   fi
 
   if [[ "${LISTEN_LOCAL:-0}" -eq 1 ]]; then
@@ -786,31 +2585,163 @@ EOF_UNIT
     apply_firewall_rule "$desired_port"
   fi
 
+  configure_limits socks5 "$desired_port"
+  configure_resources "$SOCKS_SERVICE"
   configure_smart_blinder
 
   if [[ "$ROTATE_CREDENTIALS" -eq 1 ]]; then
-    note="Credentials rotated."
+    note="$(rotation_note)"
   fi
 
+  verify_proxy socks5 "$desired_port" "$final_user" "$final_pass"
+
   load_socks_state
   load_http_state
   reconcile_hangar_status
   write_hangar_metadata "$HANGAR_STATUS" "updated socks5"
+  RESULT_EGRESS_IP="$SOCKS_EGRESS_IP"
 
   emit_result "SOCKS5" "$desired_port" "$final_user" "$final_pass" \
     "$( [[ "$existed" == "1" ]] && echo updated || echo created )" "$note"
 }
 
+# http_cache_dir is where Squid keeps the disk cache of the current HTTP
+# hangar: --cache-dir, or a directory next to the hangar's own files.
+http_cache_dir() {
+  local mode="$1"
+  if [[ -n "$CACHE_DIR" ]]; then
+    echo "$CACHE_DIR"
+  elif [[ "$mode" == "sidecar" ]]; then
+    echo "${HTTP_SIDECAR_DIR}/cache"
+  else
+    echo "$HTTP_MANAGED_CACHE_DIR"
+  fi
+}
+
+# domain_list_file is where the HTTP hangar keeps its allow or deny list of
+# destination domains, one per line.
+domain_list_file() {
+  local kind="$1"
+  local mode="$2"
+  if [[ "$mode" == "sidecar" ]]; then
+    echo "${HTTP_SIDECAR_DIR}/${kind}-domains"
+  else
+    echo "${BEAM_DIR}/http-${kind}-domains"
+  fi
+}
+
+# write_domain_lists stores --allow-domains and --deny-domains for mode. An
+# empty list removes the file, lifting that restriction.
+write_domain_lists() {
+  local mode="$1"
+  local kind list file
+  for kind in allow deny; do
+    list="$ALLOW_DOMAINS"
+    [[ "$kind" == "allow" ]] || list="$DENY_DOMAINS"
+    file="$(domain_list_file "$kind" "$mode")"
+    if [[ -n "$list" ]]; then
+      tr ',' '\n' <<<"$list" >"$file"
+      chmod 644 "$file"
+    else
+      rm -f "$file"
+    fi
+  done
+}
+
+# detect_squid_version reads the installed Squid's version. The client renders
+# squid.conf with only the directives that version understands; see
+# TemplateData.SquidAtLeast in templates.go.
+detect_squid_version() {
+  SQUID_VERSION="$(squid_version)"
+  [[ -n "$SQUID_VERSION" ]] || die "Could not tell which Squid is installed from squid -v."
+  IFS=. read -r SQUID_MAJOR SQUID_MINOR _ <<<"${SQUID_VERSION}.0.0"
+  squid_at_least 3 0 || die "Squid $SQUID_VERSION is too old; beammeup needs Squid 3 or newer."
+}
+
+# squid_version prints the installed Squid's version, e.g. 6.13, or nothing.
+squid_version() {
+  command -v squid >/dev/null 2>&1 || return 0
+  squid -v 2>/dev/null | head -n1 | sed -n 's/.*Version \([0-9][0-9.]*\).*/\1/p' || true
+}
+
+squid_at_least() {
+  (( SQUID_MAJOR > $1 || (SQUID_MAJOR == $1 && SQUID_MINOR >= $2) ))
+}
+
+# squid_parse_error runs squid -k parse on conf and, when Squid rejects it,
+# prints a one-line reason: the version and the lines naming the offending
+# directive. Squid's full output still goes to stderr.
+squid_parse_error() {
+  local conf="$1" out reason
+  if out="$(squid -k parse -f "$conf" 2>&1)"; then
+    return 1
+  fi
+  printf '%s\n' "$out" >&2
+  reason="$(grep -E 'ERROR|FATAL|Bungled|[Uu]nrecognized|[Uu]nknown|obsolete' <<<"$out" | sed -E 's/^[0-9\/]+ [0-9:.]+[^|]*\| //' | head -n 3 | paste -sd ';' - || true)"
+  printf 'Squid %s rejected the generated config: %s' "$SQUID_VERSION" "${reason:-see the output above}"
+}
+
+# install_squid_config moves the rendered config into place once Squid has
+# accepted it, so a rejected config never replaces a working one.
+install_squid_config() {
+  local rendered="$1" conf="$2" parse_error
+  if parse_error="$(squid_parse_error "$rendered")"; then
+    rm -f "$rendered"
+    die "$parse_error"
+  fi
+  chmod 644 "$rendered"
+  mv "$rendered" "$conf"
+}
+
+# domain_list_csv prints a stored domain list comma separated.
+domain_list_csv() {
+  local file
+  file="$(domain_list_file "$1" "$2")"
+  [[ -f "$file" ]] || return 0
+  paste -sd, "$file"
+}
+
+# prepare_http_cache creates the cache directory and Squid's swap layout in
+# it, stopping unit first so squid -z does not find it running. A cache
+# switched off again is removed if beammeup chose its location.
+prepare_http_cache() {
+  local dir="$1"
+  local conf="$2"
+  local unit="$3"
+  if (( CACHE_MB == 0 )); then
+    if [[ -n "$HTTP_CACHE_DIR" && ( "$HTTP_CACHE_DIR" == "$HTTP_MANAGED_CACHE_DIR" || "$HTTP_CACHE_DIR" == "${HTTP_SIDECAR_DIR}/cache" ) ]]; then
+      rm -rf "$HTTP_CACHE_DIR"
+    fi
+    return 0
+  fi
+  mkdir -p "$dir"
+  chown proxy:proxy "$dir" 2>/dev/null || true
+  chmod 750 "$dir"
+  if [[ ! -d "$dir/00" ]]; then
+    systemctl stop "$unit" >/dev/null 2>&1 || true
+    squid -z -N -f "$conf" >/dev/null 2>&1 || true
+    [[ -d "$dir/00" ]] || die "Could not initialize the Squid cache in $dir."
+  fi
+}
+
 write_http_env() {
   local mode="$1"
   local port="$2"
   local user="$3"
   local pass="$4"
+  local cache_dir=""
+  if (( CACHE_MB > 0 )); then
+    cache_dir="$5"
+  fi
   cat >"$HTTP_ENV" <<EOF_ENV
 PROXY_PORT=$port
 PROXY_USER=$user
 PROXY_PASS=$pass
 HTTP_MODE=$mode
+EGRESS_IP=$EGRESS_IP
+CACHE_MB=$CACHE_MB
+CACHE_DIR=$cache_dir
+ANONYMITY=$ANONYMITY
 EOF_ENV
   chmod 600 "$HTTP_ENV"
 }
@@ -821,55 +2752,29 @@ apply_http_managed() {
   local final_pass="$3"
   local existed="$4"
   local note="$5"
-  local auth_helper="$6"
-  local bind_line="http_port $desired_port"
-
-  if [[ "${LISTEN_LOCAL:-0}" -eq 1 ]]; then
-    bind_line="http_port 127.0.0.1:$desired_port"
-  fi
-
-  write_http_env "managed" "$desired_port" "$final_user" "$final_pass"
+  local cache_dir="$APPLY_CACHE_DIR"
+  write_http_env "managed" "$desired_port" "$final_user" "$final_pass" "$cache_dir"
+  local previous
+  previous="$(previous_credential "$HTTP_HTPASSWD")"
   htpasswd -bc "$HTTP_HTPASSWD" "$final_user" "$final_pass" >/dev/null
+  keep_previous_credential "$HTTP_HTPASSWD" "$previous" "$SQUID_CONF"
+  if [[ -f "$HTTP_ADOPTED_HTPASSWD" ]]; then
+    grep -v "^${final_user}:" "$HTTP_ADOPTED_HTPASSWD" >>"$HTTP_HTPASSWD" || true
+  fi
   chown proxy:proxy "$HTTP_HTPASSWD" 2>/dev/null || true
   chmod 640 "$HTTP_HTPASSWD"
 
+  local domain_mode=managed
+  write_domain_lists "$domain_mode"
+
   if [[ -f "$SQUID_CONF" && ! -f "$SQUID_BACKUP" ]]; then
     cp "$SQUID_CONF" "$SQUID_BACKUP"
   fi
 
-  cat >"$SQUID_CONF" <<EOF_SQUID
-# managed by beammeup
-$bind_line
+  install_unit squid.conf "${SQUID_CONF}.new"
+  install_squid_config "${SQUID_CONF}.new" "$SQUID_CONF"
 
-acl SSL_ports port 443
-acl Safe_ports port 80
-acl Safe_ports port 443
-acl Safe_ports port 1025-65535
-acl CONNECT method CONNECT
-
-http_access deny !Safe_ports
-http_access deny CONNECT !SSL_ports
-
-auth_param basic program $auth_helper $HTTP_HTPASSWD
-auth_param basic realm beammeup-proxy
-auth_param basic credentialsttl 8 hours
-acl authenticated proxy_auth REQUIRED
-
-http_access allow authenticated
-http_access deny all
-
-forwarded_for delete
-request_header_access X-Forwarded-For deny all
-request_header_access Via deny all
-
-cache deny all
-access_log stdio:/var/log/squid/access.log
-cache_log /var/log/squid/cache.log
-coredump_dir /var/spool/squid
-pid_filename /run/squid.pid
-EOF_SQUID
-
-  squid -k parse
+  prepare_http_cache "$cache_dir" "$SQUID_CONF" squid.service
   systemctl daemon-reload
   systemctl enable --now squid
   systemctl restart squid
@@ -885,13 +2790,17 @@ EOF_SQUID
     apply_firewall_rule "$desired_port"
   fi
 
+  configure_limits http "$desired_port"
+  configure_resources squid.service
   configure_smart_blinder
+  verify_proxy http "$desired_port" "$final_user" "$final_pass" "$(domain_list_file allow "$domain_mode")"
   load_http_state
   load_socks_state
   reconcile_hangar_status
   write_hangar_metadata "$HANGAR_STATUS" "updated http managed"
+  RESULT_EGRESS_IP="$HTTP_EGRESS_IP"
   emit_result "HTTP" "$desired_port" "$final_user" "$final_pass" \
-    "$( [[ "$existed" == "1" ]] && echo updated || echo created )" "$note"
+    "$( [[ "$existed" == "1" ]] && echo updated || echo created )" "${note}${GRACE_NOTE:+ $GRACE_NOTE}"
 }
 
 apply_http_sidecar() {
@@ -900,82 +2809,27 @@ apply_http_sidecar() {
   local final_pass="$3"
   local existed="$4"
   local note="$5"
-  local auth_helper="$6"
-  local bind_line="http_port $desired_port"
-
-  if [[ "${LISTEN_LOCAL:-0}" -eq 1 ]]; then
-    bind_line="http_port 127.0.0.1:$desired_port"
-  fi
+  local cache_dir="$APPLY_CACHE_DIR"
 
   mkdir -p "$HTTP_SIDECAR_DIR" "$HTTP_SIDECAR_LOG_DIR"
 
-  write_http_env "sidecar" "$desired_port" "$final_user" "$final_pass"
+  write_http_env "sidecar" "$desired_port" "$final_user" "$final_pass" "$cache_dir"
+  local previous
+  previous="$(previous_credential "$HTTP_SIDECAR_HTPASSWD")"
   htpasswd -bc "$HTTP_SIDECAR_HTPASSWD" "$final_user" "$final_pass" >/dev/null
+  keep_previous_credential "$HTTP_SIDECAR_HTPASSWD" "$previous" "$HTTP_SIDECAR_CONF"
   chown proxy:proxy "$HTTP_SIDECAR_HTPASSWD" 2>/dev/null || true
   chmod 640 "$HTTP_SIDECAR_HTPASSWD"
   chown proxy:proxy "$HTTP_SIDECAR_LOG_DIR" 2>/dev/null || true
   chmod 750 "$HTTP_SIDECAR_LOG_DIR" || true
+  local domain_mode=sidecar
+  write_domain_lists "$domain_mode"
 
-  cat >"$HTTP_SIDECAR_CONF" <<EOF_SQUID
-# managed by beammeup (http sidecar)
-$bind_line
-
-acl SSL_ports port 443
-acl Safe_ports port 80
-acl Safe_ports port 443
-acl Safe_ports port 1025-65535
-acl CONNECT method CONNECT
-
-http_access deny !Safe_ports
-http_access deny CONNECT !SSL_ports
-
-auth_param basic program $auth_helper $HTTP_SIDECAR_HTPASSWD
-auth_param basic realm beammeup-sidecar
-auth_param basic credentialsttl 8 hours
-acl authenticated proxy_auth REQUIRED
-
-http_access allow authenticated
-http_access deny all
-
-forwarded_for delete
-request_header_access X-Forwarded-For deny all
-request_header_access Via deny all
-
-cache deny all
-access_log stdio:$HTTP_SIDECAR_LOG_DIR/access.log
-cache_log $HTTP_SIDECAR_LOG_DIR/cache.log
-coredump_dir /var/spool/squid
-pid_filename /run/beammeup-http/sidecar.pid
-EOF_SQUID
+  install_unit squid.conf "${HTTP_SIDECAR_CONF}.new"
+  install_squid_config "${HTTP_SIDECAR_CONF}.new" "$HTTP_SIDECAR_CONF"
+  install_unit http-sidecar.service "$HTTP_SIDECAR_SERVICE_FILE"
 
-  cat >"$HTTP_SIDECAR_SERVICE_FILE" <<EOF_UNIT
-[Unit]
-Description=Beammeup HTTP Sidecar Proxy
-After=network-online.target
-Wants=network-online.target
-
-[Service]
-Type=simple
-User=proxy
-Group=proxy
-RuntimeDirectory=beammeup-http
-RuntimeDirectoryMode=0750
-ExecStart=/usr/sbin/squid -N -f $HTTP_SIDECAR_CONF
-ExecReload=/usr/sbin/squid -k reconfigure -f $HTTP_SIDECAR_CONF
-Restart=always
-RestartSec=2
-NoNewPrivileges=true
-PrivateTmp=true
-ProtectHome=true
-ProtectSystem=strict
-ReadWritePaths=$HTTP_SIDECAR_DIR $HTTP_SIDECAR_LOG_DIR /run
-
-[Install]
-WantedBy=multi-user.target
-EOF_UNIT
-  chmod 644 "$HTTP_SIDECAR_SERVICE_FILE"
-
-  squid -k parse -f "$HTTP_SIDECAR_CONF"
+  prepare_http_cache "$cache_dir" "$HTTP_SIDECAR_CONF" "$HTTP_SIDECAR_SERVICE"
   systemctl daemon-reload
   systemctl enable --now "$HTTP_SIDECAR_SERVICE"
   sleep 1
@@ -990,20 +2844,25 @@ EOF_UNIT
     apply_firewall_rule "$desired_port"
   fi
 
+  configure_limits http "$desired_port"
+  configure_resources "$HTTP_SIDECAR_SERVICE"
   configure_smart_blinder
+  verify_proxy http "$desired_port" "$final_user" "$final_pass" "$(domain_list_file allow "$domain_mode")"
   load_http_state
   load_socks_state
   reconcile_hangar_status
-  write_hangar_metadata "$HANGAR_STATUS" "updated http sidecar"
+  write_hangar_metadata "$HANGAR_STATUS" "updated http sidecar${HTTP_INSTANCE:+ $HTTP_INSTANCE}"
+  RESULT_EGRESS_IP="$HTTP_EGRESS_IP"
   emit_result "HTTP" "$desired_port" "$final_user" "$final_pass" \
-    "$( [[ "$existed" == "1" ]] && echo updated || echo created )" "$note"
+    "$( [[ "$existed" == "1" ]] && echo updated || echo created )" "${note}${GRACE_NOTE:+ $GRACE_NOTE}"
 }
 
-apply_http() {
-  ensure_requirements
-  ensure_packages squid apache2-utils curl iproute2
-
-  mkdir -p "$BEAM_DIR"
+# resolve_http works out what the HTTP config is rendered with into the
+# APPLY_* variables. Like resolve_socks it only reads the server: taking
+# over an unmanaged Squid config (--http-conflict) is left to apply, which
+# APPLY_TAKEOVER tells to do it.
+resolve_http() {
+  detect_squid_version
   load_http_state
   load_socks_state
 
@@ -1021,6 +2880,26 @@ apply_http() {
     *) die "Invalid --http-mode value: $mode (use auto or sidecar)." ;;
   esac
 
+  # Instances are always sidecars; only the default hangar can own the
+  # system Squid.
+  if [[ -n "$HTTP_INSTANCE" ]]; then
+    [[ "$mode" != "managed" ]] || die "HTTP instances always run as sidecars."
+    mode="sidecar"
+  fi
+
+  APPLY_TAKEOVER=0
+  local default_port="${HTTP_PORT:-18181}"
+  if [[ "$unmanaged_conf" -eq 1 && -n "$HTTP_CONFLICT" ]]; then
+    [[ "$mode" != "sidecar" && "$HTTP_MODE" != "sidecar" ]] || die "--http-conflict takes over the system Squid, which a sidecar does not use."
+    APPLY_TAKEOVER=1
+    unmanaged_conf=0
+    mode="managed"
+    # "replace" starts clean; "adopt" keeps the port the old config used.
+    if [[ "$HTTP_CONFLICT" == "replace" ]]; then
+      default_port=18181
+    fi
+  fi
+
   if [[ "$mode" == "auto" ]]; then
     if [[ "$HTTP_MODE" == "sidecar" ]]; then
       mode="sidecar"
@@ -1035,8 +2914,8 @@ apply_http() {
   if [[ "$mode" == "managed" && "$unmanaged_conf" -eq 1 ]]; then
     die "Existing non-beammeup Squid config detected at $SQUID_CONF. Refusing to overwrite."
   fi
+  APPLY_HTTP_MODE="$mode"
 
-  local existed="$HTTP_EXISTS"
   local current_port="$HTTP_PORT"
   if [[ "$mode" == "managed" && "$HTTP_MODE" != "sidecar" ]]; then
     current_port="$HTTP_PORT"
@@ -1046,15 +2925,35 @@ apply_http() {
     current_port=""
   fi
 
-  local desired_port="${PROXY_PORT:-${HTTP_PORT:-18181}}"
+  APPLY_PORT="${PROXY_PORT:-$default_port}"
+  is_valid_port "$APPLY_PORT" || die "Invalid proxy port: $APPLY_PORT"
+  ensure_port_available "$APPLY_PORT" "$current_port"
+
+  APPLY_AUTH_HELPER="$(find_squid_auth_helper || true)"
+  [[ -n "$APPLY_AUTH_HELPER" ]] || die "Could not locate Squid basic_ncsa_auth helper."
+  APPLY_CACHE_DIR="$(http_cache_dir "$mode")"
+}
+
+apply_http() {
+  ensure_requirements
+  begin_phase install
+  install_proxy_packages
+  begin_phase configure
+
+  mkdir -p "$BEAM_DIR"
+  resolve_http
+  check_prepared
+  if [[ "$APPLY_TAKEOVER" -eq 1 ]]; then
+    take_over_squid_config "$HTTP_CONFLICT"
+  fi
+
+  local existed="$HTTP_EXISTS"
+  local desired_port="$APPLY_PORT"
   local final_user="$HTTP_USER"
   local final_pass="$HTTP_PASS"
   local note=""
 
-  is_valid_port "$desired_port" || die "Invalid proxy port: $desired_port"
-  ensure_port_available "$desired_port" "$current_port"
-
-  if [[ -z "$final_user" || "$ROTATE_CREDENTIALS" -eq 1 ]]; then
+  if [[ -z "$final_user" || ( "$ROTATE_CREDENTIALS" -eq 1 && "$ROTATE_SCOPE" == "both" ) ]]; then
     final_user="beamhttp$(generate_secret 'a-z0-9' 4)"
   fi
   if [[ -z "$final_pass" || "$ROTATE_CREDENTIALS" -eq 1 ]]; then
@@ -1062,19 +2961,98 @@ apply_http() {
     if [[ "$HTTP_LEGACY" == "1" && "$ROTATE_CREDENTIALS" -eq 0 ]]; then
       note="Legacy HTTP setup detected. Password regenerated because existing password cannot be recovered."
     elif [[ "$ROTATE_CREDENTIALS" -eq 1 ]]; then
-      note="Credentials rotated."
+      note="$(rotation_note)"
     fi
   fi
+  if [[ -n "$TAKEOVER_NOTE" ]]; then
+    note="${note:+$note }$TAKEOVER_NOTE"
+  fi
+
+  if [[ "$APPLY_HTTP_MODE" == "sidecar" ]]; then
+    apply_http_sidecar "$desired_port" "$final_user" "$final_pass" "$existed" "$note"
+  else
+    apply_http_managed "$desired_port" "$final_user" "$final_pass" "$existed" "$note"
+  fi
+}
 
-  local auth_helper
-  auth_helper="$(find_squid_auth_helper || true)"
-  [[ -n "$auth_helper" ]] || die "Could not locate Squid basic_ncsa_auth helper."
+# proxy_facts prints what squid.conf and the proxy units are rendered with,
+# once resolve_socks or resolve_http has run. The prepare run hands these to
+# the client (see TemplateData in templates.go); apply compares their
+# fingerprint with --prepared.
+proxy_facts() {
+  printf 'BM_PROXY_PORT=%s\n' "$APPLY_PORT"
+  printf 'BM_PROXY_EGRESS_IP=%s\n' "$EGRESS_IP"
+  if [[ "$PROTOCOL" == "socks5" ]]; then
+    printf 'BM_PROXY_BIND_IP=%s\n' "$APPLY_BIND_IP"
+    printf 'BM_PROXY_ENV_FILE=%s\n' "$SOCKS_ENV"
+    printf 'BM_PROXY_MICROSOCKS_BIN=%s\n' "$APPLY_MICROSOCKS_BIN"
+    if [[ "$SOCKET_ACTIVATION" -eq 1 ]]; then
+      printf 'BM_PROXY_PROXYD_BIN=%s\n' "$APPLY_PROXYD_BIN"
+      printf 'BM_PROXY_BACKEND_PORT=%s\n' "$APPLY_BACKEND_PORT"
+      printf 'BM_PROXY_IDLE_SECONDS=%s\n' "$SOCKS_IDLE_SECONDS"
+      printf 'BM_PROXY_WAIT_SCRIPT=%s\n' "$SOCKS_WAIT_SCRIPT"
+      printf 'BM_PROXY_SOCKS_SERVICE=%s\n' "$SOCKS_SERVICE"
+      printf 'BM_PROXY_PROXYD_SERVICE=%s\n' "$SOCKS_PROXYD_SERVICE"
+    fi
+    return 0
+  fi
 
+  local mode="$APPLY_HTTP_MODE" kind list
+  printf 'BM_PROXY_HTTP_MODE=%s\n' "$mode"
+  printf 'BM_PROXY_LISTEN_LOCAL=%s\n' "${LISTEN_LOCAL:-0}"
+  printf 'BM_PROXY_SQUID_VERSION=%s\n' "$SQUID_VERSION"
+  printf 'BM_PROXY_AUTH_HELPER=%s\n' "$APPLY_AUTH_HELPER"
+  printf 'BM_PROXY_ANONYMITY=%s\n' "$ANONYMITY"
+  printf 'BM_PROXY_CACHE_MB=%s\n' "$CACHE_MB"
+  printf 'BM_PROXY_CACHE_DIR=%s\n' "$APPLY_CACHE_DIR"
+  # write_domain_lists keeps a list file only for a list that was given.
+  for kind in allow deny; do
+    list="$ALLOW_DOMAINS"
+    [[ "$kind" == "allow" ]] || list="$DENY_DOMAINS"
+    if [[ -n "$list" ]]; then
+      printf 'BM_PROXY_%s_FILE=%s\n' "${kind^^}" "$(domain_list_file "$kind" "$mode")"
+    fi
+  done
   if [[ "$mode" == "sidecar" ]]; then
-    apply_http_sidecar "$desired_port" "$final_user" "$final_pass" "$existed" "$note" "$auth_helper"
+    printf 'BM_PROXY_INSTANCE=%s\n' "$HTTP_INSTANCE"
+    printf 'BM_PROXY_PASSWORD_FILE=%s\n' "$HTTP_SIDECAR_HTPASSWD"
+    printf 'BM_PROXY_LOG_DIR=%s\n' "$HTTP_SIDECAR_LOG_DIR"
+    printf 'BM_PROXY_PID_FILE=%s\n' "/run/${HTTP_SIDECAR_RUN_DIR}/sidecar.pid"
+    printf 'BM_PROXY_CONF_FILE=%s\n' "$HTTP_SIDECAR_CONF"
+    printf 'BM_PROXY_SIDECAR_DIR=%s\n' "$HTTP_SIDECAR_DIR"
+    printf 'BM_PROXY_RUN_DIR=%s\n' "$HTTP_SIDECAR_RUN_DIR"
+  else
+    printf 'BM_PROXY_PASSWORD_FILE=%s\n' "$HTTP_HTPASSWD"
+    printf 'BM_PROXY_LOG_DIR=%s\n' /var/log/squid
+    printf 'BM_PROXY_PID_FILE=%s\n' /run/squid.pid
+  fi
+}
+
+proxy_fingerprint() {
+  proxy_facts | sha256sum | cut -c1-64
+}
+
+# run_prepare is the first half of an apply: it installs the proxy's
+# packages and reports what the client renders squid.conf and the proxy
+# units with. It changes nothing else.
+run_prepare() {
+  begin_phase install
+  install_proxy_packages
+  begin_phase configure
+  if [[ "$PROTOCOL" == "socks5" ]]; then
+    resolve_socks
   else
-    apply_http_managed "$desired_port" "$final_user" "$final_pass" "$existed" "$note" "$auth_helper"
+    resolve_http
   fi
+  proxy_facts
+  printf 'BM_PROXY_FINGERPRINT=%s\n' "$(proxy_fingerprint)"
+}
+
+# check_prepared stops an apply when the server no longer matches the
+# prepare run its squid.conf and proxy units were rendered from.
+check_prepared() {
+  [[ -n "$PREPARED" ]] || die "apply needs --prepared from a prepare run; the client renders the proxy configs in between."
+  [[ "$(proxy_fingerprint)" == "$PREPARED" ]] || die "The server changed since the prepare run (port, Squid or microsocks); apply again."
 }
 
 show_setup() {
@@ -1085,12 +3063,14 @@ show_setup() {
   if [[ "$PROTOCOL" == "socks5" ]]; then
     [[ "$SOCKS_EXISTS" == "1" ]] || die "SOCKS5 setup not found."
     FIREWALL_NOTE=""
+    RESULT_EGRESS_IP="$SOCKS_EGRESS_IP"
     emit_result "SOCKS5" "${SOCKS_PORT:-}" "${SOCKS_USER:-}" "${SOCKS_PASS:-}" "show" ""
     return
   fi
 
   [[ "$HTTP_EXISTS" == "1" ]] || die "HTTP setup not found."
   FIREWALL_NOTE=""
+  RESULT_EGRESS_IP="$HTTP_EGRESS_IP"
   local note=""
   if [[ -z "$HTTP_PASS" ]]; then
     note="Password is not retrievable from legacy setup. Use rotate action to issue a new password."
@@ -1110,10 +3090,13 @@ destroy_hangar() {
   note_parts+=("firewall rules not modified (safe destroy)")
 
   if [[ "$SOCKS_EXISTS" == "1" ]]; then
+    remove_socks_socket
     if service_defined "$SOCKS_SERVICE"; then
       systemctl disable --now "$SOCKS_SERVICE" >/dev/null 2>&1 || true
     fi
     rm -f "$SOCKS_ENV" "$SOCKS_SERVICE_FILE"
+    remove_hardening "$SOCKS_SERVICE_FILE"
+    remove_resources "$SOCKS_SERVICE_FILE"
     removed_any=1
     note_parts+=("SOCKS5 removed")
   fi
@@ -1124,13 +3107,19 @@ destroy_hangar() {
         systemctl disable --now "$HTTP_SIDECAR_SERVICE" >/dev/null 2>&1 || true
       fi
       rm -f "$HTTP_SIDECAR_SERVICE_FILE"
+      remove_hardening "$HTTP_SIDECAR_SERVICE_FILE"
+      remove_resources "$HTTP_SIDECAR_SERVICE_FILE"
       rm -rf "$HTTP_SIDECAR_DIR"
       note_parts+=("HTTP sidecar removed")
     else
       if service_defined "squid.service"; then
         systemctl disable --now squid >/dev/null 2>&1 || true
       fi
-      rm -f "$HTTP_HTPASSWD"
+      remove_resources /etc/systemd/system/squid.service
+      systemctl daemon-reload
+      rm -f "$HTTP_HTPASSWD" "$HTTP_ADOPTED_HTPASSWD"
+      rm -rf "$HTTP_MANAGED_CACHE_DIR"
+      rm -f "$(domain_list_file allow managed)" "$(domain_list_file deny managed)"
 
       if [[ -f "$SQUID_BACKUP" ]]; then
         cp "$SQUID_BACKUP" "$SQUID_CONF"
@@ -1150,9 +3139,32 @@ destroy_hangar() {
     note_parts+=("HTTP removed")
   fi
 
-  disable_smart_blinder
+  local name
+  for name in $(http_instance_names); do
+    (
+      use_http_instance "$name"
+      if service_defined "$HTTP_SIDECAR_SERVICE"; then
+        systemctl disable --now "$HTTP_SIDECAR_SERVICE" >/dev/null 2>&1 || true
+      fi
+      rm -f "$HTTP_SIDECAR_SERVICE_FILE" "$HTTP_ENV"
+      remove_hardening "$HTTP_SIDECAR_SERVICE_FILE"
+      remove_resources "$HTTP_SIDECAR_SERVICE_FILE"
+      rm -rf "$HTTP_SIDECAR_DIR"
+      disable_ttl
+    )
+    removed_any=1
+    note_parts+=("HTTP instance $name removed")
+  done
 
-  rm -f "$HANGAR_META"
+  disable_smart_blinder
+  disable_traffic_cap
+  disable_quiet_hours
+  disable_limits
+  disable_rotation_grace
+  disable_ttl
+  cleanup_traffic_accounting
+
+  rm -f "$HANGAR_META" "$HANGAR_SIG"
   systemctl daemon-reload
 
   if [[ "$removed_any" -eq 1 ]]; then
@@ -1162,15 +3174,307 @@ destroy_hangar() {
   fi
 }
 
+# destroy_http_instance removes one named HTTP instance and leaves the rest
+# of the hangar alone.
+destroy_http_instance() {
+  ensure_requirements
+  load_http_state
+
+  if [[ "$HTTP_EXISTS" != "1" ]]; then
+    emit_result "DESTROY" "" "" "" "destroy-noop" "No HTTP instance $HTTP_INSTANCE detected."
+    return
+  fi
+
+  FIREWALL_NOTE=""
+  if service_defined "$HTTP_SIDECAR_SERVICE"; then
+    systemctl disable --now "$HTTP_SIDECAR_SERVICE" >/dev/null 2>&1 || true
+  fi
+  rm -f "$HTTP_SIDECAR_SERVICE_FILE" "$HTTP_ENV"
+  remove_hardening "$HTTP_SIDECAR_SERVICE_FILE"
+  rm -rf "$HTTP_SIDECAR_DIR"
+  disable_ttl
+  if [[ -f "$LIMITS_CONF" ]]; then
+    set_limits "$(limits_key http)" "" 0 0
+  fi
+
+  load_http_state
+  load_socks_state
+  reconcile_hangar_status
+  if [[ "$METADATA_EXISTS" == "1" ]]; then
+    write_hangar_metadata "$HANGAR_STATUS" "removed http instance $HTTP_INSTANCE"
+  fi
+  emit_result "DESTROY" "" "" "" "destroyed" "HTTP instance $HTTP_INSTANCE removed; firewall rules not modified (safe destroy)"
+}
+
+# lock_is_stale treats a lock as abandoned once its holder is gone or it is
+# older than any run could take.
+lock_is_stale() {
+  local pid started now
+  pid="$(read_env_value "$LOCK_FILE" PID || true)"
+  started="$(read_env_value "$LOCK_FILE" STARTED_AT || true)"
+  now="$(date +%s)"
+  if [[ ! "$pid" =~ ^[0-9]+$ ]] || ! kill -0 "$pid" 2>/dev/null; then
+    return 0
+  fi
+  [[ ! "$started" =~ ^[0-9]+$ ]] || (( now - started > LOCK_STALE_SECONDS ))
+}
+
+release_lock() {
+  if [[ "$(read_env_value "$LOCK_FILE" PID || true)" == "$$" ]]; then
+    rm -f "$LOCK_FILE"
+  fi
+}
+
+on_exit() {
+  local status=$?
+  stop_phase_timer
+  if [[ -n "$ROLLBACK_DIR" ]]; then
+    if (( status != 0 )); then
+      rollback_apply
+    fi
+    # The snapshot of a failed apply is what the server has again.
+    rm -rf "$ROLLBACK_DIR"
+  fi
+  release_lock
+}
+
+# begin_phase names the step apply is in and gives it PHASE_TIMEOUT_SECONDS.
+# When time runs out the watchdog signals the script and whatever it is
+# waiting on (apt, systemctl), and the TERM trap fails the run.
+begin_phase() {
+  stop_phase_timer
+  CURRENT_PHASE="$1"
+  (( PHASE_TIMEOUT_SECONDS > 0 )) || return 0
+  (
+    sleep "$PHASE_TIMEOUT_SECONDS"
+    kill -TERM $$ 2>/dev/null
+    pkill -TERM -P $$ 2>/dev/null
+  ) </dev/null >/dev/null 2>&1 &
+  PHASE_TIMER_PID=$!
+}
+
+stop_phase_timer() {
+  [[ -n "$PHASE_TIMER_PID" ]] || return 0
+  pkill -P "$PHASE_TIMER_PID" 2>/dev/null || true
+  kill "$PHASE_TIMER_PID" 2>/dev/null || true
+  wait "$PHASE_TIMER_PID" 2>/dev/null || true
+  PHASE_TIMER_PID=""
+}
+
+# snapshot_paths lists, relative to /, what apply may change: the state
+# directory, the beammeup units and drop-ins, and the system Squid's config.
+snapshot_paths() {
+  local path
+  for path in "$BEAM_DIR" /etc/systemd/system/beammeup-* /etc/systemd/system/squid.service.d "$SQUID_CONF" "$SQUID_BACKUP"; do
+    [[ -e "$path" ]] && printf '%s\n' "${path#/}"
+  done
+  return 0
+}
+
+# hangar_units prints the beammeup units and squid.service that are in the
+# given state: "active" (running) or "enabled" (started at boot).
+hangar_units() {
+  if [[ "$1" == "active" ]]; then
+    systemctl list-units --plain --no-legend --state=active 'beammeup-*' squid.service 2>/dev/null | awk '{print $1}'
+  else
+    systemctl list-unit-files --no-legend --state=enabled 'beammeup-*' squid.service 2>/dev/null | awk '{print $1}'
+  fi
+  return 0
+}
+
+# take_snapshot saves the files apply may change, and which units were
+# running and enabled, into dir.
+take_snapshot() {
+  local dir="$1"
+  snapshot_paths >"$dir/paths"
+  tar -C / -cpf "$dir/files.tar" --exclude="${LOCK_FILE#/}" --exclude="${BACKUP_DIR#/}" -T "$dir/paths"
+  hangar_units active >"$dir/active-units"
+  hangar_units enabled >"$dir/enabled-units"
+}
+
+# restore_snapshot puts the server back the way take_snapshot found it:
+# files apply created since are removed, the saved ones restored, and the
+# units started and enabled as they were. Installed packages and firewall
+# rules stay.
+restore_snapshot() {
+  local dir="$1" unit path
+  while read -r unit; do
+    systemctl stop "$unit" >/dev/null 2>&1 || true
+    grep -qxF "$unit" "$dir/enabled-units" || systemctl disable "$unit" >/dev/null 2>&1 || true
+  done < <(hangar_units active; hangar_units enabled)
+
+  if ! grep -qxF "${SQUID_CONF#/}" "$dir/paths" && [[ -f "$SQUID_BACKUP" ]]; then
+    # Squid came with this run; go back to the distro's config.
+    cp "$SQUID_BACKUP" "$SQUID_CONF"
+  fi
+  for path in "$BEAM_DIR"/* /etc/systemd/system/beammeup-* /etc/systemd/system/squid.service.d "$SQUID_BACKUP"; do
+    [[ -e "$path" && "$path" != "$LOCK_FILE" && "$path" != "$BACKUP_DIR" ]] || continue
+    rm -rf "$path"
+  done
+  tar -C / -xpf "$dir/files.tar"
+  systemctl daemon-reload
+
+  while read -r unit; do
+    systemctl enable "$unit" >/dev/null 2>&1 || true
+  done <"$dir/enabled-units"
+  while read -r unit; do
+    systemctl start "$unit" >/dev/null 2>&1 || log "Could not start $unit again."
+  done <"$dir/active-units"
+}
+
+# new_backup snapshots the server into a new BACKUP_DIR/<UTC timestamp>
+# and prints the timestamp.
+new_backup() {
+  local stamp
+  mkdir -p -m 700 "$BACKUP_DIR"
+  stamp="$(date -u +%Y%m%dT%H%M%SZ)"
+  while [[ -e "$BACKUP_DIR/$stamp" ]]; do
+    sleep 1
+    stamp="$(date -u +%Y%m%dT%H%M%SZ)"
+  done
+  mkdir -m 700 "$BACKUP_DIR/$stamp"
+  take_snapshot "$BACKUP_DIR/$stamp"
+  printf '%s\n' "$stamp"
+}
+
+# list_backups prints the snapshot timestamps, newest first.
+list_backups() {
+  [[ -d "$BACKUP_DIR" ]] || return 0
+  find "$BACKUP_DIR" -mindepth 1 -maxdepth 1 -type d -printf '%f\n' | grep -E "^${BACKUP_STAMP_RE}$" | sort -r || true
+}
+
+# prune_backups keeps the newest BACKUP_KEEP snapshots.
+prune_backups() {
+  local stamp
+  list_backups | tail -n +$(( BACKUP_KEEP + 1 )) | while read -r stamp; do
+    rm -rf "${BACKUP_DIR:?}/$stamp"
+  done
+}
+
+# begin_rollback snapshots the server before apply changes anything; a
+# failed apply puts the snapshot back (see on_exit).
+begin_rollback() {
+  APPLY_BACKUP="$(new_backup)"
+  ROLLBACK_DIR="$BACKUP_DIR/$APPLY_BACKUP"
+}
+
+# commit_rollback keeps the applied changes. The snapshot stays as the undo
+# for this apply (rollback mode with --to).
+commit_rollback() {
+  ROLLBACK_DIR=""
+  prune_backups
+  printf 'BM_RESULT_BACKUP=%s\n' "$APPLY_BACKUP"
+}
+
+# run_rollback restores the snapshot ROLLBACK_TO, after taking one of the
+# current state so the rollback can be undone the same way. Without
+# ROLLBACK_TO it lists the snapshots.
+run_rollback() {
+  if [[ -z "$ROLLBACK_TO" ]]; then
+    printf 'BM_ROLLBACK=LIST\n'
+    printf 'BM_BACKUPS=%s\n' "$(list_backups | paste -sd, -)"
+    return 0
+  fi
+  [[ -f "$BACKUP_DIR/$ROLLBACK_TO/files.tar" ]] || die "No snapshot $ROLLBACK_TO in $BACKUP_DIR."
+  local current
+  current="$(new_backup)"
+  log "Restoring the configuration from $ROLLBACK_TO (the current one is saved as $current)."
+  restore_snapshot "$BACKUP_DIR/$ROLLBACK_TO"
+  prune_backups
+  printf 'BM_ROLLBACK=OK\n'
+  printf 'BM_ROLLBACK_TO=%s\n' "$ROLLBACK_TO"
+  printf 'BM_BACKUP=%s\n' "$current"
+}
+
+rollback_apply() {
+  set +e
+  log "Apply failed${CURRENT_PHASE:+ during $CURRENT_PHASE}; rolling back its changes."
+  restore_snapshot "$ROLLBACK_DIR"
+  log "Rolled back; the server has its previous configuration."
+}
+
+# acquire_lock serializes runs that change the server, so two clients (or a
+# client and the expiry timer) cannot interleave their edits.
+acquire_lock() {
+  mkdir -p "$BEAM_DIR"
+  if [[ "$BREAK_LOCK" == "1" && -f "$LOCK_FILE" ]]; then
+    log "Breaking lock held by pid $(read_env_value "$LOCK_FILE" PID || true)."
+    rm -f "$LOCK_FILE"
+  fi
+
+  local attempt
+  for attempt in 1 2; do
+    if (set -o noclobber; printf 'PID=%s\nSTARTED_AT=%s\nMODE=%s\n' "$$" "$(date +%s)" "$MODE" >"$LOCK_FILE") 2>/dev/null; then
+      trap on_exit EXIT
+      return 0
+    fi
+    [[ "$attempt" == "1" ]] && lock_is_stale || break
+    log "Removing stale lock left by pid $(read_env_value "$LOCK_FILE" PID || true)."
+    rm -f "$LOCK_FILE"
+  done
+
+  local holder started
+  holder="$(read_env_value "$LOCK_FILE" MODE || true) run (pid $(read_env_value "$LOCK_FILE" PID || true))"
+  started="$(read_env_value "$LOCK_FILE" STARTED_AT || true)"
+  if [[ "$started" =~ ^[0-9]+$ ]]; then
+    holder+=" started $(date -u -d "@${started}" +%Y-%m-%dT%H:%M:%SZ 2>/dev/null || echo "at ${started}")"
+  fi
+  die "Another beammeup ${holder} holds ${LOCK_FILE}. Retry later, or pass --break-lock if it is stuck."
+}
+
 MODE="inventory"
 PROTOCOL=""
 HTTP_MODE_REQUEST=""
+HTTP_CONFLICT=""
+TAKEOVER_NOTE=""
+PREPARED=""
 PROXY_PORT=""
 NO_FIREWALL_CHANGE=0
 ROTATE_CREDENTIALS=0
+ROTATE_SCOPE="both"
+ROTATE_GRACE_SECONDS=0
+GRACE_NOTE=""
 LISTEN_LOCAL=0
 SMART_BLINDER=1
 SMART_BLINDER_IDLE_MINUTES=10
+SOCKET_ACTIVATION=0
+MICROSOCKS_UPLOAD_SHA256=""
+PHASE_TIMEOUT_SECONDS=600
+CURRENT_PHASE=""
+PHASE_TIMER_PID=""
+ROLLBACK_DIR=""
+ROLLBACK_TO=""
+APPLY_BACKUP=""
+VERIFY=1
+VERIFY_NOTE=""
+TRAFFIC_CAP_GB=""
+TTL_SECONDS=""
+REMOTE_TMPDIR="/tmp"
+READ_ONLY=0
+METADATA_SIGNATURE=""
+METADATA_SHA256=""
+METADATA_WRITTEN=0
+BREAK_LOCK=0
+HARDEN=0
+SECURITY_ONLY=0
+EGRESS_IP=""
+RESULT_EGRESS_IP=""
+INSTANCE=""
+CACHE_MB=0
+CACHE_DIR=""
+ANONYMITY="elite"
+ALLOW_DOMAINS=""
+DENY_DOMAINS=""
+QUIET_HOURS=""
+HANGAR_LABEL=""
+HANGAR_DESCRIPTION=""
+CLIENT_ID=""
+CLIENT_VERSION=""
+MAX_CONNS=""
+MEMORY_MAX=""
+CPU_QUOTA=""
+TASKS_MAX=""
+RATE_LIMIT=""
 
 while [[ $# -gt 0 ]]; do
   case "$1" in
@@ -1186,6 +3490,14 @@ while [[ $# -gt 0 ]]; do
       HTTP_MODE_REQUEST="$2"
       shift 2
       ;;
+    --http-conflict)
+      HTTP_CONFLICT="$2"
+      shift 2
+      ;;
+    --prepared)
+      PREPARED="$2"
+      shift 2
+      ;;
     --proxy-port)
       PROXY_PORT="$2"
       shift 2
@@ -1202,6 +3514,14 @@ while [[ $# -gt 0 ]]; do
       ROTATE_CREDENTIALS=1
       shift
       ;;
+    --rotate-scope)
+      ROTATE_SCOPE="$2"
+      shift 2
+      ;;
+    --rotate-grace-seconds)
+      ROTATE_GRACE_SECONDS="$2"
+      shift 2
+      ;;
     --smart-blinder)
       SMART_BLINDER=1
       shift
@@ -1210,16 +3530,242 @@ while [[ $# -gt 0 ]]; do
       SMART_BLINDER=0
       shift
       ;;
+    --socket-activation)
+      SOCKET_ACTIVATION=1
+      shift
+      ;;
+    --skip-verify)
+      VERIFY=0
+      shift
+      ;;
+    --to)
+      ROLLBACK_TO="$2"
+      shift 2
+      ;;
+    --phase-timeout-seconds)
+      PHASE_TIMEOUT_SECONDS="$2"
+      shift 2
+      ;;
+    --microsocks-sha256)
+      MICROSOCKS_UPLOAD_SHA256="$2"
+      shift 2
+      ;;
     --smart-blinder-idle-minutes)
       SMART_BLINDER_IDLE_MINUTES="$2"
       shift 2
       ;;
+    --traffic-cap-gb)
+      TRAFFIC_CAP_GB="$2"
+      shift 2
+      ;;
+    --ttl-seconds)
+      TTL_SECONDS="$2"
+      shift 2
+      ;;
+    --tmpdir)
+      REMOTE_TMPDIR="$2"
+      shift 2
+      ;;
+    --read-only)
+      READ_ONLY=1
+      shift
+      ;;
+    --metadata-signature)
+      METADATA_SIGNATURE="$2"
+      shift 2
+      ;;
+    --metadata-sha256)
+      METADATA_SHA256="$2"
+      shift 2
+      ;;
+    --break-lock)
+      BREAK_LOCK=1
+      shift
+      ;;
+    --harden)
+      HARDEN=1
+      shift
+      ;;
+    --security-only)
+      SECURITY_ONLY=1
+      shift
+      ;;
+    --egress-ip)
+      EGRESS_IP="$2"
+      shift 2
+      ;;
+    --instance)
+      INSTANCE="$2"
+      shift 2
+      ;;
+    --cache-mb)
+      CACHE_MB="$2"
+      shift 2
+      ;;
+    --cache-dir)
+      CACHE_DIR="$2"
+      shift 2
+      ;;
+    --anonymity)
+      ANONYMITY="$2"
+      shift 2
+      ;;
+    --allow-domains)
+      ALLOW_DOMAINS="$2"
+      shift 2
+      ;;
+    --deny-domains)
+      DENY_DOMAINS="$2"
+      shift 2
+      ;;
+    --quiet-hours)
+      QUIET_HOURS="$2"
+      shift 2
+      ;;
+    --label)
+      HANGAR_LABEL="$2"
+      shift 2
+      ;;
+    --description)
+      HANGAR_DESCRIPTION="$2"
+      shift 2
+      ;;
+    --client)
+      CLIENT_ID="$2"
+      shift 2
+      ;;
+    --client-version)
+      CLIENT_VERSION="$2"
+      shift 2
+      ;;
+    --max-conns)
+      MAX_CONNS="$2"
+      shift 2
+      ;;
+    --memory-max)
+      MEMORY_MAX="$2"
+      shift 2
+      ;;
+    --cpu-quota)
+      CPU_QUOTA="$2"
+      shift 2
+      ;;
+    --tasks-max)
+      TASKS_MAX="$2"
+      shift 2
+      ;;
+    --rate-limit)
+      RATE_LIMIT="$2"
+      shift 2
+      ;;
     *)
       die "Unknown argument: $1"
       ;;
   esac
 done
 
+[[ "$REMOTE_TMPDIR" == /* ]] || die "--tmpdir must be an absolute path: $REMOTE_TMPDIR"
+REMOTE_TMPDIR="${REMOTE_TMPDIR%/}"
+REMOTE_TMPDIR="${REMOTE_TMPDIR:-/}"
+if [[ "$MODE" == "apply" || "$MODE" == "prepare" ]]; then
+  mkdir -p "$REMOTE_TMPDIR" 2>/dev/null || die "Cannot create --tmpdir ${REMOTE_TMPDIR}."
+fi
+# Tools started from here (apt, mktemp) follow the same directory.
+export TMPDIR="$REMOTE_TMPDIR"
+
+case "$MODE" in
+  apply|prepare|destroy|sign|os-update|reboot)
+    acquire_lock
+    ;;
+  rollback)
+    [[ -z "$ROLLBACK_TO" ]] || acquire_lock
+    ;;
+  security)
+    [[ "$HARDEN" == "0" ]] || acquire_lock
+    ;;
+esac
+
+if [[ "$READ_ONLY" == "1" && "$MODE" != "inventory" && "$MODE" != "usage" ]]; then
+  die "--read-only only applies to inventory and usage modes."
+fi
+if [[ "$HARDEN" == "1" && "$MODE" != "security" ]]; then
+  die "--harden only applies to security mode."
+fi
+if [[ "$SECURITY_ONLY" == "1" && "$MODE" != "os-update" ]]; then
+  die "--security-only only applies to os-update mode."
+fi
+
+if [[ -n "$INSTANCE" ]]; then
+  [[ "$INSTANCE" =~ ^[a-z0-9][a-z0-9-]{0,31}$ ]] || die "Invalid --instance name: $INSTANCE (use a-z, 0-9 and -)."
+  [[ "$PROTOCOL" != "socks5" ]] || die "--instance only applies to HTTP."
+  [[ -z "$TRAFFIC_CAP_GB" && -z "$QUIET_HOURS" ]] || die "--traffic-cap-gb and --quiet-hours cover the whole server; set them on the default hangar, not an --instance."
+  use_http_instance "$INSTANCE"
+fi
+
+case "$HTTP_CONFLICT" in
+  ""|adopt|replace) ;;
+  *) die "Invalid --http-conflict value: $HTTP_CONFLICT (use adopt or replace)." ;;
+esac
+if [[ -n "$HTTP_CONFLICT" ]]; then
+  [[ "$MODE" == "apply" || "$MODE" == "prepare" || "$MODE" == "preflight" ]] || die "--http-conflict only applies to apply, prepare and preflight modes."
+  [[ "$PROTOCOL" == "http" && -z "$INSTANCE" ]] || die "--http-conflict only applies to the default HTTP hangar."
+fi
+if [[ -n "$PREPARED" ]]; then
+  [[ "$PREPARED" =~ ^[0-9a-f]{64}$ ]] || die "Invalid --prepared: $PREPARED"
+  [[ "$MODE" == "apply" ]] || die "--prepared only applies to apply mode."
+fi
+case "$ROTATE_SCOPE" in
+  password|both) ;;
+  *) die "Invalid --rotate-scope value: $ROTATE_SCOPE (use password or both)." ;;
+esac
+[[ "$ROTATE_GRACE_SECONDS" =~ ^[0-9]+$ ]] || die "Invalid --rotate-grace-seconds value: $ROTATE_GRACE_SECONDS"
+if (( ROTATE_GRACE_SECONDS > 0 )); then
+  [[ "$PROTOCOL" != "socks5" ]] || die "--rotate-grace-seconds only applies to HTTP; microsocks accepts a single user."
+  [[ "$ROTATE_SCOPE" == "both" ]] || die "--rotate-grace-seconds needs a new username (--rotate-scope both)."
+fi
+case "$ANONYMITY" in
+  transparent|anonymous|elite) ;;
+  *) die "Invalid --anonymity value: $ANONYMITY (use transparent, anonymous or elite)." ;;
+esac
+[[ "$ALLOW_DOMAINS" =~ ^[a-z0-9.,-]*$ ]] || die "Invalid --allow-domains list."
+[[ "$DENY_DOMAINS" =~ ^[a-z0-9.,-]*$ ]] || die "Invalid --deny-domains list."
+if [[ -n "$QUIET_HOURS" && "$QUIET_HOURS" != "off" ]]; then
+  [[ "$QUIET_HOURS" =~ ^([01][0-9]|2[0-3]):[0-5][0-9]-([01][0-9]|2[0-3]):[0-5][0-9](,([01][0-9]|2[0-3]):[0-5][0-9]-([01][0-9]|2[0-3]):[0-5][0-9])*$ ]] || die "Invalid --quiet-hours value: $QUIET_HOURS (use HH:MM-HH:MM in UTC, or off)."
+fi
+[[ -z "$MAX_CONNS" || "$MAX_CONNS" =~ ^[0-9]+$ ]] || die "Invalid --max-conns value: $MAX_CONNS"
+[[ -z "$RATE_LIMIT" || "$RATE_LIMIT" =~ ^[0-9]+$ ]] || die "Invalid --rate-limit value: $RATE_LIMIT"
+(( ${RATE_LIMIT:-0} <= 10000 )) || die "--rate-limit is at most 10000 new connections per minute."
+[[ -z "$MEMORY_MAX" || "$MEMORY_MAX" =~ ^(0|[1-9][0-9]*[KMGT]?)$ ]] || die "Invalid --memory-max value: $MEMORY_MAX (use e.g. 256M, or 0)."
+[[ -z "$CPU_QUOTA" || "$CPU_QUOTA" =~ ^(0|[1-9][0-9]*%)$ ]] || die "Invalid --cpu-quota value: $CPU_QUOTA (use e.g. 50%, or 0)."
+[[ -z "$TASKS_MAX" || "$TASKS_MAX" =~ ^[0-9]+$ ]] || die "Invalid --tasks-max value: $TASKS_MAX"
+[[ "$CACHE_MB" =~ ^[0-9]+$ ]] || die "Invalid --cache-mb value: $CACHE_MB"
+[[ "$PHASE_TIMEOUT_SECONDS" =~ ^[0-9]+$ ]] || die "Invalid --phase-timeout-seconds value: $PHASE_TIMEOUT_SECONDS"
+if [[ -n "$ROLLBACK_TO" ]]; then
+  [[ "$ROLLBACK_TO" =~ ^${BACKUP_STAMP_RE}$ ]] || die "Invalid --to value: $ROLLBACK_TO (use a snapshot name like 20260101T120000Z)."
+  [[ "$MODE" == "rollback" ]] || die "--to only applies to rollback mode."
+fi
+for info in "$HANGAR_LABEL" "$HANGAR_DESCRIPTION"; do
+  [[ "$info" != *[[:cntrl:]]* ]] || die "--label and --description must be a single line of text."
+done
+if [[ -n "$CLIENT_ID" ]]; then
+  [[ "$CLIENT_ID" =~ ^[^[:cntrl:]=]+$ ]] || die "Invalid --client: $CLIENT_ID"
+  [[ "$CLIENT_VERSION" =~ ^[A-Za-z0-9.+-]{0,40}$ ]] || die "Invalid --client-version: $CLIENT_VERSION"
+fi
+if [[ -n "$CACHE_DIR" ]]; then
+  [[ "$CACHE_DIR" =~ ^/[A-Za-z0-9._/-]+$ && "$CACHE_DIR" != *..* ]] || die "Invalid --cache-dir: $CACHE_DIR (use an absolute path)."
+  CACHE_DIR="${CACHE_DIR%/}"
+fi
+if (( CACHE_MB > 0 )) && [[ "$PROTOCOL" == "socks5" ]]; then
+  die "--cache-mb only applies to HTTP."
+fi
+if [[ "$SOCKET_ACTIVATION" == "1" && "$PROTOCOL" != "socks5" ]]; then
+  die "--socket-activation only applies to SOCKS5."
+fi
+if [[ -n "$MICROSOCKS_UPLOAD_SHA256" ]]; then
+  [[ "$MICROSOCKS_UPLOAD_SHA256" =~ ^[0-9a-f]{64}$ ]] || die "Invalid --microsocks-sha256: $MICROSOCKS_UPLOAD_SHA256"
+  [[ "$PROTOCOL" == "socks5" ]] || die "--microsocks-sha256 only applies to SOCKS5."
+fi
+
 if ! is_valid_positive_int "${SMART_BLINDER_IDLE_MINUTES:-10}"; then
   SMART_BLINDER_IDLE_MINUTES=10
 fi
@@ -1228,6 +3774,30 @@ case "$MODE" in
   inventory)
     print_inventory
     ;;
+  usage)
+    print_usage
+    ;;
+  users)
+    print_user_traffic
+    ;;
+  activity)
+    print_activity
+    ;;
+  security)
+    run_security_audit
+    ;;
+  os-update)
+    run_os_update
+    ;;
+  units)
+    print_units
+    ;;
+  squid-config)
+    print_squid_config
+    ;;
+  reboot)
+    schedule_reboot
+    ;;
   preflight)
     [[ "$PROTOCOL" == "http" || "$PROTOCOL" == "socks5" ]] || die "--protocol is required for preflight mode."
     run_preflight
@@ -1237,18 +3807,56 @@ case "$MODE" in
     show_setup
     ;;
   destroy)
-    destroy_hangar
+    record_last_client
+    if [[ -n "$INSTANCE" ]]; then
+      destroy_http_instance
+    else
+      destroy_hangar
+    fi
+    ;;
+  sign)
+    sign_metadata
+    ;;
+  rollback)
+    run_rollback
+    [[ -z "$ROLLBACK_TO" ]] || record_last_client
+    ;;
+  prepare)
+    [[ "$PROTOCOL" == "http" || "$PROTOCOL" == "socks5" ]] || die "--protocol is required for prepare mode."
+    trap 'die "Timed out after ${PHASE_TIMEOUT_SECONDS}s in the ${CURRENT_PHASE} phase."' TERM
+    begin_phase checks
+    ensure_requirements
+    check_egress_ip
+    run_prepare
+    stop_phase_timer
     ;;
   apply)
     [[ "$PROTOCOL" == "http" || "$PROTOCOL" == "socks5" ]] || die "--protocol is required for apply mode."
+    trap 'die "Timed out after ${PHASE_TIMEOUT_SECONDS}s in the ${CURRENT_PHASE} phase."' TERM
+    begin_phase checks
+    ensure_requirements
+    check_resources
+    check_egress_ip
+    begin_rollback
+    begin_phase configure
+    configure_traffic_cap
+    configure_hangar_info
+    record_last_client
     if [[ "$PROTOCOL" == "socks5" ]]; then
       apply_socks
     else
       apply_http
     fi
+    begin_phase schedules
+    configure_quiet_hours
+    configure_ttl
+    stop_phase_timer
+    commit_rollback
     ;;
   *)
     die "Unknown mode: $MODE"
     ;;
 esac
+
+print_metadata_written
 `
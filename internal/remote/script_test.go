@@ -0,0 +1,22 @@
+package remote
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestPayloadRunsFromStdin(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash not available")
+	}
+	cmd := exec.Command("bash", "-s", "--", "--mode", "no-such-mode")
+	cmd.Stdin = strings.NewReader(Payload())
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatalf("expected unknown mode to fail, got success:\n%s", out)
+	}
+	if !strings.Contains(string(out), "Unknown mode: no-such-mode") {
+		t.Fatalf("script did not reach argument dispatch:\n%s", out)
+	}
+}
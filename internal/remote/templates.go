@@ -0,0 +1,226 @@
+package remote
+
+import (
+	"embed"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// BeamDir is where the script keeps its state and helper scripts.
+const BeamDir = "/etc/beammeup"
+
+//go:embed templates/*.tmpl templates/proxy/*.tmpl
+var templateFS embed.FS
+
+// The unit templates only depend on BeamDir. squid.conf and the proxy units
+// also need what an apply's prepare run found on the server, so they are
+// rendered per apply (RenderProxyTemplates).
+const (
+	unitTemplateDir  = "templates"
+	proxyTemplateDir = "templates/proxy"
+)
+
+// TemplateData is what the templates are rendered with. Everything but
+// BeamDir is only set for the proxy templates, from the BM_PROXY_* values of
+// a prepare run (ParseTemplateData).
+type TemplateData struct {
+	BeamDir string
+
+	Port        int    // the proxy's public (or, with ListenLocal, loopback) port
+	ListenLocal bool   // HTTP: listen on 127.0.0.1 only
+	BindIP      string // SOCKS5: address microsocks or its socket listens on
+	EgressIP    string // source address of outgoing connections; empty for the default
+
+	// HTTP (Squid).
+	Sidecar      bool
+	Instance     string
+	SquidVersion string // e.g. 6.13
+	AuthHelper   string // basic_ncsa_auth
+	PasswordFile string
+	AllowFile    string // domain lists; empty when none was given
+	DenyFile     string
+	Anonymity    string // transparent, anonymous or elite
+	CacheMB      int
+	CacheDir     string
+	LogDir       string
+	PIDFile      string
+	ConfFile     string // sidecar: its squid.conf
+	SidecarDir   string
+	RunDir       string // sidecar: RuntimeDirectory under /run
+
+	// SOCKS5 (microsocks).
+	EnvFile       string
+	MicrosocksBin string
+	// With --socket-activation systemd owns the public port and starts
+	// microsocks, behind systemd-socket-proxyd on BackendPort, only when a
+	// client connects.
+	ProxydBin     string
+	BackendPort   int
+	IdleSeconds   int
+	WaitScript    string
+	SocksService  string
+	ProxydService string
+}
+
+// SquidAtLeast reports whether SquidVersion is major.minor or newer.
+func (d TemplateData) SquidAtLeast(major, minor int) bool {
+	var have [2]int
+	for i, part := range strings.SplitN(d.SquidVersion, ".", 3) {
+		if i == 2 {
+			break
+		}
+		have[i], _ = strconv.Atoi(part)
+	}
+	return have[0] > major || have[0] == major && have[1] >= minor
+}
+
+// ParseTemplateData reads the BM_PROXY_* values a prepare run printed. The
+// fingerprint it also returns goes back to the server with --prepared, so apply
+// can tell when the server changed in between.
+func ParseTemplateData(kv KeyValues) (TemplateData, string, error) {
+	fingerprint := kv.Get("BM_PROXY_FINGERPRINT")
+	if fingerprint == "" {
+		return TemplateData{}, "", errors.New("the prepare run reported no proxy settings")
+	}
+	return TemplateData{
+		BeamDir:       BeamDir,
+		Port:          kv.Int("BM_PROXY_PORT"),
+		ListenLocal:   kv.Bool("BM_PROXY_LISTEN_LOCAL"),
+		BindIP:        kv.Get("BM_PROXY_BIND_IP"),
+		EgressIP:      kv.Get("BM_PROXY_EGRESS_IP"),
+		Sidecar:       kv.Get("BM_PROXY_HTTP_MODE") == "sidecar",
+		Instance:      kv.Get("BM_PROXY_INSTANCE"),
+		SquidVersion:  kv.Get("BM_PROXY_SQUID_VERSION"),
+		AuthHelper:    kv.Get("BM_PROXY_AUTH_HELPER"),
+		PasswordFile:  kv.Get("BM_PROXY_PASSWORD_FILE"),
+		AllowFile:     kv.Get("BM_PROXY_ALLOW_FILE"),
+		DenyFile:      kv.Get("BM_PROXY_DENY_FILE"),
+		Anonymity:     kv.Get("BM_PROXY_ANONYMITY"),
+		CacheMB:       kv.Int("BM_PROXY_CACHE_MB"),
+		CacheDir:      kv.Get("BM_PROXY_CACHE_DIR"),
+		LogDir:        kv.Get("BM_PROXY_LOG_DIR"),
+		PIDFile:       kv.Get("BM_PROXY_PID_FILE"),
+		ConfFile:      kv.Get("BM_PROXY_CONF_FILE"),
+		SidecarDir:    kv.Get("BM_PROXY_SIDECAR_DIR"),
+		RunDir:        kv.Get("BM_PROXY_RUN_DIR"),
+		EnvFile:       kv.Get("BM_PROXY_ENV_FILE"),
+		MicrosocksBin: kv.Get("BM_PROXY_MICROSOCKS_BIN"),
+		ProxydBin:     kv.Get("BM_PROXY_PROXYD_BIN"),
+		BackendPort:   kv.Int("BM_PROXY_BACKEND_PORT"),
+		IdleSeconds:   kv.Int("BM_PROXY_IDLE_SECONDS"),
+		WaitScript:    kv.Get("BM_PROXY_WAIT_SCRIPT"),
+		SocksService:  kv.Get("BM_PROXY_SOCKS_SERVICE"),
+		ProxydService: kv.Get("BM_PROXY_PROXYD_SERVICE"),
+	}, fingerprint, nil
+}
+
+// RenderTemplates renders every unit template the script installs, keyed by
+// unit name (e.g. "traffic-cap.timer"). A file named <unit>.tmpl in
+// overrideDir replaces the built-in template; an empty overrideDir uses the
+// built-ins only.
+func RenderTemplates(overrideDir string) (map[string]string, error) {
+	return renderTemplates(unitTemplateDir, overrideDir, TemplateData{BeamDir: BeamDir})
+}
+
+// RenderProxyTemplates renders squid.conf and the proxy units with data,
+// keyed like RenderTemplates and overridden the same way.
+func RenderProxyTemplates(overrideDir string, data TemplateData) (map[string]string, error) {
+	return renderTemplates(proxyTemplateDir, overrideDir, data)
+}
+
+func renderTemplates(dir, overrideDir string, data TemplateData) (map[string]string, error) {
+	entries, err := fs.ReadDir(templateFS, dir)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]string, len(entries))
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		file := e.Name()
+		src, err := templateFS.ReadFile(dir + "/" + file)
+		if err != nil {
+			return nil, err
+		}
+		if overrideDir != "" {
+			custom, err := os.ReadFile(filepath.Join(overrideDir, file))
+			switch {
+			case err == nil:
+				src = custom
+			case !errors.Is(err, os.ErrNotExist):
+				return nil, fmt.Errorf("read template override: %w", err)
+			}
+		}
+		tmpl, err := template.New(file).Option("missingkey=error").Parse(string(src))
+		if err != nil {
+			return nil, fmt.Errorf("parse template %s: %w", file, err)
+		}
+		var b strings.Builder
+		if err := tmpl.Execute(&b, data); err != nil {
+			return nil, fmt.Errorf("render template %s: %w", file, err)
+		}
+		out[strings.TrimSuffix(file, ".tmpl")] = b.String()
+	}
+	return out, nil
+}
+
+// WriteTemplates copies the built-in templates into dir, as a starting point
+// for overrides. Existing files are left alone.
+func WriteTemplates(dir string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	var written []string
+	for _, from := range []string{unitTemplateDir, proxyTemplateDir} {
+		entries, err := fs.ReadDir(templateFS, from)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.IsDir() {
+				continue
+			}
+			path := filepath.Join(dir, e.Name())
+			if _, err := os.Stat(path); err == nil {
+				continue
+			}
+			src, err := templateFS.ReadFile(from + "/" + e.Name())
+			if err != nil {
+				return nil, err
+			}
+			if err := os.WriteFile(path, src, 0o600); err != nil {
+				return nil, err
+			}
+			written = append(written, path)
+		}
+	}
+	return written, nil
+}
+
+// templateFunc renders units as the bm_template shell function the script's
+// install_unit reads from. Contents travel base64 encoded so no quoting of
+// the unit text is needed.
+func templateFunc(units map[string]string) string {
+	names := make([]string, 0, len(units))
+	for name := range units {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString("bm_template() {\n  case \"$1\" in\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "    %s) printf '%%s' '%s' ;;\n", name, base64.StdEncoding.EncodeToString([]byte(units[name])))
+	}
+	b.WriteString("    *) return 1 ;;\n  esac\n}\n")
+	return b.String()
+}
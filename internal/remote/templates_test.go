@@ -0,0 +1,192 @@
+package remote
+
+import (
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// Prepare runs as the script prints them, one per kind of proxy config.
+var prepareRuns = map[string]string{
+	// The system Squid, elite, caching, with both domain lists.
+	"managed": `BM_PROXY_PORT=18181
+BM_PROXY_EGRESS_IP=198.51.100.9
+BM_PROXY_HTTP_MODE=managed
+BM_PROXY_LISTEN_LOCAL=0
+BM_PROXY_SQUID_VERSION=6.13
+BM_PROXY_AUTH_HELPER=/usr/lib/squid/basic_ncsa_auth
+BM_PROXY_ANONYMITY=elite
+BM_PROXY_CACHE_MB=512
+BM_PROXY_CACHE_DIR=/var/spool/squid/beammeup
+BM_PROXY_ALLOW_FILE=/etc/beammeup/http-allow-domains
+BM_PROXY_DENY_FILE=/etc/beammeup/http-deny-domains
+BM_PROXY_PASSWORD_FILE=/etc/beammeup/http.htpasswd
+BM_PROXY_LOG_DIR=/var/log/squid
+BM_PROXY_PID_FILE=/run/squid.pid
+BM_PROXY_FINGERPRINT=` + strings.Repeat("a", 64),
+	// An instance sidecar on Squid 3.1, which lacks the stdio: log prefix.
+	"instance": `BM_PROXY_PORT=3128
+BM_PROXY_EGRESS_IP=
+BM_PROXY_HTTP_MODE=sidecar
+BM_PROXY_LISTEN_LOCAL=1
+BM_PROXY_SQUID_VERSION=3.1.20
+BM_PROXY_AUTH_HELPER=/usr/lib/squid3/basic_ncsa_auth
+BM_PROXY_ANONYMITY=anonymous
+BM_PROXY_CACHE_MB=64
+BM_PROXY_CACHE_DIR=/etc/beammeup/http-sidecar-team-a/cache
+BM_PROXY_INSTANCE=team-a
+BM_PROXY_PASSWORD_FILE=/etc/beammeup/http-sidecar-team-a/htpasswd
+BM_PROXY_LOG_DIR=/var/log/beammeup-http-team-a
+BM_PROXY_PID_FILE=/run/beammeup-http-team-a/sidecar.pid
+BM_PROXY_CONF_FILE=/etc/beammeup/http-sidecar-team-a/squid.conf
+BM_PROXY_SIDECAR_DIR=/etc/beammeup/http-sidecar-team-a
+BM_PROXY_RUN_DIR=beammeup-http-team-a
+BM_PROXY_FINGERPRINT=` + strings.Repeat("b", 64),
+	"socks": `BM_PROXY_PORT=1080
+BM_PROXY_EGRESS_IP=2001:db8::5
+BM_PROXY_BIND_IP=0.0.0.0
+BM_PROXY_ENV_FILE=/etc/beammeup/microsocks.env
+BM_PROXY_MICROSOCKS_BIN=/usr/bin/microsocks
+BM_PROXY_FINGERPRINT=` + strings.Repeat("c", 64),
+	"socket-activation": `BM_PROXY_PORT=1080
+BM_PROXY_EGRESS_IP=
+BM_PROXY_BIND_IP=0.0.0.0
+BM_PROXY_ENV_FILE=/etc/beammeup/microsocks.env
+BM_PROXY_MICROSOCKS_BIN=/usr/local/bin/microsocks
+BM_PROXY_PROXYD_BIN=/usr/lib/systemd/systemd-socket-proxyd
+BM_PROXY_BACKEND_PORT=41080
+BM_PROXY_IDLE_SECONDS=600
+BM_PROXY_WAIT_SCRIPT=/etc/beammeup/microsocks-wait.sh
+BM_PROXY_SOCKS_SERVICE=beammeup-microsocks.service
+BM_PROXY_PROXYD_SERVICE=beammeup-microsocks-proxy.service
+BM_PROXY_FINGERPRINT=` + strings.Repeat("d", 64),
+}
+
+// goldenFiles is what each prepare run's golden files cover: the configs an
+// apply with it installs.
+var goldenFiles = map[string][]string{
+	"managed":           {"squid.conf"},
+	"instance":          {"squid.conf", "http-sidecar.service"},
+	"socks":             {"microsocks.service"},
+	"socket-activation": {"microsocks.socket", "microsocks-proxy.service", "microsocks-ondemand.service"},
+}
+
+func renderPrepareRun(t *testing.T, name string) map[string]string {
+	t.Helper()
+	data, _, err := ParseTemplateData(ParseBM(prepareRuns[name]))
+	if err != nil {
+		t.Fatalf("ParseTemplateData(%s): %v", name, err)
+	}
+	units, err := RenderProxyTemplates("", data)
+	if err != nil {
+		t.Fatalf("RenderProxyTemplates(%s): %v", name, err)
+	}
+	return units
+}
+
+func TestProxyTemplatesGolden(t *testing.T) {
+	for run, files := range goldenFiles {
+		units := renderPrepareRun(t, run)
+		for _, file := range files {
+			path := filepath.Join("testdata", run+"."+file+".golden")
+			if *update {
+				if err := os.WriteFile(path, []byte(units[file]), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("%v (run go test -update to create it)", err)
+			}
+			if units[file] != string(want) {
+				t.Errorf("%s rendered for %s differs from %s:\n%s", file, run, path, units[file])
+			}
+		}
+	}
+}
+
+func TestParseTemplateData(t *testing.T) {
+	data, fingerprint, err := ParseTemplateData(ParseBM(prepareRuns["instance"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fingerprint != strings.Repeat("b", 64) || !data.Sidecar || !data.ListenLocal || data.Port != 3128 || data.CacheMB != 64 || data.BeamDir != BeamDir {
+		t.Fatalf("unexpected data %+v (fingerprint %s)", data, fingerprint)
+	}
+	if !data.SquidAtLeast(3, 1) || data.SquidAtLeast(3, 2) {
+		t.Fatalf("Squid %s compared wrongly", data.SquidVersion)
+	}
+	if _, _, err := ParseTemplateData(KeyValues{"BM_PROXY_PORT": "3128"}); err == nil {
+		t.Fatal("a prepare run without a fingerprint should be refused")
+	}
+}
+
+func TestTemplatesMatchScript(t *testing.T) {
+	units, err := RenderTemplates("")
+	if err != nil {
+		t.Fatalf("RenderTemplates: %v", err)
+	}
+	for name, text := range renderPrepareRun(t, "socket-activation") {
+		units[name] = text
+	}
+	used := map[string]bool{}
+	for _, m := range regexp.MustCompile(`(?m)^\s+install_unit ([a-z.-]+) `).FindAllStringSubmatch(Script, -1) {
+		used[m[1]] = true
+		if _, ok := units[m[1]]; !ok {
+			t.Fatalf("script installs %s but there is no template for it", m[1])
+		}
+	}
+	for name, text := range units {
+		// Drop-ins are installed through a variable, so only check the name
+		// appears.
+		if !used[name] && !strings.Contains(Script, `"`+name+`"`) {
+			t.Fatalf("template %s is never installed", name)
+		}
+		section := "[Unit]\n"
+		switch {
+		case name == "squid.conf":
+			section = "http_port "
+		case strings.HasSuffix(name, ".conf"):
+			section = "[Service]\n"
+		}
+		if !strings.Contains(text, section) || strings.Contains(text, "{{") {
+			t.Fatalf("%s did not render cleanly:\n%s", name, text)
+		}
+	}
+	if got := units["traffic-cap.service"]; !strings.Contains(got, "ExecStart=/usr/bin/env bash /etc/beammeup/traffic-cap.sh\n") {
+		t.Fatalf("unexpected traffic-cap.service:\n%s", got)
+	}
+	if got := units["ttl-instance.service"]; !strings.Contains(got, "--mode destroy --instance %i\n") {
+		t.Fatalf("an instance's expiry must destroy only that instance:\n%s", got)
+	}
+}
+
+func TestTemplateOverride(t *testing.T) {
+	dir := t.TempDir()
+	custom := "[Unit]\nDescription=custom\n# {{.BeamDir}}\n"
+	if err := os.WriteFile(filepath.Join(dir, "quiet-hours.timer.tmpl"), []byte(custom), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	units, err := RenderTemplates(dir)
+	if err != nil {
+		t.Fatalf("RenderTemplates: %v", err)
+	}
+	if units["quiet-hours.timer"] != "[Unit]\nDescription=custom\n# /etc/beammeup\n" {
+		t.Fatalf("override not used: %q", units["quiet-hours.timer"])
+	}
+
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash not available")
+	}
+	out, err := exec.Command("bash", "-c", templateFunc(units)+`bm_template quiet-hours.timer | base64 -d`).CombinedOutput()
+	if err != nil || string(out) != units["quiet-hours.timer"] {
+		t.Fatalf("bm_template round trip = %q, %v", out, err)
+	}
+}
@@ -0,0 +1,157 @@
+package ships
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alfaoz/beammeup/internal/geoip"
+)
+
+// HostInfo is the short host report gathered during inventory. It is cached
+// next to the ship profile so it can be shown without reconnecting.
+type HostInfo struct {
+	OS        string
+	Kernel    string
+	Virt      string // systemd-detect-virt output, "none" on bare metal
+	Uptime    time.Duration
+	Ports     []string   // listening beammeup-relevant ports as "port/process"
+	Addresses []string   // global IPs, i.e. the choices for an egress IP
+	Geo       geoip.Info // where the exit IP sits; empty unless GeoIP is set up
+	CheckedAt time.Time
+}
+
+func (h HostInfo) IsZero() bool {
+	return h.OS == "" && h.Kernel == "" && h.Virt == "" && h.Uptime == 0 && len(h.Ports) == 0 && len(h.Addresses) == 0 && h.Geo.IsZero()
+}
+
+// Lines renders the report for display, one fact per line.
+func (h HostInfo) Lines() []string {
+	var lines []string
+	if h.OS != "" {
+		lines = append(lines, "OS: "+h.OS)
+	}
+	if h.Kernel != "" {
+		lines = append(lines, "Kernel: "+h.Kernel)
+	}
+	if h.Virt != "" {
+		lines = append(lines, "Virtualization: "+h.Virt)
+	}
+	if h.Uptime > 0 {
+		lines = append(lines, "Uptime: "+formatUptime(h.Uptime))
+	}
+	if len(h.Ports) > 0 {
+		lines = append(lines, "Listening: "+strings.Join(h.Ports, " "))
+	}
+	if len(h.Addresses) > 0 {
+		lines = append(lines, "Addresses: "+strings.Join(h.Addresses, " "))
+	}
+	if loc := h.Geo.Location(); loc != "" {
+		lines = append(lines, "Exit location: "+loc)
+	}
+	if network := h.Geo.Network(); network != "" {
+		lines = append(lines, "Network: "+network)
+	}
+	if !h.CheckedAt.IsZero() {
+		lines = append(lines, "Checked: "+h.CheckedAt.Local().Format("2006-01-02 15:04"))
+	}
+	return lines
+}
+
+func formatUptime(d time.Duration) string {
+	days := int(d.Hours()) / 24
+	hours := int(d.Hours()) % 24
+	if days > 0 {
+		return fmt.Sprintf("%dd %dh", days, hours)
+	}
+	return fmt.Sprintf("%dh %dm", hours, int(d.Minutes())%60)
+}
+
+func (s *Store) hostInfoPath(name string) string {
+	return filepath.Join(s.Dir, name+".host")
+}
+
+// LoadHostInfo returns the cached host report for name, or a zero HostInfo
+// if none was recorded yet.
+func (s *Store) LoadHostInfo(name string) (HostInfo, error) {
+	name = SanitizeName(name)
+	if name == "" {
+		return HostInfo{}, errors.New("invalid ship name")
+	}
+	f, err := os.Open(s.hostInfoPath(name))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return HostInfo{}, nil
+		}
+		return HostInfo{}, fmt.Errorf("open host info: %w", err)
+	}
+	defer f.Close()
+
+	vals := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.SplitN(strings.TrimSpace(scanner.Text()), "=", 2)
+		if len(parts) == 2 {
+			vals[parts[0]] = parts[1]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return HostInfo{}, fmt.Errorf("scan host info: %w", err)
+	}
+
+	uptime, _ := strconv.ParseInt(vals["UPTIME_SECONDS"], 10, 64)
+	checkedAt, _ := time.Parse(time.RFC3339, vals["CHECKED_AT"])
+	asn, _ := strconv.ParseUint(vals["ASN"], 10, 32)
+	return HostInfo{
+		OS:        vals["OS"],
+		Kernel:    vals["KERNEL"],
+		Virt:      vals["VIRT"],
+		Uptime:    time.Duration(uptime) * time.Second,
+		Ports:     strings.Fields(vals["PORTS"]),
+		Addresses: strings.Fields(vals["ADDRESSES"]),
+		Geo: geoip.Info{
+			CountryCode: vals["COUNTRY_CODE"],
+			Country:     vals["COUNTRY"],
+			City:        vals["CITY"],
+			ASN:         uint(asn),
+			Org:         vals["AS_ORG"],
+		},
+		CheckedAt: checkedAt,
+	}, nil
+}
+
+// SaveHostInfo caches h for name, replacing the previous report.
+func (s *Store) SaveHostInfo(name string, h HostInfo) error {
+	name = SanitizeName(name)
+	if name == "" {
+		return errors.New("invalid ship name")
+	}
+	var checkedAt string
+	if !h.CheckedAt.IsZero() {
+		checkedAt = h.CheckedAt.UTC().Format(time.RFC3339)
+	}
+	content := strings.Join([]string{
+		"OS=" + h.OS,
+		"KERNEL=" + h.Kernel,
+		"VIRT=" + h.Virt,
+		"UPTIME_SECONDS=" + strconv.FormatInt(int64(h.Uptime/time.Second), 10),
+		"PORTS=" + strings.Join(h.Ports, " "),
+		"ADDRESSES=" + strings.Join(h.Addresses, " "),
+		"COUNTRY_CODE=" + h.Geo.CountryCode,
+		"COUNTRY=" + h.Geo.Country,
+		"CITY=" + h.Geo.City,
+		"ASN=" + strconv.FormatUint(uint64(h.Geo.ASN), 10),
+		"AS_ORG=" + h.Geo.Org,
+		"CHECKED_AT=" + checkedAt,
+		"",
+	}, "\n")
+	if err := os.WriteFile(s.hostInfoPath(name), []byte(content), 0o600); err != nil {
+		return fmt.Errorf("write host info: %w", err)
+	}
+	return nil
+}
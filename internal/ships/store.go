@@ -4,20 +4,28 @@ import (
 	"bufio"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const DefaultDirSuffix = ".beammeup/ships"
 
+// DefaultTrafficWarnPercent is the share of the traffic quota at which
+// beammeup starts warning.
+const DefaultTrafficWarnPercent = 80
+
 type Ship struct {
 	Name                    string
 	Host                    string
 	SSHPort                 int
 	SSHUser                 string
+	IdentityFile            string // private key beammeup logs in with, ahead of ssh-agent; also written to the exported ssh_config
 	Protocol                string
 	HTTPMode                string
 	ProxyPort               int
@@ -25,6 +33,59 @@ type Ship struct {
 	ListenLocal             bool
 	SmartBlinder            bool
 	SmartBlinderIdleMinutes int
+	TrafficQuotaGB          int // monthly provider quota; 0 disables warnings
+	TrafficWarnPercent      int
+	TrafficCapGB            int       // server-side hard cap; 0 disables it
+	QuietHours              string    // UTC windows (HH:MM-HH:MM, comma separated) when the server stops the proxy
+	MaxConns                int       // concurrent connections the hangar's credential may hold; 0 is unlimited
+	RateLimit               int       // new connections per minute on the hangar's credential; 0 is unlimited
+	MemoryMax               string    // systemd MemoryMax for the proxy unit, e.g. "256M"; empty is uncapped
+	CPUQuota                string    // systemd CPUQuota for the proxy unit, e.g. "50%"; empty is uncapped
+	TasksMax                int       // systemd TasksMax for the proxy unit; 0 is uncapped
+	EgressIP                string    // source address for proxy traffic on multi-IP servers; empty uses the default route
+	SocketActivation        bool      // SOCKS5 only: systemd starts microsocks when a client connects
+	Instance                string    // named HTTP sidecar instance on a shared server; empty is the default hangar
+	CacheMB                 int       // HTTP disk cache size; 0 keeps caching off
+	CacheDir                string    // HTTP cache location; empty lets the server pick
+	Anonymity               string    // HTTP header preset: transparent, anonymous, or empty for elite
+	AllowDomains            []string  // HTTP only reaches these destinations when set (Squid dstdomain entries)
+	DenyDomains             []string  // HTTP refuses these destinations
+	Tags                    []string  // local labels for picking ships as a group, e.g. by beammeup export --tag
+	HangarLabel             string    // name stored in the server's hangar.json; empty keeps the server's, "none" clears it
+	HangarDescription       string    // longer note stored in hangar.json, e.g. who to contact; empty and "none" as for HangarLabel
+	Exports                 []string  // format:path files rewritten whenever the proxy credentials change
+	Forwards                []string  // saved port forwards (L:listen=to or R:listen=to), run by beammeup forward
+	CloudFirewall           string    // hetzner or digitalocean: open the proxy port in the provider's firewall on apply; empty leaves it alone
+	CloudFirewallPort       int       // port beammeup opened there, closed again on destroy; 0 if none
+	ExpiresAt               time.Time // when the server destroys the hangar (--ttl); zero if never
+	HostKeyChanged          string    // fingerprint of a changed SSH host key not trusted yet; nothing connects until it is
+}
+
+// HasTag reports whether the ship carries tag.
+func (s Ship) HasTag(tag string) bool {
+	for _, t := range s.Tags {
+		if t == SanitizeName(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTags reads a comma list of tags, normalized like ship names and
+// without duplicates.
+func ParseTags(raw string) []string {
+	var tags []string
+	for _, t := range strings.Split(raw, ",") {
+		if t = SanitizeName(t); t != "" && !slices.Contains(tags, t) {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// Expired reports whether the hangar's scheduled destroy time has passed.
+func (s Ship) Expired(now time.Time) bool {
+	return !s.ExpiresAt.IsZero() && !now.Before(s.ExpiresAt)
 }
 
 type Store struct {
@@ -106,9 +167,27 @@ func (s *Store) Load(name string) (Ship, error) {
 		return Ship{}, fmt.Errorf("open ship file: %w", err)
 	}
 	defer f.Close()
+	return parse(name, f)
+}
+
+// LoadFile reads a .ship file outside the store, e.g. one about to be copied
+// in. The ship is named after the file.
+func LoadFile(path string) (Ship, error) {
+	name := SanitizeName(strings.TrimSuffix(filepath.Base(path), ".ship"))
+	if name == "" {
+		return Ship{}, errors.New("invalid ship name")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return Ship{}, fmt.Errorf("open ship file: %w", err)
+	}
+	defer f.Close()
+	return parse(name, f)
+}
 
+func parse(name string, r io.Reader) (Ship, error) {
 	vals := map[string]string{}
-	scanner := bufio.NewScanner(f)
+	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
 		if line == "" || strings.HasPrefix(line, "#") {
@@ -132,18 +211,30 @@ func (s *Store) Load(name string) (Ship, error) {
 	}
 	noFW := vals["NO_FIREWALL_CHANGE"] == "1" || strings.EqualFold(vals["NO_FIREWALL_CHANGE"], "true")
 	listenLocal := vals["LISTEN_LOCAL"] == "1" || strings.EqualFold(vals["LISTEN_LOCAL"], "true")
+	socketActivation := vals["SOCKET_ACTIVATION"] == "1" || strings.EqualFold(vals["SOCKET_ACTIVATION"], "true")
 
 	smartBlinder := true
 	if v, ok := vals["SMART_BLINDER"]; ok && strings.TrimSpace(v) != "" {
 		smartBlinder = v == "1" || strings.EqualFold(v, "true") || strings.EqualFold(v, "yes")
 	}
 	blinderIdleMin := parseIntDefault(vals["SMART_BLINDER_IDLE_MINUTES"], 10)
+	quotaGB, _ := strconv.Atoi(strings.TrimSpace(vals["TRAFFIC_QUOTA_GB"]))
+	if quotaGB < 0 {
+		quotaGB = 0
+	}
+	warnPercent := parseIntDefault(vals["TRAFFIC_WARN_PERCENT"], DefaultTrafficWarnPercent)
+	capGB, _ := strconv.Atoi(strings.TrimSpace(vals["TRAFFIC_CAP_GB"]))
+	if capGB < 0 {
+		capGB = 0
+	}
+	expiresAt, _ := time.Parse(time.RFC3339, strings.TrimSpace(vals["EXPIRES_AT"]))
 
 	ship := Ship{
 		Name:                    name,
 		Host:                    vals["HOST"],
 		SSHPort:                 sshPort,
 		SSHUser:                 defaultIfEmpty(vals["SSH_USER"], "root"),
+		IdentityFile:            strings.TrimSpace(vals["SSH_IDENTITY_FILE"]),
 		Protocol:                protocol,
 		HTTPMode:                normalizeHTTPMode(vals["HTTP_MODE"]),
 		ProxyPort:               proxyPort,
@@ -151,6 +242,32 @@ func (s *Store) Load(name string) (Ship, error) {
 		ListenLocal:             listenLocal,
 		SmartBlinder:            smartBlinder,
 		SmartBlinderIdleMinutes: blinderIdleMin,
+		TrafficQuotaGB:          quotaGB,
+		TrafficWarnPercent:      warnPercent,
+		TrafficCapGB:            capGB,
+		QuietHours:              strings.TrimSpace(vals["QUIET_HOURS"]),
+		MaxConns:                parseIntDefault(vals["MAX_CONNS"], 0),
+		RateLimit:               parseIntDefault(vals["RATE_LIMIT"], 0),
+		MemoryMax:               strings.TrimSpace(vals["MEMORY_MAX"]),
+		CPUQuota:                strings.TrimSpace(vals["CPU_QUOTA"]),
+		TasksMax:                parseIntDefault(vals["TASKS_MAX"], 0),
+		EgressIP:                strings.TrimSpace(vals["EGRESS_IP"]),
+		SocketActivation:        socketActivation,
+		Instance:                strings.TrimSpace(vals["INSTANCE"]),
+		CacheMB:                 parseIntDefault(vals["CACHE_MB"], 0),
+		CacheDir:                strings.TrimSpace(vals["CACHE_DIR"]),
+		Anonymity:               normalizeAnonymity(vals["ANONYMITY"]),
+		AllowDomains:            splitList(vals["ALLOW_DOMAINS"]),
+		DenyDomains:             splitList(vals["DENY_DOMAINS"]),
+		Tags:                    ParseTags(vals["TAGS"]),
+		HangarLabel:             strings.TrimSpace(vals["HANGAR_LABEL"]),
+		HangarDescription:       strings.TrimSpace(vals["HANGAR_DESCRIPTION"]),
+		Exports:                 splitList(vals["EXPORTS"]),
+		Forwards:                splitList(vals["FORWARDS"]),
+		CloudFirewall:           strings.TrimSpace(vals["CLOUD_FIREWALL"]),
+		CloudFirewallPort:       parseIntDefault(vals["CLOUD_FIREWALL_PORT"], 0),
+		ExpiresAt:               expiresAt,
+		HostKeyChanged:          strings.TrimSpace(vals["HOST_KEY_CHANGED"]),
 	}
 	if strings.TrimSpace(ship.Host) == "" {
 		return Ship{}, fmt.Errorf("ship %q missing HOST", name)
@@ -176,6 +293,17 @@ func (s *Store) Save(ship Ship) (Ship, error) {
 		ship.Protocol = "http"
 	}
 	ship.HTTPMode = normalizeHTTPMode(ship.HTTPMode)
+	ship.Anonymity = normalizeAnonymity(ship.Anonymity)
+	ship.Instance = strings.TrimSpace(ship.Instance)
+	if ship.Instance != "" {
+		if !ValidInstanceName(ship.Instance) {
+			return Ship{}, fmt.Errorf("invalid instance name %q", ship.Instance)
+		}
+		if ship.Protocol != "http" {
+			return Ship{}, errors.New("instances are HTTP only")
+		}
+		ship.HTTPMode = "sidecar"
+	}
 	if ship.ProxyPort == 0 {
 		if ship.Protocol == "socks5" {
 			ship.ProxyPort = 1080
@@ -186,6 +314,20 @@ func (s *Store) Save(ship Ship) (Ship, error) {
 	if ship.SmartBlinderIdleMinutes <= 0 {
 		ship.SmartBlinderIdleMinutes = 10
 	}
+	if ship.TrafficQuotaGB < 0 {
+		ship.TrafficQuotaGB = 0
+	}
+	if ship.TrafficCapGB < 0 {
+		ship.TrafficCapGB = 0
+	}
+	if ship.TrafficWarnPercent <= 0 {
+		ship.TrafficWarnPercent = DefaultTrafficWarnPercent
+	}
+
+	var expiresAt string
+	if !ship.ExpiresAt.IsZero() {
+		expiresAt = ship.ExpiresAt.UTC().Format(time.RFC3339)
+	}
 
 	var noFW string
 	if ship.NoFirewallChange {
@@ -206,11 +348,16 @@ func (s *Store) Save(ship Ship) (Ship, error) {
 	} else {
 		smartBlinder = "0"
 	}
+	socketActivation := "0"
+	if ship.SocketActivation {
+		socketActivation = "1"
+	}
 
 	content := strings.Join([]string{
 		"HOST=" + ship.Host,
 		"SSH_PORT=" + strconv.Itoa(ship.SSHPort),
 		"SSH_USER=" + ship.SSHUser,
+		"SSH_IDENTITY_FILE=" + strings.TrimSpace(ship.IdentityFile),
 		"PROTOCOL=" + ship.Protocol,
 		"HTTP_MODE=" + ship.HTTPMode,
 		"PROXY_PORT=" + strconv.Itoa(ship.ProxyPort),
@@ -218,6 +365,32 @@ func (s *Store) Save(ship Ship) (Ship, error) {
 		"LISTEN_LOCAL=" + listenLocal,
 		"SMART_BLINDER=" + smartBlinder,
 		"SMART_BLINDER_IDLE_MINUTES=" + strconv.Itoa(ship.SmartBlinderIdleMinutes),
+		"TRAFFIC_QUOTA_GB=" + strconv.Itoa(ship.TrafficQuotaGB),
+		"TRAFFIC_WARN_PERCENT=" + strconv.Itoa(ship.TrafficWarnPercent),
+		"TRAFFIC_CAP_GB=" + strconv.Itoa(ship.TrafficCapGB),
+		"QUIET_HOURS=" + ship.QuietHours,
+		"MAX_CONNS=" + strconv.Itoa(ship.MaxConns),
+		"RATE_LIMIT=" + strconv.Itoa(ship.RateLimit),
+		"MEMORY_MAX=" + strings.TrimSpace(ship.MemoryMax),
+		"CPU_QUOTA=" + strings.TrimSpace(ship.CPUQuota),
+		"TASKS_MAX=" + strconv.Itoa(ship.TasksMax),
+		"EGRESS_IP=" + strings.TrimSpace(ship.EgressIP),
+		"SOCKET_ACTIVATION=" + socketActivation,
+		"INSTANCE=" + ship.Instance,
+		"CACHE_MB=" + strconv.Itoa(ship.CacheMB),
+		"CACHE_DIR=" + strings.TrimSpace(ship.CacheDir),
+		"ANONYMITY=" + ship.Anonymity,
+		"ALLOW_DOMAINS=" + strings.Join(ship.AllowDomains, ","),
+		"DENY_DOMAINS=" + strings.Join(ship.DenyDomains, ","),
+		"TAGS=" + strings.Join(ship.Tags, ","),
+		"HANGAR_LABEL=" + strings.TrimSpace(ship.HangarLabel),
+		"HANGAR_DESCRIPTION=" + strings.TrimSpace(ship.HangarDescription),
+		"EXPORTS=" + strings.Join(ship.Exports, ","),
+		"FORWARDS=" + strings.Join(ship.Forwards, ","),
+		"CLOUD_FIREWALL=" + strings.TrimSpace(ship.CloudFirewall),
+		"CLOUD_FIREWALL_PORT=" + strconv.Itoa(ship.CloudFirewallPort),
+		"EXPIRES_AT=" + expiresAt,
+		"HOST_KEY_CHANGED=" + strings.TrimSpace(ship.HostKeyChanged),
 		"",
 	}, "\n")
 
@@ -233,15 +406,61 @@ func (s *Store) Delete(name string) error {
 	if name == "" {
 		return errors.New("invalid ship name")
 	}
-	if err := os.Remove(s.path(name)); err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return nil
-		}
+	if err := os.Remove(s.path(name)); err != nil && !errors.Is(err, os.ErrNotExist) {
 		return fmt.Errorf("delete ship: %w", err)
 	}
+	if err := os.Remove(s.hostInfoPath(name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("delete host info: %w", err)
+	}
 	return nil
 }
 
+// lastFile names the ship used most recently, for --last.
+const lastFile = ".last"
+
+// Last returns the ship used most recently, or "" when none was, or it has
+// been deleted since.
+func (s *Store) Last() string {
+	b, err := os.ReadFile(filepath.Join(s.Dir, lastFile))
+	if err != nil {
+		return ""
+	}
+	name := SanitizeName(string(b))
+	if name == "" {
+		return ""
+	}
+	if _, err := os.Stat(s.path(name)); err != nil {
+		return ""
+	}
+	return name
+}
+
+// SetLast remembers name as the ship used most recently.
+func (s *Store) SetLast(name string) error {
+	name = SanitizeName(name)
+	if name == "" {
+		return errors.New("invalid ship name")
+	}
+	if err := os.WriteFile(filepath.Join(s.Dir, lastFile), []byte(name+"\n"), 0o600); err != nil {
+		return fmt.Errorf("record last ship: %w", err)
+	}
+	return nil
+}
+
+// ValidInstanceName reports whether name can label an HTTP instance: 1-32
+// characters of a-z, 0-9 and '-', not starting with '-'.
+func ValidInstanceName(name string) bool {
+	if len(name) == 0 || len(name) > 32 || name[0] == '-' {
+		return false
+	}
+	for _, r := range name {
+		if !((r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
 func defaultIfEmpty(v, d string) string {
 	if strings.TrimSpace(v) == "" {
 		return d
@@ -257,6 +476,27 @@ func parseIntDefault(raw string, def int) int {
 	return v
 }
 
+func splitList(raw string) []string {
+	var out []string
+	for _, v := range strings.Split(raw, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// normalizeAnonymity keeps the header presets that differ from the default
+// (elite), which is stored as "".
+func normalizeAnonymity(v string) string {
+	switch v = strings.ToLower(strings.TrimSpace(v)); v {
+	case "transparent", "anonymous":
+		return v
+	default:
+		return ""
+	}
+}
+
 func normalizeHTTPMode(v string) string {
 	switch strings.ToLower(strings.TrimSpace(v)) {
 	case "sidecar":
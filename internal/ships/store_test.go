@@ -5,6 +5,9 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/alfaoz/beammeup/internal/geoip"
 )
 
 func TestStoreSaveLoadLegacyCompatibility(t *testing.T) {
@@ -26,6 +29,7 @@ func TestStoreSaveLoadLegacyCompatibility(t *testing.T) {
 		ListenLocal:             true,
 		SmartBlinder:            true,
 		SmartBlinderIdleMinutes: 15,
+		ExpiresAt:               time.Date(2026, 10, 16, 18, 0, 0, 0, time.UTC),
 	})
 	if err != nil {
 		t.Fatalf("Save: %v", err)
@@ -50,6 +54,7 @@ func TestStoreSaveLoadLegacyCompatibility(t *testing.T) {
 		"LISTEN_LOCAL=1",
 		"SMART_BLINDER=1",
 		"SMART_BLINDER_IDLE_MINUTES=15",
+		"EXPIRES_AT=2026-10-16T18:00:00Z",
 	} {
 		if !strings.Contains(got, key) {
 			t.Fatalf("expected %q in file", key)
@@ -78,6 +83,9 @@ func TestStoreSaveLoadLegacyCompatibility(t *testing.T) {
 	if loaded.SmartBlinderIdleMinutes != 15 {
 		t.Fatalf("expected SmartBlinderIdleMinutes=15, got %d", loaded.SmartBlinderIdleMinutes)
 	}
+	if !loaded.ExpiresAt.Equal(saved.ExpiresAt) || !loaded.Expired(saved.ExpiresAt) || loaded.Expired(saved.ExpiresAt.Add(-time.Minute)) {
+		t.Fatalf("unexpected expiry: %v", loaded.ExpiresAt)
+	}
 }
 
 func TestStoreLoadLegacyDefaults(t *testing.T) {
@@ -115,6 +123,12 @@ func TestStoreLoadLegacyDefaults(t *testing.T) {
 	if loaded.SmartBlinderIdleMinutes != 10 {
 		t.Fatalf("expected default SmartBlinderIdleMinutes=10, got %d", loaded.SmartBlinderIdleMinutes)
 	}
+	if loaded.TrafficQuotaGB != 0 || loaded.TrafficWarnPercent != DefaultTrafficWarnPercent {
+		t.Fatalf("unexpected traffic quota defaults: %d GB / %d%%", loaded.TrafficQuotaGB, loaded.TrafficWarnPercent)
+	}
+	if !loaded.ExpiresAt.IsZero() || loaded.Expired(time.Now()) {
+		t.Fatalf("expected no expiry by default, got %v", loaded.ExpiresAt)
+	}
 }
 
 func TestStoreDelete(t *testing.T) {
@@ -135,3 +149,100 @@ func TestStoreDelete(t *testing.T) {
 		t.Fatalf("expected file deleted, stat err=%v", err)
 	}
 }
+
+func TestHostInfoRoundTrip(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	if h, err := store.LoadHostInfo("edge"); err != nil || !h.IsZero() {
+		t.Fatalf("expected empty report before the first scan, got %+v err=%v", h, err)
+	}
+
+	want := HostInfo{
+		OS:        "Ubuntu 24.04 LTS",
+		Kernel:    "6.8.0-45-generic",
+		Virt:      "kvm",
+		Uptime:    50 * time.Hour,
+		Ports:     []string{"22/sshd", "18181/squid"},
+		Addresses: []string{"203.0.113.7", "203.0.113.9"},
+		Geo:       geoip.Info{CountryCode: "DE", Country: "Germany", City: "Frankfurt am Main", ASN: 64500, Org: "Example Net"},
+		CheckedAt: time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC),
+	}
+	if err := store.SaveHostInfo("edge", want); err != nil {
+		t.Fatalf("SaveHostInfo: %v", err)
+	}
+	got, err := store.LoadHostInfo("edge")
+	if err != nil {
+		t.Fatalf("LoadHostInfo: %v", err)
+	}
+	if got.OS != want.OS || got.Kernel != want.Kernel || got.Virt != want.Virt || got.Uptime != want.Uptime ||
+		strings.Join(got.Ports, " ") != "22/sshd 18181/squid" || len(got.Addresses) != 2 || got.Geo != want.Geo || !got.CheckedAt.Equal(want.CheckedAt) {
+		t.Fatalf("unexpected host info: %+v", got)
+	}
+	if lines := strings.Join(got.Lines(), "\n"); !strings.Contains(lines, "Uptime: 2d 2h") || !strings.Contains(lines, "Network: AS64500 Example Net") {
+		t.Fatalf("unexpected report:\n%s", lines)
+	}
+
+	if err := store.Delete("edge"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if h, _ := store.LoadHostInfo("edge"); !h.IsZero() {
+		t.Fatalf("expected host info removed with the ship, got %+v", h)
+	}
+}
+
+func TestStoreInstance(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	saved, err := store.Save(Ship{Name: "team-a", Host: "203.0.113.4", Protocol: "http", Instance: "team-a"})
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if saved.HTTPMode != "sidecar" {
+		t.Fatalf("instances should be sidecars, got mode %q", saved.HTTPMode)
+	}
+	loaded, err := store.Load("team-a")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if loaded.Instance != "team-a" {
+		t.Fatalf("instance not round-tripped: %+v", loaded)
+	}
+	if _, err := store.Save(Ship{Name: "s", Host: "203.0.113.4", Protocol: "socks5", Instance: "x"}); err == nil {
+		t.Fatalf("SOCKS5 ship with an instance should be rejected")
+	}
+	if _, err := store.Save(Ship{Name: "bad", Host: "203.0.113.4", Instance: "Bad_Name"}); err == nil {
+		t.Fatalf("invalid instance name should be rejected")
+	}
+}
+
+func TestStoreLast(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	if got := store.Last(); got != "" {
+		t.Fatalf("Last() on an empty store = %q", got)
+	}
+	if _, err := store.Save(Ship{Name: "edge", Host: "example.invalid"}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if err := store.SetLast("edge"); err != nil {
+		t.Fatalf("SetLast: %v", err)
+	}
+	if got := store.Last(); got != "edge" {
+		t.Fatalf("Last() = %q, want edge", got)
+	}
+	if names, _ := store.List(); len(names) != 1 {
+		t.Fatalf("List() = %v; the marker must not show up as a ship", names)
+	}
+	if err := store.Delete("edge"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if got := store.Last(); got != "" {
+		t.Fatalf("Last() after delete = %q", got)
+	}
+}
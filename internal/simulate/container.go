@@ -0,0 +1,161 @@
+package simulate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Container is a disposable systemd-enabled container that stands in for a
+// VPS so the remote script can be exercised without a real server.
+type Container struct {
+	Engine string
+	Name   string
+	Image  string
+}
+
+const imageRepo = "beammeup-sim"
+
+// DetectEngine returns the container engine to use. An explicit preference
+// must be installed; otherwise docker is preferred over podman.
+func DetectEngine(preferred string) (string, error) {
+	preferred = strings.ToLower(strings.TrimSpace(preferred))
+	if preferred != "" {
+		if preferred != "docker" && preferred != "podman" {
+			return "", fmt.Errorf("unsupported container engine %q (use docker or podman)", preferred)
+		}
+		if _, err := exec.LookPath(preferred); err != nil {
+			return "", fmt.Errorf("%s not found in PATH", preferred)
+		}
+		return preferred, nil
+	}
+	for _, candidate := range []string{"docker", "podman"} {
+		if _, err := exec.LookPath(candidate); err == nil {
+			return candidate, nil
+		}
+	}
+	return "", errors.New("no container engine found (install docker or podman)")
+}
+
+// ValidateDistro checks that distro names a base image the remote script
+// supports (Debian/Ubuntu).
+func ValidateDistro(distro string) error {
+	d := strings.ToLower(strings.TrimSpace(distro))
+	if strings.HasPrefix(d, "ubuntu:") || strings.HasPrefix(d, "debian:") || d == "ubuntu" || d == "debian" {
+		return nil
+	}
+	return fmt.Errorf("unsupported distro %q (use ubuntu:<tag> or debian:<tag>)", distro)
+}
+
+// ImageTag returns the local image tag used for distro's systemd-enabled
+// simulation image.
+func ImageTag(distro string) string {
+	tag := strings.NewReplacer(":", "-", "/", "-", "@", "-").Replace(strings.ToLower(strings.TrimSpace(distro)))
+	return imageRepo + ":" + tag
+}
+
+func dockerfile(distro string) string {
+	return strings.Join([]string{
+		"FROM " + distro,
+		"ENV container=docker",
+		"RUN apt-get update && DEBIAN_FRONTEND=noninteractive apt-get install -y --no-install-recommends systemd systemd-sysv dbus ca-certificates curl iproute2 && apt-get clean && rm -rf /var/lib/apt/lists/*",
+		"STOPSIGNAL SIGRTMIN+3",
+		`CMD ["/lib/systemd/systemd"]`,
+		"",
+	}, "\n")
+}
+
+// Start builds (if needed) the simulation image for distro and boots a
+// privileged container running systemd as PID 1.
+func Start(engine, distro string, logf func(format string, args ...any)) (*Container, error) {
+	if logf == nil {
+		logf = func(string, ...any) {}
+	}
+	if err := ValidateDistro(distro); err != nil {
+		return nil, err
+	}
+
+	image := ImageTag(distro)
+	if _, err := run(engine, nil, "image", "inspect", image); err != nil {
+		logf("building simulation image %s", image)
+		if out, err := run(engine, strings.NewReader(dockerfile(distro)), "build", "-t", image, "-"); err != nil {
+			return nil, fmt.Errorf("build simulation image: %w\n%s", err, tail(out, 4096))
+		}
+	}
+
+	name := fmt.Sprintf("beammeup-sim-%d", time.Now().UnixNano())
+	out, err := run(engine, nil, "run", "-d",
+		"--name", name,
+		"--hostname", "beammeup-sim",
+		"--privileged",
+		"--cgroupns=host",
+		"-v", "/sys/fs/cgroup:/sys/fs/cgroup:rw",
+		"--tmpfs", "/run",
+		"--tmpfs", "/run/lock",
+		image,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("start simulation container: %w\n%s", err, tail(out, 4096))
+	}
+	c := &Container{Engine: engine, Name: name, Image: image}
+
+	logf("waiting for systemd in %s", name)
+	if err := c.waitForSystemd(60 * time.Second); err != nil {
+		_ = c.Remove()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Container) waitForSystemd(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		out, _ := run(c.Engine, nil, "exec", c.Name, "systemctl", "is-system-running")
+		state := strings.TrimSpace(out)
+		if state == "running" || state == "degraded" {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("systemd did not become ready in container (state=%q)", state)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// RunScript pipes script into bash inside the container and returns the
+// combined output. Its signature matches hangar.Service.ScriptRunner.
+func (c *Container) RunScript(script string, args []string) (string, error) {
+	full := append([]string{"exec", "-i", c.Name, "bash", "-s", "--"}, args...)
+	return run(c.Engine, strings.NewReader(script), full...)
+}
+
+// Remove force-removes the container.
+func (c *Container) Remove() error {
+	if out, err := run(c.Engine, nil, "rm", "-f", c.Name); err != nil {
+		return fmt.Errorf("remove container %s: %w\n%s", c.Name, err, tail(out, 1024))
+	}
+	return nil
+}
+
+func run(engine string, stdin *strings.Reader, args ...string) (string, error) {
+	cmd := exec.Command(engine, args...)
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
+	var buf bytes.Buffer
+	cmd.Stdout = &buf
+	cmd.Stderr = &buf
+	err := cmd.Run()
+	return buf.String(), err
+}
+
+func tail(s string, max int) string {
+	s = strings.TrimSpace(s)
+	if len(s) <= max {
+		return s
+	}
+	return "[...]\n" + s[len(s)-max:]
+}
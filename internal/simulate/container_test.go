@@ -0,0 +1,22 @@
+package simulate
+
+import "testing"
+
+func TestValidateDistro(t *testing.T) {
+	for _, ok := range []string{"ubuntu:24.04", "debian:12", "ubuntu"} {
+		if err := ValidateDistro(ok); err != nil {
+			t.Fatalf("ValidateDistro(%q): %v", ok, err)
+		}
+	}
+	for _, bad := range []string{"", "alpine:3.20", "fedora:40"} {
+		if err := ValidateDistro(bad); err == nil {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestImageTag(t *testing.T) {
+	if got := ImageTag("Ubuntu:24.04"); got != "beammeup-sim:ubuntu-24.04" {
+		t.Fatalf("ImageTag = %q", got)
+	}
+}
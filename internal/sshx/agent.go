@@ -0,0 +1,72 @@
+package sshx
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// ErrNoAuth is returned when a target has no password, no usable identity
+// file and no ssh-agent is running to log in with.
+var ErrNoAuth = errors.New("no SSH password given, no usable IdentityFile and no ssh-agent reachable (SSH_AUTH_SOCK)")
+
+// publicKeys offers the keys in files, then the running ssh-agent's. The
+// server only gets one publickey method, so both go in the same one. close
+// releases the agent, which has to stay open until the handshake is done
+// since signing happens through it. The method is nil with no key at all.
+func publicKeys(files []string) (method ssh.AuthMethod, close func()) {
+	var signers []ssh.Signer
+	for _, path := range files {
+		// Missing and passphrase-protected keys are skipped: OpenSSH also
+		// tolerates missing IdentityFiles, and the agent may hold the key.
+		b, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		if s, err := ssh.ParsePrivateKey(b); err == nil {
+			signers = append(signers, s)
+		}
+	}
+	var agentClient agent.ExtendedAgent
+	close = func() {}
+	if sock := strings.TrimSpace(os.Getenv("SSH_AUTH_SOCK")); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			agentClient, close = agent.NewClient(conn), func() { conn.Close() }
+		}
+	}
+	if len(signers) == 0 && agentClient == nil {
+		return nil, close
+	}
+	return ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+		if agentClient == nil {
+			return signers, nil
+		}
+		fromAgent, err := agentClient.Signers()
+		if err != nil {
+			return signers, nil
+		}
+		return append(signers, fromAgent...), nil
+	}), close
+}
+
+// KeyLogin reports whether keys alone, from the ssh-agent or an
+// IdentityFile in ~/.ssh/config, log in to t, so callers can skip asking for
+// a password. t.Password is ignored, and keyboard-interactive questions
+// are not answered.
+func KeyLogin(t Target, opts ConnectOptions) bool {
+	t.Password = ""
+	// A probe must not ask for (or use up) a one-time code.
+	opts.OTPCommand, opts.Prompt = "", nil
+	// Nor wait out retries: the connection that follows retries anyway.
+	opts.Retries = 0
+	c, err := ConnectWithOptions(t, opts)
+	if err != nil {
+		return false
+	}
+	c.Close()
+	return true
+}
@@ -0,0 +1,93 @@
+package sshx
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestConnectWithoutPasswordOrAgent(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	_, err := ConnectWithOptions(Target{Host: "127.0.0.1", User: "root"}, ConnectOptions{HostKeyMode: HostKeyInsecureIgnore})
+	if !errors.Is(err, ErrNoAuth) {
+		t.Fatalf("expected ErrNoAuth, got %v", err)
+	}
+}
+
+func TestKeyLogin(t *testing.T) {
+	_, userKey, _ := ed25519.GenerateKey(rand.Reader)
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: userKey}); err != nil {
+		t.Fatal(err)
+	}
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	agentLn, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer agentLn.Close()
+	go func() {
+		for {
+			conn, err := agentLn.Accept()
+			if err != nil {
+				return
+			}
+			go agent.ServeAgent(keyring, conn)
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", sock)
+
+	signer, _ := ssh.NewSignerFromKey(userKey)
+	allowed := signer.PublicKey().Marshal()
+	_, hostKey, _ := ed25519.GenerateKey(rand.Reader)
+	hostSigner, _ := ssh.NewSignerFromKey(hostKey)
+	cfg := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(key.Marshal(), allowed) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+	}
+	cfg.AddHostKey(hostSigner)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if _, chans, reqs, err := ssh.NewServerConn(conn, cfg); err == nil {
+					go ssh.DiscardRequests(reqs)
+					for ch := range chans {
+						ch.Reject(ssh.Prohibited, "test")
+					}
+				}
+			}()
+		}
+	}()
+
+	port := ln.Addr().(*net.TCPAddr).Port
+	target := Target{Host: "127.0.0.1", Port: port, User: "root"}
+	opts := ConnectOptions{HostKeyMode: HostKeyInsecureIgnore}
+	if !KeyLogin(target, opts) {
+		t.Fatal("expected the agent key to log in")
+	}
+	keyring.RemoveAll()
+	if KeyLogin(target, opts) {
+		t.Fatal("expected an empty agent to fail")
+	}
+}
@@ -0,0 +1,201 @@
+package sshx
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// AlgorithmKind names one of the algorithm lists negotiated with the server.
+type AlgorithmKind string
+
+const (
+	AlgCiphers      AlgorithmKind = "ciphers"
+	AlgKeyExchanges AlgorithmKind = "key exchanges"
+	AlgMACs         AlgorithmKind = "MACs"
+	AlgHostKeys     AlgorithmKind = "host key algorithms"
+)
+
+// algorithmSet is what golang.org/x/crypto/ssh implements for a kind, in its
+// order of preference, and what it offers when left alone.
+type algorithmSet struct {
+	supported []string
+	defaults  []string
+	modern    []string
+}
+
+var hostKeyAlgorithms = []string{
+	ssh.CertAlgoRSASHA256v01, ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSAv01, ssh.CertAlgoDSAv01,
+	ssh.CertAlgoECDSA256v01, ssh.CertAlgoECDSA384v01, ssh.CertAlgoECDSA521v01, ssh.CertAlgoED25519v01,
+	ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+	ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSA, ssh.KeyAlgoDSA,
+	ssh.KeyAlgoED25519,
+}
+
+// algorithms mirrors the lists in x/crypto/ssh, which does not export them.
+var algorithms = map[AlgorithmKind]algorithmSet{
+	AlgCiphers: {
+		supported: []string{
+			"aes128-gcm@openssh.com", "aes256-gcm@openssh.com", "chacha20-poly1305@openssh.com",
+			"aes128-ctr", "aes192-ctr", "aes256-ctr",
+			"aes128-cbc", "3des-cbc", "arcfour256", "arcfour128", "arcfour",
+		},
+		defaults: []string{
+			"aes128-gcm@openssh.com", "aes256-gcm@openssh.com", "chacha20-poly1305@openssh.com",
+			"aes128-ctr", "aes192-ctr", "aes256-ctr",
+		},
+		modern: []string{"chacha20-poly1305@openssh.com", "aes256-gcm@openssh.com", "aes128-gcm@openssh.com"},
+	},
+	AlgKeyExchanges: {
+		supported: []string{
+			"curve25519-sha256", "curve25519-sha256@libssh.org",
+			"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+			"diffie-hellman-group14-sha256", "diffie-hellman-group16-sha512",
+			"diffie-hellman-group-exchange-sha256", "diffie-hellman-group14-sha1",
+			"diffie-hellman-group-exchange-sha1", "diffie-hellman-group1-sha1",
+		},
+		defaults: []string{
+			"curve25519-sha256", "curve25519-sha256@libssh.org",
+			"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+			"diffie-hellman-group14-sha256", "diffie-hellman-group14-sha1",
+		},
+		modern: []string{"curve25519-sha256", "curve25519-sha256@libssh.org"},
+	},
+	AlgMACs: {
+		supported: []string{
+			"hmac-sha2-256-etm@openssh.com", "hmac-sha2-512-etm@openssh.com",
+			"hmac-sha2-256", "hmac-sha2-512", "hmac-sha1", "hmac-sha1-96",
+		},
+		defaults: []string{
+			"hmac-sha2-256-etm@openssh.com", "hmac-sha2-512-etm@openssh.com",
+			"hmac-sha2-256", "hmac-sha2-512", "hmac-sha1", "hmac-sha1-96",
+		},
+		modern: []string{"hmac-sha2-256-etm@openssh.com", "hmac-sha2-512-etm@openssh.com"},
+	},
+	AlgHostKeys: {
+		// x/crypto offers every host key algorithm it supports.
+		supported: hostKeyAlgorithms,
+		defaults:  hostKeyAlgorithms,
+		modern: []string{
+			ssh.KeyAlgoED25519, ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+			ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256,
+		},
+	},
+}
+
+// SupportedAlgorithms lists the algorithms of kind beammeup can use, in its
+// order of preference.
+func SupportedAlgorithms(kind AlgorithmKind) []string {
+	return slices.Clone(algorithms[kind].supported)
+}
+
+// ParseAlgorithms reads spec the way OpenSSH reads Ciphers, KexAlgorithms,
+// MACs and HostKeyAlgorithms: a comma-separated list replaces the defaults,
+// and one starting with "+" appends to them, "-" removes from them and "^"
+// puts its algorithms first. Names may hold * and ? wildcards. "modern" is
+// a short list of current algorithms only. Names beammeup does not support
+// are returned in unknown; an empty spec gives the defaults.
+func ParseAlgorithms(kind AlgorithmKind, spec string) (list, unknown []string) {
+	set := algorithms[kind]
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return slices.Clone(set.defaults), nil
+	}
+	if strings.EqualFold(spec, "modern") {
+		return slices.Clone(set.modern), nil
+	}
+	op := spec[0]
+	if op == '+' || op == '-' || op == '^' {
+		spec = spec[1:]
+	}
+	var named []string
+	for _, pattern := range strings.Split(spec, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		n := len(named)
+		for _, alg := range set.supported {
+			if wildcardMatch(pattern, alg) && !slices.Contains(named, alg) {
+				named = append(named, alg)
+			}
+		}
+		if len(named) == n {
+			unknown = append(unknown, pattern)
+		}
+	}
+
+	switch op {
+	case '+':
+		list = slices.Clone(set.defaults)
+		for _, alg := range named {
+			if !slices.Contains(list, alg) {
+				list = append(list, alg)
+			}
+		}
+	case '-':
+		for _, alg := range set.defaults {
+			if !slices.Contains(named, alg) {
+				list = append(list, alg)
+			}
+		}
+	case '^':
+		list = named
+		for _, alg := range set.defaults {
+			if !slices.Contains(list, alg) {
+				list = append(list, alg)
+			}
+		}
+	default:
+		list = named
+	}
+	return list, unknown
+}
+
+// CheckAlgorithms reports what is wrong with spec as a list of kind.
+func CheckAlgorithms(kind AlgorithmKind, spec string) error {
+	list, unknown := ParseAlgorithms(kind, spec)
+	if len(unknown) > 0 {
+		return fmt.Errorf("unsupported %s: %s (supported: %s)", kind, strings.Join(unknown, ", "), strings.Join(algorithms[kind].supported, ","))
+	}
+	if len(list) == 0 {
+		return fmt.Errorf("no %s left to offer", kind)
+	}
+	return nil
+}
+
+// algorithmSpecs returns the lists to offer host, from opts where set and
+// otherwise from the host's settings in SSHConfigPath. Names in the config
+// that beammeup does not support are skipped, since the config is written
+// for OpenSSH; an unknown name given in opts is an error.
+func (opts ConnectOptions) algorithmSpecs(host string) (map[AlgorithmKind][]string, error) {
+	var hc hostConfig
+	if path := strings.TrimSpace(opts.SSHConfigPath); path != "" && host != "" {
+		hc, _ = readSSHConfig(path, host)
+	}
+	given := map[AlgorithmKind][2]string{
+		AlgCiphers:      {opts.Ciphers, hc.Ciphers},
+		AlgKeyExchanges: {opts.KeyExchanges, hc.KexAlgorithms},
+		AlgMACs:         {opts.MACs, hc.MACs},
+		AlgHostKeys:     {opts.HostKeyAlgorithms, hc.HostKeyAlgorithms},
+	}
+	lists := map[AlgorithmKind][]string{}
+	for kind, specs := range given {
+		if specs[0] == "" && specs[1] == "" {
+			continue
+		}
+		if specs[0] != "" {
+			if err := CheckAlgorithms(kind, specs[0]); err != nil {
+				return nil, err
+			}
+			lists[kind], _ = ParseAlgorithms(kind, specs[0])
+			continue
+		}
+		if list, _ := ParseAlgorithms(kind, specs[1]); len(list) > 0 {
+			lists[kind] = list
+		}
+	}
+	return lists, nil
+}
@@ -0,0 +1,91 @@
+package sshx
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseAlgorithms(t *testing.T) {
+	defaults := algorithms[AlgKeyExchanges].defaults
+	cases := []struct {
+		spec  string
+		want  []string
+		wrong []string
+	}{
+		{"", defaults, nil},
+		{"modern", []string{"curve25519-sha256", "curve25519-sha256@libssh.org"}, nil},
+		{"diffie-hellman-group1-sha1, ecdh-sha2-nistp256", []string{"diffie-hellman-group1-sha1", "ecdh-sha2-nistp256"}, nil},
+		{"+diffie-hellman-group1-sha1", append(slices.Clone(defaults), "diffie-hellman-group1-sha1"), nil},
+		{"-ecdh-*,diffie-hellman-*", []string{"curve25519-sha256", "curve25519-sha256@libssh.org"}, nil},
+		{"^diffie-hellman-group14-sha1", append([]string{"diffie-hellman-group14-sha1"}, slices.DeleteFunc(slices.Clone(defaults), func(s string) bool { return s == "diffie-hellman-group14-sha1" })...), nil},
+		{"curve25519-sha256,sntrup761x25519-sha512@openssh.com", []string{"curve25519-sha256"}, []string{"sntrup761x25519-sha512@openssh.com"}},
+	}
+	for _, c := range cases {
+		got, unknown := ParseAlgorithms(AlgKeyExchanges, c.spec)
+		if !slices.Equal(got, c.want) || !slices.Equal(unknown, c.wrong) {
+			t.Fatalf("%q: got %v, unknown %v", c.spec, got, unknown)
+		}
+	}
+
+	if err := CheckAlgorithms(AlgCiphers, "+aes128-cbc"); err != nil {
+		t.Fatalf("+aes128-cbc: %v", err)
+	}
+	if err := CheckAlgorithms(AlgCiphers, "blowfish-cbc"); err == nil || !strings.Contains(err.Error(), "blowfish-cbc") {
+		t.Fatalf("blowfish-cbc: %v", err)
+	}
+	if err := CheckAlgorithms(AlgMACs, "-hmac-*"); err == nil {
+		t.Fatal("removing every MAC was accepted")
+	}
+}
+
+func TestAlgorithmsFromSSHConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	config := "Host old-router\n  KexAlgorithms +diffie-hellman-group1-sha1\n  Ciphers aes128-cbc,blowfish-cbc\n"
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	lists, err := ConnectOptions{SSHConfigPath: path}.algorithmSpecs("old-router")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(lists[AlgKeyExchanges], "diffie-hellman-group1-sha1") {
+		t.Fatalf("key exchanges: %v", lists[AlgKeyExchanges])
+	}
+	// blowfish is OpenSSH's business; the rest of the line still counts.
+	if !slices.Equal(lists[AlgCiphers], []string{"aes128-cbc"}) {
+		t.Fatalf("ciphers: %v", lists[AlgCiphers])
+	}
+	if _, ok := lists[AlgMACs]; ok {
+		t.Fatalf("MACs set without being asked for: %v", lists[AlgMACs])
+	}
+
+	lists, err = ConnectOptions{SSHConfigPath: path, KeyExchanges: "modern"}.algorithmSpecs("old-router")
+	if err != nil || slices.Contains(lists[AlgKeyExchanges], "diffie-hellman-group1-sha1") {
+		t.Fatalf("flag did not win over the config: %v %v", lists[AlgKeyExchanges], err)
+	}
+	if _, err := (ConnectOptions{Ciphers: "blowfish-cbc"}).algorithmSpecs("old-router"); err == nil {
+		t.Fatal("unknown cipher from options was accepted")
+	}
+}
+
+func TestNoCommonAlgorithm(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	target := Target{Host: "127.0.0.1", Port: otpServer(t), User: "root", Password: "secret"}
+
+	// The test server only offers x/crypto's defaults, so group1 alone fails
+	// and the error says how to widen the list.
+	_, err := ConnectWithOptions(target, ConnectOptions{HostKeyMode: HostKeyInsecureIgnore, OTPCommand: "echo 123456", KeyExchanges: "diffie-hellman-group1-sha1"})
+	if err == nil || !strings.Contains(err.Error(), "--ssh-kex") {
+		t.Fatalf("group1 only: %v", err)
+	}
+
+	c, err := ConnectWithOptions(target, ConnectOptions{HostKeyMode: HostKeyInsecureIgnore, OTPCommand: "echo 123456", Ciphers: "modern", KeyExchanges: "modern", MACs: "modern", HostKeyAlgorithms: "modern"})
+	if err != nil {
+		t.Fatalf("modern only: %v", err)
+	}
+	c.Close()
+}
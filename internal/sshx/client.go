@@ -1,12 +1,18 @@
 package sshx
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/sftp"
@@ -19,13 +25,18 @@ type Target struct {
 	Port     int
 	User     string
 	Password string
+
+	// IdentityFiles are private keys offered before the ssh-agent's, usually
+	// filled in from ~/.ssh/config by Resolve.
+	IdentityFiles []string
 }
 
 type HostKeyMode int
 
 const (
 	// HostKeyAcceptNew trusts the first-seen host key (TOFU) and records it in
-	// the known_hosts file. Subsequent changes are treated as errors.
+	// the known_hosts file, once ConfirmHostKey (if set) agrees. Subsequent
+	// changes are treated as errors.
 	HostKeyAcceptNew HostKeyMode = iota
 	// HostKeyStrict requires the host key to already exist in the known_hosts
 	// file.
@@ -37,10 +48,81 @@ const (
 type ConnectOptions struct {
 	KnownHostsPath string
 	HostKeyMode    HostKeyMode
+
+	// SSHConfigPath is the OpenSSH client config whose Host aliases are
+	// honoured (see Resolve). Empty ignores it.
+	SSHConfigPath string
+
+	// HostKeyChanged, when set, is told about a connection refused because
+	// the server's host key no longer matches known_hosts. t is the target
+	// as given, before Resolve.
+	HostKeyChanged func(t Target, e *HostKeyError)
+
+	// ConfirmHostKey, when set, is asked before a first-seen host key is
+	// trusted in HostKeyAcceptNew mode; an error refuses the key and the
+	// connection. Nil trusts it without asking.
+	ConfirmHostKey func(t Target, key ssh.PublicKey) error
+
+	// HashKnownHosts records new host keys under a hashed host name, like
+	// OpenSSH's HashKnownHosts, so known_hosts does not list the servers in
+	// plain text. "HashKnownHosts yes" for the host in SSHConfigPath does
+	// the same. Hashed entries are read either way.
+	HashKnownHosts bool
+
+	// OTPCommand is a shell command printing the answer to a
+	// keyboard-interactive question other than the password, such as a
+	// one-time code (see runOTPCommand). Without it Prompt is asked; with
+	// neither, servers that want more than the password refuse the login.
+	OTPCommand string
+	Prompt     func(t Target, instruction, question string, echo bool) (string, error)
+
+	// Retries is how many more times a connection that failed on the way to
+	// the server (refused, reset or timed out, as when the network blinks or
+	// sshd restarts) is tried. Failed logins and host key problems are not
+	// retried. RetryDelay is the first wait, doubled after each try.
+	Retries    int
+	RetryDelay time.Duration
+
+	// KeepAlive is how often the connection asks the server for a sign of
+	// life, like OpenSSH's ServerAliveInterval, so NAT and firewall state
+	// does not time out during quiet steps such as a long apt install.
+	// After KeepAliveMax requests in a row go unanswered the connection is
+	// closed, and a dead link fails instead of hanging. Zero disables it.
+	KeepAlive    time.Duration
+	KeepAliveMax int
+
+	// Ciphers, KeyExchanges, MACs and HostKeyAlgorithms choose what is
+	// offered to the server, in OpenSSH's list syntax (see ParseAlgorithms):
+	// "+diffie-hellman-group1-sha1" for an old Dropbear, "modern" to refuse
+	// anything dated. Empty takes the host's Ciphers, KexAlgorithms, MACs
+	// and HostKeyAlgorithms from SSHConfigPath, or else the defaults.
+	Ciphers           string
+	KeyExchanges      string
+	MACs              string
+	HostKeyAlgorithms string
+
+	// Proxy, when set, is where the SSH connection itself goes through
+	// (see ParseProxy): a SOCKS5 server such as Tor's, or an HTTP proxy
+	// that allows CONNECT. BEAMMEUP_SSH_PROXY sets it.
+	Proxy string
 }
 
+// Retry and keepalive defaults; BEAMMEUP_SSH_RETRIES,
+// BEAMMEUP_SSH_RETRY_DELAY and BEAMMEUP_SSH_KEEPALIVE change them.
+const (
+	DefaultRetries      = 2
+	DefaultRetryDelay   = 2 * time.Second
+	maxRetryDelay       = 30 * time.Second
+	DefaultKeepAlive    = 30 * time.Second
+	DefaultKeepAliveMax = 3
+)
+
 type Client struct {
 	sshClient *ssh.Client
+
+	stopKeepAlive context.CancelFunc // nil without keepalives
+	lost          atomic.Bool        // keepalives went unanswered and closed the connection
+	keepAliveMax  int
 }
 
 func DefaultConnectOptions() ConnectOptions {
@@ -52,20 +134,38 @@ func DefaultConnectOptions() ConnectOptions {
 		mode = HostKeyInsecureIgnore
 	}
 
-	if v := strings.TrimSpace(os.Getenv("BEAMMEUP_SSH_KNOWN_HOSTS")); v != "" {
-		return ConnectOptions{KnownHostsPath: v, HostKeyMode: mode}
+	opts := ConnectOptions{HostKeyMode: mode, Retries: DefaultRetries, RetryDelay: DefaultRetryDelay, KeepAlive: DefaultKeepAlive, KeepAliveMax: DefaultKeepAliveMax}
+	if n, err := strconv.Atoi(strings.TrimSpace(os.Getenv("BEAMMEUP_SSH_RETRIES"))); err == nil && n >= 0 {
+		opts.Retries = n
 	}
-
+	if d, err := time.ParseDuration(strings.TrimSpace(os.Getenv("BEAMMEUP_SSH_RETRY_DELAY"))); err == nil && d > 0 {
+		opts.RetryDelay = d
+	}
+	// BEAMMEUP_SSH_KEEPALIVE=0 turns keepalives off.
+	if d, err := time.ParseDuration(strings.TrimSpace(os.Getenv("BEAMMEUP_SSH_KEEPALIVE"))); err == nil && d >= 0 {
+		opts.KeepAlive = d
+	}
+	// If we can't resolve home dir, leave KnownHostsPath empty and let
+	// ConnectWithOptions return an explicit error.
 	home, err := os.UserHomeDir()
-	if err != nil {
-		// If we can't resolve home dir, leave KnownHostsPath empty and let
-		// ConnectWithOptions return an explicit error.
-		return ConnectOptions{HostKeyMode: mode}
+	if err == nil {
+		opts.KnownHostsPath = filepath.Join(home, ".beammeup", "known_hosts")
+		opts.SSHConfigPath = filepath.Join(home, ".ssh", "config")
 	}
-	return ConnectOptions{
-		KnownHostsPath: filepath.Join(home, ".beammeup", "known_hosts"),
-		HostKeyMode:    mode,
+	if v := strings.TrimSpace(os.Getenv("BEAMMEUP_SSH_KNOWN_HOSTS")); v != "" {
+		opts.KnownHostsPath = v
 	}
+	opts.OTPCommand = strings.TrimSpace(os.Getenv("BEAMMEUP_SSH_OTP_COMMAND"))
+	opts.HashKnownHosts = envTrue("BEAMMEUP_HASH_KNOWN_HOSTS")
+	opts.Proxy = strings.TrimSpace(os.Getenv("BEAMMEUP_SSH_PROXY"))
+	if v, ok := os.LookupEnv("BEAMMEUP_SSH_CONFIG"); ok {
+		// BEAMMEUP_SSH_CONFIG=none (or empty) ignores ~/.ssh/config.
+		opts.SSHConfigPath = strings.TrimSpace(v)
+		if strings.EqualFold(opts.SSHConfigPath, "none") {
+			opts.SSHConfigPath = ""
+		}
+	}
+	return opts
 }
 
 func envTrue(key string) bool {
@@ -78,17 +178,53 @@ func Connect(t Target) (*Client, error) {
 }
 
 func ConnectWithOptions(t Target, opts ConnectOptions) (*Client, error) {
+	return ConnectContext(context.Background(), t, opts)
+}
+
+// ConnectContext is ConnectWithOptions with ctx bounding the connection
+// attempt: dialing, the handshake and login, and the waits between retries.
+// Cancelling ctx once the client is returned does not close it.
+func ConnectContext(ctx context.Context, t Target, opts ConnectOptions) (*Client, error) {
+	given := t
+	t = opts.Resolve(t)
 	if t.Port == 0 {
 		t.Port = 22
 	}
 
 	addr := net.JoinHostPort(t.Host, fmt.Sprintf("%d", t.Port))
 
+	// A given password goes first, so a long list of agent keys cannot use
+	// up the server's MaxAuthTries before it; without one the keys log in
+	// alone.
+	var auth []ssh.AuthMethod
+	if t.Password != "" {
+		auth = append(auth, ssh.Password(t.Password))
+	}
+	keys, closeKeys := publicKeys(t.IdentityFiles)
+	defer closeKeys()
+	if keys != nil {
+		auth = append(auth, keys)
+	}
+	// Last, for servers that ask for a one-time code, often after the
+	// password or a key (partial success).
+	if ki := keyboardInteractive(t, opts); ki != nil {
+		auth = append(auth, ki)
+	}
+	if len(auth) == 0 {
+		return nil, ErrNoAuth
+	}
+
 	cfg := &ssh.ClientConfig{
 		User:    t.User,
-		Auth:    []ssh.AuthMethod{ssh.Password(t.Password)},
+		Auth:    auth,
 		Timeout: 20 * time.Second,
 	}
+	algs, err := opts.algorithmSpecs(given.Host)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Ciphers, cfg.KeyExchanges, cfg.MACs = algs[AlgCiphers], algs[AlgKeyExchanges], algs[AlgMACs]
+	cfg.HostKeyAlgorithms = algs[AlgHostKeys]
 
 	switch opts.HostKeyMode {
 	case HostKeyInsecureIgnore:
@@ -116,12 +252,21 @@ func ConnectWithOptions(t Target, opts ConnectOptions) (*Client, error) {
 					if !acceptNew {
 						return &HostKeyError{Addr: hostname, Fingerprint: fp, KnownHostsPath: khPath, Reason: "unknown"}
 					}
-					if err := appendKnownHost(khPath, hostname, key); err != nil {
+					if opts.ConfirmHostKey != nil {
+						if err := opts.ConfirmHostKey(given, key); err != nil {
+							return fmt.Errorf("new SSH host key for %s (fingerprint %s) not trusted: %w", hostname, fp, err)
+						}
+					}
+					if err := appendKnownHost(khPath, knownHostLine(hostname, key, opts.hashHosts(given.Host))); err != nil {
 						return fmt.Errorf("trust new host key: %w", err)
 					}
 					return nil
 				}
-				return &HostKeyError{Addr: hostname, Fingerprint: fp, KnownHostsPath: khPath, Reason: "mismatch"}
+				var known []string
+				for _, k := range ke.Want {
+					known = append(known, ssh.FingerprintSHA256(k.Key))
+				}
+				return &HostKeyError{Addr: hostname, Fingerprint: fp, Known: known, KnownHostsPath: khPath, Reason: "mismatch"}
 			}
 
 			// For revoked keys or other knownhosts parser errors, keep the original
@@ -130,16 +275,158 @@ func ConnectWithOptions(t Target, opts ConnectOptions) (*Client, error) {
 		}
 	}
 
-	c, err := ssh.Dial("tcp", addr, cfg)
+	c, err := dial(ctx, addr, cfg, opts)
 	if err != nil {
+		var hke *HostKeyError
+		if opts.HostKeyChanged != nil && errors.As(err, &hke) && hke.Reason == "mismatch" {
+			opts.HostKeyChanged(given, hke)
+		}
+		if strings.Contains(err.Error(), "no common algorithm") {
+			err = fmt.Errorf("%w\n(the server and beammeup share no algorithm of this kind; old servers may need one added, e.g. --ssh-kex +diffie-hellman-group1-sha1)", err)
+		}
 		return nil, err
 	}
-	return &Client{sshClient: c}, nil
+	client := &Client{sshClient: c}
+	if opts.KeepAlive > 0 {
+		client.startKeepAlive(opts.KeepAlive, max(opts.KeepAliveMax, 1))
+	}
+	return client, nil
+}
+
+// keepAliveRequest is the global request OpenSSH clients send. Servers
+// answer requests they do not know with a failure, which is an answer all
+// the same.
+const keepAliveRequest = "keepalive@openssh.com"
+
+// startKeepAlive sends a keepalive request every interval until the client
+// is closed. Once maxMissed intervals pass without the pending request
+// being answered, it closes the connection, which fails whatever is running
+// on it.
+func (c *Client) startKeepAlive(interval time.Duration, maxMissed int) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.stopKeepAlive, c.keepAliveMax = cancel, maxMissed
+	go func() {
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+		answered := make(chan error, 1)
+		waiting, missed := false, 0
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case err := <-answered:
+				if err != nil {
+					return // the connection is gone
+				}
+				waiting, missed = false, 0
+				continue
+			case <-tick.C:
+			}
+			if waiting {
+				if missed++; missed >= maxMissed {
+					c.lost.Store(true)
+					c.sshClient.Close()
+					return
+				}
+				continue
+			}
+			waiting = true
+			go func() {
+				_, _, err := c.sshClient.SendRequest(keepAliveRequest, true, nil)
+				answered <- err
+			}()
+		}
+	}()
+}
+
+// explain names unanswered keepalives as the cause of err when they closed
+// the connection.
+func (c *Client) explain(err error) error {
+	if err != nil && c.lost.Load() {
+		return fmt.Errorf("the server stopped answering (%d SSH keepalives in a row went unanswered): %w", c.keepAliveMax, err)
+	}
+	return err
+}
+
+// dial connects to addr, trying again after a failure on the way there
+// (see transientDialError) up to opts.Retries times, with a wait that starts
+// at opts.RetryDelay and doubles each time.
+func dial(ctx context.Context, addr string, cfg *ssh.ClientConfig, opts ConnectOptions) (*ssh.Client, error) {
+	var proxy *url.URL
+	if opts.Proxy != "" {
+		var err error
+		if proxy, err = ParseProxy(opts.Proxy); err != nil {
+			return nil, err
+		}
+	}
+	delay := opts.RetryDelay
+	if delay <= 0 {
+		delay = DefaultRetryDelay
+	}
+	for attempt := 1; ; attempt++ {
+		c, err := dialOnce(ctx, addr, cfg, proxy)
+		if err == nil || !transientDialError(err) {
+			return c, err
+		}
+		if attempt > opts.Retries {
+			if attempt == 1 {
+				return nil, err
+			}
+			return nil, fmt.Errorf("could not reach %s after %d attempts; the server may be down, rebooting, or unreachable from here: %w", addr, attempt, err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxRetryDelay)
+	}
+}
+
+// dialOnce is ssh.Dial with ctx able to abort the TCP connect and the
+// handshake, going through proxy when it is set.
+func dialOnce(ctx context.Context, addr string, cfg *ssh.ClientConfig, proxy *url.URL) (*ssh.Client, error) {
+	conn, err := dialVia(ctx, proxy, addr, cfg.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	sc, chans, reqs, err := ssh.NewClientConn(conn, addr, cfg)
+	if !stop() {
+		if err == nil {
+			sc.Close()
+		}
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ssh.NewClient(sc, chans, reqs), nil
+}
+
+// transientDialError reports whether err is worth another try: the
+// connection failed or dropped before the login was decided, rather than
+// the server refusing the login or its host key being wrong.
+func transientDialError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var hke *HostKeyError
+	if errors.As(err, &hke) {
+		return false
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
 }
 
 type HostKeyError struct {
 	Addr           string
-	Fingerprint    string
+	Fingerprint    string   // the key the server presented
+	Known          []string // mismatch: the keys known_hosts expected
 	KnownHostsPath string
 	Reason         string // unknown|mismatch
 }
@@ -169,14 +456,12 @@ func ensureKnownHostsFile(path string) error {
 	return nil
 }
 
-func appendKnownHost(path, hostname string, key ssh.PublicKey) error {
-	line := knownhosts.Line([]string{hostname}, key) + "\n"
-
+func appendKnownHost(path, line string) error {
 	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o600)
 	if err != nil {
 		return err
 	}
-	_, werr := f.WriteString(line)
+	_, werr := f.WriteString(line + "\n")
 	cerr := f.Close()
 	if werr != nil {
 		return werr
@@ -191,17 +476,119 @@ func (c *Client) Close() error {
 	if c == nil || c.sshClient == nil {
 		return nil
 	}
+	if c.stopKeepAlive != nil {
+		c.stopKeepAlive()
+	}
 	return c.sshClient.Close()
 }
 
 func (c *Client) RunCombined(command string) (string, error) {
+	return c.RunCombinedContext(context.Background(), command)
+}
+
+// RunCombinedContext is RunCombined that gives up when ctx is done (see
+// watchSession).
+func (c *Client) RunCombinedContext(ctx context.Context, command string) (string, error) {
+	session, err := c.sshClient.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+	cancelled := watchSession(ctx, session)
+	out, err := session.CombinedOutput(command)
+	if cancelled() {
+		err = ctx.Err()
+	}
+	return string(out), c.explain(err)
+}
+
+// RunWithStdin runs command with stdin fed from input and returns its
+// combined output. The remote side sees EOF once input is consumed.
+func (c *Client) RunWithStdin(command string, input []byte) (string, error) {
+	return c.RunWithStdinContext(context.Background(), command, input)
+}
+
+// RunWithStdinContext is RunWithStdin that gives up when ctx is done.
+func (c *Client) RunWithStdinContext(ctx context.Context, command string, input []byte) (string, error) {
 	session, err := c.sshClient.NewSession()
 	if err != nil {
 		return "", err
 	}
 	defer session.Close()
+	session.Stdin = bytes.NewReader(input)
+	cancelled := watchSession(ctx, session)
 	out, err := session.CombinedOutput(command)
-	return string(out), err
+	if cancelled() {
+		err = ctx.Err()
+	}
+	return string(out), c.explain(err)
+}
+
+// watchSession kills the remote command and closes session once ctx is
+// done, so a call blocked on a hung server returns. sshd may not pass the
+// signal on; closing the channel still hangs up on the command. The
+// returned function stops watching and reports whether ctx ended the
+// session.
+func watchSession(ctx context.Context, session *ssh.Session) func() bool {
+	stop := context.AfterFunc(ctx, func() {
+		_ = session.Signal(ssh.SIGKILL)
+		_ = session.Close()
+	})
+	return func() bool { return !stop() }
+}
+
+// Stream runs command with its output sent to stdout and stderr as it
+// arrives and returns the remote exit status. Like ssh(1), a command that
+// died without one (e.g. killed by a signal) reports 255.
+func (c *Client) Stream(command string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	return c.StreamContext(context.Background(), command, stdin, stdout, stderr)
+}
+
+// StreamContext is Stream that gives up when ctx is done, returning 255 and
+// ctx's error.
+func (c *Client) StreamContext(ctx context.Context, command string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	session, err := c.sshClient.NewSession()
+	if err != nil {
+		return 255, err
+	}
+	defer session.Close()
+	session.Stdout, session.Stderr = stdout, stderr
+	if stdin != nil {
+		// Session.Wait would block on an interactive stdin that never hits
+		// EOF, so feed it from a goroutine the session does not wait for.
+		pipe, err := session.StdinPipe()
+		if err != nil {
+			return 255, err
+		}
+		go func() {
+			_, _ = io.Copy(pipe, stdin)
+			_ = pipe.Close()
+		}()
+	}
+
+	cancelled := watchSession(ctx, session)
+	err = session.Run(command)
+	if cancelled() {
+		return 255, ctx.Err()
+	}
+	if err != nil && c.lost.Load() {
+		return 255, c.explain(err)
+	}
+	var exitErr *ssh.ExitError
+	var missingErr *ssh.ExitMissingError
+	switch {
+	case err == nil:
+		return 0, nil
+	case errors.As(err, &exitErr):
+		if exitErr.Signal() != "" {
+			return 255, nil
+		}
+		return exitErr.ExitStatus(), nil
+	case errors.As(err, &missingErr):
+		return 255, nil
+	default:
+		return 255, err
+	}
 }
 
 func (c *Client) Dial(network, addr string) (net.Conn, error) {
@@ -211,12 +598,40 @@ func (c *Client) Dial(network, addr string) (net.Conn, error) {
 	return c.sshClient.Dial(network, addr)
 }
 
+// Listen asks the server to listen on addr and forward the connections it
+// accepts over SSH. sshd only binds other than loopback when its
+// GatewayPorts setting allows it.
+func (c *Client) Listen(network, addr string) (net.Listener, error) {
+	if c == nil || c.sshClient == nil {
+		return nil, errors.New("ssh client not connected")
+	}
+	return c.sshClient.Listen(network, addr)
+}
+
+// Upload writes content to remotePath with the given mode. Hardened sshd
+// configs often disable the SFTP subsystem, so when it is unavailable the
+// file is streamed through `cat` on an exec channel instead.
 func (c *Client) Upload(content []byte, remotePath string, mode os.FileMode) error {
+	return c.UploadContext(context.Background(), content, remotePath, mode)
+}
+
+// UploadContext is Upload that gives up when ctx is done. The file may be
+// left half written.
+func (c *Client) UploadContext(ctx context.Context, content []byte, remotePath string, mode os.FileMode) (err error) {
 	sftpClient, err := sftp.NewClient(c.sshClient)
 	if err != nil {
-		return err
+		if fallbackErr := c.uploadViaExec(ctx, content, remotePath, mode); fallbackErr != nil {
+			return fmt.Errorf("sftp unavailable (%v); exec upload failed: %w", err, fallbackErr)
+		}
+		return nil
 	}
 	defer sftpClient.Close()
+	stop := context.AfterFunc(ctx, func() { sftpClient.Close() })
+	defer func() {
+		if !stop() {
+			err = ctx.Err()
+		}
+	}()
 
 	f, err := sftpClient.Create(remotePath)
 	if err != nil {
@@ -232,3 +647,49 @@ func (c *Client) Upload(content []byte, remotePath string, mode os.FileMode) err
 	}
 	return f.Close()
 }
+
+// Download copies remotePath into w, over SFTP when available and through
+// `cat` on an exec channel otherwise.
+func (c *Client) Download(remotePath string, w io.Writer) error {
+	sftpClient, err := sftp.NewClient(c.sshClient)
+	if err != nil {
+		var stderr bytes.Buffer
+		status, runErr := c.Stream("cat -- "+ShellQuote(remotePath), nil, w, &stderr)
+		if runErr == nil && status != 0 {
+			runErr = fmt.Errorf("exit status %d: %s", status, strings.TrimSpace(stderr.String()))
+		}
+		if runErr != nil {
+			return fmt.Errorf("sftp unavailable (%v); exec download failed: %w", err, runErr)
+		}
+		return nil
+	}
+	defer sftpClient.Close()
+
+	f, err := sftpClient.Open(remotePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// uploadViaExec writes content with a plain shell pipeline. The umask keeps
+// the file private until chmod applies the requested mode.
+func (c *Client) uploadViaExec(ctx context.Context, content []byte, remotePath string, mode os.FileMode) error {
+	path := ShellQuote(remotePath)
+	command := fmt.Sprintf("umask 077 && cat > %s && chmod %o %s", path, mode.Perm(), path)
+	out, err := c.RunWithStdinContext(ctx, command, content)
+	if err != nil {
+		if msg := strings.TrimSpace(out); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// ShellQuote quotes s as a single POSIX shell word.
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
@@ -0,0 +1,127 @@
+package sshx
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestDialRetries(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	target := Target{Host: "127.0.0.1", Port: port, User: "root", Password: "secret"}
+	opts := ConnectOptions{HostKeyMode: HostKeyInsecureIgnore, Retries: 2, RetryDelay: time.Millisecond}
+	_, err = ConnectWithOptions(target, opts)
+	if err == nil || !strings.Contains(err.Error(), "after 3 attempts") {
+		t.Fatalf("refused port: %v", err)
+	}
+
+	opts.Retries = 0
+	_, err = ConnectWithOptions(target, opts)
+	if err == nil || strings.Contains(err.Error(), "attempts") {
+		t.Fatalf("no retries: %v", err)
+	}
+
+	// A refused login is an answer, not a blip.
+	target.Port = otpServer(t)
+	opts = ConnectOptions{HostKeyMode: HostKeyInsecureIgnore, OTPCommand: "echo 000000", Retries: 2, RetryDelay: time.Millisecond}
+	_, err = ConnectWithOptions(target, opts)
+	if err == nil || strings.Contains(err.Error(), "attempts") {
+		t.Fatalf("wrong code: %v", err)
+	}
+}
+
+func TestConnectContext(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	// A server that takes the connection and then never says a word, like
+	// a VPS hung halfway through booting.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	target := Target{Host: "127.0.0.1", Port: ln.Addr().(*net.TCPAddr).Port, User: "root", Password: "secret"}
+	start := time.Now()
+	_, err = ConnectContext(ctx, target, ConnectOptions{HostKeyMode: HostKeyInsecureIgnore, Retries: 3})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("hung server: %v", err)
+	}
+	if waited := time.Since(start); waited > 5*time.Second {
+		t.Fatalf("gave up after %s", waited)
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	opts := ConnectOptions{HostKeyMode: HostKeyInsecureIgnore, OTPCommand: "echo 123456", KeepAlive: 20 * time.Millisecond, KeepAliveMax: 2}
+	c, err := ConnectWithOptions(Target{Host: "127.0.0.1", Port: otpServer(t), User: "root", Password: "secret"}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	time.Sleep(200 * time.Millisecond)
+	if c.lost.Load() {
+		t.Fatal("a server answering keepalives was dropped")
+	}
+
+	// This one logs in and then ignores everything, requests included.
+	_, hostKey, _ := ed25519.GenerateKey(rand.Reader)
+	hostSigner, _ := ssh.NewSignerFromKey(hostKey)
+	cfg := &ssh.ServerConfig{NoClientAuth: true}
+	cfg.AddHostKey(hostSigner)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if sc, _, _, err := ssh.NewServerConn(conn, cfg); err == nil {
+			sc.Wait()
+		}
+	}()
+	c, err = ConnectWithOptions(Target{Host: "127.0.0.1", Port: ln.Addr().(*net.TCPAddr).Port, User: "root", Password: "secret"}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for !c.lost.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("unanswered keepalives did not drop the connection")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := c.RunCombined("true"); err == nil {
+		t.Fatal("expected the dropped connection to fail")
+	}
+}
@@ -0,0 +1,82 @@
+package sshx
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// otpCommandTimeout bounds how long an OTPCommand may take to print a code.
+const otpCommandTimeout = time.Minute
+
+// keyboardInteractive answers the server's keyboard-interactive questions,
+// as used for one-time codes and PAM password prompts: a hidden question
+// about the password gets t.Password, anything else goes to
+// opts.OTPCommand or, without one, opts.Prompt. It is nil when nothing could
+// answer.
+func keyboardInteractive(t Target, opts ConnectOptions) ssh.AuthMethod {
+	command := strings.TrimSpace(opts.OTPCommand)
+	if t.Password == "" && command == "" && opts.Prompt == nil {
+		return nil
+	}
+	return ssh.KeyboardInteractive(func(_, instruction string, questions []string, echos []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		for i, q := range questions {
+			switch {
+			case t.Password != "" && !echos[i] && strings.Contains(strings.ToLower(q), "password"):
+				answers[i] = t.Password
+			case command != "":
+				code, err := runOTPCommand(command, t, q)
+				if err != nil {
+					return nil, err
+				}
+				answers[i] = code
+			case opts.Prompt != nil:
+				answer, err := opts.Prompt(t, instruction, q, echos[i])
+				if err != nil {
+					return nil, err
+				}
+				answers[i] = answer
+			default:
+				return nil, fmt.Errorf("ssh server asks %q; pass --ssh-otp-command to answer it", strings.TrimSpace(q))
+			}
+		}
+		return answers, nil
+	})
+}
+
+// runOTPCommand runs command through the shell and returns the first line it
+// prints. The target and the question are in BEAMMEUP_SSH_HOST,
+// BEAMMEUP_SSH_USER and BEAMMEUP_SSH_PROMPT, for commands serving several
+// servers.
+func runOTPCommand(command string, t Target, question string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), otpCommandTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	cmd.Env = append(os.Environ(),
+		"BEAMMEUP_SSH_HOST="+t.Host,
+		"BEAMMEUP_SSH_USER="+t.User,
+		"BEAMMEUP_SSH_PROMPT="+strings.TrimSpace(question),
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("ssh otp command: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	code, _, _ := strings.Cut(string(out), "\n")
+	if code = strings.TrimSpace(code); code == "" {
+		return "", fmt.Errorf("ssh otp command printed nothing")
+	}
+	return code, nil
+}
@@ -0,0 +1,86 @@
+package sshx
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// otpServer accepts the password "secret" followed by the code "123456",
+// both asked over keyboard-interactive.
+func otpServer(t *testing.T) int {
+	_, hostKey, _ := ed25519.GenerateKey(rand.Reader)
+	hostSigner, _ := ssh.NewSignerFromKey(hostKey)
+	cfg := &ssh.ServerConfig{
+		KeyboardInteractiveCallback: func(_ ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			answers, err := client("", "Two-factor login", []string{"Password: ", "Verification code: "}, []bool{false, true})
+			if err != nil {
+				return nil, err
+			}
+			if len(answers) != 2 || answers[0] != "secret" || answers[1] != "123456" {
+				return nil, errors.New("wrong answers")
+			}
+			return nil, nil
+		},
+	}
+	cfg.AddHostKey(hostSigner)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if _, chans, reqs, err := ssh.NewServerConn(conn, cfg); err == nil {
+					go ssh.DiscardRequests(reqs)
+					for ch := range chans {
+						ch.Reject(ssh.Prohibited, "test")
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestKeyboardInteractive(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	target := Target{Host: "127.0.0.1", Port: otpServer(t), User: "root", Password: "secret"}
+
+	opts := ConnectOptions{HostKeyMode: HostKeyInsecureIgnore, OTPCommand: "echo 123456"}
+	c, err := ConnectWithOptions(target, opts)
+	if err != nil {
+		t.Fatalf("otp command login: %v", err)
+	}
+	c.Close()
+
+	var asked string
+	opts = ConnectOptions{HostKeyMode: HostKeyInsecureIgnore, Prompt: func(_ Target, _, question string, echo bool) (string, error) {
+		asked = question
+		return "123456", nil
+	}}
+	c, err = ConnectWithOptions(target, opts)
+	if err != nil {
+		t.Fatalf("prompted login: %v", err)
+	}
+	c.Close()
+	if asked != "Verification code: " {
+		t.Fatalf("prompt got %q", asked)
+	}
+
+	opts = ConnectOptions{HostKeyMode: HostKeyInsecureIgnore, OTPCommand: "echo 000000"}
+	if c, err := ConnectWithOptions(target, opts); err == nil {
+		c.Close()
+		t.Fatal("expected a wrong code to fail")
+	}
+}
@@ -0,0 +1,196 @@
+package sshx
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+var errScanned = errors.New("host key scanned")
+
+// ScanHostKey returns the host key t's server presents, without checking it
+// against known_hosts and without logging in.
+func ScanHostKey(t Target, opts ConnectOptions) (ssh.PublicKey, error) {
+	t = opts.Resolve(t)
+	if t.Port == 0 {
+		t.Port = 22
+	}
+	addr := net.JoinHostPort(t.Host, strconv.Itoa(t.Port))
+	conn, err := net.DialTimeout("tcp", addr, 20*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(20 * time.Second))
+
+	var key ssh.PublicKey
+	cfg := &ssh.ClientConfig{
+		User: t.User,
+		HostKeyCallback: func(_ string, _ net.Addr, k ssh.PublicKey) error {
+			key = k
+			return errScanned
+		},
+	}
+	if _, _, _, err := ssh.NewClientConn(conn, addr, cfg); key == nil {
+		return nil, fmt.Errorf("read host key of %s: %w", addr, err)
+	}
+	return key, nil
+}
+
+// TrustHostKey makes key the only trusted host key for t in known_hosts,
+// dropping whatever was recorded for the host before. Other hosts on the same
+// line, @cert-authority and @revoked lines are left alone.
+func TrustHostKey(t Target, opts ConnectOptions, key ssh.PublicKey) error {
+	path := strings.TrimSpace(opts.KnownHostsPath)
+	if path == "" {
+		return errors.New("ssh known_hosts path not set")
+	}
+	hash := opts.hashHosts(t.Host)
+	t = opts.Resolve(t)
+	if t.Port == 0 {
+		t.Port = 22
+	}
+	addr := net.JoinHostPort(t.Host, strconv.Itoa(t.Port))
+	host := knownhosts.Normalize(addr)
+
+	if err := ensureKnownHostsFile(path); err != nil {
+		return err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var out []string
+	for _, line := range strings.Split(strings.TrimRight(string(b), "\n"), "\n") {
+		if line, keep := dropKnownHost(line, host); keep {
+			out = append(out, line)
+		}
+	}
+	out = append(out, knownHostLine(addr, key, hash))
+	return writeKnownHosts(path, out)
+}
+
+// hashHosts reports whether new known_hosts entries for host (as given,
+// before Resolve) are hashed.
+func (opts ConnectOptions) hashHosts(host string) bool {
+	if opts.HashKnownHosts {
+		return true
+	}
+	path := strings.TrimSpace(opts.SSHConfigPath)
+	if path == "" || host == "" {
+		return false
+	}
+	hc, err := readSSHConfig(path, host)
+	return err == nil && hc.HashKnownHosts == "yes"
+}
+
+// knownHostLine renders the known_hosts line for addr (host:port), with the
+// host name hashed (|1|salt|hash) when hash is set.
+func knownHostLine(addr string, key ssh.PublicKey, hash bool) string {
+	if hash {
+		return knownhosts.HashHostname(knownhosts.Normalize(addr)) + " " + string(bytes.TrimSpace(ssh.MarshalAuthorizedKey(key)))
+	}
+	return knownhosts.Line([]string{addr}, key)
+}
+
+// HashKnownHostsFile replaces the plain host names in the known_hosts file
+// at path with hashed ones, like ssh-keygen -H, and returns how many it
+// hashed. A line naming several hosts becomes one line per host. Patterns,
+// @cert-authority and @revoked lines and comments are left alone.
+func HashKnownHostsFile(path string) (int, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	var out []string
+	hashed := 0
+	for _, line := range strings.Split(strings.TrimRight(string(b), "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		hosts, rest, ok := strings.Cut(trimmed, " ")
+		if !ok || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "@") || strings.ContainsAny(hosts, "*?!") {
+			out = append(out, line)
+			continue
+		}
+		for _, h := range strings.Split(hosts, ",") {
+			if !strings.HasPrefix(h, "|1|") {
+				h = knownhosts.HashHostname(h)
+				hashed++
+			}
+			out = append(out, h+" "+rest)
+		}
+	}
+	if hashed == 0 {
+		return 0, nil
+	}
+	return hashed, writeKnownHosts(path, out)
+}
+
+// writeKnownHosts replaces the known_hosts file at path with lines.
+func writeKnownHosts(path string, lines []string) error {
+	content := strings.TrimLeft(strings.Join(lines, "\n"), "\n") + "\n"
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(content), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// dropKnownHost removes host from a known_hosts line; keep is false when no
+// other host is left on it.
+func dropKnownHost(line, host string) (string, bool) {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "@") {
+		return line, true
+	}
+	hosts, rest, ok := strings.Cut(trimmed, " ")
+	if !ok {
+		return line, true
+	}
+	var left []string
+	for _, h := range strings.Split(hosts, ",") {
+		if !knownHostMatches(h, host) {
+			left = append(left, h)
+		}
+	}
+	switch {
+	case len(left) == 0:
+		return "", false
+	case len(left) == len(strings.Split(hosts, ",")):
+		return line, true
+	}
+	return strings.Join(left, ",") + " " + rest, true
+}
+
+// knownHostMatches reports whether a known_hosts host entry, plain or hashed
+// (|1|salt|hash), names host.
+func knownHostMatches(entry, host string) bool {
+	if !strings.HasPrefix(entry, "|1|") {
+		return strings.EqualFold(entry, host)
+	}
+	parts := strings.Split(entry, "|")
+	if len(parts) != 4 {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(host))
+	return hmac.Equal(mac.Sum(nil), want)
+}
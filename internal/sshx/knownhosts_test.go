@@ -0,0 +1,158 @@
+package sshx
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestTrustHostKey(t *testing.T) {
+	salt := []byte("0123456789abcdefghij")
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte("[192.0.2.10]:2222"))
+	hashed := "|1|" + base64.StdEncoding.EncodeToString(salt) + "|" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	newKey := func() ssh.PublicKey {
+		_, priv, _ := ed25519.GenerateKey(rand.Reader)
+		k, _ := ssh.NewPublicKey(priv.Public())
+		return k
+	}
+	old, other, key := newKey(), newKey(), newKey()
+	blob := func(k ssh.PublicKey) string { return base64.StdEncoding.EncodeToString(k.Marshal()) }
+
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	before := strings.Join([]string{
+		"# managed by hand",
+		"[192.0.2.10]:2222,198.51.100.7 ssh-ed25519 " + blob(other),
+		hashed + " ssh-ed25519 " + blob(old),
+		"192.0.2.10 ssh-ed25519 " + blob(other),
+		"@cert-authority *.example.com ssh-ed25519 " + blob(other),
+	}, "\n") + "\n"
+	if err := os.WriteFile(path, []byte(before), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	target := Target{Host: "192.0.2.10", Port: 2222}
+	if err := TrustHostKey(target, ConnectOptions{KnownHostsPath: path}, key); err != nil {
+		t.Fatalf("TrustHostKey: %v", err)
+	}
+
+	b, _ := os.ReadFile(path)
+	got := string(b)
+	for _, gone := range []string{"[192.0.2.10]:2222,", blob(old)} {
+		if strings.Contains(got, gone) {
+			t.Fatalf("%s left in known_hosts:\n%s", gone, got)
+		}
+	}
+	for _, kept := range []string{"# managed by hand", "\n198.51.100.7 ssh-ed25519 ", "\n192.0.2.10 ssh-ed25519 ", "@cert-authority"} {
+		if !strings.Contains(got, kept) {
+			t.Fatalf("%q dropped from known_hosts:\n%s", kept, got)
+		}
+	}
+	cb, err := knownhosts.New(path)
+	if err != nil {
+		t.Fatalf("knownhosts.New: %v", err)
+	}
+	if err := cb("192.0.2.10:2222", &net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 2222}, key); err != nil {
+		t.Fatalf("trusted key rejected: %v", err)
+	}
+}
+
+func TestConfirmHostKey(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	target := Target{Host: "127.0.0.1", Port: otpServer(t), User: "root", Password: "secret"}
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	asked := 0
+	refuse := func(Target, ssh.PublicKey) error {
+		asked++
+		return errors.New("declined")
+	}
+	opts := ConnectOptions{KnownHostsPath: path, OTPCommand: "echo 123456", ConfirmHostKey: refuse}
+
+	if c, err := ConnectWithOptions(target, opts); err == nil {
+		c.Close()
+		t.Fatal("expected a declined host key to fail")
+	}
+	if b, _ := os.ReadFile(path); len(b) != 0 || asked != 1 {
+		t.Fatalf("declined key was recorded (asked %d): %q", asked, b)
+	}
+
+	opts.ConfirmHostKey = func(Target, ssh.PublicKey) error { return nil }
+	c, err := ConnectWithOptions(target, opts)
+	if err != nil {
+		t.Fatalf("confirmed key: %v", err)
+	}
+	c.Close()
+
+	// Known now, so nobody is asked again.
+	opts.ConfirmHostKey = refuse
+	c, err = ConnectWithOptions(target, opts)
+	if err != nil || asked != 1 {
+		t.Fatalf("known key: %v (asked %d)", err, asked)
+	}
+	c.Close()
+}
+
+func TestHashKnownHosts(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	target := Target{Host: "127.0.0.1", Port: otpServer(t), User: "root", Password: "secret"}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "known_hosts")
+	config := filepath.Join(dir, "config")
+	if err := os.WriteFile(config, []byte("Host 127.0.0.1\n  HashKnownHosts yes\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	opts := ConnectOptions{KnownHostsPath: path, SSHConfigPath: config, OTPCommand: "echo 123456"}
+
+	c, err := ConnectWithOptions(target, opts)
+	if err != nil {
+		t.Fatalf("first connect: %v", err)
+	}
+	c.Close()
+	b, _ := os.ReadFile(path)
+	if !strings.HasPrefix(string(b), "|1|") || strings.Contains(string(b), "127.0.0.1") {
+		t.Fatalf("expected a hashed entry, got %q", b)
+	}
+	// The hashed entry is what the server is checked against now.
+	opts.HostKeyMode = HostKeyStrict
+	if c, err = ConnectWithOptions(target, opts); err != nil {
+		t.Fatalf("hashed entry not honoured: %v", err)
+	}
+	c.Close()
+
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	key, _ := ssh.NewPublicKey(priv.Public())
+	blob := base64.StdEncoding.EncodeToString(key.Marshal())
+	plain := "# mine\n[192.0.2.10]:2222,198.51.100.7 ssh-ed25519 " + blob + "\n*.example.com ssh-ed25519 " + blob + "\n"
+	if err := os.WriteFile(path, []byte(plain), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	n, err := HashKnownHostsFile(path)
+	if err != nil || n != 2 {
+		t.Fatalf("HashKnownHostsFile = %d, %v", n, err)
+	}
+	b, _ = os.ReadFile(path)
+	if got := string(b); strings.Contains(got, "192.0.2.10") || strings.Contains(got, "198.51.100.7") || !strings.Contains(got, "# mine\n") || !strings.Contains(got, "*.example.com ") {
+		t.Fatalf("unexpected known_hosts after hashing:\n%s", got)
+	}
+	cb, err := knownhosts.New(path)
+	if err != nil {
+		t.Fatalf("knownhosts.New: %v", err)
+	}
+	if err := cb("198.51.100.7:22", &net.TCPAddr{IP: net.ParseIP("198.51.100.7"), Port: 22}, key); err != nil {
+		t.Fatalf("hashed key rejected: %v", err)
+	}
+	if n, err := HashKnownHostsFile(path); err != nil || n != 0 {
+		t.Fatalf("second pass hashed %d entries (%v), want none", n, err)
+	}
+}
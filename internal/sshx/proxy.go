@@ -0,0 +1,221 @@
+package sshx
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseProxy reads a ConnectOptions.Proxy value: socks5://[user:pass@]host:port
+// or http://[user:pass@]host:port. socks5h:// is accepted as socks5://,
+// which already leaves name resolution to the proxy.
+func ParseProxy(spec string) (*url.URL, error) {
+	// url.Parse errors quote the whole URL, password included.
+	u, err := url.Parse(strings.TrimSpace(spec))
+	if err != nil {
+		return nil, errors.New("invalid SSH proxy URL (use socks5://host:port or http://host:port)")
+	}
+	switch u.Scheme = strings.ToLower(u.Scheme); u.Scheme {
+	case "socks5", "socks5h", "http":
+	default:
+		return nil, fmt.Errorf("unsupported SSH proxy scheme %q (use socks5:// or http://)", u.Scheme)
+	}
+	if u.Hostname() == "" || u.Port() == "" {
+		return nil, fmt.Errorf("SSH proxy %s needs a host and a port, e.g. socks5://127.0.0.1:9050", u.Redacted())
+	}
+	if p, err := strconv.Atoi(u.Port()); err != nil || p < 1 || p > 65535 {
+		return nil, fmt.Errorf("SSH proxy %s has an invalid port", u.Redacted())
+	}
+	if user := u.User.Username(); len(user) > 255 {
+		return nil, errors.New("SSH proxy username is too long")
+	}
+	if pass, _ := u.User.Password(); len(pass) > 255 {
+		return nil, errors.New("SSH proxy password is too long")
+	}
+	return u, nil
+}
+
+// dialVia opens a TCP connection to addr, through proxy when it is set.
+// The proxy is given addr's host name as is, so a name only the proxy's side
+// can resolve (a .onion address, a host on the corporate network) works.
+func dialVia(ctx context.Context, proxy *url.URL, addr string, timeout time.Duration) (net.Conn, error) {
+	d := net.Dialer{Timeout: timeout}
+	if proxy == nil {
+		return d.DialContext(ctx, "tcp", addr)
+	}
+	conn, err := d.DialContext(ctx, "tcp", proxy.Host)
+	if err != nil {
+		return nil, fmt.Errorf("SSH proxy %s: %w", proxy.Redacted(), err)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+	var through net.Conn
+	if proxy.Scheme == "http" {
+		through, err = httpConnect(conn, proxy, addr)
+	} else {
+		through, err = conn, socks5Connect(conn, proxy, addr)
+	}
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("SSH proxy %s: %w", proxy.Redacted(), err)
+	}
+	conn.SetDeadline(time.Time{})
+	return through, nil
+}
+
+// socks5Replies explains the SOCKS5 reply codes that mean failure.
+var socks5Replies = []string{
+	1: "general failure",
+	2: "connection not allowed by the proxy's rules",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// socks5Connect asks the SOCKS5 server on conn for a connection to addr,
+// logging in with the proxy URL's user and password when it has them
+// (RFC 1928 and 1929).
+func socks5Connect(conn net.Conn, proxy *url.URL, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return fmt.Errorf("invalid port %q", portStr)
+	}
+	user := proxy.User.Username()
+	pass, _ := proxy.User.Password()
+
+	greeting := []byte{5, 1, 0}
+	if user != "" {
+		greeting = []byte{5, 2, 0, 2}
+	}
+	if _, err := conn.Write(greeting); err != nil {
+		return err
+	}
+	resp := make([]byte, 2)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return err
+	}
+	if resp[0] != 5 {
+		return errors.New("not a SOCKS5 proxy")
+	}
+	switch resp[1] {
+	case 0:
+	case 2:
+		if user == "" {
+			return errors.New("the proxy wants a username and password")
+		}
+		msg := append([]byte{1, byte(len(user))}, user...)
+		msg = append(append(msg, byte(len(pass))), pass...)
+		if _, err := conn.Write(msg); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, resp); err != nil {
+			return err
+		}
+		if resp[1] != 0 {
+			return errors.New("the proxy rejected the username and password")
+		}
+	default:
+		return errors.New("the proxy accepts none of the login methods offered")
+	}
+
+	req := []byte{5, 1, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return errors.New("host name too long for SOCKS5")
+		}
+		req = append(append(req, 3, byte(len(host))), host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(append(req, 1), ip4...)
+	} else {
+		req = append(append(req, 4), ip.To16()...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return err
+	}
+	if head[1] != 0 {
+		reason := fmt.Sprintf("reply %d", head[1])
+		if int(head[1]) < len(socks5Replies) {
+			reason = socks5Replies[head[1]]
+		}
+		return fmt.Errorf("connecting to %s failed: %s", addr, reason)
+	}
+	skip := 0
+	switch head[3] {
+	case 1:
+		skip = 4
+	case 4:
+		skip = 16
+	case 3:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			return err
+		}
+		skip = int(n[0])
+	default:
+		return fmt.Errorf("unknown address type %d in the proxy's reply", head[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
+
+// httpConnect opens a CONNECT tunnel to addr through the HTTP proxy on
+// conn, sending the proxy URL's user and password as basic auth.
+func httpConnect(conn net.Conn, proxy *url.URL, addr string) (net.Conn, error) {
+	req := "CONNECT " + addr + " HTTP/1.1\r\nHost: " + addr + "\r\n"
+	if user := proxy.User.Username(); user != "" {
+		pass, _ := proxy.User.Password()
+		req += "Proxy-Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+pass)) + "\r\n"
+	}
+	if _, err := io.WriteString(conn, req+"\r\n"); err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("CONNECT %s: %s", addr, resp.Status)
+	}
+	// The SSH server speaks first, so its banner may already be buffered.
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) { return c.r.Read(p) }
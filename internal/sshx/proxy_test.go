@@ -0,0 +1,139 @@
+package sshx
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// proxyServer accepts one kind of proxy request ("socks5" or "http") on
+// 127.0.0.1, wanting user "u" and password "p", and reports each target it
+// was asked for on seen.
+func proxyServer(t *testing.T, kind string, seen chan<- string) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				var target string
+				if kind == "socks5" {
+					target = socks5Accept(conn)
+				} else {
+					target = httpAccept(conn)
+				}
+				if target == "" {
+					return
+				}
+				seen <- target
+				up, err := net.Dial("tcp", target)
+				if err != nil {
+					return
+				}
+				defer up.Close()
+				go io.Copy(up, conn)
+				io.Copy(conn, up)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func socks5Accept(conn net.Conn) string {
+	buf := make([]byte, 262)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return ""
+	}
+	io.ReadFull(conn, buf[:buf[1]])
+	conn.Write([]byte{5, 2})
+	io.ReadFull(conn, buf[:2])
+	user := make([]byte, buf[1])
+	io.ReadFull(conn, user)
+	io.ReadFull(conn, buf[:1])
+	pass := make([]byte, buf[0])
+	io.ReadFull(conn, pass)
+	if string(user) != "u" || string(pass) != "p" {
+		conn.Write([]byte{1, 1})
+		return ""
+	}
+	conn.Write([]byte{1, 0})
+	io.ReadFull(conn, buf[:5])
+	if buf[3] != 3 {
+		return ""
+	}
+	host := make([]byte, buf[4])
+	io.ReadFull(conn, host)
+	io.ReadFull(conn, buf[:2])
+	port := binary.BigEndian.Uint16(buf[:2])
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	// The test target is "localhost"; the proxy, not the client, resolves it.
+	return net.JoinHostPort(strings.Replace(string(host), "localhost", "127.0.0.1", 1), strconv.Itoa(int(port)))
+}
+
+func httpAccept(conn net.Conn) string {
+	req, err := http.ReadRequest(bufio.NewReader(conn))
+	if err != nil || req.Method != http.MethodConnect {
+		return ""
+	}
+	if req.Header.Get("Proxy-Authorization") != "Basic "+base64.StdEncoding.EncodeToString([]byte("u:p")) {
+		io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+		return ""
+	}
+	io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+	return strings.Replace(req.Host, "localhost", "127.0.0.1", 1)
+}
+
+func TestConnectThroughProxy(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	port := otpServer(t)
+	target := Target{Host: "localhost", Port: port, User: "root", Password: "secret"}
+	for _, kind := range []string{"socks5", "http"} {
+		seen := make(chan string, 4)
+		addr := proxyServer(t, kind, seen)
+		opts := ConnectOptions{HostKeyMode: HostKeyInsecureIgnore, OTPCommand: "echo 123456", Proxy: kind + "://u:p@" + addr}
+		c, err := ConnectWithOptions(target, opts)
+		if err != nil {
+			t.Fatalf("%s: %v", kind, err)
+		}
+		c.Close()
+		if got := <-seen; got != net.JoinHostPort("127.0.0.1", strconv.Itoa(port)) {
+			t.Fatalf("%s proxy was asked for %s", kind, got)
+		}
+
+		opts.Proxy = kind + "://u:wrong@" + addr
+		_, err = ConnectWithOptions(target, opts)
+		if err == nil || strings.Contains(err.Error(), "wrong") || !strings.Contains(err.Error(), "SSH proxy") {
+			t.Fatalf("%s with a wrong password: %v", kind, err)
+		}
+	}
+}
+
+func TestParseProxy(t *testing.T) {
+	for _, ok := range []string{"socks5://127.0.0.1:9050", "socks5h://proxy.example:1080", "HTTP://u:p@proxy.example:3128"} {
+		if _, err := ParseProxy(ok); err != nil {
+			t.Fatalf("%s: %v", ok, err)
+		}
+	}
+	for _, bad := range []string{"127.0.0.1:9050", "https://proxy.example:3128", "socks5://proxy.example", "http://u:secret@:3128", "socks5://127.0.0.1:99999"} {
+		_, err := ParseProxy(bad)
+		if err == nil {
+			t.Fatalf("%s was accepted", bad)
+		}
+		if strings.Contains(err.Error(), "secret") {
+			t.Fatalf("%s: error shows the password: %v", bad, err)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package sshx
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// maxIncludeDepth stops Include loops, like OpenSSH's READCONF_MAX_DEPTH.
+const maxIncludeDepth = 16
+
+// hostConfig is what beammeup takes from the OpenSSH client config for a
+// host: where it really is, who to log in as, and which keys to offer.
+type hostConfig struct {
+	HostName       string
+	User           string
+	Port           int
+	IdentityFiles  []string
+	HashKnownHosts string // "yes" or "no"; empty if not set
+
+	// Algorithm lists as written, for ParseAlgorithms.
+	Ciphers, KexAlgorithms, MACs, HostKeyAlgorithms string
+}
+
+// Resolve applies the OpenSSH client config (opts.SSHConfigPath) to t, so an
+// alias like "myvps" connects where `ssh myvps` would. HostName, User, Port
+// and IdentityFile are honoured; as in OpenSSH the first value found wins.
+// The config's User and Port only replace beammeup's defaults (root and 22),
+// never a user or port chosen for the ship.
+func (opts ConnectOptions) Resolve(t Target) Target {
+	path := strings.TrimSpace(opts.SSHConfigPath)
+	if path == "" || t.Host == "" {
+		return t
+	}
+	hc, err := readSSHConfig(path, t.Host)
+	if err != nil {
+		return t
+	}
+	if hc.HostName != "" {
+		t.Host = hc.HostName
+	}
+	if hc.User != "" && (t.User == "" || t.User == "root") {
+		t.User = hc.User
+	}
+	if hc.Port > 0 && (t.Port == 0 || t.Port == 22) {
+		t.Port = hc.Port
+	}
+	if len(t.IdentityFiles) == 0 {
+		t.IdentityFiles = hc.IdentityFiles
+	}
+	return t
+}
+
+// readSSHConfig collects the settings for host from the config at path. A
+// missing file is an empty config.
+func readSSHConfig(path, host string) (hostConfig, error) {
+	var hc hostConfig
+	home, _ := os.UserHomeDir()
+	p := &sshConfigParser{host: host, home: home, base: filepath.Dir(path), hc: &hc}
+	if err := p.file(path, 0); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return hostConfig{}, err
+	}
+	return hc, nil
+}
+
+type sshConfigParser struct {
+	host string
+	home string
+	base string // relative Include paths start here (~/.ssh for the user config)
+	hc   *hostConfig
+}
+
+func (p *sshConfigParser) file(path string, depth int) error {
+	if depth > maxIncludeDepth {
+		return fmt.Errorf("ssh config: Include nested too deep at %s", path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Lines before the first Host or Match apply to every host.
+	active := true
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, args := splitConfigLine(scanner.Text())
+		if key == "" {
+			continue
+		}
+		switch key {
+		case "host":
+			active = matchHostPatterns(p.host, args)
+			continue
+		case "match":
+			// Match criteria can run commands and test things beammeup does
+			// not know; its blocks are skipped rather than guessed at.
+			active = false
+			continue
+		}
+		if !active || len(args) == 0 {
+			continue
+		}
+		switch key {
+		case "include":
+			for _, pattern := range args {
+				if err := p.include(pattern, depth); err != nil {
+					return err
+				}
+			}
+		case "hostname":
+			if p.hc.HostName == "" {
+				p.hc.HostName = strings.ReplaceAll(args[0], "%h", p.host)
+			}
+		case "user":
+			if p.hc.User == "" {
+				p.hc.User = args[0]
+			}
+		case "port":
+			if p.hc.Port == 0 {
+				if n, err := strconv.Atoi(args[0]); err == nil && n > 0 && n <= 65535 {
+					p.hc.Port = n
+				}
+			}
+		case "hashknownhosts":
+			if p.hc.HashKnownHosts == "" {
+				p.hc.HashKnownHosts = strings.ToLower(args[0])
+			}
+		case "ciphers", "kexalgorithms", "macs", "hostkeyalgorithms":
+			field := map[string]*string{"ciphers": &p.hc.Ciphers, "kexalgorithms": &p.hc.KexAlgorithms, "macs": &p.hc.MACs, "hostkeyalgorithms": &p.hc.HostKeyAlgorithms}[key]
+			if *field == "" {
+				*field = args[0]
+			}
+		case "identityfile":
+			if !strings.EqualFold(args[0], "none") {
+				p.hc.IdentityFiles = append(p.hc.IdentityFiles, p.expandPath(args[0]))
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+func (p *sshConfigParser) include(pattern string, depth int) error {
+	pattern = p.expandPath(pattern)
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(p.base, pattern)
+	}
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil
+	}
+	for _, m := range matches {
+		if err := p.file(m, depth+1); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// expandPath handles the ~ and tokens OpenSSH allows in file names that make
+// sense here.
+func (p *sshConfigParser) expandPath(v string) string {
+	if v == "~" || strings.HasPrefix(v, "~/") {
+		v = p.home + v[1:]
+	}
+	return strings.NewReplacer("%d", p.home, "%h", p.host, "%%", "%").Replace(v)
+}
+
+// splitConfigLine returns a config line's lowercased keyword and its
+// arguments. Keywords may be separated from arguments by spaces or one "=";
+// arguments may be double-quoted.
+func splitConfigLine(line string) (string, []string) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil
+	}
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return strings.ToLower(line), nil
+	}
+	key := strings.ToLower(line[:end])
+	rest := strings.TrimLeft(line[end:], " \t")
+	rest = strings.TrimLeft(strings.TrimPrefix(rest, "="), " \t")
+
+	var args []string
+	for rest != "" {
+		var arg string
+		if rest[0] == '"' {
+			closing := strings.IndexByte(rest[1:], '"')
+			if closing < 0 {
+				arg, rest = rest[1:], ""
+			} else {
+				arg, rest = rest[1:closing+1], rest[closing+2:]
+			}
+		} else {
+			end := strings.IndexAny(rest, " \t")
+			if end < 0 {
+				end = len(rest)
+			}
+			arg, rest = rest[:end], rest[end:]
+		}
+		if strings.HasPrefix(arg, "#") {
+			break
+		}
+		args = append(args, arg)
+		rest = strings.TrimLeft(rest, " \t")
+	}
+	return key, args
+}
+
+// matchHostPatterns reports whether host matches a Host line: at least one
+// pattern matches and no negated (!) one does.
+func matchHostPatterns(host string, patterns []string) bool {
+	host = strings.ToLower(host)
+	matched := false
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if neg, ok := strings.CutPrefix(pattern, "!"); ok {
+			if wildcardMatch(neg, host) {
+				return false
+			}
+			continue
+		}
+		if wildcardMatch(pattern, host) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// wildcardMatch matches s against a pattern of literal text, * and ?.
+func wildcardMatch(pattern, s string) bool {
+	for pattern != "" {
+		switch pattern[0] {
+		case '*':
+			for pattern != "" && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if pattern == "" {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if wildcardMatch(pattern, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if s == "" {
+				return false
+			}
+		default:
+			if s == "" || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return s == ""
+}
@@ -0,0 +1,84 @@
+package sshx
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestResolveSSHConfig(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	dir := filepath.Join(home, ".ssh")
+	if err := os.MkdirAll(filepath.Join(dir, "conf.d"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	config := `# fleet
+Include conf.d/*
+
+Host myvps
+  HostName 192.0.2.10
+  Port=2222
+  IdentityFile ~/.ssh/id_myvps
+  User deploy
+
+Host *.internal !bastion.internal
+  User ops
+
+Match exec "false"
+  User never
+
+Host *
+  User fallback
+  Port 2200
+  IdentityFile "%d/.ssh/id_default"
+`
+	if err := os.WriteFile(filepath.Join(dir, "config"), []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "conf.d", "other"), []byte("Host other\n  HostName 198.51.100.7\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	opts := ConnectOptions{SSHConfigPath: filepath.Join(dir, "config")}
+
+	got := opts.Resolve(Target{Host: "myvps", User: "root", Port: 22})
+	want := []string{filepath.Join(home, ".ssh", "id_myvps"), filepath.Join(home, ".ssh", "id_default")}
+	if got.Host != "192.0.2.10" || got.User != "deploy" || got.Port != 2222 || !slices.Equal(got.IdentityFiles, want) {
+		t.Fatalf("unexpected alias resolution: %+v", got)
+	}
+	if got := opts.Resolve(Target{Host: "myvps", User: "admin", Port: 2022}); got.User != "admin" || got.Port != 2022 {
+		t.Fatalf("explicit user and port must win: %+v", got)
+	}
+	if got := opts.Resolve(Target{Host: "db.internal", User: "root"}); got.User != "ops" || got.Host != "db.internal" {
+		t.Fatalf("unexpected wildcard match: %+v", got)
+	}
+	if got := opts.Resolve(Target{Host: "bastion.internal", User: "root"}); got.User != "fallback" {
+		t.Fatalf("negated pattern should not match: %+v", got)
+	}
+	if got := opts.Resolve(Target{Host: "other"}); got.Host != "198.51.100.7" {
+		t.Fatalf("Include not followed: %+v", got)
+	}
+	if got := (ConnectOptions{}).Resolve(Target{Host: "myvps"}); got.Host != "myvps" {
+		t.Fatalf("empty config path must leave the target alone: %+v", got)
+	}
+}
+
+func TestWildcardMatch(t *testing.T) {
+	cases := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"*", "anything", true},
+		{"web-??", "web-01", true},
+		{"web-??", "web-1", false},
+		{"*.example.com", "a.b.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"10.0.*.1", "10.0.3.1", true},
+	}
+	for _, c := range cases {
+		if got := wildcardMatch(c.pattern, c.s); got != c.want {
+			t.Fatalf("wildcardMatch(%q, %q) = %v, want %v", c.pattern, c.s, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package sysproxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// networkSetup configures macOS network services with networksetup(8).
+type networkSetup struct{}
+
+type macProxy struct {
+	Enabled bool   `json:"enabled"`
+	Server  string `json:"server,omitempty"`
+	Port    string `json:"port,omitempty"`
+}
+
+type macService struct {
+	Name   string   `json:"name"`
+	Web    macProxy `json:"web"`
+	Secure macProxy `json:"secure"`
+	SOCKS  macProxy `json:"socks"`
+}
+
+// macKinds maps each proxy kind to its networksetup verbs.
+var macKinds = []struct {
+	get, set, setState string
+	field              func(*macService) *macProxy
+}{
+	{"-getwebproxy", "-setwebproxy", "-setwebproxystate", func(s *macService) *macProxy { return &s.Web }},
+	{"-getsecurewebproxy", "-setsecurewebproxy", "-setsecurewebproxystate", func(s *macService) *macProxy { return &s.Secure }},
+	{"-getsocksfirewallproxy", "-setsocksfirewallproxy", "-setsocksfirewallproxystate", func(s *macService) *macProxy { return &s.SOCKS }},
+}
+
+func (networkSetup) Name() string { return "macos" }
+
+func (networkSetup) services() ([]string, error) {
+	out, err := execFn("networksetup", "-listallnetworkservices")
+	if err != nil {
+		return nil, err
+	}
+	return parseMacServices(out), nil
+}
+
+func (n networkSetup) Snapshot() (json.RawMessage, error) {
+	names, err := n.services()
+	if err != nil {
+		return nil, err
+	}
+	snap := make([]macService, 0, len(names))
+	for _, name := range names {
+		svc := macService{Name: name}
+		for _, k := range macKinds {
+			out, err := execFn("networksetup", k.get, name)
+			if err != nil {
+				return nil, err
+			}
+			*k.field(&svc) = parseMacProxy(out)
+		}
+		snap = append(snap, svc)
+	}
+	return json.Marshal(snap)
+}
+
+func (n networkSetup) Apply(s Settings) error {
+	names, err := n.services()
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("no enabled network services found")
+	}
+	for _, name := range names {
+		for _, k := range macKinds {
+			socksKind := k.set == "-setsocksfirewallproxy"
+			if socksKind != (s.Protocol == "socks5") {
+				if _, err := execFn("networksetup", k.setState, name, "off"); err != nil {
+					return err
+				}
+				continue
+			}
+			args := []string{k.set, name, s.Host, s.Port}
+			if s.User != "" {
+				args = append(args, "on", s.User, s.Pass)
+			} else {
+				args = append(args, "off")
+			}
+			if _, err := execFn("networksetup", args...); err != nil {
+				return err
+			}
+			if _, err := execFn("networksetup", k.setState, name, "on"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (networkSetup) Restore(snapshot json.RawMessage) error {
+	var snap []macService
+	if err := json.Unmarshal(snapshot, &snap); err != nil {
+		return fmt.Errorf("parse macOS snapshot: %w", err)
+	}
+	for i := range snap {
+		svc := &snap[i]
+		for _, k := range macKinds {
+			p := k.field(svc)
+			if p.Server != "" && p.Port != "" && p.Port != "0" {
+				if _, err := execFn("networksetup", k.set, svc.Name, p.Server, p.Port, "off"); err != nil {
+					return err
+				}
+			}
+			state := "off"
+			if p.Enabled {
+				state = "on"
+			}
+			if _, err := execFn("networksetup", k.setState, svc.Name, state); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parseMacServices reads `networksetup -listallnetworkservices`, skipping
+// the explanatory first line and disabled services (prefixed with "*").
+func parseMacServices(out string) []string {
+	var names []string
+	s := bufio.NewScanner(strings.NewReader(out))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "*") || strings.HasPrefix(line, "An asterisk") {
+			continue
+		}
+		names = append(names, line)
+	}
+	return names
+}
+
+// parseMacProxy reads the output of `networksetup -get*proxy <service>`.
+func parseMacProxy(out string) macProxy {
+	var p macProxy
+	s := bufio.NewScanner(strings.NewReader(out))
+	for s.Scan() {
+		key, val, ok := strings.Cut(s.Text(), ":")
+		if !ok {
+			continue
+		}
+		val = strings.TrimSpace(val)
+		switch strings.TrimSpace(key) {
+		case "Enabled":
+			p.Enabled = strings.EqualFold(val, "yes")
+		case "Server":
+			p.Server = val
+		case "Port":
+			p.Port = val
+		}
+	}
+	return p
+}
@@ -0,0 +1,94 @@
+package sysproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// gnomeSettings configures the GNOME desktop proxy through gsettings(1).
+type gnomeSettings struct{}
+
+type gsetting struct {
+	Schema string `json:"schema"`
+	Key    string `json:"key"`
+	Value  string `json:"value"` // GVariant text as printed by `gsettings get`
+}
+
+const gnomeProxySchema = "org.gnome.system.proxy"
+
+var gnomeKeys = []struct{ schema, key string }{
+	{gnomeProxySchema, "mode"},
+	{gnomeProxySchema + ".http", "host"},
+	{gnomeProxySchema + ".http", "port"},
+	{gnomeProxySchema + ".http", "use-authentication"},
+	{gnomeProxySchema + ".http", "authentication-user"},
+	{gnomeProxySchema + ".http", "authentication-password"},
+	{gnomeProxySchema + ".https", "host"},
+	{gnomeProxySchema + ".https", "port"},
+	{gnomeProxySchema + ".socks", "host"},
+	{gnomeProxySchema + ".socks", "port"},
+}
+
+func (gnomeSettings) Name() string { return "gnome" }
+
+func (gnomeSettings) Snapshot() (json.RawMessage, error) {
+	snap := make([]gsetting, 0, len(gnomeKeys))
+	for _, k := range gnomeKeys {
+		out, err := execFn("gsettings", "get", k.schema, k.key)
+		if err != nil {
+			return nil, err
+		}
+		snap = append(snap, gsetting{Schema: k.schema, Key: k.key, Value: strings.TrimSpace(out)})
+	}
+	return json.Marshal(snap)
+}
+
+func (gnomeSettings) Apply(s Settings) error {
+	var sets [][3]string
+	if s.Protocol == "socks5" {
+		// GNOME cannot store SOCKS credentials; clear the HTTP entries so
+		// they do not take precedence.
+		sets = [][3]string{
+			{gnomeProxySchema + ".http", "host", ""},
+			{gnomeProxySchema + ".https", "host", ""},
+			{gnomeProxySchema + ".socks", "host", s.Host},
+			{gnomeProxySchema + ".socks", "port", s.Port},
+		}
+	} else {
+		useAuth := "false"
+		if s.User != "" {
+			useAuth = "true"
+		}
+		sets = [][3]string{
+			{gnomeProxySchema + ".http", "host", s.Host},
+			{gnomeProxySchema + ".http", "port", s.Port},
+			{gnomeProxySchema + ".http", "use-authentication", useAuth},
+			{gnomeProxySchema + ".http", "authentication-user", s.User},
+			{gnomeProxySchema + ".http", "authentication-password", s.Pass},
+			{gnomeProxySchema + ".https", "host", s.Host},
+			{gnomeProxySchema + ".https", "port", s.Port},
+			{gnomeProxySchema + ".socks", "host", ""},
+		}
+	}
+	sets = append(sets, [3]string{gnomeProxySchema, "mode", "manual"})
+	for _, kv := range sets {
+		if _, err := execFn("gsettings", "set", kv[0], kv[1], kv[2]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (gnomeSettings) Restore(snapshot json.RawMessage) error {
+	var snap []gsetting
+	if err := json.Unmarshal(snapshot, &snap); err != nil {
+		return fmt.Errorf("parse gnome snapshot: %w", err)
+	}
+	for _, g := range snap {
+		if _, err := execFn("gsettings", "set", g.Schema, g.Key, g.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
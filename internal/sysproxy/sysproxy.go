@@ -0,0 +1,152 @@
+package sysproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const stateFile = "sysproxy.json"
+
+// Settings is the proxy the operating system should route through.
+type Settings struct {
+	Protocol string // http|socks5
+	Host     string
+	Port     string
+	User     string
+	Pass     string
+}
+
+// Backend reads and changes one platform's system proxy configuration.
+type Backend interface {
+	Name() string
+	// Snapshot captures the current settings so Restore can put them back.
+	Snapshot() (json.RawMessage, error)
+	Apply(s Settings) error
+	Restore(snapshot json.RawMessage) error
+}
+
+// state is persisted between "on" and "off" so the previous configuration
+// survives the beammeup process.
+type state struct {
+	Backend  string          `json:"backend"`
+	Ship     string          `json:"ship"`
+	Snapshot json.RawMessage `json:"snapshot"`
+}
+
+// execFn runs a system tool and returns its combined output. Tests replace it.
+var execFn = func(name string, args ...string) (string, error) {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("%s %s: %w: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
+
+// Detect returns the backend for the running operating system.
+func Detect() (Backend, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		return networkSetup{}, nil
+	case "windows":
+		return winInet{}, nil
+	case "linux":
+		if _, err := exec.LookPath("gsettings"); err == nil && isGNOME(os.Getenv("XDG_CURRENT_DESKTOP")) {
+			return gnomeSettings{}, nil
+		}
+		return nil, errors.New("system proxy configuration on Linux requires GNOME (gsettings)")
+	default:
+		return nil, fmt.Errorf("system proxy configuration is not supported on %s", runtime.GOOS)
+	}
+}
+
+// isGNOME reports whether an XDG_CURRENT_DESKTOP value (e.g.
+// "ubuntu:GNOME") names a GNOME-based desktop.
+func isGNOME(desktop string) bool {
+	for _, d := range strings.Split(desktop, ":") {
+		switch strings.ToLower(strings.TrimSpace(d)) {
+		case "gnome", "unity", "budgie", "pantheon":
+			return true
+		}
+	}
+	return false
+}
+
+// On points the system proxy at s, remembering the previous settings in dir
+// unless an earlier "on" already saved them.
+func On(b Backend, dir, ship string, s Settings) error {
+	if _, err := loadState(dir); errors.Is(err, os.ErrNotExist) {
+		snap, err := b.Snapshot()
+		if err != nil {
+			return fmt.Errorf("snapshot current proxy settings: %w", err)
+		}
+		if err := saveState(dir, state{Backend: b.Name(), Ship: ship, Snapshot: snap}); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	return b.Apply(s)
+}
+
+// Off restores the settings saved by On and forgets them. It reports the
+// ship that was active, if any.
+func Off(b Backend, dir string) (string, error) {
+	st, err := loadState(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", errors.New("no saved system proxy settings; nothing to restore")
+	}
+	if err != nil {
+		return "", err
+	}
+	if st.Backend != b.Name() {
+		return "", fmt.Errorf("saved settings belong to %s, not %s", st.Backend, b.Name())
+	}
+	if err := b.Restore(st.Snapshot); err != nil {
+		return "", fmt.Errorf("restore proxy settings: %w", err)
+	}
+	if err := os.Remove(filepath.Join(dir, stateFile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("remove sysproxy state: %w", err)
+	}
+	return st.Ship, nil
+}
+
+// Active reports the ship the system proxy currently points at, if any.
+func Active(dir string) (string, bool) {
+	st, err := loadState(dir)
+	if err != nil {
+		return "", false
+	}
+	return st.Ship, true
+}
+
+func loadState(dir string) (state, error) {
+	var st state
+	b, err := os.ReadFile(filepath.Join(dir, stateFile))
+	if err != nil {
+		return st, err
+	}
+	if err := json.Unmarshal(b, &st); err != nil {
+		return st, fmt.Errorf("parse sysproxy state: %w", err)
+	}
+	return st, nil
+}
+
+func saveState(dir string, st state) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("ensure state dir: %w", err)
+	}
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode sysproxy state: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, stateFile), b, 0o600); err != nil {
+		return fmt.Errorf("write sysproxy state: %w", err)
+	}
+	return nil
+}
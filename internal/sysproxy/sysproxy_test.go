@@ -0,0 +1,82 @@
+package sysproxy
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseMacOutput(t *testing.T) {
+	services := parseMacServices("An asterisk (*) denotes that a network service is disabled.\nWi-Fi\n*Thunderbolt Bridge\nUSB 10/100/1000 LAN\n")
+	if len(services) != 2 || services[0] != "Wi-Fi" || services[1] != "USB 10/100/1000 LAN" {
+		t.Fatalf("unexpected services: %q", services)
+	}
+
+	p := parseMacProxy("Enabled: Yes\nServer: 192.0.2.5\nPort: 3128\nAuthenticated Proxy Enabled: 0\n")
+	if !p.Enabled || p.Server != "192.0.2.5" || p.Port != "3128" {
+		t.Fatalf("unexpected proxy: %+v", p)
+	}
+}
+
+func TestOnOffRestoresSnapshot(t *testing.T) {
+	var calls []string
+	origExec := execFn
+	t.Cleanup(func() { execFn = origExec })
+	execFn = func(name string, args ...string) (string, error) {
+		calls = append(calls, name+" "+strings.Join(args, " "))
+		switch args[0] {
+		case "-listallnetworkservices":
+			return "An asterisk (*) denotes that a network service is disabled.\nWi-Fi\n", nil
+		case "-getwebproxy":
+			return "Enabled: Yes\nServer: 192.0.2.5\nPort: 3128\n", nil
+		default:
+			return "Enabled: No\nServer: \nPort: 0\n", nil
+		}
+	}
+	dir := t.TempDir()
+	b := networkSetup{}
+
+	if err := On(b, dir, "edge", Settings{Protocol: "socks5", Host: "127.0.0.1", Port: "1080"}); err != nil {
+		t.Fatalf("On: %v", err)
+	}
+	if ship, ok := Active(dir); !ok || ship != "edge" {
+		t.Fatalf("expected edge active, got %q %v", ship, ok)
+	}
+	joined := strings.Join(calls, "\n")
+	if !strings.Contains(joined, "networksetup -setsocksfirewallproxy Wi-Fi 127.0.0.1 1080 off") ||
+		!strings.Contains(joined, "networksetup -setwebproxystate Wi-Fi off") {
+		t.Fatalf("unexpected apply calls:\n%s", joined)
+	}
+
+	calls = nil
+	ship, err := Off(b, dir)
+	if err != nil || ship != "edge" {
+		t.Fatalf("Off: %q %v", ship, err)
+	}
+	joined = strings.Join(calls, "\n")
+	if !strings.Contains(joined, "networksetup -setwebproxy Wi-Fi 192.0.2.5 3128 off") ||
+		!strings.Contains(joined, "networksetup -setwebproxystate Wi-Fi on") ||
+		!strings.Contains(joined, "networksetup -setsocksfirewallproxystate Wi-Fi off") {
+		t.Fatalf("unexpected restore calls:\n%s", joined)
+	}
+	if _, ok := Active(dir); ok {
+		t.Fatalf("expected state cleared after Off")
+	}
+}
+
+func TestParseRegValue(t *testing.T) {
+	out := "\r\nHKEY_CURRENT_USER\\Software\\Microsoft\\Windows\\CurrentVersion\\Internet Settings\r\n    ProxyEnable    REG_DWORD    0x1\r\n\r\n"
+	v := parseRegValue(out, "ProxyEnable")
+	if !v.Present || v.Type != "REG_DWORD" || v.Data != "1" {
+		t.Fatalf("unexpected value: %+v", v)
+	}
+	v = parseRegValue("    ProxyOverride    REG_SZ    *.corp.example;<local>\r\n", "ProxyOverride")
+	if v.Data != "*.corp.example;<local>" {
+		t.Fatalf("unexpected override: %+v", v)
+	}
+}
+
+func TestIsGNOME(t *testing.T) {
+	if !isGNOME("ubuntu:GNOME") || isGNOME("KDE") || isGNOME("") {
+		t.Fatalf("unexpected desktop detection")
+	}
+}
@@ -0,0 +1,100 @@
+package sysproxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+)
+
+const winInetKey = `HKCU\Software\Microsoft\Windows\CurrentVersion\Internet Settings`
+
+// winInet configures the per-user WinINET proxy through reg.exe. WinINET
+// has no place for credentials; applications prompt for them instead.
+type winInet struct{}
+
+type winValue struct {
+	Present bool   `json:"present"`
+	Type    string `json:"type,omitempty"`
+	Data    string `json:"data,omitempty"`
+}
+
+var winInetValues = []string{"ProxyEnable", "ProxyServer", "ProxyOverride"}
+
+func (winInet) Name() string { return "windows" }
+
+func (winInet) Snapshot() (json.RawMessage, error) {
+	snap := map[string]winValue{}
+	for _, name := range winInetValues {
+		out, err := execFn("reg", "query", winInetKey, "/v", name)
+		if err != nil {
+			// reg.exe exits non-zero when the value does not exist.
+			snap[name] = winValue{}
+			continue
+		}
+		snap[name] = parseRegValue(out, name)
+	}
+	return json.Marshal(snap)
+}
+
+func (winInet) Apply(s Settings) error {
+	server := net.JoinHostPort(s.Host, s.Port)
+	if s.Protocol == "socks5" {
+		server = "socks=" + server
+	}
+	for _, v := range []struct{ name, typ, data string }{
+		{"ProxyServer", "REG_SZ", server},
+		{"ProxyOverride", "REG_SZ", "<local>"},
+		{"ProxyEnable", "REG_DWORD", "1"},
+	} {
+		if _, err := execFn("reg", "add", winInetKey, "/v", v.name, "/t", v.typ, "/d", v.data, "/f"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (winInet) Restore(snapshot json.RawMessage) error {
+	var snap map[string]winValue
+	if err := json.Unmarshal(snapshot, &snap); err != nil {
+		return fmt.Errorf("parse windows snapshot: %w", err)
+	}
+	for _, name := range winInetValues {
+		v := snap[name]
+		if !v.Present {
+			execFn("reg", "delete", winInetKey, "/v", name, "/f")
+			continue
+		}
+		if _, err := execFn("reg", "add", winInetKey, "/v", name, "/t", v.Type, "/d", v.Data, "/f"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseRegValue reads `reg query <key> /v <name>` output, e.g.
+// "    ProxyEnable    REG_DWORD    0x1".
+func parseRegValue(out, name string) winValue {
+	s := bufio.NewScanner(strings.NewReader(out))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 || !strings.EqualFold(fields[0], name) || !strings.HasPrefix(fields[1], "REG_") {
+			continue
+		}
+		v := winValue{Present: true, Type: fields[1]}
+		if len(fields) > 2 {
+			line := strings.TrimSpace(s.Text())
+			idx := strings.Index(line, fields[1]) + len(fields[1])
+			v.Data = strings.TrimSpace(line[idx:])
+		}
+		if v.Type == "REG_DWORD" && strings.HasPrefix(v.Data, "0x") {
+			var n uint32
+			if _, err := fmt.Sscanf(v.Data, "0x%x", &n); err == nil {
+				v.Data = fmt.Sprintf("%d", n)
+			}
+		}
+		return v
+	}
+	return winValue{}
+}
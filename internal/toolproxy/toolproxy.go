@@ -0,0 +1,281 @@
+// Package toolproxy writes per-tool proxy settings for common developer
+// tools (git, npm, pip) so they can use a hangar without a system proxy.
+package toolproxy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Tools lists the supported tool names.
+var Tools = []string{"git", "npm", "pip"}
+
+// execFn runs a command and returns its combined output. Tests replace it.
+var execFn = func(name string, args ...string) (string, error) {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("%s %s: %w: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
+
+// Set points tool at proxyURL and returns where the setting was written.
+// An empty proxyURL removes beammeup's settings instead.
+func Set(tool, proxyURL string) (string, error) {
+	switch tool {
+	case "git":
+		return "git config --global", setGit(proxyURL)
+	case "npm":
+		path, err := npmrcPath()
+		if err != nil {
+			return "", err
+		}
+		return path, setFlatKeys(path, proxyURL, "proxy", "https-proxy")
+	case "pip":
+		path, err := pipConfigPath()
+		if err != nil {
+			return "", err
+		}
+		return path, setINIKey(path, "global", "proxy", proxyURL)
+	default:
+		return "", fmt.Errorf("unsupported tool %q (use %s)", tool, strings.Join(Tools, ", "))
+	}
+}
+
+// Unset removes the proxy settings Set wrote for tool.
+func Unset(tool string) (string, error) {
+	return Set(tool, "")
+}
+
+// Get returns the proxy URL tool uses from the settings Set writes, or ""
+// when none is set.
+func Get(tool string) (string, error) {
+	switch tool {
+	case "git":
+		// A missing key (or git) exits non-zero; either way there is no proxy.
+		out, err := execFn("git", "config", "--global", "--get", "http.proxy")
+		if err != nil {
+			return "", nil
+		}
+		return strings.TrimSpace(out), nil
+	case "npm":
+		path, err := npmrcPath()
+		if err != nil {
+			return "", err
+		}
+		return getFlatKey(path, "proxy")
+	case "pip":
+		path, err := pipConfigPath()
+		if err != nil {
+			return "", err
+		}
+		return getINIKey(path, "global", "proxy")
+	default:
+		return "", fmt.Errorf("unsupported tool %q (use %s)", tool, strings.Join(Tools, ", "))
+	}
+}
+
+func setGit(proxyURL string) error {
+	for _, key := range []string{"http.proxy", "https.proxy"} {
+		if proxyURL == "" {
+			// --unset fails on a missing key, so only unset what is there.
+			if _, err := execFn("git", "config", "--global", "--get", key); err != nil {
+				continue
+			}
+			if _, err := execFn("git", "config", "--global", "--unset", key); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := execFn("git", "config", "--global", key, proxyURL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func npmrcPath() (string, error) {
+	if v := strings.TrimSpace(os.Getenv("NPM_CONFIG_USERCONFIG")); v != "" {
+		return v, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("resolve home dir: %w", err)
+	}
+	return filepath.Join(home, ".npmrc"), nil
+}
+
+// pipConfigPath returns pip's per-user config file for this OS.
+func pipConfigPath() (string, error) {
+	if v := strings.TrimSpace(os.Getenv("PIP_CONFIG_FILE")); v != "" {
+		return v, nil
+	}
+	switch runtime.GOOS {
+	case "windows":
+		appData := os.Getenv("APPDATA")
+		if appData == "" {
+			return "", errors.New("APPDATA is not set")
+		}
+		return filepath.Join(appData, "pip", "pip.ini"), nil
+	case "darwin":
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("resolve home dir: %w", err)
+		}
+		return filepath.Join(home, "Library", "Application Support", "pip", "pip.conf"), nil
+	default:
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return "", fmt.Errorf("resolve config dir: %w", err)
+		}
+		return filepath.Join(dir, "pip", "pip.conf"), nil
+	}
+}
+
+// setFlatKeys sets every key to value in a flat key=value file such as
+// .npmrc, or removes the keys when value is empty. Other lines are kept.
+func setFlatKeys(path, value string, keys ...string) error {
+	lines, err := readLines(path)
+	if err != nil {
+		return err
+	}
+	want := map[string]bool{}
+	for _, k := range keys {
+		want[k] = true
+	}
+	out := make([]string, 0, len(lines)+len(keys))
+	for _, line := range lines {
+		k, _, ok := strings.Cut(line, "=")
+		if ok && want[strings.TrimSpace(k)] {
+			continue
+		}
+		out = append(out, line)
+	}
+	if value != "" {
+		for _, k := range keys {
+			out = append(out, k+"="+value)
+		}
+	}
+	return writeLines(path, out)
+}
+
+// getFlatKey returns the value of key in a flat key=value file, or "".
+func getFlatKey(path, key string) (string, error) {
+	lines, err := readLines(path)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range lines {
+		if k, v, ok := strings.Cut(line, "="); ok && strings.TrimSpace(k) == key {
+			return strings.TrimSpace(v), nil
+		}
+	}
+	return "", nil
+}
+
+// getINIKey returns the value of key in [section] of an INI file, or "".
+func getINIKey(path, section, key string) (string, error) {
+	lines, err := readLines(path)
+	if err != nil {
+		return "", err
+	}
+	inSection := false
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") {
+			inSection = strings.EqualFold(trimmed, "["+section+"]")
+			continue
+		}
+		if k, v, ok := strings.Cut(trimmed, "="); inSection && ok && strings.TrimSpace(k) == key {
+			return strings.TrimSpace(v), nil
+		}
+	}
+	return "", nil
+}
+
+// setINIKey sets key in [section] of an INI file such as pip.conf, creating
+// the section if needed, or removes the key when value is empty.
+func setINIKey(path, section, key, value string) error {
+	lines, err := readLines(path)
+	if err != nil {
+		return err
+	}
+	header := "[" + section + "]"
+	out := make([]string, 0, len(lines)+2)
+	inSection, written := false, false
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") {
+			if inSection && !written && value != "" {
+				out = append(out, key+" = "+value)
+				written = true
+			}
+			inSection = strings.EqualFold(trimmed, header)
+			out = append(out, line)
+			continue
+		}
+		if inSection {
+			if k, _, ok := strings.Cut(trimmed, "="); ok && strings.TrimSpace(k) == key {
+				if value != "" && !written {
+					out = append(out, key+" = "+value)
+					written = true
+				}
+				continue
+			}
+		}
+		out = append(out, line)
+	}
+	if value != "" && !written {
+		if !inSection {
+			out = append(out, header)
+		}
+		out = append(out, key+" = "+value)
+	}
+	return writeLines(path, out)
+}
+
+func readLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	return lines, nil
+}
+
+// writeLines replaces path with lines. The file may hold proxy credentials,
+// so it is written owner-only.
+func writeLines(path string, lines []string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("create %s: %w", filepath.Dir(path), err)
+	}
+	content := strings.Join(lines, "\n")
+	if content != "" {
+		content += "\n"
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(content), 0o600); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package toolproxy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSetFlatKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".npmrc")
+	if err := os.WriteFile(path, []byte("registry=https://registry.npmjs.org/\nproxy=http://old:1\n"), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := setFlatKeys(path, "http://u:p@203.0.113.5:8080", "proxy", "https-proxy"); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	got, _ := os.ReadFile(path)
+	want := "registry=https://registry.npmjs.org/\nproxy=http://u:p@203.0.113.5:8080\nhttps-proxy=http://u:p@203.0.113.5:8080\n"
+	if string(got) != want {
+		t.Fatalf("unexpected .npmrc:\n%s", got)
+	}
+	if err := setFlatKeys(path, "", "proxy", "https-proxy"); err != nil {
+		t.Fatalf("unset: %v", err)
+	}
+	got, _ = os.ReadFile(path)
+	if string(got) != "registry=https://registry.npmjs.org/\n" {
+		t.Fatalf("unexpected .npmrc after unset:\n%s", got)
+	}
+}
+
+func TestSetINIKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pip", "pip.conf")
+	if err := setINIKey(path, "global", "proxy", "http://203.0.113.5:8080"); err != nil {
+		t.Fatalf("set new: %v", err)
+	}
+	got, _ := os.ReadFile(path)
+	if string(got) != "[global]\nproxy = http://203.0.113.5:8080\n" {
+		t.Fatalf("unexpected new pip.conf:\n%s", got)
+	}
+
+	existing := "[global]\ntimeout = 60\nproxy = http://old:1\n[install]\nuser = true\n"
+	if err := os.WriteFile(path, []byte(existing), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := setINIKey(path, "global", "proxy", "http://203.0.113.5:9090"); err != nil {
+		t.Fatalf("replace: %v", err)
+	}
+	got, _ = os.ReadFile(path)
+	if string(got) != "[global]\ntimeout = 60\nproxy = http://203.0.113.5:9090\n[install]\nuser = true\n" {
+		t.Fatalf("unexpected replaced pip.conf:\n%s", got)
+	}
+	if err := setINIKey(path, "global", "proxy", ""); err != nil {
+		t.Fatalf("unset: %v", err)
+	}
+	got, _ = os.ReadFile(path)
+	if strings.Contains(string(got), "proxy") || !strings.Contains(string(got), "timeout = 60") {
+		t.Fatalf("unexpected pip.conf after unset:\n%s", got)
+	}
+}
+
+func TestGitUnsetSkipsMissingKeys(t *testing.T) {
+	var calls []string
+	orig := execFn
+	t.Cleanup(func() { execFn = orig })
+	execFn = func(name string, args ...string) (string, error) {
+		calls = append(calls, strings.Join(args, " "))
+		if args[2] == "--get" && args[3] == "https.proxy" {
+			return "", errors.New("exit status 1")
+		}
+		return "http://old:1\n", nil
+	}
+	if _, err := Unset("git"); err != nil {
+		t.Fatalf("Unset: %v", err)
+	}
+	want := []string{
+		"config --global --get http.proxy",
+		"config --global --unset http.proxy",
+		"config --global --get https.proxy",
+	}
+	if strings.Join(calls, "|") != strings.Join(want, "|") {
+		t.Fatalf("unexpected git calls: %v", calls)
+	}
+}
+
+func TestGetReadsWhatSetWrote(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("NPM_CONFIG_USERCONFIG", filepath.Join(dir, ".npmrc"))
+	t.Setenv("PIP_CONFIG_FILE", filepath.Join(dir, "pip.conf"))
+	for _, tool := range []string{"npm", "pip"} {
+		if got, err := Get(tool); err != nil || got != "" {
+			t.Fatalf("%s before Set = %q, %v", tool, got, err)
+		}
+		if _, err := Set(tool, "http://u:p@203.0.113.5:8080"); err != nil {
+			t.Fatalf("%s Set: %v", tool, err)
+		}
+		if got, err := Get(tool); err != nil || got != "http://u:p@203.0.113.5:8080" {
+			t.Fatalf("%s after Set = %q, %v", tool, got, err)
+		}
+	}
+}
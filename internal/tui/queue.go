@@ -0,0 +1,433 @@
+package tui
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alfaoz/beammeup/internal/hangar"
+	"github.com/alfaoz/beammeup/internal/history"
+	"github.com/alfaoz/beammeup/internal/ships"
+	"github.com/alfaoz/beammeup/internal/sshx"
+	"github.com/charmbracelet/huh"
+	"golang.org/x/crypto/ssh"
+)
+
+type queueState string
+
+const (
+	queuePending   queueState = "pending"
+	queueRunning   queueState = "running"
+	queueDone      queueState = "done"
+	queueFailed    queueState = "failed"
+	queueCancelled queueState = "cancelled"
+)
+
+// queueItem is one operation waiting in, or finished by, the queue.
+type queueItem struct {
+	ID       int
+	Ship     ships.Ship
+	Label    string // e.g. "rotate credentials"
+	In       hangar.ActionInput
+	State    queueState
+	Result   hangar.ActionResult
+	Err      error
+	Started  time.Time
+	Finished time.Time
+
+	password  string
+	collected bool // result folded into the cockpit's state
+}
+
+// opQueue runs queued hangar operations one at a time in the background, so
+// the cockpit stays usable while they work through several ships. Only
+// pending items can be cancelled; a running one finishes.
+type opQueue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	items  []*queueItem
+	nextID int
+	run    func(it *queueItem) (hangar.ActionResult, error)
+	once   sync.Once
+}
+
+func newOpQueue(run func(it *queueItem) (hangar.ActionResult, error)) *opQueue {
+	q := &opQueue{run: run}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// Add queues in for ship and starts the worker on first use.
+func (q *opQueue) Add(ship ships.Ship, password, label string, in hangar.ActionInput) int {
+	q.once.Do(func() { go q.work() })
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.nextID++
+	q.items = append(q.items, &queueItem{ID: q.nextID, Ship: ship, Label: label, In: in, State: queuePending, password: password})
+	q.cond.Signal()
+	return q.nextID
+}
+
+// Cancel drops the pending items with the given IDs and returns how many it
+// cancelled.
+func (q *opQueue) Cancel(ids ...int) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := 0
+	for _, it := range q.items {
+		for _, id := range ids {
+			if it.ID == id && it.State == queuePending {
+				it.State = queueCancelled
+				it.Finished = time.Now()
+				it.password = ""
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// ClearFinished forgets cancelled items and finished ones already collected.
+func (q *opQueue) ClearFinished() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	kept := q.items[:0]
+	for _, it := range q.items {
+		finished := it.State == queueDone || it.State == queueFailed
+		if it.State == queuePending || it.State == queueRunning || (finished && !it.collected) {
+			kept = append(kept, it)
+		}
+	}
+	q.items = kept
+}
+
+// Snapshot copies the items, oldest first.
+func (q *opQueue) Snapshot() []queueItem {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	out := make([]queueItem, len(q.items))
+	for i, it := range q.items {
+		out[i] = *it
+	}
+	return out
+}
+
+// Collect returns the items finished since the last call.
+func (q *opQueue) Collect() []queueItem {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var out []queueItem
+	for _, it := range q.items {
+		if !it.collected && (it.State == queueDone || it.State == queueFailed) {
+			it.collected = true
+			out = append(out, *it)
+		}
+	}
+	return out
+}
+
+// Counts reports how many items are pending and running.
+func (q *opQueue) Counts() (pending, running int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, it := range q.items {
+		switch it.State {
+		case queuePending:
+			pending++
+		case queueRunning:
+			running++
+		}
+	}
+	return pending, running
+}
+
+func (q *opQueue) work() {
+	for {
+		q.mu.Lock()
+		var it *queueItem
+		for it == nil {
+			for _, c := range q.items {
+				if c.State == queuePending {
+					it = c
+					break
+				}
+			}
+			if it == nil {
+				q.cond.Wait()
+			}
+		}
+		it.State = queueRunning
+		it.Started = time.Now()
+		q.mu.Unlock()
+
+		res, err := q.run(it)
+
+		q.mu.Lock()
+		it.Result, it.Err = res, err
+		it.State = queueDone
+		if err != nil {
+			it.State = queueFailed
+		}
+		it.Finished = time.Now()
+		it.password = ""
+		q.mu.Unlock()
+	}
+}
+
+// line renders it for the queue panel. position is its place among the
+// pending items, from 1.
+func (it queueItem) line(now time.Time, position int) string {
+	head := fmt.Sprintf("#%d %s :: %s", it.ID, it.Label, it.Ship.Name)
+	switch it.State {
+	case queuePending:
+		return fmt.Sprintf("%s  pending (%d in line)", head, position)
+	case queueRunning:
+		return fmt.Sprintf("%s  running %s", head, now.Sub(it.Started).Round(time.Second))
+	case queueDone:
+		return fmt.Sprintf("%s  done in %s", head, it.Finished.Sub(it.Started).Round(time.Second))
+	case queueFailed:
+		msg, _, _ := strings.Cut(it.Err.Error(), "\n")
+		return fmt.Sprintf("%s  failed: %s", head, msg)
+	}
+	return head + "  cancelled"
+}
+
+// runQueued is the queue's worker side. It only talks to the hangar service
+// and the new proxy; the cockpit's state is updated from collectQueue on the
+// UI goroutine.
+func (a *App) runQueued(it *queueItem) (hangar.ActionResult, error) {
+	// Queued operations run behind the menus, where no prompt can show up;
+	// servers that ask for a one-time code need BEAMMEUP_SSH_OTP_COMMAND, and
+	// a new server's host key has to be confirmed from its cockpit first.
+	svc := *a.HangarSvc
+	svc.SSH.Prompt = nil
+	svc.SSH.ConfirmHostKey = func(sshx.Target, ssh.PublicKey) error {
+		return errors.New("open the ship's cockpit once to confirm it")
+	}
+	if it.In.Mode == "inventory" {
+		inv, err := svc.Inventory(it.Ship, it.password)
+		return hangar.ActionResult{Inventory: inv}, err
+	}
+	res, err := svc.Execute(it.Ship, it.password, it.In)
+	if err == nil {
+		res.Reachable = reachFromHere(it.Ship, res)
+	}
+	return res, err
+}
+
+// collectQueue folds finished queue items into the ship statuses, host
+// reports and result cards.
+func (a *App) collectQueue() {
+	if a.queue == nil {
+		return
+	}
+	for _, it := range a.queue.Collect() {
+		e, ok := history.Action(it.In, it.Err)
+		if it.In.Mode == "inventory" {
+			e, ok = history.Check(it.Result.Inventory.HangarStatus, it.Finished.Sub(it.Started), it.Err), true
+		}
+		if ok {
+			e.Time = it.Finished
+			a.recordHistory(it.Ship.Name, e)
+		}
+		if it.Err != nil {
+			continue
+		}
+		if st := it.Result.Inventory.HangarStatus; st != "" {
+			a.status[it.Ship.Name] = st
+		}
+		if it.In.Mode == "inventory" {
+			if host := it.Result.Inventory.Host; !host.IsZero() {
+				_ = a.Store.SaveHostInfo(it.Ship.Name, host)
+			}
+			continue
+		}
+		a.storeCard(it.Ship, it.Result)
+	}
+}
+
+// queueCounts is opQueue.Counts, zero before anything was queued.
+func (a *App) queueCounts() (pending, running int) {
+	if a.queue == nil {
+		return 0, 0
+	}
+	return a.queue.Counts()
+}
+
+// queueSummary is the main deck's line about the queue, or "" when idle.
+func (a *App) queueSummary() string {
+	pending, running := a.queueCounts()
+	if pending == 0 && running == 0 {
+		return ""
+	}
+	return fmt.Sprintf("queue: %d running, %d pending", running, pending)
+}
+
+// queueOperations asks for an operation and the ships to run it on, then
+// queues one item per ship. SSH passwords are asked for up front so the
+// queue never prompts.
+func (a *App) queueOperations(shipNames []string) error {
+	op := ""
+	opts := []huh.Option[string]{huh.NewOption("Refresh Status", "inventory")}
+	if !a.HangarSvc.ReadOnly {
+		opts = append(opts,
+			huh.NewOption("Rotate Credentials", "rotate"),
+			huh.NewOption("Rotate Password Only", "rotate-password"),
+			huh.NewOption("Re-apply Configuration", "configure"),
+		)
+	}
+	opts = append(opts, huh.NewOption("Back", ""))
+	if err := huh.NewSelect[string]().Title("queue operation").Options(opts...).Value(&op).Run(); err != nil {
+		if isUserCancelled(err) {
+			return errUserCancelled
+		}
+		return err
+	}
+	if op == "" {
+		return nil
+	}
+
+	var picked []string
+	shipOpts := make([]huh.Option[string], 0, len(shipNames))
+	for _, name := range shipNames {
+		shipOpts = append(shipOpts, huh.NewOption(fmt.Sprintf("%s  [%s]", name, a.statusBadge(name)), name))
+	}
+	if err := huh.NewMultiSelect[string]().Title("on which ships? (space selects)").Options(shipOpts...).Value(&picked).Run(); err != nil {
+		if isUserCancelled(err) {
+			return errUserCancelled
+		}
+		return err
+	}
+	if len(picked) == 0 {
+		return nil
+	}
+
+	if a.queue == nil {
+		a.queue = newOpQueue(a.runQueued)
+	}
+	queued := 0
+	for _, name := range picked {
+		ship, err := a.Store.Load(name)
+		if err != nil {
+			a.note("load failed", err.Error())
+			continue
+		}
+		pwd, err := a.passwordForShip(ship)
+		if err != nil {
+			if errors.Is(err, errUserCancelled) {
+				continue
+			}
+			return err
+		}
+		label, in := "refresh status", hangar.ActionInput{Mode: "inventory"}
+		switch op {
+		case "rotate":
+			label, in = "rotate credentials", applyInput(ship, true)
+		case "rotate-password":
+			label, in = "rotate password", applyInput(ship, true)
+			in.RotateScope = "password"
+		case "configure":
+			label, in = "re-apply configuration", applyInput(ship, false)
+		}
+		a.queue.Add(ship, pwd, label, in)
+		queued++
+	}
+	a.note("queued", fmt.Sprintf("%d operation(s) queued. follow them under Operation Queue.", queued))
+	return nil
+}
+
+// queuePanel shows the queue with per-item progress and lets pending items
+// be cancelled. The panel is a snapshot; Refresh redraws it.
+func (a *App) queuePanel() error {
+	for {
+		a.collectQueue()
+		items := a.queue.Snapshot()
+		now := time.Now()
+		lines := make([]string, 0, len(items))
+		position := 0
+		var pending, finished []queueItem
+		for _, it := range items {
+			if it.State == queuePending {
+				position++
+				pending = append(pending, it)
+			}
+			if it.State == queueDone || it.State == queueFailed {
+				finished = append(finished, it)
+			}
+			lines = append(lines, it.line(now, position))
+		}
+		if len(lines) == 0 {
+			lines = append(lines, "nothing queued")
+		}
+
+		choice := ""
+		opts := []huh.Option[string]{huh.NewOption("Refresh", "refresh")}
+		if len(pending) > 0 {
+			opts = append(opts, huh.NewOption("Cancel Pending Items", "cancel"))
+		}
+		if len(finished) > 0 {
+			opts = append(opts, huh.NewOption("Show Result", "result"), huh.NewOption("Clear Finished", "clear"))
+		}
+		opts = append(opts, huh.NewOption("Back to Main Deck", "back"))
+		if err := huh.NewSelect[string]().
+			Title("operation queue").
+			Description(strings.Join(lines, "\n")).
+			Options(opts...).
+			Value(&choice).
+			Run(); err != nil {
+			if isUserCancelled(err) {
+				return nil
+			}
+			return err
+		}
+
+		switch choice {
+		case "cancel":
+			var ids []int
+			itemOpts := make([]huh.Option[int], 0, len(pending))
+			for _, it := range pending {
+				itemOpts = append(itemOpts, huh.NewOption(fmt.Sprintf("#%d %s :: %s", it.ID, it.Label, it.Ship.Name), it.ID))
+			}
+			if err := huh.NewMultiSelect[int]().Title("cancel which items? (space selects)").Options(itemOpts...).Value(&ids).Run(); err != nil {
+				if isUserCancelled(err) {
+					continue
+				}
+				return err
+			}
+			if n := a.queue.Cancel(ids...); n < len(ids) {
+				a.note("cancel", fmt.Sprintf("%d item(s) cancelled; the rest had already started.", n))
+			}
+		case "result":
+			id := 0
+			itemOpts := make([]huh.Option[int], 0, len(finished))
+			for _, it := range finished {
+				itemOpts = append(itemOpts, huh.NewOption(fmt.Sprintf("#%d %s :: %s (%s)", it.ID, it.Label, it.Ship.Name, it.State), it.ID))
+			}
+			if err := huh.NewSelect[int]().Title("show which result?").Options(itemOpts...).Value(&id).Run(); err != nil {
+				if isUserCancelled(err) {
+					continue
+				}
+				return err
+			}
+			for _, it := range finished {
+				if it.ID != id {
+					continue
+				}
+				switch {
+				case it.Err != nil:
+					a.note(fmt.Sprintf("#%d %s failed", it.ID, it.Label), it.Err.Error())
+				case it.In.Mode == "inventory":
+					a.showInventoryCard(it.Ship, it.Result.Inventory)
+				default:
+					a.resultNote(it.Ship, it.Result)
+				}
+			}
+		case "clear":
+			a.queue.ClearFinished()
+		case "back":
+			return nil
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package tui
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alfaoz/beammeup/internal/hangar"
+	"github.com/alfaoz/beammeup/internal/ships"
+)
+
+func TestOpQueueRunsInOrderAndCancelsPending(t *testing.T) {
+	release := make(chan struct{})
+	var ran []string
+	q := newOpQueue(func(it *queueItem) (hangar.ActionResult, error) {
+		if it.password != "pw-"+it.Ship.Name {
+			t.Errorf("item %d got password %q", it.ID, it.password)
+		}
+		<-release
+		ran = append(ran, it.Ship.Name)
+		if it.Ship.Name == "b" {
+			return hangar.ActionResult{}, errors.New("boom")
+		}
+		return hangar.ActionResult{User: "u"}, nil
+	})
+	for _, name := range []string{"a", "b", "c"} {
+		q.Add(ships.Ship{Name: name}, "pw-"+name, "rotate credentials", hangar.ActionInput{Mode: "apply"})
+	}
+	waitFor(t, func() bool { _, running := q.Counts(); return running == 1 })
+	if n := q.Cancel(1, 3); n != 1 {
+		t.Fatalf("Cancel = %d, want only the pending item", n)
+	}
+	release <- struct{}{}
+	release <- struct{}{}
+	waitFor(t, func() bool { p, r := q.Counts(); return p == 0 && r == 0 })
+
+	if len(ran) != 2 || ran[0] != "a" || ran[1] != "b" {
+		t.Fatalf("ran %v", ran)
+	}
+	got := q.Collect()
+	if len(got) != 2 || got[0].State != queueDone || got[1].State != queueFailed || got[1].Err == nil {
+		t.Fatalf("Collect = %+v", got)
+	}
+	if len(q.Collect()) != 0 {
+		t.Fatalf("items collected twice")
+	}
+	if line := got[1].line(time.Now(), 0); line != "#2 rotate credentials :: b  failed: boom" {
+		t.Fatalf("line = %q", line)
+	}
+	q.ClearFinished()
+	if items := q.Snapshot(); len(items) != 0 {
+		t.Fatalf("ClearFinished kept %+v", items)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -4,25 +4,45 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"os"
 	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/alfaoz/beammeup/internal/config"
+	"github.com/alfaoz/beammeup/internal/creds"
+	"github.com/alfaoz/beammeup/internal/exports"
+	"github.com/alfaoz/beammeup/internal/geoip"
 	"github.com/alfaoz/beammeup/internal/hangar"
+	"github.com/alfaoz/beammeup/internal/history"
+	"github.com/alfaoz/beammeup/internal/hostkey"
+	"github.com/alfaoz/beammeup/internal/notify"
+	"github.com/alfaoz/beammeup/internal/probe"
 	"github.com/alfaoz/beammeup/internal/session"
 	"github.com/alfaoz/beammeup/internal/ships"
 	"github.com/alfaoz/beammeup/internal/sshx"
 	"github.com/alfaoz/beammeup/internal/tunnel"
+	"github.com/alfaoz/beammeup/internal/usage"
 	"github.com/charmbracelet/huh"
+	"golang.org/x/crypto/ssh"
 )
 
 type App struct {
 	Store     *ships.Store
 	HangarSvc *hangar.Service
 	Secrets   *session.PasswordCache
+	Usage     *usage.Store
+	History   *history.Store
+	Creds     *creds.Store
+	Notifier  notify.Notifier
+	Config    config.Config // client config: the leak check of new proxy passwords
+	StartShip string        // cockpit to open before the main deck (--last); empty starts on the main deck
 	status    map[string]hangar.Status
+	traffic   map[string]usage.Level
+	queue     *opQueue
 }
 
 var (
@@ -31,10 +51,18 @@ var (
 )
 
 func New(store *ships.Store, svc *hangar.Service, sec *session.PasswordCache) *App {
-	return &App{Store: store, HangarSvc: svc, Secrets: sec, status: map[string]hangar.Status{}}
+	return &App{Store: store, HangarSvc: svc, Secrets: sec, status: map[string]hangar.Status{}, traffic: map[string]usage.Level{}}
 }
 
 func (a *App) Run() error {
+	a.HangarSvc.SSH.HostKeyChanged = a.hostKeys().Changed
+	a.HangarSvc.SSH.Prompt = a.promptSSH
+	a.HangarSvc.SSH.ConfirmHostKey = a.confirmHostKey
+	if a.StartShip != "" {
+		if err := a.continueShip(a.StartShip); err != nil {
+			return err
+		}
+	}
 	for {
 		shipNames, err := a.Store.List()
 		if err != nil {
@@ -50,18 +78,31 @@ func (a *App) Run() error {
 			continue
 		}
 
+		a.collectQueue()
 		description := a.mainDeckDescription(shipNames)
+		title := "beammeup :: main deck"
+		if a.HangarSvc.ReadOnly {
+			title += " (read-only)"
+		}
 
 		choice := ""
+		var deck []huh.Option[string]
+		last := a.Store.Last()
+		if last != "" {
+			deck = append(deck, huh.NewOption("Continue: "+last, "continue"))
+		}
+		deck = append(deck,
+			huh.NewOption("Select Ship", "select"),
+			huh.NewOption("Create Ship", "create"),
+			huh.NewOption("Queue Operation", "queue"),
+			huh.NewOption("Operation Queue", "queue-panel"),
+			huh.NewOption("Abandon Ship", "abandon"),
+			huh.NewOption("Exit", "exit"),
+		)
 		if err := huh.NewSelect[string]().
-			Title("beammeup :: main deck").
+			Title(title).
 			Description(description).
-			Options(
-				huh.NewOption("Select Ship", "select"),
-				huh.NewOption("Create Ship", "create"),
-				huh.NewOption("Abandon Ship", "abandon"),
-				huh.NewOption("Exit", "exit"),
-			).
+			Options(deck...).
 			Value(&choice).
 			Run(); err != nil {
 			if isUserCancelled(err) {
@@ -71,6 +112,10 @@ func (a *App) Run() error {
 		}
 
 		switch choice {
+		case "continue":
+			if err := a.continueShip(last); err != nil {
+				return err
+			}
 		case "select":
 			name, err := a.pickShip(shipNames)
 			if err != nil {
@@ -142,10 +187,27 @@ func (a *App) Run() error {
 				} else {
 					a.Secrets.Forget(name)
 					delete(a.status, name)
+					delete(a.traffic, name)
 					a.note("ship abandoned", "local profile deleted")
 				}
 			}
+		case "queue":
+			if err := a.queueOperations(shipNames); err != nil && !errors.Is(err, errUserCancelled) {
+				return err
+			}
+		case "queue-panel":
+			if a.queue == nil {
+				a.note("operation queue", "nothing queued yet. use Queue Operation to run one on several ships.")
+				continue
+			}
+			if err := a.queuePanel(); err != nil {
+				return err
+			}
 		case "exit":
+			if pending, running := a.queueCounts(); pending+running > 0 &&
+				!a.confirm(fmt.Sprintf("%d queued operation(s) have not finished. exit anyway?", pending+running)) {
+				continue
+			}
 			return nil
 		}
 	}
@@ -192,25 +254,49 @@ func (a *App) mainDeckDescription(shipNames []string) string {
 	if strings.TrimSpace(lines) == "" {
 		lines = "persistent cockpit"
 	}
+	if q := a.queueSummary(); q != "" {
+		lines += "\n\n" + q
+	}
 	return logoText() + "\n\n" + lines
 }
 
+// continueShip opens the cockpit of the named ship, where the last session
+// left off.
+func (a *App) continueShip(name string) error {
+	ship, err := a.Store.Load(name)
+	if err != nil {
+		a.note("load failed", err.Error())
+		return nil
+	}
+	if err := a.shipCockpit(ship); err != nil {
+		a.note("error", err.Error())
+	}
+	return nil
+}
+
 func (a *App) shipCockpit(ship ships.Ship) error {
+	_ = a.Store.SetLast(ship.Name)
 	for {
 		status := a.statusBadge(ship.Name)
 		choice := ""
 		title := fmt.Sprintf("ship cockpit :: %s (%s)", ship.Name, status)
+		options := []huh.Option[string]{
+			huh.NewOption("Launch", "launch"),
+			huh.NewOption("Launch (Stealth)", "stealth"),
+			huh.NewOption("Hangar", "hangar"),
+			huh.NewOption("Ship Info", "info"),
+			huh.NewOption("Ports", "ports"),
+			huh.NewOption("Edit Ship", "edit"),
+			huh.NewOption("Forget Session Password", "forget"),
+			huh.NewOption("Abandon Ship", "abandon"),
+			huh.NewOption("Back to Main Deck", "back"),
+		}
+		if saved, err := a.Store.Load(ship.Name); err == nil && saved.HostKeyChanged != "" {
+			options = append([]huh.Option[string]{huh.NewOption("Trust New Host Key", "trust-host")}, options...)
+		}
 		if err := huh.NewSelect[string]().
 			Title(title).
-			Options(
-				huh.NewOption("Launch", "launch"),
-				huh.NewOption("Launch (Stealth)", "stealth"),
-				huh.NewOption("Hangar", "hangar"),
-				huh.NewOption("Edit Ship", "edit"),
-				huh.NewOption("Forget Session Password", "forget"),
-				huh.NewOption("Abandon Ship", "abandon"),
-				huh.NewOption("Back to Main Deck", "back"),
-			).
+			Options(options...).
 			Value(&choice).
 			Run(); err != nil {
 			if isUserCancelled(err) {
@@ -232,6 +318,14 @@ func (a *App) shipCockpit(ship ships.Ship) error {
 			if err := a.hangarMenu(ship); err != nil {
 				a.note("hangar error", err.Error())
 			}
+		case "info":
+			if err := a.shipInfo(ship); err != nil {
+				a.note("ship info failed", err.Error())
+			}
+		case "ports":
+			if err := a.showPorts(ship); err != nil {
+				a.note("ports failed", err.Error())
+			}
 		case "edit":
 			updated, err := a.createShipForm(ship)
 			if err != nil {
@@ -248,12 +342,17 @@ func (a *App) shipCockpit(ship ships.Ship) error {
 						a.Secrets.Forget(ship.Name)
 					}
 					delete(a.status, ship.Name)
+					delete(a.traffic, ship.Name)
 				}
 				ship = updated
 			}
 		case "forget":
 			a.Secrets.Forget(ship.Name)
 			a.note("forgotten", "session password removed")
+		case "trust-host":
+			if err := a.trustHostKey(ship); err != nil {
+				a.note("trust host key failed", err.Error())
+			}
 		case "abandon":
 			if a.confirm("abandon ship " + ship.Name + "?") {
 				if err := a.Store.Delete(ship.Name); err != nil {
@@ -262,6 +361,7 @@ func (a *App) shipCockpit(ship ships.Ship) error {
 				}
 				a.Secrets.Forget(ship.Name)
 				delete(a.status, ship.Name)
+				delete(a.traffic, ship.Name)
 				a.note("ship abandoned", "local profile deleted")
 				return nil
 			}
@@ -280,6 +380,9 @@ func (a *App) hangarMenu(ship ships.Ship) error {
 				huh.NewOption("Show Configuration", "show"),
 				huh.NewOption("Configure/Repair", "configure"),
 				huh.NewOption("Rotate Credentials", "rotate"),
+				huh.NewOption("Rotate Password Only", "rotate-password"),
+				huh.NewOption("Traffic Usage", "usage"),
+				huh.NewOption("Stored Credentials", "creds"),
 				huh.NewOption("Destroy Hangar", "destroy"),
 				huh.NewOption("Back", "back"),
 			).
@@ -301,7 +404,21 @@ func (a *App) hangarMenu(ship ships.Ship) error {
 			}
 			a.status[ship.Name] = inv.HangarStatus
 			a.showInventoryCard(ship, inv)
-		case "configure", "rotate":
+		case "usage":
+			if err := a.showTrafficUsage(ship); err != nil {
+				if errors.Is(err, errUserCancelled) {
+					continue
+				}
+				a.note("traffic usage failed", err.Error())
+			}
+		case "creds":
+			if err := a.showStoredCard(ship); err != nil {
+				if errors.Is(err, errUserCancelled) {
+					continue
+				}
+				a.note("stored credentials", err.Error())
+			}
+		case "configure", "rotate", "rotate-password":
 			updated, err := a.configurePrompt(ship)
 			if err != nil {
 				if errors.Is(err, errUserCancelled) {
@@ -310,16 +427,9 @@ func (a *App) hangarMenu(ship ships.Ship) error {
 				return err
 			}
 			ship = updated
-			in := hangar.ActionInput{
-				Mode:                    "apply",
-				Protocol:                ship.Protocol,
-				HTTPMode:                ship.HTTPMode,
-				ProxyPort:               ship.ProxyPort,
-				NoFirewallChange:        ship.NoFirewallChange,
-				ListenLocal:             ship.ListenLocal,
-				SmartBlinder:            ship.SmartBlinder,
-				SmartBlinderIdleMinutes: ship.SmartBlinderIdleMinutes,
-				RotateCredentials:       choice == "rotate",
+			in := applyInput(ship, choice != "configure")
+			if choice == "rotate-password" {
+				in.RotateScope = "password"
 			}
 			res, err := a.execWithPassword(ship, in)
 			if err != nil {
@@ -366,6 +476,7 @@ func (a *App) hangarMenu(ship ships.Ship) error {
 				return err
 			}
 			a.status[ship.Name] = hangar.StatusMissing
+			a.storeCard(ship, res)
 			a.note("destroy hangar complete", fallback(res.Note, "remote configuration removed"))
 			if a.confirm("abandon ship too?") {
 				if err := a.Store.Delete(ship.Name); err != nil {
@@ -373,6 +484,7 @@ func (a *App) hangarMenu(ship ships.Ship) error {
 				}
 				a.Secrets.Forget(ship.Name)
 				delete(a.status, ship.Name)
+				delete(a.traffic, ship.Name)
 				a.note("ship abandoned", "local .ship deleted")
 				return nil
 			}
@@ -382,6 +494,38 @@ func (a *App) hangarMenu(ship ships.Ship) error {
 	}
 }
 
+// applyInput is the apply of ship's saved settings, optionally rotating the
+// credentials.
+func applyInput(ship ships.Ship, rotate bool) hangar.ActionInput {
+	return hangar.ActionInput{
+		Mode:                    "apply",
+		Protocol:                ship.Protocol,
+		HTTPMode:                ship.HTTPMode,
+		ProxyPort:               ship.ProxyPort,
+		NoFirewallChange:        ship.NoFirewallChange,
+		ListenLocal:             ship.ListenLocal,
+		SmartBlinder:            ship.SmartBlinder,
+		SmartBlinderIdleMinutes: ship.SmartBlinderIdleMinutes,
+		TrafficCapGB:            ship.TrafficCapGB,
+		QuietHours:              ship.QuietHours,
+		Label:                   ship.HangarLabel,
+		Description:             ship.HangarDescription,
+		MaxConns:                ship.MaxConns,
+		RateLimit:               ship.RateLimit,
+		MemoryMax:               ship.MemoryMax,
+		CPUQuota:                ship.CPUQuota,
+		TasksMax:                ship.TasksMax,
+		EgressIP:                ship.EgressIP,
+		SocketActivation:        ship.SocketActivation,
+		CacheMB:                 ship.CacheMB,
+		CacheDir:                ship.CacheDir,
+		Anonymity:               ship.Anonymity,
+		AllowDomains:            ship.AllowDomains,
+		DenyDomains:             ship.DenyDomains,
+		RotateCredentials:       rotate,
+	}
+}
+
 func (a *App) launchShip(ship ships.Ship) error {
 	inv, err := a.inventoryWithPassword(ship)
 	if err != nil {
@@ -421,6 +565,20 @@ func (a *App) launchShip(ship ships.Ship) error {
 			}
 		}
 		return a.ensureHangarCreated(ship, false)
+	case hangar.StatusTampered:
+		a.note("hangar metadata tampered", "hangar.json on the server is missing or does not match the signature this machine stored.\nsomething other than beammeup changed it. review the server, then run Configure/Repair to re-sign it.")
+		a.showInventoryCard(ship, inv)
+		return nil
+	case hangar.StatusUnverified:
+		a.note("hangar metadata unverified", "hangar.json on the server was signed by a key this machine does not have,\ne.g. another machine managing the same server. run Configure/Repair to sign it here if that is expected.")
+		a.showInventoryCard(ship, inv)
+		return nil
+	case hangar.StatusQuietHours:
+		a.note("quiet hours", fmt.Sprintf("the server stopped the proxy for its quiet hours (%s).\nit starts again when the window ends, or change the schedule in Edit Ship and run Configure/Repair.", hangar.QuietHoursLabel(inv.QuietHours)))
+		return nil
+	case hangar.StatusQuotaExceeded:
+		a.note("traffic cap reached", fmt.Sprintf("the server paused the proxy after reaching its %d GB monthly cap.\nit resumes next month, or raise the cap in Edit Ship and run Configure/Repair.", inv.TrafficCapGB))
+		return nil
 	default:
 		a.showInventoryCard(ship, inv)
 		return nil
@@ -442,12 +600,7 @@ func (a *App) launchStealth(ship ships.Ship) error {
 	}
 	localAddr := fmt.Sprintf("127.0.0.1:%d", localPort)
 
-	target := sshx.Target{
-		Host:     ship.Host,
-		Port:     ship.SSHPort,
-		User:     ship.SSHUser,
-		Password: password,
-	}
+	target := hangar.ShipTarget(ship, password)
 
 	fmt.Printf("\n[beammeup] stealth mode :: %s\n", ship.Name)
 	fmt.Printf("  Local proxy: socks5://%s\n", localAddr)
@@ -463,7 +616,7 @@ func (a *App) launchStealth(ship ships.Ship) error {
 		fmt.Fprintf(os.Stderr, "[stealth] "+format+"\n", args...)
 	}
 
-	if err := tunnel.Run(ctx, target, a.HangarSvc.SSH, localAddr, logf); err != nil {
+	if err := tunnel.Run(ctx, target, a.HangarSvc.SSH, localAddr, "", tunnel.Config{}, logf); err != nil {
 		return err
 	}
 	fmt.Println("\n[beammeup] stealth tunnel closed.")
@@ -493,6 +646,22 @@ func (a *App) ensureHangarCreated(ship ships.Ship, forcePrompt bool) error {
 			ListenLocal:             ship.ListenLocal,
 			SmartBlinder:            ship.SmartBlinder,
 			SmartBlinderIdleMinutes: ship.SmartBlinderIdleMinutes,
+			TrafficCapGB:            ship.TrafficCapGB,
+			QuietHours:              ship.QuietHours,
+			Label:                   ship.HangarLabel,
+			Description:             ship.HangarDescription,
+			MaxConns:                ship.MaxConns,
+			RateLimit:               ship.RateLimit,
+			MemoryMax:               ship.MemoryMax,
+			CPUQuota:                ship.CPUQuota,
+			TasksMax:                ship.TasksMax,
+			EgressIP:                ship.EgressIP,
+			SocketActivation:        ship.SocketActivation,
+			CacheMB:                 ship.CacheMB,
+			CacheDir:                ship.CacheDir,
+			Anonymity:               ship.Anonymity,
+			AllowDomains:            ship.AllowDomains,
+			DenyDomains:             ship.DenyDomains,
 		})
 		if err != nil {
 			handled, _, fallbackErr := a.handleHTTPConflictWizard(ship, protocol, port, err)
@@ -577,6 +746,12 @@ func (a *App) configurePrompt(ship ships.Ship) (ships.Ship, error) {
 	} else {
 		httpMode = ""
 	}
+	// An instance is an HTTP sidecar; switching the ship to SOCKS5 leaves it.
+	if protocol != "http" {
+		ship.Instance = ""
+	} else if ship.Instance != "" {
+		httpMode = "sidecar"
+	}
 
 	port, err := strconv.Atoi(strings.TrimSpace(portStr))
 	if err != nil || port <= 0 {
@@ -610,40 +785,61 @@ func (a *App) configurePrompt(ship ships.Ship) (ships.Ship, error) {
 }
 
 func (a *App) createShipForm(existing ships.Ship) (ships.Ship, error) {
-	ship := existing
-	name := ship.Name
-	host := ship.Host
-	sshPort := strconv.Itoa(nonZero(ship.SSHPort, 22))
-	sshUser := fallback(ship.SSHUser, "root")
-	protocol := fallback(ship.Protocol, "http")
-	httpMode := normalizeHTTPMode(ship.HTTPMode)
-	proxyPort := strconv.Itoa(nonZero(ship.ProxyPort, defaultProxy(protocol)))
-	noFW := ship.NoFirewallChange
-	listenLocal := ship.ListenLocal
-	smartBlinder := ship.SmartBlinder
-	if ship.Name == "" && !ship.SmartBlinder {
-		smartBlinder = true
-	}
-	idleMinStr := strconv.Itoa(nonZero(ship.SmartBlinderIdleMinutes, 10))
-
+	f := newShipForm(existing)
 	group := huh.NewGroup(
-		huh.NewInput().Title("Ship name").Value(&name),
-		huh.NewInput().Title("Target server host/IP").Value(&host),
-		huh.NewInput().Title("SSH port").Value(&sshPort),
-		huh.NewInput().Title("SSH user").Value(&sshUser),
+		huh.NewInput().Title("Ship name").Value(&f.name),
+		huh.NewInput().Title("Target server host/IP").Value(&f.host),
+		huh.NewInput().Title("SSH port").Value(&f.sshPort),
+		huh.NewInput().Title("SSH user").Value(&f.sshUser),
 		huh.NewSelect[string]().
 			Title("Default protocol").
 			Options(huh.NewOption("HTTP", "http"), huh.NewOption("SOCKS5", "socks5")).
-			Value(&protocol),
-		huh.NewInput().Title("Default proxy port").Value(&proxyPort),
+			Value(&f.protocol),
+		huh.NewInput().Title("Default proxy port").Value(&f.proxyPort),
 		huh.NewConfirm().
 			Title("Bind proxy to localhost only (requires SSH tunnel)?").
 			Description("More private: nothing is reachable publicly on the proxy port.").
-			Value(&listenLocal),
+			Value(&f.listenLocal),
 		huh.NewConfirm().
 			Title("Enable smart blinder (idle shutdown)?").
 			Description("Stops the proxy after a period of no-use (recommended).").
-			Value(&smartBlinder),
+			Value(&f.smartBlinder),
+		huh.NewInput().
+			Title("Monthly traffic quota (GB)").
+			Description("Provider allowance used for usage warnings. 0 disables them.").
+			Value(&f.quotaStr),
+		huh.NewInput().
+			Title("Server traffic cap (GB per month)").
+			Description("The server pauses the proxy once this much is transferred. 0 disables the cap.").
+			Value(&f.capStr),
+		huh.NewInput().
+			Title("Quiet hours (UTC, optional)").
+			Description("Windows when the server stops the proxy, e.g. 00:00-06:00. Leave empty for always on.").
+			Value(&f.quietHours),
+		huh.NewInput().
+			Title("Max concurrent connections").
+			Description("Caps what the proxy credential can hold open at once, so a leaked password can't be farmed out. 0 is unlimited.").
+			Value(&f.maxConnsStr),
+		huh.NewInput().
+			Title("New connections per minute").
+			Description("Rate limit on the proxy credential. 0 is unlimited.").
+			Value(&f.rateStr),
+		huh.NewInput().
+			Title("Egress IP (multi-IP servers)").
+			Description("Address the proxy connects out from. Leave empty to use the server's main route.").
+			Value(&f.egressIP),
+		huh.NewInput().
+			Title("Tags (optional)").
+			Description("Comma list of labels for handling ships as a group, e.g. rotation-pool.").
+			Value(&f.tags),
+		huh.NewInput().
+			Title("Hangar label (optional)").
+			Description("Stored in hangar.json on the server, e.g. marketing team proxy. Empty keeps the server's; none clears it.").
+			Value(&f.label),
+		huh.NewInput().
+			Title("Hangar description (optional)").
+			Description("Also stored in hangar.json, e.g. who to contact. Empty keeps the server's; none clears it.").
+			Value(&f.description),
 	)
 
 	if err := huh.NewForm(group).Run(); err != nil {
@@ -653,8 +849,8 @@ func (a *App) createShipForm(existing ships.Ship) (ships.Ship, error) {
 		return ships.Ship{}, err
 	}
 
-	if protocol == "http" {
-		modeChoice := httpMode
+	if f.protocol == "http" {
+		modeChoice := f.httpMode
 		if err := huh.NewSelect[string]().
 			Title("HTTP mode").
 			Description("Auto may manage squid if safe. Sidecar is isolated and never overwrites existing /etc/squid/squid.conf.").
@@ -669,33 +865,159 @@ func (a *App) createShipForm(existing ships.Ship) (ships.Ship, error) {
 			}
 			return ships.Ship{}, err
 		}
-		httpMode = modeChoice
-	} else {
-		httpMode = ""
+		f.httpMode = modeChoice
+		if err := huh.NewForm(huh.NewGroup(
+			huh.NewInput().
+				Title("HTTP instance (optional)").
+				Description("Name a separate sidecar to share this server with other ships. Leave empty for the main hangar.").
+				Value(&f.instance),
+			huh.NewInput().
+				Title("HTTP cache size (MB)").
+				Description("Cache repeated plain-HTTP downloads on the server. 0 keeps caching off.").
+				Value(&f.cacheStr),
+			huh.NewInput().
+				Title("HTTP cache directory (optional)").
+				Description("Absolute path on the server. Leave empty to let beammeup choose.").
+				Value(&f.cacheDir),
+			huh.NewSelect[string]().
+				Title("Header anonymity").
+				Description("What sites learn about the client: elite strips every hint, transparent forwards the client IP.").
+				Options(
+					huh.NewOption("Elite (no proxy headers)", "elite"),
+					huh.NewOption("Anonymous (Via only)", "anonymous"),
+					huh.NewOption("Transparent (forward client IP)", "transparent"),
+				).
+				Value(&f.anonymity),
+			huh.NewInput().
+				Title("Allowed destination domains (optional)").
+				Description("Comma list, e.g. *.example.com. When set, the proxy reaches nothing else.").
+				Value(&f.allowDomains),
+			huh.NewInput().
+				Title("Denied destination domains (optional)").
+				Description("Comma list of destinations the proxy refuses.").
+				Value(&f.denyDomains),
+		)).Run(); err != nil {
+			if isUserCancelled(err) {
+				return ships.Ship{}, errUserCancelled
+			}
+			return ships.Ship{}, err
+		}
 	}
-
-	if listenLocal {
-		noFW = true
-	} else {
-		if err := huh.NewConfirm().Title("Skip firewall changes by default?").Value(&noFW).Run(); err != nil {
+	if !f.listenLocal {
+		if err := huh.NewConfirm().Title("Skip firewall changes by default?").Value(&f.noFW).Run(); err != nil {
 			if isUserCancelled(err) {
 				return ships.Ship{}, errUserCancelled
 			}
 			return ships.Ship{}, err
 		}
 	}
-
-	idleMin := nonZero(ship.SmartBlinderIdleMinutes, 10)
-	if smartBlinder {
-		if err := huh.NewInput().Title("Smart blinder idle minutes").Value(&idleMinStr).Run(); err != nil {
+	if f.smartBlinder {
+		if err := huh.NewInput().Title("Smart blinder idle minutes").Value(&f.idleMinStr).Run(); err != nil {
 			if isUserCancelled(err) {
 				return ships.Ship{}, errUserCancelled
 			}
 			return ships.Ship{}, err
 		}
-		v, err := strconv.Atoi(strings.TrimSpace(idleMinStr))
+	}
+
+	ship, err := f.ship(existing)
+	if err != nil {
+		return ships.Ship{}, err
+	}
+	return a.Store.Save(ship)
+}
+
+// shipForm holds the cockpit's ship form answers as the fields show them.
+type shipForm struct {
+	name, host, sshPort, sshUser             string
+	protocol, httpMode, proxyPort            string
+	noFW, listenLocal, smartBlinder          bool
+	idleMinStr, quotaStr, capStr, quietHours string
+	maxConnsStr, rateStr, egressIP           string
+	instance, cacheStr, cacheDir, anonymity  string
+	allowDomains, denyDomains                string
+	tags, label, description                 string
+}
+
+func newShipForm(ship ships.Ship) shipForm {
+	f := shipForm{
+		name:         ship.Name,
+		host:         ship.Host,
+		sshPort:      strconv.Itoa(nonZero(ship.SSHPort, 22)),
+		sshUser:      fallback(ship.SSHUser, "root"),
+		protocol:     fallback(ship.Protocol, "http"),
+		httpMode:     normalizeHTTPMode(ship.HTTPMode),
+		noFW:         ship.NoFirewallChange,
+		listenLocal:  ship.ListenLocal,
+		smartBlinder: ship.SmartBlinder || ship.Name == "",
+		idleMinStr:   strconv.Itoa(nonZero(ship.SmartBlinderIdleMinutes, 10)),
+		quotaStr:     strconv.Itoa(ship.TrafficQuotaGB),
+		capStr:       strconv.Itoa(ship.TrafficCapGB),
+		quietHours:   ship.QuietHours,
+		maxConnsStr:  strconv.Itoa(ship.MaxConns),
+		rateStr:      strconv.Itoa(ship.RateLimit),
+		egressIP:     ship.EgressIP,
+		instance:     ship.Instance,
+		cacheStr:     strconv.Itoa(ship.CacheMB),
+		cacheDir:     ship.CacheDir,
+		anonymity:    hangar.AnonymityLabel(ship.Anonymity),
+		allowDomains: strings.Join(ship.AllowDomains, ","),
+		denyDomains:  strings.Join(ship.DenyDomains, ","),
+		tags:         strings.Join(ship.Tags, ","),
+		label:        ship.HangarLabel,
+		description:  ship.HangarDescription,
+	}
+	f.proxyPort = strconv.Itoa(nonZero(ship.ProxyPort, defaultProxy(f.protocol)))
+	return f
+}
+
+// ship validates the form's answers. Settings the form doesn't show are
+// carried over from existing.
+func (f shipForm) ship(existing ships.Ship) (ships.Ship, error) {
+	name, httpMode, instance := f.name, f.httpMode, f.instance
+	cacheStr, cacheDir, anonymity := f.cacheStr, f.cacheDir, f.anonymity
+	allowDomains, denyDomains := f.allowDomains, f.denyDomains
+	if f.protocol != "http" {
+		httpMode, instance = "", ""
+		cacheStr, cacheDir, anonymity = "0", "", ""
+		allowDomains, denyDomains = "", ""
+	}
+	if anonymity == "elite" {
+		anonymity = ""
+	}
+	allow, err := hangar.NormalizeDomains(strings.Split(allowDomains, ","))
+	if err != nil {
+		return ships.Ship{}, fmt.Errorf("allowed domains: %w", err)
+	}
+	deny, err := hangar.NormalizeDomains(strings.Split(denyDomains, ","))
+	if err != nil {
+		return ships.Ship{}, fmt.Errorf("denied domains: %w", err)
+	}
+	cacheMB, err := strconv.Atoi(strings.TrimSpace(cacheStr))
+	if err != nil || cacheMB < 0 {
+		return ships.Ship{}, fmt.Errorf("invalid cache size: %s", cacheStr)
+	}
+	cacheDir = strings.TrimSpace(cacheDir)
+	if cacheDir != "" && !strings.HasPrefix(cacheDir, "/") {
+		return ships.Ship{}, fmt.Errorf("cache directory must be an absolute path: %s", cacheDir)
+	}
+	instance = strings.ToLower(strings.TrimSpace(instance))
+	if instance != "" {
+		if !ships.ValidInstanceName(instance) {
+			return ships.Ship{}, fmt.Errorf("invalid instance name: %s (use a-z, 0-9 and -)", instance)
+		}
+		httpMode = "sidecar"
+	}
+
+	noFW := f.noFW
+	if f.listenLocal {
+		noFW = true
+	}
+	idleMin := nonZero(existing.SmartBlinderIdleMinutes, 10)
+	if f.smartBlinder {
+		v, err := strconv.Atoi(strings.TrimSpace(f.idleMinStr))
 		if err != nil || v <= 0 {
-			return ships.Ship{}, fmt.Errorf("invalid smart blinder idle minutes: %s", idleMinStr)
+			return ships.Ship{}, fmt.Errorf("invalid smart blinder idle minutes: %s", f.idleMinStr)
 		}
 		idleMin = v
 	}
@@ -704,29 +1026,96 @@ func (a *App) createShipForm(existing ships.Ship) (ships.Ship, error) {
 	if name == "" {
 		return ships.Ship{}, fmt.Errorf("ship name is required")
 	}
-	port, err := strconv.Atoi(strings.TrimSpace(sshPort))
+	port, err := strconv.Atoi(strings.TrimSpace(f.sshPort))
 	if err != nil || port <= 0 {
 		return ships.Ship{}, fmt.Errorf("invalid ssh port")
 	}
-	proxy, err := strconv.Atoi(strings.TrimSpace(proxyPort))
+	proxy, err := strconv.Atoi(strings.TrimSpace(f.proxyPort))
 	if err != nil || proxy <= 0 {
 		return ships.Ship{}, fmt.Errorf("invalid proxy port")
 	}
+	quota, err := strconv.Atoi(strings.TrimSpace(f.quotaStr))
+	if err != nil || quota < 0 {
+		return ships.Ship{}, fmt.Errorf("invalid traffic quota")
+	}
+	trafficCap, err := strconv.Atoi(strings.TrimSpace(f.capStr))
+	if err != nil || trafficCap < 0 {
+		return ships.Ship{}, fmt.Errorf("invalid traffic cap")
+	}
+	maxConns, err := strconv.Atoi(strings.TrimSpace(f.maxConnsStr))
+	if err != nil || maxConns < 0 {
+		return ships.Ship{}, fmt.Errorf("invalid connection limit: %s", f.maxConnsStr)
+	}
+	rateLimit, err := strconv.Atoi(strings.TrimSpace(f.rateStr))
+	if err != nil || rateLimit < 0 || rateLimit > 10000 {
+		return ships.Ship{}, fmt.Errorf("invalid rate limit: %s (0-10000 per minute)", f.rateStr)
+	}
+	quietHours := strings.TrimSpace(f.quietHours)
+	if quietHours != "" {
+		if quietHours, err = hangar.NormalizeQuietHours(quietHours); err != nil {
+			return ships.Ship{}, fmt.Errorf("invalid quiet hours: %w", err)
+		}
+	}
+	if instance != "" && (trafficCap > 0 || quietHours != "") {
+		return ships.Ship{}, fmt.Errorf("the traffic cap and quiet hours cover the whole server; set them on the default hangar, not instance %s", instance)
+	}
+	egressIP := strings.TrimSpace(f.egressIP)
+	if egressIP != "" && net.ParseIP(egressIP) == nil {
+		return ships.Ship{}, fmt.Errorf("invalid egress IP: %s", egressIP)
+	}
+	label, description := strings.TrimSpace(f.label), strings.TrimSpace(f.description)
+	if err := hangar.CheckHangarInfo(label); err != nil {
+		return ships.Ship{}, fmt.Errorf("invalid hangar label: %w", err)
+	}
+	if err := hangar.CheckHangarInfo(description); err != nil {
+		return ships.Ship{}, fmt.Errorf("invalid hangar description: %w", err)
+	}
 
-	ship = ships.Ship{
+	ship := ships.Ship{
 		Name:                    name,
-		Host:                    strings.TrimSpace(host),
+		Host:                    strings.TrimSpace(f.host),
 		SSHPort:                 port,
-		SSHUser:                 strings.TrimSpace(sshUser),
-		Protocol:                protocol,
+		SSHUser:                 strings.TrimSpace(f.sshUser),
+		IdentityFile:            existing.IdentityFile,
+		Protocol:                f.protocol,
 		HTTPMode:                httpMode,
 		ProxyPort:               proxy,
 		NoFirewallChange:        noFW,
-		ListenLocal:             listenLocal,
-		SmartBlinder:            smartBlinder,
+		ListenLocal:             f.listenLocal,
+		SmartBlinder:            f.smartBlinder,
 		SmartBlinderIdleMinutes: idleMin,
-	}
-	return a.Store.Save(ship)
+		TrafficQuotaGB:          quota,
+		TrafficWarnPercent:      existing.TrafficWarnPercent,
+		TrafficCapGB:            trafficCap,
+		QuietHours:              quietHours,
+		MaxConns:                maxConns,
+		RateLimit:               rateLimit,
+		MemoryMax:               existing.MemoryMax,
+		CPUQuota:                existing.CPUQuota,
+		TasksMax:                existing.TasksMax,
+		EgressIP:                egressIP,
+		SocketActivation:        existing.SocketActivation,
+		Instance:                instance,
+		CacheMB:                 cacheMB,
+		CacheDir:                cacheDir,
+		Anonymity:               anonymity,
+		AllowDomains:            allow,
+		DenyDomains:             deny,
+		Tags:                    ships.ParseTags(f.tags),
+		HangarLabel:             label,
+		HangarDescription:       description,
+		Exports:                 existing.Exports,
+		Forwards:                existing.Forwards,
+		CloudFirewall:           existing.CloudFirewall,
+		CloudFirewallPort:       existing.CloudFirewallPort,
+		ExpiresAt:               existing.ExpiresAt,
+	}
+	// A changed host key stays flagged until trusted, unless the ship now
+	// points at another server.
+	if ship.Host == existing.Host && ship.SSHPort == existing.SSHPort {
+		ship.HostKeyChanged = existing.HostKeyChanged
+	}
+	return ship, nil
 }
 
 func (a *App) pickShip(shipNames []string) (string, error) {
@@ -745,10 +1134,91 @@ func (a *App) pickShip(shipNames []string) (string, error) {
 }
 
 func (a *App) statusBadge(shipName string) string {
+	badge := "unknown"
 	if st, ok := a.status[shipName]; ok {
-		return string(st)
+		badge = string(st)
+	}
+	if lvl := a.traffic[shipName]; lvl != usage.LevelOK {
+		badge += ", " + lvl.String()
 	}
-	return "unknown"
+	ship, err := a.Store.Load(shipName)
+	if err != nil {
+		return badge
+	}
+	if ship.HostKeyChanged != "" {
+		badge += ", key-changed"
+	}
+	if !ship.ExpiresAt.IsZero() {
+		if ship.Expired(time.Now()) {
+			badge += ", expired"
+		} else {
+			badge += ", expires " + ship.ExpiresAt.Local().Format("Jan 2 15:04")
+		}
+	}
+	return badge
+}
+
+// shipInfo shows the host report cached by the last inventory, scanning the
+// server first if there is none yet.
+func (a *App) shipInfo(ship ships.Ship) error {
+	info, err := a.Store.LoadHostInfo(ship.Name)
+	if err != nil {
+		return err
+	}
+	if info.IsZero() {
+		if !a.confirm("no host report cached. scan the server now?") {
+			return nil
+		}
+		inv, err := a.inventoryWithPassword(ship)
+		if err != nil {
+			if errors.Is(err, errUserCancelled) {
+				return nil
+			}
+			return err
+		}
+		info = inv.Host
+	}
+	a.note("ship info :: "+ship.Name, strings.Join(info.Lines(), "\n"))
+	return nil
+}
+
+// showPorts lists what listens on the server, so a proxy port can be picked
+// that nothing else holds and stray services stand out.
+func (a *App) showPorts(ship ships.Ship) error {
+	inv, err := a.inventoryWithPassword(ship)
+	if err != nil {
+		if errors.Is(err, errUserCancelled) {
+			return nil
+		}
+		return err
+	}
+	if len(inv.Listening) == 0 {
+		a.note("ports :: "+ship.Name, "the server reported no listening sockets (is ss installed?)")
+		return nil
+	}
+	lines := []string{}
+	for _, p := range inv.Listening {
+		line := fmt.Sprintf("%-4s %-6d %-18s %s", p.Proto, p.Port, fallback(p.Process, "?"), p.Addr)
+		if !p.Known() && !p.Loopback() {
+			line += "  <- not beammeup"
+		}
+		lines = append(lines, line)
+	}
+	var free []string
+	for _, port := range append(fallbackHTTPPorts(0), fallbackSocksPorts(0)...) {
+		if _, used := inv.PortInUse(port); !used {
+			free = append(free, strconv.Itoa(port))
+		}
+	}
+	lines = append(lines, "")
+	if n := len(inv.Unexpected()); n > 0 {
+		lines = append(lines, fmt.Sprintf("%d reachable listener(s) are not SSH or a beammeup proxy.", n))
+	}
+	if len(free) > 0 {
+		lines = append(lines, "Free proxy ports: "+strings.Join(free, " "))
+	}
+	a.note("ports :: "+ship.Name, strings.Join(lines, "\n"))
+	return nil
 }
 
 func (a *App) inventoryWithPassword(ship ships.Ship) (hangar.Inventory, error) {
@@ -756,11 +1226,25 @@ func (a *App) inventoryWithPassword(ship ships.Ship) (hangar.Inventory, error) {
 	if err != nil {
 		return hangar.Inventory{}, err
 	}
-	inv, err := a.HangarSvc.Inventory(ship, pwd)
+	checked := time.Now()
+	var inv hangar.Inventory
+	err = interruptible(func(ctx context.Context) (err error) {
+		inv, err = a.HangarSvc.InventoryContext(ctx, ship, pwd)
+		return err
+	})
+	a.recordHistory(ship.Name, history.Check(inv.HangarStatus, time.Since(checked), err))
 	if err != nil {
 		return hangar.Inventory{}, err
 	}
 	a.status[ship.Name] = inv.HangarStatus
+	if !inv.Host.IsZero() {
+		_ = a.Store.SaveHostInfo(ship.Name, inv.Host)
+	}
+	// The server's TTL timer is authoritative; keep the local reminder in sync.
+	if saved, err := a.Store.Load(ship.Name); err == nil && !saved.ExpiresAt.Equal(inv.ExpiresAt) {
+		saved.ExpiresAt = inv.ExpiresAt
+		a.Store.Save(saved)
+	}
 	return inv, nil
 }
 
@@ -769,7 +1253,103 @@ func (a *App) execWithPassword(ship ships.Ship, in hangar.ActionInput) (hangar.A
 	if err != nil {
 		return hangar.ActionResult{}, err
 	}
-	return a.HangarSvc.Execute(ship, pwd, in)
+	var res hangar.ActionResult
+	err = interruptible(func(ctx context.Context) (err error) {
+		res, err = a.HangarSvc.ExecuteContext(ctx, ship, pwd, in)
+		return err
+	})
+	if e, ok := history.Action(in, err); ok {
+		a.recordHistory(ship.Name, e)
+	}
+	if err != nil {
+		return res, err
+	}
+	res, warnings := creds.Screen(a.HangarSvc, ship, pwd, in, res, a.Config.LeakCheck, a.Config.LeakCheckURL)
+	if len(warnings) > 0 {
+		a.note("proxy password", strings.Join(warnings, "\n"))
+	}
+	return res, nil
+}
+
+// promptSSH asks what the SSH server wants beyond the password, usually a
+// one-time code (keyboard-interactive).
+func (a *App) promptSSH(t sshx.Target, instruction, question string, echo bool) (string, error) {
+	loaderMu.Lock()
+	defer loaderMu.Unlock()
+	clearLoaderLine()
+	answer := ""
+	input := huh.NewInput().Title(fmt.Sprintf("%s@%s: %s", t.User, t.Host, strings.TrimSpace(question))).Value(&answer)
+	if instruction = strings.TrimSpace(instruction); instruction != "" {
+		input = input.Description(instruction)
+	}
+	if !echo {
+		input = input.EchoMode(huh.EchoModePassword)
+	}
+	if err := input.Run(); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(answer), nil
+}
+
+// confirmHostKey shows a first-seen SSH host key and asks before it goes
+// into known_hosts.
+func (a *App) confirmHostKey(t sshx.Target, key ssh.PublicKey) error {
+	loaderMu.Lock()
+	defer loaderMu.Unlock()
+	clearLoaderLine()
+	ok := false
+	err := huh.NewConfirm().
+		Title("first connection to " + t.Host + ". trust its SSH host key?").
+		Description(fmt.Sprintf("%s %s\n\nCompare it with the server's own key, e.g. from the provider's console:\n  ssh-keygen -lf /etc/ssh/ssh_host_ed25519_key.pub", key.Type(), ssh.FingerprintSHA256(key))).
+		Affirmative("Trust").
+		Negative("Cancel").
+		Value(&ok).
+		Run()
+	if err != nil || !ok {
+		return errors.New("declined")
+	}
+	return nil
+}
+
+// hostKeys flags the saved ships behind a changed SSH host key.
+func (a *App) hostKeys() *hostkey.Guard {
+	return &hostkey.Guard{Store: a.Store, History: a.History, Notifier: a.Notifier}
+}
+
+// trustHostKey shows the key ship's server presents now and, once the user
+// confirms it, trusts it in place of the old one.
+func (a *App) trustHostKey(ship ships.Ship) error {
+	key, err := sshx.ScanHostKey(sshx.Target{Host: ship.Host, Port: ship.SSHPort, User: ship.SSHUser}, a.HangarSvc.SSH)
+	if err != nil {
+		return err
+	}
+	got := ssh.FingerprintSHA256(key)
+	lines := []string{
+		fmt.Sprintf("Server presents: %s %s", key.Type(), got),
+		"",
+		"Compare it with the server's own key, e.g. from the provider's console:",
+		"  ssh-keygen -lf /etc/ssh/ssh_host_ed25519_key.pub",
+	}
+	if saved, err := a.Store.Load(ship.Name); err == nil && saved.HostKeyChanged != "" && saved.HostKeyChanged != got {
+		lines = append(lines, "", fmt.Sprintf("WARNING: the key flagged earlier was %s; it changed again since.", saved.HostKeyChanged))
+	}
+	a.note("host key :: "+ship.Name, strings.Join(lines, "\n"))
+	if !a.confirm("trust " + got + " for " + ship.Host + "?") {
+		return nil
+	}
+	if err := a.hostKeys().Trust(ship, a.HangarSvc.SSH, key); err != nil {
+		return err
+	}
+	a.note("host key trusted", got)
+	return nil
+}
+
+// recordHistory adds e to the ship's status history. The cockpit has no good
+// place for the rare write error, so it is dropped.
+func (a *App) recordHistory(name string, e history.Entry) {
+	if a.History != nil {
+		_ = a.History.Record(name, e)
+	}
 }
 
 func (a *App) execWithLoader(ship ships.Ship, in hangar.ActionInput, label string) (hangar.ActionResult, error) {
@@ -781,10 +1361,24 @@ func (a *App) execWithLoader(ship ships.Ship, in hangar.ActionInput, label strin
 	return res, err
 }
 
+// passwordForShip returns the session password for ship, asking for it the
+// first time. When SSH keys log in on their own there is nothing to ask: the
+// empty password is cached and every call uses the keys.
 func (a *App) passwordForShip(ship ships.Ship) (string, error) {
-	if p, ok := a.Secrets.Get(ship.Name); ok && strings.TrimSpace(p) != "" {
+	// Everything that reaches the server asks here first, so a ship whose
+	// host key changed stops here until the key is trusted.
+	if saved, err := a.Store.Load(ship.Name); err == nil {
+		if err := hostkey.Check(saved); err != nil {
+			return "", err
+		}
+	}
+	if p, ok := a.Secrets.Get(ship.Name); ok {
 		return p, nil
 	}
+	if a.HangarSvc.KeyLogin(ship) {
+		a.Secrets.Set(ship.Name, "")
+		return "", nil
+	}
 	pwd := ""
 	if err := huh.NewInput().EchoMode(huh.EchoModePassword).Title(fmt.Sprintf("SSH password for %s@%s", ship.SSHUser, ship.Host)).Value(&pwd).Run(); err != nil {
 		if isUserCancelled(err) {
@@ -804,14 +1398,75 @@ func (a *App) showInventoryCard(ship ships.Ship, inv hangar.Inventory) {
 		fmt.Sprintf("Ship: %s", ship.Name),
 		fmt.Sprintf("Host: %s", fallback(inv.PublicIP, ship.Host)),
 		fmt.Sprintf("Hangar: %s", inv.HangarStatus),
-		"",
 	}
+	if inv.Label != "" {
+		lines = append(lines, "Label: "+inv.Label)
+	}
+	if inv.Description != "" {
+		lines = append(lines, "Description: "+inv.Description)
+	}
+	if change := inv.LastChange.Label(); change != "" {
+		lines = append(lines, "Last change: "+change)
+	}
+	if loc := inv.Host.Geo.Location(); loc != "" {
+		lines = append(lines, "Exit location: "+loc)
+	}
+	if network := inv.Host.Geo.Network(); network != "" {
+		lines = append(lines, "Network: "+network)
+	}
+	if inv.GeoErr != nil {
+		lines = append(lines, "Exit location: lookup failed ("+inv.GeoErr.Error()+")")
+	}
+	if inv.TrafficCapGB > 0 {
+		lines = append(lines, fmt.Sprintf("Traffic cap: %d GB/month", inv.TrafficCapGB))
+	}
+	if inv.QuietHours != "" {
+		lines = append(lines, "Quiet hours: "+hangar.QuietHoursLabel(inv.QuietHours))
+	}
+	if st := inv.HTTP; st.Exists && (st.MaxConns > 0 || st.RateLimit > 0) {
+		lines = append(lines, "HTTP limits: "+hangar.LimitsLabel(st.MaxConns, st.RateLimit))
+	}
+	if st := inv.Socks5; st.Exists && (st.MaxConns > 0 || st.RateLimit > 0) {
+		lines = append(lines, "SOCKS5 limits: "+hangar.LimitsLabel(st.MaxConns, st.RateLimit))
+	}
+	if st := inv.HTTP; st.Exists && (st.MemoryMax != "" || st.CPUQuota != "" || st.TasksMax > 0) {
+		lines = append(lines, "HTTP resources: "+hangar.ResourcesLabel(st.MemoryMax, st.CPUQuota, st.TasksMax))
+	}
+	if st := inv.Socks5; st.Exists && (st.MemoryMax != "" || st.CPUQuota != "" || st.TasksMax > 0) {
+		lines = append(lines, "SOCKS5 resources: "+hangar.ResourcesLabel(st.MemoryMax, st.CPUQuota, st.TasksMax))
+	}
+	if !inv.ExpiresAt.IsZero() {
+		lines = append(lines, fmt.Sprintf("Self-destruct: %s", inv.ExpiresAt.Local().Format("2006-01-02 15:04 MST")))
+	}
+	if now := time.Now(); inv.KeptAlive(now) {
+		lines = append(lines, fmt.Sprintf("Smart blinder: kept alive by client (%s ago)", now.Sub(inv.SmartBlinderKeepalive).Round(time.Second)))
+	}
+	lines = append(lines, "")
 	if inv.HTTP.Exists {
 		httpMode := fallback(inv.HTTP.Mode, "managed")
-		lines = append(lines, fmt.Sprintf("HTTP   active=%v  mode=%s  port=%s  user=%s", inv.HTTP.Active, httpMode, fallback(inv.HTTP.Port, "-"), fallback(inv.HTTP.User, "-")))
+		label := "HTTP  "
+		if inv.Instance != "" {
+			label = "HTTP [" + inv.Instance + "]"
+		}
+		lines = append(lines, fmt.Sprintf("%s active=%v  mode=%s  port=%s  user=%s  headers=%s  egress=%s", label, inv.HTTP.Active, httpMode, fallback(inv.HTTP.Port, "-"), fallback(inv.HTTP.User, "-"), hangar.AnonymityLabel(inv.HTTP.Anonymity), fallback(inv.HTTP.EgressIP, "default")))
 	}
 	if inv.Socks5.Exists {
-		lines = append(lines, fmt.Sprintf("SOCKS5 active=%v  port=%s  user=%s", inv.Socks5.Active, fallback(inv.Socks5.Port, "-"), fallback(inv.Socks5.User, "-")))
+		line := fmt.Sprintf("SOCKS5 active=%v  port=%s  user=%s  egress=%s", inv.Socks5.Active, fallback(inv.Socks5.Port, "-"), fallback(inv.Socks5.User, "-"), fallback(inv.Socks5.EgressIP, "default"))
+		if inv.Socks5.SocketActivation {
+			line += "  on-demand"
+		}
+		lines = append(lines, line)
+	}
+	if c := inv.HTTPCache; inv.HTTP.Exists && c.SizeMB > 0 {
+		lines = append(lines, fmt.Sprintf("HTTP cache  size=%dMB  used=%s  hits=%d/%d  dir=%s", c.SizeMB, usage.FormatBytes(c.UsedBytes), c.Hits, c.Requests, fallback(c.Dir, "-")))
+	}
+	if inv.HTTP.Exists && (len(inv.HTTP.AllowDomains) > 0 || len(inv.HTTP.DenyDomains) > 0) {
+		lines = append(lines, fmt.Sprintf("HTTP destinations  allow=%s  deny=%s", hangar.DomainsLabel(inv.HTTP.AllowDomains), fallback(strings.Join(inv.HTTP.DenyDomains, ","), "none")))
+	}
+	for _, in := range inv.HTTPInstances {
+		if in.Name != inv.Instance {
+			lines = append(lines, fmt.Sprintf("HTTP instance %s  active=%v  port=%s", in.Name, in.Active, fallback(in.Port, "-")))
+		}
 	}
 	if !inv.HTTP.Exists && !inv.Socks5.Exists {
 		lines = append(lines, "No hangar services configured.")
@@ -845,7 +1500,124 @@ func (a *App) showInventoryCard(ship ships.Ship, inv hangar.Inventory) {
 	a.note("hangar configuration", strings.Join(lines, "\n"))
 }
 
+func (a *App) showTrafficUsage(ship ships.Ship) error {
+	if a.Usage == nil {
+		return errors.New("usage history store is not available")
+	}
+	pwd, err := a.passwordForShip(ship)
+	if err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	go renderLoader(done, "reading traffic counters")
+	u, err := a.HangarSvc.Usage(ship, pwd)
+	close(done)
+	clearLoaderLine()
+	if err != nil {
+		return err
+	}
+	rep, err := a.Usage.Record(ship.Name, usage.Sample{
+		Source: u.Source,
+		Period: u.Period,
+		RX:     u.RXBytes,
+		TX:     u.TXBytes,
+	}, usage.Quota{GB: ship.TrafficQuotaGB, WarnPercent: ship.TrafficWarnPercent})
+	if err != nil {
+		return err
+	}
+	a.traffic[ship.Name] = rep.Level
+
+	lines := []string{
+		fmt.Sprintf("Month: %s", rep.Month),
+		fmt.Sprintf("Source: %s (%s)", rep.Source, fallback(u.Iface, "unknown interface")),
+		fmt.Sprintf("Used: %s", usage.FormatBytes(rep.Used)),
+	}
+	if rep.Quota.Bytes() > 0 {
+		lines = append(lines, fmt.Sprintf("Quota: %s (%.0f%% used, warn at %d%%)", usage.FormatBytes(rep.Quota.Bytes()), rep.Percent(), ship.TrafficWarnPercent))
+	} else {
+		lines = append(lines, "Quota: not set (Edit Ship to add one)")
+	}
+	if rep.Level != usage.LevelOK {
+		lines = append(lines, "", "WARNING: "+rep.Level.String())
+	}
+	if rep.Crossed && a.Notifier != nil {
+		if err := a.Notifier.Notify(rep.Alert()); err != nil {
+			lines = append(lines, "", "Notification failed: "+err.Error())
+		}
+	}
+	a.note("traffic usage :: "+ship.Name, strings.Join(lines, "\n"))
+	return nil
+}
+
 func (a *App) showResultCard(ship ships.Ship, res hangar.ActionResult) {
+	changes, changed := a.credentialChanges(ship, res)
+	a.storeCard(ship, res)
+	if changed {
+		info, _ := a.Store.LoadHostInfo(ship.Name)
+		changes = append(changes, regenerateExports(ship, res, info.Geo)...)
+	}
+	res.Reachable = reachFromHere(ship, res)
+	a.resultNote(ship, res, changes...)
+}
+
+// credentialChanges compares res with the stored result card. It reports
+// whether anything a client uses changed (always, without an earlier card)
+// and, when the login did (e.g. after a rotate), lists what clients must
+// update.
+func (a *App) credentialChanges(ship ships.Ship, res hangar.ActionResult) ([]string, bool) {
+	if a.Creds == nil || res.Pass == "" || strings.EqualFold(res.Protocol, "DESTROY") {
+		return nil, false
+	}
+	after := creds.FromResult(ship.Name, res)
+	before, err := a.Creds.Load(ship.Name)
+	if err != nil {
+		return nil, true
+	}
+	changed := false
+	for _, c := range creds.Compare(before, after) {
+		changed = changed || c.Differs()
+	}
+	if before.User == after.User && before.Pass == after.Pass {
+		return nil, changed
+	}
+	lines := []string{"", "Changed since the last result (update your clients):"}
+	for _, c := range creds.Compare(before, after) {
+		lines = append(lines, "  "+c.String())
+	}
+	return lines, changed
+}
+
+// regenerateExports rewrites the export files registered for ship (beammeup
+// export --register) and returns the lines for the result card.
+func regenerateExports(ship ships.Ship, res hangar.ActionResult, geo geoip.Info) []string {
+	if len(ship.Exports) == 0 {
+		return nil
+	}
+	written, err := exports.Regenerate(ship, creds.FromResult(ship.Name, res), geo)
+	lines := []string{""}
+	for _, path := range written {
+		lines = append(lines, "Export updated: "+path)
+	}
+	if err != nil {
+		lines = append(lines, "Export failed: "+err.Error())
+	}
+	return lines
+}
+
+// reachFromHere checks the proxy port of an apply result from this machine,
+// with a request through it, and renders the outcome for the result card.
+// Proxies that only listen on localhost are reached over SSH and skipped.
+func reachFromHere(ship ships.Ship, res hangar.ActionResult) string {
+	if ship.ListenLocal || res.Port == "" || strings.EqualFold(res.Protocol, "DESTROY") {
+		return ""
+	}
+	ep := probe.Endpoint{Protocol: strings.ToLower(res.Protocol), Host: ship.Host, Port: res.Port, User: res.User, Pass: res.Pass}
+	return probe.Reach(ep, true, probe.DefaultIPEchoURL, 5*time.Second).String()
+}
+
+// resultNote shows res without storing it, e.g. for an older queued result.
+// extra lines go at the end of the card.
+func (a *App) resultNote(ship ships.Ship, res hangar.ActionResult, extra ...string) {
 	if strings.EqualFold(res.Protocol, "DESTROY") {
 		a.note("destroy complete", fallback(res.Note, "hangar removed"))
 		return
@@ -873,15 +1645,78 @@ func (a *App) showResultCard(ship ships.Ship, res hangar.ActionResult) {
 		}
 		msg = append(msg, "", "SSH tunnel required (keep it running):", sshCmd)
 	}
+	if res.Reachable != "" {
+		msg = append(msg, "Reachable from your location: "+res.Reachable)
+	}
 	if res.FirewallNote != "" {
 		msg = append(msg, "", "Firewall: "+res.FirewallNote)
 	}
 	if res.Note != "" {
 		msg = append(msg, "Note: "+res.Note)
 	}
+	msg = append(msg, extra...)
 	a.note("mission complete", strings.Join(msg, "\n"))
 }
 
+// showStoredCard reveals the ship's local result card once the reveal
+// challenge, if one is set, is answered.
+func (a *App) showStoredCard(ship ships.Ship) error {
+	if a.Creds == nil {
+		return errors.New("stored credentials are not available")
+	}
+	card, err := a.Creds.Load(ship.Name)
+	if err != nil {
+		return err
+	}
+	gate, err := a.Creds.LoadGate()
+	if err != nil {
+		return err
+	}
+	if gate.Kind() != "" {
+		title := "Authenticator code"
+		if gate.Kind() == "passphrase" {
+			title = "Reveal passphrase"
+		}
+		answer := ""
+		if err := huh.NewInput().EchoMode(huh.EchoModePassword).Title(title).Value(&answer).Run(); err != nil {
+			if isUserCancelled(err) {
+				return errUserCancelled
+			}
+			return err
+		}
+		if !gate.Check(answer, time.Now()) {
+			time.Sleep(time.Second)
+			return errors.New("reveal challenge failed")
+		}
+	}
+	lines := []string{
+		fmt.Sprintf("Proxy: %s://%s:%s", card.Protocol, card.Host, card.Port),
+		fmt.Sprintf("Username: %s", card.User),
+		fmt.Sprintf("Password: %s", card.Pass),
+		fmt.Sprintf("Stored: %s", card.UpdatedAt.Local().Format("2006-01-02 15:04")),
+	}
+	a.note("stored credentials :: "+ship.Name, strings.Join(lines, "\n"))
+	return nil
+}
+
+// storeCard keeps the local result card in line with res: the client-side
+// commands read the proxy credentials from it instead of asking the server.
+func (a *App) storeCard(ship ships.Ship, res hangar.ActionResult) {
+	if a.Creds == nil {
+		return
+	}
+	var err error
+	switch {
+	case strings.EqualFold(res.Protocol, "DESTROY"):
+		err = a.Creds.Delete(ship.Name)
+	case res.User != "" && res.Pass != "":
+		err = a.Creds.Save(creds.FromResult(ship.Name, res))
+	}
+	if err != nil {
+		a.note("credentials not stored", err.Error())
+	}
+}
+
 func (a *App) confirm(prompt string) bool {
 	val := false
 	if err := huh.NewConfirm().Title(prompt).Affirmative("Yes").Negative("No").Value(&val).Run(); err != nil {
@@ -902,13 +1737,21 @@ func (a *App) handleHTTPConflictWizard(ship ships.Ship, requestedProtocol string
 		return false, ship, nil
 	}
 
+	description := "beammeup found an existing non-beammeup Squid config and will not overwrite it."
+	if pwd, err := a.passwordForShip(ship); err == nil {
+		if cfg, err := a.HangarSvc.SquidConfig(ship, pwd); err == nil {
+			description += "\n\n" + cfg.Summary()
+		}
+	}
 	choice := ""
 	if err := huh.NewSelect[string]().
 		Title("HTTP conflict detected").
-		Description("beammeup found an existing non-beammeup Squid config and will not overwrite it. Choose how to continue.").
+		Description(description+"\n\nChoose how to continue.").
 		Options(
 			huh.NewOption("Use SOCKS5 fallback (recommended)", "socks"),
 			huh.NewOption("Create isolated HTTP sidecar (no overwrite)", "sidecar"),
+			huh.NewOption("Adopt it: take over its port and users (backed up)", "adopt"),
+			huh.NewOption("Back it up and replace it with beammeup's", "replace"),
 			huh.NewOption("Cancel", "cancel"),
 		).
 		Value(&choice).
@@ -923,20 +1766,39 @@ func (a *App) handleHTTPConflictWizard(ship ships.Ship, requestedProtocol string
 		return true, ship, nil
 	}
 
+	if choice == "adopt" || choice == "replace" {
+		in := conflictHTTPInput(ship)
+		in.HTTPConflict = choice
+		// Adopting keeps the old config's port unless the ship names one;
+		// replacing starts on the ship's port or the default.
+		in.ProxyPort = ship.ProxyPort
+		res, err := a.execWithPassword(ship, in)
+		if err != nil {
+			return true, ship, err
+		}
+		updated := ship
+		updated.Protocol = "http"
+		updated.HTTPMode = ""
+		if p, err := strconv.Atoi(res.Port); err == nil {
+			updated.ProxyPort = p
+		}
+		saved, saveErr := a.Store.Save(updated)
+		if saveErr != nil {
+			return true, ship, saveErr
+		}
+		a.status[saved.Name] = hangar.StatusOnline
+		a.showResultCard(saved, res)
+		return true, saved, nil
+	}
+
 	if choice == "sidecar" {
 		ports := fallbackHTTPPorts(requestedPort)
 		var lastErr error
 		for _, p := range ports {
-			res, err := a.execWithPassword(ship, hangar.ActionInput{
-				Mode:                    "apply",
-				Protocol:                "http",
-				HTTPMode:                "sidecar",
-				ProxyPort:               p,
-				NoFirewallChange:        ship.NoFirewallChange,
-				ListenLocal:             ship.ListenLocal,
-				SmartBlinder:            ship.SmartBlinder,
-				SmartBlinderIdleMinutes: ship.SmartBlinderIdleMinutes,
-			})
+			in := conflictHTTPInput(ship)
+			in.HTTPMode = "sidecar"
+			in.ProxyPort = p
+			res, err := a.execWithPassword(ship, in)
 			if err != nil {
 				lastErr = err
 				if isPortBusyError(err) {
@@ -977,6 +1839,17 @@ func (a *App) handleHTTPConflictWizard(ship ships.Ship, requestedProtocol string
 			ListenLocal:             ship.ListenLocal,
 			SmartBlinder:            ship.SmartBlinder,
 			SmartBlinderIdleMinutes: ship.SmartBlinderIdleMinutes,
+			TrafficCapGB:            ship.TrafficCapGB,
+			QuietHours:              ship.QuietHours,
+			Label:                   ship.HangarLabel,
+			Description:             ship.HangarDescription,
+			MaxConns:                ship.MaxConns,
+			RateLimit:               ship.RateLimit,
+			MemoryMax:               ship.MemoryMax,
+			CPUQuota:                ship.CPUQuota,
+			TasksMax:                ship.TasksMax,
+			EgressIP:                ship.EgressIP,
+			SocketActivation:        ship.SocketActivation,
 		})
 		if err != nil {
 			lastErr = err
@@ -1007,6 +1880,34 @@ func (a *App) handleHTTPConflictWizard(ship ships.Ship, requestedProtocol string
 	return true, ship, fmt.Errorf("unable to create SOCKS5 fallback")
 }
 
+// conflictHTTPInput is the HTTP apply of ship that the conflict wizard
+// adjusts to get past a Squid config beammeup did not write.
+func conflictHTTPInput(ship ships.Ship) hangar.ActionInput {
+	return hangar.ActionInput{
+		Mode:                    "apply",
+		Protocol:                "http",
+		NoFirewallChange:        ship.NoFirewallChange,
+		ListenLocal:             ship.ListenLocal,
+		SmartBlinder:            ship.SmartBlinder,
+		SmartBlinderIdleMinutes: ship.SmartBlinderIdleMinutes,
+		TrafficCapGB:            ship.TrafficCapGB,
+		QuietHours:              ship.QuietHours,
+		Label:                   ship.HangarLabel,
+		Description:             ship.HangarDescription,
+		MaxConns:                ship.MaxConns,
+		RateLimit:               ship.RateLimit,
+		MemoryMax:               ship.MemoryMax,
+		CPUQuota:                ship.CPUQuota,
+		TasksMax:                ship.TasksMax,
+		EgressIP:                ship.EgressIP,
+		CacheMB:                 ship.CacheMB,
+		CacheDir:                ship.CacheDir,
+		Anonymity:               ship.Anonymity,
+		AllowDomains:            ship.AllowDomains,
+		DenyDomains:             ship.DenyDomains,
+	}
+}
+
 func (a *App) shipSummaryLines(shipNames []string) string {
 	lines := []string{"select a ship to open cockpit"}
 	for _, name := range shipNames {
@@ -1095,13 +1996,32 @@ func normalizeHTTPMode(v string) string {
 	}
 }
 
+// interruptible runs fn with a context Ctrl-C cancels, so an operation
+// stuck on an unresponsive server can be abandoned without leaving the
+// cockpit.
+func interruptible(fn func(ctx context.Context) error) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	err := fn(ctx)
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("interrupted; the server may still be finishing the step it was on: %w", err)
+	}
+	return err
+}
+
+// loaderMu stops the loader line from drawing over a prompt that comes up
+// while the operation runs, such as an SSH one-time code.
+var loaderMu sync.Mutex
+
 func renderLoader(done <-chan struct{}, label string) {
 	frames := []rune{'|', '/', '-', '\\'}
 	t := time.NewTicker(120 * time.Millisecond)
 	defer t.Stop()
 	i := 0
 	for {
+		loaderMu.Lock()
 		fmt.Fprintf(os.Stderr, "\r[beammeup] %s %c", label, frames[i%len(frames)])
+		loaderMu.Unlock()
 		i++
 		select {
 		case <-done:
@@ -1130,8 +2050,8 @@ func nonZero(v, d int) int {
 }
 
 func defaultProxy(protocol string) int {
-	if protocol == "socks5" {
-		return 1080
+	if proto, ok := hangar.LookupProtocol(protocol); ok {
+		return proto.DefaultPort()
 	}
-	return 18181
+	return 0
 }
@@ -0,0 +1,83 @@
+package tui
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alfaoz/beammeup/internal/ships"
+)
+
+func TestShipFormKeepsEveryField(t *testing.T) {
+	existing := ships.Ship{
+		Name:                    "edge",
+		Host:                    "203.0.113.7",
+		SSHPort:                 2222,
+		SSHUser:                 "ops",
+		IdentityFile:            "/home/ops/.ssh/id_ed25519",
+		Protocol:                "http",
+		HTTPMode:                "sidecar",
+		ProxyPort:               3128,
+		NoFirewallChange:        true,
+		ListenLocal:             true,
+		SmartBlinder:            true,
+		SmartBlinderIdleMinutes: 15,
+		TrafficQuotaGB:          1000,
+		TrafficWarnPercent:      90,
+		TrafficCapGB:            800,
+		QuietHours:              "00:00-06:00",
+		MaxConns:                64,
+		RateLimit:               120,
+		MemoryMax:               "256M",
+		CPUQuota:                "50%",
+		TasksMax:                128,
+		EgressIP:                "198.51.100.9",
+		SocketActivation:        true,
+		Instance:                "team-a",
+		CacheMB:                 512,
+		CacheDir:                "/var/cache/beammeup",
+		Anonymity:               "anonymous",
+		AllowDomains:            []string{".example.com"},
+		DenyDomains:             []string{".example.net"},
+		Tags:                    []string{"rotation-pool"},
+		HangarLabel:             "marketing",
+		HangarDescription:       "ask ops",
+		Exports:                 []string{"curl:/tmp/curlrc"},
+		Forwards:                []string{"L:127.0.0.1:5432=127.0.0.1:5432"},
+		CloudFirewall:           "hetzner",
+		CloudFirewallPort:       3128,
+		ExpiresAt:               time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		HostKeyChanged:          "SHA256:abc",
+	}
+	// The cap and quiet hours are server-wide, so an instance ship can't
+	// carry them; between the two ships every field is set.
+	instance := existing
+	existing.Instance = ""
+	existing.HTTPMode = "sidecar"
+	instance.TrafficCapGB, instance.QuietHours = 0, ""
+	all := []ships.Ship{existing, instance}
+	for i := 0; i < reflect.TypeOf(existing).NumField(); i++ {
+		set := false
+		for _, s := range all {
+			set = set || !reflect.ValueOf(s).Field(i).IsZero()
+		}
+		if !set {
+			t.Fatalf("set %s in this test so the edit path is checked for it", reflect.TypeOf(existing).Field(i).Name)
+		}
+	}
+
+	for _, want := range all {
+		got, err := newShipForm(want).ship(want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("editing without changes altered the ship:\n got %+v\nwant %+v", got, want)
+		}
+	}
+
+	instance.QuietHours = "00:00-06:00"
+	if _, err := newShipForm(instance).ship(instance); err == nil {
+		t.Fatal("quiet hours on an instance ship should be rejected")
+	}
+}
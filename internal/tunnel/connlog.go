@@ -0,0 +1,89 @@
+package tunnel
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConnRecord is one line of the connection log: a connection through the
+// local proxy, written when it closes (or when its dial fails).
+type ConnRecord struct {
+	Time       time.Time `json:"time"` // when the connection was opened
+	Target     string    `json:"target"`
+	BytesUp    int64     `json:"bytes_up"`
+	BytesDown  int64     `json:"bytes_down"`
+	DurationMS int64     `json:"duration_ms"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// ConnLog writes a ConnRecord per connection as JSON lines. It is opt-in:
+// the log tells anyone who can read it where this machine connected to.
+type ConnLog struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewConnLog logs to w.
+func NewConnLog(w io.Writer) *ConnLog {
+	return &ConnLog{enc: json.NewEncoder(w)}
+}
+
+func (l *ConnLog) write(rec ConnRecord) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.enc.Encode(rec)
+}
+
+// Wrap returns dial with every connection it opens, or fails to, logged.
+func (l *ConnLog) Wrap(dial DialFunc) DialFunc {
+	return func(network, addr string) (net.Conn, error) {
+		start := time.Now()
+		conn, err := dial(network, addr)
+		if err != nil {
+			l.write(ConnRecord{Time: start, Target: addr, DurationMS: time.Since(start).Milliseconds(), Error: err.Error()})
+			return nil, err
+		}
+		return &loggedConn{Conn: conn, log: l, target: addr, start: start}, nil
+	}
+}
+
+type loggedConn struct {
+	net.Conn
+	log    *ConnLog
+	target string
+	start  time.Time
+	up     atomic.Int64
+	down   atomic.Int64
+	once   sync.Once
+}
+
+func (c *loggedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.down.Add(int64(n))
+	return n, err
+}
+
+func (c *loggedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.up.Add(int64(n))
+	return n, err
+}
+
+func (c *loggedConn) CloseWrite() error { return closeWrite(c.Conn) }
+
+func (c *loggedConn) Close() error {
+	c.once.Do(func() {
+		c.log.write(ConnRecord{
+			Time:       c.start,
+			Target:     c.target,
+			BytesUp:    c.up.Load(),
+			BytesDown:  c.down.Load(),
+			DurationMS: time.Since(c.start).Milliseconds(),
+		})
+	})
+	return c.Conn.Close()
+}
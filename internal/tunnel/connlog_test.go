@@ -0,0 +1,59 @@
+package tunnel
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestConnLog(t *testing.T) {
+	var buf bytes.Buffer
+	log := NewConnLog(&buf)
+	dial := log.Wrap(func(network, addr string) (net.Conn, error) {
+		if addr == "ads.example.net:443" {
+			return nil, ErrBlocked
+		}
+		client, server := net.Pipe()
+		go func() {
+			b := make([]byte, 5)
+			io.ReadFull(server, b)
+			server.Write([]byte("hi"))
+			server.Close()
+		}()
+		return client, nil
+	})
+
+	conn, err := dial("tcp", "example.com:443")
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	conn.Write([]byte("hello"))
+	io.ReadAll(conn)
+	conn.Close()
+	conn.Close()
+	if _, err := dial("tcp", "ads.example.net:443"); !errors.Is(err, ErrBlocked) {
+		t.Fatalf("blocked dial: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d log lines, want 2:\n%s", len(lines), buf.String())
+	}
+	var rec ConnRecord
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if rec.Target != "example.com:443" || rec.BytesUp != 5 || rec.BytesDown != 2 || rec.Error != "" || rec.Time.IsZero() {
+		t.Fatalf("unexpected record: %+v", rec)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &rec); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if rec.Target != "ads.example.net:443" || rec.Error == "" {
+		t.Fatalf("unexpected record for the failed dial: %+v", rec)
+	}
+}
@@ -0,0 +1,122 @@
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Control commands accepted on a tunnel's control socket.
+const (
+	ControlStatus = "status"
+	ControlStop   = "stop"
+	ControlReload = "reload"
+)
+
+// ControlHandler answers control requests for a running tunnel.
+type ControlHandler struct {
+	Status func() Status
+	Stop   func()
+	Reload func() error // nil when reload is not supported
+}
+
+type controlReply struct {
+	OK     bool    `json:"ok"`
+	Error  string  `json:"error,omitempty"`
+	Status *Status `json:"status,omitempty"`
+}
+
+// ListenControl opens the control socket at path. A socket left behind by a
+// tunnel that is gone is replaced; one that still answers is an error.
+func ListenControl(path string) (net.Listener, error) {
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("a tunnel is already running (control socket %s)", path)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("remove stale control socket: %w", err)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("listen %s: %w", path, err)
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("chmod control socket: %w", err)
+	}
+	return ln, nil
+}
+
+// ServeControl answers one command per connection on ln until ctx is
+// cancelled.
+func ServeControl(ctx context.Context, ln net.Listener, h ControlHandler, logf LogFunc) {
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(30 * time.Second))
+			line, err := bufio.NewReader(conn).ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.TrimSpace(line)
+			reply := controlReply{OK: true}
+			switch cmd {
+			case ControlStatus:
+				st := h.Status()
+				reply.Status = &st
+			case ControlStop:
+				logf("stop requested over control socket")
+				h.Stop()
+			case ControlReload:
+				logf("reload requested over control socket")
+				if h.Reload == nil {
+					reply = controlReply{Error: "reload is not supported here"}
+				} else if err := h.Reload(); err != nil {
+					reply = controlReply{Error: err.Error()}
+				}
+			default:
+				reply = controlReply{Error: fmt.Sprintf("unknown command %q", cmd)}
+			}
+			json.NewEncoder(conn).Encode(reply)
+		}()
+	}
+}
+
+// Control sends cmd to the tunnel behind the control socket at path. For
+// ControlStatus the returned Status is filled in.
+func Control(path, cmd string) (Status, error) {
+	conn, err := net.DialTimeout("unix", path, 2*time.Second)
+	if err != nil {
+		return Status{}, fmt.Errorf("no tunnel answering at %s: %w", path, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	if _, err := fmt.Fprintln(conn, cmd); err != nil {
+		return Status{}, fmt.Errorf("send %s: %w", cmd, err)
+	}
+	var reply controlReply
+	if err := json.NewDecoder(conn).Decode(&reply); err != nil {
+		return Status{}, fmt.Errorf("read %s reply: %w", cmd, err)
+	}
+	if !reply.OK {
+		return Status{}, errors.New(reply.Error)
+	}
+	if reply.Status != nil {
+		return *reply.Status, nil
+	}
+	return Status{}, nil
+}
@@ -0,0 +1,79 @@
+package tunnel
+
+import (
+	"context"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestControlReportsTrackedTraffic(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen echo: %v", err)
+	}
+	defer echo.Close()
+	go func() {
+		c, err := echo.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.Copy(c, c)
+	}()
+
+	mon := NewMonitor(42, "127.0.0.1:1080", "203.0.113.7")
+	conn, err := mon.Track(net.Dial)("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "ship.sock")
+	ln, err := ListenControl(path)
+	if err != nil {
+		t.Fatalf("ListenControl: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopped := make(chan struct{})
+	go ServeControl(ctx, ln, ControlHandler{
+		Status: mon.Snapshot,
+		Stop:   func() { close(stopped) },
+		Reload: func() error { return nil },
+	}, func(string, ...any) {})
+
+	if _, err := ListenControl(path); err == nil {
+		t.Fatalf("second ListenControl on a live socket should fail")
+	}
+	st, err := Control(path, ControlStatus)
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if st.PID != 42 || st.Served != 1 || st.BytesUp != 5 || st.BytesDown != 5 {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+	if len(st.Conns) != 1 || st.Conns[0].Target != echo.Addr().String() {
+		t.Fatalf("unexpected connections: %+v", st.Conns)
+	}
+
+	conn.Close()
+	if st := mon.Snapshot(); len(st.Conns) != 0 || st.BytesUp != 5 {
+		t.Fatalf("closed connection should move into the totals: %+v", st)
+	}
+	if _, err := Control(path, "bogus"); err == nil {
+		t.Fatalf("unknown command should fail")
+	}
+	if _, err := Control(path, ControlStop); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	<-stopped
+}
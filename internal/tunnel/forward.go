@@ -0,0 +1,161 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/alfaoz/beammeup/internal/sshx"
+)
+
+// Forward is a port forward over the SSH connection. A local forward
+// (ssh -L) listens on this machine and connects to To from the server; a
+// reverse one (ssh -R) listens on the server and connects to To here. Listen
+// and To are host:port.
+type Forward struct {
+	Reverse bool
+	Listen  string
+	To      string
+}
+
+func (f Forward) String() string {
+	if f.Reverse {
+		return "server " + f.Listen + " -> local " + f.To
+	}
+	return "local " + f.Listen + " -> server " + f.To
+}
+
+// Spec is the form a forward is saved in: L:listen=to or R:listen=to.
+func (f Forward) Spec() string {
+	kind := "L"
+	if f.Reverse {
+		kind = "R"
+	}
+	return kind + ":" + f.Listen + "=" + f.To
+}
+
+// ParseForward reads a forward saved with Spec.
+func ParseForward(spec string) (Forward, error) {
+	kind, rest, _ := strings.Cut(strings.TrimSpace(spec), ":")
+	listen, to, ok := strings.Cut(rest, "=")
+	if !ok || (kind != "L" && kind != "R") {
+		return Forward{}, fmt.Errorf("forward %q: want L:listen=to or R:listen=to", spec)
+	}
+	f := Forward{Reverse: kind == "R"}
+	var err error
+	if f.Listen, err = ForwardAddr(listen); err != nil {
+		return Forward{}, fmt.Errorf("forward %q: %w", spec, err)
+	}
+	if f.To, err = ForwardAddr(to); err != nil {
+		return Forward{}, fmt.Errorf("forward %q: %w", spec, err)
+	}
+	return f, nil
+}
+
+// ForwardAddr reads a forward endpoint: a bare port means that port on
+// 127.0.0.1, anything else must be host:port.
+func ForwardAddr(v string) (string, error) {
+	if port, err := strconv.Atoi(v); err == nil {
+		if port < 1 || port > 65535 {
+			return "", fmt.Errorf("port %d out of range", port)
+		}
+		return net.JoinHostPort("127.0.0.1", v), nil
+	}
+	// , and = would break the saved form.
+	if _, _, err := net.SplitHostPort(v); err != nil || strings.ContainsAny(v, ",=") {
+		return "", fmt.Errorf("%q: want a port or host:port", v)
+	}
+	return v, nil
+}
+
+// RunForwards connects to the target via SSH and serves every forward over
+// that one connection. When controlPath is set, a unix socket there answers
+// status and stop requests. It blocks until ctx is cancelled, a stop is
+// requested, or a forward fails, e.g. because the SSH connection dropped.
+func RunForwards(ctx context.Context, target sshx.Target, opts sshx.ConnectOptions, forwards []Forward, controlPath string, logf LogFunc) error {
+	if logf == nil {
+		logf = func(string, ...any) {}
+	}
+
+	link := &sshLink{target: target, opts: opts}
+	if err := link.connect(ctx); err != nil {
+		return fmt.Errorf("ssh connect: %w", err)
+	}
+	defer link.close()
+
+	listeners := make([]net.Listener, 0, len(forwards))
+	defer func() {
+		for _, ln := range listeners {
+			ln.Close()
+		}
+	}()
+	for _, f := range forwards {
+		listen := net.Listen
+		if f.Reverse {
+			// sshd binds other than loopback only when GatewayPorts allows it.
+			listen = link.listen
+		}
+		ln, err := listen("tcp", f.Listen)
+		if err != nil {
+			return fmt.Errorf("forward %s: listen: %w", f, err)
+		}
+		listeners = append(listeners, ln)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	mon := NewMonitor(os.Getpid(), "", target.Host)
+	for _, f := range forwards {
+		mon.status.Forwards = append(mon.status.Forwards, f.String())
+	}
+	if controlPath != "" {
+		cl, err := ListenControl(controlPath)
+		if err != nil {
+			return err
+		}
+		go ServeControl(ctx, cl, ControlHandler{Status: mon.Snapshot, Stop: cancel}, logf)
+	}
+	go keepalive(ctx, link, mon, logf)
+
+	local := &net.Dialer{Timeout: directDialTimeout}
+	errs := make(chan error, len(forwards))
+	var wg sync.WaitGroup
+	for i, f := range forwards {
+		dial := link.dial
+		if f.Reverse {
+			dial = local.Dial
+		}
+		dial = mon.Track(dial)
+		logf("forwarding %s", f)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := serve(ctx, listeners[i], func(conn net.Conn) error {
+				return forwardConn(conn, dial, f.To)
+			}, logf)
+			if err != nil {
+				errs <- fmt.Errorf("forward %s: %w", f, err)
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// forwardConn connects an accepted connection to the forward's target.
+func forwardConn(conn net.Conn, dial DialFunc, to string) error {
+	defer conn.Close()
+	target, err := dial("tcp", to)
+	if err != nil {
+		return fmt.Errorf("dial %s: %w", to, err)
+	}
+	defer target.Close()
+	pipe(conn, conn, target, DefaultIdleTimeout)
+	return nil
+}
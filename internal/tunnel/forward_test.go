@@ -0,0 +1,72 @@
+package tunnel
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+func TestForwardAddr(t *testing.T) {
+	cases := map[string]string{
+		"3000":           "127.0.0.1:3000",
+		"0.0.0.0:8080":   "0.0.0.0:8080",
+		"[::1]:22":       "[::1]:22",
+		"localhost:5432": "localhost:5432",
+	}
+	for in, want := range cases {
+		got, err := ForwardAddr(in)
+		if err != nil || got != want {
+			t.Fatalf("ForwardAddr(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "0", "70000", "db"} {
+		if _, err := ForwardAddr(in); err == nil {
+			t.Fatalf("ForwardAddr(%q) succeeded", in)
+		}
+	}
+}
+
+func TestParseForward(t *testing.T) {
+	for _, f := range []Forward{
+		{Listen: "127.0.0.1:5432", To: "10.0.0.5:5432"},
+		{Reverse: true, Listen: "0.0.0.0:8080", To: "127.0.0.1:3000"},
+	} {
+		got, err := ParseForward(f.Spec())
+		if err != nil || got != f {
+			t.Fatalf("ParseForward(%q) = %+v, %v; want %+v", f.Spec(), got, err, f)
+		}
+	}
+	if f, err := ParseForward("L:5432=5433"); err != nil || f.Listen != "127.0.0.1:5432" || f.To != "127.0.0.1:5433" {
+		t.Fatalf("ParseForward with bare ports = %+v, %v", f, err)
+	}
+	for _, spec := range []string{"", "X:1=2", "L:1", "R:a=b"} {
+		if _, err := ParseForward(spec); err == nil {
+			t.Fatalf("ParseForward(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestForwardConn(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(conn, conn)
+		conn.Close()
+	}()
+
+	accepted, peer := tcpPair(t)
+	go forwardConn(accepted, net.Dial, ln.Addr().String())
+	peer.Write([]byte("ping"))
+	peer.(*net.TCPConn).CloseWrite()
+	got, _ := io.ReadAll(peer)
+	if string(got) != "ping" {
+		t.Fatalf("echo through the forward = %q", got)
+	}
+}
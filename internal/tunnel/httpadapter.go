@@ -0,0 +1,208 @@
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// hopHeaders are connection-scoped and must not be forwarded upstream.
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// SOCKS5Upstream returns a DialFunc that reaches every target through the
+// SOCKS5 server at proxyAddr, authenticating with user/pass. forward dials
+// the SOCKS5 server itself (net.Dial or an SSH channel).
+func SOCKS5Upstream(forward DialFunc, proxyAddr, user, pass string) DialFunc {
+	return func(_ string, addr string) (net.Conn, error) {
+		return DialSOCKS5(forward, proxyAddr, user, pass, addr)
+	}
+}
+
+// HTTPUpstream returns a DialFunc that opens a CONNECT tunnel to every target
+// through the HTTP proxy at proxyAddr, sending user/pass as basic
+// Proxy-Authorization when user is non-empty.
+func HTTPUpstream(forward DialFunc, proxyAddr, user, pass string) DialFunc {
+	return func(_ string, addr string) (net.Conn, error) {
+		conn, err := forward("tcp", proxyAddr)
+		if err != nil {
+			return nil, err
+		}
+		req := "CONNECT " + addr + " HTTP/1.1\r\nHost: " + addr + "\r\n"
+		if user != "" {
+			req += "Proxy-Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+pass)) + "\r\n"
+		}
+		if _, err := io.WriteString(conn, req+"\r\n"); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("write connect request: %w", err)
+		}
+		br := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("read connect response: %w", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			conn.Close()
+			return nil, fmt.Errorf("http proxy CONNECT %s: %s", addr, resp.Status)
+		}
+		if br.Buffered() > 0 {
+			return &bufferedConn{Conn: conn, r: br}, nil
+		}
+		return conn, nil
+	}
+}
+
+// bufferedConn keeps bytes the proxy sent right after its CONNECT response.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+func (c *bufferedConn) CloseWrite() error { return closeWrite(c.Conn) }
+
+// RunHTTPAdapter serves a local HTTP proxy on localAddr that opens every
+// upstream connection with dialFn. It blocks until ctx is cancelled.
+func RunHTTPAdapter(ctx context.Context, localAddr string, dialFn DialFunc, logf LogFunc) error {
+	ln, err := net.Listen("tcp", localAddr)
+	if err != nil {
+		return fmt.Errorf("listen %s: %w", localAddr, err)
+	}
+	return ServeHTTPAdapter(ctx, ln, dialFn, logf)
+}
+
+// ServeHTTPAdapter is RunHTTPAdapter on an existing listener, which it closes
+// on return.
+func ServeHTTPAdapter(ctx context.Context, ln net.Listener, dialFn DialFunc, logf LogFunc) error {
+	if logf == nil {
+		logf = func(string, ...any) {}
+	}
+	defer ln.Close()
+
+	logf("http adapter active at %s", ln.Addr())
+	return serve(ctx, ln, func(conn net.Conn) error {
+		return HandleHTTPConn(conn, dialFn)
+	}, logf)
+}
+
+// HandleHTTPConn processes HTTP proxy requests from a single client
+// connection. CONNECT requests become raw tunnels; absolute-form requests
+// (plain http://) are forwarded one at a time.
+func HandleHTTPConn(conn net.Conn, dialFn DialFunc) error {
+	defer conn.Close()
+
+	br := bufio.NewReader(conn)
+	for {
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("read request: %w", err)
+		}
+
+		if req.Method == http.MethodConnect {
+			return handleConnect(conn, br, req, dialFn)
+		}
+		keepAlive, err := forwardHTTP(conn, req, dialFn)
+		if err != nil || !keepAlive {
+			return err
+		}
+	}
+}
+
+func handleConnect(conn net.Conn, br *bufio.Reader, req *http.Request, dialFn DialFunc) error {
+	target := req.Host
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		target = net.JoinHostPort(target, "443")
+	}
+	remote, err := dialFn("tcp", target)
+	if err != nil {
+		writeStatus(conn, dialErrorStatus(err))
+		return fmt.Errorf("dial %s: %w", target, err)
+	}
+	defer remote.Close()
+
+	if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+		return fmt.Errorf("write connect response: %w", err)
+	}
+
+	// br may already hold bytes the client sent after the CONNECT header.
+	pipe(conn, br, remote, DefaultIdleTimeout)
+	return nil
+}
+
+func forwardHTTP(conn net.Conn, req *http.Request, dialFn DialFunc) (bool, error) {
+	if req.URL.Scheme != "http" || req.URL.Host == "" {
+		writeStatus(conn, http.StatusBadRequest)
+		return false, fmt.Errorf("unsupported request target %q", req.RequestURI)
+	}
+	target := req.URL.Host
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		target = net.JoinHostPort(target, "80")
+	}
+
+	keepAlive := !req.Close && !strings.EqualFold(req.Header.Get("Proxy-Connection"), "close")
+	for _, h := range hopHeaders {
+		req.Header.Del(h)
+	}
+	req.Close = true
+
+	remote, err := dialFn("tcp", target)
+	if err != nil {
+		writeStatus(conn, dialErrorStatus(err))
+		return false, fmt.Errorf("dial %s: %w", target, err)
+	}
+	defer remote.Close()
+
+	if err := req.Write(remote); err != nil {
+		writeStatus(conn, http.StatusBadGateway)
+		return false, fmt.Errorf("write upstream request: %w", err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(remote), req)
+	if err != nil {
+		writeStatus(conn, http.StatusBadGateway)
+		return false, fmt.Errorf("read upstream response: %w", err)
+	}
+	defer resp.Body.Close()
+
+	for _, h := range hopHeaders {
+		resp.Header.Del(h)
+	}
+	resp.Close = !keepAlive
+	if err := resp.Write(conn); err != nil {
+		return false, fmt.Errorf("write response: %w", err)
+	}
+	return keepAlive, nil
+}
+
+// dialErrorStatus answers a failed upstream dial: 403 when a routing rule
+// blocked it, 502 otherwise.
+func dialErrorStatus(err error) int {
+	if errors.Is(err, ErrBlocked) {
+		return http.StatusForbidden
+	}
+	return http.StatusBadGateway
+}
+
+func writeStatus(conn net.Conn, code int) {
+	fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\nContent-Length: 0\r\nConnection: close\r\n\r\n", code, http.StatusText(code))
+}
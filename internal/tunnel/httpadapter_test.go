@@ -0,0 +1,144 @@
+package tunnel
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startSOCKS serves the no-auth SOCKS5 handler on a random local port.
+func startSOCKS(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen socks: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go HandleConn(c, net.Dial)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestHTTPAdapterForwardsThroughSOCKS(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Authorization") != "" {
+			t.Errorf("proxy credentials leaked to origin")
+		}
+		io.WriteString(w, "hello "+r.URL.Path)
+	}))
+	defer origin.Close()
+
+	adapter, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen adapter: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dial := SOCKS5Upstream(net.Dial, startSOCKS(t), "", "")
+	go serve(ctx, adapter, func(c net.Conn) error { return HandleHTTPConn(c, dial) }, func(string, ...any) {})
+
+	proxyURL, _ := url.Parse("http://user:pass@" + adapter.Addr().String())
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
+		Timeout:   5 * time.Second,
+	}
+	for _, path := range []string{"/one", "/two"} {
+		resp, err := client.Get(origin.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "hello "+path {
+			t.Fatalf("unexpected body %q", body)
+		}
+	}
+
+	tlsOrigin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "secure")
+	}))
+	defer tlsOrigin.Close()
+	tr := tlsOrigin.Client().Transport.(*http.Transport).Clone()
+	tr.Proxy = http.ProxyURL(proxyURL)
+	resp, err := (&http.Client{Transport: tr, Timeout: 5 * time.Second}).Get(tlsOrigin.URL)
+	if err != nil {
+		t.Fatalf("CONNECT GET: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "secure" {
+		t.Fatalf("unexpected CONNECT body %q", body)
+	}
+}
+
+func TestHTTPUpstreamChainsThroughConnect(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "chained")
+	}))
+	defer origin.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	listen := func() net.Listener {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("listen: %v", err)
+		}
+		return ln
+	}
+
+	// upstream plays the role of an authenticated HTTP hangar.
+	upstream := listen()
+	var gotAuth string
+	go serve(ctx, upstream, func(c net.Conn) error { return HandleHTTPConn(c, net.Dial) }, func(string, ...any) {})
+	front := listen()
+	dial := HTTPUpstream(func(network, addr string) (net.Conn, error) {
+		conn, err := net.Dial(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &authSniffer{Conn: conn, got: &gotAuth}, nil
+	}, upstream.Addr().String(), "u", "p")
+	go ServeHTTPAdapter(ctx, front, dial, nil)
+
+	proxyURL, _ := url.Parse("http://" + front.Addr().String())
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}, Timeout: 5 * time.Second}
+	resp, err := client.Get(origin.URL)
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "chained" {
+		t.Fatalf("unexpected body %q", body)
+	}
+	if !strings.Contains(gotAuth, "Proxy-Authorization: Basic dTpw") {
+		t.Fatalf("missing proxy credentials in %q", gotAuth)
+	}
+}
+
+// authSniffer records the first write (the CONNECT request).
+type authSniffer struct {
+	net.Conn
+	got *string
+}
+
+func (s *authSniffer) Write(p []byte) (int, error) {
+	if *s.got == "" {
+		*s.got = string(p)
+	}
+	return s.Conn.Write(p)
+}
@@ -0,0 +1,47 @@
+package tunnel
+
+import (
+	"context"
+	"time"
+)
+
+// keepaliveInterval is how often a tunnel in use touches the smart blinder's
+// activity marker, well under the shortest idle time it can be set to.
+const keepaliveInterval = 30 * time.Second
+
+// keepaliveCommand touches the marker the smart blinder script reads
+// (BLINDER_KEEPALIVE in remote/script.go). Servers without a blinder are left
+// alone.
+const keepaliveCommand = "if [ -f /etc/beammeup/smart-blinder.env ]; then touch /etc/beammeup/smart-blinder.keepalive; fi"
+
+// keepalive tells the server's smart blinder that the tunnel is in use, so
+// light but real use through SSH does not count as idle. A tick with no
+// connection open or opened since the last one touches nothing, and the
+// blinder shuts down as usual.
+func keepalive(ctx context.Context, link *sshLink, mon *Monitor, logf LogFunc) {
+	tick := time.NewTicker(keepaliveInterval)
+	defer tick.Stop()
+	var served int64
+	failing := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+		st := mon.Snapshot()
+		if st.Served == served && len(st.Conns) == 0 {
+			continue
+		}
+		served = st.Served
+		if err := link.run(keepaliveCommand); err != nil {
+			if !failing {
+				logf("smart blinder keepalive failed: %v", err)
+			}
+			failing = true
+			continue
+		}
+		failing = false
+		mon.keptAlive(time.Now())
+	}
+}
@@ -0,0 +1,136 @@
+package tunnel
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Status is a snapshot of a running tunnel, as reported over its control
+// socket.
+type Status struct {
+	PID       int          `json:"pid"`
+	Listen    string       `json:"listen"`
+	Host      string       `json:"host"`
+	Started   time.Time    `json:"started"`
+	BytesUp   int64        `json:"bytes_up"`
+	BytesDown int64        `json:"bytes_down"`
+	Served    int64        `json:"served"` // connections opened since start
+	Conns     []ConnStatus `json:"conns"`
+	Forwards  []string     `json:"forwards,omitempty"` // beammeup forward: what is forwarded
+	// KeptAlive is when the server's smart blinder was last told the tunnel
+	// is in use; zero if never.
+	KeptAlive time.Time `json:"kept_alive,omitzero"`
+}
+
+// ConnStatus describes one open connection through the tunnel.
+type ConnStatus struct {
+	Target    string    `json:"target"`
+	Since     time.Time `json:"since"`
+	BytesUp   int64     `json:"bytes_up"`
+	BytesDown int64     `json:"bytes_down"`
+}
+
+// Monitor counts the connections and bytes passing through a tunnel.
+type Monitor struct {
+	mu      sync.Mutex
+	status  Status
+	nextID  uint64
+	open    map[uint64]*trackedConn
+	closedU int64
+	closedD int64
+}
+
+// NewMonitor returns a Monitor for a tunnel listening on listen and exiting
+// at host.
+func NewMonitor(pid int, listen, host string) *Monitor {
+	return &Monitor{
+		status: Status{PID: pid, Listen: listen, Host: host, Started: time.Now()},
+		open:   map[uint64]*trackedConn{},
+	}
+}
+
+// Track wraps dial so every connection it opens is counted.
+func (m *Monitor) Track(dial DialFunc) DialFunc {
+	return func(network, addr string) (net.Conn, error) {
+		conn, err := dial(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		tc := &trackedConn{Conn: conn, m: m, target: addr, since: time.Now()}
+		m.mu.Lock()
+		m.nextID++
+		tc.id = m.nextID
+		m.open[tc.id] = tc
+		m.status.Served++
+		m.mu.Unlock()
+		return tc, nil
+	}
+}
+
+// Snapshot returns the current totals and open connections, oldest first.
+func (m *Monitor) Snapshot() Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st := m.status
+	st.BytesUp, st.BytesDown = m.closedU, m.closedD
+	st.Conns = make([]ConnStatus, 0, len(m.open))
+	for _, tc := range m.open {
+		cs := ConnStatus{Target: tc.target, Since: tc.since, BytesUp: tc.up.Load(), BytesDown: tc.down.Load()}
+		st.BytesUp += cs.BytesUp
+		st.BytesDown += cs.BytesDown
+		st.Conns = append(st.Conns, cs)
+	}
+	sort.Slice(st.Conns, func(i, j int) bool { return st.Conns[i].Since.Before(st.Conns[j].Since) })
+	return st
+}
+
+func (m *Monitor) keptAlive(at time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.status.KeptAlive = at
+}
+
+func (m *Monitor) closed(tc *trackedConn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.open[tc.id]; !ok {
+		return
+	}
+	delete(m.open, tc.id)
+	m.closedU += tc.up.Load()
+	m.closedD += tc.down.Load()
+}
+
+// trackedConn is the outbound side of a tunnelled connection: writes go up
+// to the target, reads come down from it.
+type trackedConn struct {
+	net.Conn
+	m      *Monitor
+	id     uint64
+	target string
+	since  time.Time
+	up     atomic.Int64
+	down   atomic.Int64
+}
+
+func (c *trackedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.down.Add(int64(n))
+	return n, err
+}
+
+func (c *trackedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.up.Add(int64(n))
+	return n, err
+}
+
+func (c *trackedConn) CloseWrite() error { return closeWrite(c.Conn) }
+
+func (c *trackedConn) Close() error {
+	c.m.closed(c)
+	return c.Conn.Close()
+}
@@ -0,0 +1,103 @@
+package tunnel
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultIdleTimeout closes a piped connection that carried no traffic in
+// either direction for this long.
+const DefaultIdleTimeout = 15 * time.Minute
+
+// pipeBufferSize is the copy buffer of each direction of a pipe, which
+// bounds what a connection holds in memory however fast either side sends.
+const pipeBufferSize = 32 << 10
+
+var pipeBuffers = sync.Pool{New: func() any { return new([pipeBufferSize]byte) }}
+
+// errNoHalfClose is returned by closeWrite for connections that can only be
+// closed as a whole.
+var errNoHalfClose = errors.New("connection does not support half-close")
+
+// closeWrite shuts down the write side of conn (TCP, SSH channels), so the
+// peer reads EOF while data can still flow the other way.
+func closeWrite(conn net.Conn) error {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return errNoHalfClose
+}
+
+// pipe copies upload (the client's bytes, usually client itself) to remote
+// and remote back to client, returning once both directions are done. When
+// one side finishes sending, the other side's write half is closed so it sees
+// EOF while its reply keeps streaming. A copy error, a connection that cannot
+// be half-closed, or idle without traffic either way closes both.
+func pipe(client net.Conn, upload io.Reader, remote net.Conn, idle time.Duration) {
+	if idle <= 0 {
+		idle = DefaultIdleTimeout
+	}
+	var last atomic.Int64
+	touch := func() { last.Store(time.Now().UnixNano()) }
+	touch()
+	abort := sync.OnceFunc(func() {
+		client.Close()
+		remote.Close()
+	})
+
+	stop := make(chan struct{})
+	go func() {
+		tick := time.NewTicker(idle / 4)
+		defer tick.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-tick.C:
+				if time.Since(time.Unix(0, last.Load())) >= idle {
+					abort()
+					return
+				}
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { defer wg.Done(); halfPipe(remote, upload, touch, abort) }()
+	go func() { defer wg.Done(); halfPipe(client, remote, touch, abort) }()
+	wg.Wait()
+	close(stop)
+}
+
+// halfPipe copies src to dst, then passes the EOF on by closing dst's write
+// half.
+func halfPipe(dst net.Conn, src io.Reader, touch, abort func()) {
+	buf := pipeBuffers.Get().(*[pipeBufferSize]byte)
+	defer pipeBuffers.Put(buf)
+	// Hiding src's WriteTo keeps the copy on buf: TCP connections and
+	// bufio.Readers would otherwise bring buffers of their own.
+	_, err := io.CopyBuffer(activityWriter{dst, touch}, struct{ io.Reader }{src}, buf[:])
+	if err != nil {
+		abort()
+		return
+	}
+	if closeWrite(dst) != nil {
+		abort()
+	}
+}
+
+// activityWriter notes every write, for the idle timeout.
+type activityWriter struct {
+	w     io.Writer
+	touch func()
+}
+
+func (a activityWriter) Write(p []byte) (int, error) {
+	a.touch()
+	return a.w.Write(p)
+}
@@ -0,0 +1,88 @@
+package tunnel
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tcpPair returns both ends of a loopback TCP connection.
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := ln.Accept()
+		accepted <- conn
+	}()
+	dialed, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	return dialed, <-accepted
+}
+
+func TestPipeHalfClose(t *testing.T) {
+	app, clientEnd := tcpPair(t)
+	remoteEnd, server := tcpPair(t)
+	defer app.Close()
+	defer server.Close()
+
+	done := make(chan struct{})
+	go func() {
+		pipe(clientEnd, clientEnd, remoteEnd, time.Minute)
+		close(done)
+	}()
+
+	// The server answers only once the upload is complete, and slowly.
+	go func() {
+		body, _ := io.ReadAll(server)
+		for range 3 {
+			time.Sleep(20 * time.Millisecond)
+			server.Write([]byte("got " + string(body) + ";"))
+		}
+		server.Close()
+	}()
+
+	app.Write([]byte("upload"))
+	app.(*net.TCPConn).CloseWrite()
+	reply, err := io.ReadAll(app)
+	if err != nil {
+		t.Fatalf("read reply: %v", err)
+	}
+	if want := strings.Repeat("got upload;", 3); string(reply) != want {
+		t.Fatalf("reply = %q, want %q", reply, want)
+	}
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("pipe did not return after both directions finished")
+	}
+}
+
+func TestPipeIdleTimeout(t *testing.T) {
+	app, clientEnd := tcpPair(t)
+	remoteEnd, server := tcpPair(t)
+	defer app.Close()
+	defer server.Close()
+
+	done := make(chan struct{})
+	go func() {
+		pipe(clientEnd, clientEnd, remoteEnd, 100*time.Millisecond)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("idle pipe was not closed")
+	}
+	if _, err := app.Read(make([]byte, 1)); err == nil {
+		t.Fatalf("client connection still open after the idle timeout")
+	}
+}
@@ -0,0 +1,160 @@
+package tunnel
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Route is where a destination's traffic goes.
+type Route string
+
+const (
+	RouteTunnel Route = "tunnel" // through the SSH tunnel or hangar proxy
+	RouteDirect Route = "direct" // straight from this machine
+	RouteBlock  Route = "block"  // refused
+)
+
+// ErrBlocked is returned by a routed DialFunc for destinations a block rule
+// matches.
+var ErrBlocked = errors.New("blocked by a routing rule")
+
+// directDialTimeout bounds connections a direct rule sends around the tunnel.
+const directDialTimeout = 15 * time.Second
+
+// Rules picks a Route per destination. The first matching rule wins; without
+// one, the default applies (tunnel unless the file says otherwise).
+type Rules struct {
+	rules []rule
+	def   Route
+}
+
+type rule struct {
+	route  Route
+	domain string     // matches itself and its subdomains; "*" matches all
+	cidr   *net.IPNet // matches IP literals only
+}
+
+// LoadRules reads a rules file (see ParseRules).
+func LoadRules(path string) (*Rules, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open rules: %w", err)
+	}
+	defer f.Close()
+	rules, err := ParseRules(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return rules, nil
+}
+
+// ParseRules reads one "<route> <pattern>" per line, route being tunnel,
+// direct or block, and pattern a domain (which covers its subdomains), an IP
+// or a CIDR, or * for everything. "default <route>" sets the route of
+// destinations no rule matches. Blank lines and # comments are ignored.
+func ParseRules(r io.Reader) (*Rules, error) {
+	rs := &Rules{def: RouteTunnel}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: want <route> <pattern>", n)
+		}
+		if strings.EqualFold(fields[0], "default") {
+			route, err := parseRoute(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			rs.def = route
+			continue
+		}
+		route, err := parseRoute(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		ru := rule{route: route}
+		pattern := strings.ToLower(fields[1])
+		if _, cidr, err := net.ParseCIDR(pattern); err == nil {
+			ru.cidr = cidr
+		} else if ip := net.ParseIP(pattern); ip != nil {
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			ru.cidr = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		} else {
+			ru.domain = strings.TrimPrefix(strings.TrimSuffix(pattern, "."), "*.")
+		}
+		rs.rules = append(rs.rules, ru)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+func parseRoute(v string) (Route, error) {
+	switch route := Route(strings.ToLower(v)); route {
+	case RouteTunnel, RouteDirect, RouteBlock:
+		return route, nil
+	}
+	return "", fmt.Errorf("unknown route %q (use tunnel, direct or block)", v)
+}
+
+// Match returns the route for host, a domain or an IP literal. CIDR rules
+// only match IP literals: resolving a domain here would leak the lookup
+// outside the tunnel.
+func (rs *Rules) Match(host string) Route {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	ip := net.ParseIP(host)
+	for _, ru := range rs.rules {
+		switch {
+		case ru.cidr != nil:
+			if ip != nil && ru.cidr.Contains(ip) {
+				return ru.route
+			}
+		case ru.domain == "*":
+			return ru.route
+		case ip == nil && (host == ru.domain || strings.HasSuffix(host, "."+ru.domain)):
+			return ru.route
+		}
+	}
+	return rs.def
+}
+
+// Len is the number of rules, the default excluded.
+func (rs *Rules) Len() int { return len(rs.rules) }
+
+// Routed returns a DialFunc that sends each destination where rs says:
+// through tunnel, through direct, or nowhere (ErrBlocked). A nil direct
+// dials from this machine.
+func (rs *Rules) Routed(tunnel, direct DialFunc) DialFunc {
+	if direct == nil {
+		d := &net.Dialer{Timeout: directDialTimeout}
+		direct = d.Dial
+	}
+	return func(network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		switch rs.Match(host) {
+		case RouteDirect:
+			return direct(network, addr)
+		case RouteBlock:
+			return nil, fmt.Errorf("%s: %w", addr, ErrBlocked)
+		default:
+			return tunnel(network, addr)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package tunnel
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestRulesMatch(t *testing.T) {
+	rules, err := ParseRules(strings.NewReader(`
+# corporate stuff stays on the office network
+direct 10.0.0.0/8
+direct intranet.example.com
+block  ads.example.net     # and its subdomains
+tunnel *.example.org
+default direct
+`))
+	if err != nil {
+		t.Fatalf("ParseRules: %v", err)
+	}
+	cases := map[string]Route{
+		"10.1.2.3":                  RouteDirect,
+		"intranet.example.com":      RouteDirect,
+		"wiki.intranet.example.com": RouteDirect,
+		"ads.example.net":           RouteBlock,
+		"x.ads.example.net":         RouteBlock,
+		"badads.example.net":        RouteDirect,
+		"example.org":               RouteTunnel,
+		"www.example.org.":          RouteTunnel,
+		"192.0.2.1":                 RouteDirect,
+	}
+	for host, want := range cases {
+		if got := rules.Match(host); got != want {
+			t.Fatalf("Match(%q) = %s, want %s", host, got, want)
+		}
+	}
+	if rules.Len() != 4 {
+		t.Fatalf("Len = %d", rules.Len())
+	}
+
+	for _, bad := range []string{"proxy example.com", "direct", "default sideways"} {
+		if _, err := ParseRules(strings.NewReader(bad)); err == nil {
+			t.Fatalf("ParseRules(%q) accepted", bad)
+		}
+	}
+}
+
+func TestRulesRouted(t *testing.T) {
+	rules, _ := ParseRules(strings.NewReader("block 192.0.2.0/24\ndirect example.com\n"))
+	var via string
+	fake := func(name string) DialFunc {
+		return func(network, addr string) (net.Conn, error) {
+			via = name
+			return nil, errors.New("not dialing in tests")
+		}
+	}
+	dial := rules.Routed(fake("tunnel"), fake("direct"))
+	if _, err := dial("tcp", "192.0.2.9:443"); !errors.Is(err, ErrBlocked) {
+		t.Fatalf("blocked dial: %v", err)
+	}
+	dial("tcp", "www.example.com:443")
+	if via != "direct" {
+		t.Fatalf("example.com went %s", via)
+	}
+	dial("tcp", "[2001:db8::1]:443")
+	if via != "tunnel" {
+		t.Fatalf("default went %s", via)
+	}
+}
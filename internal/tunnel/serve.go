@@ -0,0 +1,69 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// serve accepts connections on ln and hands each to handle until ctx is
+// cancelled, then waits for in-flight connections to finish.
+func serve(ctx context.Context, ln net.Listener, handle func(net.Conn) error, logf LogFunc) error {
+	// Close listener when context is cancelled.
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	var wg sync.WaitGroup
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				wg.Wait()
+				logf("tunnel closed")
+				return nil
+			default:
+				return fmt.Errorf("accept: %w", err)
+			}
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := handle(conn); err != nil {
+				logf("conn error: %v", err)
+			}
+		}()
+	}
+}
+
+// DefaultMaxConns caps the connections a tunnel serves at once, and so the
+// SSH channels it opens.
+const DefaultMaxConns = 256
+
+// limitConns wraps handle so at most max connections are served at once.
+// Connections over the limit are closed straight away; the limit being
+// reached is logged once until there is room again.
+func limitConns(handle func(net.Conn) error, max int, logf LogFunc) func(net.Conn) error {
+	slots := make(chan struct{}, max)
+	var full atomic.Bool
+	return func(conn net.Conn) error {
+		select {
+		case slots <- struct{}{}:
+		default:
+			conn.Close()
+			if !full.Swap(true) {
+				logf("connection limit (%d) reached; refusing new connections until some close", max)
+			}
+			return nil
+		}
+		defer func() {
+			<-slots
+			full.Store(false)
+		}()
+		return handle(conn)
+	}
+}
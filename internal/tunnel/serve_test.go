@@ -0,0 +1,35 @@
+package tunnel
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLimitConns(t *testing.T) {
+	release := make(chan struct{})
+	handle := limitConns(func(conn net.Conn) error {
+		<-release
+		return conn.Close()
+	}, 1, func(string, ...any) {})
+
+	held, heldPeer := net.Pipe()
+	defer heldPeer.Close()
+	go handle(held)
+	time.Sleep(20 * time.Millisecond)
+
+	refused, refusedPeer := net.Pipe()
+	handle(refused)
+	if _, err := refusedPeer.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("connection over the limit: read err = %v, want EOF", err)
+	}
+
+	close(release)
+	time.Sleep(20 * time.Millisecond)
+	next, nextPeer := net.Pipe()
+	if err := handle(next); err != nil {
+		t.Fatalf("connection after a slot freed: %v", err)
+	}
+	nextPeer.Close()
+}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"net"
 	"strconv"
+	"time"
 )
 
 const (
@@ -14,6 +15,7 @@ const (
 	authNone       = 0x00
 	authNoAccept   = 0xFF
 	cmdConnect     = 0x01
+	cmdBind        = 0x02
 	atypIPv4       = 0x01
 	atypDomain     = 0x03
 	atypIPv6       = 0x04
@@ -26,9 +28,37 @@ const (
 // DialFunc dials a network address. Typically backed by ssh.Client.Dial.
 type DialFunc func(network, addr string) (net.Conn, error)
 
+// ListenFunc listens on a network address. Typically backed by
+// ssh.Client.Listen, so the listener is on the server.
+type ListenFunc func(network, addr string) (net.Listener, error)
+
+// bindTimeout bounds how long a BIND waits for the incoming connection.
+const bindTimeout = 2 * time.Minute
+
+// Handler serves SOCKS5 clients.
+type Handler struct {
+	// Dial establishes outbound connections (through the SSH tunnel).
+	Dial DialFunc
+	// Listen opens the listener for a BIND request. BIND is refused when it
+	// is nil.
+	Listen ListenFunc
+	// BindHost is the address reported to BIND clients when the listener
+	// does not tell its own, typically the server's host.
+	BindHost string
+	// IdleTimeout closes connections without traffic for this long;
+	// zero means DefaultIdleTimeout.
+	IdleTimeout time.Duration
+}
+
 // HandleConn processes a single SOCKS5 connection. dialFn is called to
-// establish the outbound connection (through the SSH tunnel).
+// establish the outbound connection (through the SSH tunnel). BIND is
+// refused.
 func HandleConn(conn net.Conn, dialFn DialFunc) error {
+	return Handler{Dial: dialFn}.Serve(conn)
+}
+
+// Serve processes a single SOCKS5 connection.
+func (h Handler) Serve(conn net.Conn) error {
 	defer conn.Close()
 
 	// --- auth negotiation ---
@@ -73,7 +103,7 @@ func HandleConn(conn net.Conn, dialFn DialFunc) error {
 	if req[0] != socks5Version {
 		return errors.New("bad SOCKS version in request")
 	}
-	if req[1] != cmdConnect {
+	if req[1] != cmdConnect && req[1] != cmdBind {
 		sendReply(conn, repNotAllowed, nil)
 		return fmt.Errorf("unsupported command: %d", req[1])
 	}
@@ -113,25 +143,76 @@ func HandleConn(conn net.Conn, dialFn DialFunc) error {
 	}
 	port := binary.BigEndian.Uint16(portBuf)
 	target := net.JoinHostPort(host, strconv.Itoa(int(port)))
+	if req[1] == cmdBind {
+		return h.bind(conn, host)
+	}
 
 	// --- connect via tunnel ---
-	remote, err := dialFn("tcp", target)
+	remote, err := h.Dial("tcp", target)
 	if err != nil {
-		sendReply(conn, repHostUnreach, nil)
+		if errors.Is(err, ErrBlocked) {
+			sendReply(conn, repNotAllowed, nil)
+		} else {
+			sendReply(conn, repHostUnreach, nil)
+		}
 		return fmt.Errorf("dial %s: %w", target, err)
 	}
 	defer remote.Close()
 
 	sendReply(conn, repSuccess, remote.LocalAddr())
+	pipe(conn, conn, remote, h.IdleTimeout)
+	return nil
+}
+
+// bind serves a BIND request: listen on the server, tell the client where
+// (first reply), wait for one connection from peer (the request's DST.ADDR)
+// and tell the client who connected (second reply), then relay. Protocols
+// like active FTP use it for their data connections.
+func (h Handler) bind(conn net.Conn, peer string) error {
+	if h.Listen == nil {
+		sendReply(conn, repNotAllowed, nil)
+		return errors.New("BIND refused: not enabled for this tunnel")
+	}
+	ln, err := h.Listen("tcp", "0.0.0.0:0")
+	if err != nil {
+		sendReply(conn, repFailure, nil)
+		return fmt.Errorf("bind: listen on the server: %w", err)
+	}
+	defer ln.Close()
+
+	bound, _ := ln.Addr().(*net.TCPAddr)
+	if bound == nil {
+		sendReply(conn, repFailure, nil)
+		return fmt.Errorf("bind: unexpected listener address %v", ln.Addr())
+	}
+	if bound.IP == nil || bound.IP.IsUnspecified() || bound.IP.IsLoopback() {
+		if ip, err := net.ResolveIPAddr("ip4", h.BindHost); err == nil {
+			bound = &net.TCPAddr{IP: ip.IP, Port: bound.Port}
+		}
+	}
+	sendReply(conn, repSuccess, bound)
 
-	// --- bidirectional pipe ---
-	done := make(chan struct{}, 2)
-	go func() { io.Copy(remote, conn); done <- struct{}{} }()
-	go func() { io.Copy(conn, remote); done <- struct{}{} }()
-	<-done
+	timer := time.AfterFunc(bindTimeout, func() { ln.Close() })
+	remote, err := ln.Accept()
+	timer.Stop()
+	if err != nil {
+		sendReply(conn, repFailure, nil)
+		return fmt.Errorf("bind: no incoming connection: %w", err)
+	}
+	defer remote.Close()
+
+	// DST.ADDR names the host expected to connect; anything else is refused.
+	from, _ := remote.RemoteAddr().(*net.TCPAddr)
+	if want := net.ParseIP(peer); want != nil && !want.IsUnspecified() && (from == nil || !from.IP.Equal(want)) {
+		sendReply(conn, repNotAllowed, nil)
+		return fmt.Errorf("bind: refused connection from %v, expected %s", remote.RemoteAddr(), peer)
+	}
+	sendReply(conn, repSuccess, remote.RemoteAddr())
+	pipe(conn, conn, remote, h.IdleTimeout)
 	return nil
 }
 
+// pipe copies between the client and remote until one side finishes.
 func sendReply(conn net.Conn, rep byte, bindAddr net.Addr) {
 	// +----+-----+-------+------+----------+----------+
 	// |VER | REP |  RSV  | ATYP | BND.ADDR | BND.PORT |
@@ -0,0 +1,167 @@
+package tunnel
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"testing"
+)
+
+func TestDialSOCKS5ThroughHandleConn(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen echo: %v", err)
+	}
+	defer echo.Close()
+	go func() {
+		c, err := echo.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.Copy(c, c)
+	}()
+
+	proxy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen proxy: %v", err)
+	}
+	defer proxy.Close()
+	go func() {
+		c, err := proxy.Accept()
+		if err != nil {
+			return
+		}
+		HandleConn(c, net.Dial)
+	}()
+
+	conn, err := DialSOCKS5(net.Dial, proxy.Addr().String(), "", "", echo.Addr().String())
+	if err != nil {
+		t.Fatalf("DialSOCKS5: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(buf) != "ping" {
+		t.Fatalf("unexpected echo %q", buf)
+	}
+}
+
+func TestDialSOCKS5AuthRejected(t *testing.T) {
+	proxy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer proxy.Close()
+	go func() {
+		c, err := proxy.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		greeting := make([]byte, 3)
+		io.ReadFull(c, greeting)
+		c.Write([]byte{socks5Version, authUserPass})
+		head := make([]byte, 2)
+		io.ReadFull(c, head)
+		io.ReadFull(c, make([]byte, head[1]))
+		l := make([]byte, 1)
+		io.ReadFull(c, l)
+		io.ReadFull(c, make([]byte, l[0]))
+		c.Write([]byte{userPassVersion, 0x01})
+	}()
+
+	_, err = DialSOCKS5(net.Dial, proxy.Addr().String(), "beam", "wrong", "example.invalid:443")
+	if err != ErrSOCKSAuthRejected {
+		t.Fatalf("expected ErrSOCKSAuthRejected, got %v", err)
+	}
+}
+
+// socks5Request greets the proxy at addr without auth and sends cmd for
+// 127.0.0.1:port.
+func socks5Request(t *testing.T, addr string, cmd byte, port int) net.Conn {
+	t.Helper()
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial proxy: %v", err)
+	}
+	c.Write([]byte{socks5Version, 1, authNone})
+	if _, err := io.ReadFull(c, make([]byte, 2)); err != nil {
+		t.Fatalf("auth reply: %v", err)
+	}
+	c.Write([]byte{socks5Version, cmd, 0, atypIPv4, 127, 0, 0, 1, byte(port >> 8), byte(port)})
+	return c
+}
+
+func readReply(t *testing.T, c net.Conn) (byte, int) {
+	t.Helper()
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(c, reply); err != nil {
+		t.Fatalf("read reply: %v", err)
+	}
+	return reply[1], int(reply[8])<<8 | int(reply[9])
+}
+
+func TestBind(t *testing.T) {
+	proxy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen proxy: %v", err)
+	}
+	defer proxy.Close()
+	h := Handler{Dial: net.Dial, Listen: func(network, _ string) (net.Listener, error) {
+		return net.Listen(network, "127.0.0.1:0")
+	}, BindHost: "127.0.0.1"}
+	go func() {
+		for {
+			c, err := proxy.Accept()
+			if err != nil {
+				return
+			}
+			go h.Serve(c)
+		}
+	}()
+
+	client := socks5Request(t, proxy.Addr().String(), cmdBind, 0)
+	defer client.Close()
+	rep, port := readReply(t, client)
+	if rep != repSuccess || port == 0 {
+		t.Fatalf("first reply = %d, port %d", rep, port)
+	}
+	peer, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatalf("dial bound port: %v", err)
+	}
+	defer peer.Close()
+	if rep, _ := readReply(t, client); rep != repSuccess {
+		t.Fatalf("second reply = %d", rep)
+	}
+	peer.Write([]byte("data"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(client, buf); err != nil || string(buf) != "data" {
+		t.Fatalf("relayed %q, %v", buf, err)
+	}
+
+	// Without a ListenFunc, BIND is refused.
+	denyLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer denyLn.Close()
+	go func() {
+		c, err := denyLn.Accept()
+		if err == nil {
+			HandleConn(c, net.Dial)
+		}
+	}()
+	denied := socks5Request(t, denyLn.Addr().String(), cmdBind, 0)
+	defer denied.Close()
+	if rep, _ := readReply(t, denied); rep != repNotAllowed {
+		t.Fatalf("BIND without Listen = %d, want not allowed", rep)
+	}
+}
@@ -0,0 +1,135 @@
+package tunnel
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+const (
+	authUserPass      = 0x02
+	userPassVersion   = 0x01
+	userPassStatusOK  = 0x00
+	maxUserPassLength = 255
+)
+
+// ErrSOCKSAuthRejected is returned when a SOCKS5 server refuses the supplied
+// username/password.
+var ErrSOCKSAuthRejected = errors.New("socks5 server rejected credentials")
+
+// DialSOCKS5 connects to target through the SOCKS5 server at proxyAddr,
+// authenticating with user/pass when user is non-empty. forward dials the
+// proxy itself (net.Dial or an SSH channel).
+func DialSOCKS5(forward DialFunc, proxyAddr, user, pass, target string) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target %q: %w", target, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid target port %q", portStr)
+	}
+	if len(user) > maxUserPassLength || len(pass) > maxUserPassLength {
+		return nil, errors.New("socks5 username/password too long")
+	}
+
+	conn, err := forward("tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	if err := socks5Handshake(conn, user, pass, host, port); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func socks5Handshake(conn net.Conn, user, pass, host string, port int) error {
+	method := byte(authNone)
+	if user != "" {
+		method = authUserPass
+	}
+	if _, err := conn.Write([]byte{socks5Version, 1, method}); err != nil {
+		return fmt.Errorf("write greeting: %w", err)
+	}
+	resp := make([]byte, 2)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return fmt.Errorf("read greeting: %w", err)
+	}
+	if resp[0] != socks5Version {
+		return errors.New("unexpected SOCKS version from server")
+	}
+	if resp[1] == authNoAccept {
+		return ErrSOCKSAuthRejected
+	}
+	if resp[1] != method {
+		return fmt.Errorf("server selected unsupported auth method %d", resp[1])
+	}
+
+	if method == authUserPass {
+		msg := []byte{userPassVersion, byte(len(user))}
+		msg = append(msg, user...)
+		msg = append(msg, byte(len(pass)))
+		msg = append(msg, pass...)
+		if _, err := conn.Write(msg); err != nil {
+			return fmt.Errorf("write credentials: %w", err)
+		}
+		if _, err := io.ReadFull(conn, resp); err != nil {
+			return fmt.Errorf("read auth status: %w", err)
+		}
+		if resp[1] != userPassStatusOK {
+			return ErrSOCKSAuthRejected
+		}
+	}
+
+	req := []byte{socks5Version, cmdConnect, 0x00}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			req = append(req, atypIPv4)
+			req = append(req, ip4...)
+		} else {
+			req = append(req, atypIPv6)
+			req = append(req, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return errors.New("target hostname too long")
+		}
+		req = append(req, atypDomain, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return fmt.Errorf("write connect request: %w", err)
+	}
+
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return fmt.Errorf("read connect reply: %w", err)
+	}
+	if head[1] != repSuccess {
+		return fmt.Errorf("socks5 connect to %s failed (reply %d)", net.JoinHostPort(host, strconv.Itoa(port)), head[1])
+	}
+	var skip int
+	switch head[3] {
+	case atypIPv4:
+		skip = 4
+	case atypIPv6:
+		skip = 16
+	case atypDomain:
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(conn, l); err != nil {
+			return fmt.Errorf("read bind address: %w", err)
+		}
+		skip = int(l[0])
+	default:
+		return fmt.Errorf("unsupported bind address type %d", head[3])
+	}
+	if _, err := io.ReadFull(conn, make([]byte, skip+2)); err != nil {
+		return fmt.Errorf("read bind address: %w", err)
+	}
+	return nil
+}
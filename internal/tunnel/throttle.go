@@ -0,0 +1,132 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ParseRate reads a bandwidth in bytes per second: a number with an optional
+// decimal unit, as in 500k, 2M, 1.5MB or 10MB/s. Zero means no limit.
+func ParseRate(v string) (int64, error) {
+	s := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(v)), "/S")
+	s = strings.TrimSuffix(s, "B")
+	mult := 1.0
+	if s != "" {
+		if i := strings.IndexByte("KMG", s[len(s)-1]); i >= 0 {
+			mult = []float64{1e3, 1e6, 1e9}[i]
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid rate %q (use e.g. 500k, 2M or 1.5MB/s)", v)
+	}
+	return int64(n * mult), nil
+}
+
+// rateLabel describes the limits Run applies.
+func rateLabel(total, perConn int64) string {
+	var parts []string
+	if total > 0 {
+		parts = append(parts, fmt.Sprintf("%s total", formatRate(total)))
+	}
+	if perConn > 0 {
+		parts = append(parts, fmt.Sprintf("%s per connection", formatRate(perConn)))
+	}
+	return strings.Join(parts, ", ") + " in each direction"
+}
+
+func formatRate(n int64) string {
+	switch {
+	case n >= 1e9:
+		return strconv.FormatFloat(float64(n)/1e9, 'f', -1, 64) + " GB/s"
+	case n >= 1e6:
+		return strconv.FormatFloat(float64(n)/1e6, 'f', -1, 64) + " MB/s"
+	case n >= 1e3:
+		return strconv.FormatFloat(float64(n)/1e3, 'f', -1, 64) + " kB/s"
+	}
+	return strconv.FormatInt(n, 10) + " B/s"
+}
+
+// bucket is a token bucket of bytes refilled at rate per second, holding at
+// most one second's worth. Callers take what they send and sleep off any
+// debt, so a large write waits in proportion to its size.
+type bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate int64) *bucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &bucket{rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+// wait takes n bytes from b, sleeping until they are covered. A nil bucket
+// never waits.
+func (b *bucket) wait(n int) {
+	if b == nil || n <= 0 {
+		return
+	}
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*b.rate, b.rate)
+	b.last = now
+	b.tokens -= float64(n)
+	var debt time.Duration
+	if b.tokens < 0 {
+		debt = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+	time.Sleep(debt)
+}
+
+// Throttle caps the bandwidth of connections opened by dial, in bytes per
+// second and in each direction separately: perConn for every connection on
+// its own, total for all of them together. Zero leaves a limit off.
+func Throttle(dial DialFunc, perConn, total int64) DialFunc {
+	if perConn <= 0 && total <= 0 {
+		return dial
+	}
+	totalUp, totalDown := newBucket(total), newBucket(total)
+	return func(network, addr string) (net.Conn, error) {
+		conn, err := dial(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &throttledConn{
+			Conn: conn,
+			up:   []*bucket{newBucket(perConn), totalUp},
+			down: []*bucket{newBucket(perConn), totalDown},
+		}, nil
+	}
+}
+
+type throttledConn struct {
+	net.Conn
+	up, down []*bucket
+}
+
+func (c *throttledConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	for _, b := range c.down {
+		b.wait(n)
+	}
+	return n, err
+}
+
+func (c *throttledConn) Write(p []byte) (int, error) {
+	for _, b := range c.up {
+		b.wait(len(p))
+	}
+	return c.Conn.Write(p)
+}
+
+func (c *throttledConn) CloseWrite() error { return closeWrite(c.Conn) }
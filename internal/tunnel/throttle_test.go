@@ -0,0 +1,58 @@
+package tunnel
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	cases := map[string]int64{
+		"0":        0,
+		"512":      512,
+		"500k":     500_000,
+		"2M":       2_000_000,
+		"1.5MB":    1_500_000,
+		"10MB/s":   10_000_000,
+		" 1g ":     1_000_000_000,
+		"250 kB/s": 250_000,
+	}
+	for in, want := range cases {
+		got, err := ParseRate(in)
+		if err != nil || got != want {
+			t.Fatalf("ParseRate(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "fast", "-1M", "2T"} {
+		if _, err := ParseRate(in); err == nil {
+			t.Fatalf("ParseRate(%q) succeeded", in)
+		}
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	const rate = 20_000
+	dial := Throttle(func(string, string) (net.Conn, error) {
+		client, server := net.Pipe()
+		go io.Copy(io.Discard, server)
+		return client, nil
+	}, rate, 0)
+	conn, err := dial("tcp", "example.com:443")
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	// The first second's worth passes at once, the next half second's
+	// worth has to wait for it.
+	start := time.Now()
+	conn.Write(make([]byte, rate))
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Fatalf("burst took %s", d)
+	}
+	conn.Write(make([]byte, rate/2))
+	if d := time.Since(start); d < 400*time.Millisecond {
+		t.Fatalf("throttled write took only %s", d)
+	}
+}
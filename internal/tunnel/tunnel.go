@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"os"
 	"sync"
+	"time"
 
 	"github.com/alfaoz/beammeup/internal/sshx"
 )
@@ -12,19 +14,46 @@ import (
 // LogFunc is called for status messages.
 type LogFunc func(format string, args ...any)
 
+// Config tunes the local proxy Run serves. The zero value is a plain SOCKS5
+// CONNECT proxy.
+type Config struct {
+	// AllowBind lets SOCKS5 clients use BIND, which listens on an ephemeral
+	// port of the server for one incoming connection (active FTP data
+	// connections, some P2P tools). Off by default.
+	AllowBind bool
+	// Rules, when set, send some destinations direct or block them instead
+	// of tunnelling everything.
+	Rules *Rules
+	// ConnLogPath, when set, appends a JSON line per connection (time,
+	// destination, bytes, duration) to this file. Off by default.
+	ConnLogPath string
+	// IdleTimeout closes connections without traffic for this long; zero
+	// means DefaultIdleTimeout.
+	IdleTimeout time.Duration
+	// MaxConns caps the connections served at once, each being an SSH
+	// channel; zero means DefaultMaxConns.
+	MaxConns int
+	// Rate and ConnRate cap tunnelled bandwidth in bytes per second, in each
+	// direction: Rate for all connections together, ConnRate for each one.
+	// Zero leaves a limit off; direct routes are never throttled.
+	Rate, ConnRate int64
+}
+
 // Run connects to the target via SSH and starts a local SOCKS5 proxy that
-// tunnels all traffic through the SSH connection. It blocks until ctx is
-// cancelled or a fatal error occurs.
-func Run(ctx context.Context, target sshx.Target, opts sshx.ConnectOptions, localAddr string, logf LogFunc) error {
+// tunnels all traffic through the SSH connection. When controlPath is set, a
+// unix socket there answers status, stop and reload (reconnect SSH)
+// requests. It blocks until ctx is cancelled, a stop is requested, or a fatal
+// error occurs.
+func Run(ctx context.Context, target sshx.Target, opts sshx.ConnectOptions, localAddr, controlPath string, cfg Config, logf LogFunc) error {
 	if logf == nil {
 		logf = func(string, ...any) {}
 	}
 
-	client, err := sshx.ConnectWithOptions(target, opts)
-	if err != nil {
+	link := &sshLink{target: target, opts: opts}
+	if err := link.connect(ctx); err != nil {
 		return fmt.Errorf("ssh connect: %w", err)
 	}
-	defer client.Close()
+	defer link.close()
 
 	ln, err := net.Listen("tcp", localAddr)
 	if err != nil {
@@ -32,34 +61,112 @@ func Run(ctx context.Context, target sshx.Target, opts sshx.ConnectOptions, loca
 	}
 	defer ln.Close()
 
-	logf("stealth tunnel active at %s", ln.Addr())
-	logf("all traffic is routed through SSH to %s", target.Host)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	mon := NewMonitor(os.Getpid(), ln.Addr().String(), target.Host)
+	if controlPath != "" {
+		cl, err := ListenControl(controlPath)
+		if err != nil {
+			return err
+		}
+		go ServeControl(ctx, cl, ControlHandler{
+			Status: mon.Snapshot,
+			Stop:   cancel,
+			Reload: func() error {
+				if err := link.connect(ctx); err != nil {
+					return fmt.Errorf("ssh reconnect: %w", err)
+				}
+				logf("ssh connection re-established")
+				return nil
+			},
+		}, logf)
+	}
 
-	// Close listener when context is cancelled.
-	go func() {
-		<-ctx.Done()
-		ln.Close()
-	}()
+	go keepalive(ctx, link, mon, logf)
 
-	var wg sync.WaitGroup
-	for {
-		conn, err := ln.Accept()
+	logf("stealth tunnel active at %s", ln.Addr())
+	if cfg.Rules == nil {
+		logf("all traffic is routed through SSH to %s", target.Host)
+	}
+
+	h := Handler{Dial: Throttle(mon.Track(link.dial), cfg.ConnRate, cfg.Rate), IdleTimeout: cfg.IdleTimeout}
+	if cfg.Rate > 0 || cfg.ConnRate > 0 {
+		logf("bandwidth limits active: %s", rateLabel(cfg.Rate, cfg.ConnRate))
+	}
+	if cfg.Rules != nil {
+		h.Dial = cfg.Rules.Routed(h.Dial, nil)
+		logf("routing rules active: %d rule(s)", cfg.Rules.Len())
+	}
+	if cfg.ConnLogPath != "" {
+		f, err := os.OpenFile(cfg.ConnLogPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
 		if err != nil {
-			select {
-			case <-ctx.Done():
-				wg.Wait()
-				logf("tunnel closed")
-				return nil
-			default:
-				return fmt.Errorf("accept: %w", err)
-			}
+			return fmt.Errorf("open connection log: %w", err)
 		}
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			if err := HandleConn(conn, client.Dial); err != nil {
-				logf("conn error: %v", err)
-			}
-		}()
+		defer f.Close()
+		h.Dial = NewConnLog(f).Wrap(h.Dial)
+		logf("logging connections to %s", cfg.ConnLogPath)
+	}
+	if cfg.AllowBind {
+		// BIND replies carry the server's address, not a ~/.ssh/config alias.
+		h.Listen, h.BindHost = link.listen, opts.Resolve(target).Host
+		logf("SOCKS5 BIND allowed: incoming connections are accepted on the server")
+	}
+	maxConns := cfg.MaxConns
+	if maxConns <= 0 {
+		maxConns = DefaultMaxConns
 	}
+	return serve(ctx, ln, limitConns(h.Serve, maxConns, logf), logf)
+}
+
+// sshLink is the tunnel's SSH connection. connect can be called again to
+// replace it; connections still open on the old one are dropped.
+type sshLink struct {
+	target sshx.Target
+	opts   sshx.ConnectOptions
+
+	mu     sync.Mutex
+	client *sshx.Client
+}
+
+func (l *sshLink) connect(ctx context.Context) error {
+	client, err := sshx.ConnectContext(ctx, l.target, l.opts)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	old := l.client
+	l.client = client
+	l.mu.Unlock()
+	// The old connection is usually why a reload was asked for; closing it
+	// can fail and that is fine.
+	old.Close()
+	return nil
+}
+
+func (l *sshLink) dial(network, addr string) (net.Conn, error) {
+	l.mu.Lock()
+	client := l.client
+	l.mu.Unlock()
+	return client.Dial(network, addr)
+}
+
+func (l *sshLink) listen(network, addr string) (net.Listener, error) {
+	l.mu.Lock()
+	client := l.client
+	l.mu.Unlock()
+	return client.Listen(network, addr)
+}
+
+func (l *sshLink) run(command string) error {
+	l.mu.Lock()
+	client := l.client
+	l.mu.Unlock()
+	_, err := client.RunCombined(command)
+	return err
+}
+
+func (l *sshLink) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.client.Close()
 }
@@ -0,0 +1,125 @@
+package update
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Install methods. Only InstallArchive is updated by replacing the binary;
+// the others belong to a package manager, which does the upgrade.
+const (
+	InstallArchive  = "archive" // release archive or install.sh
+	InstallHomebrew = "homebrew"
+	InstallDeb      = "deb"
+	InstallRPM      = "rpm"
+)
+
+// HomebrewTap is the tap the release formula is published to.
+const HomebrewTap = "alfaoz/tap"
+
+// Install is how the running beammeup was installed.
+type Install struct {
+	Method  string
+	Path    string // the executable, symlinks resolved
+	Package string // owning package for deb and rpm installs
+}
+
+// DetectInstall works out how the running executable was installed.
+func DetectInstall() (Install, error) {
+	execPath, err := os.Executable()
+	if err != nil {
+		return Install{}, fmt.Errorf("resolve executable path: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(execPath); err == nil {
+		execPath = resolved
+	}
+	return detectInstall(execPath, queryCommand), nil
+}
+
+func queryCommand(name string, args ...string) (string, error) {
+	if _, err := exec.LookPath(name); err != nil {
+		return "", err
+	}
+	out, err := exec.Command(name, args...).Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// detectInstall asks the package databases whether they own path; query runs
+// a command and returns its output.
+func detectInstall(path string, query func(name string, args ...string) (string, error)) Install {
+	inst := Install{Method: InstallArchive, Path: path}
+	if strings.Contains(filepath.ToSlash(path), "/Cellar/beammeup/") {
+		inst.Method = InstallHomebrew
+		return inst
+	}
+	// dpkg-query -S prints "<package>: <path>".
+	if out, err := query("dpkg-query", "-S", path); err == nil {
+		if pkg, _, ok := strings.Cut(out, ":"); ok && pkg != "" {
+			inst.Method, inst.Package = InstallDeb, pkg
+			return inst
+		}
+	}
+	if out, err := query("rpm", "-qf", "--queryformat", "%{NAME}", path); err == nil && out != "" {
+		inst.Method, inst.Package = InstallRPM, out
+		return inst
+	}
+	return inst
+}
+
+// AssetName is the release file this install upgrades from.
+func (i Install) AssetName(osName, archName string) string {
+	switch i.Method {
+	case InstallDeb:
+		return fmt.Sprintf("beammeup_%s_%s.deb", osName, archName)
+	case InstallRPM:
+		return fmt.Sprintf("beammeup_%s_%s.rpm", osName, archName)
+	}
+	return fmt.Sprintf("beammeup_%s_%s.tar.gz", osName, archName)
+}
+
+// UpgradeHint is how to upgrade this install by hand.
+func (i Install) UpgradeHint() string {
+	switch i.Method {
+	case InstallHomebrew:
+		return "brew upgrade beammeup"
+	case InstallDeb, InstallRPM:
+		return "beammeup --self-update (installs the new ." + i.Method + " with the package manager)"
+	}
+	return "beammeup --self-update"
+}
+
+// upgradePackage hands a downloaded package (or, for Homebrew, the whole
+// upgrade) to the package manager, with sudo when not running as root.
+func (i Install) upgradePackage(pkgPath string) error {
+	var args []string
+	switch i.Method {
+	case InstallHomebrew:
+		return runTool("brew", "upgrade", "beammeup")
+	case InstallDeb:
+		args = []string{"dpkg", "-i", pkgPath}
+	case InstallRPM:
+		args = []string{"rpm", "-U", pkgPath}
+	default:
+		return fmt.Errorf("%s installs are not upgraded by a package manager", i.Method)
+	}
+	if os.Geteuid() != 0 {
+		if _, err := exec.LookPath("sudo"); err != nil {
+			return errors.New("installing the ." + i.Method + " needs root; run as root or install sudo")
+		}
+		args = append([]string{"sudo"}, args...)
+	}
+	return runTool(args[0], args[1:]...)
+}
+
+func runTool(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stderr, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %w", strings.Join(append([]string{name}, args...), " "), err)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package update
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDetectInstall(t *testing.T) {
+	none := func(string, ...string) (string, error) { return "", errors.New("not owned") }
+	if got := detectInstall("/home/u/.local/bin/beammeup", none); got.Method != InstallArchive {
+		t.Fatalf("expected archive install, got %+v", got)
+	}
+	if got := detectInstall("/opt/homebrew/Cellar/beammeup/2.1.0/bin/beammeup", none); got.Method != InstallHomebrew {
+		t.Fatalf("expected homebrew install, got %+v", got)
+	}
+
+	dpkg := func(name string, args ...string) (string, error) {
+		if name == "dpkg-query" {
+			return "beammeup: " + args[1], nil
+		}
+		return "", errors.New("not found")
+	}
+	got := detectInstall("/usr/bin/beammeup", dpkg)
+	if got.Method != InstallDeb || got.Package != "beammeup" || got.AssetName("linux", "amd64") != "beammeup_linux_amd64.deb" {
+		t.Fatalf("expected deb install, got %+v", got)
+	}
+
+	rpm := func(name string, _ ...string) (string, error) {
+		if name == "rpm" {
+			return "beammeup", nil
+		}
+		return "", errors.New("not found")
+	}
+	if got := detectInstall("/usr/bin/beammeup", rpm); got.Method != InstallRPM || got.AssetName("linux", "arm64") != "beammeup_linux_arm64.rpm" {
+		t.Fatalf("expected rpm install, got %+v", got)
+	}
+}
@@ -4,7 +4,10 @@ import (
 	"archive/tar"
 	"bufio"
 	"compress/gzip"
+	"context"
+	"crypto/ed25519"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -15,6 +18,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 	"time"
 
@@ -24,6 +28,7 @@ import (
 type Result struct {
 	Version string
 	Updated bool
+	Method  string // Install.Method of the updated executable
 }
 
 const (
@@ -32,6 +37,11 @@ const (
 	maxUpdateBinaryBytes     = int64(80 << 20)  // 80 MiB
 )
 
+// releasePublicKey is the ed25519 key, base64, that SHA256SUMS.sig must
+// verify against. scripts/build-release.sh sets it from the release signing
+// key; a build without one refuses to self-update.
+var releasePublicKey = ""
+
 type ghRelease struct {
 	TagName string `json:"tag_name"`
 	Assets  []struct {
@@ -40,42 +50,173 @@ type ghRelease struct {
 	} `json:"assets"`
 }
 
+// SelfUpdate installs the latest release. Package manager installs are
+// upgraded through their package manager rather than by overwriting the
+// binary: Homebrew with brew upgrade, deb and rpm by installing the release's
+// package.
 func SelfUpdate(baseURL string) (Result, error) {
 	execPath, err := os.Executable()
 	if err != nil {
 		return Result{}, fmt.Errorf("resolve executable path: %w", err)
 	}
+	inst, err := DetectInstall()
+	if err != nil {
+		return Result{}, err
+	}
 
-	osName, archName, err := platformAssetParts()
+	osName, archName, err := Platform()
 	if err != nil {
 		return Result{}, err
 	}
-	assetName := fmt.Sprintf("beammeup_%s_%s.tar.gz", osName, archName)
+	// Homebrew upgrades from its formula; the archive entry still tells
+	// whether a source has a release for this platform.
+	assetName := inst.AssetName(osName, archName)
 
 	base := strings.TrimRight(strings.TrimSpace(baseURL), "/")
+	var sources []source
 	if base != "" {
 		if err := validateBaseURL(base); err != nil {
 			return Result{}, err
 		}
-		res, err := selfUpdateFromMirror(execPath, base, assetName)
+		sources = append(sources, mirrorSource(base))
+	}
+	// beammeup.pw is the default and GitHub serves the same releases; a
+	// custom mirror is used on its own.
+	if base == "" || base == "https://beammeup.pw" {
+		sources = append(sources, githubSource())
+	}
+
+	var errs []error
+	for len(sources) > 0 {
+		m, err := probeFirst(context.Background(), sources, assetName)
+		if err != nil {
+			errs = append(errs, err)
+			break
+		}
+		if m.version == version.AppVersion {
+			return Result{Version: m.version, Updated: false, Method: inst.Method}, nil
+		}
+		if inst.Method == InstallHomebrew {
+			if err := inst.upgradePackage(""); err != nil {
+				return Result{}, err
+			}
+			return Result{Version: m.version, Updated: true, Method: inst.Method}, nil
+		}
+		err = installRelease(inst, execPath, m.src.assetURL(assetName), m.sums, assetName)
 		if err == nil {
-			return res, nil
+			return Result{Version: m.version, Updated: true, Method: inst.Method}, nil
 		}
-		// If the mirror looks compromised (checksum mismatch), don't fall back.
+		// If the source looks compromised (checksum mismatch), don't fall back.
 		var ie *integrityError
 		if errors.As(err, &ie) {
 			return Result{}, err
 		}
-		// beammeup.pw is the default; fall back to GitHub if the mirror isn't available.
-		if base != "https://beammeup.pw" {
-			return Result{}, err
+		errs = append(errs, fmt.Errorf("%s: %w", m.src.name, err))
+		sources = slices.DeleteFunc(sources, func(s source) bool { return s.name == m.src.name })
+	}
+	return Result{}, errors.Join(errs...)
+}
+
+// source is one place releases are published.
+type source struct {
+	name    string
+	baseURL string // <baseURL>/<file> for version.txt, SHA256SUMS and the archives
+	tagURL  string // optional releases API consulted when version.txt is missing
+}
+
+func (s source) assetURL(name string) string { return s.baseURL + "/" + name }
+
+func mirrorSource(base string) source {
+	return source{name: "mirror", baseURL: base + "/releases/latest"}
+}
+
+func githubSource() source {
+	return source{
+		name:    "github",
+		baseURL: fmt.Sprintf("https://github.com/%s/releases/latest/download", version.DefaultRepo),
+		tagURL:  fmt.Sprintf("https://api.github.com/repos/%s/releases/latest", version.DefaultRepo),
+	}
+}
+
+// manifest is what a source says about its latest release.
+type manifest struct {
+	src     source
+	version string
+	sums    string // SHA256SUMS, checked to list the archive for this platform
+}
+
+// probe reads a source's manifest. It fails unless the source names a
+// version and publishes a checksum for assetName in a SHA256SUMS signed with
+// the release key.
+func probe(ctx context.Context, src source, assetName string) (manifest, error) {
+	newVersion := ""
+	v, err := fetchText(ctx, src.assetURL("version.txt"), 1024)
+	if err == nil {
+		newVersion = normalizeVersion(v)
+	}
+	if newVersion == "" && src.tagURL != "" {
+		rel, err := fetchLatestRelease(ctx, src.tagURL)
+		if err != nil {
+			return manifest{}, fmt.Errorf("%s: %w", src.name, err)
 		}
+		newVersion = normalizeVersion(rel.TagName)
 	}
+	if newVersion == "" {
+		if err != nil {
+			return manifest{}, fmt.Errorf("%s: version.txt fetch failed: %w", src.name, err)
+		}
+		return manifest{}, fmt.Errorf("%s: version.txt was empty", src.name)
+	}
+
+	sums, err := fetchText(ctx, src.assetURL("SHA256SUMS"), maxUpdateSHA256SUMSBytes)
+	if err != nil {
+		return manifest{}, fmt.Errorf("%s: failed to download SHA256SUMS: %w", src.name, err)
+	}
+	sig, err := fetchText(ctx, src.assetURL("SHA256SUMS.sig"), 1024)
+	if err != nil {
+		return manifest{}, fmt.Errorf("%s: failed to download SHA256SUMS.sig: %w", src.name, err)
+	}
+	if err := verifySumsSignature(sums, sig); err != nil {
+		return manifest{}, fmt.Errorf("%s: %w", src.name, err)
+	}
+	if _, err := expectedSHA256(sums, assetName); err != nil {
+		return manifest{}, fmt.Errorf("%s: %w", src.name, err)
+	}
+	return manifest{src: src, version: newVersion, sums: sums}, nil
+}
 
-	return selfUpdateFromGitHub(execPath, assetName)
+// probeFirst probes the sources concurrently and returns the first valid
+// manifest, so a slow source costs nothing when another answers. The probes
+// still running are cancelled. Without any valid manifest it returns every
+// source's error.
+func probeFirst(ctx context.Context, sources []source, assetName string) (manifest, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type answer struct {
+		m   manifest
+		err error
+	}
+	answers := make(chan answer, len(sources))
+	for _, src := range sources {
+		go func() {
+			m, err := probe(ctx, src, assetName)
+			answers <- answer{m, err}
+		}()
+	}
+	var errs []error
+	for range sources {
+		a := <-answers
+		if a.err == nil {
+			return a.m, nil
+		}
+		errs = append(errs, a.err)
+	}
+	return manifest{}, errors.Join(errs...)
 }
 
-func platformAssetParts() (string, string, error) {
+// Platform returns the OS and architecture names used in release file names.
+func Platform() (string, string, error) {
 	var osName string
 	switch runtime.GOOS {
 	case "darwin":
@@ -98,67 +239,18 @@ func platformAssetParts() (string, string, error) {
 	return osName, archName, nil
 }
 
-func selfUpdateFromMirror(execPath, base, assetName string) (Result, error) {
-	downloadURL := fmt.Sprintf("%s/releases/latest/%s", base, assetName)
-	sumsURL := fmt.Sprintf("%s/releases/latest/SHA256SUMS", base)
-	versionURL := fmt.Sprintf("%s/releases/latest/version.txt", base)
-
-	newVersionRaw, err := fetchText(versionURL, 1024)
-	if err != nil {
-		return Result{}, fmt.Errorf("mirror version.txt fetch failed: %w", err)
-	}
-	newVersion := normalizeVersion(newVersionRaw)
-	if newVersion == "" {
-		return Result{}, fmt.Errorf("mirror version.txt was empty")
-	}
-	if newVersion == version.AppVersion {
-		return Result{Version: newVersion, Updated: false}, nil
-	}
-
-	if err := updateFromURLs(execPath, downloadURL, sumsURL, assetName); err != nil {
-		return Result{}, err
-	}
-	return Result{Version: newVersion, Updated: true}, nil
-}
-
-func selfUpdateFromGitHub(execPath, assetName string) (Result, error) {
-	downloadURL := fmt.Sprintf("https://github.com/%s/releases/latest/download/%s", version.DefaultRepo, assetName)
-	sumsURL := fmt.Sprintf("https://github.com/%s/releases/latest/download/SHA256SUMS", version.DefaultRepo)
-	versionURL := fmt.Sprintf("https://github.com/%s/releases/latest/download/version.txt", version.DefaultRepo)
-
-	newVersion := ""
-	if v, err := fetchText(versionURL, 1024); err == nil {
-		newVersion = normalizeVersion(v)
-	}
-	if newVersion == "" {
-		rel, err := fetchLatestRelease()
-		if err != nil {
-			return Result{}, err
-		}
-		newVersion = strings.TrimPrefix(strings.TrimSpace(rel.TagName), "v")
-	}
-	if newVersion == "" {
-		return Result{}, errors.New("could not determine latest release version")
-	}
-	if newVersion == version.AppVersion {
-		return Result{Version: newVersion, Updated: false}, nil
-	}
-
-	if err := updateFromURLs(execPath, downloadURL, sumsURL, assetName); err != nil {
-		return Result{}, err
-	}
-	return Result{Version: newVersion, Updated: true}, nil
-}
-
 func normalizeVersion(v string) string {
 	v = strings.TrimSpace(v)
 	v = strings.TrimPrefix(v, "v")
 	return v
 }
 
-func fetchLatestRelease() (ghRelease, error) {
-	url := fmt.Sprintf("https://api.github.com/repos/%s/releases/latest", version.DefaultRepo)
-	resp, err := (&http.Client{Timeout: 20 * time.Second}).Get(url)
+func fetchLatestRelease(ctx context.Context, url string) (ghRelease, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return ghRelease{}, err
+	}
+	resp, err := (&http.Client{Timeout: 20 * time.Second}).Do(req)
 	if err != nil {
 		return ghRelease{}, err
 	}
@@ -183,7 +275,10 @@ type integrityError struct {
 
 func (e *integrityError) Error() string { return e.msg }
 
-func updateFromURLs(execPath, downloadURL, sumsURL, assetName string) error {
+// installRelease downloads the archive or package, checks it against sums
+// (the source's SHA256SUMS) and replaces the running executable, or for deb
+// and rpm installs hands the package to the package manager.
+func installRelease(inst Install, execPath, downloadURL, sums, assetName string) error {
 	tmpDir, err := os.MkdirTemp("", "beammeup-update-*")
 	if err != nil {
 		return err
@@ -195,13 +290,12 @@ func updateFromURLs(execPath, downloadURL, sumsURL, assetName string) error {
 		return err
 	}
 
-	sums, err := fetchText(sumsURL, maxUpdateSHA256SUMSBytes)
-	if err != nil {
-		return fmt.Errorf("failed to download SHA256SUMS: %w", err)
-	}
 	if err := verifyChecksum(sums, assetName, archivePath); err != nil {
 		return err
 	}
+	if inst.Method != InstallArchive {
+		return inst.upgradePackage(archivePath)
+	}
 
 	binPath := filepath.Join(tmpDir, "beammeup-new")
 	if err := extractBinary(archivePath, binPath, maxUpdateBinaryBytes); err != nil {
@@ -226,6 +320,20 @@ func updateFromURLs(execPath, downloadURL, sumsURL, assetName string) error {
 	return nil
 }
 
+// verifySumsSignature checks sig, the base64 ed25519 signature from
+// SHA256SUMS.sig, over sums against releasePublicKey.
+func verifySumsSignature(sums, sig string) error {
+	key, err := base64.StdEncoding.DecodeString(releasePublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return errors.New("this build carries no release signing key; reinstall from a release to self-update")
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(sig))
+	if err != nil || !ed25519.Verify(ed25519.PublicKey(key), []byte(sums), raw) {
+		return errors.New("SHA256SUMS signature does not match the release key")
+	}
+	return nil
+}
+
 func verifyChecksum(sumsText, assetName, archivePath string) error {
 	want, err := expectedSHA256(sumsText, assetName)
 	if err != nil {
@@ -359,8 +467,12 @@ func extractBinary(archivePath, dst string, maxBytes int64) error {
 	return errors.New("binary not found in archive")
 }
 
-func fetchText(url string, maxBytes int64) (string, error) {
-	resp, err := (&http.Client{Timeout: 10 * time.Second}).Get(url)
+func fetchText(ctx context.Context, url string, maxBytes int64) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
 	if err != nil {
 		return "", err
 	}
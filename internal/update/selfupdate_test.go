@@ -0,0 +1,100 @@
+package update
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testAsset = "beammeup_linux_amd64.tar.gz"
+
+// testReleaseKey stands in for the release signing key.
+var testReleaseKey ed25519.PrivateKey
+
+func init() {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	testReleaseKey = priv
+	releasePublicKey = base64.StdEncoding.EncodeToString(pub)
+}
+
+func releaseServer(t *testing.T, ver, sums string, delay time.Duration) source {
+	t.Helper()
+	return signedReleaseServer(t, ver, sums, base64.StdEncoding.EncodeToString(ed25519.Sign(testReleaseKey, []byte(sums))), delay)
+}
+
+func signedReleaseServer(t *testing.T, ver, sums, sig string, delay time.Duration) source {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		switch r.URL.Path {
+		case "/version.txt":
+			w.Write([]byte(ver + "\n"))
+		case "/SHA256SUMS":
+			w.Write([]byte(sums))
+		case "/SHA256SUMS.sig":
+			w.Write([]byte(sig + "\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return source{name: ver, baseURL: srv.URL}
+}
+
+func TestProbeFirstTakesFastestValidSource(t *testing.T) {
+	sums := strings.Repeat("a", 64) + "  " + testAsset + "\n"
+	slow := releaseServer(t, "1.0.0", sums, 500*time.Millisecond)
+	fast := releaseServer(t, "1.0.1", sums, 0)
+	broken := releaseServer(t, "1.0.2", "no entries here\n", 0)
+
+	// The slow source is valid and listed first; a serial probe would pick it.
+	m, err := probeFirst(context.Background(), []source{slow, broken, fast}, testAsset)
+	if err != nil {
+		t.Fatalf("probeFirst: %v", err)
+	}
+	if m.version != "1.0.1" || m.sums != sums {
+		t.Fatalf("expected the fast source, got %+v", m)
+	}
+
+	m, err = probeFirst(context.Background(), []source{broken, slow}, testAsset)
+	if err != nil || m.version != "1.0.0" {
+		t.Fatalf("a source without a checksum for the asset must be skipped, got %+v, %v", m, err)
+	}
+
+	if _, err := probeFirst(context.Background(), []source{broken}, testAsset); err == nil || !strings.Contains(err.Error(), "SHA256SUMS missing entry") {
+		t.Fatalf("expected the missing checksum error, got %v", err)
+	}
+}
+
+func TestProbeRequiresSignedSums(t *testing.T) {
+	sums := strings.Repeat("a", 64) + "  " + testAsset + "\n"
+	_, other, _ := ed25519.GenerateKey(rand.Reader)
+	forged := signedReleaseServer(t, "9.9.9", sums, base64.StdEncoding.EncodeToString(ed25519.Sign(other, []byte(sums))), 0)
+	if _, err := probe(context.Background(), forged, testAsset); err == nil || !strings.Contains(err.Error(), "signature does not match") {
+		t.Fatalf("expected a forged signature to be refused, got %v", err)
+	}
+	unsigned := signedReleaseServer(t, "9.9.9", sums, "", 0)
+	if _, err := probe(context.Background(), unsigned, testAsset); err == nil {
+		t.Fatal("expected an empty signature to be refused")
+	}
+
+	key := releasePublicKey
+	t.Cleanup(func() { releasePublicKey = key })
+	releasePublicKey = ""
+	if _, err := probe(context.Background(), releaseServer(t, "9.9.9", sums, 0), testAsset); err == nil || !strings.Contains(err.Error(), "no release signing key") {
+		t.Fatalf("a build without a key must not accept releases, got %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package update
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/alfaoz/beammeup/internal/version"
+)
+
+// DefaultTelemetryURL receives the opt-in ping.
+const DefaultTelemetryURL = "https://beammeup.pw/telemetry/ping"
+
+// Ping is everything the opt-in telemetry sends: no ship, host, user or
+// machine identifier, just enough to tell which platforms are in use.
+type Ping struct {
+	Version string `json:"version"`
+	OS      string `json:"os"`
+	Arch    string `json:"arch"`
+	Install string `json:"install"` // Install.Method
+}
+
+// NewPing describes the running executable.
+func NewPing() Ping {
+	p := Ping{Version: version.AppVersion, OS: runtime.GOOS, Arch: runtime.GOARCH}
+	if inst, err := DetectInstall(); err == nil {
+		p.Install = inst.Method
+	}
+	return p
+}
+
+// SendPing posts p to url (DefaultTelemetryURL when empty).
+func SendPing(ctx context.Context, url string, p Ping) error {
+	if url == "" {
+		url = DefaultTelemetryURL
+	}
+	if err := validateBaseURL(url); err != nil {
+		return fmt.Errorf("telemetry URL: %w", err)
+	}
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "beammeup")
+	resp, err := (&http.Client{Timeout: 5 * time.Second}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("telemetry ping: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package update
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSendPingSendsOnlyThePing(t *testing.T) {
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("expected POST, got %s", r.Method)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	p := Ping{Version: "2.1.0", OS: "linux", Arch: "amd64", Install: InstallDeb}
+	if err := SendPing(context.Background(), srv.URL, p); err != nil {
+		t.Fatalf("SendPing: %v", err)
+	}
+	if len(got) != 4 || got["version"] != "2.1.0" || got["os"] != "linux" || got["arch"] != "amd64" || got["install"] != "deb" {
+		t.Fatalf("unexpected ping body: %v", got)
+	}
+
+	if err := SendPing(context.Background(), "http://telemetry.example.com/ping", p); err == nil {
+		t.Fatalf("plain http to a remote host must be refused")
+	}
+}
@@ -0,0 +1,317 @@
+package usage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alfaoz/beammeup/internal/notify"
+	"github.com/alfaoz/beammeup/internal/ships"
+)
+
+const DefaultDirSuffix = ".beammeup/usage"
+
+// MonthLayout formats the accounting period a sample belongs to.
+const MonthLayout = "2006-01"
+
+const bytesPerGB = 1000 * 1000 * 1000
+
+// Sample is one reading of a hangar's traffic counters.
+type Sample struct {
+	Time   time.Time
+	Source string // vnstat|iptables
+	Period string // month covered by vnstat totals; empty for raw counters
+	RX     uint64
+	TX     uint64
+}
+
+type Level int
+
+const (
+	LevelOK Level = iota
+	LevelWarn
+	LevelExceeded
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelWarn:
+		return "quota-warning"
+	case LevelExceeded:
+		return "quota-exceeded"
+	default:
+		return "ok"
+	}
+}
+
+// Quota is a ship's monthly provider allowance.
+type Quota struct {
+	GB          int
+	WarnPercent int
+}
+
+func (q Quota) Bytes() uint64 {
+	if q.GB <= 0 {
+		return 0
+	}
+	return uint64(q.GB) * bytesPerGB
+}
+
+// Evaluate classifies used bytes against q. A zero quota is always LevelOK.
+func (q Quota) Evaluate(used uint64) Level {
+	limit := q.Bytes()
+	if limit == 0 {
+		return LevelOK
+	}
+	if used >= limit {
+		return LevelExceeded
+	}
+	warn := q.WarnPercent
+	if warn <= 0 || warn > 100 {
+		warn = ships.DefaultTrafficWarnPercent
+	}
+	if used*100 >= limit*uint64(warn) {
+		return LevelWarn
+	}
+	return LevelOK
+}
+
+// Report summarizes a ship's traffic for one month.
+type Report struct {
+	Ship    string
+	Month   string
+	Source  string
+	Used    uint64
+	Quota   Quota
+	Level   Level
+	Crossed bool // Level rose with the sample that produced this report
+}
+
+// Percent returns the share of the quota used, or 0 without a quota.
+func (r Report) Percent() float64 {
+	limit := r.Quota.Bytes()
+	if limit == 0 {
+		return 0
+	}
+	return float64(r.Used) * 100 / float64(limit)
+}
+
+// Summary renders the report as a single human-readable line.
+func (r Report) Summary() string {
+	if r.Quota.Bytes() == 0 {
+		return fmt.Sprintf("%s transferred in %s (no quota set)", FormatBytes(r.Used), r.Month)
+	}
+	return fmt.Sprintf("%s of %s transferred in %s (%.0f%%)", FormatBytes(r.Used), FormatBytes(r.Quota.Bytes()), r.Month, r.Percent())
+}
+
+// Alert builds the notification sent when a report's level rises.
+func (r Report) Alert() notify.Event {
+	return notify.Event{
+		Type:     "traffic." + r.Level.String(),
+		Ship:     r.Ship,
+		Message:  fmt.Sprintf("ship %s: %s", r.Ship, r.Summary()),
+		Critical: r.Level == LevelExceeded,
+		Fields: map[string]string{
+			"month":       r.Month,
+			"used_bytes":  strconv.FormatUint(r.Used, 10),
+			"quota_bytes": strconv.FormatUint(r.Quota.Bytes(), 10),
+			"source":      r.Source,
+		},
+	}
+}
+
+type Store struct {
+	Dir string
+}
+
+func NewStore(dir string) (*Store, error) {
+	if strings.TrimSpace(dir) == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("resolve home dir: %w", err)
+		}
+		dir = filepath.Join(home, DefaultDirSuffix)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("ensure usage dir: %w", err)
+	}
+	return &Store{Dir: dir}, nil
+}
+
+func (s *Store) path(ship string) string {
+	return filepath.Join(s.Dir, ship+".usage")
+}
+
+// Load returns the recorded samples for ship, oldest first.
+func (s *Store) Load(ship string) ([]Sample, error) {
+	ship = ships.SanitizeName(ship)
+	if ship == "" {
+		return nil, errors.New("invalid ship name")
+	}
+	f, err := os.Open(s.path(ship))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("open usage history: %w", err)
+	}
+	defer f.Close()
+
+	var samples []Sample
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		smp, ok := parseSample(scanner.Text())
+		if ok {
+			samples = append(samples, smp)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scan usage history: %w", err)
+	}
+	return samples, nil
+}
+
+// Record appends smp to ship's history and reports the month it falls in.
+func (s *Store) Record(ship string, smp Sample, q Quota) (Report, error) {
+	ship = ships.SanitizeName(ship)
+	if ship == "" {
+		return Report{}, errors.New("invalid ship name")
+	}
+	if smp.Time.IsZero() {
+		smp.Time = time.Now()
+	}
+	smp.Time = smp.Time.UTC()
+
+	history, err := s.Load(ship)
+	if err != nil {
+		return Report{}, err
+	}
+	month := smp.Time.Format(MonthLayout)
+	before := MonthTotal(history, month)
+
+	f, err := os.OpenFile(s.path(ship), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return Report{}, fmt.Errorf("open usage history: %w", err)
+	}
+	if _, err := fmt.Fprintln(f, formatSample(smp)); err != nil {
+		f.Close()
+		return Report{}, fmt.Errorf("write usage history: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return Report{}, fmt.Errorf("write usage history: %w", err)
+	}
+
+	used := MonthTotal(append(history, smp), month)
+	level := q.Evaluate(used)
+	return Report{
+		Ship:    ship,
+		Month:   month,
+		Source:  smp.Source,
+		Used:    used,
+		Quota:   q,
+		Level:   level,
+		Crossed: level > q.Evaluate(before),
+	}, nil
+}
+
+// Delete removes ship's usage history.
+func (s *Store) Delete(ship string) error {
+	ship = ships.SanitizeName(ship)
+	if ship == "" {
+		return errors.New("invalid ship name")
+	}
+	if err := os.Remove(s.path(ship)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("delete usage history: %w", err)
+	}
+	return nil
+}
+
+// MonthTotal returns the bytes transferred during month. vnstat samples carry
+// their own monthly totals, so the latest one wins; otherwise the deltas
+// between consecutive iptables counter readings are summed, treating a
+// smaller reading as a counter reset (e.g. after a reboot).
+func MonthTotal(samples []Sample, month string) uint64 {
+	var latestVnstat *Sample
+	var counters uint64
+	var prev *Sample
+	for i := range samples {
+		smp := &samples[i]
+		switch smp.Source {
+		case "vnstat":
+			if smp.Period == month && (latestVnstat == nil || !smp.Time.Before(latestVnstat.Time)) {
+				latestVnstat = smp
+			}
+		default:
+			if smp.Time.UTC().Format(MonthLayout) == month {
+				cur := smp.RX + smp.TX
+				if prev != nil && cur >= prev.RX+prev.TX {
+					counters += cur - (prev.RX + prev.TX)
+				} else {
+					counters += cur
+				}
+			}
+			prev = smp
+		}
+	}
+	if latestVnstat != nil {
+		return latestVnstat.RX + latestVnstat.TX
+	}
+	return counters
+}
+
+// FormatBytes renders n using decimal units, as hosting providers do.
+func FormatBytes(n uint64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "kMGTPE"[exp])
+}
+
+func formatSample(smp Sample) string {
+	period := smp.Period
+	if period == "" {
+		period = "-"
+	}
+	return strings.Join([]string{
+		smp.Time.Format(time.RFC3339),
+		smp.Source,
+		period,
+		strconv.FormatUint(smp.RX, 10),
+		strconv.FormatUint(smp.TX, 10),
+	}, " ")
+}
+
+func parseSample(line string) (Sample, bool) {
+	fields := strings.Fields(line)
+	if len(fields) != 5 || strings.HasPrefix(fields[0], "#") {
+		return Sample{}, false
+	}
+	ts, err := time.Parse(time.RFC3339, fields[0])
+	if err != nil {
+		return Sample{}, false
+	}
+	rx, err := strconv.ParseUint(fields[3], 10, 64)
+	if err != nil {
+		return Sample{}, false
+	}
+	tx, err := strconv.ParseUint(fields[4], 10, 64)
+	if err != nil {
+		return Sample{}, false
+	}
+	period := fields[2]
+	if period == "-" {
+		period = ""
+	}
+	return Sample{Time: ts, Source: fields[1], Period: period, RX: rx, TX: tx}, true
+}
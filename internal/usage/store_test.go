@@ -0,0 +1,87 @@
+package usage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMonthTotalCounters(t *testing.T) {
+	at := func(day int) time.Time { return time.Date(2026, 10, day, 12, 0, 0, 0, time.UTC) }
+	samples := []Sample{
+		{Time: time.Date(2026, 9, 30, 12, 0, 0, 0, time.UTC), Source: "iptables", RX: 400, TX: 100},
+		{Time: at(1), Source: "iptables", RX: 600, TX: 200},  // +300
+		{Time: at(2), Source: "iptables", RX: 1000, TX: 300}, // +500
+		{Time: at(3), Source: "iptables", RX: 50, TX: 50},    // reboot: +100
+	}
+	if got := MonthTotal(samples, "2026-10"); got != 900 {
+		t.Fatalf("MonthTotal = %d, want 900", got)
+	}
+	if got := MonthTotal(samples, "2026-09"); got != 500 {
+		t.Fatalf("MonthTotal(sep) = %d, want 500", got)
+	}
+}
+
+func TestMonthTotalPrefersVnstat(t *testing.T) {
+	samples := []Sample{
+		{Time: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), Source: "iptables", RX: 10, TX: 10},
+		{Time: time.Date(2026, 10, 2, 0, 0, 0, 0, time.UTC), Source: "vnstat", Period: "2026-10", RX: 1000, TX: 500},
+		{Time: time.Date(2026, 10, 3, 0, 0, 0, 0, time.UTC), Source: "vnstat", Period: "2026-10", RX: 2000, TX: 700},
+	}
+	if got := MonthTotal(samples, "2026-10"); got != 2700 {
+		t.Fatalf("MonthTotal = %d, want 2700", got)
+	}
+}
+
+func TestRecordCrossesWarnThreshold(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	q := Quota{GB: 1, WarnPercent: 80}
+	now := time.Date(2026, 10, 5, 0, 0, 0, 0, time.UTC)
+
+	rep, err := store.Record("edge", Sample{Time: now, Source: "vnstat", Period: "2026-10", RX: 500_000_000}, q)
+	if err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	if rep.Level != LevelOK || rep.Crossed {
+		t.Fatalf("unexpected first report: %+v", rep)
+	}
+
+	rep, err = store.Record("edge", Sample{Time: now.Add(time.Hour), Source: "vnstat", Period: "2026-10", RX: 850_000_000}, q)
+	if err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	if rep.Level != LevelWarn || !rep.Crossed {
+		t.Fatalf("expected warn crossing, got %+v", rep)
+	}
+
+	rep, err = store.Record("edge", Sample{Time: now.Add(2 * time.Hour), Source: "vnstat", Period: "2026-10", RX: 900_000_000}, q)
+	if err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	if rep.Level != LevelWarn || rep.Crossed {
+		t.Fatalf("expected warn without crossing, got %+v", rep)
+	}
+
+	history, err := store.Load("edge")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(history) != 3 {
+		t.Fatalf("expected 3 samples, got %d", len(history))
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	cases := map[uint64]string{
+		999:           "999 B",
+		1_500:         "1.5 kB",
+		2_340_000_000: "2.3 GB",
+	}
+	for in, want := range cases {
+		if got := FormatBytes(in); got != want {
+			t.Fatalf("FormatBytes(%d) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -4,12 +4,21 @@ set -euo pipefail
 # Build beammeup release archives for supported platforms.
 # Output files:
 #   dist/beammeup_<os>_<arch>.tar.gz
+#   dist/beammeup_linux_<arch>.deb, .rpm   (when nfpm is installed)
+#   dist/beammeup.rb                       (Homebrew formula)
 #   dist/version.txt
 #   dist/SHA256SUMS
+#   dist/SHA256SUMS.sig                    (when SIGNING_KEY is set)
+#
+# SIGNING_KEY is an ed25519 private key in PEM (openssl genpkey -algorithm
+# ed25519). Its public half is built into the binaries, which only
+# self-update to releases whose SHA256SUMS.sig verifies against it; the key
+# must stay the same from one release to the next.
 
 ROOT_DIR="$(cd "$(dirname "${BASH_SOURCE[0]}")/.." && pwd)"
 OUT_DIR="${OUT_DIR:-${ROOT_DIR}/dist}"
 VERSION="${VERSION:-}"
+SIGNING_KEY="${SIGNING_KEY:-}"
 
 mkdir -p "$OUT_DIR"
 
@@ -17,6 +26,16 @@ if [[ -z "$VERSION" ]]; then
   VERSION="$(git -C "$ROOT_DIR" describe --tags --always 2>/dev/null | sed 's/^v//')"
 fi
 
+ldflags="-s -w -X github.com/alfaoz/beammeup/internal/version.AppVersion=${VERSION}"
+if [[ -n "$SIGNING_KEY" ]]; then
+  command -v openssl >/dev/null 2>&1 || { echo "[build] ERROR: openssl is required to sign with SIGNING_KEY" >&2; exit 1; }
+  # The raw 32-byte key is the tail of the DER public key.
+  public_key="$(openssl pkey -in "$SIGNING_KEY" -pubout -outform DER | tail -c 32 | openssl base64 -A)"
+  ldflags="${ldflags} -X github.com/alfaoz/beammeup/internal/update.releasePublicKey=${public_key}"
+else
+  echo "[build] SIGNING_KEY not set; SHA256SUMS stays unsigned and these builds cannot self-update"
+fi
+
 echo "[build] version: ${VERSION}"
 echo "[build] output: ${OUT_DIR}"
 
@@ -36,10 +55,24 @@ for entry in "${platforms[@]}"; do
   echo "[build] ${os}/${arch}"
   (cd "$ROOT_DIR" && \
     CGO_ENABLED=0 GOOS="$os" GOARCH="$arch" \
-    go build -trimpath -ldflags "-s -w -X github.com/alfaoz/beammeup/internal/version.AppVersion=${VERSION}" \
+    go build -trimpath -ldflags "$ldflags" \
     -o "${work}/beammeup" ./cmd/beammeup)
 
   tar -C "$work" -czf "${OUT_DIR}/beammeup_${os}_${arch}.tar.gz" beammeup
+
+  if [[ "$os" == "linux" ]]; then
+    if command -v nfpm >/dev/null 2>&1; then
+      for packager in deb rpm; do
+        echo "[build] ${os}/${arch} ${packager}"
+        (cd "$ROOT_DIR" && \
+          VERSION="$VERSION" NFPM_ARCH="$arch" BINARY="${work}/beammeup" \
+          nfpm package --config packaging/nfpm.yaml --packager "$packager" \
+          --target "${OUT_DIR}/beammeup_${os}_${arch}.${packager}")
+      done
+    else
+      echo "[build] nfpm not found; skipping .deb/.rpm for ${os}/${arch}"
+    fi
+  fi
   rm -rf "$work"
 done
 
@@ -48,7 +81,8 @@ printf '%s\n' "${VERSION}" > "${OUT_DIR}/version.txt"
 # Generate checksums for installers/self-update verification.
 (
   cd "$OUT_DIR"
-  files=(beammeup_*.tar.gz version.txt)
+  shopt -s nullglob
+  files=(beammeup_*.tar.gz beammeup_*.deb beammeup_*.rpm version.txt)
   if command -v sha256sum >/dev/null 2>&1; then
     sha256sum "${files[@]}" > SHA256SUMS
   elif command -v shasum >/dev/null 2>&1; then
@@ -59,4 +93,19 @@ printf '%s\n' "${VERSION}" > "${OUT_DIR}/version.txt"
   fi
 )
 
+if [[ -n "$SIGNING_KEY" ]]; then
+  openssl pkeyutl -sign -rawin -inkey "$SIGNING_KEY" -in "${OUT_DIR}/SHA256SUMS" | openssl base64 -A > "${OUT_DIR}/SHA256SUMS.sig"
+  printf '\n' >> "${OUT_DIR}/SHA256SUMS.sig"
+fi
+
+# Homebrew formula pointing at this release's archives.
+formula="$(sed "s/@VERSION@/${VERSION}/g" "${ROOT_DIR}/packaging/beammeup.rb.tmpl")"
+for entry in "${platforms[@]}"; do
+  os="${entry%% *}"
+  arch="${entry##* }"
+  sum="$(awk -v f="beammeup_${os}_${arch}.tar.gz" '$2 == f {print $1}' "${OUT_DIR}/SHA256SUMS")"
+  formula="${formula//@SHA256_${os}_${arch}@/${sum}}"
+done
+printf '%s\n' "$formula" > "${OUT_DIR}/beammeup.rb"
+
 echo "[build] done"